@@ -0,0 +1,16 @@
+CREATE TABLE `usage_events` (
+  `day` char(10) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `name` varchar(64) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `count` int NOT NULL,
+  PRIMARY KEY (`day`, `kind`, `name`, `conv_id`, `username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_daily` (
+  `day` char(10) NOT NULL,
+  `metric` varchar(96) NOT NULL,
+  `value` bigint NOT NULL,
+  PRIMARY KEY (`day`, `metric`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,15 @@
+CREATE TABLE `audit_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `ctime` datetime(6) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `command` varchar(64) NOT NULL,
+  `args` text NOT NULL,
+  `result` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `duration_ms` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`),
+  KEY `username` (`username`),
+  KEY `conv_id` (`conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
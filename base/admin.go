@@ -0,0 +1,201 @@
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+// AdminCommand is a privileged command only the bot admins may run, invoked
+// as `!<bot username> admin <name> [args]`. Bots register their own with
+// Server.RegisterAdminCommands.
+type AdminCommand struct {
+	Name        string
+	Usage       string
+	Description string
+	Handler     func(msg chat1.MsgSummary, args []string) error
+}
+
+func adminCmd(prefix string) string {
+	return fmt.Sprintf("%s admin", prefix)
+}
+
+func (s *Server) builtinAdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "stats",
+			Usage:       "[7d|30d]",
+			Description: "Show uptime, command counts and usage over the last 7 or 30 days",
+			Handler:     s.handleAdminStats,
+		},
+		{
+			Name:        "pause",
+			Usage:       "[conv ID]",
+			Description: "Ignore commands in a conversation, defaults to the current one",
+			Handler:     s.handleAdminPause,
+		},
+		{
+			Name:        "unpause",
+			Usage:       "[conv ID]",
+			Description: "Resume handling commands in a paused conversation",
+			Handler:     s.handleAdminUnpause,
+		},
+		{
+			Name:        "paused",
+			Description: "List paused conversations",
+			Handler:     s.handleAdminPaused,
+		},
+	}
+}
+
+// RegisterAdminCommands adds bot specific admin commands, a command with the
+// same name as an existing one replaces it.
+func (s *Server) RegisterAdminCommands(cmds ...AdminCommand) {
+	s.Lock()
+	defer s.Unlock()
+	for _, cmd := range cmds {
+		s.adminCommands[cmd.Name] = cmd
+	}
+}
+
+// IsConvPaused reports whether an admin paused the conversation, handlers
+// may use this to also skip notifications.
+func (s *Server) IsConvPaused(convID chat1.ConvIDStr) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.pausedConvs[convID]
+}
+
+func (s *Server) handleAdmin(msg chat1.MsgSummary) error {
+	if !s.allowHiddenCommand(msg) {
+		s.Debug("ignoring admin command from @%s, botAdmins: %v",
+			msg.Sender.Username, s.botAdmins)
+		s.getAuditLog().Record(msg, AuditResultDenied, nil, 0)
+		return nil
+	}
+
+	toks, userErr, err := SplitTokens(msg.Content.Text.Body)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		s.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	// drop `!<username> admin`
+	toks = toks[2:]
+	if len(toks) == 0 || toks[0] == "help" {
+		s.ChatEcho(msg.ConvID, "%s", s.adminHelpText())
+		return nil
+	}
+
+	s.Lock()
+	cmd, ok := s.adminCommands[toks[0]]
+	s.Unlock()
+	if !ok {
+		s.ChatEcho(msg.ConvID, "Unknown admin command %q, try `!%s help`", toks[0], adminCmd(s.kbc.GetUsername()))
+		return nil
+	}
+	s.Debug("admin command %q from @%s", cmd.Name, msg.Sender.Username)
+	start := time.Now()
+	err = cmd.Handler(msg, toks[1:])
+	result := AuditResultSuccess
+	if err != nil {
+		result = AuditResultError
+	}
+	s.getAuditLog().Record(msg, result, err, time.Since(start))
+	return err
+}
+
+func (s *Server) adminHelpText() string {
+	s.Lock()
+	names := make([]string, 0, len(s.adminCommands))
+	for name := range s.adminCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{"Admin commands:"}
+	prefix := adminCmd(s.kbc.GetUsername())
+	for _, name := range names {
+		cmd := s.adminCommands[name]
+		usage := strings.TrimSpace(fmt.Sprintf("!%s %s %s", prefix, cmd.Name, cmd.Usage))
+		lines = append(lines, fmt.Sprintf("`%s` %s", usage, cmd.Description))
+	}
+	s.Unlock()
+	return strings.Join(lines, "\n")
+}
+
+func (s *Server) handleAdminStats(msg chat1.MsgSummary, args []string) error {
+	days := 7
+	if len(args) > 0 {
+		switch args[0] {
+		case "7d":
+		case "30d":
+			days = 30
+		default:
+			s.ChatEcho(msg.ConvID, "Usage: `stats [7d|30d]`")
+			return nil
+		}
+	}
+	stats := fmt.Sprintf("uptime: %v\ncommands: %.0f\ncommand errors: %.0f\nrate limited: %.0f\nHTTP requests: %.0f",
+		time.Since(s.startTime).Round(time.Second),
+		DefaultMetrics.Total("keybase_bot_commands_total"),
+		DefaultMetrics.Total("keybase_bot_command_errors_total"),
+		DefaultMetrics.Total("keybase_bot_rate_limited_total"),
+		DefaultMetrics.Total("keybase_bot_http_requests_total"))
+	s.Lock()
+	analytics := s.analytics
+	s.Unlock()
+	if analytics != nil {
+		report, err := analytics.Report(days)
+		if err != nil {
+			return err
+		}
+		stats += "\n" + report.String()
+	}
+	s.ChatEcho(msg.ConvID, "%s", stats)
+	return nil
+}
+
+func adminTargetConv(msg chat1.MsgSummary, args []string) chat1.ConvIDStr {
+	if len(args) > 0 {
+		return chat1.ConvIDStr(args[0])
+	}
+	return msg.ConvID
+}
+
+func (s *Server) handleAdminPause(msg chat1.MsgSummary, args []string) error {
+	convID := adminTargetConv(msg, args)
+	s.Lock()
+	s.pausedConvs[convID] = true
+	s.Unlock()
+	s.ChatEcho(msg.ConvID, "Paused %s", convID)
+	return nil
+}
+
+func (s *Server) handleAdminUnpause(msg chat1.MsgSummary, args []string) error {
+	convID := adminTargetConv(msg, args)
+	s.Lock()
+	delete(s.pausedConvs, convID)
+	s.Unlock()
+	s.ChatEcho(msg.ConvID, "Unpaused %s", convID)
+	return nil
+}
+
+func (s *Server) handleAdminPaused(msg chat1.MsgSummary, args []string) error {
+	s.Lock()
+	convIDs := make([]string, 0, len(s.pausedConvs))
+	for convID := range s.pausedConvs {
+		convIDs = append(convIDs, string(convID))
+	}
+	s.Unlock()
+	if len(convIDs) == 0 {
+		s.ChatEcho(msg.ConvID, "No paused conversations")
+		return nil
+	}
+	sort.Strings(convIDs)
+	s.ChatEcho(msg.ConvID, "Paused conversations:\n%s", strings.Join(convIDs, "\n"))
+	return nil
+}
@@ -0,0 +1,416 @@
+package base
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	UsageCommand      = "command"
+	UsageNotification = "notification"
+
+	analyticsFlushInterval = time.Minute
+	// raw events are kept long enough to count distinct users over 30 days
+	analyticsRetentionDays = 90
+	analyticsDayFormat     = "2006-01-02"
+	analyticsTopCommands   = 5
+)
+
+type usageKey struct {
+	day      string
+	kind     string
+	name     string
+	convID   chat1.ConvIDStr
+	username string
+}
+
+// UsageCount is a single command or notification type and how often it was
+// used.
+type UsageCount struct {
+	Name  string
+	Count int64
+}
+
+// UsageReport summarizes a bot's usage over the last Days days, including
+// today.
+type UsageReport struct {
+	Days          int
+	Commands      int64
+	Notifications int64
+	ActiveConvs   int
+	ActiveUsers   int
+	// average of the daily distinct users
+	DailyActiveUsers float64
+	TopCommands      []UsageCount
+}
+
+// Analytics records command invocations and notifications per conversation
+// and user so maintainers can see how a bot is adopted. Events are buffered
+// and written to the usage_events table every minute, its Task rolls them up
+// into per day totals in usage_daily (see analytics.sql) and exports active
+// user and conversation gauges. A nil *Analytics records nothing.
+type Analytics struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats      *StatsRegistry
+	db         *DB
+	pending    map[usageKey]int
+	shutdownCh chan struct{}
+}
+
+func NewAnalytics(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, db *DB) *Analytics {
+	return &Analytics{
+		DebugOutput: NewDebugOutput("Analytics", debugConfig),
+		stats:       stats.SetPrefix("Analytics"),
+		db:          db,
+		pending:     make(map[usageKey]int),
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+func usageDay(t time.Time) string {
+	return t.UTC().Format(analyticsDayFormat)
+}
+
+func (a *Analytics) record(kind, name string, convID chat1.ConvIDStr, username string) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.pending[usageKey{
+		day:      usageDay(time.Now()),
+		kind:     kind,
+		name:     name,
+		convID:   convID,
+		username: username,
+	}]++
+}
+
+// RecordCommand counts a command run by username in convID, the Server does
+// this for every command sent to the bot once the Analytics is registered.
+func (a *Analytics) RecordCommand(convID chat1.ConvIDStr, username, command string) {
+	a.record(UsageCommand, command, convID, username)
+}
+
+// RecordNotification counts a notification of the given kind, e.g. a webhook
+// event type, sent to convID.
+func (a *Analytics) RecordNotification(convID chat1.ConvIDStr, kind string) {
+	a.record(UsageNotification, kind, convID, "")
+}
+
+// Flush writes buffered events to the database.
+func (a *Analytics) Flush() error {
+	a.Lock()
+	pending := a.pending
+	a.pending = make(map[usageKey]int)
+	a.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := a.db.RunTxn(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(a.db.Rebind(a.db.Dialect.UpsertIncrement("usage_events",
+			[]string{"day", "kind", "name", "conv_id", "username", "count"},
+			[]string{"day", "kind", "name", "conv_id", "username"}, "count")))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for key, count := range pending {
+			if _, err := stmt.Exec(key.day, key.kind, key.name, key.convID, key.username, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// keep the events for the next flush
+		a.Lock()
+		for key, count := range pending {
+			a.pending[key] += count
+		}
+		a.Unlock()
+		return err
+	}
+	a.stats.CountMult("Flush - events", len(pending))
+	return nil
+}
+
+func (a *Analytics) Run() error {
+	a.Lock()
+	shutdownCh := a.shutdownCh
+	a.Unlock()
+	if shutdownCh == nil {
+		return nil
+	}
+	ticker := time.NewTicker(analyticsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdownCh:
+			return nil
+		case <-ticker.C:
+			if err := a.Flush(); err != nil {
+				a.Errorf("Run: unable to flush: %v", err)
+			}
+		}
+	}
+}
+
+func (a *Analytics) Shutdown() (err error) {
+	defer a.Trace(&err, "Shutdown")()
+	a.Lock()
+	if a.shutdownCh != nil {
+		close(a.shutdownCh)
+		a.shutdownCh = nil
+	}
+	a.Unlock()
+	return a.Flush()
+}
+
+// Aggregate rolls the events of day up into usage_daily.
+func (a *Analytics) Aggregate(day time.Time) error {
+	if err := a.Flush(); err != nil {
+		return err
+	}
+	dayStr := usageDay(day)
+	totals := make(map[string]int64)
+	rows, err := a.db.Query(`
+		SELECT kind, name, SUM(count)
+		FROM usage_events
+		WHERE day = ?
+		GROUP BY kind, name
+	`, dayStr)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var kind, name string
+		var count int64
+		if err := rows.Scan(&kind, &name, &count); err != nil {
+			rows.Close()
+			return err
+		}
+		totals[kind+"s"] += count
+		totals[kind+":"+name] = count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	var convs, users int64
+	row := a.db.QueryRow(`
+		SELECT COUNT(DISTINCT conv_id), COUNT(DISTINCT CASE WHEN username = '' THEN NULL ELSE username END)
+		FROM usage_events
+		WHERE day = ?
+	`, dayStr)
+	if err := row.Scan(&convs, &users); err != nil {
+		return err
+	}
+	totals["active_convs"] = convs
+	totals["active_users"] = users
+
+	return a.db.RunTxn(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(a.db.Rebind(a.db.Dialect.Upsert("usage_daily",
+			[]string{"day", "metric", "value"}, []string{"day", "metric"}, []string{"value"})))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for metric, value := range totals {
+			if _, err := stmt.Exec(dayStr, metric, value); err != nil {
+				return err
+			}
+		}
+		cutoff := usageDay(day.AddDate(0, 0, -analyticsRetentionDays))
+		_, err = tx.Exec(a.db.Rebind(`DELETE FROM usage_events WHERE day < ?`), cutoff)
+		return err
+	})
+}
+
+// Report summarizes the last days days of usage.
+func (a *Analytics) Report(days int) (*UsageReport, error) {
+	if err := a.Flush(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	since := usageDay(now.AddDate(0, 0, -(days - 1)))
+	report := &UsageReport{Days: days}
+	row := a.db.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN kind = ? THEN count ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN kind = ? THEN count ELSE 0 END), 0),
+			COUNT(DISTINCT conv_id),
+			COUNT(DISTINCT CASE WHEN username = '' THEN NULL ELSE username END)
+		FROM usage_events
+		WHERE day >= ?
+	`, UsageCommand, UsageNotification, since)
+	if err := row.Scan(&report.Commands, &report.Notifications, &report.ActiveConvs, &report.ActiveUsers); err != nil {
+		return nil, err
+	}
+
+	// today isn't aggregated until the task next runs, count it live
+	var dailyUsers int64
+	row = a.db.QueryRow(`
+		SELECT COALESCE(SUM(value), 0)
+		FROM usage_daily
+		WHERE metric = 'active_users' AND day >= ? AND day < ?
+	`, since, usageDay(now))
+	if err := row.Scan(&dailyUsers); err != nil {
+		return nil, err
+	}
+	var todayUsers int64
+	row = a.db.QueryRow(`
+		SELECT COUNT(DISTINCT username)
+		FROM usage_events
+		WHERE day = ? AND username != ''
+	`, usageDay(now))
+	if err := row.Scan(&todayUsers); err != nil {
+		return nil, err
+	}
+	report.DailyActiveUsers = float64(dailyUsers+todayUsers) / float64(days)
+
+	rows, err := a.db.Query(fmt.Sprintf(`
+		SELECT name, SUM(count) AS total
+		FROM usage_events
+		WHERE kind = ? AND day >= ?
+		GROUP BY name
+		ORDER BY total DESC
+		LIMIT %d
+	`, analyticsTopCommands), UsageCommand, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var count UsageCount
+		if err := rows.Scan(&count.Name, &count.Count); err != nil {
+			return nil, err
+		}
+		report.TopCommands = append(report.TopCommands, count)
+	}
+	return report, rows.Err()
+}
+
+func (r *UsageReport) String() string {
+	lines := []string{
+		fmt.Sprintf("last %dd: commands: %d, notifications: %d", r.Days, r.Commands, r.Notifications),
+		fmt.Sprintf("active conversations: %d, active users: %d (%.1f daily)",
+			r.ActiveConvs, r.ActiveUsers, r.DailyActiveUsers),
+	}
+	if len(r.TopCommands) > 0 {
+		top := make([]string, len(r.TopCommands))
+		for index, count := range r.TopCommands {
+			top[index] = fmt.Sprintf("%s (%d)", count.Name, count.Count)
+		}
+		lines = append(lines, "top commands: "+strings.Join(top, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// export publishes active users and conversations for the usual windows to
+// Prometheus and, if configured, StatHat.
+func (a *Analytics) export() error {
+	for _, days := range []int{1, 7, 30} {
+		report, err := a.Report(days)
+		if err != nil {
+			return err
+		}
+		window := fmt.Sprintf("%dd", days)
+		DefaultMetrics.GaugeSet("keybase_bot_active_users", "Distinct users running commands.",
+			float64(report.ActiveUsers), "window", window)
+		DefaultMetrics.GaugeSet("keybase_bot_active_convs", "Distinct conversations using the bot.",
+			float64(report.ActiveConvs), "window", window)
+		a.stats.Value("active users - "+window, float64(report.ActiveUsers))
+		a.stats.Value("active convs - "+window, float64(report.ActiveConvs))
+	}
+	return nil
+}
+
+// Task aggregates yesterday, which may have had late events, and today every
+// hour and refreshes the exported gauges.
+func (a *Analytics) Task() Task {
+	return Task{
+		Name:       "aggregate-usage",
+		Schedule:   "@hourly",
+		Jitter:     5 * time.Minute,
+		RunOnStart: true,
+		Run: func(ctx context.Context) error {
+			now := time.Now()
+			for _, day := range []time.Time{now.AddDate(0, 0, -1), now} {
+				if err := a.Aggregate(day); err != nil {
+					return err
+				}
+			}
+			return a.export()
+		},
+	}
+}
+
+// AnalyticsMigrations creates the usage_events and usage_daily tables used by
+// Analytics.
+var AnalyticsMigrations = []Migration{
+	{
+		ID: "base-analytics-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS usage_events (
+					day char(10) NOT NULL,
+					kind varchar(16) NOT NULL,
+					name varchar(64) NOT NULL,
+					conv_id char(64) NOT NULL,
+					username varchar(128) NOT NULL,
+					count int NOT NULL,
+					PRIMARY KEY (day, kind, name, conv_id, username)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, `
+				CREATE TABLE IF NOT EXISTS usage_daily (
+					day char(10) NOT NULL,
+					metric varchar(96) NOT NULL,
+					value bigint NOT NULL,
+					PRIMARY KEY (day, metric)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS usage_events (
+					day char(10) NOT NULL,
+					kind varchar(16) NOT NULL,
+					name varchar(64) NOT NULL,
+					conv_id char(64) NOT NULL,
+					username varchar(128) NOT NULL,
+					count int NOT NULL,
+					PRIMARY KEY (day, kind, name, conv_id, username)
+				)`, `
+				CREATE TABLE IF NOT EXISTS usage_daily (
+					day char(10) NOT NULL,
+					metric varchar(96) NOT NULL,
+					value bigint NOT NULL,
+					PRIMARY KEY (day, metric)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS usage_events (
+					day text NOT NULL,
+					kind text NOT NULL,
+					name text NOT NULL,
+					conv_id text NOT NULL,
+					username text NOT NULL,
+					count integer NOT NULL,
+					PRIMARY KEY (day, kind, name, conv_id, username)
+				)`, `
+				CREATE TABLE IF NOT EXISTS usage_daily (
+					day text NOT NULL,
+					metric text NOT NULL,
+					value integer NOT NULL,
+					PRIMARY KEY (day, metric)
+				)`,
+			},
+		},
+	},
+}
@@ -0,0 +1,286 @@
+package base
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	DefaultMaxAttachmentSize = 50 * 1024 * 1024
+	// the chat API returns before it's finished reading an upload, so files are
+	// kept around for a bit after the send
+	attachmentCleanupDelay = time.Minute
+	// how much of a file http.DetectContentType looks at
+	mimeSniffLen = 512
+)
+
+// ErrAttachmentTooLarge is returned for uploads and downloads over the
+// AttachmentStore's size limit.
+var ErrAttachmentTooLarge = errors.New("attachment too large")
+
+// DownloadedAttachment is a chat attachment saved to a temporary file, call
+// Cleanup once done with it.
+type DownloadedAttachment struct {
+	Path     string
+	Filename string
+	MimeType string
+	Size     int64
+}
+
+func (d *DownloadedAttachment) Open() (*os.File, error) {
+	return os.Open(d.Path)
+}
+
+func (d *DownloadedAttachment) Cleanup() error {
+	return os.RemoveAll(filepath.Dir(d.Path))
+}
+
+// AttachmentStore uploads generated files, such as charts or ICS invites, to
+// chat and downloads files users attach. Everything is staged in a temporary
+// directory which Shutdown removes, and files over the size limit are
+// rejected in both directions.
+type AttachmentStore struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats   *StatsRegistry
+	dir     string
+	maxSize int64
+}
+
+// NewAttachmentStore creates a store limited to maxSize bytes per file,
+// DefaultMaxAttachmentSize if maxSize is zero.
+func NewAttachmentStore(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, maxSize int64) (*AttachmentStore, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxAttachmentSize
+	}
+	dir, err := ioutil.TempDir("", "keybase-bot-attachments-")
+	if err != nil {
+		return nil, err
+	}
+	return &AttachmentStore{
+		DebugOutput: NewDebugOutput("AttachmentStore", debugConfig),
+		stats:       stats.SetPrefix("AttachmentStore"),
+		dir:         dir,
+		maxSize:     maxSize,
+	}, nil
+}
+
+// DetectMIMEType guesses the MIME type of a file from its extension, falling
+// back to sniffing data.
+func DetectMIMEType(filename string, data []byte) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(filename)); mimeType != "" {
+		return mimeType
+	}
+	if len(data) > mimeSniffLen {
+		data = data[:mimeSniffLen]
+	}
+	return http.DetectContentType(data)
+}
+
+// sanitizeAttachmentName keeps user or API provided file names from escaping
+// the staging directory.
+func sanitizeAttachmentName(filename string) string {
+	filename = filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if filename == "." || filename == "/" || filename == "" {
+		return "attachment"
+	}
+	return filename
+}
+
+// tempDir makes a directory per file so files keep their names, which chat
+// shows to users.
+func (a *AttachmentStore) tempDir() (string, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.dir == "" {
+		return "", errors.New("attachment store is shut down")
+	}
+	return ioutil.TempDir(a.dir, "")
+}
+
+func (a *AttachmentStore) removeLater(path string) {
+	GoWithRecover(a.DebugOutput, func() {
+		time.Sleep(attachmentCleanupDelay)
+		if err := os.RemoveAll(path); err != nil {
+			a.Errorf("unable to clean up %s: %v", path, err)
+		}
+	})
+}
+
+// Upload sends data as an attachment named filename to convID.
+func (a *AttachmentStore) Upload(convID chat1.ConvIDStr, filename, title string, data []byte) (err error) {
+	defer a.Trace(&err, "Upload(%s, %s)", convID, filename)()
+	if int64(len(data)) > a.maxSize {
+		a.stats.Count("Upload - too large")
+		return ErrAttachmentTooLarge
+	}
+	dir, err := a.tempDir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, sanitizeAttachmentName(filename))
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	defer a.removeLater(dir)
+	return a.send(convID, path, title)
+}
+
+// UploadFile sends the file at path to convID. The file is left in place.
+func (a *AttachmentStore) UploadFile(convID chat1.ConvIDStr, path, title string) (err error) {
+	defer a.Trace(&err, "UploadFile(%s, %s)", convID, path)()
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > a.maxSize {
+		a.stats.Count("UploadFile - too large")
+		return ErrAttachmentTooLarge
+	}
+	return a.send(convID, path, title)
+}
+
+func (a *AttachmentStore) send(convID chat1.ConvIDStr, path, title string) error {
+	if _, err := a.Config().KBC.SendAttachmentByConvID(convID, path, title); err != nil {
+		a.CollectGoneConv(convID, err)
+		a.stats.Count("send - error")
+		return err
+	}
+	a.stats.Count("send - success")
+	DefaultMetrics.CounterInc("keybase_bot_attachments_total", "Chat attachments uploaded and downloaded.",
+		"direction", "upload")
+	return nil
+}
+
+type downloadOptions struct {
+	ConversationID chat1.ConvIDStr `json:"conversation_id"`
+	MessageID      chat1.MessageID `json:"message_id"`
+	Output         string          `json:"output"`
+}
+
+type downloadArg struct {
+	Method string `json:"method"`
+	Params struct {
+		Options downloadOptions `json:"options"`
+	} `json:"params"`
+}
+
+type downloadRes struct {
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Download saves the attachment of msg to a temporary file. It returns an
+// error if msg isn't an attachment or the file is over the size limit.
+func (a *AttachmentStore) Download(msg chat1.MsgSummary) (res *DownloadedAttachment, err error) {
+	defer a.Trace(&err, "Download(%s, %d)", msg.ConvID, msg.Id)()
+	attachment := msg.Content.Attachment
+	if attachment == nil {
+		return nil, errors.New("message has no attachment")
+	}
+	object := attachment.Object
+	if object.Size > a.maxSize {
+		a.stats.Count("Download - too large")
+		return nil, ErrAttachmentTooLarge
+	}
+	dir, err := a.tempDir()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	path := filepath.Join(dir, sanitizeAttachmentName(object.Filename))
+
+	var arg downloadArg
+	arg.Method = "download"
+	arg.Params.Options = downloadOptions{
+		ConversationID: msg.ConvID,
+		MessageID:      msg.Id,
+		Output:         path,
+	}
+	input, err := json.Marshal(arg)
+	if err != nil {
+		return nil, err
+	}
+	output, err := a.Config().KBC.Command("chat", "api", "-m", string(input)).Output()
+	if err != nil {
+		return nil, fmt.Errorf("unable to download: %v", err)
+	}
+	var apiRes downloadRes
+	if err := json.Unmarshal(output, &apiRes); err != nil {
+		return nil, fmt.Errorf("invalid download response: %v", err)
+	}
+	if apiRes.Error != nil {
+		return nil, fmt.Errorf("unable to download: %s", apiRes.Error.Message)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	// the asset size is what the sender claimed, check what we got
+	if info.Size() > a.maxSize {
+		a.stats.Count("Download - too large")
+		return nil, ErrAttachmentTooLarge
+	}
+	mimeType := object.MimeType
+	if mimeType == "" {
+		mimeType, err = sniffFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.stats.Count("Download - success")
+	DefaultMetrics.CounterInc("keybase_bot_attachments_total", "Chat attachments uploaded and downloaded.",
+		"direction", "download")
+	return &DownloadedAttachment{
+		Path:     path,
+		Filename: filepath.Base(path),
+		MimeType: mimeType,
+		Size:     info.Size(),
+	}, nil
+}
+
+func sniffFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data := make([]byte, mimeSniffLen)
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return DetectMIMEType(path, data[:n]), nil
+}
+
+func (a *AttachmentStore) Shutdown() (err error) {
+	defer a.Trace(&err, "Shutdown")()
+	a.Lock()
+	defer a.Unlock()
+	if a.dir == "" {
+		return nil
+	}
+	err = os.RemoveAll(a.dir)
+	a.dir = ""
+	return err
+}
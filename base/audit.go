@@ -0,0 +1,474 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	DefaultAuditRetention = 90 * 24 * time.Hour
+
+	AuditResultSuccess     = "success"
+	AuditResultError       = "error"
+	AuditResultOAuth       = "oauth"
+	AuditResultRateLimited = "rate_limited"
+	AuditResultDenied      = "denied"
+
+	auditQueueSize     = 1000
+	auditMaxErrorLen   = 1024
+	auditMaxCommandLen = 64
+	auditDefaultLimit  = 20
+	auditMaxLimit      = 100
+	auditRedacted      = "[redacted]"
+	// brackets would be escaped in URLs
+	auditRedactedURL  = "REDACTED"
+	auditMinSecretLen = 20
+	auditConvIDLen    = 64
+)
+
+var (
+	// key=value and --flag=value arguments with these in the key are redacted
+	auditSecretKeyRE = regexp.MustCompile(`(?i)(secret|token|passw|key|auth|cred)`)
+	auditSecretRE    = regexp.MustCompile(`^[A-Za-z0-9_\-./+=]+$`)
+	auditHexRE       = regexp.MustCompile(`^[0-9a-f]+$`)
+	auditLetterRE    = regexp.MustCompile(`[A-Za-z]`)
+	auditDigitRE     = regexp.MustCompile(`[0-9]`)
+)
+
+// AuditEntry is one command run, as stored in the audit_log table.
+type AuditEntry struct {
+	Time     time.Time
+	ConvID   chat1.ConvIDStr
+	Username string
+	Command  string
+	Args     string
+	Result   string
+	Error    string
+	Duration time.Duration
+}
+
+func (e AuditEntry) String() string {
+	line := fmt.Sprintf("%s @%s in %s `%s", e.Time.UTC().Format(time.RFC3339), e.Username,
+		ShortConvID(e.ConvID), e.Command)
+	if e.Args != "" {
+		line += " " + e.Args
+	}
+	line += fmt.Sprintf("` %s %v", e.Result, e.Duration.Round(time.Millisecond))
+	if e.Error != "" {
+		line += ": " + e.Error
+	}
+	return line
+}
+
+// AuditFilter narrows AuditLog.Query, empty fields match everything.
+type AuditFilter struct {
+	Username string
+	ConvID   chat1.ConvIDStr
+	Command  string
+}
+
+// AuditLog records every command a bot handles, who ran it where, its
+// arguments with anything that looks like a secret redacted, and how it went,
+// for abuse investigations and debugging in team deployments. Entries are
+// written to the audit_log table (see audit.sql) in the background and
+// pruned by its Task once they're older than the retention. A nil *AuditLog
+// records nothing.
+type AuditLog struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats            *StatsRegistry
+	db               *DB
+	retention        time.Duration
+	redactedCommands []string
+	entriesCh        chan AuditEntry
+	shutdownCh       chan struct{}
+}
+
+func NewAuditLog(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, db *DB) *AuditLog {
+	return &AuditLog{
+		DebugOutput: NewDebugOutput("AuditLog", debugConfig),
+		stats:       stats.SetPrefix("AuditLog"),
+		db:          db,
+		retention:   DefaultAuditRetention,
+		entriesCh:   make(chan AuditEntry, auditQueueSize),
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+// SetRetention changes how long entries are kept, DefaultAuditRetention by
+// default.
+func (a *AuditLog) SetRetention(retention time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.retention = retention
+}
+
+// RedactCommand drops all arguments of commands starting with prefix, e.g.
+// `!webhook create`, for commands whose arguments are secret no matter what
+// they look like.
+func (a *AuditLog) RedactCommand(prefix string) {
+	a.Lock()
+	defer a.Unlock()
+	a.redactedCommands = append(a.redactedCommands, prefix)
+}
+
+func isAuditSecret(tok string) bool {
+	if len(tok) < auditMinSecretLen || !auditSecretRE.MatchString(tok) {
+		return false
+	}
+	// conversation IDs show up in admin commands and aren't secret
+	if len(tok) == auditConvIDLen && auditHexRE.MatchString(tok) {
+		return false
+	}
+	return auditLetterRE.MatchString(tok) && auditDigitRE.MatchString(tok)
+}
+
+func redactAuditURL(tok string) (string, bool) {
+	u, err := url.Parse(tok)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	if u.User != nil {
+		u.User = url.User(auditRedactedURL)
+	}
+	query := u.Query()
+	for key := range query {
+		if auditSecretKeyRE.MatchString(key) {
+			query.Set(key, auditRedactedURL)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), true
+}
+
+// RedactArgs replaces command arguments which look like secrets: values of
+// key=value pairs with a secret sounding key, credentials and tokens in URLs,
+// and long random looking strings.
+func RedactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	for index, tok := range args {
+		if u, ok := redactAuditURL(tok); ok {
+			redacted[index] = u
+			continue
+		}
+		if eq := strings.Index(tok, "="); eq > 0 && auditSecretKeyRE.MatchString(tok[:eq]) {
+			redacted[index] = tok[:eq+1] + auditRedacted
+			continue
+		}
+		if isAuditSecret(tok) {
+			redacted[index] = auditRedacted
+			continue
+		}
+		redacted[index] = tok
+	}
+	return redacted
+}
+
+// Record queues an entry for the command in msg. It never blocks, entries are
+// dropped if the database falls behind.
+func (a *AuditLog) Record(msg chat1.MsgSummary, result string, cmdErr error, duration time.Duration) {
+	if a == nil || msg.Content.Text == nil {
+		return
+	}
+	toks := strings.Fields(msg.Content.Text.Body)
+	if len(toks) == 0 {
+		return
+	}
+	// commands are named by their first two words, e.g. `!gcal accounts`
+	command := toks[0]
+	var args []string
+	if len(toks) > 1 {
+		command += " " + toks[1]
+		args = toks[2:]
+	}
+	// admin commands are named by their subcommand too
+	if len(args) > 0 && len(toks) > 1 && toks[1] == "admin" {
+		command += " " + args[0]
+		args = args[1:]
+	}
+	if len(command) > auditMaxCommandLen {
+		command = command[:auditMaxCommandLen]
+	}
+	a.Lock()
+	for _, prefix := range a.redactedCommands {
+		// keep the words of the prefix, e.g. `trigger` of `!gitlab pipeline trigger`
+		n := len(strings.Fields(prefix))
+		if n < 2 {
+			n = 2
+		}
+		if len(toks) > n && strings.HasPrefix(strings.Join(toks, " "), prefix) {
+			args = append(append([]string(nil), toks[2:n]...), auditRedacted)
+		}
+	}
+	a.Unlock()
+	entry := AuditEntry{
+		Time:     time.Now().UTC(),
+		ConvID:   msg.ConvID,
+		Username: msg.Sender.Username,
+		Command:  command,
+		Args:     strings.Join(RedactArgs(args), " "),
+		Result:   result,
+		Duration: duration,
+	}
+	if cmdErr != nil {
+		entry.Error = cmdErr.Error()
+		if len(entry.Error) > auditMaxErrorLen {
+			entry.Error = entry.Error[:auditMaxErrorLen]
+		}
+	}
+	select {
+	case a.entriesCh <- entry:
+	default:
+		a.stats.Count("Record - dropped")
+		a.Debug("Record: queue full, dropping entry for %s", command)
+	}
+}
+
+func (a *AuditLog) insert(entry AuditEntry) error {
+	_, err := a.db.Exec(`
+		INSERT INTO audit_log
+		(ctime, conv_id, username, command, args, result, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.Time, entry.ConvID, entry.Username, entry.Command, entry.Args, entry.Result, entry.Error,
+		entry.Duration.Milliseconds())
+	if err != nil {
+		a.stats.Count("insert - error")
+		return err
+	}
+	a.stats.Count("insert - success")
+	return nil
+}
+
+func (a *AuditLog) Run() error {
+	a.Lock()
+	shutdownCh := a.shutdownCh
+	a.Unlock()
+	if shutdownCh == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-shutdownCh:
+			return nil
+		case entry := <-a.entriesCh:
+			if err := a.insert(entry); err != nil {
+				a.Errorf("Run: unable to write entry: %v", err)
+			}
+		}
+	}
+}
+
+// Shutdown stops Run and writes the entries still queued.
+func (a *AuditLog) Shutdown() (err error) {
+	defer a.Trace(&err, "Shutdown")()
+	a.Lock()
+	if a.shutdownCh != nil {
+		close(a.shutdownCh)
+		a.shutdownCh = nil
+	}
+	a.Unlock()
+	for {
+		select {
+		case entry := <-a.entriesCh:
+			if err := a.insert(entry); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// Query returns the newest entries matching filter.
+func (a *AuditLog) Query(filter AuditFilter, limit int) (res []AuditEntry, err error) {
+	var where []string
+	var args []interface{}
+	if filter.Username != "" {
+		where = append(where, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.ConvID != "" {
+		where = append(where, "conv_id = ?")
+		args = append(args, filter.ConvID)
+	}
+	if filter.Command != "" {
+		where = append(where, "command = ?")
+		args = append(args, filter.Command)
+	}
+	query := fmt.Sprintf(`SELECT %s, conv_id, username, command, args, result, error, duration_ms
+		FROM audit_log`, a.db.Dialect.UnixTimestamp("ctime"))
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf("\n\t\tORDER BY id DESC\n\t\tLIMIT %d", limit)
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry AuditEntry
+		var ctime, durationMs int64
+		if err := rows.Scan(&ctime, &entry.ConvID, &entry.Username, &entry.Command, &entry.Args,
+			&entry.Result, &entry.Error, &durationMs); err != nil {
+			return nil, err
+		}
+		entry.Time = time.Unix(ctime, 0)
+		entry.Duration = time.Duration(durationMs) * time.Millisecond
+		res = append(res, entry)
+	}
+	return res, rows.Err()
+}
+
+// Prune deletes entries older than the retention.
+func (a *AuditLog) Prune() (deleted int64, err error) {
+	a.Lock()
+	retention := a.retention
+	a.Unlock()
+	res, err := a.db.Exec(`DELETE FROM audit_log
+		WHERE ctime < ?`, time.Now().UTC().Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Task prunes the audit log daily.
+func (a *AuditLog) Task() Task {
+	return Task{
+		Name:     "prune-audit-log",
+		Schedule: "@daily",
+		Jitter:   time.Hour,
+		Run: func(ctx context.Context) error {
+			deleted, err := a.Prune()
+			if err != nil {
+				return err
+			}
+			a.stats.CountMult("Prune - deleted", int(deleted))
+			return nil
+		},
+	}
+}
+
+// AdminCommands lets bot admins search the audit log.
+func (a *AuditLog) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "audit",
+			Usage:       "[user <username>|conv <conv id>|command <command>] [limit]",
+			Description: "Show the latest commands run, optionally by a user, in a conversation or of a command",
+			Handler:     a.handleAdminAudit,
+		},
+	}
+}
+
+func (a *AuditLog) handleAdminAudit(msg chat1.MsgSummary, args []string) error {
+	usage := "Usage: `audit [user <username>|conv <conv id>|command <command>] [limit]`"
+	var filter AuditFilter
+	limit := auditDefaultLimit
+	if len(args) > 0 {
+		if n, err := strconv.Atoi(args[len(args)-1]); err == nil {
+			if n <= 0 || n > auditMaxLimit {
+				a.ChatEcho(msg.ConvID, "The limit must be between 1 and %d", auditMaxLimit)
+				return nil
+			}
+			limit = n
+			args = args[:len(args)-1]
+		}
+	}
+	switch {
+	case len(args) == 0:
+	case len(args) >= 2 && args[0] == "user":
+		filter.Username = strings.TrimPrefix(args[1], "@")
+	case len(args) >= 2 && args[0] == "conv":
+		filter.ConvID = chat1.ConvIDStr(args[1])
+	case len(args) >= 2 && args[0] == "command":
+		// commands are several words, e.g. `!github subscribe`
+		filter.Command = strings.Join(args[1:], " ")
+	default:
+		a.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	entries, err := a.Query(filter, limit)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		a.ChatEcho(msg.ConvID, "No matching commands")
+		return nil
+	}
+	lines := make([]string, len(entries))
+	for index, entry := range entries {
+		lines[index] = entry.String()
+	}
+	a.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return nil
+}
+
+// AuditMigrations creates the audit_log table used by AuditLog.
+var AuditMigrations = []Migration{
+	{
+		ID: "base-audit-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS audit_log (
+					id bigint NOT NULL AUTO_INCREMENT,
+					ctime datetime(6) NOT NULL,
+					conv_id char(64) NOT NULL,
+					username varchar(128) NOT NULL,
+					command varchar(64) NOT NULL,
+					args text NOT NULL,
+					result varchar(16) NOT NULL,
+					error text NOT NULL,
+					duration_ms bigint NOT NULL,
+					PRIMARY KEY (id),
+					KEY ctime (ctime),
+					KEY username (username),
+					KEY conv_id (conv_id)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS audit_log (
+					id bigserial NOT NULL,
+					ctime timestamp NOT NULL,
+					conv_id char(64) NOT NULL,
+					username varchar(128) NOT NULL,
+					command varchar(64) NOT NULL,
+					args text NOT NULL,
+					result varchar(16) NOT NULL,
+					error text NOT NULL,
+					duration_ms bigint NOT NULL,
+					PRIMARY KEY (id)
+				)`,
+				`CREATE INDEX IF NOT EXISTS audit_log_ctime ON audit_log (ctime)`,
+				`CREATE INDEX IF NOT EXISTS audit_log_username ON audit_log (username)`,
+				`CREATE INDEX IF NOT EXISTS audit_log_conv_id ON audit_log (conv_id)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS audit_log (
+					id integer PRIMARY KEY AUTOINCREMENT,
+					ctime datetime NOT NULL,
+					conv_id text NOT NULL,
+					username text NOT NULL,
+					command text NOT NULL,
+					args text NOT NULL,
+					result text NOT NULL,
+					error text NOT NULL,
+					duration_ms integer NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS audit_log_ctime ON audit_log (ctime)`,
+				`CREATE INDEX IF NOT EXISTS audit_log_username ON audit_log (username)`,
+				`CREATE INDEX IF NOT EXISTS audit_log_conv_id ON audit_log (conv_id)`,
+			},
+		},
+	},
+}
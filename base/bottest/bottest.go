@@ -0,0 +1,104 @@
+// Package bottest provides fakes for integration testing bots: a fake Keybase
+// chat API, an in-memory database and recording upstream API servers. Tests
+// using FakeChat must run through Main from their TestMain.
+package bottest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// NewDB returns an empty in-memory SQLite database with migrations applied.
+// The test is skipped unless built with `-tags sqlite`.
+func NewDB(t testing.TB, migrations ...[]base.Migration) *base.DB {
+	t.Helper()
+	db, err := base.OpenDB("sqlite://:memory:")
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		t.Skipf("in-memory database unavailable, build with -tags sqlite: %v", err)
+	}
+	for _, migration := range migrations {
+		if err := db.Migrate(migration); err != nil {
+			t.Fatalf("unable to migrate: %v", err)
+		}
+	}
+	return db
+}
+
+// UpstreamRequest is a request received by an Upstream.
+type UpstreamRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Upstream is an httptest server standing in for a third party API, such as
+// Google Calendar or GitHub, which records every request it serves.
+type Upstream struct {
+	*httptest.Server
+	sync.Mutex
+
+	mux      *http.ServeMux
+	requests []UpstreamRequest
+}
+
+func NewUpstream() *Upstream {
+	u := &Upstream{mux: http.NewServeMux()}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		u.Lock()
+		u.requests = append(u.requests, UpstreamRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Header: r.Header.Clone(),
+			Body:   body,
+		})
+		u.Unlock()
+		u.mux.ServeHTTP(w, r)
+	}))
+	return u
+}
+
+// HandleFunc registers a canned response for pattern, unregistered paths
+// return 404.
+func (u *Upstream) HandleFunc(pattern string, handler http.HandlerFunc) {
+	u.mux.HandleFunc(pattern, handler)
+}
+
+// Transport sends every request to the upstream whatever its host, so bots
+// calling APIs by their real URL hit the fake. Install it with
+// base.SetHTTPClientOptions.
+func (u *Upstream) Transport() http.RoundTripper {
+	target, _ := url.Parse(u.URL)
+	next := u.Client().Transport
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.Host = ""
+		return next.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (u *Upstream) Requests() []UpstreamRequest {
+	u.Lock()
+	defer u.Unlock()
+	return append([]UpstreamRequest(nil), u.requests...)
+}
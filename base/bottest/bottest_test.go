@@ -0,0 +1,55 @@
+package bottest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) { Main(m) }
+
+func TestFakeChat(t *testing.T) {
+	fake := NewFakeChat("testbot")
+	defer fake.Close()
+	kbc := fake.Start(t)
+	require.Equal(t, "testbot", kbc.GetUsername())
+
+	sub, err := kbc.Listen(kbchat.ListenOptions{Convs: true})
+	require.NoError(t, err)
+	defer sub.Shutdown()
+
+	convID := chat1.ConvIDStr("deadbeef")
+	// the listener connects asynchronously, keep delivering until it's seen
+	msgCh := make(chan kbchat.SubscriptionMessage, 1)
+	go func() {
+		msg, err := sub.Read()
+		if err == nil {
+			msgCh <- msg
+		}
+	}()
+	deadline := time.After(10 * time.Second)
+	var received kbchat.SubscriptionMessage
+loop:
+	for {
+		fake.SendText(convID, "alice", "!ping")
+		select {
+		case received = <-msgCh:
+			break loop
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("message never delivered")
+		}
+	}
+	require.Equal(t, convID, received.Message.ConvID)
+	require.Equal(t, "alice", received.Message.Sender.Username)
+	require.Equal(t, "!ping", received.Message.Content.Text.Body)
+
+	_, err = kbc.SendMessageByConvID(convID, "pong %d", 1)
+	require.NoError(t, err)
+	sent := fake.WaitForMessage(t, 5*time.Second)
+	require.Equal(t, convID, sent.ConvID)
+	require.Equal(t, "pong 1", sent.Body)
+}
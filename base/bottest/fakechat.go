@@ -0,0 +1,325 @@
+package bottest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	fakeKeybaseURLEnv      = "BOTTEST_FAKE_KEYBASE_URL"
+	fakeKeybaseUsernameEnv = "BOTTEST_FAKE_KEYBASE_USERNAME"
+)
+
+// Main runs the tests of a package using FakeChat, call it from TestMain:
+//
+//	func TestMain(m *testing.M) { bottest.Main(m) }
+//
+// FakeChat points kbchat at the test binary itself, so when it's run as the
+// `keybase` CLI Main acts as the fake instead of running the tests.
+func Main(m *testing.M) {
+	if url := os.Getenv(fakeKeybaseURLEnv); url != "" {
+		os.Exit(runFakeKeybase(url, os.Getenv(fakeKeybaseUsernameEnv), os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// SentMessage is a message a bot sent through the fake chat API.
+type SentMessage struct {
+	ConvID  chat1.ConvIDStr
+	Channel chat1.ChatChannel
+	Body    string
+}
+
+// FakeChat stands in for the Keybase service behind kbchat. It records
+// messages the bot sends and delivers messages to its listeners as if they
+// were sent by other users.
+type FakeChat struct {
+	sync.Mutex
+
+	Username string
+
+	srv       *httptest.Server
+	doneCh    chan struct{}
+	apis      []*kbchat.API
+	sent      []SentMessage
+	sentCh    chan SentMessage
+	listeners map[chan []byte]bool
+	nextMsgID chat1.MessageID
+}
+
+func NewFakeChat(username string) *FakeChat {
+	f := &FakeChat{
+		Username:  username,
+		doneCh:    make(chan struct{}),
+		sentCh:    make(chan SentMessage, 100),
+		listeners: make(map[chan []byte]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/done", f.handleDone)
+	mux.HandleFunc("/api", f.handleAPI)
+	mux.HandleFunc("/listen", f.handleListen)
+	f.srv = httptest.NewServer(mux)
+	return f
+}
+
+// RunOptions points kbchat, or a base.Server, at the fake. The calling
+// package's tests must run through Main.
+func (f *FakeChat) RunOptions() kbchat.RunOptions {
+	os.Setenv(fakeKeybaseURLEnv, f.srv.URL)
+	os.Setenv(fakeKeybaseUsernameEnv, f.Username)
+	return kbchat.RunOptions{KeybaseLocation: os.Args[0]}
+}
+
+// Start connects a kbchat API to the fake, it's shut down by Close.
+func (f *FakeChat) Start(t testing.TB) *kbchat.API {
+	t.Helper()
+	kbc, err := kbchat.Start(f.RunOptions())
+	if err != nil {
+		t.Fatalf("unable to start fake keybase: %v", err)
+	}
+	f.Lock()
+	f.apis = append(f.apis, kbc)
+	f.Unlock()
+	return kbc
+}
+
+// Close stops the fake `keybase` processes, shuts down the APIs returned by
+// Start and then the fake itself.
+func (f *FakeChat) Close() {
+	close(f.doneCh)
+	f.Lock()
+	apis := f.apis
+	f.apis = nil
+	f.Unlock()
+	for _, kbc := range apis {
+		_ = kbc.Shutdown()
+	}
+	f.Lock()
+	for listener := range f.listeners {
+		close(listener)
+		delete(f.listeners, listener)
+	}
+	f.Unlock()
+	f.srv.Close()
+	os.Unsetenv(fakeKeybaseURLEnv)
+	os.Unsetenv(fakeKeybaseUsernameEnv)
+}
+
+// Sent returns every message sent so far.
+func (f *FakeChat) Sent() []SentMessage {
+	f.Lock()
+	defer f.Unlock()
+	return append([]SentMessage(nil), f.sent...)
+}
+
+// WaitForMessage returns the next message sent by the bot, failing the test
+// after timeout.
+func (f *FakeChat) WaitForMessage(t testing.TB, timeout time.Duration) SentMessage {
+	t.Helper()
+	select {
+	case msg := <-f.sentCh:
+		return msg
+	case <-time.After(timeout):
+		t.Fatalf("no message sent within %v", timeout)
+		return SentMessage{}
+	}
+}
+
+// Deliver sends msg to the bot's listeners.
+func (f *FakeChat) Deliver(msg chat1.MsgSummary) {
+	line, err := json.Marshal(chat1.MsgNotification{
+		Type:   "chat",
+		Source: "remote",
+		Msg:    &msg,
+	})
+	if err != nil {
+		panic(err)
+	}
+	f.Lock()
+	defer f.Unlock()
+	for listener := range f.listeners {
+		listener <- line
+	}
+}
+
+// SendText delivers a text message from sender in convID, e.g. a command.
+func (f *FakeChat) SendText(convID chat1.ConvIDStr, sender, body string) {
+	f.Lock()
+	f.nextMsgID++
+	id := f.nextMsgID
+	f.Unlock()
+	f.Deliver(chat1.MsgSummary{
+		Id:     id,
+		ConvID: convID,
+		Channel: chat1.ChatChannel{
+			Name: fmt.Sprintf("%s,%s", sender, f.Username),
+		},
+		Sender: chat1.MsgSender{
+			Username: sender,
+		},
+		Content: chat1.MsgContent{
+			TypeName: "text",
+			Text:     &chat1.MsgTextContent{Body: body},
+		},
+		SentAt:   time.Now().Unix(),
+		SentAtMs: time.Now().UnixNano() / int64(time.Millisecond),
+	})
+}
+
+type apiRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		Options struct {
+			ConvID  chat1.ConvIDStr   `json:"conversation_id"`
+			Channel chat1.ChatChannel `json:"channel"`
+			Message struct {
+				Body string `json:"body"`
+			} `json:"message"`
+		} `json:"options"`
+	} `json:"params"`
+}
+
+// handleDone blocks until Close so the fake `keybase chat api` process knows
+// when to exit, the real one runs until the service stops.
+func (f *FakeChat) handleDone(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-f.doneCh:
+	case <-r.Context().Done():
+	}
+}
+
+func (f *FakeChat) handleAPI(w http.ResponseWriter, r *http.Request) {
+	var req apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result interface{}
+	switch req.Method {
+	case "send":
+		msg := SentMessage{
+			ConvID:  req.Params.Options.ConvID,
+			Channel: req.Params.Options.Channel,
+			Body:    req.Params.Options.Message.Body,
+		}
+		f.Lock()
+		f.sent = append(f.sent, msg)
+		f.nextMsgID++
+		id := f.nextMsgID
+		f.Unlock()
+		select {
+		case f.sentCh <- msg:
+		default:
+		}
+		result = chat1.SendRes{Message: "message sent", MessageID: &id}
+	case "list":
+		result = kbchat.Result{Convs: []chat1.ConvSummary{}}
+	default:
+		result = struct{}{}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+}
+
+func (f *FakeChat) handleListen(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	listener := make(chan []byte, 100)
+	f.Lock()
+	f.listeners[listener] = true
+	f.Unlock()
+	defer func() {
+		f.Lock()
+		defer f.Unlock()
+		if f.listeners[listener] {
+			delete(f.listeners, listener)
+		}
+	}()
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case line, ok := <-listener:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// runFakeKeybase implements the subset of the `keybase` CLI kbchat uses by
+// proxying to the FakeChat at url.
+func runFakeKeybase(url, username string, args []string) int {
+	if len(args) >= 2 && args[0] == "--home" {
+		args = args[2:]
+	}
+	switch {
+	case len(args) >= 1 && args[0] == "whoami":
+		fmt.Printf(`{"configured":true,"registered":true,"loggedIn":true,"sessionIsValid":true,"user":{"username":%q}}`,
+			username)
+	case len(args) >= 2 && args[0] == "chat" && args[1] == "api":
+		go func() {
+			if resp, err := http.Get(url + "/done"); err == nil {
+				resp.Body.Close()
+			}
+			os.Exit(0)
+		}()
+		dec := json.NewDecoder(os.Stdin)
+		for {
+			var req json.RawMessage
+			if err := dec.Decode(&req); err != nil {
+				if err == io.EOF {
+					return 0
+				}
+				fmt.Fprintf(os.Stderr, "invalid request: %v\n", err)
+				return 1
+			}
+			resp, err := http.Post(url+"/api", "application/json", bytes.NewReader(req))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fake chat unavailable: %v\n", err)
+				return 1
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				return 1
+			}
+			os.Stdout.Write(body)
+		}
+	case len(args) >= 2 && args[0] == "chat" && args[1] == "api-listen":
+		resp, err := http.Get(url + "/listen")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fake chat unavailable: %v\n", err)
+			return 1
+		}
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 1<<20), 10<<20)
+		for scanner.Scan() {
+			fmt.Println(scanner.Text())
+		}
+	}
+	// everything else, e.g. `chat notification-settings`, is a no-op
+	return 0
+}
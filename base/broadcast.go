@@ -0,0 +1,437 @@
+package base
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	// space out announcement sends to stay well under chat rate limits
+	broadcastSendInterval = 500 * time.Millisecond
+
+	BroadcastPending = "pending"
+	BroadcastSent    = "sent"
+	// the conversation can't be reached anymore, e.g. the bot was removed
+	BroadcastSkipped = "skipped"
+	BroadcastFailed  = "failed"
+)
+
+// ConversationLister returns every conversation a bot has state for, such as
+// the conversations of its subscriptions. Duplicates are ignored.
+type ConversationLister func() ([]chat1.ConvIDStr, error)
+
+// BroadcastTemplateData is available to announcement templates, e.g.
+// `{{.Bot}} will be down for maintenance`.
+type BroadcastTemplateData struct {
+	Bot    string
+	ConvID chat1.ConvIDStr
+}
+
+type BroadcastStatus struct {
+	ID        string
+	Message   string
+	CreatedBy string
+	Ctime     time.Time
+	Counts    map[string]int
+}
+
+// Broadcaster sends an announcement to every conversation a bot is in, for
+// maintenance windows or breaking changes. Deliveries are recorded in the
+// broadcast_deliveries table (see broadcasts.sql) so an interrupted
+// broadcast can be resumed without messaging anyone twice.
+type Broadcaster struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats      *StatsRegistry
+	db         *DB
+	lister     ConversationLister
+	active     map[string]bool
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewBroadcaster(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, db *DB,
+	lister ConversationLister) *Broadcaster {
+	return &Broadcaster{
+		DebugOutput: NewDebugOutput("Broadcaster", debugConfig),
+		stats:       stats.SetPrefix("Broadcaster"),
+		db:          db,
+		lister:      lister,
+		active:      make(map[string]bool),
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+func (b *Broadcaster) render(message string, convID chat1.ConvIDStr) (string, error) {
+	tmpl, err := template.New("broadcast").Parse(message)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, BroadcastTemplateData{
+		Bot:    b.Config().KBC.GetUsername(),
+		ConvID: convID,
+	}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (b *Broadcaster) conversations() ([]chat1.ConvIDStr, error) {
+	convIDs, err := b.lister()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[chat1.ConvIDStr]bool, len(convIDs))
+	unique := convIDs[:0]
+	for _, convID := range convIDs {
+		if convID != "" && !seen[convID] {
+			seen[convID] = true
+			unique = append(unique, convID)
+		}
+	}
+	return unique, nil
+}
+
+// Create records a broadcast of message to every listed conversation and
+// starts delivering it in the background.
+func (b *Broadcaster) Create(message, createdBy string) (id string, recipients int, err error) {
+	if _, err := b.render(message, ""); err != nil {
+		return "", 0, fmt.Errorf("invalid template: %v", err)
+	}
+	convIDs, err := b.conversations()
+	if err != nil {
+		return "", 0, err
+	}
+	id = RandHexString(8)
+	now := time.Now().UTC()
+	if err := b.db.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(b.db.Rebind(`
+			INSERT INTO broadcasts (id, message, created_by, ctime)
+			VALUES (?, ?, ?, ?)
+		`), id, message, createdBy, now); err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(b.db.Rebind(`
+			INSERT INTO broadcast_deliveries (broadcast_id, conv_id, status, error, mtime)
+			VALUES (?, ?, ?, '', ?)
+		`))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, convID := range convIDs {
+			if _, err := stmt.Exec(id, convID, BroadcastPending, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return "", 0, err
+	}
+	b.stats.Count("Create")
+	return id, len(convIDs), b.Resume(id)
+}
+
+// Resume delivers the pending and failed sends of a broadcast in the
+// background.
+func (b *Broadcaster) Resume(id string) error {
+	var message string
+	row := b.db.QueryRow(`SELECT message FROM broadcasts WHERE id = ?`, id)
+	switch err := row.Scan(&message); err {
+	case nil:
+	case sql.ErrNoRows:
+		return fmt.Errorf("unknown broadcast %s", id)
+	default:
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.shutdownCh == nil {
+		return fmt.Errorf("shutting down")
+	} else if b.active[id] {
+		return nil
+	}
+	b.active[id] = true
+	shutdownCh := b.shutdownCh
+	b.wg.Add(1)
+	GoWithRecover(b.DebugOutput, func() {
+		defer b.wg.Done()
+		defer func() {
+			b.Lock()
+			delete(b.active, id)
+			b.Unlock()
+		}()
+		if err := b.deliver(shutdownCh, id, message); err != nil {
+			b.Errorf("broadcast %s: %v", id, err)
+		}
+	})
+	return nil
+}
+
+func (b *Broadcaster) deliver(shutdownCh chan struct{}, id, message string) error {
+	rows, err := b.db.Query(`
+		SELECT conv_id
+		FROM broadcast_deliveries
+		WHERE broadcast_id = ? AND status IN (?, ?)
+	`, id, BroadcastPending, BroadcastFailed)
+	if err != nil {
+		return err
+	}
+	var convIDs []chat1.ConvIDStr
+	for rows.Next() {
+		var convID chat1.ConvIDStr
+		if err := rows.Scan(&convID); err != nil {
+			rows.Close()
+			return err
+		}
+		convIDs = append(convIDs, convID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(broadcastSendInterval)
+	defer ticker.Stop()
+	for _, convID := range convIDs {
+		select {
+		case <-shutdownCh:
+			b.Debug("deliver: shutting down, %s can be resumed", id)
+			return nil
+		case <-ticker.C:
+		}
+		status, errMsg := BroadcastSent, ""
+		body, err := b.render(message, convID)
+		if err == nil {
+			_, err = b.Config().KBC.SendMessageByConvID(convID, "%s", body)
+		}
+		if err != nil {
+			errMsg = err.Error()
+			if b.CollectGoneConv(convID, err) || GetNonFatalChatError(err) != nil {
+				status = BroadcastSkipped
+			} else {
+				status = BroadcastFailed
+			}
+		}
+		b.stats.Count("deliver - " + status)
+		DefaultMetrics.CounterInc("keybase_bot_broadcast_deliveries_total", "Announcement deliveries.",
+			"status", status)
+		if _, err := b.db.Exec(`
+			UPDATE broadcast_deliveries
+			SET status = ?, error = ?, mtime = ?
+			WHERE broadcast_id = ? AND conv_id = ?
+		`, status, errMsg, time.Now().UTC(), id, convID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Status returns the most recent broadcasts, or just id if it's set, with
+// delivery counts by status.
+func (b *Broadcaster) Status(id string, limit int) (statuses []BroadcastStatus, err error) {
+	query := `SELECT id, message, created_by, ctime FROM broadcasts`
+	args := []interface{}{}
+	if id != "" {
+		query += ` WHERE id = ?`
+		args = append(args, id)
+	}
+	query += fmt.Sprintf(` ORDER BY ctime DESC LIMIT %d`, limit)
+	rows, err := b.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		status := BroadcastStatus{Counts: make(map[string]int)}
+		if err := rows.Scan(&status.ID, &status.Message, &status.CreatedBy, &status.Ctime); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for index := range statuses {
+		rows, err := b.db.Query(`
+			SELECT status, COUNT(*)
+			FROM broadcast_deliveries
+			WHERE broadcast_id = ?
+			GROUP BY status
+		`, statuses[index].ID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var status string
+			var count int
+			if err := rows.Scan(&status, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			statuses[index].Counts[status] = count
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+// Shutdown stops delivering, unsent conversations stay pending.
+func (b *Broadcaster) Shutdown() (err error) {
+	defer b.Trace(&err, "Shutdown")()
+	b.Lock()
+	if b.shutdownCh != nil {
+		close(b.shutdownCh)
+		b.shutdownCh = nil
+	}
+	b.Unlock()
+	b.wg.Wait()
+	return nil
+}
+
+// AdminCommands lets bot admins preview, send and track announcements.
+func (b *Broadcaster) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "broadcast",
+			Usage:       "[preview|send <message>|status [id]|resume <id>]",
+			Description: "Announce something to every conversation, `{{.Bot}}` is replaced with the bot's name",
+			Handler:     b.handleAdminBroadcast,
+		},
+	}
+}
+
+var broadcastMessageRE = regexp.MustCompile(`(?s)^\s*\S+\s+admin\s+broadcast\s+(?:preview|send)\s+(.+)$`)
+
+func (b *Broadcaster) handleAdminBroadcast(msg chat1.MsgSummary, args []string) error {
+	if len(args) == 0 {
+		args = []string{"status"}
+	}
+	// preserve the message's formatting rather than the split tokens
+	var message string
+	if match := broadcastMessageRE.FindStringSubmatch(msg.Content.Text.Body); match != nil {
+		message = strings.TrimSpace(match[1])
+	}
+	switch {
+	case args[0] == "preview" && message != "":
+		body, err := b.render(message, msg.ConvID)
+		if err != nil {
+			b.ChatEcho(msg.ConvID, "Invalid template: %v", err)
+			return nil
+		}
+		convIDs, err := b.conversations()
+		if err != nil {
+			return err
+		}
+		b.ChatEcho(msg.ConvID, "This would be sent to %d conversations:\n\n%s", len(convIDs), body)
+	case args[0] == "send" && message != "":
+		id, recipients, err := b.Create(message, msg.Sender.Username)
+		if err != nil {
+			return err
+		}
+		b.ChatEcho(msg.ConvID, "OK! Sending broadcast `%s` to %d conversations, check on it with `broadcast status %s`",
+			id, recipients, id)
+	case args[0] == "status" && len(args) <= 2:
+		var id string
+		if len(args) == 2 {
+			id = args[1]
+		}
+		statuses, err := b.Status(id, 10)
+		if err != nil {
+			return err
+		} else if len(statuses) == 0 {
+			b.ChatEcho(msg.ConvID, "No broadcasts found")
+			return nil
+		}
+		lines := make([]string, len(statuses))
+		for index, status := range statuses {
+			lines[index] = fmt.Sprintf("%s %s by %s: %d pending, %d sent, %d skipped, %d failed",
+				status.ID, status.Ctime.Format(time.RFC3339), status.CreatedBy, status.Counts[BroadcastPending],
+				status.Counts[BroadcastSent], status.Counts[BroadcastSkipped], status.Counts[BroadcastFailed])
+		}
+		b.ChatEcho(msg.ConvID, "```%s```", strings.Join(lines, "\n"))
+	case args[0] == "resume" && len(args) == 2:
+		if err := b.Resume(args[1]); err != nil {
+			b.ChatEcho(msg.ConvID, "%s", err)
+			return nil
+		}
+		b.ChatEcho(msg.ConvID, "OK! Resuming broadcast `%s`", args[1])
+	default:
+		b.ChatEcho(msg.ConvID, "Usage: `broadcast [preview|send <message>|status [id]|resume <id>]`")
+	}
+	return nil
+}
+
+// BroadcastMigrations creates the broadcasts and broadcast_deliveries tables
+// used by Broadcaster.
+var BroadcastMigrations = []Migration{
+	{
+		ID: "base-broadcasts-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS broadcasts (
+					id varchar(32) NOT NULL,
+					message text NOT NULL,
+					created_by varchar(128) NOT NULL,
+					ctime datetime(6) NOT NULL,
+					PRIMARY KEY (id),
+					KEY ctime (ctime)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, `
+				CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+					broadcast_id varchar(32) NOT NULL,
+					conv_id char(64) NOT NULL,
+					status varchar(16) NOT NULL,
+					error text NOT NULL,
+					mtime datetime(6) NOT NULL,
+					PRIMARY KEY (broadcast_id, conv_id)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS broadcasts (
+					id varchar(32) PRIMARY KEY,
+					message text NOT NULL,
+					created_by varchar(128) NOT NULL,
+					ctime timestamp with time zone NOT NULL
+				)`, `
+				CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+					broadcast_id varchar(32) NOT NULL,
+					conv_id char(64) NOT NULL,
+					status varchar(16) NOT NULL,
+					error text NOT NULL,
+					mtime timestamp with time zone NOT NULL,
+					PRIMARY KEY (broadcast_id, conv_id)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS broadcasts (
+					id text NOT NULL PRIMARY KEY,
+					message text NOT NULL,
+					created_by text NOT NULL,
+					ctime datetime NOT NULL
+				)`, `
+				CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+					broadcast_id text NOT NULL,
+					conv_id text NOT NULL,
+					status text NOT NULL,
+					error text NOT NULL,
+					mtime datetime NOT NULL,
+					PRIMARY KEY (broadcast_id, conv_id)
+				)`,
+			},
+		},
+	},
+}
@@ -0,0 +1,377 @@
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+type CommandFlagType int
+
+const (
+	StringCommandFlag CommandFlagType = iota
+	IntCommandFlag
+	BoolCommandFlag
+	DurationCommandFlag
+)
+
+// CommandFlag describes a `--name value` (or `--name=value`) option. Bool
+// flags don't take a value.
+type CommandFlag struct {
+	Name        string
+	Type        CommandFlagType
+	Default     string
+	Description string
+}
+
+func (f CommandFlag) parse(value string) (interface{}, error) {
+	switch f.Type {
+	case IntCommandFlag:
+		return strconv.Atoi(value)
+	case BoolCommandFlag:
+		return strconv.ParseBool(value)
+	case DurationCommandFlag:
+		return time.ParseDuration(value)
+	default:
+		return value, nil
+	}
+}
+
+func (f CommandFlag) usage() string {
+	switch f.Type {
+	case BoolCommandFlag:
+		return fmt.Sprintf("[--%s]", f.Name)
+	case IntCommandFlag:
+		return fmt.Sprintf("[--%s <number>]", f.Name)
+	case DurationCommandFlag:
+		return fmt.Sprintf("[--%s <duration>]", f.Name)
+	default:
+		return fmt.Sprintf("[--%s <value>]", f.Name)
+	}
+}
+
+type CommandFunc func(msg chat1.MsgSummary, args *CommandArgs) error
+
+// Command is a single (sub)command handled by a CommandRouter. Name excludes
+// the router prefix, e.g. `accounts connect` for `!gcal accounts connect`.
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Flags       []CommandFlag
+	// MinArgs and MaxArgs bound the positional arguments, a negative MaxArgs
+	// means unbounded.
+	MinArgs int
+	MaxArgs int
+	Handler CommandFunc
+}
+
+func (c Command) usage(prefix string) string {
+	parts := []string{prefix, c.Name}
+	for _, flag := range c.Flags {
+		parts = append(parts, flag.usage())
+	}
+	if c.Usage != "" {
+		parts = append(parts, c.Usage)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c Command) help(prefix string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "`%s`", c.usage(prefix))
+	if c.Description != "" {
+		fmt.Fprintf(&sb, "\n%s", c.Description)
+	}
+	for _, flag := range c.Flags {
+		fmt.Fprintf(&sb, "\n    `--%s`", flag.Name)
+		if flag.Description != "" {
+			fmt.Fprintf(&sb, " %s", flag.Description)
+		}
+		if flag.Default != "" && flag.Type != BoolCommandFlag {
+			fmt.Fprintf(&sb, " (default: %s)", flag.Default)
+		}
+	}
+	return sb.String()
+}
+
+// CommandArgs holds the positional arguments and typed flag values of a parsed
+// command.
+type CommandArgs struct {
+	Positional []string
+	flags      map[string]interface{}
+	set        map[string]bool
+}
+
+func (a *CommandArgs) String(name string) string {
+	value, _ := a.flags[name].(string)
+	return value
+}
+
+func (a *CommandArgs) Int(name string) int {
+	value, _ := a.flags[name].(int)
+	return value
+}
+
+func (a *CommandArgs) Bool(name string) bool {
+	value, _ := a.flags[name].(bool)
+	return value
+}
+
+func (a *CommandArgs) Duration(name string) time.Duration {
+	value, _ := a.flags[name].(time.Duration)
+	return value
+}
+
+// IsSet reports whether the flag was passed explicitly rather than defaulted.
+func (a *CommandArgs) IsSet(name string) bool {
+	return a.set[name]
+}
+
+type commandUserError string
+
+func (e commandUserError) Error() string { return string(e) }
+
+// CommandRouter dispatches messages like `!gcal accounts connect work` to the
+// registered command with the longest matching name. Quoted arguments are
+// supported via SplitTokens, `<prefix> help [command]` and `--help` are
+// generated from the registered commands.
+type CommandRouter struct {
+	*DebugOutput
+
+	stats     *StatsRegistry
+	prefix    string
+	commands  []Command
+	templates *MessageTemplates
+}
+
+// NewCommandRouter creates a router for commands starting with prefix, e.g.
+// `!gcal`. Each handled command is counted by name on stats as is.
+func NewCommandRouter(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, prefix string) *CommandRouter {
+	return &CommandRouter{
+		DebugOutput: NewDebugOutput("CommandRouter", debugConfig),
+		stats:       stats,
+		prefix:      prefix,
+	}
+}
+
+// HelpTemplate is the MessageTemplates name of the `<prefix> help` reply,
+// rendered with HelpTemplateData.
+const HelpTemplate = "help"
+
+// HelpTemplateData is available to the help template, Text is the generated
+// list of commands.
+type HelpTemplateData struct {
+	Prefix string
+	Topic  string
+	Text   string
+}
+
+// SetTemplates renders help replies through templates so deployments can add
+// their own introduction or links.
+func (r *CommandRouter) SetTemplates(templates *MessageTemplates) {
+	templates.Register(HelpTemplate, "{{.Text}}", "Reply to help, .Text lists the commands")
+	r.templates = templates
+}
+
+func (r *CommandRouter) Register(cmds ...Command) {
+	for _, cmd := range cmds {
+		for index, flag := range cmd.Flags {
+			if flag.Type == BoolCommandFlag && flag.Default == "" {
+				cmd.Flags[index].Default = "false"
+			}
+		}
+		r.commands = append(r.commands, cmd)
+	}
+	sort.SliceStable(r.commands, func(i, j int) bool {
+		return r.commands[i].Name < r.commands[j].Name
+	})
+}
+
+// match finds the command with the longest name matching the start of toks
+// and returns the remaining tokens.
+func (r *CommandRouter) match(toks []string) (cmd *Command, rest []string) {
+	var best int
+	for index := range r.commands {
+		nameToks := strings.Fields(r.commands[index].Name)
+		if len(nameToks) > len(toks) || len(nameToks) <= best {
+			continue
+		}
+		matched := true
+		for i, tok := range nameToks {
+			if toks[i] != tok {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			cmd = &r.commands[index]
+			best = len(nameToks)
+		}
+	}
+	if cmd == nil {
+		return nil, nil
+	}
+	return cmd, toks[best:]
+}
+
+func (r *CommandRouter) parseArgs(cmd *Command, toks []string) (args *CommandArgs, wantsHelp bool, err error) {
+	args = &CommandArgs{
+		flags: make(map[string]interface{}),
+		set:   make(map[string]bool),
+	}
+	flags := make(map[string]CommandFlag, len(cmd.Flags))
+	for _, flag := range cmd.Flags {
+		flags[flag.Name] = flag
+	}
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		switch {
+		case tok == "--":
+			args.Positional = append(args.Positional, toks[i+1:]...)
+			i = len(toks)
+			continue
+		case tok == "--help" || tok == "-h":
+			return nil, true, nil
+		case !strings.HasPrefix(tok, "--") || len(tok) == 2:
+			args.Positional = append(args.Positional, tok)
+			continue
+		}
+		name := strings.TrimPrefix(tok, "--")
+		var value string
+		hasValue := false
+		if index := strings.Index(name, "="); index >= 0 {
+			name, value, hasValue = name[:index], name[index+1:], true
+		}
+		flag, ok := flags[name]
+		if !ok {
+			return nil, false, commandUserError(fmt.Sprintf("Unknown flag `--%s`.", name))
+		}
+		if !hasValue {
+			if flag.Type == BoolCommandFlag {
+				value = "true"
+			} else if i+1 < len(toks) {
+				i++
+				value = toks[i]
+			} else {
+				return nil, false, commandUserError(fmt.Sprintf("Flag `--%s` requires a value.", name))
+			}
+		}
+		parsed, err := flag.parse(value)
+		if err != nil {
+			return nil, false, commandUserError(fmt.Sprintf("Invalid value %q for flag `--%s`.", value, name))
+		}
+		args.flags[name] = parsed
+		args.set[name] = true
+	}
+	for _, flag := range cmd.Flags {
+		if _, ok := args.flags[flag.Name]; ok || flag.Default == "" {
+			continue
+		}
+		parsed, err := flag.parse(flag.Default)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid default for flag --%s: %v", flag.Name, err)
+		}
+		args.flags[flag.Name] = parsed
+	}
+	if len(args.Positional) < cmd.MinArgs || (cmd.MaxArgs >= 0 && len(args.Positional) > cmd.MaxArgs) {
+		return nil, false, commandUserError(fmt.Sprintf("Invalid number of arguments, usage: `%s`", cmd.usage(r.prefix)))
+	}
+	return args, false, nil
+}
+
+// HelpText lists every registered command, or the commands under topic when
+// one is given, e.g. `accounts`.
+func (r *CommandRouter) HelpText(topic string) string {
+	var sections []string
+	for _, cmd := range r.commands {
+		if topic != "" && cmd.Name != topic && !strings.HasPrefix(cmd.Name, topic+" ") {
+			continue
+		}
+		sections = append(sections, cmd.help(r.prefix))
+	}
+	if len(sections) == 0 {
+		return fmt.Sprintf("Unknown command %q, try `%s help`.", topic, r.prefix)
+	}
+	if topic == "" {
+		sections = append([]string{"Available commands:"}, sections...)
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+// hasPrefix reports whether body starts with the router prefix as a whole
+// word, e.g. `!gcal list` but not `!gcalendar`.
+func (r *CommandRouter) hasPrefix(body string) bool {
+	rest := strings.TrimPrefix(body, r.prefix)
+	return len(rest) < len(body) && (rest == "" || unicode.IsSpace(rune(rest[0])))
+}
+
+// Handle dispatches msg if it's addressed to this router. handled is false if
+// the message doesn't start with the router prefix so callers can fall through
+// to other processing.
+func (r *CommandRouter) Handle(msg chat1.MsgSummary) (handled bool, err error) {
+	if msg.Content.Text == nil {
+		return false, nil
+	}
+	cmd := strings.TrimSpace(msg.Content.Text.Body)
+	// only tokenize our own commands, other messages may contain apostrophes
+	// or unmatched quotes
+	if !r.hasPrefix(cmd) {
+		return false, nil
+	}
+	toks, userErr, err := SplitTokens(cmd)
+	if err != nil {
+		return true, err
+	} else if userErr != "" {
+		r.ChatEcho(msg.ConvID, userErr)
+		return true, nil
+	}
+	if len(toks) == 0 || toks[0] != r.prefix {
+		return false, nil
+	}
+	toks = toks[1:]
+
+	if len(toks) == 0 || toks[0] == "help" {
+		r.stats.Count("help")
+		var topic string
+		if len(toks) > 1 {
+			topic = strings.Join(toks[1:], " ")
+		}
+		text := r.HelpText(topic)
+		if r.templates != nil {
+			text = r.templates.Render(HelpTemplate, HelpTemplateData{
+				Prefix: r.prefix,
+				Topic:  topic,
+				Text:   text,
+			})
+		}
+		r.ChatEcho(msg.ConvID, "%s", text)
+		return true, nil
+	}
+
+	command, rest := r.match(toks)
+	if command == nil {
+		r.ChatEcho(msg.ConvID, "Unknown command %q", cmd)
+		return true, nil
+	}
+	args, wantsHelp, err := r.parseArgs(command, rest)
+	switch err := err.(type) {
+	case nil:
+	case commandUserError:
+		r.ChatEcho(msg.ConvID, "%s", err.Error())
+		return true, nil
+	default:
+		return true, err
+	}
+	if wantsHelp {
+		r.ChatEcho(msg.ConvID, "%s", command.help(r.prefix))
+		return true, nil
+	}
+	r.stats.Count(command.Name)
+	return true, command.Handler(msg, args)
+}
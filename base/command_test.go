@@ -0,0 +1,154 @@
+package base_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/bottest"
+)
+
+func TestMain(m *testing.M) { bottest.Main(m) }
+
+func textMsg(convID chat1.ConvIDStr, body string) chat1.MsgSummary {
+	return chat1.MsgSummary{
+		ConvID:  convID,
+		Content: chat1.MsgContent{TypeName: "text", Text: &chat1.MsgTextContent{Body: body}},
+	}
+}
+
+func TestCommandRouter(t *testing.T) {
+	fake := bottest.NewFakeChat("testbot")
+	defer fake.Close()
+	kbc := fake.Start(t)
+	debugConfig := base.NewChatDebugOutputConfig(kbc, "")
+	stats, err := base.NewStatsRegistry(debugConfig, "")
+	require.NoError(t, err)
+
+	var called string
+	var calledArgs *base.CommandArgs
+	handler := func(name string) func(chat1.MsgSummary, *base.CommandArgs) error {
+		return func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+			called, calledArgs = name, args
+			return nil
+		}
+	}
+	router := base.NewCommandRouter(stats, debugConfig, "!test")
+	router.Register(
+		base.Command{
+			Name:        "list",
+			Description: "List things",
+			MaxArgs:     0,
+			Handler:     handler("list"),
+		},
+		base.Command{
+			Name:        "accounts connect",
+			Usage:       "<nickname>",
+			Description: "Connect an account",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler:     handler("accounts connect"),
+		},
+		base.Command{
+			Name:        "remind",
+			Usage:       "<text...>",
+			Description: "Set a reminder",
+			Flags: []base.CommandFlag{
+				{Name: "in", Type: base.DurationCommandFlag, Default: "5m", Description: "When to remind"},
+				{Name: "count", Type: base.IntCommandFlag, Description: "How often"},
+				{Name: "quiet", Type: base.BoolCommandFlag, Description: "Don't mention"},
+			},
+			MinArgs: 1,
+			MaxArgs: -1,
+			Handler: handler("remind"),
+		},
+	)
+
+	convID := chat1.ConvIDStr("deadbeef")
+	handle := func(body string) (handled bool, reply string) {
+		called, calledArgs = "", nil
+		before := len(fake.Sent())
+		handled, err := router.Handle(textMsg(convID, body))
+		require.NoError(t, err)
+		if sent := fake.Sent(); len(sent) > before {
+			require.Len(t, sent, before+1)
+			reply = sent[before].Body
+		}
+		return handled, reply
+	}
+
+	testCases := []struct {
+		name    string
+		body    string
+		handled bool
+		called  string
+		reply   string
+	}{
+		{name: "other message", body: "hello there"},
+		{name: "unmatched quote", body: "it's a \"test"},
+		{name: "other prefix", body: "!testing 'one"},
+		{name: "other bot", body: "!gcal list"},
+		{name: "command", body: "!test list", handled: true, called: "list"},
+		{name: "longest match", body: "!test accounts connect work", handled: true, called: "accounts connect"},
+		{name: "unknown command", body: "!test nope", handled: true, reply: `Unknown command "!test nope"`},
+		{name: "unterminated quote", body: "!test remind 'oops", handled: true,
+			reply: "Error in command: unterminated single-quoted string"},
+		{name: "too few args", body: "!test accounts connect", handled: true,
+			reply: "Invalid number of arguments, usage: `!test accounts connect <nickname>`"},
+		{name: "too many args", body: "!test list all", handled: true,
+			reply: "Invalid number of arguments, usage: `!test list`"},
+		{name: "unknown flag", body: "!test remind --at 5 lunch", handled: true, reply: "Unknown flag `--at`."},
+		{name: "missing flag value", body: "!test remind lunch --count", handled: true,
+			reply: "Flag `--count` requires a value."},
+		{name: "invalid flag value", body: "!test remind --count many lunch", handled: true,
+			reply: "Invalid value \"many\" for flag `--count`."},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handled, reply := handle(tc.body)
+			require.Equal(t, tc.handled, handled)
+			require.Equal(t, tc.called, called)
+			require.Equal(t, tc.reply, reply)
+		})
+	}
+
+	t.Run("quoting and flags", func(t *testing.T) {
+		handled, reply := handle(`!test remind --count=2 --quiet "lunch with 'bob'" -- --soon`)
+		require.True(t, handled)
+		require.Empty(t, reply)
+		require.Equal(t, "remind", called)
+		require.Equal(t, []string{"lunch with 'bob'", "--soon"}, calledArgs.Positional)
+		require.Equal(t, 2, calledArgs.Int("count"))
+		require.True(t, calledArgs.Bool("quiet"))
+		require.True(t, calledArgs.IsSet("count"))
+		require.Equal(t, 5*time.Minute, calledArgs.Duration("in"))
+		require.False(t, calledArgs.IsSet("in"))
+
+		handle("!test remind --in 1h lunch")
+		require.Equal(t, time.Hour, calledArgs.Duration("in"))
+		require.False(t, calledArgs.Bool("quiet"))
+	})
+
+	t.Run("help", func(t *testing.T) {
+		handled, reply := handle("!test")
+		require.True(t, handled)
+		require.Equal(t, router.HelpText(""), reply)
+		require.Contains(t, reply, "Available commands:")
+		require.Contains(t, reply, "!test accounts connect <nickname>")
+
+		_, reply = handle("!test help accounts")
+		require.Equal(t, router.HelpText("accounts"), reply)
+		require.Contains(t, reply, "Connect an account")
+		require.NotContains(t, reply, "List things")
+
+		_, reply = handle("!test help nope")
+		require.Equal(t, "Unknown command \"nope\", try `!test help`.", reply)
+
+		_, reply = handle("!test remind --help")
+		require.Empty(t, called)
+		require.Contains(t, reply, "When to remind")
+	})
+}
@@ -0,0 +1,218 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const convGCQueueSize = 100
+
+// chat errors (lowercased) which mean the bot can't reach a conversation
+// anymore, e.g. it was kicked from the team or the other user deleted their
+// account
+var convGoneErrors = []string{
+	"no conversations matched",
+	"getconvtriple called with unknown conversationid",
+	"convnotfound",
+	"conversation not found",
+	"sentdeleted",
+	"not a member",
+}
+
+// IsConvGoneError returns whether err from a chat call means the conversation
+// is gone for good, rather than a transient failure.
+func IsConvGoneError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, gone := range convGoneErrors {
+		if strings.Contains(msg, gone) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvCleanupHook purges a bot's state for a conversation.
+type ConvCleanupHook func(convID chat1.ConvIDStr) error
+
+type convCleanupHook struct {
+	name string
+	fn   ConvCleanupHook
+}
+
+// ConvGC purges the subscriptions, channels and other state bots keep for
+// conversations they were removed from. Every base send path reports errors
+// to it through DebugOutput.CollectGoneConv once it's set as the ConvGC of
+// the bot's ChatDebugOutputConfig, which NewConvGC does. Before purging it
+// confirms with the chat API that the bot really lost the conversation, then
+// runs every hook added with AddHook.
+type ConvGC struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats      *StatsRegistry
+	hooks      []convCleanupHook
+	pending    map[chat1.ConvIDStr]bool
+	queueCh    chan chat1.ConvIDStr
+	shutdownCh chan struct{}
+}
+
+func NewConvGC(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig) *ConvGC {
+	g := &ConvGC{
+		DebugOutput: NewDebugOutput("ConvGC", debugConfig),
+		stats:       stats.SetPrefix("ConvGC"),
+		pending:     make(map[chat1.ConvIDStr]bool),
+		queueCh:     make(chan chat1.ConvIDStr, convGCQueueSize),
+		shutdownCh:  make(chan struct{}),
+	}
+	debugConfig.ConvGC = g
+	return g
+}
+
+// AddHook registers name's cleanup, hooks run in the order they're added.
+func (g *ConvGC) AddHook(name string, fn ConvCleanupHook) {
+	g.Lock()
+	defer g.Unlock()
+	g.hooks = append(g.hooks, convCleanupHook{name: name, fn: fn})
+}
+
+// Collect queues convID for cleanup if err means it's gone. It returns
+// whether it did.
+func (g *ConvGC) Collect(convID chat1.ConvIDStr, err error) bool {
+	if convID == "" || !IsConvGoneError(err) {
+		return false
+	}
+	g.Lock()
+	defer g.Unlock()
+	if g.pending[convID] {
+		return true
+	}
+	select {
+	case g.queueCh <- convID:
+		g.pending[convID] = true
+		g.stats.Count("Collect - queued")
+	default:
+		// the conversation is collected again on its next failed send
+		g.stats.Count("Collect - full")
+		g.Debug("Collect: queue full, dropping %s", convID)
+	}
+	return true
+}
+
+func (g *ConvGC) Run() error {
+	g.Lock()
+	shutdownCh := g.shutdownCh
+	g.Unlock()
+	if shutdownCh == nil {
+		return nil
+	}
+	for {
+		select {
+		case <-shutdownCh:
+			return nil
+		case convID := <-g.queueCh:
+			if err := g.collect(convID); err != nil {
+				g.Errorf("Run: unable to clean up %s: %v", convID, err)
+			}
+			g.Lock()
+			delete(g.pending, convID)
+			g.Unlock()
+		}
+	}
+}
+
+// isGone double checks a send error against the conversation's membership.
+func (g *ConvGC) isGone(convID chat1.ConvIDStr) (bool, error) {
+	conv, err := g.Config().KBC.GetConversation(convID)
+	if err != nil {
+		if IsConvGoneError(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	switch strings.ToLower(conv.MemberStatus) {
+	case "removed", "left", "reset", "never_joined":
+		return true, nil
+	}
+	return false, nil
+}
+
+func (g *ConvGC) collect(convID chat1.ConvIDStr) error {
+	gone, err := g.isGone(convID)
+	if err != nil {
+		return err
+	} else if !gone {
+		g.stats.Count("collect - still member")
+		g.Debug("collect: still a member of %s, keeping its state", convID)
+		return nil
+	}
+	return g.Purge(convID)
+}
+
+// Purge runs every cleanup hook for convID, whether or not it's gone.
+func (g *ConvGC) Purge(convID chat1.ConvIDStr) (err error) {
+	defer g.Trace(&err, "Purge(%s)", convID)()
+	g.Lock()
+	hooks := g.hooks
+	g.Unlock()
+	var failed []string
+	for _, hook := range hooks {
+		if err := RecoverToError(g.DebugOutput, "hook "+hook.name, func() error { return hook.fn(convID) }); err != nil {
+			g.Debug("Purge: %s failed for %s: %v", hook.name, convID, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", hook.name, err))
+		}
+	}
+	result := "success"
+	if len(failed) > 0 {
+		result = "error"
+	}
+	g.stats.Count("Purge - " + result)
+	DefaultMetrics.CounterInc("keybase_bot_conv_gc_total", "Conversations cleaned up after the bot lost access.",
+		"result", result)
+	if len(failed) > 0 {
+		return fmt.Errorf("cleanup failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (g *ConvGC) Shutdown() (err error) {
+	defer g.Trace(&err, "Shutdown")()
+	g.Lock()
+	defer g.Unlock()
+	if g.shutdownCh != nil {
+		close(g.shutdownCh)
+		g.shutdownCh = nil
+	}
+	return nil
+}
+
+// AdminCommands lets bot admins purge a conversation by hand.
+func (g *ConvGC) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "gc",
+			Usage:       "<conv id>",
+			Description: "Delete all state for a conversation the bot was removed from",
+			Handler:     g.handleAdminGC,
+		},
+	}
+}
+
+func (g *ConvGC) handleAdminGC(msg chat1.MsgSummary, args []string) error {
+	if len(args) != 1 {
+		g.ChatEcho(msg.ConvID, "Usage: `gc <conv id>`")
+		return nil
+	}
+	convID := chat1.ConvIDStr(args[0])
+	if err := g.Purge(convID); err != nil {
+		g.ChatEcho(msg.ConvID, "Unable to clean up `%s`: %v", convID, err)
+		return nil
+	}
+	g.ChatEcho(msg.ConvID, "OK! Cleaned up `%s`", convID)
+	return nil
+}
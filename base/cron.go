@@ -0,0 +1,158 @@
+package base
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression. It supports the standard five
+// fields (minute, hour, day of month, month, day of week) with `*`, lists,
+// ranges and steps, e.g. `*/15 9-17 * * 1-5`, as well as the `@hourly`,
+// `@daily`, `@weekly` and `@monthly` shorthands and `@every <duration>`.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// cron matches either day field when both are restricted
+	domStar, dowStar bool
+	every            time.Duration
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+var cronShorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+func ParseCron(spec string) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		} else if every < time.Second {
+			return nil, fmt.Errorf("invalid cron expression %q: interval must be at least 1s", spec)
+		}
+		return &CronSchedule{every: every}, nil
+	}
+	if expanded, ok := cronShorthands[spec]; ok {
+		spec = expanded
+	}
+	toks := strings.Fields(spec)
+	if len(toks) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected %d fields, got %d", spec, len(cronFields), len(toks))
+	}
+	var bits [5]uint64
+	for index, field := range cronFields {
+		var err error
+		if bits[index], err = parseCronField(toks[index], field); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		}
+	}
+	// 7 is also Sunday
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+	return &CronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: toks[2] == "*" || toks[2] == "?",
+		dowStar: toks[4] == "*" || toks[4] == "?",
+	}, nil
+}
+
+func parseCronField(tok string, field cronField) (bits uint64, err error) {
+	max := field.max
+	if field.name == "day of week" {
+		max = 7
+	}
+	for _, part := range strings.Split(tok, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			if step, err = strconv.Atoi(part[index+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %s field %q", field.name, part)
+			}
+			part = part[:index]
+		}
+		lo, hi := field.min, max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", field.name, part)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", field.name, part)
+			}
+		default:
+			if lo, err = strconv.Atoi(part); err != nil {
+				return 0, fmt.Errorf("invalid %s %q", field.name, part)
+			}
+			if step == 1 {
+				hi = lo
+			}
+		}
+		if lo < field.min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%s %q out of range %d-%d", field.name, part, field.min, max)
+		}
+		for value := lo; value <= hi; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t matching the schedule, in t's
+// location, or the zero time if there is none within five years.
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,73 @@
+package base_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+func TestParseCron(t *testing.T) {
+	at := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2020, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	// June 1st 2020 is a Monday
+	testCases := []struct {
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		{spec: "* * * * *", from: at(6, 1, 10, 7), next: at(6, 1, 10, 8)},
+		{spec: "*/15 * * * *", from: at(6, 1, 10, 7), next: at(6, 1, 10, 15)},
+		{spec: "*/15 * * * *", from: at(6, 1, 10, 45), next: at(6, 1, 11, 0)},
+		{spec: "5,35 * * * *", from: at(6, 1, 10, 5), next: at(6, 1, 10, 35)},
+		{spec: "0 9-17/4 * * *", from: at(6, 1, 9, 0), next: at(6, 1, 13, 0)},
+		{spec: "0 9-17/4 * * *", from: at(6, 1, 17, 0), next: at(6, 2, 9, 0)},
+		{spec: "30 17 * * 1-5", from: at(6, 5, 18, 0), next: at(6, 8, 17, 30)},
+		// 0 and 7 are both Sunday
+		{spec: "0 0 * * 0", from: at(6, 6, 12, 0), next: at(6, 7, 0, 0)},
+		{spec: "0 0 * * 7", from: at(6, 6, 12, 0), next: at(6, 7, 0, 0)},
+		{spec: "0 0 31 * *", from: at(6, 1, 0, 0), next: at(7, 31, 0, 0)},
+		{spec: "0 0 1 */3 *", from: at(6, 1, 0, 0), next: at(7, 1, 0, 0)},
+		// with both day fields restricted either one matches
+		{spec: "0 0 13 * 5", from: at(6, 1, 0, 0), next: at(6, 5, 0, 0)},
+		{spec: "0 0 13 * 5", from: at(6, 12, 0, 0), next: at(6, 13, 0, 0)},
+		// with one of them `*` only the other one counts
+		{spec: "0 0 13 * *", from: at(6, 1, 0, 0), next: at(6, 13, 0, 0)},
+		{spec: "0 0 ? * 5", from: at(6, 1, 0, 0), next: at(6, 5, 0, 0)},
+		{spec: "@hourly", from: at(6, 1, 10, 7), next: at(6, 1, 11, 0)},
+		{spec: "@weekly", from: at(6, 1, 10, 7), next: at(6, 7, 0, 0)},
+		{spec: "@every 90m", from: at(6, 1, 10, 7), next: at(6, 1, 11, 37)},
+	}
+	for _, tc := range testCases {
+		schedule, err := base.ParseCron(tc.spec)
+		require.NoError(t, err, tc.spec)
+		require.Equal(t, tc.next, schedule.Next(tc.from), "%s from %v", tc.spec, tc.from)
+	}
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@often",
+		"@every 500ms",
+		"@every soon",
+	} {
+		_, err := base.ParseCron(spec)
+		require.Error(t, err, spec)
+	}
+}
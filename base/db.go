@@ -3,6 +3,8 @@ package base
 import (
 	"database/sql"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -10,12 +12,136 @@ import (
 
 type DB struct {
 	*sql.DB
+	Dialect Dialect
 }
 
 func NewDB(db *sql.DB) *DB {
+	return NewDBWithDialect(db, MySQLDialect)
+}
+
+func NewDBWithDialect(db *sql.DB, dialect Dialect) *DB {
 	return &DB{
-		DB: db,
+		DB:      db,
+		Dialect: dialect,
+	}
+}
+
+// OpenDB connects to the database described by dsn, see ParseDSN for the
+// supported formats.
+func OpenDB(dsn string) (*DB, error) {
+	dialect, driverDSN := ParseDSN(dsn)
+	db, err := sql.Open(dialect.DriverName(), driverDSN)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s database: %v", dialect, err)
+	}
+	if dialect == SQLiteDialect {
+		// sqlite only supports a single writer
+		db.SetMaxOpenConns(1)
+	}
+	return NewDBWithDialect(db, dialect), nil
+}
+
+// OpenMySQLDB is OpenDB for bots whose queries are still MySQL only, it
+// rejects the DSNs of other databases up front instead of failing on the
+// first query.
+func OpenMySQLDB(dsn string) (*DB, error) {
+	if dialect, _ := ParseDSN(dsn); dialect != MySQLDialect {
+		return nil, fmt.Errorf("only MySQL is supported, not %s", dialect)
+	}
+	return OpenDB(dsn)
+}
+
+// Rebind converts `?` placeholders for the database's dialect, queries run
+// within RunTxn should be passed through this.
+func (d *DB) Rebind(query string) string {
+	return d.Dialect.Rebind(query)
+}
+
+const DefaultSlowQueryThreshold = 500 * time.Millisecond
+
+// DBPoolOptions tunes the connection pool of a bot's database, zero values
+// keep the database/sql defaults.
+type DBPoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// Queries slower than this are logged, zero disables logging
+	SlowQueryThreshold time.Duration
+}
+
+var slowQueryLog = struct {
+	sync.Mutex
+	*DebugOutput
+	threshold time.Duration
+}{
+	DebugOutput: NewDebugOutput("DB", nil),
+	threshold:   DefaultSlowQueryThreshold,
+}
+
+// ConfigureDBPool applies opts to db and exports its pool statistics, such as
+// connections in use and time spent waiting for one, as metrics.
+func ConfigureDBPool(db *sql.DB, opts DBPoolOptions) {
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
 	}
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+	}
+	slowQueryLog.Lock()
+	slowQueryLog.threshold = opts.SlowQueryThreshold
+	slowQueryLog.Unlock()
+	DefaultMetrics.AddCollector(func() {
+		stats := db.Stats()
+		DefaultMetrics.GaugeSet("keybase_bot_db_connections", "Database connections by state.",
+			float64(stats.InUse), "state", "in_use")
+		DefaultMetrics.GaugeSet("keybase_bot_db_connections", "Database connections by state.",
+			float64(stats.Idle), "state", "idle")
+		DefaultMetrics.GaugeSet("keybase_bot_db_max_open_connections", "Maximum open database connections.",
+			float64(stats.MaxOpenConnections))
+		DefaultMetrics.GaugeSet("keybase_bot_db_wait_count", "Total waits for a free database connection.",
+			float64(stats.WaitCount))
+		DefaultMetrics.GaugeSet("keybase_bot_db_wait_duration_seconds", "Total time spent waiting for a free database connection.",
+			stats.WaitDuration.Seconds())
+	})
+}
+
+func observeQuery(op, query string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+	DefaultMetrics.Observe("keybase_bot_db_query_duration_seconds", "Database query latencies.",
+		elapsed.Seconds(), "op", op)
+	if err != nil && *err != nil && *err != sql.ErrNoRows {
+		DefaultMetrics.CounterInc("keybase_bot_db_errors_total", "Failed database queries.", "op", op)
+	}
+	slowQueryLog.Lock()
+	threshold := slowQueryLog.threshold
+	slowQueryLog.Unlock()
+	if threshold > 0 && elapsed > threshold {
+		DefaultMetrics.CounterInc("keybase_bot_db_slow_queries_total", "Database queries slower than the slow query threshold.",
+			"op", op)
+		query = strings.Join(strings.Fields(query), " ")
+		if len(query) > 256 {
+			query = query[:256] + "..."
+		}
+		slowQueryLog.Debug("slow %s took %v: %s", op, elapsed, query)
+	}
+}
+
+func (d *DB) Query(query string, args ...interface{}) (rows *sql.Rows, err error) {
+	defer observeQuery("query", query, time.Now(), &err)
+	return d.DB.Query(d.Rebind(query), args...)
+}
+
+func (d *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	defer observeQuery("query_row", query, time.Now(), nil)
+	return d.DB.QueryRow(d.Rebind(query), args...)
+}
+
+func (d *DB) Exec(query string, args ...interface{}) (res sql.Result, err error) {
+	defer observeQuery("exec", query, time.Now(), &err)
+	return d.DB.Exec(d.Rebind(query), args...)
 }
 
 func (d *DB) RunTxn(fn func(tx *sql.Tx) error) error {
@@ -37,20 +163,26 @@ type BaseOAuthDB struct {
 }
 
 func NewBaseOAuthDB(db *sql.DB) *BaseOAuthDB {
+	return NewBaseOAuthDBWithDB(NewDB(db))
+}
+
+func NewBaseOAuthDBWithDB(db *DB) *BaseOAuthDB {
 	return &BaseOAuthDB{
-		DB: NewDB(db),
+		DB: db,
 	}
 }
 
 func (d *BaseOAuthDB) GetState(state string) (*OAuthRequest, error) {
 	var oauthState OAuthRequest
-	row := d.DB.QueryRow(`SELECT identifier, conv_id, msg_id, is_complete
+	var ctime int64
+	row := d.DB.QueryRow(fmt.Sprintf(`SELECT identifier, conv_id, msg_id, is_complete, code_verifier, %s
 		FROM oauth_state
-		WHERE state = ?`, state)
+		WHERE state = ?`, d.Dialect.UnixTimestamp("ctime")), state)
 	err := row.Scan(&oauthState.TokenIdentifier, &oauthState.ConvID,
-		&oauthState.MsgID, &oauthState.IsComplete)
+		&oauthState.MsgID, &oauthState.IsComplete, &oauthState.CodeVerifier, &ctime)
 	switch err {
 	case nil:
+		oauthState.Ctime = time.Unix(ctime, 0)
 		return &oauthState, nil
 	case sql.ErrNoRows:
 		return nil, nil
@@ -59,16 +191,23 @@ func (d *BaseOAuthDB) GetState(state string) (*OAuthRequest, error) {
 	}
 }
 
+// oauthStateRetention is how long used and expired states are kept around,
+// so following an old link still shows why it doesn't work
+const oauthStateRetention = 24 * time.Hour
+
 func (d *BaseOAuthDB) PutState(state string, oauthState *OAuthRequest) error {
 	err := d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`INSERT INTO oauth_state
-		(state, identifier, conv_id, msg_id)
-		VALUES (?, ?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		identifier=VALUES(identifier),
-		conv_id=VALUES(conv_id),
-		msg_id=VALUES(msg_id)
-	`, state, oauthState.TokenIdentifier, oauthState.ConvID, oauthState.MsgID)
+		now := time.Now()
+		_, err := tx.Exec(d.Rebind(d.Dialect.Upsert("oauth_state",
+			[]string{"state", "identifier", "conv_id", "msg_id", "code_verifier", "ctime"},
+			[]string{"state"},
+			[]string{"identifier", "conv_id", "msg_id", "code_verifier", "ctime"})),
+			state, oauthState.TokenIdentifier, oauthState.ConvID, oauthState.MsgID, oauthState.CodeVerifier, now)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(d.Rebind(`DELETE FROM oauth_state
+		WHERE ctime < ?`), now.Add(-oauthStateRetention))
 		return err
 	})
 	return err
@@ -76,35 +215,57 @@ func (d *BaseOAuthDB) PutState(state string, oauthState *OAuthRequest) error {
 
 func (d *BaseOAuthDB) CompleteState(state string) error {
 	err := d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`UPDATE oauth_state
+		res, err := tx.Exec(d.Rebind(`UPDATE oauth_state
 		SET is_complete=true
-		WHERE state = ?`, state)
-		return err
+		WHERE state = ? AND is_complete=false`), state)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return ErrOAuthStateUsed
+		}
+		return nil
 	})
 	return err
 }
 
 type OAuthDB struct {
 	*BaseOAuthDB
+	cipher *FieldCipher
 }
 
 func NewOAuthDB(db *sql.DB) *OAuthDB {
+	return NewOAuthDBWithDB(NewDB(db))
+}
+
+func NewOAuthDBWithDB(db *DB) *OAuthDB {
 	return &OAuthDB{
-		BaseOAuthDB: NewBaseOAuthDB(db),
+		BaseOAuthDB: NewBaseOAuthDBWithDB(db),
 	}
 }
 
+// SetCipher encrypts tokens written from now on, tokens already stored in
+// plaintext are still readable until EncryptExistingTokens is run.
+func (d *OAuthDB) SetCipher(cipher *FieldCipher) {
+	d.cipher = cipher
+}
+
 func (d *OAuthDB) GetToken(identifier string) (*oauth2.Token, error) {
 	var token oauth2.Token
 	var expiry int64
-	row := d.DB.QueryRow(`SELECT access_token, token_type, refresh_token, ROUND(UNIX_TIMESTAMP(expiry))
+	row := d.DB.QueryRow(fmt.Sprintf(`SELECT access_token, token_type, refresh_token, %s
 		FROM oauth
-		WHERE identifier = ?`, identifier)
+		WHERE identifier = ?`, d.Dialect.UnixTimestamp("expiry")), identifier)
 	err := row.Scan(&token.AccessToken, &token.TokenType,
 		&token.RefreshToken, &expiry)
 	switch err {
 	case nil:
 		token.Expiry = time.Unix(expiry, 0)
+		if err := d.cipher.DecryptToken(&token); err != nil {
+			return nil, fmt.Errorf("unable to decrypt token for %s: %v", identifier, err)
+		}
 		return &token, nil
 	case sql.ErrNoRows:
 		return nil, nil
@@ -114,25 +275,80 @@ func (d *OAuthDB) GetToken(identifier string) (*oauth2.Token, error) {
 }
 
 func (d *OAuthDB) PutToken(identifier string, token *oauth2.Token) error {
-	err := d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`INSERT INTO oauth
-		(identifier, access_token, token_type, refresh_token, expiry, ctime, mtime)
-		VALUES (?, ?, ?, ?, ?, NOW(), NOW())
-		ON DUPLICATE KEY UPDATE
-		access_token=VALUES(access_token),
-		refresh_token=VALUES(refresh_token),
-		expiry=VALUES(expiry),
-		mtime=VALUES(mtime)
-	`, identifier, token.AccessToken, token.TokenType, token.RefreshToken, token.Expiry)
+	token, err := d.cipher.EncryptToken(token)
+	if err != nil {
+		return err
+	}
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		now := time.Now()
+		_, err := tx.Exec(d.Rebind(d.Dialect.Upsert("oauth",
+			[]string{"identifier", "access_token", "token_type", "refresh_token", "expiry", "ctime", "mtime"},
+			[]string{"identifier"},
+			[]string{"access_token", "refresh_token", "expiry", "mtime"})),
+			identifier, token.AccessToken, token.TokenType, token.RefreshToken, token.Expiry, now, now)
 		return err
 	})
 	return err
 }
 
+// EncryptExistingTokens encrypts every token still stored in plaintext and
+// returns how many were updated. It's safe to run more than once.
+func (d *OAuthDB) EncryptExistingTokens() (updated int, err error) {
+	if d.cipher == nil {
+		return 0, fmt.Errorf("no encryption key configured")
+	}
+	rows, err := d.DB.Query(`SELECT identifier, access_token, refresh_token
+		FROM oauth`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	type storedToken struct {
+		identifier, access, refresh string
+	}
+	var plaintext []storedToken
+	for rows.Next() {
+		var token storedToken
+		if err := rows.Scan(&token.identifier, &token.access, &token.refresh); err != nil {
+			return 0, err
+		}
+		if isPlaintextValue(token.access) || isPlaintextValue(token.refresh) {
+			plaintext = append(plaintext, token)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	for _, token := range plaintext {
+		decrypted := &oauth2.Token{
+			AccessToken:  token.access,
+			RefreshToken: token.refresh,
+		}
+		if err := d.cipher.DecryptToken(decrypted); err != nil {
+			return updated, err
+		}
+		encrypted, err := d.cipher.EncryptToken(decrypted)
+		if err != nil {
+			return updated, err
+		}
+		if err := d.RunTxn(func(tx *sql.Tx) error {
+			_, err := tx.Exec(d.Rebind(`UPDATE oauth
+			SET access_token = ?, refresh_token = ?
+			WHERE identifier = ? AND access_token = ? AND refresh_token = ?`),
+				encrypted.AccessToken, encrypted.RefreshToken, token.identifier, token.access, token.refresh)
+			return err
+		}); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
 func (d *OAuthDB) DeleteToken(identifier string) error {
 	err := d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`DELETE FROM oauth
-	WHERE identifier = ?`, identifier)
+		_, err := tx.Exec(d.Rebind(`DELETE FROM oauth
+	WHERE identifier = ?`), identifier)
 		return err
 	})
 	return err
@@ -0,0 +1,29 @@
+package base_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+func TestRebind(t *testing.T) {
+	testCases := []struct {
+		query    string
+		postgres string
+	}{
+		{query: "SELECT 1", postgres: "SELECT 1"},
+		{query: "SELECT a FROM t WHERE b = ? AND c = ?", postgres: "SELECT a FROM t WHERE b = $1 AND c = $2"},
+		{query: "INSERT INTO t VALUES (?, ?, ?)", postgres: "INSERT INTO t VALUES ($1, $2, $3)"},
+		{query: "SELECT a FROM t WHERE b = '?' AND c = ?", postgres: "SELECT a FROM t WHERE b = '?' AND c = $1"},
+		{query: "SELECT a FROM t WHERE b = 'what?' AND c = ?", postgres: "SELECT a FROM t WHERE b = 'what?' AND c = $1"},
+		{query: "SELECT a FROM t WHERE b = 'it''s ?' AND c = ?", postgres: "SELECT a FROM t WHERE b = 'it''s ?' AND c = $1"},
+		{query: "SELECT a FROM t WHERE b = '' AND c = ?", postgres: "SELECT a FROM t WHERE b = '' AND c = $1"},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.postgres, base.PostgresDialect.Rebind(tc.query), tc.query)
+		require.Equal(t, tc.query, base.MySQLDialect.Rebind(tc.query), tc.query)
+		require.Equal(t, tc.query, base.SQLiteDialect.Rebind(tc.query), tc.query)
+	}
+}
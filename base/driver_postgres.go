@@ -0,0 +1,8 @@
+//go:build postgres
+// +build postgres
+
+package base
+
+// Register the Postgres driver, build with `-tags postgres` to back a bot
+// with a postgres:// DSN.
+import _ "github.com/lib/pq"
@@ -0,0 +1,8 @@
+//go:build sqlite
+// +build sqlite
+
+package base
+
+// Register the SQLite driver, build with `-tags sqlite` to back a bot with a
+// sqlite:// DSN. This requires cgo.
+import _ "github.com/mattn/go-sqlite3"
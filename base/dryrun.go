@@ -0,0 +1,241 @@
+package base
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	dryRunKeybaseEnv = "BOT_DRY_RUN_KEYBASE"
+	dryRunURLEnv     = "BOT_DRY_RUN_URL"
+	// name of the link to the bot binary kbchat runs as the keybase CLI
+	dryRunKeybaseName = "keybase-dry-run"
+)
+
+// chat API methods which change what users see, they're recorded instead of
+// run in dry run mode
+var dryRunSuppressedMethods = map[string]bool{
+	"send":              true,
+	"edit":              true,
+	"reaction":          true,
+	"attach":            true,
+	"delete":            true,
+	"join":              true,
+	"leave":             true,
+	"advertisecommands": true,
+	"clearcommands":     true,
+}
+
+func init() {
+	// in dry run mode kbchat runs the bot binary itself as the keybase CLI,
+	// through a link only DryRunRecorder creates
+	if filepath.Base(os.Args[0]) == dryRunKeybaseName {
+		os.Exit(runDryRunKeybase(os.Getenv(dryRunKeybaseEnv), os.Getenv(dryRunURLEnv), os.Args[1:]))
+	}
+}
+
+type dryRunRequest struct {
+	Method string `json:"method"`
+	Params struct {
+		Options struct {
+			ConversationID string `json:"conversation_id"`
+			Channel        struct {
+				Name      string `json:"name"`
+				TopicName string `json:"topic_name"`
+			} `json:"channel"`
+			Message struct {
+				Body string `json:"body"`
+			} `json:"message"`
+			Filename string `json:"filename"`
+		} `json:"options"`
+	} `json:"params"`
+}
+
+// DryRunRecorder logs the chat sends, edits and reactions a bot would have
+// made instead of posting them, so a new deployment can run against
+// production traffic safely. Webhooks, commands and database writes are
+// processed as usual. The Server sets it up when started with --dry-run by
+// pointing kbchat at a link to the bot binary, which forwards reads to the
+// real keybase CLI and reports suppressed calls back to the recorder.
+type DryRunRecorder struct {
+	*DebugOutput
+
+	listener net.Listener
+	srv      *http.Server
+	linkDir  string
+}
+
+func NewDryRunRecorder() (*DryRunRecorder, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	r := &DryRunRecorder{
+		DebugOutput: NewDebugOutput("DryRun", nil),
+		listener:    listener,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/record", r.handleRecord)
+	r.srv = &http.Server{Handler: mux}
+	go func() {
+		if err := r.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			r.Debug("unable to serve: %v", err)
+		}
+	}()
+	return r, nil
+}
+
+// RunOptions returns opts running chat API calls through the recorder.
+func (r *DryRunRecorder) RunOptions(opts kbchat.RunOptions) (kbchat.RunOptions, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return opts, err
+	}
+	if r.linkDir, err = ioutil.TempDir("", "bot-dry-run"); err != nil {
+		return opts, err
+	}
+	link := filepath.Join(r.linkDir, dryRunKeybaseName)
+	if err := os.Symlink(exe, link); err != nil {
+		return opts, err
+	}
+	keybase := opts.KeybaseLocation
+	if keybase == "" {
+		keybase = "keybase"
+	}
+	if err := os.Setenv(dryRunKeybaseEnv, keybase); err != nil {
+		return opts, err
+	}
+	if err := os.Setenv(dryRunURLEnv, "http://"+r.listener.Addr().String()); err != nil {
+		return opts, err
+	}
+	opts.KeybaseLocation = link
+	return opts, nil
+}
+
+func (r *DryRunRecorder) handleRecord(w http.ResponseWriter, req *http.Request) {
+	var call dryRunRequest
+	if err := json.NewDecoder(req.Body).Decode(&call); err != nil {
+		r.Debug("handleRecord: invalid request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	options := call.Params.Options
+	target := options.ConversationID
+	if target == "" {
+		target = options.Channel.Name
+		if options.Channel.TopicName != "" {
+			target += "#" + options.Channel.TopicName
+		}
+	}
+	DefaultMetrics.CounterInc("keybase_bot_dry_run_suppressed_total", "Chat API calls suppressed in dry run mode.",
+		"method", call.Method)
+	switch {
+	case options.Message.Body != "":
+		r.Debug("would %s to %s: %s", call.Method, target, options.Message.Body)
+	case options.Filename != "":
+		r.Debug("would %s %s to %s", call.Method, options.Filename, target)
+	default:
+		r.Debug("would %s in %s", call.Method, target)
+	}
+}
+
+func (r *DryRunRecorder) Shutdown() error {
+	if r.linkDir != "" {
+		if err := os.RemoveAll(r.linkDir); err != nil {
+			r.Debug("Shutdown: unable to remove %s: %v", r.linkDir, err)
+		}
+	}
+	return r.srv.Close()
+}
+
+// runDryRunKeybase stands in for the keybase CLI at keybase, suppressing
+// chat API calls which post and reporting them to the recorder at url.
+func runDryRunKeybase(keybase, url string, args []string) int {
+	subcmd := args
+	if len(subcmd) >= 2 && subcmd[0] == "--home" {
+		subcmd = subcmd[2:]
+	}
+	cmd := exec.Command(keybase, args...)
+	cmd.Stderr = os.Stderr
+	if len(subcmd) != 2 || subcmd[0] != "chat" || subcmd[1] != "api" {
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		if err := cmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return exitErr.ExitCode()
+			}
+			fmt.Fprintf(os.Stderr, "dry run: unable to run %s: %v\n", keybase, err)
+			return 1
+		}
+		return 0
+	}
+
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return 1
+	}
+	output, err := cmd.StdoutPipe()
+	if err != nil {
+		return 1
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "dry run: unable to run %s: %v\n", keybase, err)
+		return 1
+	}
+	defer input.Close()
+	go func() {
+		// kbchat waits for the API process to exit on shutdown, so exit with
+		// keybase rather than waiting for stdin to close
+		_ = cmd.Wait()
+		os.Exit(cmd.ProcessState.ExitCode())
+	}()
+	reader := bufio.NewReader(output)
+	client := &http.Client{Timeout: 5 * time.Second}
+	// suppressed sends get a synthetic ID, callers may keep it to edit or
+	// react to the message later, which is suppressed too
+	var msgID chat1.MessageID
+	dec := json.NewDecoder(os.Stdin)
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return 0
+			}
+			fmt.Fprintf(os.Stderr, "dry run: invalid request: %v\n", err)
+			return 1
+		}
+		var call dryRunRequest
+		if err := json.Unmarshal(raw, &call); err == nil && dryRunSuppressedMethods[call.Method] {
+			if resp, err := client.Post(url+"/record", "application/json", bytes.NewReader(raw)); err == nil {
+				resp.Body.Close()
+			}
+			msgID++
+			res, _ := json.Marshal(map[string]chat1.SendRes{
+				"result": {Message: "dry run", MessageID: &msgID},
+			})
+			fmt.Println(string(res))
+			continue
+		}
+		if _, err := input.Write(raw); err != nil {
+			return 1
+		}
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return 1
+		}
+		os.Stdout.Write(line)
+	}
+}
@@ -0,0 +1,40 @@
+package base_test
+
+import (
+	"testing"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/bottest"
+)
+
+func TestDryRun(t *testing.T) {
+	fake := bottest.NewFakeChat("testbot")
+	recorder, err := base.NewDryRunRecorder()
+	require.NoError(t, err)
+	defer func() { _ = recorder.Shutdown() }()
+	opts, err := recorder.RunOptions(fake.RunOptions())
+	require.NoError(t, err)
+	kbc, err := kbchat.Start(opts)
+	require.NoError(t, err)
+	// the fake keybase behind the recorder only exits once the fake is closed
+	defer func() {
+		fake.Close()
+		_ = kbc.Shutdown()
+	}()
+
+	// reads go through to keybase
+	require.Equal(t, "testbot", kbc.GetUsername())
+
+	// sends are suppressed but still get an ID
+	first, err := kbc.SendMessageByConvID("deadbeef", "hello")
+	require.NoError(t, err)
+	require.NotNil(t, first.Result.MessageID)
+	second, err := kbc.SendMessageByConvID("deadbeef", "again")
+	require.NoError(t, err)
+	require.NotNil(t, second.Result.MessageID)
+	require.NotEqual(t, *first.Result.MessageID, *second.Result.MessageID)
+	require.Empty(t, fake.Sent())
+}
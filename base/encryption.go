@@ -0,0 +1,251 @@
+package base
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"golang.org/x/oauth2"
+)
+
+const encryptedValuePrefix = "enc:v1:"
+
+// KeyEncrypter wraps the per-value data keys used by FieldCipher with a
+// master key which never touches the database.
+type KeyEncrypter interface {
+	// KeyID identifies the master key new values are wrapped with
+	KeyID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// StaticKeyEncrypter wraps data keys with AES-256 master keys from config.
+// Older keys can be kept around for decryption while rotating.
+type StaticKeyEncrypter struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyEncrypter parses a comma separated list of `<id>:<base64 key>`
+// pairs, the first key is used for new values.
+func NewStaticKeyEncrypter(spec string) (*StaticKeyEncrypter, error) {
+	s := &StaticKeyEncrypter{keys: make(map[string][]byte)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid encryption key, expected <id>:<base64 key>")
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %s: %v", parts[0], err)
+		} else if len(key) != 32 {
+			return nil, fmt.Errorf("invalid encryption key %s: must be 32 bytes, got %d", parts[0], len(key))
+		}
+		if s.current == "" {
+			s.current = parts[0]
+		}
+		s.keys[parts[0]] = key
+	}
+	if s.current == "" {
+		return nil, fmt.Errorf("no encryption keys given")
+	}
+	return s, nil
+}
+
+func (s *StaticKeyEncrypter) KeyID() string {
+	return s.current
+}
+
+func (s *StaticKeyEncrypter) WrapKey(dataKey []byte) ([]byte, error) {
+	return sealAESGCM(s.keys[s.current], dataKey)
+}
+
+func (s *StaticKeyEncrypter) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	return openAESGCM(key, wrapped)
+}
+
+// KMSKeyEncrypter wraps data keys with an AWS KMS key.
+type KMSKeyEncrypter struct {
+	keyID string
+	svc   *kms.KMS
+}
+
+func NewKMSKeyEncrypter(region, keyID string) (*KMSKeyEncrypter, error) {
+	sess, err := GetSession(region)
+	if err != nil {
+		return nil, err
+	}
+	return &KMSKeyEncrypter{
+		keyID: keyID,
+		svc:   kms.New(sess),
+	}, nil
+}
+
+// KeyID is a constant, KMS embeds the key in the ciphertext it returns.
+func (k *KMSKeyEncrypter) KeyID() string {
+	return "kms"
+}
+
+func (k *KMSKeyEncrypter) WrapKey(dataKey []byte) ([]byte, error) {
+	out, err := k.svc.Encrypt(&kms.EncryptInput{
+		KeyId:     aws.String(k.keyID),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (k *KMSKeyEncrypter) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	out, err := k.svc.Decrypt(&kms.DecryptInput{
+		CiphertextBlob: wrapped,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// FieldCipher envelope encrypts sensitive column values, such as OAuth
+// tokens. Every value gets a fresh AES-256 data key which is stored wrapped
+// by the KeyEncrypter next to the ciphertext:
+//
+//	enc:v1:<key id>:<base64 wrapped data key>:<base64 nonce+ciphertext>
+//
+// Values without the prefix are returned as is when decrypting, so rows
+// written before encryption was enabled keep working. A nil FieldCipher
+// stores plaintext.
+type FieldCipher struct {
+	keys KeyEncrypter
+}
+
+func NewFieldCipher(keys KeyEncrypter) *FieldCipher {
+	return &FieldCipher{keys: keys}
+}
+
+func IsEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, encryptedValuePrefix)
+}
+
+// isPlaintextValue reports whether value still needs to be encrypted.
+func isPlaintextValue(value string) bool {
+	return value != "" && !IsEncryptedValue(value)
+}
+
+func (c *FieldCipher) Encrypt(plaintext string) (string, error) {
+	if c == nil || plaintext == "" {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	sealed, err := sealAESGCM(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := c.keys.WrapKey(dataKey)
+	if err != nil {
+		return "", fmt.Errorf("unable to wrap data key: %v", err)
+	}
+	return fmt.Sprintf("%s%s:%s:%s", encryptedValuePrefix, c.keys.KeyID(),
+		base64.RawStdEncoding.EncodeToString(wrapped), base64.RawStdEncoding.EncodeToString(sealed)), nil
+}
+
+func (c *FieldCipher) Decrypt(value string) (string, error) {
+	if !IsEncryptedValue(value) {
+		return value, nil
+	} else if c == nil {
+		return "", fmt.Errorf("found an encrypted value but no encryption key is configured")
+	}
+	parts := strings.Split(strings.TrimPrefix(value, encryptedValuePrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %v", err)
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %v", err)
+	}
+	dataKey, err := c.keys.UnwrapKey(parts[0], wrapped)
+	if err != nil {
+		return "", fmt.Errorf("unable to unwrap data key: %v", err)
+	}
+	plaintext, err := openAESGCM(dataKey, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// EncryptToken returns a copy of token with its access and refresh tokens
+// encrypted for storage.
+func (c *FieldCipher) EncryptToken(token *oauth2.Token) (*oauth2.Token, error) {
+	encrypted := *token
+	var err error
+	if encrypted.AccessToken, err = c.Encrypt(token.AccessToken); err != nil {
+		return nil, err
+	}
+	if encrypted.RefreshToken, err = c.Encrypt(token.RefreshToken); err != nil {
+		return nil, err
+	}
+	return &encrypted, nil
+}
+
+// DecryptToken decrypts a token read from storage in place.
+func (c *FieldCipher) DecryptToken(token *oauth2.Token) (err error) {
+	if token.AccessToken, err = c.Decrypt(token.AccessToken); err != nil {
+		return err
+	}
+	token.RefreshToken, err = c.Decrypt(token.RefreshToken)
+	return err
+}
@@ -0,0 +1,94 @@
+package base_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+func newTestCipher(t *testing.T, spec string) *base.FieldCipher {
+	keys, err := base.NewStaticKeyEncrypter(spec)
+	require.NoError(t, err)
+	return base.NewFieldCipher(keys)
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestFieldCipher(t *testing.T) {
+	cipher := newTestCipher(t, "old:"+testKey('a'))
+	for _, plaintext := range []string{"a", "ya29.access-token", strings.Repeat("long ", 100)} {
+		encrypted, err := cipher.Encrypt(plaintext)
+		require.NoError(t, err)
+		require.True(t, base.IsEncryptedValue(encrypted))
+		require.NotEqual(t, plaintext, encrypted)
+		decrypted, err := cipher.Decrypt(encrypted)
+		require.NoError(t, err)
+		require.Equal(t, plaintext, decrypted)
+	}
+
+	// empty and legacy plaintext values are passed through
+	encrypted, err := cipher.Encrypt("")
+	require.NoError(t, err)
+	require.Empty(t, encrypted)
+	decrypted, err := cipher.Decrypt("plaintext")
+	require.NoError(t, err)
+	require.Equal(t, "plaintext", decrypted)
+	var nilCipher *base.FieldCipher
+	encrypted, err = nilCipher.Encrypt("secret")
+	require.NoError(t, err)
+	require.Equal(t, "secret", encrypted)
+
+	value, err := cipher.Encrypt("secret")
+	require.NoError(t, err)
+	_, err = nilCipher.Decrypt(value)
+	require.Error(t, err)
+
+	// values of rotated keys stay readable while the key is listed
+	rotated := newTestCipher(t, "new:"+testKey('b')+",old:"+testKey('a'))
+	decrypted, err = rotated.Decrypt(value)
+	require.NoError(t, err)
+	require.Equal(t, "secret", decrypted)
+	_, err = newTestCipher(t, "new:"+testKey('b')).Decrypt(value)
+	require.Error(t, err)
+	// a different key under the same ID can't unwrap the data key
+	_, err = newTestCipher(t, "old:"+testKey('c')).Decrypt(value)
+	require.Error(t, err)
+
+	// changing any part of the value is detected
+	prefix := value[:strings.Index(value, "old:")]
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	require.Len(t, parts, 3)
+	for _, index := range []int{1, 2} {
+		raw, err := base64.RawStdEncoding.DecodeString(parts[index])
+		require.NoError(t, err)
+		raw[len(raw)/2] ^= 1
+		tampered := append([]string(nil), parts...)
+		tampered[index] = base64.RawStdEncoding.EncodeToString(raw)
+		_, err = cipher.Decrypt(prefix + strings.Join(tampered, ":"))
+		require.Error(t, err, "tampered part %d", index)
+	}
+	_, err = cipher.Decrypt(prefix + "old:abc")
+	require.Error(t, err)
+}
+
+func TestNewStaticKeyEncrypter(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"nokey",
+		":" + testKey('a'),
+		"short:" + base64.StdEncoding.EncodeToString([]byte("too short")),
+		"bad:not base64!",
+	} {
+		_, err := base.NewStaticKeyEncrypter(spec)
+		require.Error(t, err, spec)
+	}
+	keys, err := base.NewStaticKeyEncrypter(" new:" + testKey('b') + ", old:" + testKey('a'))
+	require.NoError(t, err)
+	require.Equal(t, "new", keys.KeyID())
+}
@@ -1,6 +1,7 @@
 package base
 
 import (
+	"fmt"
 	"time"
 
 	"runtime/debug"
@@ -10,14 +11,46 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+// reportPanic logs a recovered panic with its stack, counts it and forwards
+// it to Sentry if configured. where identifies the recovery point, e.g. the
+// command or HTTP route.
+func reportPanic(debugOutput *DebugOutput, where string, r interface{}) {
+	stack := debug.Stack()
+	DefaultMetrics.CounterInc("keybase_bot_panics_total", "Recovered panics.", "where", where)
+	debugOutput.Errorf("panic in %s: %v stack trace: %s", where, r, stack)
+	if sentry := getSentryReporter(); sentry != nil {
+		tags := map[string]string{"where": where, "component": debugOutput.name}
+		if traceID := debugOutput.TraceID(); traceID != "" {
+			tags["trace_id"] = traceID
+		}
+		if err := sentry.Report(fmt.Sprintf("panic: %v", r), tags, map[string]interface{}{
+			"stack": string(stack),
+		}); err != nil {
+			debugOutput.Debug("reportPanic: unable to report to sentry: %v", err)
+		}
+	}
+}
+
 func PanicRecover(debugOutput *DebugOutput) {
 	if r := recover(); r != nil {
-		debugOutput.Errorf("panic: %v stack trace: %s", r, debug.Stack())
+		reportPanic(debugOutput, "goroutine", r)
 		time.Sleep(2 * time.Second) // sleep so that we can get this message to logging infrastructure
 		os.Exit(1)
 	}
 }
 
+// RecoverToError runs f, converting a panic into an error after reporting it
+// so a single bad message or request doesn't take the bot down.
+func RecoverToError(debugOutput *DebugOutput, where string, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(debugOutput, where, r)
+			err = fmt.Errorf("panic in %s: %v", where, r)
+		}
+	}()
+	return f()
+}
+
 func GoWithRecoverErrGroup(eg *errgroup.Group, debugOutput *DebugOutput, f func() error) {
 	eg.Go(func() error {
 		defer PanicRecover(debugOutput)
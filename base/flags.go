@@ -0,0 +1,282 @@
+package base
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const featureFlagCacheTTL = 30 * time.Second
+
+// Feature flag scopes, a user override wins over a conversation override
+// which wins over the global value.
+const (
+	GlobalFlagScope = "global"
+	convFlagPrefix  = "conv:"
+	userFlagPrefix  = "user:"
+)
+
+func ConvFlagScope(convID chat1.ConvIDStr) string {
+	return convFlagPrefix + string(convID)
+}
+
+func UserFlagScope(username string) string {
+	return userFlagPrefix + username
+}
+
+type featureFlag struct {
+	def         bool
+	description string
+}
+
+// FeatureFlags gates new behavior behind flags which admins can toggle at
+// runtime for a single user, a conversation or everyone. Overrides live in
+// the feature_flags table (see flags.sql) and are cached for
+// featureFlagCacheTTL so checks are cheap enough for every message.
+type FeatureFlags struct {
+	*DebugOutput
+	sync.Mutex
+
+	db    *DB
+	flags map[string]featureFlag
+
+	overrides map[string]map[string]bool
+	loadedAt  time.Time
+}
+
+func NewFeatureFlags(debugConfig *ChatDebugOutputConfig, db *DB) *FeatureFlags {
+	return &FeatureFlags{
+		DebugOutput: NewDebugOutput("FeatureFlags", debugConfig),
+		db:          db,
+		flags:       make(map[string]featureFlag),
+	}
+}
+
+// Register declares a flag and the value used when no override is set.
+func (f *FeatureFlags) Register(name string, def bool, description string) {
+	f.Lock()
+	defer f.Unlock()
+	f.flags[name] = featureFlag{def: def, description: description}
+}
+
+func (f *FeatureFlags) loadLocked() error {
+	if f.overrides != nil && time.Since(f.loadedAt) < featureFlagCacheTTL {
+		return nil
+	}
+	rows, err := f.db.Query(`
+		SELECT name, scope, enabled
+		FROM feature_flags
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	overrides := make(map[string]map[string]bool)
+	for rows.Next() {
+		var name, scope string
+		var enabled bool
+		if err := rows.Scan(&name, &scope, &enabled); err != nil {
+			return err
+		}
+		if overrides[name] == nil {
+			overrides[name] = make(map[string]bool)
+		}
+		overrides[name][scope] = enabled
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	f.overrides = overrides
+	f.loadedAt = time.Now()
+	return nil
+}
+
+// Enabled reports whether name is on for username in convID, either may be
+// empty. If the overrides can't be loaded the last cached values, or the
+// registered default, are used.
+func (f *FeatureFlags) Enabled(name string, convID chat1.ConvIDStr, username string) bool {
+	f.Lock()
+	defer f.Unlock()
+	if err := f.loadLocked(); err != nil {
+		f.Debug("Enabled: unable to load flags: %v", err)
+	}
+	overrides := f.overrides[name]
+	var scopes []string
+	if username != "" {
+		scopes = append(scopes, UserFlagScope(username))
+	}
+	if convID != "" {
+		scopes = append(scopes, ConvFlagScope(convID))
+	}
+	scopes = append(scopes, GlobalFlagScope)
+	for _, scope := range scopes {
+		if enabled, ok := overrides[scope]; ok {
+			return enabled
+		}
+	}
+	return f.flags[name].def
+}
+
+func (f *FeatureFlags) Set(name, scope string, enabled bool) error {
+	if _, err := f.db.Exec(f.db.Dialect.Upsert("feature_flags",
+		[]string{"name", "scope", "enabled", "mtime"},
+		[]string{"name", "scope"},
+		[]string{"enabled", "mtime"}),
+		name, scope, enabled, time.Now().UTC()); err != nil {
+		return err
+	}
+	f.invalidate()
+	return nil
+}
+
+// Clear removes an override so the next broader scope applies.
+func (f *FeatureFlags) Clear(name, scope string) error {
+	if _, err := f.db.Exec(`
+		DELETE FROM feature_flags
+		WHERE name = ? AND scope = ?
+	`, name, scope); err != nil {
+		return err
+	}
+	f.invalidate()
+	return nil
+}
+
+func (f *FeatureFlags) invalidate() {
+	f.Lock()
+	defer f.Unlock()
+	f.overrides = nil
+}
+
+// AdminCommands lets bot admins list and toggle flags from chat.
+func (f *FeatureFlags) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "flags",
+			Usage:       "[on|off|clear <flag> [global|conv|@user]]",
+			Description: "List feature flags or override one, for this conversation by default",
+			Handler:     f.handleAdminFlags,
+		},
+	}
+}
+
+func (f *FeatureFlags) handleAdminFlags(msg chat1.MsgSummary, args []string) error {
+	if len(args) == 0 {
+		return f.listFlags(msg)
+	}
+	if len(args) < 2 || len(args) > 3 {
+		f.ChatEcho(msg.ConvID, "Usage: `flags [on|off|clear <flag> [global|conv|@user]]`")
+		return nil
+	}
+	action, name := args[0], args[1]
+	f.Lock()
+	_, ok := f.flags[name]
+	f.Unlock()
+	if !ok {
+		f.ChatEcho(msg.ConvID, "Unknown flag %q", name)
+		return nil
+	}
+	scope := ConvFlagScope(msg.ConvID)
+	if len(args) == 3 {
+		switch target := args[2]; {
+		case target == GlobalFlagScope:
+			scope = GlobalFlagScope
+		case target == "conv":
+		case strings.HasPrefix(target, "@") && len(target) > 1:
+			scope = UserFlagScope(strings.TrimPrefix(target, "@"))
+		default:
+			f.ChatEcho(msg.ConvID, "Invalid scope %q, expected `global`, `conv` or `@user`", target)
+			return nil
+		}
+	}
+	var err error
+	switch action {
+	case "on":
+		err = f.Set(name, scope, true)
+	case "off":
+		err = f.Set(name, scope, false)
+	case "clear":
+		err = f.Clear(name, scope)
+	default:
+		f.ChatEcho(msg.ConvID, "Unknown action %q, expected `on`, `off` or `clear`", action)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.ChatEcho(msg.ConvID, "OK! `%s` %s for `%s`", name, action, scope)
+	return nil
+}
+
+func (f *FeatureFlags) listFlags(msg chat1.MsgSummary) error {
+	f.Lock()
+	if err := f.loadLocked(); err != nil {
+		f.Unlock()
+		return err
+	}
+	names := make([]string, 0, len(f.flags))
+	for name := range f.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		flag := f.flags[name]
+		line := fmt.Sprintf("%s (default %v): %s", name, flag.def, flag.description)
+		scopes := make([]string, 0, len(f.overrides[name]))
+		for scope, enabled := range f.overrides[name] {
+			scopes = append(scopes, fmt.Sprintf("%s=%v", scope, enabled))
+		}
+		sort.Strings(scopes)
+		if len(scopes) > 0 {
+			line += "\n  " + strings.Join(scopes, ", ")
+		}
+		lines = append(lines, line)
+	}
+	f.Unlock()
+	if len(lines) == 0 {
+		f.ChatEcho(msg.ConvID, "No feature flags registered")
+		return nil
+	}
+	f.ChatEcho(msg.ConvID, "```%s```", strings.Join(lines, "\n"))
+	return nil
+}
+
+// FeatureFlagMigrations creates the feature_flags table used by FeatureFlags.
+var FeatureFlagMigrations = []Migration{
+	{
+		ID: "base-feature-flags-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS feature_flags (
+					name varchar(128) NOT NULL,
+					scope varchar(192) NOT NULL,
+					enabled boolean NOT NULL,
+					mtime datetime(6) NOT NULL,
+					PRIMARY KEY (name, scope)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS feature_flags (
+					name varchar(128) NOT NULL,
+					scope varchar(192) NOT NULL,
+					enabled boolean NOT NULL,
+					mtime timestamp with time zone NOT NULL,
+					PRIMARY KEY (name, scope)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS feature_flags (
+					name text NOT NULL,
+					scope text NOT NULL,
+					enabled boolean NOT NULL,
+					mtime datetime NOT NULL,
+					PRIMARY KEY (name, scope)
+				)`,
+			},
+		},
+	},
+}
@@ -11,14 +11,13 @@ Currently supports:
 - GitHub
 - GitLab
 
- */
+*/
 
 const (
 	GITHUB = iota
 	GITLAB
 )
 
-
 func RefToName(ref string) (branch string) {
 	// refs are always given in the form "refs/heads/{branch name}" or "refs/tags/{tag name}"
 	branch = strings.Split(ref, "refs/")[1]
@@ -29,14 +28,13 @@ func RefToName(ref string) (branch string) {
 	return branch
 }
 
-
 /*
 Push Events
 
 GitHub: https://developer.github.com/v3/activity/events/types/#pushevent
 GitLab: https://docs.gitlab.com/ee/user/project/integrations/webhooks.html#push-events
 
- */
+*/
 
 func FormatPushMsg(username string, repo string, branch string, numCommits int, messages []string, commitURL string) (res string) {
 	res = fmt.Sprintf("%s pushed %d commit", username, numCommits)
@@ -65,6 +63,20 @@ func formatCommitString(commit string, maxLen int) string {
 	return firstLine
 }
 
+// FormatExcerpt quotes the start of a comment or description for chat,
+// collapsing whitespace and cutting it at maxLen characters.
+func FormatExcerpt(text string, maxLen int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) == 0 {
+		return ""
+	}
+	excerpt := string(runes)
+	if len(runes) > maxLen {
+		excerpt = strings.TrimSpace(string(runes[:maxLen])) + "..."
+	}
+	return "> " + excerpt
+}
+
 /*
 Issue Events
 
@@ -74,7 +86,7 @@ Namespace: "opened", "reopened", "closed"
 GitLab: https://docs.gitlab.com/ee/user/project/integrations/webhooks.html#issues-events
 Namespace: "open", "reopen", "close"
 
- */
+*/
 
 func FormatIssueMsg(action string, username string, repo string, repoNum int, issueTitle string, issueURL string) (res string) {
 	switch action {
@@ -103,7 +115,7 @@ as an action in GitHub.
 GitLab: https://docs.gitlab.com/ee/user/project/integrations/webhooks.html#merge-request-events
 Namespace: "open", "reopen", "close", "merge"
 
- */
+*/
 
 func FormatPullRequestMsg(provider int, action string, username string, repo string, repoNum int, issueTitle string, prURL string, targetBranch string) (res string) {
 	var requestName string
@@ -0,0 +1,146 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+)
+
+const healthCheckTimeout = 5 * time.Second
+
+// HealthCheck returns an error if the dependency it checks is unavailable.
+type HealthCheck func() error
+
+// healthChecks backs the `/healthz` and `/readyz` endpoints. Liveness checks
+// should only fail if restarting the bot would help, readiness checks cover
+// dependencies like the database or upstream APIs.
+type healthChecks struct {
+	sync.Mutex
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+}
+
+var defaultHealthChecks = &healthChecks{
+	liveness:  make(map[string]HealthCheck),
+	readiness: make(map[string]HealthCheck),
+}
+
+func (c *healthChecks) addLiveness(name string, check HealthCheck) {
+	c.Lock()
+	defer c.Unlock()
+	c.liveness[name] = check
+}
+
+func (c *healthChecks) addReadiness(name string, check HealthCheck) {
+	c.Lock()
+	defer c.Unlock()
+	c.readiness[name] = check
+}
+
+func runHealthCheck(check HealthCheck) error {
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errCh <- check()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(healthCheckTimeout):
+		return fmt.Errorf("timed out after %v", healthCheckTimeout)
+	}
+}
+
+// run executes checks concurrently and returns the status of each by name.
+func (c *healthChecks) run(includeReadiness bool) (results map[string]string, ok bool) {
+	c.Lock()
+	checks := make(map[string]HealthCheck, len(c.liveness)+len(c.readiness))
+	for name, check := range c.liveness {
+		checks[name] = check
+	}
+	if includeReadiness {
+		for name, check := range c.readiness {
+			checks[name] = check
+		}
+	}
+	c.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok = true
+	results = make(map[string]string, len(checks))
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			status := "ok"
+			if err := runHealthCheck(check); err != nil {
+				status = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if status != "ok" {
+				ok = false
+			}
+			results[name] = status
+		}(name, check)
+	}
+	wg.Wait()
+	return results, ok
+}
+
+func (c *healthChecks) handler(includeReadiness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results, ok := c.run(includeReadiness)
+		names := make([]string, 0, len(results))
+		for name := range results {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(struct {
+			OK     bool              `json:"ok"`
+			Checks map[string]string `json:"checks"`
+		}{OK: ok, Checks: results})
+	}
+}
+
+// ChatAPIHealthCheck verifies the Keybase chat API is still responding.
+func ChatAPIHealthCheck(kbc *kbchat.API) HealthCheck {
+	return func() error {
+		if kbc == nil {
+			return fmt.Errorf("chat API not started")
+		}
+		_, err := kbc.GetConversations(true)
+		return err
+	}
+}
+
+// HTTPHealthCheck verifies an upstream API is reachable, any response below
+// 500 is considered healthy.
+func HTTPHealthCheck(url string) HealthCheck {
+	client := &http.Client{Timeout: healthCheckTimeout}
+	return func() error {
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
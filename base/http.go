@@ -3,28 +3,146 @@ package base
 import (
 	"context"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// DefaultMetricsAddr is where `/metrics` is served unless configured with
+// SetMetricsAddr, apart from the public webhook listener.
+const DefaultMetricsAddr = "localhost:9090"
+
+var (
+	registerBaseHandlersOnce sync.Once
+
+	metricsAddrMu sync.Mutex
+	metricsAddr   = DefaultMetricsAddr
+)
+
+// SetMetricsAddr changes the address of the `/metrics` listener of servers
+// created afterwards, an empty address disables it. Server.Configure applies
+// the command line flag.
+func SetMetricsAddr(addr string) {
+	metricsAddrMu.Lock()
+	defer metricsAddrMu.Unlock()
+	metricsAddr = addr
+}
+
+func getMetricsAddr() string {
+	metricsAddrMu.Lock()
+	defer metricsAddrMu.Unlock()
+	return metricsAddr
+}
+
 type HTTPSrv struct {
 	*DebugOutput
-	srv   *http.Server
-	Stats *StatsRegistry
+	srv        *http.Server
+	metricsSrv *http.Server
+	Stats      *StatsRegistry
 }
 
 func NewHTTPSrv(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig) *HTTPSrv {
-	return &HTTPSrv{
-		DebugOutput: NewDebugOutput("HTTPSrv", debugConfig),
+	registerBaseHandlersOnce.Do(func() {
+		http.HandleFunc("/healthz", defaultHealthChecks.handler(false))
+		http.HandleFunc("/readyz", defaultHealthChecks.handler(true))
+	})
+	if debugConfig != nil && debugConfig.KBC != nil {
+		defaultHealthChecks.addLiveness("chat", ChatAPIHealthCheck(debugConfig.KBC))
+	}
+	debugOutput := NewDebugOutput("HTTPSrv", debugConfig)
+	h := &HTTPSrv{
+		DebugOutput: debugOutput,
 		Stats:       stats.SetPrefix("HTTPSrv"),
-		srv:         &http.Server{Addr: ":8080"},
+		srv:         &http.Server{Addr: ":8080", Handler: instrumentHandler(debugOutput, http.DefaultServeMux)},
 	}
+	// metrics aren't authenticated, so they're kept off the public listener
+	if addr := getMetricsAddr(); addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", DefaultMetrics)
+		h.metricsSrv = &http.Server{Addr: addr, Handler: mux}
+	}
+	return h
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// instrumentHandler records request counts and latencies for every route
+// registered on mux, labeled by the matched pattern rather than the raw path.
+// Each request is also given a trace ID on its context, see WithContext, and
+// panicking handlers are reported and answered with a 500.
+func instrumentHandler(debugOutput *DebugOutput, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceID := requestTraceID(r.Header.Get(RequestIDHeader))
+		w.Header().Set(RequestIDHeader, traceID)
+		r = r.WithContext(WithTraceID(r.Context(), traceID))
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					if p == http.ErrAbortHandler {
+						panic(p)
+					}
+					reportPanic(debugOutput.WithTraceID(traceID), "http "+pattern, p)
+					rec.WriteHeader(http.StatusInternalServerError)
+				}
+			}()
+			mux.ServeHTTP(rec, r)
+		}()
+		DefaultMetrics.CounterInc("keybase_bot_http_requests_total", "HTTP requests, including webhook deliveries.",
+			"path", pattern, "code", strconv.Itoa(rec.status))
+		DefaultMetrics.ObserveSince("keybase_bot_http_request_duration_seconds", "HTTP request latencies.",
+			start, "path", pattern)
+	})
+}
+
+// AddLivenessCheck registers a check reported by `/healthz` and `/readyz`.
+func (h *HTTPSrv) AddLivenessCheck(name string, check HealthCheck) {
+	defaultHealthChecks.addLiveness(name, check)
+}
+
+// AddReadinessCheck registers a check only reported by `/readyz`, such as
+// database connectivity or upstream API reachability.
+func (h *HTTPSrv) AddReadinessCheck(name string, check HealthCheck) {
+	defaultHealthChecks.addReadiness(name, check)
 }
 
 func (h *HTTPSrv) Listen() (err error) {
 	defer h.Trace(&err, "ListenAndServe")()
+	if h.metricsSrv != nil {
+		go func() {
+			if err := h.metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				h.Errorf("Listen: unable to serve metrics on %s: %v", h.metricsSrv.Addr, err)
+			}
+		}()
+	}
 	return h.srv.ListenAndServe()
 }
 
 func (h *HTTPSrv) Shutdown() (err error) {
+	return h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext stops accepting connections and waits for in-flight
+// requests, like webhook deliveries, to finish until ctx is done.
+func (h *HTTPSrv) ShutdownContext(ctx context.Context) (err error) {
 	defer h.Trace(&err, "Shutdown")()
-	return h.srv.Shutdown(context.Background())
+	if h.metricsSrv != nil {
+		if err := h.metricsSrv.Shutdown(ctx); err != nil {
+			h.Debug("Shutdown: unable to shutdown metrics listener: %v", err)
+		}
+	}
+	return h.srv.Shutdown(ctx)
 }
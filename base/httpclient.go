@@ -0,0 +1,222 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	DefaultHTTPTimeout    = 60 * time.Second
+	DefaultHTTPMaxRetries = 3
+	httpDialTimeout       = 10 * time.Second
+	httpTLSTimeout        = 10 * time.Second
+	httpHeaderTimeout     = 30 * time.Second
+	httpIdleConnTimeout   = 90 * time.Second
+	httpRetryWaitMin      = 500 * time.Millisecond
+	httpRetryWaitMax      = 10 * time.Second
+)
+
+// HTTPClientOptions configures the clients bots use to call upstream APIs,
+// zero values keep the defaults.
+type HTTPClientOptions struct {
+	// Timeout bounds a whole request including retries, DefaultHTTPTimeout
+	// by default.
+	Timeout time.Duration
+	// MaxRetries is how many times idempotent requests are retried on network
+	// errors, 429s and 5xxs, DefaultHTTPMaxRetries by default. Negative
+	// disables retries.
+	MaxRetries int
+	// ProxyURL is the HTTP(S) proxy to send requests through, the
+	// HTTPS_PROXY/HTTP_PROXY environment variables are used if it's empty.
+	ProxyURL string
+	// Transport replaces the network transport under the retry policy, e.g.
+	// with bottest.Upstream.Transport in tests.
+	Transport http.RoundTripper
+}
+
+var (
+	httpClientOptsMu sync.Mutex
+	httpClientOpts   HTTPClientOptions
+)
+
+// SetHTTPClientOptions changes the options of clients created afterwards,
+// Server.Configure applies the command line flags.
+func SetHTTPClientOptions(opts HTTPClientOptions) error {
+	if opts.ProxyURL != "" {
+		if _, err := url.Parse(opts.ProxyURL); err != nil {
+			return fmt.Errorf("invalid proxy URL: %v", err)
+		}
+	}
+	httpClientOptsMu.Lock()
+	defer httpClientOptsMu.Unlock()
+	httpClientOpts = opts
+	return nil
+}
+
+func getHTTPClientOptions() HTTPClientOptions {
+	httpClientOptsMu.Lock()
+	defer httpClientOptsMu.Unlock()
+	opts := httpClientOpts
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultHTTPTimeout
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultHTTPMaxRetries
+	} else if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	return opts
+}
+
+// NewHTTPTransport returns a transport with the shared timeouts, proxy and
+// retry policy which records per host metrics. Auth transports, such as
+// ghinstallation's, should be layered over it.
+func NewHTTPTransport() http.RoundTripper {
+	opts := getHTTPClientOptions()
+	next := opts.Transport
+	if next == nil {
+		proxy := http.ProxyFromEnvironment
+		if opts.ProxyURL != "" {
+			// validated by SetHTTPClientOptions
+			proxyURL, _ := url.Parse(opts.ProxyURL)
+			proxy = http.ProxyURL(proxyURL)
+		}
+		next = &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   httpDialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   httpTLSTimeout,
+			ResponseHeaderTimeout: httpHeaderTimeout,
+			IdleConnTimeout:       httpIdleConnTimeout,
+			ExpectContinueTimeout: time.Second,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+		}
+	}
+	return &retryTransport{
+		next:       next,
+		maxRetries: opts.MaxRetries,
+	}
+}
+
+// NewHTTPClient returns a client using NewHTTPTransport.
+func NewHTTPClient() *http.Client {
+	return NewHTTPClientWithTransport(NewHTTPTransport())
+}
+
+// NewHTTPClientWithTransport returns a client with the shared timeout for a
+// transport built on NewHTTPTransport.
+func NewHTTPClientWithTransport(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   getHTTPClientOptions().Timeout,
+	}
+}
+
+// HTTPClientContext returns ctx carrying NewHTTPClient for the oauth2
+// package, which uses it for token exchanges and refreshes as well as the
+// clients it returns.
+func HTTPClientContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, NewHTTPClient())
+}
+
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+}
+
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		// the body has to be replayable for a retry
+		return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	}
+	return false
+}
+
+func shouldRetryHTTP(resp *http.Response, err error) bool {
+	if err != nil {
+		// the caller gave up, not the network
+		return err != context.Canceled && err != context.DeadlineExceeded
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryWait is exponential backoff with jitter, or what the server asked
+// for in Retry-After.
+func retryWait(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if wait := time.Duration(seconds) * time.Second; wait < httpRetryWaitMax {
+				return wait
+			}
+			return httpRetryWaitMax
+		}
+	}
+	wait := httpRetryWaitMin << uint(attempt)
+	if wait > httpRetryWaitMax || wait <= 0 {
+		wait = httpRetryWaitMax
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	host := req.URL.Hostname()
+	retries := 0
+	if isIdempotentRequest(req) {
+		retries = t.maxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+		start := time.Now()
+		resp, err = t.next.RoundTrip(req)
+		code := "error"
+		if err == nil {
+			code = strconv.Itoa(resp.StatusCode)
+		}
+		DefaultMetrics.CounterInc("keybase_bot_http_client_requests_total", "Upstream API requests.",
+			"host", host, "method", req.Method, "code", code)
+		DefaultMetrics.ObserveSince("keybase_bot_http_client_request_duration_seconds", "Upstream API latencies.",
+			start, "host", host)
+		if attempt >= retries || !shouldRetryHTTP(resp, err) {
+			return resp, err
+		}
+		wait := retryWait(attempt, resp)
+		if resp != nil {
+			// drain so the connection can be reused
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		DefaultMetrics.CounterInc("keybase_bot_http_client_retries_total", "Upstream API requests retried.",
+			"host", host)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+// Identity providers shared across bots.
+const (
+	GitHubIdentity = "github"
+	GitLabIdentity = "gitlab"
+	GoogleIdentity = "google"
+	JiraIdentity   = "jira"
+)
+
+// Identity is a Keybase user's account on an external service.
+type Identity struct {
+	KeybaseUsername string
+	Provider        string
+	ExternalID      string
+	// Verified is set when the bot confirmed the account, e.g. through OAuth,
+	// rather than taking the user's word for it.
+	Verified bool
+}
+
+type identityProvider struct {
+	description string
+	// normalize canonicalizes external IDs, e.g. case insensitive logins
+	normalize func(string) string
+}
+
+// IdentityConflictError is returned when linking an external account which is
+// already linked to another Keybase user.
+type IdentityConflictError struct {
+	Provider   string
+	ExternalID string
+}
+
+func (e IdentityConflictError) Error() string {
+	return fmt.Sprintf("%s account %s is already linked to another Keybase user", e.Provider, e.ExternalID)
+}
+
+// IdentityStore maps Keybase users to their accounts on external services,
+// such as GitHub logins, Google emails or Jira accountIds, so bots can
+// mention the right person or assign work to them. Each user has at most
+// one account per provider and each account belongs to one user. Links live
+// in the identities table, see identities.sql.
+type IdentityStore struct {
+	*DebugOutput
+	sync.Mutex
+
+	db        *DB
+	providers map[string]identityProvider
+}
+
+func NewIdentityStore(debugConfig *ChatDebugOutputConfig, db *DB) *IdentityStore {
+	s := &IdentityStore{
+		DebugOutput: NewDebugOutput("IdentityStore", debugConfig),
+		db:          db,
+		providers:   make(map[string]identityProvider),
+	}
+	s.RegisterProvider(GitHubIdentity, "GitHub login", strings.ToLower)
+	s.RegisterProvider(GitLabIdentity, "GitLab username", strings.ToLower)
+	s.RegisterProvider(GoogleIdentity, "Google account email", strings.ToLower)
+	s.RegisterProvider(JiraIdentity, "Jira accountId", nil)
+	return s
+}
+
+// RegisterProvider allows users to link accounts of another service. If
+// normalize is non-nil external IDs are passed through it before they're
+// stored or looked up.
+func (s *IdentityStore) RegisterProvider(name, description string, normalize func(string) string) {
+	s.Lock()
+	defer s.Unlock()
+	s.providers[name] = identityProvider{description: description, normalize: normalize}
+}
+
+func (s *IdentityStore) normalize(provider, externalID string) (string, error) {
+	s.Lock()
+	p, ok := s.providers[provider]
+	s.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown identity provider %q", provider)
+	}
+	externalID = strings.TrimSpace(externalID)
+	if p.normalize != nil {
+		externalID = p.normalize(externalID)
+	}
+	return externalID, nil
+}
+
+// Link sets username's account for provider, replacing any previous link.
+func (s *IdentityStore) Link(username, provider, externalID string, verified bool) error {
+	externalID, err := s.normalize(provider, externalID)
+	if err != nil {
+		return err
+	} else if externalID == "" {
+		return fmt.Errorf("empty %s account", provider)
+	}
+	return s.db.RunTxn(func(tx *sql.Tx) error {
+		var owner string
+		row := tx.QueryRow(s.db.Rebind(`
+			SELECT keybase_username
+			FROM identities
+			WHERE provider = ? AND external_id = ?
+		`), provider, externalID)
+		switch err := row.Scan(&owner); err {
+		case nil:
+			if owner != username {
+				return IdentityConflictError{Provider: provider, ExternalID: externalID}
+			}
+		case sql.ErrNoRows:
+		default:
+			return err
+		}
+		_, err := tx.Exec(s.db.Rebind(s.db.Dialect.Upsert("identities",
+			[]string{"keybase_username", "provider", "external_id", "verified", "mtime"},
+			[]string{"keybase_username", "provider"},
+			[]string{"external_id", "verified", "mtime"})),
+			username, provider, externalID, verified, time.Now().UTC())
+		return err
+	})
+}
+
+func (s *IdentityStore) Unlink(username, provider string) error {
+	_, err := s.db.Exec(`
+		DELETE FROM identities
+		WHERE keybase_username = ? AND provider = ?
+	`, username, provider)
+	return err
+}
+
+// UnlinkAll removes every link of username, e.g. when they disconnect from
+// the bot entirely.
+func (s *IdentityStore) UnlinkAll(username string) error {
+	_, err := s.db.Exec(`
+		DELETE FROM identities
+		WHERE keybase_username = ?
+	`, username)
+	return err
+}
+
+// ExternalID returns username's account for provider, or nil if they haven't
+// linked one.
+func (s *IdentityStore) ExternalID(username, provider string) (*Identity, error) {
+	identity := Identity{KeybaseUsername: username, Provider: provider}
+	row := s.db.QueryRow(`
+		SELECT external_id, verified
+		FROM identities
+		WHERE keybase_username = ? AND provider = ?
+	`, username, provider)
+	switch err := row.Scan(&identity.ExternalID, &identity.Verified); err {
+	case nil:
+		return &identity, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// KeybaseUser returns who linked externalID for provider, or nil if nobody
+// has.
+func (s *IdentityStore) KeybaseUser(provider, externalID string) (*Identity, error) {
+	externalID, err := s.normalize(provider, externalID)
+	if err != nil {
+		return nil, err
+	}
+	identity := Identity{Provider: provider, ExternalID: externalID}
+	row := s.db.QueryRow(`
+		SELECT keybase_username, verified
+		FROM identities
+		WHERE provider = ? AND external_id = ?
+	`, provider, externalID)
+	switch err := row.Scan(&identity.KeybaseUsername, &identity.Verified); err {
+	case nil:
+		return &identity, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (s *IdentityStore) Identities(username string) (identities []Identity, err error) {
+	rows, err := s.db.Query(`
+		SELECT provider, external_id, verified
+		FROM identities
+		WHERE keybase_username = ?
+		ORDER BY provider
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		identity := Identity{KeybaseUsername: username}
+		if err := rows.Scan(&identity.Provider, &identity.ExternalID, &identity.Verified); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// Commands returns `link`, `unlink` and `links` for a bot's CommandRouter so
+// users can manage their own links. Links made this way are unverified, bots
+// which can confirm an account should call Link themselves instead.
+func (s *IdentityStore) Commands() []Command {
+	return []Command{
+		{
+			Name:        "link",
+			Usage:       "<service> <account>",
+			Description: fmt.Sprintf("Link your account on another service (%s)", strings.Join(s.providerNames(), ", ")),
+			MinArgs:     2,
+			MaxArgs:     2,
+			Handler: func(msg chat1.MsgSummary, args *CommandArgs) error {
+				return s.HandleLink(msg, args.Positional[0], args.Positional[1], false)
+			},
+		},
+		{
+			Name:        "unlink",
+			Usage:       "<service>",
+			Description: "Unlink your account on another service",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *CommandArgs) error {
+				return s.HandleUnlink(msg, args.Positional[0])
+			},
+		},
+		{
+			Name:        "links",
+			Description: "List your linked accounts",
+			Handler: func(msg chat1.MsgSummary, args *CommandArgs) error {
+				return s.HandleLinks(msg)
+			},
+		},
+	}
+}
+
+func (s *IdentityStore) providerNames() (names []string) {
+	s.Lock()
+	defer s.Unlock()
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *IdentityStore) providerHelp() string {
+	names := s.providerNames()
+	s.Lock()
+	defer s.Unlock()
+	lines := make([]string, len(names))
+	for index, name := range names {
+		lines[index] = fmt.Sprintf("• `%s`: %s", name, s.providers[name].description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HandleLink links the sender's account and replies with the outcome.
+func (s *IdentityStore) HandleLink(msg chat1.MsgSummary, provider, externalID string, verified bool) error {
+	provider = strings.ToLower(provider)
+	if _, err := s.normalize(provider, externalID); err != nil {
+		s.ChatEcho(msg.ConvID, "I don't know %q, try one of:\n%s", provider, s.providerHelp())
+		return nil
+	}
+	switch err := s.Link(msg.Sender.Username, provider, externalID, verified).(type) {
+	case nil:
+	case IdentityConflictError:
+		s.ChatEcho(msg.ConvID, "Sorry, %s", err)
+		return nil
+	default:
+		return err
+	}
+	s.ChatEcho(msg.ConvID, "OK! @%s is linked to %s account `%s`.", msg.Sender.Username, provider, externalID)
+	return nil
+}
+
+func (s *IdentityStore) HandleUnlink(msg chat1.MsgSummary, provider string) error {
+	provider = strings.ToLower(provider)
+	if err := s.Unlink(msg.Sender.Username, provider); err != nil {
+		return err
+	}
+	s.ChatEcho(msg.ConvID, "OK! @%s is no longer linked to a %s account.", msg.Sender.Username, provider)
+	return nil
+}
+
+func (s *IdentityStore) HandleLinks(msg chat1.MsgSummary) error {
+	identities, err := s.Identities(msg.Sender.Username)
+	if err != nil {
+		return err
+	}
+	if len(identities) == 0 {
+		s.ChatEcho(msg.ConvID, "You haven't linked any accounts.")
+		return nil
+	}
+	lines := make([]string, len(identities))
+	for index, identity := range identities {
+		lines[index] = fmt.Sprintf("• %s: `%s`", identity.Provider, identity.ExternalID)
+		if identity.Verified {
+			lines[index] += " (verified)"
+		}
+	}
+	s.ChatEcho(msg.ConvID, "Your linked accounts:\n%s", strings.Join(lines, "\n"))
+	return nil
+}
+
+// IdentityMigrations creates the identities table used by IdentityStore.
+var IdentityMigrations = []Migration{
+	{
+		ID: "base-identities-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS identities (
+					keybase_username varchar(128) NOT NULL,
+					provider varchar(32) NOT NULL,
+					external_id varchar(255) NOT NULL,
+					verified boolean NOT NULL,
+					mtime datetime(6) NOT NULL,
+					PRIMARY KEY (keybase_username, provider),
+					UNIQUE KEY provider_external_id (provider, external_id)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS identities (
+					keybase_username varchar(128) NOT NULL,
+					provider varchar(32) NOT NULL,
+					external_id varchar(255) NOT NULL,
+					verified boolean NOT NULL,
+					mtime timestamp with time zone NOT NULL,
+					PRIMARY KEY (keybase_username, provider),
+					UNIQUE (provider, external_id)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS identities (
+					keybase_username text NOT NULL,
+					provider text NOT NULL,
+					external_id text NOT NULL,
+					verified boolean NOT NULL,
+					mtime datetime NOT NULL,
+					PRIMARY KEY (keybase_username, provider),
+					UNIQUE (provider, external_id)
+				)`,
+			},
+		},
+	},
+}
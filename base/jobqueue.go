@@ -0,0 +1,485 @@
+package base
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	ChatSendJobKind = "chat_send"
+	ChatDMJobKind   = "chat_dm"
+
+	defaultJobMaxAttempts = 6
+	defaultJobBackoff     = 5 * time.Second
+	maxJobBackoff         = time.Hour
+	// how long a claimed job is hidden from other pollers while it runs
+	jobLockTimeout = 5 * time.Minute
+	jobBatchSize   = 20
+)
+
+// JobHandler processes a job's JSON payload, returning an error retries the
+// job with exponential backoff until it runs out of attempts and is moved to
+// the dead letter table.
+type JobHandler func(payload []byte) error
+
+type DeadJob struct {
+	ID        int64
+	Kind      string
+	Payload   string
+	Attempts  int
+	LastError string
+	FailedAt  time.Time
+}
+
+type chatSendPayload struct {
+	ConvID chat1.ConvIDStr `json:"conv_id"`
+	Body   string          `json:"body"`
+}
+
+type chatDMPayload struct {
+	Username string `json:"username"`
+	Body     string `json:"body"`
+}
+
+// JobQueue is a DB backed queue of background work, such as chat sends
+// triggered by webhooks, shared by every instance of a bot.
+type JobQueue struct {
+	*DebugOutput
+	sync.Mutex
+
+	shutdownCh chan struct{}
+
+	stats        *StatsRegistry
+	db           *DB
+	name         string
+	handlers     map[string]JobHandler
+	maxAttempts  int
+	pollInterval time.Duration
+}
+
+func NewJobQueue(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, db *DB, name string) *JobQueue {
+	q := &JobQueue{
+		DebugOutput:  NewDebugOutput("JobQueue", debugConfig),
+		shutdownCh:   make(chan struct{}),
+		stats:        stats.SetPrefix("JobQueue"),
+		db:           db,
+		name:         name,
+		handlers:     make(map[string]JobHandler),
+		maxAttempts:  defaultJobMaxAttempts,
+		pollInterval: time.Second,
+	}
+	q.RegisterHandler(ChatSendJobKind, q.handleChatSend)
+	q.RegisterHandler(ChatDMJobKind, q.handleChatDM)
+	return q
+}
+
+func (q *JobQueue) RegisterHandler(kind string, handler JobHandler) {
+	q.Lock()
+	defer q.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Enqueue stores a job of kind with payload marshalled as JSON.
+func (q *JobQueue) Enqueue(kind string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = q.db.Exec(`
+		INSERT INTO jobs (queue, kind, payload, attempts, max_attempts, run_at, last_error, ctime)
+		VALUES (?, ?, ?, 0, ?, ?, '', ?)
+	`, q.name, kind, string(data), q.maxAttempts, now, now)
+	if err == nil {
+		q.stats.Count("Enqueue - " + kind)
+	}
+	return err
+}
+
+// EnqueueChatSend queues a chat message to convID which is retried if the
+// send fails. The message is formatted immediately.
+func (q *JobQueue) EnqueueChatSend(convID chat1.ConvIDStr, msg string, args ...interface{}) error {
+	body := msg
+	if len(args) > 0 {
+		body = fmt.Sprintf(msg, args...)
+	}
+	return q.Enqueue(ChatSendJobKind, chatSendPayload{ConvID: convID, Body: body})
+}
+
+func (q *JobQueue) handleChatSend(payload []byte) error {
+	var send chatSendPayload
+	if err := json.Unmarshal(payload, &send); err != nil {
+		return err
+	}
+	if _, err := q.Config().KBC.SendMessageByConvID(send.ConvID, "%s", send.Body); err != nil {
+		q.CollectGoneConv(send.ConvID, err)
+		if err := GetNonFatalChatError(err); err != nil {
+			// the conversation is gone, retrying won't help
+			q.Debug("handleChatSend: dropping message to %s: %s", send.ConvID, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// EnqueueDirectMessage queues a chat message to the conversation between the
+// bot and username, like EnqueueChatSend.
+func (q *JobQueue) EnqueueDirectMessage(username string, msg string, args ...interface{}) error {
+	body := msg
+	if len(args) > 0 {
+		body = fmt.Sprintf(msg, args...)
+	}
+	return q.Enqueue(ChatDMJobKind, chatDMPayload{Username: username, Body: body})
+}
+
+func (q *JobQueue) handleChatDM(payload []byte) error {
+	var send chatDMPayload
+	if err := json.Unmarshal(payload, &send); err != nil {
+		return err
+	}
+	if _, err := q.Config().KBC.SendMessageByTlfName(send.Username, "%s", send.Body); err != nil {
+		if err := GetNonFatalChatError(err); err != nil {
+			// the user is gone or blocked the bot, retrying won't help
+			q.Debug("handleChatDM: dropping message to %s: %s", send.Username, err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (q *JobQueue) Shutdown() (err error) {
+	defer q.Trace(&err, "Shutdown")()
+	q.Lock()
+	defer q.Unlock()
+	if q.shutdownCh != nil {
+		close(q.shutdownCh)
+		q.shutdownCh = nil
+	}
+	return nil
+}
+
+func (q *JobQueue) Run() (err error) {
+	defer q.Trace(&err, "Run")()
+	q.Lock()
+	shutdownCh := q.shutdownCh
+	q.Unlock()
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdownCh:
+			return nil
+		case <-ticker.C:
+			if err := q.runDueJobs(shutdownCh); err != nil {
+				q.Errorf("unable to run jobs: %s", err)
+			}
+		}
+	}
+}
+
+type queuedJob struct {
+	id          int64
+	kind        string
+	payload     string
+	attempts    int
+	maxAttempts int
+}
+
+func (q *JobQueue) runDueJobs(shutdownCh chan struct{}) error {
+	rows, err := q.db.Query(fmt.Sprintf(`
+		SELECT id, kind, payload, attempts, max_attempts
+		FROM jobs
+		WHERE queue = ? AND run_at <= ?
+		ORDER BY id
+		LIMIT %d
+	`, jobBatchSize), q.name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	var jobs []queuedJob
+	for rows.Next() {
+		var job queuedJob
+		if err := rows.Scan(&job.id, &job.kind, &job.payload, &job.attempts, &job.maxAttempts); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		select {
+		case <-shutdownCh:
+			return nil
+		default:
+		}
+		claimed, err := q.claim(job.id)
+		if err != nil {
+			return err
+		} else if !claimed {
+			continue
+		}
+		if err := q.runJob(job); err != nil {
+			q.Errorf("unable to update job %d: %s", job.id, err)
+		}
+	}
+	return nil
+}
+
+// claim pushes run_at past the lock timeout so other instances skip the job
+// while it runs, a crashed worker's job becomes due again afterwards.
+func (q *JobQueue) claim(id int64) (bool, error) {
+	now := time.Now().UTC()
+	res, err := q.db.Exec(`
+		UPDATE jobs SET run_at = ? WHERE id = ? AND run_at <= ?
+	`, now.Add(jobLockTimeout), id, now)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+func jobBackoff(attempts int) time.Duration {
+	backoff := defaultJobBackoff
+	for i := 1; i < attempts && backoff < maxJobBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxJobBackoff {
+		backoff = maxJobBackoff
+	}
+	return backoff
+}
+
+func (q *JobQueue) runJob(job queuedJob) error {
+	q.Lock()
+	handler, ok := q.handlers[job.kind]
+	q.Unlock()
+	var jobErr error
+	if !ok {
+		jobErr = fmt.Errorf("no handler registered for %q", job.kind)
+	} else {
+		jobErr = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panic: %v", r)
+				}
+			}()
+			return handler([]byte(job.payload))
+		}()
+	}
+
+	if jobErr == nil {
+		q.stats.Count("runJob - success - " + job.kind)
+		_, err := q.db.Exec(`DELETE FROM jobs WHERE id = ?`, job.id)
+		return err
+	}
+
+	attempts := job.attempts + 1
+	if attempts < job.maxAttempts {
+		q.stats.Count("runJob - retry - " + job.kind)
+		q.Debug("runJob: job %d (%s) failed, attempt %d/%d: %s", job.id, job.kind, attempts, job.maxAttempts, jobErr)
+		_, err := q.db.Exec(`
+			UPDATE jobs SET attempts = ?, run_at = ?, last_error = ? WHERE id = ?
+		`, attempts, time.Now().UTC().Add(jobBackoff(attempts)), jobErr.Error(), job.id)
+		return err
+	}
+
+	q.stats.Count("runJob - dead - " + job.kind)
+	q.Errorf("runJob: job %d (%s) failed after %d attempts: %s", job.id, job.kind, attempts, jobErr)
+	return q.db.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(q.db.Rebind(`
+			INSERT INTO dead_jobs (id, queue, kind, payload, attempts, last_error, failed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`), job.id, q.name, job.kind, job.payload, attempts, jobErr.Error(), time.Now().UTC()); err != nil {
+			return err
+		}
+		_, err := tx.Exec(q.db.Rebind(`DELETE FROM jobs WHERE id = ?`), job.id)
+		return err
+	})
+}
+
+func (q *JobQueue) DeadJobs(limit int) (jobs []DeadJob, err error) {
+	rows, err := q.db.Query(fmt.Sprintf(`
+		SELECT id, kind, payload, attempts, last_error, %s
+		FROM dead_jobs
+		WHERE queue = ?
+		ORDER BY failed_at DESC
+		LIMIT %d
+	`, q.db.Dialect.UnixTimestamp("failed_at"), limit), q.name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var job DeadJob
+		var failedAt int64
+		if err := rows.Scan(&job.ID, &job.Kind, &job.Payload, &job.Attempts, &job.LastError, &failedAt); err != nil {
+			return nil, err
+		}
+		job.FailedAt = time.Unix(failedAt, 0)
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Requeue moves a dead job back onto the queue with its attempts reset.
+func (q *JobQueue) Requeue(id int64) error {
+	return q.db.RunTxn(func(tx *sql.Tx) error {
+		var kind, payload string
+		row := tx.QueryRow(q.db.Rebind(`SELECT kind, payload FROM dead_jobs WHERE id = ? AND queue = ?`), id, q.name)
+		if err := row.Scan(&kind, &payload); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("no dead job %d", id)
+			}
+			return err
+		}
+		now := time.Now().UTC()
+		if _, err := tx.Exec(q.db.Rebind(`
+			INSERT INTO jobs (queue, kind, payload, attempts, max_attempts, run_at, last_error, ctime)
+			VALUES (?, ?, ?, 0, ?, ?, '', ?)
+		`), q.name, kind, payload, q.maxAttempts, now, now); err != nil {
+			return err
+		}
+		_, err := tx.Exec(q.db.Rebind(`DELETE FROM dead_jobs WHERE id = ?`), id)
+		return err
+	})
+}
+
+// AdminCommands exposes the dead letter table to bot admins.
+func (q *JobQueue) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "jobs",
+			Usage:       "[dead|requeue <job id>]",
+			Description: "Inspect failed background jobs or requeue one",
+			Handler:     q.handleAdminJobs,
+		},
+	}
+}
+
+func (q *JobQueue) handleAdminJobs(msg chat1.MsgSummary, args []string) error {
+	switch {
+	case len(args) == 0 || args[0] == "dead":
+		jobs, err := q.DeadJobs(20)
+		if err != nil {
+			return err
+		} else if len(jobs) == 0 {
+			q.ChatEcho(msg.ConvID, "No dead jobs :tada:")
+			return nil
+		}
+		lines := make([]string, len(jobs))
+		for index, job := range jobs {
+			lines[index] = fmt.Sprintf("%d %s attempts=%d failed=%s error=%s", job.ID, job.Kind, job.Attempts,
+				job.FailedAt.UTC().Format(time.RFC3339), job.LastError)
+		}
+		q.ChatEcho(msg.ConvID, "```%s```", strings.Join(lines, "\n"))
+	case args[0] == "requeue" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			q.ChatEcho(msg.ConvID, "Invalid job ID %q", args[1])
+			return nil
+		}
+		if err := q.Requeue(id); err != nil {
+			q.ChatEcho(msg.ConvID, "Unable to requeue job %d: %s", id, err)
+			return nil
+		}
+		q.ChatEcho(msg.ConvID, "Requeued job %d", id)
+	default:
+		q.ChatEcho(msg.ConvID, "Usage: `jobs [dead|requeue <job id>]`")
+	}
+	return nil
+}
+
+// JobQueueMigrations creates the jobs and dead_jobs tables, see jobs.sql.
+var JobQueueMigrations = []Migration{
+	{
+		ID: "base-jobs-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS jobs (
+					id bigint NOT NULL AUTO_INCREMENT,
+					queue varchar(64) NOT NULL,
+					kind varchar(64) NOT NULL,
+					payload mediumtext NOT NULL,
+					attempts int NOT NULL,
+					max_attempts int NOT NULL,
+					run_at datetime(6) NOT NULL,
+					last_error text NOT NULL,
+					ctime datetime(6) NOT NULL,
+					PRIMARY KEY (id),
+					KEY queue_run_at (queue, run_at)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`, `
+				CREATE TABLE IF NOT EXISTS dead_jobs (
+					id bigint NOT NULL,
+					queue varchar(64) NOT NULL,
+					kind varchar(64) NOT NULL,
+					payload mediumtext NOT NULL,
+					attempts int NOT NULL,
+					last_error text NOT NULL,
+					failed_at datetime(6) NOT NULL,
+					PRIMARY KEY (id),
+					KEY queue_failed_at (queue, failed_at)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS jobs (
+					id bigserial PRIMARY KEY,
+					queue varchar(64) NOT NULL,
+					kind varchar(64) NOT NULL,
+					payload text NOT NULL,
+					attempts int NOT NULL,
+					max_attempts int NOT NULL,
+					run_at timestamp with time zone NOT NULL,
+					last_error text NOT NULL,
+					ctime timestamp with time zone NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS jobs_queue_run_at ON jobs (queue, run_at)`, `
+				CREATE TABLE IF NOT EXISTS dead_jobs (
+					id bigint PRIMARY KEY,
+					queue varchar(64) NOT NULL,
+					kind varchar(64) NOT NULL,
+					payload text NOT NULL,
+					attempts int NOT NULL,
+					last_error text NOT NULL,
+					failed_at timestamp with time zone NOT NULL
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS jobs (
+					id integer PRIMARY KEY AUTOINCREMENT,
+					queue text NOT NULL,
+					kind text NOT NULL,
+					payload text NOT NULL,
+					attempts integer NOT NULL,
+					max_attempts integer NOT NULL,
+					run_at datetime NOT NULL,
+					last_error text NOT NULL,
+					ctime datetime NOT NULL
+				)`,
+				`CREATE INDEX IF NOT EXISTS jobs_queue_run_at ON jobs (queue, run_at)`, `
+				CREATE TABLE IF NOT EXISTS dead_jobs (
+					id integer PRIMARY KEY,
+					queue text NOT NULL,
+					kind text NOT NULL,
+					payload text NOT NULL,
+					attempts integer NOT NULL,
+					last_error text NOT NULL,
+					failed_at datetime NOT NULL
+				)`,
+			},
+		},
+	},
+}
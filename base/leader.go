@@ -0,0 +1,252 @@
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	DefaultLeaseTTL = 30 * time.Second
+)
+
+// LeaderElector elects one instance of a bot to run work which must not
+// double fire, such as scheduled tasks, when several replicas share a
+// database. The leader holds a row in the leases table (see leases.sql) and
+// renews it every third of the TTL; if it stops renewing, e.g. because it
+// crashed, another instance takes over once the lease expires. Webhook and
+// command handling doesn't need it and keeps running everywhere. A nil
+// *LeaderElector is always the leader, so single instance bots can skip it.
+type LeaderElector struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats      *StatsRegistry
+	db         *DB
+	name       string
+	id         string
+	ttl        time.Duration
+	isLeader   bool
+	leaseUntil time.Time
+	holder     string
+	electedCh  chan struct{}
+	elected    bool
+	shutdownCh chan struct{}
+}
+
+// NewLeaderElector creates an elector for the lease name, usually the bot's
+// name, with the DefaultLeaseTTL.
+func NewLeaderElector(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig, db *DB, name string) *LeaderElector {
+	return &LeaderElector{
+		DebugOutput: NewDebugOutput("LeaderElector", debugConfig),
+		stats:       stats.SetPrefix("LeaderElector"),
+		db:          db,
+		name:        name,
+		id:          RandHexString(8),
+		ttl:         DefaultLeaseTTL,
+		electedCh:   make(chan struct{}),
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+// IsLeader returns whether this instance currently holds the lease. It turns
+// false as soon as the lease could have expired, even if renewing it failed.
+func (e *LeaderElector) IsLeader() bool {
+	if e == nil {
+		return true
+	}
+	e.Lock()
+	defer e.Unlock()
+	return e.isLeader && time.Now().Before(e.leaseUntil)
+}
+
+// Elected is closed once the first election has finished, so work run at
+// startup can wait to find out whether this instance leads.
+func (e *LeaderElector) Elected() <-chan struct{} {
+	if e == nil {
+		ch := make(chan struct{})
+		close(ch)
+		return ch
+	}
+	return e.electedCh
+}
+
+func (e *LeaderElector) Run() error {
+	e.Lock()
+	shutdownCh := e.shutdownCh
+	e.Unlock()
+	if shutdownCh == nil {
+		return nil
+	}
+	e.Debug("Run: starting leader election for %s: id: %s", e.name, e.id)
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign()
+		select {
+		case <-shutdownCh:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *LeaderElector) campaign() {
+	start := time.Now()
+	isLeader, holder, err := e.tryAcquire()
+	e.Lock()
+	defer e.Unlock()
+	if !e.elected {
+		e.elected = true
+		close(e.electedCh)
+	}
+	if err != nil {
+		e.stats.Count("campaign - error")
+		e.Errorf("campaign: unable to acquire lease %s: %v", e.name, err)
+		return
+	}
+	wasLeader := e.isLeader
+	e.isLeader = isLeader
+	e.holder = holder
+	if isLeader {
+		e.leaseUntil = start.Add(e.ttl)
+	}
+	if wasLeader != isLeader {
+		e.stats.Count(fmt.Sprintf("campaign - leader - %v", isLeader))
+		e.Debug("campaign: leader change: isLeader: %v myid: %s leaderid: %s", isLeader, e.id, holder)
+	}
+	value := 0.
+	if isLeader {
+		value = 1
+	}
+	DefaultMetrics.GaugeSet("keybase_bot_leader", "Whether this instance holds the scheduler lease.", value,
+		"lease", e.name)
+}
+
+// tryAcquire takes the lease if it's free or expired, or renews it if we
+// already hold it. It returns whether we hold it and who does. Expiry is
+// stamped and compared with the database clock so replicas with skewed
+// clocks agree on it.
+func (e *LeaderElector) tryAcquire() (isLeader bool, holder string, err error) {
+	expireTime := e.db.Dialect.IntervalFromNow(int(e.ttl.Seconds()))
+	err = e.db.RunTxn(func(tx *sql.Tx) error {
+		res, err := tx.Exec(e.db.Rebind(`
+			UPDATE leases
+			SET holder = ?, expire_time = `+expireTime+`
+			WHERE name = ? AND (holder = ? OR expire_time < `+e.db.Dialect.Now()+`)
+		`), e.id, e.name, e.id)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected > 0 {
+			isLeader, holder = true, e.id
+			return nil
+		}
+		row := tx.QueryRow(e.db.Rebind(`
+			SELECT holder
+			FROM leases
+			WHERE name = ?
+		`), e.name)
+		switch err := row.Scan(&holder); err {
+		case nil:
+			return nil
+		case sql.ErrNoRows:
+		default:
+			return err
+		}
+		// nobody has ever held the lease, if another instance beats us to it
+		// the insert fails and we try again next round
+		if _, err := tx.Exec(e.db.Rebind(`
+			INSERT INTO leases (name, holder, expire_time)
+			VALUES (?, ?, `+expireTime+`)
+		`), e.name, e.id); err != nil {
+			return err
+		}
+		isLeader, holder = true, e.id
+		return nil
+	})
+	return isLeader, holder, err
+}
+
+// Shutdown releases the lease so another instance can take over right away.
+func (e *LeaderElector) Shutdown() (err error) {
+	defer e.Trace(&err, "Shutdown")()
+	e.Lock()
+	if e.shutdownCh != nil {
+		close(e.shutdownCh)
+		e.shutdownCh = nil
+	}
+	wasLeader := e.isLeader
+	e.isLeader = false
+	e.Unlock()
+	if !wasLeader {
+		return nil
+	}
+	_, err = e.db.Exec(`
+		DELETE FROM leases
+		WHERE name = ? AND holder = ?
+	`, e.name, e.id)
+	return err
+}
+
+// AdminCommands lets bot admins see which instance leads.
+func (e *LeaderElector) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "leader",
+			Description: "Show whether this instance runs the schedulers",
+			Handler:     e.handleAdminLeader,
+		},
+	}
+}
+
+func (e *LeaderElector) handleAdminLeader(msg chat1.MsgSummary, args []string) error {
+	isLeader := e.IsLeader()
+	e.Lock()
+	holder := e.holder
+	e.Unlock()
+	if isLeader {
+		e.ChatEcho(msg.ConvID, "`%s` is the leader for %s", e.id, e.name)
+	} else {
+		e.ChatEcho(msg.ConvID, "`%s` is a follower, `%s` is the leader for %s", e.id, holder, e.name)
+	}
+	return nil
+}
+
+// LeaderMigrations creates the leases table used by LeaderElector.
+var LeaderMigrations = []Migration{
+	{
+		ID: "base-leader-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS leases (
+					name varchar(64) NOT NULL,
+					holder varchar(32) NOT NULL,
+					expire_time datetime(6) NOT NULL,
+					PRIMARY KEY (name)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS leases (
+					name varchar(64) NOT NULL,
+					holder varchar(32) NOT NULL,
+					expire_time timestamp NOT NULL,
+					PRIMARY KEY (name)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS leases (
+					name text NOT NULL,
+					holder text NOT NULL,
+					expire_time datetime NOT NULL,
+					PRIMARY KEY (name)
+				)`,
+			},
+		},
+	},
+}
@@ -0,0 +1,47 @@
+package base_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/bottest"
+)
+
+func TestLeaderElector(t *testing.T) {
+	db := bottest.NewDB(t, base.LeaderMigrations)
+	debugConfig := base.NewChatDebugOutputConfig(nil, "")
+	stats, err := base.NewStatsRegistry(debugConfig, "")
+	require.NoError(t, err)
+	elect := func() *base.LeaderElector {
+		e := base.NewLeaderElector(stats, debugConfig, db, "testbot")
+		go func() { _ = e.Run() }()
+		select {
+		case <-e.Elected():
+		case <-time.After(5 * time.Second):
+			t.Fatal("no election within 5s")
+		}
+		return e
+	}
+
+	// a crashed instance's lease is taken over once it expired
+	_, err = db.Exec(`INSERT INTO leases (name, holder, expire_time) VALUES (?, ?, `+
+		db.Dialect.IntervalAgo(1)+`)`, "testbot", "crashed")
+	require.NoError(t, err)
+	first := elect()
+	require.True(t, first.IsLeader())
+	second := elect()
+	require.False(t, second.IsLeader())
+	require.True(t, first.IsLeader())
+
+	// the lease is released on shutdown, so the next instance takes over
+	// without waiting for it to expire
+	require.NoError(t, first.Shutdown())
+	require.False(t, first.IsLeader())
+	third := elect()
+	defer func() { _ = third.Shutdown() }()
+	require.NoError(t, second.Shutdown())
+	require.True(t, third.IsLeader())
+}
@@ -0,0 +1,122 @@
+package base
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const DefaultShutdownDeadline = 30 * time.Second
+
+// ContextShutdowner is implemented by components which can bound their
+// shutdown, such as HTTPSrv draining in-flight requests.
+type ContextShutdowner interface {
+	ShutdownContext(ctx context.Context) error
+}
+
+// Lifecycle runs a bot's servers and schedulers in an errgroup. On SIGTERM,
+// SIGINT or the first component error it cancels its context and shuts every
+// registered Shutdowner down in registration order, all within a single
+// deadline.
+type Lifecycle struct {
+	*DebugOutput
+
+	ctx         context.Context
+	cancel      context.CancelFunc
+	eg          *errgroup.Group
+	deadline    time.Duration
+	shutdowners []Shutdowner
+}
+
+func NewLifecycle(debugConfig *ChatDebugOutputConfig, deadline time.Duration) *Lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	eg, ctx := errgroup.WithContext(ctx)
+	return &Lifecycle{
+		DebugOutput: NewDebugOutput("Lifecycle", debugConfig),
+		ctx:         ctx,
+		cancel:      cancel,
+		eg:          eg,
+		deadline:    deadline,
+	}
+}
+
+// Context is cancelled once the bot starts shutting down.
+func (l *Lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Go runs f and registers shutdowner, which may be nil, to stop it.
+func (l *Lifecycle) Go(f func() error, shutdowner Shutdowner) {
+	if shutdowner != nil {
+		l.AddShutdowner(shutdowner)
+	}
+	GoWithRecoverErrGroup(l.eg, l.DebugOutput, f)
+}
+
+// GoContext runs f with the lifecycle context, f must return once the
+// context is done.
+func (l *Lifecycle) GoContext(f func(ctx context.Context) error) {
+	GoWithRecoverErrGroup(l.eg, l.DebugOutput, func() error { return f(l.ctx) })
+}
+
+func (l *Lifecycle) AddShutdowner(shutdowners ...Shutdowner) {
+	l.shutdowners = append(l.shutdowners, shutdowners...)
+}
+
+// Run blocks until a signal is received or a component fails, then shuts
+// everything down and returns the first component error.
+func (l *Lifecycle) Run() (err error) {
+	defer l.Trace(&err, "Run")()
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, os.Signal(syscall.SIGTERM))
+	defer signal.Stop(signalCh)
+
+	select {
+	case sig := <-signalCh:
+		l.Debug("Run: Received %q, shutting down", sig)
+	case <-l.ctx.Done():
+		l.Debug("Run: component stopped, shutting down")
+	}
+	l.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), l.deadline)
+	defer cancel()
+	l.shutdown(ctx)
+
+	waitCh := make(chan error, 1)
+	go func() { waitCh <- l.eg.Wait() }()
+	select {
+	case err := <-waitCh:
+		return err
+	case <-ctx.Done():
+		l.Debug("Run: components still running after %v, charging forward", l.deadline)
+		return nil
+	}
+}
+
+func (l *Lifecycle) shutdown(ctx context.Context) {
+	for _, shutdowner := range l.shutdowners {
+		done := make(chan struct{})
+		go func(shutdowner Shutdowner) {
+			defer close(done)
+			var err error
+			if cs, ok := shutdowner.(ContextShutdowner); ok {
+				err = cs.ShutdownContext(ctx)
+			} else {
+				err = shutdowner.Shutdown()
+			}
+			if err != nil {
+				l.Debug("Unable to shutdown %T: %v", shutdowner, err)
+			}
+		}(shutdowner)
+		select {
+		case <-done:
+			l.Debug("Shutdown: %T", shutdowner)
+		case <-ctx.Done():
+			l.Debug("Shutdown: %T timed out, charging forward", shutdowner)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMetrics collects the metrics every bot exposes in the Prometheus
+// text format on `/metrics` of its HTTPSrv.
+var DefaultMetrics = NewMetricsRegistry()
+
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type metricType string
+
+const (
+	counterMetricType   metricType = "counter"
+	gaugeMetricType     metricType = "gauge"
+	histogramMetricType metricType = "histogram"
+)
+
+type metricSeries struct {
+	labels  string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type metricFamily struct {
+	name    string
+	help    string
+	typ     metricType
+	buckets []float64
+	series  map[string]*metricSeries
+}
+
+type MetricsRegistry struct {
+	sync.Mutex
+	families   map[string]*metricFamily
+	collectors []func()
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// formatLabels renders key/value label pairs, e.g. ("path", "/x") -> `{path="/x"}`
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (r *MetricsRegistry) getSeries(name, help string, typ metricType, labels []string) *metricSeries {
+	family, ok := r.families[name]
+	if !ok {
+		family = &metricFamily{
+			name:   name,
+			help:   help,
+			typ:    typ,
+			series: make(map[string]*metricSeries),
+		}
+		if typ == histogramMetricType {
+			family.buckets = defaultLatencyBuckets
+		}
+		r.families[name] = family
+	}
+	key := formatLabels(labels)
+	series, ok := family.series[key]
+	if !ok {
+		series = &metricSeries{labels: key}
+		if typ == histogramMetricType {
+			series.buckets = make([]uint64, len(family.buckets))
+		}
+		family.series[key] = series
+	}
+	return series
+}
+
+// CounterAdd increments the counter name by value, labels are given as
+// alternating keys and values.
+func (r *MetricsRegistry) CounterAdd(name, help string, value float64, labels ...string) {
+	r.Lock()
+	defer r.Unlock()
+	r.getSeries(name, help, counterMetricType, labels).value += value
+}
+
+func (r *MetricsRegistry) CounterInc(name, help string, labels ...string) {
+	r.CounterAdd(name, help, 1, labels...)
+}
+
+func (r *MetricsRegistry) GaugeSet(name, help string, value float64, labels ...string) {
+	r.Lock()
+	defer r.Unlock()
+	r.getSeries(name, help, gaugeMetricType, labels).value = value
+}
+
+// Observe records value in the histogram name.
+func (r *MetricsRegistry) Observe(name, help string, value float64, labels ...string) {
+	r.Lock()
+	defer r.Unlock()
+	series := r.getSeries(name, help, histogramMetricType, labels)
+	for index, bound := range r.families[name].buckets {
+		if value <= bound {
+			series.buckets[index]++
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+// ObserveSince records the seconds elapsed since start in the histogram name.
+func (r *MetricsRegistry) ObserveSince(name, help string, start time.Time, labels ...string) {
+	r.Observe(name, help, time.Since(start).Seconds(), labels...)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// withLabel adds a label to an already formatted label set.
+func withLabel(labels, key, value string) string {
+	label := fmt.Sprintf(`%s="%s"`, key, value)
+	if labels == "" {
+		return "{" + label + "}"
+	}
+	return strings.TrimSuffix(labels, "}") + "," + label + "}"
+}
+
+// AddCollector registers f to be run before every scrape, e.g. to set gauges
+// from a snapshot such as sql.DBStats.
+func (r *MetricsRegistry) AddCollector(f func()) {
+	r.Lock()
+	defer r.Unlock()
+	r.collectors = append(r.collectors, f)
+}
+
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.Lock()
+	collectors := r.collectors
+	r.Unlock()
+	for _, collect := range collectors {
+		collect()
+	}
+	r.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		family := r.families[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.typ)
+		keys := make([]string, 0, len(family.series))
+		for key := range family.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			series := family.series[key]
+			if family.typ != histogramMetricType {
+				fmt.Fprintf(&buf, "%s%s %s\n", name, series.labels, formatFloat(series.value))
+				continue
+			}
+			for index, bound := range family.buckets {
+				fmt.Fprintf(&buf, "%s_bucket%s %d\n", name,
+					withLabel(series.labels, "le", formatFloat(bound)), series.buckets[index])
+			}
+			fmt.Fprintf(&buf, "%s_bucket%s %d\n", name, withLabel(series.labels, "le", "+Inf"), series.count)
+			fmt.Fprintf(&buf, "%s_sum%s %s\n", name, series.labels, formatFloat(series.sum))
+			fmt.Fprintf(&buf, "%s_count%s %d\n", name, series.labels, series.count)
+		}
+	}
+	r.Unlock()
+	return buf.WriteTo(w)
+}
+
+func (r *MetricsRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := r.WriteTo(w); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// CommandMetricName names a chat message by the longest of commands it starts
+// with, e.g. `!gcal accounts connect work` -> `!gcal accounts connect`. Any
+// other command is "other" and text that isn't a command "none", so
+// arbitrary message text doesn't blow up the number of series.
+func CommandMetricName(body string, commands []string) string {
+	toks := strings.Fields(body)
+	if len(toks) == 0 || !strings.HasPrefix(toks[0], "!") {
+		return "none"
+	}
+	name := "other"
+	var best int
+	for _, cmd := range commands {
+		cmdToks := strings.Fields(cmd)
+		if len(cmdToks) <= best || len(cmdToks) > len(toks) {
+			continue
+		}
+		if strings.Join(toks[:len(cmdToks)], " ") == strings.Join(cmdToks, " ") {
+			name, best = strings.Join(cmdToks, " "), len(cmdToks)
+		}
+	}
+	return name
+}
+
+// Total sums every series of the counter or gauge name, for histograms it's
+// the total number of observations.
+func (r *MetricsRegistry) Total(name string) float64 {
+	r.Lock()
+	defer r.Unlock()
+	family, ok := r.families[name]
+	if !ok {
+		return 0
+	}
+	var total float64
+	for _, series := range family.series {
+		if family.typ == histogramMetricType {
+			total += float64(series.count)
+		} else {
+			total += series.value
+		}
+	}
+	return total
+}
@@ -0,0 +1,30 @@
+package base_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+func TestCommandMetricName(t *testing.T) {
+	commands := []string{"!gcal accounts", "!gcal accounts connect", "!gcal next"}
+	testCases := []struct {
+		body string
+		name string
+	}{
+		{body: "", name: "none"},
+		{body: "hello !gcal next", name: "none"},
+		{body: "!gcal next", name: "!gcal next"},
+		{body: "  !gcal   next 5", name: "!gcal next"},
+		{body: "!gcal accounts list", name: "!gcal accounts"},
+		{body: "!gcal accounts connect work", name: "!gcal accounts connect"},
+		{body: "!gcal nope", name: "other"},
+		{body: "!gcal", name: "other"},
+		{body: "!github subscribe keybase/client", name: "other"},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.name, base.CommandMetricName(tc.body, commands), tc.body)
+	}
+}
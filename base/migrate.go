@@ -0,0 +1,172 @@
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a single schema change. Statements are keyed by dialect, a
+// dialect without its own statements falls back to the MySQL ones.
+type Migration struct {
+	ID         string
+	Statements map[Dialect][]string
+}
+
+func (m Migration) statementsFor(dialect Dialect) ([]string, error) {
+	if stmts, ok := m.Statements[dialect]; ok {
+		return stmts, nil
+	}
+	if stmts, ok := m.Statements[MySQLDialect]; ok {
+		return stmts, nil
+	}
+	return nil, fmt.Errorf("migration %q has no statements for %s", m.ID, dialect)
+}
+
+// Migrate applies any migrations which have not yet been recorded in the
+// schema_migrations table, in order. Each migration runs in its own
+// transaction.
+func (d *DB) Migrate(migrations []Migration) error {
+	if _, err := d.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			id varchar(128) NOT NULL,
+			ctime varchar(64) NOT NULL,
+			PRIMARY KEY (id)
+		)
+	`); err != nil {
+		return fmt.Errorf("unable to create schema_migrations: %v", err)
+	}
+	for _, migration := range migrations {
+		var exists bool
+		row := d.QueryRow(`SELECT EXISTS(SELECT * FROM schema_migrations WHERE id = ?)`, migration.ID)
+		if err := row.Scan(&exists); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		stmts, err := migration.statementsFor(d.Dialect)
+		if err != nil {
+			return err
+		}
+		if err := d.RunTxn(func(tx *sql.Tx) error {
+			for _, stmt := range stmts {
+				if _, err := tx.Exec(stmt); err != nil {
+					return fmt.Errorf("migration %q failed: %v", migration.ID, err)
+				}
+			}
+			_, err := tx.Exec(d.Rebind(`INSERT INTO schema_migrations (id, ctime) VALUES (?, ?)`),
+				migration.ID, time.Now().UTC().Format(time.RFC3339))
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OAuthMigrations creates the tables used by OAuthDB.
+var OAuthMigrations = []Migration{
+	{
+		ID: "base-oauth-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS oauth (
+					identifier varchar(128) NOT NULL,
+					access_token varchar(256) NOT NULL,
+					token_type varchar(64) NOT NULL,
+					refresh_token varchar(256) NOT NULL,
+					expiry datetime NOT NULL,
+					ctime datetime NOT NULL,
+					mtime datetime NOT NULL,
+					PRIMARY KEY (identifier)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8`, `
+				CREATE TABLE IF NOT EXISTS oauth_state (
+					state char(24) NOT NULL,
+					identifier varchar(128) NOT NULL,
+					conv_id char(64) NOT NULL,
+					msg_id char(64) NOT NULL,
+					is_complete boolean NOT NULL DEFAULT 0,
+					PRIMARY KEY (state)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS oauth (
+					identifier varchar(128) NOT NULL,
+					access_token varchar(2048) NOT NULL,
+					token_type varchar(64) NOT NULL,
+					refresh_token varchar(2048) NOT NULL,
+					expiry timestamp with time zone NOT NULL,
+					ctime timestamp with time zone NOT NULL,
+					mtime timestamp with time zone NOT NULL,
+					PRIMARY KEY (identifier)
+				)`, `
+				CREATE TABLE IF NOT EXISTS oauth_state (
+					state char(24) NOT NULL,
+					identifier varchar(128) NOT NULL,
+					conv_id char(64) NOT NULL,
+					msg_id bigint NOT NULL,
+					is_complete boolean NOT NULL DEFAULT false,
+					PRIMARY KEY (state)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS oauth (
+					identifier text NOT NULL PRIMARY KEY,
+					access_token text NOT NULL,
+					token_type text NOT NULL,
+					refresh_token text NOT NULL,
+					expiry datetime NOT NULL,
+					ctime datetime NOT NULL,
+					mtime datetime NOT NULL
+				)`, `
+				CREATE TABLE IF NOT EXISTS oauth_state (
+					state text NOT NULL PRIMARY KEY,
+					identifier text NOT NULL,
+					conv_id text NOT NULL,
+					msg_id bigint NOT NULL,
+					is_complete boolean NOT NULL DEFAULT 0
+				)`,
+			},
+		},
+	},
+	{
+		// make room for tokens encrypted by FieldCipher
+		ID: "base-oauth-2",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				ALTER TABLE oauth
+				MODIFY access_token varchar(2048) NOT NULL,
+				MODIFY refresh_token varchar(2048) NOT NULL`,
+			},
+			// the Postgres and SQLite columns are already wide enough
+			PostgresDialect: {},
+			SQLiteDialect:   {},
+		},
+	},
+	{
+		// PKCE and state expiry, states from before this get a ctime in the
+		// past so they're expired
+		ID: "base-oauth-3",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				ALTER TABLE oauth_state
+				ADD code_verifier varchar(128) NOT NULL DEFAULT '',
+				ADD ctime datetime NOT NULL DEFAULT '1970-01-02 00:00:00',
+				ADD KEY ctime (ctime)`,
+			},
+			PostgresDialect: {`
+				ALTER TABLE oauth_state
+				ADD code_verifier varchar(128) NOT NULL DEFAULT '',
+				ADD ctime timestamp with time zone NOT NULL DEFAULT '1970-01-02 00:00:00+00'`, `
+				CREATE INDEX IF NOT EXISTS oauth_state_ctime ON oauth_state (ctime)`,
+			},
+			SQLiteDialect: {`
+				ALTER TABLE oauth_state
+				ADD code_verifier text NOT NULL DEFAULT ''`, `
+				ALTER TABLE oauth_state
+				ADD ctime datetime NOT NULL DEFAULT '1970-01-02 00:00:00'`, `
+				CREATE INDEX IF NOT EXISTS oauth_state_ctime ON oauth_state (ctime)`,
+			},
+		},
+	},
+}
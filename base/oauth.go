@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
 	"strings"
@@ -15,6 +17,13 @@ import (
 	"golang.org/x/oauth2"
 )
 
+// OAuthStateTTL is how long an auth link works for.
+const OAuthStateTTL = 30 * time.Minute
+
+// ErrOAuthStateUsed is returned by OAuthStorage.CompleteState when the state
+// was already completed, every state can only be exchanged once.
+var ErrOAuthStateUsed = errors.New("OAuth state already used")
+
 type OAuthRequiredError struct{}
 
 func (e OAuthRequiredError) Error() string {
@@ -28,6 +37,8 @@ type OAuthStorage interface {
 
 	GetState(state string) (*OAuthRequest, error)
 	PutState(state string, req *OAuthRequest) error
+	// CompleteState marks state as used, returning ErrOAuthStateUsed if it
+	// already was.
 	CompleteState(state string) error
 }
 
@@ -112,13 +123,11 @@ func (o *OAuthHTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.IsComplete {
-		_, err = w.Write(MakeOAuthHTML(o.htmlTitle, "success",
-			`<div class="success"> Success! </div>
-		<div>You can now close this page and return to the Keybase app.</div>`,
-			o.htmlLogoSrc))
-		if err != nil {
-			o.Errorf("oauthHandler: unable to write: %v", err)
-		}
+		o.showOAuthSuccess(w, o.getConvChannel(req.ConvID))
+		return
+	} else if req.IsExpired() {
+		o.Stats.Count("oauthHandler - expired")
+		o.showOAuthExpired(w)
 		return
 	}
 
@@ -128,7 +137,17 @@ func (o *OAuthHTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 		o.showOAuthError(w)
 		return
 	}
-	token, err := o.oauth.Exchange(context.TODO(), code)
+	// claim the state before the exchange so a replayed callback can't
+	// exchange a second code
+	if err = o.storage.CompleteState(state); err == ErrOAuthStateUsed {
+		err = nil
+		o.Stats.Count("oauthHandler - replayed")
+		o.showOAuthError(w)
+		return
+	} else if err != nil {
+		return
+	}
+	token, err := o.oauth.Exchange(HTTPClientContext(context.TODO()), code, PKCEExchangeOptions(req.CodeVerifier)...)
 	if err != nil {
 		return
 	}
@@ -136,9 +155,6 @@ func (o *OAuthHTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 	if err = o.storage.PutToken(req.TokenIdentifier, token); err != nil {
 		return
 	}
-	if err = o.storage.CompleteState(state); err != nil {
-		return
-	}
 	callbackMsg, err := o.getCallbackMsg(*req)
 	if err != nil {
 		return
@@ -147,16 +163,48 @@ func (o *OAuthHTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 	if err = o.callback(callbackMsg, req.TokenIdentifier); err != nil {
 		return
 	}
+	o.showOAuthSuccess(w, &callbackMsg.Channel)
+}
 
-	_, err = w.Write(MakeOAuthHTML(o.htmlTitle, "success",
-		`<div class="success"> Success! </div>
-		<div>You can now close this page and return to the Keybase app.</div>`,
-		o.htmlLogoSrc))
+// getConvChannel looks up the conversation an auth request came from for the
+// landing page's link back, it's nil if that fails.
+func (o *OAuthHTTPSrv) getConvChannel(convID chat1.ConvIDStr) *chat1.ChatChannel {
+	conv, err := o.kbc.GetConversation(convID)
 	if err != nil {
+		o.Debug("getConvChannel: unable to get %s: %v", convID, err)
+		return nil
+	}
+	return &conv.Channel
+}
+
+func (o *OAuthHTTPSrv) showOAuthSuccess(w http.ResponseWriter, channel *chat1.ChatChannel) {
+	if _, err := w.Write(MakeOAuthSuccessHTML(o.htmlTitle, o.htmlLogoSrc, channel)); err != nil {
+		o.Errorf("oauthHandler: unable to write: %v", err)
+	}
+}
+
+func (o *OAuthHTTPSrv) showOAuthExpired(w http.ResponseWriter) {
+	if _, err := w.Write(MakeOAuthHTML(o.htmlTitle, "error",
+		"This link has expired, please run the bot command again!", o.htmlLogoSrc)); err != nil {
 		o.Errorf("oauthHandler: unable to write: %v", err)
 	}
 }
 
+// MakeOAuthSuccessHTML is the page users land on once they've authorized a
+// bot, with a link back to the conversation they started from if it's known.
+func MakeOAuthSuccessHTML(botName, logoURL string, channel *chat1.ChatChannel) []byte {
+	msg := `<div class="success"> Success! </div>`
+	if channel != nil {
+		msg += fmt.Sprintf(`
+		<div><a href="%s">Return to %s in the Keybase app</a></div>`,
+			html.EscapeString(KeybaseChatLink(*channel)), html.EscapeString(channelDisplayName(*channel)))
+	} else {
+		msg += `
+		<div>You can now close this page and return to the Keybase app.</div>`
+	}
+	return MakeOAuthHTML(botName, "success", msg, logoURL)
+}
+
 func (o *OAuthHTTPSrv) showOAuthError(w http.ResponseWriter) {
 	if _, err := w.Write(MakeOAuthHTML(o.htmlTitle, "error",
 		"Unable to complete request, please try running the bot command again!", o.htmlLogoSrc)); err != nil {
@@ -177,6 +225,14 @@ type OAuthRequest struct {
 	TokenIdentifier string
 	ConvID          chat1.ConvIDStr
 	MsgID           chat1.MessageID
+	// CodeVerifier is the PKCE verifier for the auth URL, if any
+	CodeVerifier string
+	Ctime        time.Time
+}
+
+// IsExpired returns whether the auth link for the request is too old to use.
+func (r OAuthRequest) IsExpired() bool {
+	return time.Since(r.Ctime) > OAuthStateTTL
 }
 
 type GetOAuthOpts struct {
@@ -186,6 +242,8 @@ type GetOAuthOpts struct {
 	AuthMessageTemplate string
 	// optional callback which constructs and sends auth URL (default: disabled)
 	AuthURLCallback func(authUrl string) error
+	// skip PKCE for providers which reject the extra parameters (default: false)
+	DisablePKCE bool
 }
 
 func GetOAuthClient(
@@ -216,10 +274,17 @@ func GetOAuthClient(
 		if err != nil {
 			return nil, err
 		}
+		var verifier string
+		if !opts.DisablePKCE {
+			if verifier, err = NewPKCEVerifier(); err != nil {
+				return nil, err
+			}
+		}
 		if err := storage.PutState(state, &OAuthRequest{
 			TokenIdentifier: tokenIdentifier,
 			ConvID:          callbackMsg.ConvID,
 			MsgID:           callbackMsg.Id,
+			CodeVerifier:    verifier,
 		}); err != nil {
 			return nil, err
 		}
@@ -228,6 +293,9 @@ func GetOAuthClient(
 		if opts.OAuthOfflineAccessType {
 			oauthOpts = append(oauthOpts, oauth2.AccessTypeOffline)
 		}
+		if verifier != "" {
+			oauthOpts = append(oauthOpts, PKCEChallengeOptions(verifier)...)
+		}
 		authURL := config.AuthCodeURL(state, oauthOpts...)
 		// strip protocol to skip unfurl prompt
 		authURL = strings.TrimPrefix(authURL, "https://")
@@ -254,22 +322,7 @@ func GetOAuthClient(
 		}
 
 		return nil, OAuthRequiredError{}
-	} else {
-		// renew token
-		if token.Expiry.Before(time.Now()) {
-			newToken, err := config.TokenSource(context.Background(), token).Token()
-			if err != nil {
-				return nil, fmt.Errorf("unable to renew token: %s", err)
-			}
-			if newToken.AccessToken != token.AccessToken {
-				err = storage.PutToken(tokenIdentifier, newToken)
-				if err != nil {
-					return nil, fmt.Errorf("unable to update token: %s", err)
-				}
-				token = newToken
-			}
-		}
 	}
 
-	return config.Client(context.Background(), token), nil
+	return NewPersistingClient(config, token, StorageTokenSaver(storage, tokenIdentifier)), nil
 }
@@ -2,8 +2,11 @@ package base
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 )
@@ -27,12 +30,49 @@ type Options struct {
 	// Conversation name or ID to report bot errors to
 	ErrReportConv string
 	// Database Source Name
-	DSN          string
+	DSN string
+	// Connection pool settings for DSN, see DBPoolOptions
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	DBSlowQuery       time.Duration
+	// Create or update the bot's tables on startup
+	Migrate      bool
 	MultiDSN     string
 	StathatEZKey string
+	// Commands allowed per minute for each user and conversation, with bursts
+	// up to CommandRateBurst. Zero disables rate limiting.
+	CommandRateLimit float64
+	CommandRateBurst int
+	// Where bot credentials are read from, see NewSecretsProvider
+	SecretsBackend string
+	SecretsPath    string
+	// Sentry DSN to report recovered panics to, optional
+	SentryDSN string
+	// Upstream API client settings, see HTTPClientOptions
+	HTTPTimeout    time.Duration
+	HTTPMaxRetries int
+	HTTPProxy      string
+	// Master key for encrypting OAuth tokens at rest, either static keys (see
+	// NewStaticKeyEncrypter) or an AWS KMS key. Tokens are stored in plaintext
+	// if neither is set.
+	EncryptionKey string
+	KMSKeyID      string
+	// Encrypt any plaintext tokens already in the database and exit
+	EncryptExisting bool
+	// Keybase usernames allowed to run hidden and admin commands, defaults to
+	// DefaultBotAdmins
+	BotAdmins []string
 	// Allow the bot to read it's own messages (default: false)
 	ReadSelf bool
-	AWSOpts  *AWSOptions
+	// Directory of `<name>.tmpl` message template overrides, see
+	// MessageTemplates
+	TemplatesDir string
+	// Log chat sends instead of posting them, see DryRunRecorder
+	DryRun bool
+	// Address of the `/metrics` listener, see SetMetricsAddr
+	MetricsAddr string
+	AWSOpts     *AWSOptions
 }
 
 func NewOptions() *Options {
@@ -47,9 +87,46 @@ func (o *Options) Parse(fs *flag.FlagSet, argv []string) error {
 	fs.StringVar(&o.ErrReportConv, "err-report-conv", os.Getenv("BOT_ERR_REPORT_CONV"),
 		"Conversation name or ID to report errors to")
 	fs.StringVar(&o.DSN, "dsn", os.Getenv("BOT_DSN"), "Bot database DSN")
+	fs.IntVar(&o.DBMaxOpenConns, "db-max-open-conns", 0, "Maximum open database connections, 0 for unlimited")
+	fs.IntVar(&o.DBMaxIdleConns, "db-max-idle-conns", 0, "Maximum idle database connections, 0 for the default of 2")
+	fs.DurationVar(&o.DBConnMaxLifetime, "db-conn-max-lifetime", 0, "Maximum lifetime of a database connection, 0 for no limit")
+	fs.DurationVar(&o.DBSlowQuery, "db-slow-query", DefaultSlowQueryThreshold, "Log database queries slower than this, 0 to disable")
+	fs.BoolVar(&o.Migrate, "migrate", os.Getenv("BOT_MIGRATE") != "", "Run database migrations on startup")
 	fs.StringVar(&o.MultiDSN, "multi-dsn", os.Getenv("BOT_MULTI_DSN"), "Bot multi coordination database DSN")
 	fs.StringVar(&o.StathatEZKey, "stathat-ezkey", os.Getenv("BOT_STATHAT_EZKEY"), "Bot stathat ezkey")
+	fs.Float64Var(&o.CommandRateLimit, "command-rate-limit", DefaultCommandRateLimit,
+		"Commands per minute allowed for each user and conversation, 0 to disable")
+	fs.IntVar(&o.CommandRateBurst, "command-rate-burst", DefaultCommandRateBurst,
+		"Commands a user or conversation may burst above the rate limit")
 	fs.BoolVar(&o.ReadSelf, "read-self", false, "Allow the bot to read it's own messages")
+	fs.StringVar(&o.TemplatesDir, "templates-dir", os.Getenv("BOT_TEMPLATES_DIR"),
+		"Directory of <name>.tmpl files overriding the bot's message templates, optional")
+	fs.BoolVar(&o.DryRun, "dry-run", os.Getenv("BOT_DRY_RUN") != "",
+		"Process commands and webhooks but log chat sends instead of posting them")
+	metricsAddr, ok := os.LookupEnv("BOT_METRICS_ADDR")
+	if !ok {
+		metricsAddr = DefaultMetricsAddr
+	}
+	fs.StringVar(&o.MetricsAddr, "metrics-addr", metricsAddr, "Address to serve Prometheus /metrics on, empty to disable")
+
+	fs.StringVar(&o.SecretsBackend, "secrets-backend", os.Getenv("BOT_SECRETS_BACKEND"),
+		"Where to read bot credentials from: kbfs (default), env, file or vault")
+	fs.StringVar(&o.SecretsPath, "secrets-path", os.Getenv("BOT_SECRETS_PATH"),
+		"Directory (kbfs, file) or Vault path of the bot credentials, defaults to the bot's KBFS folder")
+	fs.StringVar(&o.SentryDSN, "sentry-dsn", os.Getenv("BOT_SENTRY_DSN"), "Sentry DSN to report panics to, optional")
+	fs.DurationVar(&o.HTTPTimeout, "http-timeout", DefaultHTTPTimeout, "Timeout for upstream API requests, including retries")
+	fs.IntVar(&o.HTTPMaxRetries, "http-max-retries", DefaultHTTPMaxRetries,
+		"Retries of idempotent upstream API requests, negative to disable")
+	fs.StringVar(&o.HTTPProxy, "http-proxy", os.Getenv("BOT_HTTP_PROXY"),
+		"HTTP(S) proxy for upstream API requests, defaults to HTTPS_PROXY/HTTP_PROXY")
+	fs.StringVar(&o.EncryptionKey, "encryption-key", os.Getenv("BOT_ENCRYPTION_KEY"),
+		"Comma separated <id>:<base64 key> AES-256 keys to encrypt tokens with, the first is used for new values")
+	fs.StringVar(&o.KMSKeyID, "kms-key-id", os.Getenv("BOT_KMS_KEY_ID"),
+		"AWS KMS key to encrypt tokens with, instead of --encryption-key")
+	fs.BoolVar(&o.EncryptExisting, "encrypt-existing", false, "Encrypt plaintext tokens already in the database and exit")
+	var botAdmins string
+	fs.StringVar(&botAdmins, "bot-admins", os.Getenv("BOT_ADMINS"),
+		"Comma separated Keybase usernames allowed to run admin commands")
 
 	awsOpts := &AWSOptions{}
 	fs.StringVar(&awsOpts.AWSRegion, "aws-region", os.Getenv("BOT_AWS_REGION"), "AWS region for cloudwatch logs, optional")
@@ -60,9 +137,76 @@ func (o *Options) Parse(fs *flag.FlagSet, argv []string) error {
 	if err := fs.Parse(argv[1:]); err != nil {
 		return err
 	}
+	for _, username := range strings.Split(botAdmins, ",") {
+		if username = strings.TrimSpace(username); username != "" {
+			o.BotAdmins = append(o.BotAdmins, username)
+		}
+	}
 	return nil
 }
 
+func (o *Options) DBPoolOptions() DBPoolOptions {
+	return DBPoolOptions{
+		MaxOpenConns:       o.DBMaxOpenConns,
+		MaxIdleConns:       o.DBMaxIdleConns,
+		ConnMaxLifetime:    o.DBConnMaxLifetime,
+		SlowQueryThreshold: o.DBSlowQuery,
+	}
+}
+
+func (o *Options) HTTPClientOptions() HTTPClientOptions {
+	return HTTPClientOptions{
+		Timeout:    o.HTTPTimeout,
+		MaxRetries: o.HTTPMaxRetries,
+		ProxyURL:   o.HTTPProxy,
+	}
+}
+
+// FieldCipher returns the cipher for the configured encryption key, or nil
+// if none is configured.
+func (o *Options) FieldCipher() (*FieldCipher, error) {
+	switch {
+	case o.EncryptionKey != "" && o.KMSKeyID != "":
+		return nil, fmt.Errorf("only one of --encryption-key and --kms-key-id may be set")
+	case o.EncryptionKey != "":
+		keys, err := NewStaticKeyEncrypter(o.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		return NewFieldCipher(keys), nil
+	case o.KMSKeyID != "":
+		var region string
+		if o.AWSOpts != nil {
+			region = o.AWSOpts.AWSRegion
+		}
+		keys, err := NewKMSKeyEncrypter(region, o.KMSKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return NewFieldCipher(keys), nil
+	default:
+		return nil, nil
+	}
+}
+
+func (o *Options) CommandRateLimiter() *RateLimiter {
+	return NewRateLimiter(o.CommandRateLimit, o.CommandRateBurst)
+}
+
+// SecretsProvider returns the configured secrets backend, defaultKBFSRoot is
+// the bot's KBFS folder used when no secrets path is given.
+func (o *Options) SecretsProvider(defaultKBFSRoot string) (SecretsProvider, error) {
+	path := o.SecretsPath
+	backend := SecretsBackendType(o.SecretsBackend)
+	if path == "" && (backend == "" || backend == KBFSSecretsBackend) {
+		path = defaultKBFSRoot
+	}
+	return NewSecretsProvider(backend, path, kbchat.RunOptions{
+		KeybaseLocation: o.KeybaseLocation,
+		HomeDir:         o.Home,
+	})
+}
+
 func (o *Options) Command(args ...string) *exec.Cmd {
 	return kbchat.RunOptions{
 		KeybaseLocation: o.KeybaseLocation,
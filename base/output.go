@@ -1,6 +1,7 @@
 package base
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
@@ -12,6 +13,8 @@ import (
 type ChatDebugOutputConfig struct {
 	KBC           *kbchat.API
 	ErrReportConv string
+	// ConvGC, if set, cleans up after conversations sends fail for good
+	ConvGC *ConvGC
 }
 
 func NewChatDebugOutputConfig(kbc *kbchat.API, errReportConv string) *ChatDebugOutputConfig {
@@ -22,8 +25,9 @@ func NewChatDebugOutputConfig(kbc *kbchat.API, errReportConv string) *ChatDebugO
 }
 
 type DebugOutput struct {
-	config *ChatDebugOutputConfig
-	name   string
+	config  *ChatDebugOutputConfig
+	name    string
+	traceID string
 }
 
 func NewDebugOutput(name string, config *ChatDebugOutputConfig) *DebugOutput {
@@ -37,14 +41,44 @@ func (d *DebugOutput) Config() *ChatDebugOutputConfig {
 	return d.config
 }
 
+// WithTraceID returns a copy of d which tags its logs and error reports with
+// traceID.
+func (d *DebugOutput) WithTraceID(traceID string) *DebugOutput {
+	return &DebugOutput{
+		name:    d.name,
+		config:  d.config,
+		traceID: traceID,
+	}
+}
+
+// WithContext is WithTraceID for the trace ID attached to ctx, if any.
+func (d *DebugOutput) WithContext(ctx context.Context) *DebugOutput {
+	return d.WithTraceID(TraceIDFromContext(ctx))
+}
+
+func (d *DebugOutput) TraceID() string {
+	return d.traceID
+}
+
+func (d *DebugOutput) prefix() string {
+	if d.traceID == "" {
+		return d.name
+	}
+	return fmt.Sprintf("%s [trace=%s]", d.name, d.traceID)
+}
+
 func (d *DebugOutput) Debug(format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("%s: %s\n", d.name, msg)
+	fmt.Printf("%s: %s\n", d.prefix(), msg)
 }
 
 func (d *DebugOutput) Errorf(msg string, args ...interface{}) {
 	d.Debug(msg, args...)
-	msg = fmt.Sprintf("```%s```", msg)
+	if d.traceID != "" {
+		msg = fmt.Sprintf("```[trace=%s] %s```", d.traceID, msg)
+	} else {
+		msg = fmt.Sprintf("```%s```", msg)
+	}
 	d.Report(msg, args...)
 }
 
@@ -77,6 +111,8 @@ func (d *DebugOutput) ChatErrorf(convID chat1.ConvIDStr, msg string, args ...int
 
 func (d *DebugOutput) ChatEcho(convID chat1.ConvIDStr, msg string, args ...interface{}) {
 	if _, err := d.config.KBC.SendMessageByConvID(convID, msg, args...); err != nil {
+		DefaultMetrics.CounterInc("keybase_bot_chat_api_errors_total", "Failed chat API sends.")
+		d.CollectGoneConv(convID, err)
 		if err := GetNonFatalChatError(err); err != nil {
 			d.Debug("ChatEcho: failed to send echo message: %s", err)
 			return
@@ -85,12 +121,21 @@ func (d *DebugOutput) ChatEcho(convID chat1.ConvIDStr, msg string, args ...inter
 	}
 }
 
+// CollectGoneConv hands a failed send to convID to the configured ConvGC, it
+// returns whether the error means the conversation is gone.
+func (d *DebugOutput) CollectGoneConv(convID chat1.ConvIDStr, err error) bool {
+	if d.config == nil || d.config.ConvGC == nil {
+		return IsConvGoneError(err)
+	}
+	return d.config.ConvGC.Collect(convID, err)
+}
+
 func (d *DebugOutput) Trace(err *error, format string, args ...interface{}) func() {
 	msg := fmt.Sprintf(format, args...)
 	start := time.Now()
-	fmt.Printf("+ %s: %s\n", d.name, msg)
+	fmt.Printf("+ %s: %s\n", d.prefix(), msg)
 	return func() {
-		fmt.Printf("- %s: %s -> %s [time=%v]\n", d.name, msg, ErrToOK(err), time.Since(start))
+		fmt.Printf("- %s: %s -> %s [time=%v]\n", d.prefix(), msg, ErrToOK(err), time.Since(start))
 	}
 }
 
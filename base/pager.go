@@ -0,0 +1,250 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	DefaultPageSize = 10
+	// how long `!next` and `!prev` keep working after a list is sent
+	pagerTTL           = 30 * time.Minute
+	pagerMaxPageLength = sendQueueMaxMessageLength
+
+	PagerNextCommand  = "!next"
+	PagerPrevCommand  = "!prev"
+	PagerNextReaction = ":arrow_right:"
+	PagerPrevReaction = ":arrow_left:"
+)
+
+// PagedList is a long chat response to split into pages.
+type PagedList struct {
+	// Header is repeated at the top of every page
+	Header string
+	Items  []string
+	// Separator joins items on a page, defaults to a newline
+	Separator string
+	// PageSize is the maximum number of items per page, defaults to
+	// DefaultPageSize. Pages are also cut short to fit in a chat message.
+	PageSize int
+}
+
+// Paginate splits items into pages of at most pageSize items, and at most
+// maxLength characters when joined with sep. An item longer than maxLength
+// gets a page of its own.
+func Paginate(items []string, pageSize, maxLength int, sep string) (pages [][]string) {
+	var page []string
+	length := 0
+	for _, item := range items {
+		if len(page) > 0 && (len(page) >= pageSize || length+len(sep)+len(item) > maxLength) {
+			pages = append(pages, page)
+			page, length = nil, 0
+		}
+		if len(page) > 0 {
+			length += len(sep)
+		}
+		page = append(page, item)
+		length += len(item)
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+type pagedResult struct {
+	pages   []string
+	current int
+	// page index of each message sent for this result
+	msgIDs  map[chat1.MessageID]int
+	expires time.Time
+}
+
+// Pager sends long lists one page at a time. Users page through the latest
+// list in a conversation with `!next` and `!prev`, or by reacting to a page
+// with :arrow_right: or :arrow_left: which edits that page in place. The
+// Server routes those follow-ups once the pager is registered with
+// RegisterPager.
+type Pager struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats   *StatsRegistry
+	results map[chat1.ConvIDStr]*pagedResult
+}
+
+func NewPager(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig) *Pager {
+	return &Pager{
+		DebugOutput: NewDebugOutput("Pager", debugConfig),
+		stats:       stats.SetPrefix("Pager"),
+		results:     make(map[chat1.ConvIDStr]*pagedResult),
+	}
+}
+
+// Send sends the first page of list to convID. Lists which fit on one page
+// are sent as is, without any paging hints.
+func (p *Pager) Send(convID chat1.ConvIDStr, list PagedList) error {
+	sep := list.Separator
+	if sep == "" {
+		sep = "\n"
+	}
+	pageSize := list.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	maxLength := pagerMaxPageLength - len(list.Header)
+	var pages []string
+	for _, page := range Paginate(list.Items, pageSize, maxLength, sep) {
+		body := strings.Join(page, sep)
+		if list.Header != "" {
+			body = list.Header + "\n" + body
+		}
+		pages = append(pages, body)
+	}
+	switch len(pages) {
+	case 0:
+		if list.Header != "" {
+			p.ChatEcho(convID, "%s", list.Header)
+		}
+		return nil
+	case 1:
+		p.ChatEcho(convID, "%s", pages[0])
+		return nil
+	}
+
+	p.stats.Count("Send - paged")
+	result := &pagedResult{
+		pages:   pages,
+		msgIDs:  make(map[chat1.MessageID]int),
+		expires: time.Now().Add(pagerTTL),
+	}
+	p.Lock()
+	p.expireLocked()
+	p.results[convID] = result
+	p.Unlock()
+	return p.sendPage(convID, result, 0)
+}
+
+func (p *Pager) expireLocked() {
+	now := time.Now()
+	for convID, result := range p.results {
+		if now.After(result.expires) {
+			delete(p.results, convID)
+		}
+	}
+}
+
+func (p *Pager) getResult(convID chat1.ConvIDStr) *pagedResult {
+	p.Lock()
+	defer p.Unlock()
+	p.expireLocked()
+	return p.results[convID]
+}
+
+func (p *Pager) renderPage(result *pagedResult, index int) string {
+	var hints []string
+	if index > 0 {
+		hints = append(hints, fmt.Sprintf("`%s` or %s for the previous page", PagerPrevCommand, PagerPrevReaction))
+	}
+	if index < len(result.pages)-1 {
+		hints = append(hints, fmt.Sprintf("`%s` or %s for the next page", PagerNextCommand, PagerNextReaction))
+	}
+	return fmt.Sprintf("%s\n\n_Page %d of %d, %s._", result.pages[index], index+1, len(result.pages),
+		strings.Join(hints, ", "))
+}
+
+func (p *Pager) sendPage(convID chat1.ConvIDStr, result *pagedResult, index int) error {
+	res, err := p.Config().KBC.SendMessageByConvID(convID, "%s", p.renderPage(result, index))
+	if err != nil {
+		p.CollectGoneConv(convID, err)
+		if err := GetNonFatalChatError(err); err != nil {
+			p.Debug("sendPage: unable to send: %v", err)
+			return nil
+		}
+		return err
+	}
+	p.Lock()
+	result.current = index
+	result.expires = time.Now().Add(pagerTTL)
+	if res.Result.MessageID != nil {
+		result.msgIDs[*res.Result.MessageID] = index
+	}
+	p.Unlock()
+	if res.Result.MessageID != nil {
+		for _, reaction := range []string{PagerPrevReaction, PagerNextReaction} {
+			if _, err := p.Config().KBC.ReactByConvID(convID, *res.Result.MessageID, reaction); err != nil {
+				p.Debug("sendPage: unable to react: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// HandleMessage handles paging follow-ups, it returns false for anything
+// else, including `!next` in a conversation without a list to page through.
+func (p *Pager) HandleMessage(msg chat1.MsgSummary) (handled bool, err error) {
+	switch {
+	case msg.Content.Text != nil:
+		var delta int
+		switch strings.TrimSpace(msg.Content.Text.Body) {
+		case PagerNextCommand:
+			delta = 1
+		case PagerPrevCommand:
+			delta = -1
+		default:
+			return false, nil
+		}
+		result := p.getResult(msg.ConvID)
+		if result == nil {
+			return false, nil
+		}
+		p.Lock()
+		index := result.current + delta
+		p.Unlock()
+		if index < 0 || index >= len(result.pages) {
+			p.ChatEcho(msg.ConvID, "There are no more pages.")
+			return true, nil
+		}
+		p.stats.Count("HandleMessage - command")
+		return true, p.sendPage(msg.ConvID, result, index)
+	case msg.Content.Reaction != nil:
+		var delta int
+		switch msg.Content.Reaction.Body {
+		case PagerNextReaction:
+			delta = 1
+		case PagerPrevReaction:
+			delta = -1
+		default:
+			return false, nil
+		}
+		result := p.getResult(msg.ConvID)
+		if result == nil {
+			return false, nil
+		}
+		target := msg.Content.Reaction.MessageID
+		p.Lock()
+		index, isPage := result.msgIDs[target]
+		index += delta
+		inRange := isPage && index >= 0 && index < len(result.pages)
+		if inRange {
+			result.msgIDs[target] = index
+			result.current = index
+			result.expires = time.Now().Add(pagerTTL)
+		}
+		p.Unlock()
+		if !inRange {
+			// a reaction to a message that isn't a page, or past either end
+			return isPage, nil
+		}
+		p.stats.Count("HandleMessage - reaction")
+		if _, err := p.Config().KBC.EditByConvID(msg.ConvID, target, p.renderPage(result, index)); err != nil {
+			return true, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,40 @@
+package base
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"golang.org/x/oauth2"
+)
+
+// pkceVerifierBytes gives a 43 character verifier, the minimum RFC 7636
+// allows
+const pkceVerifierBytes = 32
+
+// NewPKCEVerifier returns a random PKCE code verifier, which is kept with the
+// OAuth state and sent with the token exchange.
+func NewPKCEVerifier() (string, error) {
+	b, err := RandBytes(pkceVerifierBytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// PKCEChallengeOptions adds the S256 challenge for verifier to an auth URL.
+func PKCEChallengeOptions(verifier string) []oauth2.AuthCodeOption {
+	sum := sha256.Sum256([]byte(verifier))
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+// PKCEExchangeOptions proves the token exchange comes from whoever made the
+// auth URL, no options are added without a verifier.
+func PKCEExchangeOptions(verifier string) []oauth2.AuthCodeOption {
+	if verifier == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", verifier)}
+}
@@ -0,0 +1,110 @@
+package base
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCommandRateLimit = 30
+	DefaultCommandRateBurst = 10
+)
+
+type tokenBucket struct {
+	tokens   float64
+	last     time.Time
+	notified bool
+}
+
+// RateLimiter is a keyed token bucket, each key (e.g. a username or
+// conversation ID) may burst up to burst events and is then refilled at
+// perMinute events per minute. A nil RateLimiter allows everything.
+type RateLimiter struct {
+	sync.Mutex
+
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns nil, disabling rate limiting, if perMinute or burst
+// are not positive.
+func NewRateLimiter(perMinute float64, burst int) *RateLimiter {
+	if perMinute <= 0 || burst <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		perSecond: perMinute / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *RateLimiter) refill(bucket *tokenBucket, now time.Time) {
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.perSecond)
+	bucket.last = now
+}
+
+// sweep drops buckets which have refilled completely so idle keys don't
+// accumulate.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		l.refill(bucket, now)
+		if bucket.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Allow takes a token for key. If none are available allowed is false and
+// notify is true only for the first denial since key was last allowed, so
+// callers can send a single "slow down" response rather than one per event.
+func (l *RateLimiter) Allow(key string) (allowed, notify bool) {
+	if l == nil {
+		return true, false
+	}
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	l.refill(bucket, now)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.notified = false
+		return true, false
+	}
+	notify = !bucket.notified
+	bucket.notified = true
+	return false, notify
+}
+
+// RetryAfter is how long until key has a token available again.
+func (l *RateLimiter) RetryAfter(key string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		return 0
+	}
+	l.refill(bucket, time.Now())
+	if bucket.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / l.perSecond * float64(time.Second))
+}
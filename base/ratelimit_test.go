@@ -0,0 +1,58 @@
+package base_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+func TestRateLimiter(t *testing.T) {
+	require.Nil(t, base.NewRateLimiter(0, 10))
+	require.Nil(t, base.NewRateLimiter(30, 0))
+	var disabled *base.RateLimiter
+	allowed, _ := disabled.Allow("alice")
+	require.True(t, allowed)
+
+	// a token every 100ms
+	limiter := base.NewRateLimiter(600, 2)
+	for i := 0; i < 2; i++ {
+		allowed, notify := limiter.Allow("alice")
+		require.True(t, allowed)
+		require.False(t, notify)
+	}
+	allowed, notify := limiter.Allow("alice")
+	require.False(t, allowed)
+	require.True(t, notify)
+	// only the first denial notifies
+	allowed, notify = limiter.Allow("alice")
+	require.False(t, allowed)
+	require.False(t, notify)
+	retryAfter := limiter.RetryAfter("alice")
+	require.True(t, retryAfter > 0 && retryAfter <= 100*time.Millisecond, "%v", retryAfter)
+
+	// keys are limited separately
+	allowed, _ = limiter.Allow("bob")
+	require.True(t, allowed)
+	require.Zero(t, limiter.RetryAfter("bob"))
+
+	// the bucket refills over time
+	time.Sleep(retryAfter + 10*time.Millisecond)
+	allowed, notify = limiter.Allow("alice")
+	require.True(t, allowed)
+	require.False(t, notify)
+	allowed, notify = limiter.Allow("alice")
+	require.False(t, allowed)
+	require.True(t, notify)
+
+	// but never above the burst
+	time.Sleep(350 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		allowed, _ = limiter.Allow("alice")
+		require.True(t, allowed)
+	}
+	allowed, _ = limiter.Allow("alice")
+	require.False(t, allowed)
+}
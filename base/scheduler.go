@@ -0,0 +1,282 @@
+package base
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+// Task is a periodic job run by a Scheduler.
+type Task struct {
+	Name string
+	// Schedule is a cron expression, see ParseCron. It's evaluated in
+	// Location, UTC by default.
+	Schedule string
+	Location *time.Location
+	// Jitter delays each run by a random duration up to Jitter so bots
+	// sharing a schedule don't all hit an upstream API at once.
+	Jitter time.Duration
+	// RunOnStart runs the task once as soon as the scheduler starts.
+	RunOnStart bool
+	// Run is passed a context which is cancelled on Shutdown.
+	Run func(ctx context.Context) error
+}
+
+type scheduledTask struct {
+	Task
+	schedule *CronSchedule
+	running  bool
+	lastRun  time.Time
+	lastErr  error
+}
+
+// Scheduler runs Tasks on cron schedules. A run which is still going when
+// the task is next due is skipped rather than overlapped, and Shutdown
+// cancels running tasks and waits for them to return. With a LeaderElector
+// scheduled runs only happen on the leading instance.
+type Scheduler struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats      *StatsRegistry
+	leader     *LeaderElector
+	tasks      []*scheduledTask
+	ctx        context.Context
+	cancel     context.CancelFunc
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewScheduler(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		DebugOutput: NewDebugOutput("Scheduler", debugConfig),
+		stats:       stats.SetPrefix("Scheduler"),
+		ctx:         ctx,
+		cancel:      cancel,
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+// SetLeaderElector makes scheduled runs skip instances which aren't the
+// leader, it must be called before Run. RunNow still runs tasks anywhere.
+func (s *Scheduler) SetLeaderElector(leader *LeaderElector) {
+	s.Lock()
+	defer s.Unlock()
+	s.leader = leader
+}
+
+// Add registers a task, it must be called before Run.
+func (s *Scheduler) Add(task Task) error {
+	schedule, err := ParseCron(task.Schedule)
+	if err != nil {
+		return fmt.Errorf("task %s: %v", task.Name, err)
+	}
+	if task.Location == nil {
+		task.Location = time.UTC
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, existing := range s.tasks {
+		if existing.Name == task.Name {
+			return fmt.Errorf("task %s already registered", task.Name)
+		}
+	}
+	s.tasks = append(s.tasks, &scheduledTask{Task: task, schedule: schedule})
+	return nil
+}
+
+// Run starts every registered task and blocks until Shutdown.
+func (s *Scheduler) Run() error {
+	s.Lock()
+	shutdownCh := s.shutdownCh
+	tasks := s.tasks
+	s.Unlock()
+	if shutdownCh == nil {
+		return nil
+	}
+	for _, task := range tasks {
+		task := task
+		s.wg.Add(1)
+		GoWithRecover(s.DebugOutput, func() {
+			defer s.wg.Done()
+			s.loop(shutdownCh, task)
+		})
+	}
+	<-shutdownCh
+	return nil
+}
+
+func (s *Scheduler) loop(shutdownCh chan struct{}, task *scheduledTask) {
+	if task.RunOnStart {
+		select {
+		case <-shutdownCh:
+			return
+		case <-s.leader.Elected():
+		}
+		s.startIfLeader(task)
+	}
+	for {
+		next := task.schedule.Next(time.Now().In(task.Location))
+		if next.IsZero() {
+			s.Errorf("loop: task %s never runs again", task.Name)
+			return
+		}
+		wait := time.Until(next)
+		if task.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(task.Jitter)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-shutdownCh:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.startIfLeader(task)
+		}
+	}
+}
+
+func (s *Scheduler) startIfLeader(task *scheduledTask) bool {
+	if !s.leader.IsLeader() {
+		s.stats.Count(task.Name + " - not leader")
+		return false
+	}
+	return s.start(task)
+}
+
+// start runs task in the background unless its previous run is still going.
+func (s *Scheduler) start(task *scheduledTask) bool {
+	s.Lock()
+	if s.shutdownCh == nil {
+		s.Unlock()
+		return false
+	}
+	if task.running {
+		s.Unlock()
+		s.stats.Count(task.Name + " - skipped")
+		DefaultMetrics.CounterInc("keybase_bot_task_skipped_total",
+			"Scheduled task runs skipped because the previous run was still going.", "task", task.Name)
+		s.Debug("start: %s is still running, skipping", task.Name)
+		return false
+	}
+	task.running = true
+	s.Unlock()
+
+	s.wg.Add(1)
+	GoWithRecover(s.DebugOutput, func() {
+		defer s.wg.Done()
+		start := time.Now()
+		err := RecoverToError(s.DebugOutput, "task "+task.Name, func() error { return task.Run(s.ctx) })
+		s.Lock()
+		task.running = false
+		task.lastRun = start
+		task.lastErr = err
+		s.Unlock()
+
+		result := "success"
+		if err != nil {
+			result = "error"
+			s.Errorf("task %s failed: %v", task.Name, err)
+		}
+		s.stats.Count(task.Name + " - " + result)
+		s.stats.Value(task.Name+" - duration - seconds", time.Since(start).Seconds())
+		DefaultMetrics.CounterInc("keybase_bot_task_runs_total", "Scheduled task runs.",
+			"task", task.Name, "result", result)
+		DefaultMetrics.ObserveSince("keybase_bot_task_duration_seconds", "Scheduled task latencies.",
+			start, "task", task.Name)
+	})
+	return true
+}
+
+// RunNow starts a task immediately, outside its schedule. It returns false
+// if the task is already running.
+func (s *Scheduler) RunNow(name string) (started bool, err error) {
+	s.Lock()
+	var found *scheduledTask
+	for _, task := range s.tasks {
+		if task.Name == name {
+			found = task
+		}
+	}
+	s.Unlock()
+	if found == nil {
+		return false, fmt.Errorf("unknown task %s", name)
+	}
+	return s.start(found), nil
+}
+
+// Shutdown stops scheduling, cancels running tasks and waits for them.
+func (s *Scheduler) Shutdown() (err error) {
+	defer s.Trace(&err, "Shutdown")()
+	s.Lock()
+	if s.shutdownCh != nil {
+		close(s.shutdownCh)
+		s.shutdownCh = nil
+	}
+	s.Unlock()
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+// AdminCommands lets bot admins check on tasks and run them by hand.
+func (s *Scheduler) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "tasks",
+			Usage:       "[run <task>]",
+			Description: "List scheduled tasks or run one now",
+			Handler:     s.handleAdminTasks,
+		},
+	}
+}
+
+func (s *Scheduler) handleAdminTasks(msg chat1.MsgSummary, args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "run":
+		started, err := s.RunNow(args[1])
+		if err != nil {
+			s.ChatEcho(msg.ConvID, "%s", err)
+			return nil
+		} else if !started {
+			s.ChatEcho(msg.ConvID, "`%s` is already running", args[1])
+			return nil
+		}
+		s.ChatEcho(msg.ConvID, "OK! Started `%s`", args[1])
+		return nil
+	case len(args) != 0:
+		s.ChatEcho(msg.ConvID, "Usage: `tasks [run <task>]`")
+		return nil
+	}
+	s.Lock()
+	lines := make([]string, 0, len(s.tasks))
+	now := time.Now()
+	for _, task := range s.tasks {
+		status := "idle"
+		if task.running {
+			status = "running"
+		}
+		line := fmt.Sprintf("%s (%s) %s, next %s", task.Name, task.Schedule, status,
+			task.schedule.Next(now.In(task.Location)).Format(time.RFC3339))
+		if !task.lastRun.IsZero() {
+			line += fmt.Sprintf(", last %s", task.lastRun.Format(time.RFC3339))
+			if task.lastErr != nil {
+				line += fmt.Sprintf(" failed: %v", task.lastErr)
+			}
+		}
+		lines = append(lines, line)
+	}
+	s.Unlock()
+	if len(lines) == 0 {
+		s.ChatEcho(msg.ConvID, "No scheduled tasks")
+		return nil
+	}
+	s.ChatEcho(msg.ConvID, "```%s```", strings.Join(lines, "\n"))
+	return nil
+}
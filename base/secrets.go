@@ -0,0 +1,176 @@
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+)
+
+type SecretsBackendType string
+
+const (
+	KBFSSecretsBackend  SecretsBackendType = "kbfs"
+	EnvSecretsBackend   SecretsBackendType = "env"
+	FileSecretsBackend  SecretsBackendType = "file"
+	VaultSecretsBackend SecretsBackendType = "vault"
+)
+
+// SecretsProvider looks up bot credentials by name, e.g. `credentials.json`
+// or `login.secret`.
+type SecretsProvider interface {
+	GetSecret(name string) ([]byte, error)
+}
+
+// EnvSecretsProvider reads secrets from environment variables, the name is
+// upper cased with non alphanumeric characters replaced by underscores and
+// prefixed with Prefix, so `credentials.json` is read from
+// `BOT_SECRET_CREDENTIALS_JSON`.
+type EnvSecretsProvider struct {
+	Prefix string
+}
+
+func NewEnvSecretsProvider() *EnvSecretsProvider {
+	return &EnvSecretsProvider{Prefix: "BOT_SECRET_"}
+}
+
+func (e *EnvSecretsProvider) envName(name string) string {
+	return e.Prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'):
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+func (e *EnvSecretsProvider) GetSecret(name string) ([]byte, error) {
+	value, ok := os.LookupEnv(e.envName(name))
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found, set %s", name, e.envName(name))
+	}
+	return []byte(value), nil
+}
+
+// FileSecretsProvider reads secrets from files in Dir, such as a mounted
+// Kubernetes secret.
+type FileSecretsProvider struct {
+	Dir string
+}
+
+func (f *FileSecretsProvider) GetSecret(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(f.Dir, name))
+}
+
+// KBFSSecretsProvider reads secrets from Root in KBFS with `keybase fs read`.
+type KBFSSecretsProvider struct {
+	Root       string
+	RunOptions kbchat.RunOptions
+}
+
+func (k *KBFSSecretsProvider) GetSecret(name string) ([]byte, error) {
+	path := filepath.Join(k.Root, name)
+	cmd := k.RunOptions.Command("fs", "read", path)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	fmt.Printf("Running `keybase fs read` on %q and waiting for it to finish...\n", path)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not read %s: %v", name, err)
+	}
+	return out.Bytes(), nil
+}
+
+// VaultSecretsProvider reads the keys of a single HashiCorp Vault secret at
+// Path, e.g. `secret/data/gcalbot`, supporting both KV v1 and v2 engines.
+type VaultSecretsProvider struct {
+	Addr   string
+	Token  string
+	Path   string
+	client *http.Client
+}
+
+func NewVaultSecretsProvider(addr, token, path string) *VaultSecretsProvider {
+	return &VaultSecretsProvider{
+		Addr:   strings.TrimSuffix(addr, "/"),
+		Token:  token,
+		Path:   strings.Trim(path, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *VaultSecretsProvider) GetSecret(name string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/v1/%s", v.Addr, v.Path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s for %s", resp.Status, v.Path)
+	}
+
+	var res struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	data := res.Data
+	// KV v2 nests the secret under data.data next to data.metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	switch value := data[name].(type) {
+	case string:
+		return []byte(value), nil
+	case nil:
+		return nil, fmt.Errorf("secret %s not found at %s", name, v.Path)
+	default:
+		// structured values, e.g. credentials stored as JSON objects
+		return json.Marshal(value)
+	}
+}
+
+// NewSecretsProvider builds the provider for backend. path is the directory
+// for the file and kbfs backends and the secret path for vault, which also
+// requires VAULT_ADDR and VAULT_TOKEN.
+func NewSecretsProvider(backend SecretsBackendType, path string, runOptions kbchat.RunOptions) (SecretsProvider, error) {
+	switch backend {
+	case KBFSSecretsBackend, "":
+		if path == "" {
+			return nil, fmt.Errorf("a KBFS secrets path must be specified")
+		}
+		return &KBFSSecretsProvider{Root: path, RunOptions: runOptions}, nil
+	case EnvSecretsBackend:
+		return NewEnvSecretsProvider(), nil
+	case FileSecretsBackend:
+		if path == "" {
+			return nil, fmt.Errorf("a secrets directory must be specified")
+		}
+		return &FileSecretsProvider{Dir: path}, nil
+	case VaultSecretsBackend:
+		addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+		if addr == "" || token == "" || path == "" {
+			return nil, fmt.Errorf("VAULT_ADDR, VAULT_TOKEN and a secrets path must be specified")
+		}
+		return NewVaultSecretsProvider(addr, token, path), nil
+	default:
+		return nil, fmt.Errorf("unknown secrets backend %q", backend)
+	}
+}
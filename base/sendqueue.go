@@ -0,0 +1,165 @@
+package base
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	// messages arriving within this window of each other are sent together
+	sendQueueCoalesceDelay = 250 * time.Millisecond
+	// stay under the chat API's 10k character message limit when coalescing
+	sendQueueMaxMessageLength = 9000
+	sendQueueMaxAttempts      = 5
+	sendQueueInitialBackoff   = time.Second
+	sendQueueMaxBackoff       = 30 * time.Second
+)
+
+// ChatSendQueue sends chat messages in the order they were queued for each
+// conversation. Bursts, like a webhook fanning out a dozen events, are
+// coalesced into as few messages as possible and sends are retried with
+// backoff when the chat API pushes back. Conversations don't block each
+// other.
+type ChatSendQueue struct {
+	*DebugOutput
+	sync.Mutex
+
+	stats      *StatsRegistry
+	pending    map[chat1.ConvIDStr][]string
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+func NewChatSendQueue(stats *StatsRegistry, debugConfig *ChatDebugOutputConfig) *ChatSendQueue {
+	return &ChatSendQueue{
+		DebugOutput: NewDebugOutput("ChatSendQueue", debugConfig),
+		stats:       stats.SetPrefix("ChatSendQueue"),
+		pending:     make(map[chat1.ConvIDStr][]string),
+		shutdownCh:  make(chan struct{}),
+	}
+}
+
+// Send formats and queues a message for convID, it returns immediately.
+// Once the queue is shut down messages are sent synchronously.
+func (q *ChatSendQueue) Send(convID chat1.ConvIDStr, msg string, args ...interface{}) {
+	body := msg
+	if len(args) > 0 {
+		body = fmt.Sprintf(msg, args...)
+	}
+	q.Lock()
+	defer q.Unlock()
+	if q.shutdownCh == nil {
+		q.ChatEcho(convID, "%s", body)
+		return
+	}
+	queued, running := q.pending[convID]
+	q.pending[convID] = append(queued, body)
+	q.stats.Count("Send")
+	if running {
+		return
+	}
+	shutdownCh := q.shutdownCh
+	q.wg.Add(1)
+	GoWithRecover(q.DebugOutput, func() {
+		defer q.wg.Done()
+		q.drain(shutdownCh, convID)
+	})
+}
+
+// nextBatch pops as many queued messages for convID as fit in one chat
+// message, removing the conversation once it's empty so a new worker is
+// started for the next Send.
+func (q *ChatSendQueue) nextBatch(convID chat1.ConvIDStr) (body string, count int) {
+	q.Lock()
+	defer q.Unlock()
+	queued := q.pending[convID]
+	if len(queued) == 0 {
+		delete(q.pending, convID)
+		return "", 0
+	}
+	var batch []string
+	length := 0
+	for _, msg := range queued {
+		if len(batch) > 0 && length+len(msg)+2 > sendQueueMaxMessageLength {
+			break
+		}
+		batch = append(batch, msg)
+		length += len(msg) + 2
+	}
+	q.pending[convID] = queued[len(batch):]
+	return strings.Join(batch, "\n\n"), len(batch)
+}
+
+func (q *ChatSendQueue) drain(shutdownCh chan struct{}, convID chat1.ConvIDStr) {
+	select {
+	case <-shutdownCh:
+	case <-time.After(sendQueueCoalesceDelay):
+	}
+	for {
+		body, count := q.nextBatch(convID)
+		if count == 0 {
+			return
+		}
+		if count > 1 {
+			q.stats.CountMult("drain - coalesced", count)
+		}
+		q.sendWithBackoff(shutdownCh, convID, body)
+	}
+}
+
+func isChatRateLimitError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "rate limit") || strings.Contains(msg, "ratelimit") ||
+		strings.Contains(msg, "too many requests")
+}
+
+func (q *ChatSendQueue) sendWithBackoff(shutdownCh chan struct{}, convID chat1.ConvIDStr, body string) {
+	backoff := sendQueueInitialBackoff
+	for attempt := 1; ; attempt++ {
+		_, err := q.Config().KBC.SendMessageByConvID(convID, "%s", body)
+		if err == nil {
+			q.stats.Count("sendWithBackoff - success")
+			return
+		}
+		DefaultMetrics.CounterInc("keybase_bot_chat_api_errors_total", "Failed chat API sends.")
+		q.CollectGoneConv(convID, err)
+		if err := GetNonFatalChatError(err); err != nil {
+			q.Debug("sendWithBackoff: dropping message to %s: %s", convID, err)
+			return
+		}
+		if attempt >= sendQueueMaxAttempts {
+			q.stats.Count("sendWithBackoff - failed")
+			q.Errorf("sendWithBackoff: failed to send message to %s after %d attempts: %s", convID, attempt, err)
+			return
+		}
+		if isChatRateLimitError(err) {
+			q.stats.Count("sendWithBackoff - rate limited")
+		}
+		q.Debug("sendWithBackoff: attempt %d to %s failed, retrying in %v: %s", attempt, convID, backoff, err)
+		select {
+		case <-shutdownCh:
+			// keep retrying without waiting so shutdown isn't held up
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > sendQueueMaxBackoff {
+			backoff = sendQueueMaxBackoff
+		}
+	}
+}
+
+// Shutdown flushes queued messages without waiting to coalesce or back off.
+func (q *ChatSendQueue) Shutdown() (err error) {
+	defer q.Trace(&err, "Shutdown")()
+	q.Lock()
+	if q.shutdownCh != nil {
+		close(q.shutdownCh)
+		q.shutdownCh = nil
+	}
+	q.Unlock()
+	q.wg.Wait()
+	return nil
+}
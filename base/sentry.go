@@ -0,0 +1,96 @@
+package base
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SentryReporter sends events to Sentry's store API, it's configured with a
+// project DSN such as `https://<key>@o0.ingest.sentry.io/<project>`.
+type SentryReporter struct {
+	storeURL   string
+	authHeader string
+	serverName string
+	client     *http.Client
+}
+
+func NewSentryReporter(dsn string) (*SentryReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sentry DSN: %v", err)
+	}
+	projectID := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || projectID == "" {
+		return nil, fmt.Errorf("invalid sentry DSN: missing key or project")
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=managed-bots/1.0, sentry_key=%s", u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	hostname, _ := os.Hostname()
+	return &SentryReporter{
+		storeURL:   fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, projectID),
+		authHeader: auth,
+		serverName: hostname,
+		client:     &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+// Report sends message as an error level event, tags are indexed by Sentry
+// and extra is attached as additional data.
+func (s *SentryReporter) Report(message string, tags map[string]string, extra map[string]interface{}) error {
+	event := map[string]interface{}{
+		"event_id":    randomHex(16),
+		"timestamp":   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		"level":       "error",
+		"platform":    "go",
+		"server_name": s.serverName,
+		"message":     message,
+		"tags":        tags,
+		"extra":       extra,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.authHeader)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sentry returned %s", resp.Status)
+	}
+	return nil
+}
+
+var panicReporter struct {
+	sync.Mutex
+	sentry *SentryReporter
+}
+
+// SetSentryReporter reports recovered panics to Sentry, pass nil to disable.
+func SetSentryReporter(sentry *SentryReporter) {
+	panicReporter.Lock()
+	defer panicReporter.Unlock()
+	panicReporter.sentry = sentry
+}
+
+func getSentryReporter() *SentryReporter {
+	panicReporter.Lock()
+	defer panicReporter.Unlock()
+	return panicReporter.sentry
+}
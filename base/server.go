@@ -1,7 +1,6 @@
 package base
 
 import (
-	"database/sql"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -39,15 +38,27 @@ type Server struct {
 	multiDBDSN   string
 	multi        *multi
 	readSelf     bool
+	rateLimiter  *RateLimiter
+	startTime    time.Time
+	// advertised command names, e.g. `!gcal accounts connect`
+	commands []string
+
+	adminCommands map[string]AdminCommand
+	pausedConvs   map[chat1.ConvIDStr]bool
+	pager         *Pager
+	analytics     *Analytics
+	auditLog      *AuditLog
 
 	runOptions kbchat.RunOptions
+	dryRun     *DryRunRecorder
+	isDryRun   bool
 }
 
 func NewServer(
 	name, announcement string, awsOpts *AWSOptions, multiDBDSN string, readSelf bool,
 	runOptions kbchat.RunOptions,
 ) *Server {
-	return &Server{
+	s := &Server{
 		name:         name,
 		announcement: announcement,
 		awsOpts:      awsOpts,
@@ -55,8 +66,36 @@ func NewServer(
 		shutdownCh:   make(chan struct{}),
 		multiDBDSN:   multiDBDSN,
 		readSelf:     readSelf,
+		rateLimiter:  NewRateLimiter(DefaultCommandRateLimit, DefaultCommandRateBurst),
+		startTime:    time.Now(),
+		pausedConvs:  make(map[chat1.ConvIDStr]bool),
 		runOptions:   runOptions,
 	}
+	s.adminCommands = make(map[string]AdminCommand)
+	for _, cmd := range s.builtinAdminCommands() {
+		s.adminCommands[cmd.Name] = cmd
+	}
+	return s
+}
+
+// Configure applies the server settings from parsed options.
+func (s *Server) Configure(opts *Options) {
+	s.SetCommandRateLimiter(opts.CommandRateLimiter())
+	if err := SetHTTPClientOptions(opts.HTTPClientOptions()); err != nil {
+		s.Errorf("Configure: unable to configure HTTP clients: %v", err)
+	}
+	if opts.SentryDSN != "" {
+		sentry, err := NewSentryReporter(opts.SentryDSN)
+		if err != nil {
+			s.Errorf("Configure: unable to configure sentry: %v", err)
+		} else {
+			SetSentryReporter(sentry)
+		}
+	}
+	if len(opts.BotAdmins) > 0 {
+		s.SetBotAdmins(opts.BotAdmins)
+	}
+	SetMetricsAddr(opts.MetricsAddr)
 }
 
 func (s *Server) Name() string {
@@ -67,6 +106,42 @@ func (s *Server) SetBotAdmins(admins []string) {
 	s.botAdmins = admins
 }
 
+// SetCommandRateLimiter replaces the default per-user and per-conversation
+// command rate limits, a nil limiter disables rate limiting.
+func (s *Server) SetCommandRateLimiter(limiter *RateLimiter) {
+	s.rateLimiter = limiter
+}
+
+// RegisterPager routes `!next`, `!prev` and page reactions to pager before
+// the bot's handler sees them.
+func (s *Server) RegisterPager(pager *Pager) {
+	s.Lock()
+	defer s.Unlock()
+	s.pager = pager
+}
+
+// RegisterAnalytics records every command for this bot in analytics and adds a
+// usage report to `!bot admin stats`.
+func (s *Server) RegisterAnalytics(analytics *Analytics) {
+	s.Lock()
+	defer s.Unlock()
+	s.analytics = analytics
+}
+
+// RegisterAuditLog records every command for this bot and every admin command
+// handled in auditLog.
+func (s *Server) RegisterAuditLog(auditLog *AuditLog) {
+	s.Lock()
+	defer s.Unlock()
+	s.auditLog = auditLog
+}
+
+func (s *Server) getAuditLog() *AuditLog {
+	s.Lock()
+	defer s.Unlock()
+	return s.auditLog
+}
+
 func (s *Server) GoWithRecover(eg *errgroup.Group, f func() error) {
 	GoWithRecoverErrGroup(eg, s.DebugOutput, f)
 }
@@ -81,6 +156,11 @@ func (s *Server) Shutdown() (err error) {
 		if err := s.kbc.Shutdown(); err != nil {
 			return err
 		}
+		if s.dryRun != nil {
+			if err := s.dryRun.Shutdown(); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -115,24 +195,43 @@ func (s *Server) HandleSignals(shutdowners ...Shutdowner) (err error) {
 	return nil
 }
 
+// SetDryRun makes the bot log chat sends instead of posting them, see
+// DryRunRecorder. It must be called before Start.
+func (s *Server) SetDryRun(dryRun bool) {
+	s.isDryRun = dryRun
+}
+
 func (s *Server) Start(errReportConv string) (kbc *kbchat.API, err error) {
-	if s.kbc, err = kbchat.Start(s.runOptions); err != nil {
+	runOptions := s.runOptions
+	if s.isDryRun {
+		if s.dryRun, err = NewDryRunRecorder(); err != nil {
+			return nil, err
+		}
+		if runOptions, err = s.dryRun.RunOptions(runOptions); err != nil {
+			return nil, err
+		}
+	}
+	if s.kbc, err = kbchat.Start(runOptions); err != nil {
 		return s.kbc, err
 	}
 	debugConfig := NewChatDebugOutputConfig(s.kbc, errReportConv)
 	s.DebugOutput = NewDebugOutput("Server", debugConfig)
+	if s.isDryRun {
+		s.Debug("Start: dry run mode, chat sends are logged instead of posted")
+	}
 	if s.multiDBDSN != "" {
-		db, err := sql.Open("mysql", s.multiDBDSN)
+		db, err := OpenMySQLDB(s.multiDBDSN)
 		if err != nil {
 			s.Errorf("failed to connect to Multi MySQL: %s", err)
 			return nil, err
 		}
-		s.multi = newMulti(s.name, NewDB(db), debugConfig)
+		s.multi = newMulti(s.name, db, debugConfig)
 	}
 	return s.kbc, nil
 }
 
 func (s *Server) AnnounceAndAdvertise(advert kbchat.Advertisement, running string) (err error) {
+	s.setCommands(advert)
 	if _, err := s.kbc.AdvertiseCommands(advert); err != nil {
 		s.Errorf("advertise error: %s", err)
 		return err
@@ -148,6 +247,46 @@ func (s *Server) AnnounceAndAdvertise(advert kbchat.Advertisement, running strin
 	return SendByConvNameOrID(s.kbc, s.DebugOutput, s.announcement, running)
 }
 
+// setCommands remembers the commands in advert, only messages starting with
+// one of their prefixes are treated as commands to this bot.
+func (s *Server) setCommands(advert kbchat.Advertisement) {
+	var commands []string
+	for _, ad := range advert.Advertisements {
+		for _, cmd := range ad.Commands {
+			commands = append(commands, "!"+cmd.Name)
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.commands = commands
+}
+
+// ownsCommand reports whether body is addressed to this bot rather than
+// another bot in the conversation, i.e. it starts with the prefix of an
+// advertised command such as `!gcal`.
+func (s *Server) ownsCommand(body string) bool {
+	toks := strings.Fields(body)
+	if len(toks) == 0 {
+		return false
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, cmd := range s.commands {
+		if strings.Fields(cmd)[0] == toks[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// commandName names body for metrics and analytics by the advertised command
+// it starts with, see CommandMetricName.
+func (s *Server) commandName(body string) string {
+	s.Lock()
+	defer s.Unlock()
+	return CommandMetricName(body, s.commands)
+}
+
 func (s *Server) Listen(handler Handler) (err error) {
 	defer s.Trace(&err, "Listen")()
 	sub, err := s.kbc.Listen(kbchat.ListenOptions{Convs: true})
@@ -218,6 +357,11 @@ func (s *Server) listenForMsgs(shutdownCh chan struct{}, sub *kbchat.Subscriptio
 					s.Errorf("listenForMsgs: unable to handleStack: %v", err)
 				}
 				continue
+			case strings.HasPrefix(cmd, fmt.Sprintf("!%s", adminCmd(s.kbc.GetUsername()))):
+				if err := s.handleAdmin(msg); err != nil {
+					s.Errorf("listenForMsgs: unable to handleAdmin: %v", err)
+				}
+				continue
 			case strings.HasPrefix(cmd, fmt.Sprintf("!%s", feedbackCmd(s.kbc.GetUsername()))):
 				if err := s.handleFeedback(msg); err != nil {
 					s.Errorf("listenForMsgs: unable to handleFeedback: %v", err)
@@ -226,13 +370,82 @@ func (s *Server) listenForMsgs(shutdownCh chan struct{}, sub *kbchat.Subscriptio
 			}
 		}
 
-		err = handler.HandleCommand(msg)
+		if s.IsConvPaused(msg.ConvID) {
+			s.Debug("listenForMsgs: ignoring message, conversation paused")
+			continue
+		}
+
+		s.Lock()
+		pager := s.pager
+		analytics := s.analytics
+		auditLog := s.auditLog
+		s.Unlock()
+		if pager != nil {
+			handled, err := pager.HandleMessage(msg)
+			if err != nil {
+				s.Errorf("listenForMsgs: unable to page: %v", err)
+			}
+			if handled {
+				continue
+			}
+		}
+
+		var command string
+		var owned bool
+		if msg.Content.Text != nil {
+			command = s.commandName(msg.Content.Text.Body)
+			owned = s.ownsCommand(msg.Content.Text.Body)
+			if owned && !s.allowCommand(msg) {
+				auditLog.Record(msg, AuditResultRateLimited, nil, 0)
+				continue
+			}
+		}
+		start := time.Now()
+		err = RecoverToError(s.DebugOutput, "command "+command, func() error { return handler.HandleCommand(msg) })
+		if owned {
+			analytics.RecordCommand(msg.ConvID, msg.Sender.Username, command)
+		}
+		if command != "" {
+			DefaultMetrics.CounterInc("keybase_bot_commands_total", "Chat commands handled.", "command", command)
+			DefaultMetrics.ObserveSince("keybase_bot_command_duration_seconds", "Chat command latencies.",
+				start, "command", command)
+		}
+		result := AuditResultSuccess
 		switch err := err.(type) {
-		case nil, OAuthRequiredError:
+		case nil:
+		case OAuthRequiredError:
+			result = AuditResultOAuth
 		default:
+			result = AuditResultError
+			DefaultMetrics.CounterInc("keybase_bot_command_errors_total", "Chat commands which failed.",
+				"command", command)
 			s.ChatErrorf(msg.ConvID, "listenForMsgs: unable to HandleCommand: %v", err)
 		}
+		if owned {
+			auditLog.Record(msg, result, err, time.Since(start))
+		}
+	}
+}
+
+// allowCommand applies the command rate limits for both the sender and the
+// conversation, replying once when a limit is first hit.
+func (s *Server) allowCommand(msg chat1.MsgSummary) bool {
+	for _, key := range []string{"user:" + msg.Sender.Username, "conv:" + string(msg.ConvID)} {
+		allowed, notify := s.rateLimiter.Allow(key)
+		if allowed {
+			continue
+		}
+		DefaultMetrics.CounterInc("keybase_bot_rate_limited_total", "Chat commands dropped by rate limiting.")
+		if notify {
+			wait := s.rateLimiter.RetryAfter(key).Round(time.Second)
+			if wait < time.Second {
+				wait = time.Second
+			}
+			s.ChatEcho(msg.ConvID, "Whoa, slow down! Please wait %v before sending more commands.", wait)
+		}
+		return false
 	}
+	return true
 }
 
 func (s *Server) listenForConvs(shutdownCh chan struct{}, sub *kbchat.Subscription, handler Handler) error {
@@ -255,7 +468,9 @@ func (s *Server) listenForConvs(shutdownCh chan struct{}, sub *kbchat.Subscripti
 			continue
 		}
 
-		if err := handler.HandleNewConv(c.Conversation); err != nil {
+		if err := RecoverToError(s.DebugOutput, "new conv", func() error {
+			return handler.HandleNewConv(c.Conversation)
+		}); err != nil {
 			s.Errorf("listenForConvs: unable to HandleNewConv: %v", err)
 		}
 	}
@@ -0,0 +1,181 @@
+package base
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+// SettingsStore is a key/value store of per conversation preferences, such as
+// a timezone or mention policy, backed by the conv_settings table in
+// settings.sql. Values are stored as strings and parsed by the typed getters,
+// which return def when a conversation hasn't set a key.
+type SettingsStore struct {
+	db *DB
+}
+
+func NewSettingsStore(db *DB) *SettingsStore {
+	return &SettingsStore{db: db}
+}
+
+func (s *SettingsStore) get(convID chat1.ConvIDStr, key string) (value string, ok bool, err error) {
+	row := s.db.QueryRow(`
+		SELECT value
+		FROM conv_settings
+		WHERE conv_id = ? AND name = ?
+	`, convID, key)
+	switch err := row.Scan(&value); err {
+	case nil:
+		return value, true, nil
+	case sql.ErrNoRows:
+		return "", false, nil
+	default:
+		return "", false, err
+	}
+}
+
+// Set stores value for key, which is formatted with fmt.Sprint so
+// time.Duration and *time.Location values round trip through the getters.
+func (s *SettingsStore) Set(convID chat1.ConvIDStr, key string, value interface{}) error {
+	_, err := s.db.Exec(s.db.Dialect.Upsert("conv_settings",
+		[]string{"conv_id", "name", "value", "mtime"},
+		[]string{"conv_id", "name"},
+		[]string{"value", "mtime"}),
+		convID, key, fmt.Sprint(value), time.Now().UTC())
+	return err
+}
+
+func (s *SettingsStore) Delete(convID chat1.ConvIDStr, key string) error {
+	_, err := s.db.Exec(`
+		DELETE FROM conv_settings
+		WHERE conv_id = ? AND name = ?
+	`, convID, key)
+	return err
+}
+
+// DeleteAll clears every setting for a conversation, e.g. when the bot is
+// removed from it.
+func (s *SettingsStore) DeleteAll(convID chat1.ConvIDStr) error {
+	_, err := s.db.Exec(`
+		DELETE FROM conv_settings
+		WHERE conv_id = ?
+	`, convID)
+	return err
+}
+
+func (s *SettingsStore) All(convID chat1.ConvIDStr) (settings map[string]string, err error) {
+	rows, err := s.db.Query(`
+		SELECT name, value
+		FROM conv_settings
+		WHERE conv_id = ?
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	settings = make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		settings[key] = value
+	}
+	return settings, rows.Err()
+}
+
+func (s *SettingsStore) GetString(convID chat1.ConvIDStr, key, def string) (string, error) {
+	value, ok, err := s.get(convID, key)
+	if err != nil || !ok {
+		return def, err
+	}
+	return value, nil
+}
+
+func (s *SettingsStore) GetInt(convID chat1.ConvIDStr, key string, def int) (int, error) {
+	value, ok, err := s.get(convID, key)
+	if err != nil || !ok {
+		return def, err
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return def, fmt.Errorf("setting %s: %v", key, err)
+	}
+	return i, nil
+}
+
+func (s *SettingsStore) GetBool(convID chat1.ConvIDStr, key string, def bool) (bool, error) {
+	value, ok, err := s.get(convID, key)
+	if err != nil || !ok {
+		return def, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return def, fmt.Errorf("setting %s: %v", key, err)
+	}
+	return b, nil
+}
+
+func (s *SettingsStore) GetDuration(convID chat1.ConvIDStr, key string, def time.Duration) (time.Duration, error) {
+	value, ok, err := s.get(convID, key)
+	if err != nil || !ok {
+		return def, err
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return def, fmt.Errorf("setting %s: %v", key, err)
+	}
+	return d, nil
+}
+
+// GetLocation parses an IANA timezone name such as `America/New_York`.
+func (s *SettingsStore) GetLocation(convID chat1.ConvIDStr, key string, def *time.Location) (*time.Location, error) {
+	value, ok, err := s.get(convID, key)
+	if err != nil || !ok {
+		return def, err
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return def, fmt.Errorf("setting %s: %v", key, err)
+	}
+	return loc, nil
+}
+
+// SettingsMigrations creates the conv_settings table used by SettingsStore.
+var SettingsMigrations = []Migration{
+	{
+		ID: "base-settings-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS conv_settings (
+					conv_id char(64) NOT NULL,
+					name varchar(128) NOT NULL,
+					value text NOT NULL,
+					mtime datetime(6) NOT NULL,
+					PRIMARY KEY (conv_id, name)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS conv_settings (
+					conv_id char(64) NOT NULL,
+					name varchar(128) NOT NULL,
+					value text NOT NULL,
+					mtime timestamp with time zone NOT NULL,
+					PRIMARY KEY (conv_id, name)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS conv_settings (
+					conv_id text NOT NULL,
+					name text NOT NULL,
+					value text NOT NULL,
+					mtime datetime NOT NULL,
+					PRIMARY KEY (conv_id, name)
+				)`,
+			},
+		},
+	},
+}
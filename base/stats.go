@@ -123,12 +123,15 @@ func (r *StatsRegistry) ResetPrefix() *StatsRegistry {
 }
 
 func (r *StatsRegistry) Count(name string) {
+	DefaultMetrics.CounterInc("keybase_bot_stats_count_total", "Bot specific counters.", "name", r.makeFname(name))
 	if err := r.backend.Count(r.makeFname(name)); err != nil {
 		r.Errorf("failed to post stat: err: %s name: %s", err, name)
 	}
 }
 
 func (r *StatsRegistry) CountMult(name string, count int) {
+	DefaultMetrics.CounterAdd("keybase_bot_stats_count_total", "Bot specific counters.",
+		float64(count), "name", r.makeFname(name))
 	if err := r.backend.CountMult(r.makeFname(name), count); err != nil {
 		r.Errorf("failed to post stat: err: %s name: %s", err, name)
 	}
@@ -139,6 +142,7 @@ func (r *StatsRegistry) ValueInt(name string, value int) {
 }
 
 func (r *StatsRegistry) Value(name string, value float64) {
+	DefaultMetrics.GaugeSet("keybase_bot_stats_value", "Bot specific values.", value, "name", r.makeFname(name))
 	if err := r.backend.Value(r.makeFname(name), value); err != nil {
 		r.Errorf("failed to post stat: err: %s name: %s", err, name)
 	}
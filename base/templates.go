@@ -0,0 +1,359 @@
+package base
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const (
+	messageTemplateCacheTTL = 30 * time.Second
+	messageTemplateExt      = ".tmpl"
+)
+
+type messageTemplate struct {
+	def         *template.Template
+	description string
+}
+
+// MessageTemplates lets operators reword the messages a bot sends, such as
+// reminders, invites or help text, without forking it. Bots register each
+// message with its default Go template; a deployment overrides it with a
+// `<name>.tmpl` file in the templates directory (--templates-dir) or a row in
+// the message_templates table (see message_templates.sql), which admins edit
+// from chat and wins over the file. Overrides which don't parse or fail to
+// render fall back to the default so a typo can't silence the bot.
+type MessageTemplates struct {
+	*DebugOutput
+	sync.Mutex
+
+	db        *DB
+	dir       string
+	templates map[string]messageTemplate
+
+	files     map[string]*template.Template
+	overrides map[string]*template.Template
+	loadedAt  time.Time
+}
+
+// NewMessageTemplates creates a template set with overrides from db, which
+// may be nil to only read the templates directory.
+func NewMessageTemplates(debugConfig *ChatDebugOutputConfig, db *DB) *MessageTemplates {
+	return &MessageTemplates{
+		DebugOutput: NewDebugOutput("MessageTemplates", debugConfig),
+		db:          db,
+		templates:   make(map[string]messageTemplate),
+		files:       make(map[string]*template.Template),
+	}
+}
+
+// Register declares a message and its default template, it panics if the
+// default doesn't parse.
+func (t *MessageTemplates) Register(name, defaultText, description string) {
+	t.Lock()
+	defer t.Unlock()
+	t.templates[name] = messageTemplate{
+		def:         template.Must(template.New(name).Parse(defaultText)),
+		description: description,
+	}
+}
+
+// LoadDir reads overrides from the `<name>.tmpl` files in dir, an empty dir
+// clears them. Files which don't parse are skipped and reported.
+func (t *MessageTemplates) LoadDir(dir string) error {
+	files := make(map[string]*template.Template)
+	if dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*"+messageTemplateExt))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			name := strings.TrimSuffix(filepath.Base(path), messageTemplateExt)
+			text, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			tmpl, err := template.New(name).Parse(string(text))
+			if err != nil {
+				t.Errorf("LoadDir: skipping invalid template %s: %v", path, err)
+				continue
+			}
+			files[name] = tmpl
+		}
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.dir = dir
+	t.files = files
+	return nil
+}
+
+func (t *MessageTemplates) loadLocked() error {
+	if t.db == nil || (t.overrides != nil && time.Since(t.loadedAt) < messageTemplateCacheTTL) {
+		return nil
+	}
+	rows, err := t.db.Query(`
+		SELECT name, body
+		FROM message_templates
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	overrides := make(map[string]*template.Template)
+	for rows.Next() {
+		var name, body string
+		if err := rows.Scan(&name, &body); err != nil {
+			return err
+		}
+		tmpl, err := template.New(name).Parse(body)
+		if err != nil {
+			t.Debug("loadLocked: skipping invalid template %s: %v", name, err)
+			continue
+		}
+		overrides[name] = tmpl
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	t.overrides = overrides
+	t.loadedAt = time.Now()
+	return nil
+}
+
+// lookup returns the override in effect for name, if any, and the default.
+func (t *MessageTemplates) lookup(name string) (override, def *template.Template, source string) {
+	t.Lock()
+	defer t.Unlock()
+	if err := t.loadLocked(); err != nil {
+		t.Debug("lookup: unable to load templates: %v", err)
+	}
+	def = t.templates[name].def
+	if tmpl, ok := t.overrides[name]; ok {
+		return tmpl, def, "db"
+	}
+	if tmpl, ok := t.files[name]; ok {
+		return tmpl, def, "file"
+	}
+	return nil, def, "default"
+}
+
+func executeTemplate(tmpl *template.Template, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render executes the template registered as name with data. If the override
+// fails the default is used, and an unknown name renders as empty.
+func (t *MessageTemplates) Render(name string, data interface{}) string {
+	override, def, source := t.lookup(name)
+	if override != nil {
+		text, err := executeTemplate(override, data)
+		if err == nil {
+			return text
+		}
+		t.Errorf("Render: %s override of %s failed, using the default: %v", source, name, err)
+	}
+	if def == nil {
+		t.Errorf("Render: unknown template %s", name)
+		return ""
+	}
+	text, err := executeTemplate(def, data)
+	if err != nil {
+		t.Errorf("Render: default %s failed: %v", name, err)
+	}
+	return text
+}
+
+// Set stores a database override of name, which must parse.
+func (t *MessageTemplates) Set(name, body string) error {
+	if t.db == nil {
+		return fmt.Errorf("no database configured for template overrides")
+	}
+	if _, err := template.New(name).Parse(body); err != nil {
+		return err
+	}
+	if _, err := t.db.Exec(t.db.Dialect.Upsert("message_templates",
+		[]string{"name", "body", "mtime"},
+		[]string{"name"},
+		[]string{"body", "mtime"}),
+		name, body, time.Now().UTC()); err != nil {
+		return err
+	}
+	t.invalidate()
+	return nil
+}
+
+// Reset removes the database override of name so the file or default applies.
+func (t *MessageTemplates) Reset(name string) error {
+	if t.db == nil {
+		return nil
+	}
+	if _, err := t.db.Exec(`
+		DELETE FROM message_templates
+		WHERE name = ?
+	`, name); err != nil {
+		return err
+	}
+	t.invalidate()
+	return nil
+}
+
+// Reload rereads the templates directory and the database overrides.
+func (t *MessageTemplates) Reload() error {
+	t.Lock()
+	dir := t.dir
+	t.Unlock()
+	if err := t.LoadDir(dir); err != nil {
+		return err
+	}
+	t.invalidate()
+	return nil
+}
+
+func (t *MessageTemplates) invalidate() {
+	t.Lock()
+	defer t.Unlock()
+	t.overrides = nil
+}
+
+// AdminCommands lets bot admins inspect and override templates from chat.
+func (t *MessageTemplates) AdminCommands() []AdminCommand {
+	return []AdminCommand{
+		{
+			Name:        "templates",
+			Usage:       "[show <name>|set <name> <template>|reset <name>|reload]",
+			Description: "List message templates or override one for this deployment",
+			Handler:     t.handleAdminTemplates,
+		},
+	}
+}
+
+var templateBodyRE = regexp.MustCompile(`(?s)^\s*\S+\s+admin\s+templates\s+set\s+\S+\s+(.+)$`)
+
+func (t *MessageTemplates) handleAdminTemplates(msg chat1.MsgSummary, args []string) error {
+	if len(args) == 0 {
+		return t.listTemplates(msg)
+	}
+	if args[0] == "reload" {
+		if err := t.Reload(); err != nil {
+			return err
+		}
+		t.ChatEcho(msg.ConvID, "OK! templates reloaded")
+		return nil
+	}
+	if len(args) < 2 {
+		t.ChatEcho(msg.ConvID, "Usage: `templates [show <name>|set <name> <template>|reset <name>|reload]`")
+		return nil
+	}
+	action, name := args[0], args[1]
+	override, def, source := t.lookup(name)
+	if def == nil {
+		t.ChatEcho(msg.ConvID, "Unknown template %q", name)
+		return nil
+	}
+	switch action {
+	case "show":
+		text := def.Root.String()
+		if override != nil {
+			text = override.Root.String()
+		}
+		t.ChatEcho(msg.ConvID, "`%s` (%s):\n```%s```", name, source, text)
+	case "set":
+		// preserve the template's formatting rather than the split tokens
+		match := templateBodyRE.FindStringSubmatch(msg.Content.Text.Body)
+		if match == nil {
+			t.ChatEcho(msg.ConvID, "Usage: `templates set <name> <template>`")
+			return nil
+		}
+		if err := t.Set(name, strings.TrimSpace(match[1])); err != nil {
+			t.ChatEcho(msg.ConvID, "Invalid template: %v", err)
+			return nil
+		}
+		t.ChatEcho(msg.ConvID, "OK! `%s` overridden", name)
+	case "reset":
+		if err := t.Reset(name); err != nil {
+			return err
+		}
+		t.ChatEcho(msg.ConvID, "OK! `%s` reset", name)
+	default:
+		t.ChatEcho(msg.ConvID, "Unknown action %q, expected `show`, `set`, `reset` or `reload`", action)
+	}
+	return nil
+}
+
+func (t *MessageTemplates) listTemplates(msg chat1.MsgSummary) error {
+	t.Lock()
+	if err := t.loadLocked(); err != nil {
+		t.Unlock()
+		return err
+	}
+	names := make([]string, 0, len(t.templates))
+	for name := range t.templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		source := "default"
+		if _, ok := t.overrides[name]; ok {
+			source = "db"
+		} else if _, ok := t.files[name]; ok {
+			source = "file"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s): %s", name, source, t.templates[name].description))
+	}
+	t.Unlock()
+	if len(lines) == 0 {
+		t.ChatEcho(msg.ConvID, "No message templates registered")
+		return nil
+	}
+	t.ChatEcho(msg.ConvID, "```%s```", strings.Join(lines, "\n"))
+	return nil
+}
+
+// MessageTemplateMigrations creates the message_templates table used by
+// MessageTemplates.
+var MessageTemplateMigrations = []Migration{
+	{
+		ID: "base-message-templates-1",
+		Statements: map[Dialect][]string{
+			MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS message_templates (
+					name varchar(128) NOT NULL,
+					body text NOT NULL,
+					mtime datetime(6) NOT NULL,
+					PRIMARY KEY (name)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`,
+			},
+			PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS message_templates (
+					name varchar(128) NOT NULL,
+					body text NOT NULL,
+					mtime timestamp with time zone NOT NULL,
+					PRIMARY KEY (name)
+				)`,
+			},
+			SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS message_templates (
+					name text NOT NULL,
+					body text NOT NULL,
+					mtime datetime NOT NULL,
+					PRIMARY KEY (name)
+				)`,
+			},
+		},
+	},
+}
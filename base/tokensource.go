@@ -0,0 +1,64 @@
+package base
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenSaver persists a token after it has been refreshed.
+type TokenSaver func(token *oauth2.Token) error
+
+// StorageTokenSaver saves refreshed tokens under identifier in storage.
+func StorageTokenSaver(storage OAuthStorage, identifier string) TokenSaver {
+	return func(token *oauth2.Token) error {
+		return storage.PutToken(identifier, token)
+	}
+}
+
+type persistingTokenSource struct {
+	sync.Mutex
+
+	src  oauth2.TokenSource
+	last oauth2.Token
+	save TokenSaver
+}
+
+// NewPersistingTokenSource returns a TokenSource which refreshes token like
+// config.TokenSource, and writes the new access and refresh tokens back with
+// save whenever they change so they aren't lost when the client is dropped.
+func NewPersistingTokenSource(config *oauth2.Config, token *oauth2.Token, save TokenSaver) oauth2.TokenSource {
+	s := &persistingTokenSource{
+		src:  config.TokenSource(HTTPClientContext(context.Background()), token),
+		save: save,
+	}
+	if token != nil {
+		s.last = *token
+	}
+	return s
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.src.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if token.AccessToken == s.last.AccessToken && token.RefreshToken == s.last.RefreshToken {
+		return token, nil
+	}
+	if err := s.save(token); err != nil {
+		return nil, err
+	}
+	s.last = *token
+	return token, nil
+}
+
+// NewPersistingClient is like config.Client but saves refreshed tokens. It's
+// built on NewHTTPClient.
+func NewPersistingClient(config *oauth2.Config, token *oauth2.Token, save TokenSaver) *http.Client {
+	return oauth2.NewClient(HTTPClientContext(context.Background()), NewPersistingTokenSource(config, token, save))
+}
@@ -0,0 +1,59 @@
+package base
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// RequestIDHeader carries the trace ID of an inbound request, it's honored if
+// set by a proxy and echoed back on the response.
+const RequestIDHeader = "X-Request-ID"
+
+var validTraceID = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,64}$`)
+
+type traceIDKey struct{}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.Repeat("0", 2*n)
+	}
+	return hex.EncodeToString(b)
+}
+
+func NewTraceID() string {
+	return randomHex(8)
+}
+
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceIDFromContext returns the trace ID attached to ctx or the empty string.
+func TraceIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// EnsureTraceID returns ctx with a trace ID, generating a new one if ctx has
+// none, e.g. at the start of a scheduler run.
+func EnsureTraceID(ctx context.Context) (context.Context, string) {
+	if traceID := TraceIDFromContext(ctx); traceID != "" {
+		return ctx, traceID
+	}
+	traceID := NewTraceID()
+	return WithTraceID(ctx, traceID), traceID
+}
+
+func requestTraceID(header string) string {
+	if validTraceID.MatchString(header) {
+		return header
+	}
+	return NewTraceID()
+}
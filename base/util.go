@@ -309,6 +309,26 @@ func SplitTokens(cmd string) (tokens []string, userErrorMessage string, err erro
 	}
 }
 
+// KeybaseChatLink returns a keybase:// link which opens channel in the
+// Keybase app.
+func KeybaseChatLink(channel chat1.ChatChannel) string {
+	if channel.MembersType == "team" {
+		topic := channel.TopicName
+		if topic == "" {
+			topic = "general"
+		}
+		return fmt.Sprintf("keybase://chat/%s#%s", channel.Name, topic)
+	}
+	return "keybase://chat/" + channel.Name
+}
+
+func channelDisplayName(channel chat1.ChatChannel) string {
+	if channel.MembersType == "team" && channel.TopicName != "" {
+		return channel.Name + "#" + channel.TopicName
+	}
+	return channel.Name
+}
+
 func IsDirectPrivateMessage(botUsername, senderUsername string, channel chat1.ChatChannel) bool {
 	if channel.MembersType == "team" {
 		return false
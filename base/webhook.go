@@ -0,0 +1,158 @@
+package base
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const maxWebhookBodySize = 25 << 20
+
+// WebhookVerifier validates an inbound webhook request given its raw body.
+type WebhookVerifier func(r *http.Request, body []byte) error
+
+func hmacVerifier(newHash func() hash.Hash, header, prefix string, secret []byte) WebhookVerifier {
+	return func(r *http.Request, body []byte) error {
+		sig := r.Header.Get(header)
+		if sig == "" {
+			return fmt.Errorf("missing %s header", header)
+		}
+		expected, err := hex.DecodeString(strings.TrimPrefix(sig, prefix))
+		if err != nil {
+			return fmt.Errorf("malformed %s header: %v", header, err)
+		}
+		mac := hmac.New(newHash, secret)
+		_, _ = mac.Write(body)
+		if !hmac.Equal(mac.Sum(nil), expected) {
+			return fmt.Errorf("invalid %s signature", header)
+		}
+		return nil
+	}
+}
+
+// HMACSHA256Verifier checks a hex encoded HMAC-SHA256 of the body in header,
+// e.g. GitHub's `X-Hub-Signature-256: sha256=<hex>`.
+func HMACSHA256Verifier(header, prefix string, secret []byte) WebhookVerifier {
+	return hmacVerifier(sha256.New, header, prefix, secret)
+}
+
+// HMACSHA1Verifier checks a hex encoded HMAC-SHA1 of the body in header.
+func HMACSHA1Verifier(header, prefix string, secret []byte) WebhookVerifier {
+	return hmacVerifier(sha1.New, header, prefix, secret)
+}
+
+// SharedSecretQueryVerifier requires the query parameter param to equal secret.
+func SharedSecretQueryVerifier(param, secret string) WebhookVerifier {
+	return func(r *http.Request, body []byte) error {
+		if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get(param)), []byte(secret)) != 1 {
+			return fmt.Errorf("invalid %s parameter", param)
+		}
+		return nil
+	}
+}
+
+// SharedSecretHeaderVerifier requires header to equal secret, e.g. GitLab's
+// `X-Gitlab-Token`.
+func SharedSecretHeaderVerifier(header, secret string) WebhookVerifier {
+	return func(r *http.Request, body []byte) error {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(header)), []byte(secret)) != 1 {
+			return fmt.Errorf("invalid %s header", header)
+		}
+		return nil
+	}
+}
+
+// IPAllowListVerifier only accepts requests from the given IPs or CIDR
+// ranges. If trustForwardedFor is set the first `X-Forwarded-For` address is
+// used, which is only safe behind a proxy that sets it.
+func IPAllowListVerifier(allowed []string, trustForwardedFor bool) (WebhookVerifier, error) {
+	var nets []*net.IPNet
+	for _, entry := range allowed {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return func(r *http.Request, body []byte) error {
+		addr := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); trustForwardedFor && forwarded != "" {
+			addr = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return fmt.Errorf("unable to parse remote address %q", addr)
+		}
+		for _, ipNet := range nets {
+			if ipNet.Contains(ip) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not allowed", ip)
+	}, nil
+}
+
+// AnyWebhookVerifier passes if at least one of verifiers does, such as when a
+// provider sends both SHA1 and SHA256 signatures.
+func AnyWebhookVerifier(verifiers ...WebhookVerifier) WebhookVerifier {
+	return func(r *http.Request, body []byte) error {
+		errs := make([]string, 0, len(verifiers))
+		for _, verifier := range verifiers {
+			err := verifier(r, body)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return errors.New(strings.Join(errs, ", "))
+	}
+}
+
+// Verify wraps handler so requests are only served once every verifier
+// passes, the body is buffered and restored for handler.
+func (h *HTTPSrv) Verify(handler http.Handler, verifiers ...WebhookVerifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+		if err != nil {
+			h.Debug("Verify: unable to read body for %s: %v", r.URL.Path, err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body.Close()
+		for _, verifier := range verifiers {
+			if err := verifier(r, body); err != nil {
+				h.Debug("Verify: rejected request for %s: %v", r.URL.Path, err)
+				h.Stats.Count("webhook - verification failed")
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// HandleVerified registers handler for pattern behind Verify.
+func (h *HTTPSrv) HandleVerified(pattern string, handler http.HandlerFunc, verifiers ...WebhookVerifier) {
+	http.Handle(pattern, h.Verify(handler, verifiers...))
+}
@@ -0,0 +1,17 @@
+CREATE TABLE `broadcasts` (
+  `id` varchar(32) NOT NULL,
+  `message` text NOT NULL,
+  `created_by` varchar(128) NOT NULL,
+  `ctime` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `broadcast_deliveries` (
+  `broadcast_id` varchar(32) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`broadcast_id`, `conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
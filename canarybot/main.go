@@ -9,7 +9,6 @@ import (
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/canarybot/canarybot"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
@@ -59,9 +58,11 @@ func (s *BotServer) makeAdvertisement() kbchat.Advertisement {
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
+	s.Configure(s.opts.Options)
 
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
 	stats, err := base.NewStatsRegistry(debugConfig, s.opts.StathatEZKey)
@@ -72,12 +73,16 @@ func (s *BotServer) Go() (err error) {
 	stats = stats.SetPrefix(s.Name())
 	httpSrv := canarybot.NewHTTPSrv(stats, debugConfig)
 	handler := canarybot.NewHandler(stats, s.kbc, debugConfig)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "🦜 chirp. chirp.") })
-	if err := eg.Wait(); err != nil {
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "🦜 chirp. chirp.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"flag"
 	"fmt"
 	"net/http"
@@ -15,7 +14,6 @@ import (
 	"github.com/keybase/managed-bots/elastiwatch/elastiwatch"
 	"github.com/olivere/elastic"
 	elaws "github.com/olivere/elastic/aws/v4"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
@@ -108,15 +106,18 @@ Remove deferral`,
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	s.Configure(s.opts.Options)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := elastiwatch.NewDB(sdb)
 	s.Debug("Connect to Elasticsearch at %s", s.opts.ESAddress)
 	var emailer base.Emailer
@@ -147,14 +148,19 @@ func (s *BotServer) Go() (err error) {
 	logwatch := elastiwatch.NewLogWatch(cli, db, s.opts.Index, s.opts.Email, emailer, s.opts.AlertConvID,
 		s.opts.EmailConvID, debugConfig)
 	httpSrv := elastiwatch.NewHTTPSrv(stats, s.kbc, debugConfig, db)
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
 	handler := elastiwatch.NewHandler(s.kbc, debugConfig, httpSrv, db, logwatch)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, logwatch, stats) })
-	s.GoWithRecover(eg, func() error { return logwatch.Run() })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(logwatch.Run, logwatch)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
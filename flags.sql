@@ -0,0 +1,7 @@
+CREATE TABLE `feature_flags` (
+  `name` varchar(128) NOT NULL,
+  `scope` varchar(192) NOT NULL,
+  `enabled` boolean NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`, `scope`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -6,7 +6,10 @@ CREATE TABLE `oauth_state` (
   `account_nickname` varchar(128) NOT NULL,
   `keybase_conv_id` char(64) NOT NULL,
   `is_complete` boolean NOT NULL DEFAULT 0,
-  PRIMARY KEY (`state`)
+  `code_verifier` varchar(128) NOT NULL DEFAULT '',
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`state`),
+  KEY `ctime` (`ctime`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 DROP TABLE IF EXISTS `account`;
@@ -16,9 +19,9 @@ CREATE TABLE `account` (
     `account_nickname` varchar(128) NOT NULL,   -- nickname of google account for kb user
     `ctime` datetime NOT NULL,
     `mtime` datetime NOT NULL,
-    `access_token` varchar(256) NOT NULL,
+    `access_token` varchar(2048) NOT NULL,
     `token_type` varchar(64) NOT NULL,
-    `refresh_token` varchar(256) NOT NULL,
+    `refresh_token` varchar(2048) NOT NULL,
     `expiry` datetime NOT NULL,
     PRIMARY KEY (`keybase_username`, `account_nickname`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -50,6 +53,7 @@ CREATE TABLE `subscription` (
     `keybase_conv_id` char(64) NOT NULL,            -- channel that is subscribed to notifications
     `minutes_before` int(11) NOT NULL DEFAULT 0,    -- minutes until event that a notification should be sent (for reminder)
     `type` ENUM ('invite', 'reminder'),             -- type of subscription
+    `mention_policy` ENUM ('none', 'attendees', 'here') NOT NULL DEFAULT 'none', -- who to mention for reminders in team channels
     PRIMARY KEY (`keybase_username`, `account_nickname`, `calendar_id`, `keybase_conv_id`, `minutes_before`, `type`),
     FOREIGN KEY (`keybase_username`, `account_nickname`)
         REFERENCES account(`keybase_username`, `account_nickname`)
@@ -90,3 +94,68 @@ CREATE TABLE `daily_schedule_subscription` (
         REFERENCES account(`keybase_username`, `account_nickname`)
         ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `broadcasts` (
+  `id` varchar(32) NOT NULL,
+  `message` text NOT NULL,
+  `created_by` varchar(128) NOT NULL,
+  `ctime` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `broadcast_deliveries` (
+  `broadcast_id` varchar(32) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`broadcast_id`, `conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_events` (
+  `day` char(10) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `name` varchar(64) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `count` int NOT NULL,
+  PRIMARY KEY (`day`, `kind`, `name`, `conv_id`, `username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_daily` (
+  `day` char(10) NOT NULL,
+  `metric` varchar(96) NOT NULL,
+  `value` bigint NOT NULL,
+  PRIMARY KEY (`day`, `metric`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `leases` (
+  `name` varchar(64) NOT NULL,
+  `holder` varchar(32) NOT NULL,
+  `expire_time` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `audit_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `ctime` datetime(6) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `command` varchar(64) NOT NULL,
+  `args` text NOT NULL,
+  `result` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `duration_ms` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`),
+  KEY `username` (`username`),
+  KEY `conv_id` (`conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `message_templates` (
+  `name` varchar(128) NOT NULL,
+  `body` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
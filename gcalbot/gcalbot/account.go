@@ -4,13 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"golang.org/x/oauth2"
 
 	"google.golang.org/api/googleapi"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 )
@@ -124,19 +124,13 @@ func (h *Handler) deleteAccount(keybaseUsername, accountNickname string) error {
 }
 
 func GetCalendarService(account *Account, config *oauth2.Config, db *DB) (srv *calendar.Service, err error) {
-	if account.Token.Expiry.Before(time.Now()) {
-		newToken, err := config.TokenSource(context.Background(), &account.Token).Token()
-		if err != nil {
-			return nil, err
+	client := base.NewPersistingClient(config, &account.Token, func(token *oauth2.Token) error {
+		updated := *account
+		updated.Token = *token
+		if err := db.InsertAccount(updated); err != nil {
+			return fmt.Errorf("unable to update account token: %s", err)
 		}
-		if newToken.AccessToken != account.Token.AccessToken {
-			account.Token = *newToken
-			err = db.InsertAccount(*account)
-			if err != nil {
-				return nil, fmt.Errorf("unable to update account token: %s", err)
-			}
-		}
-	}
-	client := config.Client(context.Background(), &account.Token)
+		return nil
+	})
 	return calendar.NewService(context.Background(), option.WithHTTPClient(client))
 }
@@ -0,0 +1,81 @@
+package gcalbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+// AdminCommands are the gcalbot specific commands registered with the base
+// admin framework.
+func (h *Handler) AdminCommands(renewScheduler *RenewChannelScheduler) []base.AdminCommand {
+	return []base.AdminCommand{
+		{
+			Name:        "subscriptions",
+			Usage:       "<keybase username>",
+			Description: "Dump a user's calendar subscriptions",
+			Handler:     h.handleAdminSubscriptions,
+		},
+		{
+			Name:        "renew",
+			Usage:       "<keybase username>",
+			Description: "Force renewal of a user's calendar webhook channels",
+			Handler: func(msg chat1.MsgSummary, args []string) error {
+				return h.handleAdminRenew(msg, args, renewScheduler)
+			},
+		},
+	}
+}
+
+func (h *Handler) handleAdminSubscriptions(msg chat1.MsgSummary, args []string) error {
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "Invalid number of arguments.")
+		return nil
+	}
+	pairs, err := h.db.GetSubscriptionListForUsername(args[0])
+	if err != nil {
+		return err
+	} else if len(pairs) == 0 {
+		h.ChatEcho(msg.ConvID, "@%s has no subscriptions", args[0])
+		return nil
+	}
+	lines := make([]string, len(pairs))
+	for index, pair := range pairs {
+		subscription := pair.Subscription
+		lines[index] = fmt.Sprintf("%s %s %s type=%s minutes_before=%d mention=%s",
+			pair.Account.AccountNickname, subscription.CalendarID, subscription.KeybaseConvID, subscription.Type,
+			GetMinutesFromDuration(subscription.DurationBefore), subscription.MentionPolicy)
+	}
+	h.ChatEcho(msg.ConvID, "```%s```", strings.Join(lines, "\n"))
+	return nil
+}
+
+func (h *Handler) handleAdminRenew(msg chat1.MsgSummary, args []string, renewScheduler *RenewChannelScheduler) error {
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "Invalid number of arguments.")
+		return nil
+	}
+	accounts, err := h.db.GetAccountListForUsername(args[0])
+	if err != nil {
+		return err
+	}
+	var renewed, failed int
+	for _, account := range accounts {
+		channels, err := h.db.GetChannelListByAccount(account)
+		if err != nil {
+			return err
+		}
+		for _, channel := range channels {
+			if err := renewScheduler.renewChannel(account, channel); err != nil {
+				h.Errorf("error renewing channel '%s': %s", channel.ChannelID, err)
+				failed++
+				continue
+			}
+			renewed++
+		}
+	}
+	h.ChatEcho(msg.ConvID, "Renewed %d channels for @%s, %d failed", renewed, args[0], failed)
+	return nil
+}
@@ -2,9 +2,10 @@ package gcalbot
 
 import (
 	"context"
-	"strings"
+	"fmt"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
 	"google.golang.org/api/calendar/v3"
 )
 
@@ -41,18 +42,19 @@ func (h *Handler) handleCalendarsList(msg chat1.MsgSummary, args []string) error
 		return nil
 	}
 
-	data := []interface{}{accountNickname}
-	for _, calendarItem := range calendarList {
+	items := make([]string, len(calendarList))
+	for index, calendarItem := range calendarList {
 		if calendarItem.SummaryOverride != "" {
-			data = append(data, calendarItem.SummaryOverride)
+			items[index] = "• " + calendarItem.SummaryOverride
 		} else {
-			data = append(data, calendarItem.Summary)
+			items[index] = "• " + calendarItem.Summary
 		}
 	}
 
-	calendarListMessage := "Here are the calendars associated with the account '%s':" + strings.Repeat("\n• %s", len(calendarList))
-	h.ChatEcho(msg.ConvID, calendarListMessage, data...)
-	return nil
+	return h.pager.Send(msg.ConvID, base.PagedList{
+		Header: fmt.Sprintf("Here are the calendars associated with the account '%s':", accountNickname),
+		Items:  items,
+	})
 }
 
 func getCalendarList(srv *calendar.Service) (list []*calendar.CalendarListEntry, err error) {
@@ -2,10 +2,12 @@ package gcalbot
 
 import (
 	"database/sql"
+	"fmt"
 	"strings"
 	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"golang.org/x/oauth2"
 
 	"github.com/keybase/managed-bots/base"
 )
@@ -13,30 +15,40 @@ import (
 type DB struct {
 	*base.DB
 	*base.DebugOutput
+	cipher *base.FieldCipher
 }
 
 func NewDB(
-	db *sql.DB,
+	db *base.DB,
 	debugConfig *base.ChatDebugOutputConfig,
 ) *DB {
 	return &DB{
-		DB:          base.NewDB(db),
+		DB:          db,
 		DebugOutput: base.NewDebugOutput("DB", debugConfig),
 	}
 }
 
+// SetCipher encrypts account tokens written from now on, see
+// EncryptExistingAccounts for tokens already stored in plaintext.
+func (d *DB) SetCipher(cipher *base.FieldCipher) {
+	d.cipher = cipher
+}
+
 // OAuth state
 func (d *DB) GetState(state string) (*OAuthRequest, error) {
 	var oauthState OAuthRequest
+	var ctime int64
 	row := d.DB.QueryRow(`
-		SELECT keybase_username, account_nickname, keybase_conv_id, is_complete
+		SELECT keybase_username, account_nickname, keybase_conv_id, is_complete, code_verifier,
+			ROUND(UNIX_TIMESTAMP(ctime))
 		FROM oauth_state
 		WHERE state = ?
 	`, state)
 	err := row.Scan(&oauthState.KeybaseUsername, &oauthState.AccountNickname, &oauthState.KeybaseConvID,
-		&oauthState.IsComplete)
+		&oauthState.IsComplete, &oauthState.CodeVerifier, &ctime)
 	switch err {
 	case nil:
+		oauthState.Ctime = time.Unix(ctime, 0)
 		return &oauthState, nil
 	case sql.ErrNoRows:
 		return nil, nil
@@ -49,44 +61,65 @@ func (d *DB) PutState(state string, oauthState OAuthRequest) error {
 	err := d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
 			INSERT INTO oauth_state
-			(state, keybase_username, account_nickname, keybase_conv_id)
-			VALUES (?, ?, ?, ?)
+			(state, keybase_username, account_nickname, keybase_conv_id, code_verifier, ctime)
+			VALUES (?, ?, ?, ?, ?, NOW())
 			ON DUPLICATE KEY UPDATE
 			keybase_username=VALUES(keybase_username),
 			account_nickname=VALUES(account_nickname),
-			keybase_conv_id=VALUES(keybase_conv_id)
-		`, state, oauthState.KeybaseUsername, oauthState.AccountNickname, oauthState.KeybaseConvID)
+			keybase_conv_id=VALUES(keybase_conv_id),
+			code_verifier=VALUES(code_verifier),
+			ctime=VALUES(ctime)
+		`, state, oauthState.KeybaseUsername, oauthState.AccountNickname, oauthState.KeybaseConvID,
+			oauthState.CodeVerifier)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			DELETE FROM oauth_state
+			WHERE ctime < NOW() - INTERVAL 1 DAY
+		`)
 		return err
 	})
 	return err
 }
 
+// CompleteState marks state as used, returning base.ErrOAuthStateUsed if it
+// already was.
 func (d *DB) CompleteState(state string) error {
 	err := d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`
+		res, err := tx.Exec(`
 			UPDATE oauth_state
 			SET is_complete = true
-			WHERE state = ?
+			WHERE state = ? AND is_complete = false
 		`, state)
-		return err
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return base.ErrOAuthStateUsed
+		}
+		return nil
 	})
 	return err
 }
 
 // Account
 func (d *DB) InsertAccount(account Account) error {
+	token, err := d.cipher.EncryptToken(&account.Token)
+	if err != nil {
+		return err
+	}
 	return d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`
-			INSERT INTO account
-			(keybase_username, account_nickname, access_token, token_type, refresh_token, expiry, ctime, mtime)
-			VALUES (?, ?, ?, ?, ?, ?, NOW(), NOW())
-			ON DUPLICATE KEY UPDATE 
-			access_token=VALUES(access_token),
-			refresh_token=VALUES(refresh_token),
-			expiry=VALUES(expiry),
-			mtime=VALUES(mtime)
-		`, account.KeybaseUsername, account.AccountNickname, account.Token.AccessToken, account.Token.TokenType,
-			account.Token.RefreshToken, account.Token.Expiry)
+		now := time.Now()
+		_, err := tx.Exec(d.Rebind(d.Dialect.Upsert("account",
+			[]string{"keybase_username", "account_nickname", "access_token", "token_type", "refresh_token", "expiry",
+				"ctime", "mtime"},
+			[]string{"keybase_username", "account_nickname"},
+			[]string{"access_token", "refresh_token", "expiry", "mtime"})),
+			account.KeybaseUsername, account.AccountNickname, token.AccessToken, token.TokenType,
+			token.RefreshToken, token.Expiry, now, now)
 		return err
 	})
 }
@@ -106,12 +139,69 @@ func (d *DB) GetAccount(keybaseUsername, accountNickname string) (account *Accou
 		return nil, nil
 	case nil:
 		account.Token.Expiry = time.Unix(expiry, 0)
+		if err = d.cipher.DecryptToken(&account.Token); err != nil {
+			return nil, err
+		}
 		return account, nil
 	default:
 		return nil, err
 	}
 }
 
+// EncryptExistingAccounts encrypts every account token still stored in
+// plaintext and returns how many accounts were updated.
+func (d *DB) EncryptExistingAccounts() (updated int, err error) {
+	if d.cipher == nil {
+		return 0, fmt.Errorf("no encryption key configured")
+	}
+	accounts, err := d.getAllAccounts()
+	if err != nil {
+		return 0, err
+	}
+	for _, account := range accounts {
+		if !needsEncryption(account.Token) {
+			continue
+		}
+		if err := d.cipher.DecryptToken(&account.Token); err != nil {
+			return updated, err
+		}
+		if err := d.InsertAccount(*account); err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+func needsEncryption(token oauth2.Token) bool {
+	return (token.AccessToken != "" && !base.IsEncryptedValue(token.AccessToken)) ||
+		(token.RefreshToken != "" && !base.IsEncryptedValue(token.RefreshToken))
+}
+
+// getAllAccounts returns every account with its token as stored.
+func (d *DB) getAllAccounts() (accounts []*Account, err error) {
+	rows, err := d.DB.Query(`
+		SELECT keybase_username, account_nickname, access_token, token_type, refresh_token, ROUND(UNIX_TIMESTAMP(expiry))
+		FROM account
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var account Account
+		var expiry int64
+		err = rows.Scan(&account.KeybaseUsername, &account.AccountNickname, &account.Token.AccessToken,
+			&account.Token.TokenType, &account.Token.RefreshToken, &expiry)
+		if err != nil {
+			return nil, err
+		}
+		account.Token.Expiry = time.Unix(expiry, 0)
+		accounts = append(accounts, &account)
+	}
+	return accounts, rows.Err()
+}
+
 func (d *DB) DeleteAccount(keybaseUsername, accountNickname string) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
 		// remove subscriptions first due to foreign key constraint
@@ -159,6 +249,9 @@ func (d *DB) GetAccountListForUsername(keybaseUsername string) (accounts []*Acco
 		if err != nil {
 			return nil, err
 		}
+		if err = d.cipher.DecryptToken(&account.Token); err != nil {
+			return nil, err
+		}
 		accounts = append(accounts, &account)
 	}
 	return accounts, nil
@@ -224,14 +317,15 @@ func (d *DB) GetChannelAndAccountByID(channelID string) (channel *Channel, accou
 	account = &Account{}
 	var channelExpiry int64
 	var tokenExpiry int64
-	row := d.DB.QueryRow(`
+	row := d.DB.QueryRow(d.Rebind(`
 		SELECT
-		    channel_id, calendar_id, resource_id, ROUND(UNIX_TIMESTAMP(channel.expiry)), next_sync_token,
-			account.keybase_username, account.account_nickname, access_token, token_type, refresh_token, ROUND(UNIX_TIMESTAMP(account.expiry))
+		    channel_id, calendar_id, resource_id, `+d.Dialect.UnixTimestamp("channel.expiry")+`, next_sync_token,
+			account.keybase_username, account.account_nickname, access_token, token_type, refresh_token, `+
+		d.Dialect.UnixTimestamp("account.expiry")+`
 		FROM channel
 		JOIN account USING(keybase_username, account_nickname)
 		WHERE channel_id = ?
-	`, channelID)
+	`), channelID)
 	err = row.Scan(&channel.ChannelID, &channel.CalendarID, &channel.ResourceID, &channelExpiry, &channel.NextSyncToken,
 		&account.KeybaseUsername, &account.AccountNickname, &account.Token.AccessToken, &account.Token.TokenType,
 		&account.Token.RefreshToken, &tokenExpiry)
@@ -241,6 +335,9 @@ func (d *DB) GetChannelAndAccountByID(channelID string) (channel *Channel, accou
 	case nil:
 		channel.Expiry = time.Unix(channelExpiry, 0)
 		account.Token.Expiry = time.Unix(tokenExpiry, 0)
+		if err = d.cipher.DecryptToken(&account.Token); err != nil {
+			return nil, nil, err
+		}
 		return channel, account, nil
 	default:
 		return nil, nil, err
@@ -297,6 +394,9 @@ func (d *DB) GetExpiringChannelAndAccountList() (pairs []*ChannelAndAccount, err
 		}
 		pair.Channel.Expiry = time.Unix(channelExpiry, 0)
 		pair.Account.Token.Expiry = time.Unix(accountExpiry, 0)
+		if err = d.cipher.DecryptToken(&pair.Account.Token); err != nil {
+			return nil, err
+		}
 		pairs = append(pairs, &pair)
 	}
 	return pairs, nil
@@ -326,10 +426,10 @@ func (d *DB) InsertSubscription(account *Account, subscription Subscription) err
 	return d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
 			INSERT INTO subscription
-			(keybase_username, account_nickname, calendar_id, keybase_conv_id, minutes_before, type)
-			VALUES (?, ?, ?, ?, ?, ?)
+			(keybase_username, account_nickname, calendar_id, keybase_conv_id, minutes_before, type, mention_policy)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
 		`, account.KeybaseUsername, account.AccountNickname, subscription.CalendarID,
-			subscription.KeybaseConvID, minutesBefore, subscription.Type)
+			subscription.KeybaseConvID, minutesBefore, subscription.Type, ParseMentionPolicy(string(subscription.MentionPolicy)))
 		return err
 	})
 }
@@ -360,7 +460,7 @@ func (d *DB) CountSubscriptionsByAccountAndCalender(account *Account, calendarID
 func (d *DB) GetReminderSubscriptionAndAccountPairs() (pairs []*SubscriptionAndAccount, err error) {
 	row, err := d.DB.Query(`
 		SELECT
-		       calendar_id, keybase_conv_id, minutes_before, type, -- subscription
+		       calendar_id, keybase_conv_id, minutes_before, type, mention_policy, -- subscription
 		       account.keybase_username, account.account_nickname, access_token, token_type, refresh_token, ROUND(UNIX_TIMESTAMP(expiry)) -- account
 		FROM subscription
 		JOIN account USING(keybase_username, account_nickname)
@@ -374,7 +474,44 @@ func (d *DB) GetReminderSubscriptionAndAccountPairs() (pairs []*SubscriptionAndA
 		var pair SubscriptionAndAccount
 		var subscriptionMinutesBefore int
 		var tokenExpiry int64
-		err = row.Scan(&pair.Subscription.CalendarID, &pair.Subscription.KeybaseConvID, &subscriptionMinutesBefore, &pair.Subscription.Type,
+		err = row.Scan(&pair.Subscription.CalendarID, &pair.Subscription.KeybaseConvID, &subscriptionMinutesBefore,
+			&pair.Subscription.Type, &pair.Subscription.MentionPolicy,
+			&pair.Account.KeybaseUsername, &pair.Account.AccountNickname, &pair.Account.Token.AccessToken,
+			&pair.Account.Token.TokenType, &pair.Account.Token.RefreshToken, &tokenExpiry)
+		if err != nil {
+			return nil, err
+		}
+		pair.Subscription.DurationBefore = GetDurationFromMinutes(subscriptionMinutesBefore)
+		pair.Account.Token.Expiry = time.Unix(tokenExpiry, 0)
+		if err = d.cipher.DecryptToken(&pair.Account.Token); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, &pair)
+	}
+	return pairs, nil
+}
+
+// GetSubscriptionAndAccountPairsForConv returns every subscription notifying
+// keybaseConvID, along with the account it belongs to.
+func (d *DB) GetSubscriptionAndAccountPairsForConv(keybaseConvID chat1.ConvIDStr) (pairs []*SubscriptionAndAccount, err error) {
+	row, err := d.DB.Query(`
+		SELECT
+		       calendar_id, keybase_conv_id, minutes_before, type, mention_policy, -- subscription
+		       account.keybase_username, account.account_nickname, access_token, token_type, refresh_token, ROUND(UNIX_TIMESTAMP(expiry)) -- account
+		FROM subscription
+		JOIN account USING(keybase_username, account_nickname)
+		WHERE subscription.keybase_conv_id = ?
+	`, keybaseConvID)
+	if err != nil {
+		return nil, err
+	}
+	defer row.Close()
+	for row.Next() {
+		var pair SubscriptionAndAccount
+		var subscriptionMinutesBefore int
+		var tokenExpiry int64
+		err = row.Scan(&pair.Subscription.CalendarID, &pair.Subscription.KeybaseConvID, &subscriptionMinutesBefore,
+			&pair.Subscription.Type, &pair.Subscription.MentionPolicy,
 			&pair.Account.KeybaseUsername, &pair.Account.AccountNickname, &pair.Account.Token.AccessToken,
 			&pair.Account.Token.TokenType, &pair.Account.Token.RefreshToken, &tokenExpiry)
 		if err != nil {
@@ -382,6 +519,38 @@ func (d *DB) GetReminderSubscriptionAndAccountPairs() (pairs []*SubscriptionAndA
 		}
 		pair.Subscription.DurationBefore = GetDurationFromMinutes(subscriptionMinutesBefore)
 		pair.Account.Token.Expiry = time.Unix(tokenExpiry, 0)
+		if err = d.cipher.DecryptToken(&pair.Account.Token); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, &pair)
+	}
+	return pairs, nil
+}
+
+// GetSubscriptionListForUsername returns every subscription of the user's
+// accounts, the account tokens are not populated.
+func (d *DB) GetSubscriptionListForUsername(keybaseUsername string) (pairs []*SubscriptionAndAccount, err error) {
+	rows, err := d.DB.Query(`
+		SELECT account_nickname, calendar_id, keybase_conv_id, minutes_before, type, mention_policy
+		FROM subscription
+		WHERE keybase_username = ?
+		ORDER BY account_nickname, calendar_id
+	`, keybaseUsername)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pair SubscriptionAndAccount
+		var minutesBefore int
+		pair.Account.KeybaseUsername = keybaseUsername
+		err = rows.Scan(&pair.Account.AccountNickname, &pair.Subscription.CalendarID,
+			&pair.Subscription.KeybaseConvID, &minutesBefore, &pair.Subscription.Type,
+			&pair.Subscription.MentionPolicy)
+		if err != nil {
+			return nil, err
+		}
+		pair.Subscription.DurationBefore = GetDurationFromMinutes(minutesBefore)
 		pairs = append(pairs, &pair)
 	}
 	return pairs, nil
@@ -393,7 +562,7 @@ func (d *DB) GetReminderSubscriptionsByAccountAndCalendar(
 	subscriptionType SubscriptionType,
 ) (subscriptions []*Subscription, err error) {
 	row, err := d.DB.Query(`
-		SELECT calendar_id, keybase_conv_id, minutes_before, type
+		SELECT calendar_id, keybase_conv_id, minutes_before, type, mention_policy
 		FROM subscription
 		WHERE keybase_username = ? AND account_nickname = ? AND calendar_id = ? AND type = ?
 	`, account.KeybaseUsername, account.AccountNickname, calendarID, subscriptionType)
@@ -404,7 +573,8 @@ func (d *DB) GetReminderSubscriptionsByAccountAndCalendar(
 	for row.Next() {
 		var subscription Subscription
 		var minutesBefore int
-		err = row.Scan(&subscription.CalendarID, &subscription.KeybaseConvID, &minutesBefore, &subscription.Type)
+		err = row.Scan(&subscription.CalendarID, &subscription.KeybaseConvID, &minutesBefore, &subscription.Type,
+			&subscription.MentionPolicy)
 		if err != nil {
 			return nil, err
 		}
@@ -416,7 +586,7 @@ func (d *DB) GetReminderSubscriptionsByAccountAndCalendar(
 
 func (d *DB) GetSubscriptions(account *Account, calendarID string, keybaseConvID chat1.ConvIDStr) (subscriptions []*Subscription, err error) {
 	rows, err := d.DB.Query(`
-		SELECT calendar_id, keybase_conv_id, minutes_before, type
+		SELECT calendar_id, keybase_conv_id, minutes_before, type, mention_policy
 		FROM subscription
 		WHERE keybase_username = ? AND account_nickname = ? AND calendar_id = ? AND keybase_conv_id = ?
 	`, account.KeybaseUsername, account.AccountNickname, calendarID, keybaseConvID)
@@ -427,7 +597,8 @@ func (d *DB) GetSubscriptions(account *Account, calendarID string, keybaseConvID
 	for rows.Next() {
 		var subscription Subscription
 		var minutesBefore int
-		err = rows.Scan(&subscription.CalendarID, &subscription.KeybaseConvID, &minutesBefore, &subscription.Type)
+		err = rows.Scan(&subscription.CalendarID, &subscription.KeybaseConvID, &minutesBefore, &subscription.Type,
+			&subscription.MentionPolicy)
 		if err != nil {
 			return nil, err
 		}
@@ -450,6 +621,28 @@ func (d *DB) DeleteSubscription(account *Account, subscription Subscription) err
 	})
 }
 
+// GetAllSubscribedConvs returns every conversation with a reminder or daily
+// schedule subscription, for broadcasts.
+func (d *DB) GetAllSubscribedConvs() (res []chat1.ConvIDStr, err error) {
+	rows, err := d.DB.Query(`
+		SELECT keybase_conv_id FROM subscription
+		UNION
+		SELECT keybase_conv_id FROM daily_schedule_subscription
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var convID chat1.ConvIDStr
+		if err := rows.Scan(&convID); err != nil {
+			return res, err
+		}
+		res = append(res, convID)
+	}
+	return res, nil
+}
+
 // Invite
 func (d *DB) InsertInvite(account *Account, invite Invite) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
@@ -494,6 +687,9 @@ func (d *DB) GetInviteAndAccountByUserMessage(keybaseUsername string, messageID
 		return nil, nil, nil
 	case nil:
 		account.Token.Expiry = time.Unix(expiry, 0)
+		if err = d.cipher.DecryptToken(&account.Token); err != nil {
+			return nil, nil, err
+		}
 		return invite, account, nil
 	default:
 		return nil, nil, err
@@ -557,6 +753,9 @@ func (d *DB) GetAggregatedDailyScheduleSubscription(scheduleToSend ScheduleToSen
 			continue
 		}
 		pair.Account.Token.Expiry = time.Unix(tokenExpiry, 0)
+		if err = d.cipher.DecryptToken(&pair.Account.Token); err != nil {
+			return nil, err
+		}
 		subscriptions = append(subscriptions, &pair)
 	}
 	return subscriptions, nil
@@ -604,3 +803,13 @@ func (d *DB) DeleteDailyScheduleSubscription(account *Account, calendarID string
 		return err
 	})
 }
+
+func (d *DB) DeleteDailyScheduleSubscriptionsForConv(keybaseConvID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM daily_schedule_subscription
+			WHERE keybase_conv_id = ?
+		`, keybaseConvID)
+		return err
+	})
+}
@@ -160,3 +160,52 @@ func FormatEventSchedule(
 
 	return strings.Join(formattedEvents, "\n"), nil
 }
+
+func FormatUpcomingEvent(
+	event *calendar.Event,
+	calendarSummary string,
+	now time.Time,
+	timezone *time.Location,
+	format24HourTime bool,
+) (string, error) {
+	message := `%s starts %s
+> When: %s%s%s
+> Calendar: %s
+%s`
+
+	summary := "An event"
+	if event.Summary != "" {
+		summary = fmt.Sprintf("*%s*", event.Summary)
+	}
+
+	start, _, _, err := ParseTime(event.Start, event.End)
+	if err != nil {
+		return "", err
+	}
+
+	when, err := FormatTimeRange(event.Start, event.End, timezone, format24HourTime)
+	if err != nil {
+		return "", err
+	}
+
+	var where string
+	if event.Location != "" {
+		where = fmt.Sprintf("\n> Where: %s", event.Location)
+	}
+
+	var joinOnline string
+	if event.ConferenceData != nil {
+		for _, entryPoint := range event.ConferenceData.EntryPoints {
+			if entryPoint.EntryPointType == "video" {
+				joinOnline = fmt.Sprintf("\n> Join online: %s", strings.TrimPrefix(entryPoint.Uri, "https://"))
+				break
+			}
+		}
+	}
+
+	// strip protocol to skip unfurl prompt
+	url := strings.TrimPrefix(event.HtmlLink, "https://")
+
+	return fmt.Sprintf(message,
+		summary, FormatTimeUntil(start.Sub(now)), when, where, joinOnline, calendarSummary, url), nil
+}
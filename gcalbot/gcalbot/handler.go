@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/url"
-	"strings"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
@@ -17,10 +16,14 @@ import (
 type Handler struct {
 	*base.DebugOutput
 
-	stats *base.StatsRegistry
-	kbc   *kbchat.API
-	db    *DB
-	oauth *oauth2.Config
+	stats  *base.StatsRegistry
+	kbc    *kbchat.API
+	router *base.CommandRouter
+	pager  *base.Pager
+	db     *DB
+	oauth  *oauth2.Config
+
+	templates *base.MessageTemplates
 
 	reminderScheduler ReminderScheduler
 
@@ -36,25 +39,31 @@ func NewHandler(
 	kbc *kbchat.API,
 	debugConfig *base.ChatDebugOutputConfig,
 	db *DB,
+	pager *base.Pager,
+	templates *base.MessageTemplates,
 	oauth *oauth2.Config,
 	reminderScheduler ReminderScheduler,
 	tokenSecret string,
 	httpPrefix string,
 ) *Handler {
-	return &Handler{
+	h := &Handler{
 		DebugOutput:       base.NewDebugOutput("Handler", debugConfig),
 		stats:             stats.SetPrefix("Handler"),
 		kbc:               kbc,
+		pager:             pager,
+		templates:         templates,
 		db:                db,
 		oauth:             oauth,
 		reminderScheduler: reminderScheduler,
 		tokenSecret:       tokenSecret,
 		httpPrefix:        httpPrefix,
 	}
+	h.router = h.newCommandRouter(debugConfig)
+	return h
 }
 
 func (h *Handler) HandleNewConv(conv chat1.ConvSummary) error {
-	welcomeMsg := "Hello! I can get you set up with Google Calendar anytime, just send me `!gcal accounts connect <account nickname>`."
+	welcomeMsg := h.templates.Render(WelcomeTemplate, nil)
 	return base.HandleNewTeam(h.stats, h.DebugOutput, h.kbc, conv, welcomeMsg)
 }
 
@@ -67,43 +76,69 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 		return nil
 	}
 
-	cmd := strings.TrimSpace(msg.Content.Text.Body)
-
-	if !strings.HasPrefix(cmd, "!gcal") {
-		return nil
-	}
-
-	tokens, userErr, err := base.SplitTokens(cmd)
-	if err != nil {
-		return err
-	} else if userErr != "" {
-		h.ChatEcho(msg.ConvID, userErr)
-		return nil
-	}
+	_, err := h.router.Handle(msg)
+	return err
+}
 
-	switch {
-	case strings.HasPrefix(cmd, "!gcal accounts list"):
-		h.stats.Count("accounts list")
-		return h.handleAccountsList(msg)
-	case strings.HasPrefix(cmd, "!gcal accounts connect"):
-		h.stats.Count("accounts connect")
-		return h.handleAccountsConnect(msg, tokens[3:])
-	case strings.HasPrefix(cmd, "!gcal accounts disconnect"):
-		h.stats.Count("accounts disconnect")
-		return h.handleAccountsDisconnect(msg, tokens[3:])
-
-	case strings.HasPrefix(cmd, "!gcal calendars list"):
-		h.stats.Count("calendars list")
-		return h.handleCalendarsList(msg, tokens[3:])
-
-	case strings.HasPrefix(cmd, "!gcal configure"):
-		h.stats.Count("configure")
-		return h.handleConfigure(msg)
-
-	default:
-		h.ChatEcho(msg.ConvID, "Unknown command %q", cmd)
-		return nil
-	}
+func (h *Handler) newCommandRouter(debugConfig *base.ChatDebugOutputConfig) *base.CommandRouter {
+	router := base.NewCommandRouter(h.stats, debugConfig, "!gcal")
+	router.SetTemplates(h.templates)
+	router.Register(
+		base.Command{
+			Name:        "accounts list",
+			Description: "List your connected Google accounts",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleAccountsList(msg)
+			},
+		},
+		base.Command{
+			Name:        "accounts connect",
+			Usage:       "<account nickname>",
+			Description: "Connect a Google account",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleAccountsConnect(msg, args.Positional)
+			},
+		},
+		base.Command{
+			Name:        "accounts disconnect",
+			Usage:       "<account nickname>",
+			Description: "Disconnect a Google account",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleAccountsDisconnect(msg, args.Positional)
+			},
+		},
+		base.Command{
+			Name:        "calendars list",
+			Usage:       "<account nickname>",
+			Description: "List calendars that a Google account is subscribed to",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleCalendarsList(msg, args.Positional)
+			},
+		},
+		base.Command{
+			Name:        "next",
+			Usage:       "[number of events]",
+			Description: "Show your next upcoming events",
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleNext(msg, args.Positional)
+			},
+		},
+		base.Command{
+			Name:        "configure",
+			Description: "Configure Google Calendar notifications for the current conversation",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleConfigure(msg)
+			},
+		},
+	)
+	return router
 }
 
 func (h *Handler) handleReaction(msg chat1.MsgSummary) error {
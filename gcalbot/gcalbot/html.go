@@ -344,9 +344,11 @@ type ConfigPage struct {
 	CalendarID string
 	Calendars  []*calendar.CalendarListEntry
 
-	Reminder        string
-	ReminderOptions []ReminderType
-	Invite          bool
+	Reminder             string
+	ReminderOptions      []ReminderType
+	MentionPolicy        MentionPolicy
+	MentionPolicyOptions []MentionPolicyOption
+	Invite               bool
 
 	DSEnabled         bool
 	DSDays            DaysToSendType
@@ -365,6 +367,11 @@ type ReminderType struct {
 	Minute string
 }
 
+type MentionPolicyOption struct {
+	Title  string
+	Policy MentionPolicy
+}
+
 type DSDaysOption struct {
 	Title string
 	Days  DaysToSendType
@@ -434,6 +441,20 @@ const tmplConfig = `{{template "header" .}}
 		</div>
 		</div>
 
+		{{if .ConvIsPrivate | not}}
+		<div class="column">
+		<label for="mention" class="select-label">When sending reminders, mention... </label>
+		<div class="select-container">
+			<select name="mention">
+				{{range .MentionPolicyOptions}}
+					<option value="{{.Policy}}" {{if eq .Policy $.MentionPolicy}} selected {{end}}>{{.Title}}</option>
+				{{end}}
+			</select>
+			<div class="caret">{{.CaretSVG}}</div>
+		</div>
+		</div>
+		{{end}}
+
 		{{if .ConvIsPrivate}}
 		<div class="row">
 		<label for="invite-input">
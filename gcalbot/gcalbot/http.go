@@ -68,6 +68,12 @@ var reminderOptions = []ReminderType{
 	{"60 minutes before", "60"},
 }
 
+var mentionPolicyOptions = []MentionPolicyOption{
+	{"Nobody", MentionPolicyNone},
+	{"The attendee", MentionPolicyAttendees},
+	{"@here", MentionPolicyHere},
+}
+
 var dsDaysOptions = []DSDaysOption{
 	{"Everyday", DaysToSendEveryday},
 	{"Monday through Friday", DaysToSendMonToFri},
@@ -125,6 +131,7 @@ func (h *HTTPSrv) configHandler(w http.ResponseWriter, r *http.Request) {
 	previousCalendarID := r.Form.Get("previous_calendar")
 
 	reminderInput := r.Form.Get("reminder")
+	mentionInput := ParseMentionPolicy(r.Form.Get("mention"))
 	inviteInput := r.Form.Get("invite")
 	var invite bool
 	if inviteInput != "" {
@@ -169,16 +176,18 @@ func (h *HTTPSrv) configHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	page := ConfigPage{
-		Title:             "gcalbot | config",
-		CaretSVG:          caretSVG,
-		ConvID:            keybaseConvID,
-		ConvHelpText:      GetConvHelpText(keybaseConv.Channel, isPrivate, false),
-		ConvIsPrivate:     isPrivate,
-		Account:           accountNickname,
-		Accounts:          accounts,
-		ReminderOptions:   reminderOptions,
-		DSDaysOptions:     dsDaysOptions,
-		DSScheduleOptions: dsScheduleOptions,
+		Title:                "gcalbot | config",
+		CaretSVG:             caretSVG,
+		ConvID:               keybaseConvID,
+		ConvHelpText:         GetConvHelpText(keybaseConv.Channel, isPrivate, false),
+		ConvIsPrivate:        isPrivate,
+		Account:              accountNickname,
+		Accounts:             accounts,
+		ReminderOptions:      reminderOptions,
+		MentionPolicy:        MentionPolicyNone,
+		MentionPolicyOptions: mentionPolicyOptions,
+		DSDaysOptions:        dsDaysOptions,
+		DSScheduleOptions:    dsScheduleOptions,
 	}
 
 	if accountNickname == "" {
@@ -235,6 +244,7 @@ func (h *HTTPSrv) configHandler(w http.ResponseWriter, r *http.Request) {
 			page.Invite = true
 		case SubscriptionTypeReminder:
 			page.Reminder = strconv.Itoa(GetMinutesFromDuration(subscription.DurationBefore))
+			page.MentionPolicy = ParseMentionPolicy(string(subscription.MentionPolicy))
 		}
 	}
 
@@ -291,6 +301,7 @@ func (h *HTTPSrv) configHandler(w http.ResponseWriter, r *http.Request) {
 
 		// keep changed reminder settings, but don't save them to db
 		page.Reminder = reminderInput
+		page.MentionPolicy = mentionInput
 		page.Invite = invite
 
 		if !dsSubExists {
@@ -308,6 +319,7 @@ func (h *HTTPSrv) configHandler(w http.ResponseWriter, r *http.Request) {
 		// keep changed reminder settings, but don't save them to db
 		page.DSEnabled = false
 		page.Reminder = reminderInput
+		page.MentionPolicy = mentionInput
 		page.Invite = invite
 		h.servePage(w, "config", page)
 		return
@@ -421,12 +433,14 @@ func (h *HTTPSrv) configHandler(w http.ResponseWriter, r *http.Request) {
 				KeybaseConvID:  keybaseConvID,
 				DurationBefore: GetDurationFromMinutes(newMinutesBefore),
 				Type:           SubscriptionTypeReminder,
+				MentionPolicy:  mentionInput,
 			})
 			if err != nil {
 				return
 			}
 		}
 		page.Reminder = reminderInput
+		page.MentionPolicy = mentionInput
 
 		page.Updated = true
 	}
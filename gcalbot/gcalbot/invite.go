@@ -31,9 +31,6 @@ const (
 func (h *Handler) sendEventInvite(account *Account, channel *Channel, event *calendar.Event) error {
 	h.stats.Count("sendEventInvite")
 
-	message := `You've been invited to %s: %s
-Awaiting your response. *Are you going?*`
-
 	var eventType string
 	if event.Recurrence == nil {
 		eventType = "an event"
@@ -62,7 +59,11 @@ Awaiting your response. *Are you going?*`
 		return err
 	}
 
-	sendRes, err := h.kbc.SendMessageByTlfName(account.KeybaseUsername, message, eventType, eventContent)
+	message := h.templates.Render(InviteTemplate, InviteTemplateData{
+		EventType: eventType,
+		Event:     eventContent,
+	})
+	sendRes, err := h.kbc.SendMessageByTlfName(account.KeybaseUsername, "%s", message)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,184 @@
+package gcalbot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	maxNextEvents = 50
+	nextPageSize  = 5
+)
+
+type upcomingEvent struct {
+	event            *calendar.Event
+	start            time.Time
+	calendarSummary  string
+	timezone         *time.Location
+	format24HourTime bool
+}
+
+func (h *Handler) handleNext(msg chat1.MsgSummary, args []string) error {
+	count := 1
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		count, err = strconv.Atoi(args[0])
+		if err != nil || count < 1 || count > maxNextEvents {
+			h.ChatEcho(msg.ConvID, "The number of events must be between 1 and %d.", maxNextEvents)
+			return nil
+		}
+	default:
+		h.ChatEcho(msg.ConvID, "Invalid number of arguments.")
+		return nil
+	}
+
+	accounts, err := h.db.GetAccountListForUsername(msg.Sender.Username)
+	if err != nil {
+		return fmt.Errorf("error fetching accounts from database %q", err)
+	} else if len(accounts) == 0 {
+		h.ChatEcho(msg.ConvID,
+			"You have no connected accounts, connect one with `!gcal accounts connect <account nickname>`.")
+		return nil
+	}
+
+	now := time.Now()
+	var upcoming []upcomingEvent
+	for _, account := range accounts {
+		events, err := h.getUpcomingEventsForAccount(account, now, count)
+		switch err.(type) {
+		case nil:
+		case *oauth2.RetrieveError:
+			h.Debug("error retrieving token: %s", err)
+			continue
+		default:
+			return err
+		}
+		upcoming = append(upcoming, events...)
+	}
+
+	if len(upcoming) == 0 {
+		h.ChatEcho(msg.ConvID, "You have no upcoming events :sunny:")
+		return nil
+	}
+
+	sort.Slice(upcoming, func(i, j int) bool {
+		return upcoming[i].start.Before(upcoming[j].start)
+	})
+	if len(upcoming) > count {
+		upcoming = upcoming[:count]
+	}
+
+	formattedEvents := make([]string, len(upcoming))
+	for index, item := range upcoming {
+		formattedEvents[index], err = FormatUpcomingEvent(item.event, item.calendarSummary, now,
+			item.timezone, item.format24HourTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.pager.Send(msg.ConvID, base.PagedList{
+		Items:     formattedEvents,
+		Separator: "\n\n",
+		PageSize:  nextPageSize,
+	})
+}
+
+// getUpcomingEventsForAccount fetches up to count timed events starting after
+// now for the calendars of the account that have subscriptions, falling back
+// to the primary calendar when nothing is subscribed.
+func (h *Handler) getUpcomingEventsForAccount(
+	account *Account,
+	now time.Time,
+	count int,
+) (upcoming []upcomingEvent, err error) {
+	srv, err := GetCalendarService(account, h.oauth, h.db)
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := h.db.GetChannelListByAccount(account)
+	if err != nil {
+		return nil, err
+	}
+	calendarIDs := make([]string, len(channels))
+	for index, channel := range channels {
+		calendarIDs[index] = channel.CalendarID
+	}
+	if len(calendarIDs) == 0 {
+		calendarIDs = []string{"primary"}
+	}
+
+	timezone, err := GetUserTimezone(srv)
+	if err != nil {
+		return nil, err
+	}
+	format24HourTime, err := GetUserFormat24HourTime(srv)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, calendarID := range calendarIDs {
+		cal, err := srv.Calendars.Get(calendarID).Fields("summary").Do()
+		if err != nil {
+			return nil, err
+		}
+		calendarSummary := fmt.Sprintf("%s [%s]", cal.Summary, account.AccountNickname)
+
+		// fetch a few extra events since declined and all day events are skipped
+		events, err := srv.Events.
+			List(calendarID).
+			TimeMin(now.UTC().Format(time.RFC3339)).
+			SingleEvents(true).
+			OrderBy("startTime").
+			MaxResults(int64(count + 5)).
+			Do()
+		if err != nil {
+			return nil, err
+		}
+
+		var found int
+		for _, event := range events.Items {
+			if found == count {
+				break
+			}
+			if EventStatus(event.Status) == EventStatusCancelled || hasDeclinedEvent(event) {
+				continue
+			}
+			start, _, isAllDay, err := ParseTime(event.Start, event.End)
+			if err != nil {
+				return nil, err
+			}
+			if isAllDay || start.Before(now) {
+				continue
+			}
+			upcoming = append(upcoming, upcomingEvent{
+				event:            event,
+				start:            start,
+				calendarSummary:  calendarSummary,
+				timezone:         timezone,
+				format24HourTime: format24HourTime,
+			})
+			found++
+		}
+	}
+	return upcoming, nil
+}
+
+func hasDeclinedEvent(event *calendar.Event) bool {
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			return ResponseStatus(attendee.ResponseStatus) == ResponseStatusDeclined
+		}
+	}
+	return false
+}
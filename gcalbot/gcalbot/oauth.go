@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"google.golang.org/api/calendar/v3"
 
@@ -42,11 +43,19 @@ func (h *HTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.IsComplete {
-		_, err = w.Write(base.MakeOAuthHTML("gcalbot", "success",
-			`<div class="success"> Success! </div>
-		<div>You can now close this page and return to the Keybase app.</div>`,
-			"/gcalbot/image/logo"))
-		if err != nil {
+		var channel *chat1.ChatChannel
+		if conv, err := h.kbc.GetConversation(req.KeybaseConvID); err == nil {
+			channel = &conv.Channel
+		}
+		if _, err := w.Write(base.MakeOAuthSuccessHTML("gcalbot", "/gcalbot/image/logo", channel)); err != nil {
+			h.Errorf("oauthHandler: unable to write: %v", err)
+		}
+		return
+	} else if time.Since(req.Ctime) > base.OAuthStateTTL {
+		h.Stats.Count("oauthHandler - expired")
+		if _, err := w.Write(base.MakeOAuthHTML("gcalbot", "error",
+			"This link has expired, please run the bot command again!",
+			"/gcalbot/image/logo")); err != nil {
 			h.Errorf("oauthHandler: unable to write: %v", err)
 		}
 		return
@@ -58,7 +67,18 @@ func (h *HTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 		h.showOAuthError(w)
 		return
 	}
-	token, err := h.oauth.Exchange(context.TODO(), code)
+	// claim the state before the exchange so a replayed callback can't
+	// exchange a second code
+	if err = h.db.CompleteState(state); err == base.ErrOAuthStateUsed {
+		err = nil
+		h.Stats.Count("oauthHandler - replayed")
+		h.showOAuthError(w)
+		return
+	} else if err != nil {
+		return
+	}
+	token, err := h.oauth.Exchange(base.HTTPClientContext(context.TODO()), code,
+		base.PKCEExchangeOptions(req.CodeVerifier)...)
 	if err != nil {
 		return
 	}
@@ -72,9 +92,6 @@ func (h *HTTPSrv) oauthHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
-	if err = h.db.CompleteState(state); err != nil {
-		return
-	}
 
 	conv, err := h.kbc.GetConversation(req.KeybaseConvID)
 	if err != nil {
@@ -140,16 +157,23 @@ func (h *Handler) requestOAuth(msg chat1.MsgSummary, accountNickname string) err
 		return err
 	}
 
+	verifier, err := base.NewPKCEVerifier()
+	if err != nil {
+		return err
+	}
 	err = h.db.PutState(state, OAuthRequest{
 		KeybaseUsername: msg.Sender.Username,
 		AccountNickname: accountNickname,
 		KeybaseConvID:   msg.ConvID,
+		CodeVerifier:    verifier,
 	})
 	if err != nil {
 		return err
 	}
 
-	authURL := h.oauth.AuthCodeURL(state, oauth2.ApprovalForce, oauth2.AccessTypeOffline)
+	authOpts := append([]oauth2.AuthCodeOption{oauth2.ApprovalForce, oauth2.AccessTypeOffline},
+		base.PKCEChallengeOptions(verifier)...)
+	authURL := h.oauth.AuthCodeURL(state, authOpts...)
 	_, err = h.kbc.SendMessageByTlfName(msg.Sender.Username,
 		"Visit %s to connect a Google account as '%s'.", authURL, accountNickname)
 	if err != nil {
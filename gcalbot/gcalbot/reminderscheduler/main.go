@@ -15,9 +15,12 @@ type ReminderScheduler struct {
 
 	shutdownCh chan struct{}
 
-	stats *base.StatsRegistry
-	db    *gcalbot.DB
-	oauth *oauth2.Config
+	stats     *base.StatsRegistry
+	db        *gcalbot.DB
+	oauth     *oauth2.Config
+	analytics *base.Analytics
+	templates *base.MessageTemplates
+	leader    *base.LeaderElector
 
 	subscriptionReminders *SubscriptionReminders
 	eventReminders        *EventReminders
@@ -29,6 +32,9 @@ func NewReminderScheduler(
 	debugConfig *base.ChatDebugOutputConfig,
 	db *gcalbot.DB,
 	oauth *oauth2.Config,
+	analytics *base.Analytics,
+	templates *base.MessageTemplates,
+	leader *base.LeaderElector,
 ) *ReminderScheduler {
 	return &ReminderScheduler{
 		stats:                 stats.SetPrefix("ReminderScheduler"),
@@ -36,6 +42,9 @@ func NewReminderScheduler(
 		shutdownCh:            make(chan struct{}),
 		db:                    db,
 		oauth:                 oauth,
+		analytics:             analytics,
+		templates:             templates,
+		leader:                leader,
 		subscriptionReminders: NewSubscriptionReminders(),
 		eventReminders:        NewEventReminders(),
 		minuteReminders:       NewMinuteReminders(),
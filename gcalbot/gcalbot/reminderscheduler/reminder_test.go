@@ -0,0 +1,171 @@
+package reminderscheduler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/bottest"
+	"github.com/keybase/managed-bots/gcalbot/gcalbot"
+)
+
+func TestMain(m *testing.M) { bottest.Main(m) }
+
+// the tables of db.sql the webhook and reminders use
+var testMigrations = []base.Migration{
+	{
+		ID: "gcalbot-test-1",
+		Statements: map[base.Dialect][]string{
+			base.SQLiteDialect: {`
+				CREATE TABLE account (
+					keybase_username text NOT NULL,
+					account_nickname text NOT NULL,
+					ctime datetime NOT NULL,
+					mtime datetime NOT NULL,
+					access_token text NOT NULL,
+					token_type text NOT NULL,
+					refresh_token text NOT NULL,
+					expiry datetime NOT NULL,
+					PRIMARY KEY (keybase_username, account_nickname)
+				)`, `
+				CREATE TABLE channel (
+					channel_id text NOT NULL PRIMARY KEY,
+					keybase_username text NOT NULL,
+					account_nickname text NOT NULL,
+					calendar_id text NOT NULL,
+					resource_id text NOT NULL,
+					expiry datetime NOT NULL,
+					next_sync_token text NOT NULL
+				)`, `
+				CREATE TABLE subscription (
+					keybase_username text NOT NULL,
+					account_nickname text NOT NULL,
+					calendar_id text NOT NULL,
+					keybase_conv_id text NOT NULL,
+					minutes_before integer NOT NULL DEFAULT 0,
+					type text,
+					mention_policy text NOT NULL DEFAULT 'none',
+					PRIMARY KEY (keybase_username, account_nickname, calendar_id, keybase_conv_id, minutes_before, type)
+				)`,
+			},
+		},
+	},
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	require.NoError(t, json.NewEncoder(w).Encode(v))
+}
+
+// TestWebhookReminder follows an event from the calendar webhook to its
+// reminder being sent to the subscribed conversation.
+func TestWebhookReminder(t *testing.T) {
+	fake := bottest.NewFakeChat("gcalbot")
+	defer fake.Close()
+	kbc := fake.Start(t)
+	debugConfig := base.NewChatDebugOutputConfig(kbc, "")
+	stats, err := base.NewStatsRegistry(debugConfig, "")
+	require.NoError(t, err)
+	db := gcalbot.NewDB(bottest.NewDB(t, base.MessageTemplateMigrations, testMigrations), debugConfig)
+
+	start := time.Now().UTC().Truncate(time.Minute).Add(30 * time.Minute)
+	upstream := bottest.NewUpstream()
+	defer upstream.Close()
+	upstream.HandleFunc("/calendar/v3/calendars/primary/events", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]interface{}{
+			"items": []map[string]interface{}{{
+				"id":      "standup",
+				"status":  "confirmed",
+				"summary": "Standup",
+				"start":   map[string]string{"dateTime": start.Format(time.RFC3339)},
+				"end":     map[string]string{"dateTime": start.Add(15 * time.Minute).Format(time.RFC3339)},
+			}},
+			"nextSyncToken": "next",
+		})
+	})
+	upstream.HandleFunc("/calendar/v3/calendars/primary", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]string{"id": "primary", "summary": "Work"})
+	})
+	upstream.HandleFunc("/calendar/v3/users/me/settings/timezone", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]string{"id": "timezone", "value": "UTC"})
+	})
+	upstream.HandleFunc("/calendar/v3/users/me/settings/format24HourTime", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]string{"id": "format24HourTime", "value": "true"})
+	})
+	require.NoError(t, base.SetHTTPClientOptions(base.HTTPClientOptions{Transport: upstream.Transport(), MaxRetries: -1}))
+	defer func() { _ = base.SetHTTPClientOptions(base.HTTPClientOptions{}) }()
+
+	account := gcalbot.Account{
+		KeybaseUsername: "alice",
+		AccountNickname: "work",
+		Token: oauth2.Token{
+			AccessToken: "access",
+			TokenType:   "Bearer",
+			Expiry:      time.Now().UTC().Add(time.Hour),
+		},
+	}
+	require.NoError(t, db.InsertAccount(account))
+	require.NoError(t, db.InsertChannel(&account, gcalbot.Channel{
+		ChannelID:     "channel",
+		CalendarID:    "primary",
+		ResourceID:    "resource",
+		Expiry:        time.Now().UTC().Add(24 * time.Hour),
+		NextSyncToken: "sync",
+	}))
+	convID := chat1.ConvIDStr("deadbeef")
+	require.NoError(t, db.InsertSubscription(&account, gcalbot.Subscription{
+		CalendarID:     "primary",
+		KeybaseConvID:  convID,
+		DurationBefore: 5 * time.Minute,
+		Type:           gcalbot.SubscriptionTypeReminder,
+		MentionPolicy:  gcalbot.MentionPolicyNone,
+	}))
+
+	templates := base.NewMessageTemplates(debugConfig, db.DB)
+	gcalbot.RegisterTemplates(templates)
+	analytics := base.NewAnalytics(stats, debugConfig, db.DB)
+	scheduler := NewReminderScheduler(stats, debugConfig, db, &oauth2.Config{}, analytics, templates, nil)
+	httpSrv := gcalbot.NewHTTPSrv(stats, kbc, debugConfig, db, &oauth2.Config{}, scheduler, nil)
+	defer func() { _ = httpSrv.Shutdown() }()
+
+	req := httptest.NewRequest("POST", "/gcalbot/events/webhook", nil)
+	req.Header.Set("X-Goog-Resource-State", "exists")
+	req.Header.Set("X-Goog-Channel-ID", "channel")
+	req.Header.Set("X-Goog-Resource-ID", "resource")
+	http.DefaultServeMux.ServeHTTP(httptest.NewRecorder(), req)
+
+	var listed bool
+	for _, req := range upstream.Requests() {
+		if req.Path == "/calendar/v3/calendars/primary/events" {
+			require.Equal(t, "Bearer access", req.Header.Get("Authorization"))
+			listed = true
+		}
+	}
+	require.True(t, listed, "events were never listed")
+	channel, _, err := db.GetChannelAndAccountByID("channel")
+	require.NoError(t, err)
+	require.Equal(t, "next", channel.NextSyncToken)
+
+	// nothing is due the minute before
+	scheduler.sendReminders(start.Add(-6 * time.Minute))
+	require.Empty(t, fake.Sent())
+
+	scheduler.sendReminders(start.Add(-5 * time.Minute))
+	sent := fake.WaitForMessage(t, 5*time.Second)
+	require.Equal(t, convID, sent.ConvID)
+	require.True(t, strings.HasPrefix(sent.Body, `"Standup" is starting in 5 minutes: `), sent.Body)
+	require.Contains(t, sent.Body, "> Calendar: Work")
+
+	// each reminder is only sent once
+	scheduler.sendReminders(start.Add(-5 * time.Minute))
+	require.Len(t, fake.Sent(), 1)
+}
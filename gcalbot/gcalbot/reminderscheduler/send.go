@@ -36,11 +36,21 @@ func (r *ReminderScheduler) sendReminderLoop(shutdownCh chan struct{}) error {
 }
 
 func (r *ReminderScheduler) sendReminders(sendMinute time.Time) {
+	// every replica runs the loop, only the leader sends. The others still
+	// drop the minute's reminders so they don't pile up in memory.
+	isLeader := r.leader.IsLeader()
+	if !isLeader {
+		r.stats.Count("sendReminders - not leader")
+	}
 	timestamp := getReminderTimestamp(sendMinute, 0)
 	r.minuteReminders.ForEachReminderMessageInMinute(timestamp, func(msg *ReminderMessage) {
 		for duration := range msg.MinuteReminders {
 			msgTimestamp := getReminderTimestamp(msg.StartTime, duration)
 			if msgTimestamp == timestamp {
+				if !isLeader {
+					delete(msg.MinuteReminders, duration)
+					continue
+				}
 				minutesBefore := gcalbot.GetMinutesFromDuration(duration)
 				var eventSummary string
 				if msg.EventSummary != "" {
@@ -48,14 +58,21 @@ func (r *ReminderScheduler) sendReminders(sendMinute time.Time) {
 				} else {
 					eventSummary = "An event"
 				}
-				if minutesBefore == 0 {
-					r.ChatEcho(msg.KeybaseConvID, "%s is starting now: %s", eventSummary, msg.MsgContent)
-				} else {
-					r.ChatEcho(msg.KeybaseConvID, "%s is starting in %s: %s",
-						eventSummary, gcalbot.MinutesBeforeString(minutesBefore), msg.MsgContent)
+				mention := gcalbot.FormatReminderMention(msg.MentionPolicy, msg.KeybaseUsername)
+				log := r.WithTraceID(msg.TraceID)
+				var startsIn string
+				if minutesBefore != 0 {
+					startsIn = gcalbot.MinutesBeforeString(minutesBefore)
 				}
+				log.ChatEcho(msg.KeybaseConvID, "%s", r.templates.Render(gcalbot.ReminderTemplate, gcalbot.ReminderTemplateData{
+					Mention:  mention,
+					Summary:  eventSummary,
+					StartsIn: startsIn,
+					Content:  msg.MsgContent,
+				}))
 				delete(msg.MinuteReminders, duration)
 				r.stats.Count("sendReminders - reminder")
+				r.analytics.RecordNotification(msg.KeybaseConvID, "reminder")
 			}
 		}
 		if len(msg.MinuteReminders) == 0 {
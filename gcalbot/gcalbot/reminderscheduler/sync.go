@@ -7,6 +7,7 @@ import (
 
 	"golang.org/x/oauth2"
 
+	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/gcalbot/gcalbot"
 	"google.golang.org/api/calendar/v3"
 )
@@ -30,7 +31,9 @@ func (r *ReminderScheduler) eventSyncLoop(shutdownCh chan struct{}) error {
 				return
 			default:
 			}
-			r.syncEvents(&pair.Account, &pair.Subscription)
+			// each subscription sync gets its own trace so its reminders can be followed to the send
+			ctx, _ := base.EnsureTraceID(context.Background())
+			r.syncEvents(ctx, &pair.Account, &pair.Subscription)
 		}
 		r.stats.Value("eventSyncLoop - duration - seconds", time.Since(syncMinute).Seconds())
 	}
@@ -46,15 +49,16 @@ func (r *ReminderScheduler) eventSyncLoop(shutdownCh chan struct{}) error {
 	}
 }
 
-func (r *ReminderScheduler) syncEvents(account *gcalbot.Account, subscription *gcalbot.Subscription) {
+func (r *ReminderScheduler) syncEvents(ctx context.Context, account *gcalbot.Account, subscription *gcalbot.Subscription) {
+	log := r.WithContext(ctx)
 	srv, err := gcalbot.GetCalendarService(account, r.oauth, r.db)
 	switch err.(type) {
 	case nil:
 	case *oauth2.RetrieveError:
-		r.Debug("error retrieving token: %s", err)
+		log.Debug("error retrieving token: %s", err)
 		return
 	default:
-		r.Errorf("error getting calendar service: %s", err)
+		log.Errorf("error getting calendar service: %s", err)
 		return
 	}
 
@@ -67,28 +71,29 @@ func (r *ReminderScheduler) syncEvents(account *gcalbot.Account, subscription *g
 		TimeMin(minTime.Format(time.RFC3339)).
 		TimeMax(maxTime.Format(time.RFC3339)).
 		SingleEvents(true).
-		Pages(context.Background(), func(page *calendar.Events) error {
+		Pages(ctx, func(page *calendar.Events) error {
 			events = append(events, page.Items...)
 			return nil
 		})
 	switch err := err.(type) {
 	case nil:
 	case *oauth2.RetrieveError:
-		r.Errorf("error refreshing token API: %s", err)
+		log.Errorf("error refreshing token API: %s", err)
 		return
 	default:
-		r.Errorf("error getting events from API: %s", err)
+		log.Errorf("error getting events from API: %s", err)
 		return
 	}
 	for _, event := range events {
-		err = r.UpdateOrCreateReminderEvent(account, subscription, event)
+		err = r.UpdateOrCreateReminderEvent(ctx, account, subscription, event)
 		if err != nil {
-			r.Errorf("error updating or creating reminder event: %s", err)
+			log.Errorf("error updating or creating reminder event: %s", err)
 		}
 	}
 }
 
 func (r *ReminderScheduler) UpdateOrCreateReminderEvent(
+	ctx context.Context,
 	account *gcalbot.Account,
 	subscription *gcalbot.Subscription,
 	event *calendar.Event,
@@ -128,7 +133,7 @@ func (r *ReminderScheduler) UpdateOrCreateReminderEvent(
 	switch err.(type) {
 	case nil:
 	case *oauth2.RetrieveError:
-		r.Debug("error retrieving token: %s", err)
+		r.WithContext(ctx).Debug("error retrieving token: %s", err)
 		return nil
 	default:
 		return err
@@ -170,6 +175,8 @@ func (r *ReminderScheduler) UpdateOrCreateReminderEvent(
 
 		reminderMessage.EventSummary = event.Summary
 		reminderMessage.MsgContent = eventMsgContent
+		reminderMessage.MentionPolicy = subscription.MentionPolicy
+		reminderMessage.TraceID = base.TraceIDFromContext(ctx)
 	} else {
 		// create the event
 		r.stats.Count("UpdateOrCreateReminderEvent - create")
@@ -180,9 +187,11 @@ func (r *ReminderScheduler) UpdateOrCreateReminderEvent(
 			AccountNickname: account.AccountNickname,
 			CalendarID:      subscription.CalendarID,
 			KeybaseConvID:   subscription.KeybaseConvID,
+			MentionPolicy:   subscription.MentionPolicy,
 			StartTime:       start,
 			MsgContent:      eventMsgContent,
 			MinuteReminders: make(map[time.Duration]*list.Element),
+			TraceID:         base.TraceIDFromContext(ctx),
 		}
 		reminderMessage.Lock()
 		defer reminderMessage.Unlock()
@@ -225,7 +234,8 @@ func (r *ReminderScheduler) AddSubscription(account *gcalbot.Account, subscripti
 		})
 
 	// do a background sync when a new subscription is added
-	go r.syncEvents(account, &subscription)
+	ctx, _ := base.EnsureTraceID(context.Background())
+	go r.syncEvents(ctx, account, &subscription)
 }
 
 func (r *ReminderScheduler) RemoveSubscription(account *gcalbot.Account, subscription gcalbot.Subscription) {
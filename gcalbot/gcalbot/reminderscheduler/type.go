@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/gcalbot/gcalbot"
 )
 
 type ReminderTimestamp string
@@ -21,9 +22,12 @@ type ReminderMessage struct {
 	AccountNickname string
 	CalendarID      string
 	KeybaseConvID   chat1.ConvIDStr
+	MentionPolicy   gcalbot.MentionPolicy
 
 	StartTime  time.Time
 	MsgContent string
+	// TraceID of the webhook or sync which last updated the reminder
+	TraceID string
 
 	SubscriptionReminder *list.Element
 	EventReminder        *list.Element
@@ -14,9 +14,10 @@ type ScheduleScheduler struct {
 
 	shutdownCh chan struct{}
 
-	stats *base.StatsRegistry
-	db    *gcalbot.DB
-	oauth *oauth2.Config
+	stats  *base.StatsRegistry
+	db     *gcalbot.DB
+	oauth  *oauth2.Config
+	leader *base.LeaderElector
 }
 
 func NewScheduleScheduler(
@@ -24,6 +25,7 @@ func NewScheduleScheduler(
 	debugConfig *base.ChatDebugOutputConfig,
 	db *gcalbot.DB,
 	oauth *oauth2.Config,
+	leader *base.LeaderElector,
 ) *ScheduleScheduler {
 	return &ScheduleScheduler{
 		stats:       stats.SetPrefix("ScheduleScheduler"),
@@ -31,6 +33,7 @@ func NewScheduleScheduler(
 		shutdownCh:  make(chan struct{}),
 		db:          db,
 		oauth:       oauth,
+		leader:      leader,
 	}
 }
 
@@ -43,6 +43,11 @@ func (s *ScheduleScheduler) sendDailyScheduleLoop(shutdownCh chan struct{}) erro
 }
 
 func (s *ScheduleScheduler) sendDailySchedulesForMinute(sendMinute time.Time, shutdownCh chan struct{}) {
+	// every replica runs the loop, only the leader sends
+	if !s.leader.IsLeader() {
+		s.stats.Count("sendDailySchedulesForMinute - not leader")
+		return
+	}
 	if sendMinute.Minute()%30 != 0 {
 		s.Errorf("daily schedule loop out of sync, sendMinute: %s", sendMinute.Format("15:04:05"))
 	}
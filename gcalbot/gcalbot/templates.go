@@ -0,0 +1,39 @@
+package gcalbot
+
+import "github.com/keybase/managed-bots/base"
+
+// MessageTemplates names, see RegisterTemplates
+const (
+	ReminderTemplate = "reminder"
+	InviteTemplate   = "invite"
+	WelcomeTemplate  = "welcome"
+)
+
+// ReminderTemplateData is available to the reminder template, StartsIn is
+// empty once the event starts.
+type ReminderTemplateData struct {
+	Mention  string
+	Summary  string
+	StartsIn string
+	Content  string
+}
+
+// InviteTemplateData is available to the invite template, EventType is "an
+// event" or "a recurring event".
+type InviteTemplateData struct {
+	EventType string
+	Event     string
+}
+
+// RegisterTemplates declares the user facing messages operators can reword.
+func RegisterTemplates(templates *base.MessageTemplates) {
+	templates.Register(ReminderTemplate,
+		`{{.Mention}}{{.Summary}} is starting {{if .StartsIn}}in {{.StartsIn}}{{else}}now{{end}}: {{.Content}}`,
+		"Event reminder, see ReminderTemplateData")
+	templates.Register(InviteTemplate,
+		"You've been invited to {{.EventType}}: {{.Event}}\nAwaiting your response. *Are you going?*",
+		"Event invite sent to the invitee, see InviteTemplateData")
+	templates.Register(WelcomeTemplate,
+		"Hello! I can get you set up with Google Calendar anytime, just send me `!gcal accounts connect <account nickname>`.",
+		"Sent when the bot joins a conversation")
+}
@@ -1,6 +1,7 @@
 package gcalbot
 
 import (
+	"context"
 	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
@@ -14,6 +15,8 @@ type OAuthRequest struct {
 	AccountNickname string
 	KeybaseConvID   chat1.ConvIDStr
 	IsComplete      bool
+	CodeVerifier    string
+	Ctime           time.Time
 }
 
 type Account struct {
@@ -42,11 +45,21 @@ const (
 	SubscriptionTypeReminder SubscriptionType = "reminder"
 )
 
+// MentionPolicy controls who gets notified when a reminder is sent to a team channel
+type MentionPolicy string
+
+const (
+	MentionPolicyNone      MentionPolicy = "none"
+	MentionPolicyAttendees MentionPolicy = "attendees"
+	MentionPolicyHere      MentionPolicy = "here"
+)
+
 type Subscription struct {
 	CalendarID     string
 	KeybaseConvID  chat1.ConvIDStr
 	DurationBefore time.Duration
 	Type           SubscriptionType
+	MentionPolicy  MentionPolicy
 }
 
 type SubscriptionAndAccount struct {
@@ -62,6 +75,7 @@ type Invite struct {
 
 type ReminderScheduler interface {
 	UpdateOrCreateReminderEvent(
+		ctx context.Context,
 		account *Account,
 		subscription *Subscription,
 		event *calendar.Event,
@@ -169,6 +169,28 @@ func MinutesBeforeString(minutesBefore int) string {
 	}
 }
 
+func ParseMentionPolicy(input string) MentionPolicy {
+	switch policy := MentionPolicy(input); policy {
+	case MentionPolicyAttendees, MentionPolicyHere:
+		return policy
+	default:
+		return MentionPolicyNone
+	}
+}
+
+// FormatReminderMention returns the prefix for a reminder message sent with the given policy. The attendee we know
+// of on Keybase is the user who owns the subscribed calendar, so that is who gets mentioned.
+func FormatReminderMention(policy MentionPolicy, keybaseUsername string) string {
+	switch policy {
+	case MentionPolicyAttendees:
+		return fmt.Sprintf("@%s ", keybaseUsername)
+	case MentionPolicyHere:
+		return "@here "
+	default:
+		return ""
+	}
+}
+
 func GetConvHelpText(channel chat1.ChatChannel, convIsPrivateMsg, isKeybaseMessage bool) string {
 	if convIsPrivateMsg {
 		return "in our chat together"
@@ -182,3 +204,32 @@ func GetConvHelpText(channel chat1.ChatChannel, convIsPrivateMsg, isKeybaseMessa
 	}
 	return fmt.Sprintf("for the conversation %s", channel.Name)
 }
+
+func FormatTimeUntil(duration time.Duration) string {
+	minutes := int(duration.Round(time.Minute).Minutes())
+	switch {
+	case minutes <= 0:
+		return "now"
+	case minutes < 60:
+		return fmt.Sprintf("in %s", MinutesBeforeString(minutes))
+	}
+	hours, minutes := minutes/60, minutes%60
+	if hours >= 24 {
+		days, hours := hours/24, hours%24
+		if hours == 0 {
+			return fmt.Sprintf("in %s", pluralize(days, "day"))
+		}
+		return fmt.Sprintf("in %s %s", pluralize(days, "day"), pluralize(hours, "hour"))
+	}
+	if minutes == 0 {
+		return fmt.Sprintf("in %s", pluralize(hours, "hour"))
+	}
+	return fmt.Sprintf("in %s %s", pluralize(hours, "hour"), pluralize(minutes, "minute"))
+}
+
+func pluralize(count int, unit string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
@@ -203,3 +203,30 @@ func TestFormatTimeRange(t *testing.T) {
 		require.Equal(t, expected, actual)
 	})
 }
+
+func TestFormatTimeUntil(t *testing.T) {
+	cases := []struct {
+		duration time.Duration
+		expected string
+	}{
+		{-time.Minute, "now"},
+		{20 * time.Second, "now"},
+		{time.Minute, "in 1 minute"},
+		{25 * time.Minute, "in 25 minutes"},
+		{time.Hour, "in 1 hour"},
+		{time.Hour + 30*time.Minute, "in 1 hour 30 minutes"},
+		{2*time.Hour + time.Minute, "in 2 hours 1 minute"},
+		{24 * time.Hour, "in 1 day"},
+		{50 * time.Hour, "in 2 days 2 hours"},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, gcalbot.FormatTimeUntil(c.duration))
+	}
+}
+
+func TestFormatReminderMention(t *testing.T) {
+	require.Equal(t, "", gcalbot.FormatReminderMention(gcalbot.MentionPolicyNone, "alice"))
+	require.Equal(t, "@alice ", gcalbot.FormatReminderMention(gcalbot.MentionPolicyAttendees, "alice"))
+	require.Equal(t, "@here ", gcalbot.FormatReminderMention(gcalbot.MentionPolicyHere, "alice"))
+	require.Equal(t, "", gcalbot.FormatReminderMention(gcalbot.ParseMentionPolicy("everyone"), "alice"))
+}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"sync"
 	"time"
 
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"golang.org/x/oauth2"
 
 	"github.com/keybase/managed-bots/base"
@@ -16,10 +16,12 @@ import (
 )
 
 func (h *HTTPSrv) handleEventUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := h.WithContext(ctx)
 	var err error
 	defer func() {
 		if err != nil {
-			h.Errorf("error in event update webhook: %s", err)
+			log.Errorf("error in event update webhook: %s", err)
 		}
 	}()
 
@@ -35,7 +37,7 @@ func (h *HTTPSrv) handleEventUpdateWebhook(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return
 	} else if channel == nil {
-		h.Debug("channel not found: %s", channelID)
+		log.Debug("channel not found: %s", channelID)
 		return
 	}
 
@@ -61,7 +63,7 @@ func (h *HTTPSrv) handleEventUpdateWebhook(w http.ResponseWriter, r *http.Reques
 	switch err.(type) {
 	case nil:
 	case *oauth2.RetrieveError:
-		h.Debug("error retrieving token: %s", err)
+		log.Debug("error retrieving token: %s", err)
 		err = nil // clear error
 		return
 	default:
@@ -76,7 +78,7 @@ func (h *HTTPSrv) handleEventUpdateWebhook(w http.ResponseWriter, r *http.Reques
 		// check if the event starts in the next 3 hours before registering it
 		if time.Now().Before(start) && time.Now().Add(3*time.Hour).After(start) {
 			for _, subscription := range reminderSubscriptions {
-				err = h.reminderScheduler.UpdateOrCreateReminderEvent(account, subscription, event)
+				err = h.reminderScheduler.UpdateOrCreateReminderEvent(ctx, account, subscription, event)
 				if err != nil {
 					return
 				}
@@ -117,7 +119,7 @@ func (h *HTTPSrv) handleEventUpdateWebhook(w http.ResponseWriter, r *http.Reques
 		err = srv.Events.
 			List(channel.CalendarID).
 			SyncToken(syncToken).
-			Pages(context.Background(), func(page *calendar.Events) error {
+			Pages(ctx, func(page *calendar.Events) error {
 				if page.NextPageToken == "" {
 					// set the sync token when the page token is empty
 					nextSyncToken = page.NextSyncToken
@@ -154,7 +156,7 @@ func (h *HTTPSrv) handleEventUpdateWebhook(w http.ResponseWriter, r *http.Reques
 
 		if status == EventStatusCancelled {
 			for _, subscription := range reminderSubscriptions {
-				err = h.reminderScheduler.UpdateOrCreateReminderEvent(account, subscription, event)
+				err = h.reminderScheduler.UpdateOrCreateReminderEvent(ctx, account, subscription, event)
 				if err != nil {
 					return
 				}
@@ -278,6 +280,22 @@ func (h *Handler) removeSubscription(
 	return nil
 }
 
+// CleanupConv removes every subscription notifying keybaseConvID, stopping
+// the calendar channels nothing else needs, for when the bot is removed from
+// the conversation.
+func (h *Handler) CleanupConv(keybaseConvID chat1.ConvIDStr) error {
+	pairs, err := h.db.GetSubscriptionAndAccountPairsForConv(keybaseConvID)
+	if err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		if err := h.removeSubscription(&pair.Account, pair.Subscription); err != nil {
+			return err
+		}
+	}
+	return h.db.DeleteDailyScheduleSubscriptionsForConv(keybaseConvID)
+}
+
 func (h *Handler) createEventChannel(account *Account, calendarID string) error {
 	srv, err := GetCalendarService(account, h.oauth, h.db)
 	if err != nil {
@@ -325,9 +343,6 @@ func (h *Handler) createEventChannel(account *Account, calendarID string) error
 
 type RenewChannelScheduler struct {
 	*base.DebugOutput
-	sync.Mutex
-
-	shutdownCh chan struct{}
 
 	stats      *base.StatsRegistry
 	db         *DB
@@ -348,60 +363,34 @@ func NewRenewChannelScheduler(
 		db:          db,
 		config:      config,
 		httpPrefix:  httpPrefix,
-		shutdownCh:  make(chan struct{}),
 	}
 }
 
-func (r *RenewChannelScheduler) Shutdown() (err error) {
-	defer r.Trace(&err, "Shutdown")()
-	r.Lock()
-	defer r.Unlock()
-	if r.shutdownCh != nil {
-		close(r.shutdownCh)
-		r.shutdownCh = nil
+// Task renews channels expiring within a day every hour.
+func (r *RenewChannelScheduler) Task() base.Task {
+	return base.Task{
+		Name:     "renew-channels",
+		Schedule: "@hourly",
+		Jitter:   5 * time.Minute,
+		Run:      r.renewExpiringChannels,
 	}
-	return nil
 }
 
-func (r *RenewChannelScheduler) Run() (err error) {
-	defer r.Trace(&err, "Run")()
-	r.Lock()
-	shutdownCh := r.shutdownCh
-	r.Unlock()
-	r.renewScheduler(shutdownCh)
-	r.Debug("shut down")
-	return nil
-}
-
-func (r *RenewChannelScheduler) renewScheduler(shutdownCh chan struct{}) {
-	ticker := time.NewTicker(time.Hour)
-	defer func() {
-		ticker.Stop()
-		r.Debug("shutting down")
-	}()
-	for {
-		select {
-		case <-shutdownCh:
-			return
-		case renewMinute := <-ticker.C:
-			pairs, err := r.db.GetExpiringChannelAndAccountList()
-			if err != nil {
-				r.Errorf("error getting expiring pairs: %s", err)
-			}
-			for _, pair := range pairs {
-				select {
-				case <-shutdownCh:
-					return
-				default:
-				}
-				err = r.renewChannel(&pair.Account, &pair.Channel)
-				if err != nil {
-					r.Errorf("error renewing channel '%s': %s", pair.Channel.ChannelID, err)
-				}
-			}
-			r.stats.Value("renewScheduler - duration - seconds", time.Since(renewMinute).Seconds())
+func (r *RenewChannelScheduler) renewExpiringChannels(ctx context.Context) error {
+	pairs, err := r.db.GetExpiringChannelAndAccountList()
+	if err != nil {
+		return fmt.Errorf("error getting expiring pairs: %s", err)
+	}
+	for _, pair := range pairs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		err = r.renewChannel(&pair.Account, &pair.Channel)
+		if err != nil {
+			r.Errorf("error renewing channel '%s': %s", pair.Channel.ChannelID, err)
 		}
 	}
+	return nil
 }
 
 func (r *RenewChannelScheduler) renewChannel(account *Account, channel *Channel) error {
@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"github.com/keybase/managed-bots/gcalbot/gcalbot/schedulescheduler"
 
@@ -20,7 +17,6 @@ import (
 	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/gcalbot/gcalbot"
 	"golang.org/x/oauth2/google"
-	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/calendar/v3"
 )
 
@@ -83,6 +79,14 @@ Examples:%s
 !gcal calendars list work%s`,
 		back, back, backs, backs)
 
+	nextDesc := fmt.Sprintf(`Shows your next upcoming events across your subscribed calendars, including how long until they start, where they are and a link to join.
+Defaults to the next event, or pass the number of events to show (up to 50).
+
+Examples:%s
+!gcal next
+!gcal next 3%s`,
+		backs, backs)
+
 	commands := []chat1.UserBotCommandInput{
 		{
 			Name:        "gcal accounts list",
@@ -120,11 +124,28 @@ Examples:%s
 			},
 		},
 
+		{
+			Name:        "gcal next",
+			Description: "Show your next upcoming events",
+			Usage:       "[number of events]",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       "*!gcal next* [number of events]",
+				DesktopBody: nextDesc,
+				MobileBody:  nextDesc,
+			},
+		},
+
 		{
 			Name:        "gcal configure",
 			Description: "Configure Google Calendar notifications for the current conversation",
 		},
 
+		{
+			Name:        "gcal help",
+			Description: "List the available commands",
+			Usage:       "[command]",
+		},
+
 		base.GetFeedbackCommandAdvertisement(s.kbc.GetUsername()),
 	}
 
@@ -144,32 +165,32 @@ func (s *BotServer) getLoginSecret() (secret string, err error) {
 	if s.opts.LoginSecret != "" {
 		return s.opts.LoginSecret, nil
 	}
-	secretPath := filepath.Join(s.opts.KBFSRoot, "login.secret")
-	cmd := s.opts.Command("fs", "read", secretPath)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", secretPath)
-	if err := cmd.Run(); err != nil {
+	secrets, err := s.opts.SecretsProvider(s.opts.KBFSRoot)
+	if err != nil {
 		return "", err
 	}
-	return out.String(), nil
+	out, err := secrets.GetSecret("login.secret")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
 }
 
 func (s *BotServer) getOAuthConfig() (config *oauth2.Config, err error) {
 	defer s.Trace(&err, "getOAuthConfig")()
-	configPath := filepath.Join(s.opts.KBFSRoot, "credentials.json")
-	cmd := s.opts.Command("fs", "read", configPath)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", configPath)
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("could not read credentials.json: %v", err)
+	secrets, err := s.opts.SecretsProvider(s.opts.KBFSRoot)
+	if err != nil {
+		return nil, err
+	}
+	out, err := secrets.GetSecret("credentials.json")
+	if err != nil {
+		return nil, err
 	}
 
 	// If modifying these scopes, drop the saved tokens in the db
 	// Need CalendarReadonlyScope to list calendars and get primary calendar
 	// Need CalendarEventsScope to set a response status for events that a user is invited to
-	config, err = google.ConfigFromJSON(out.Bytes(), calendar.CalendarReadonlyScope, calendar.CalendarEventsScope)
+	config, err = google.ConfigFromJSON(out, calendar.CalendarReadonlyScope, calendar.CalendarEventsScope)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
 	}
@@ -178,13 +199,11 @@ func (s *BotServer) getOAuthConfig() (config *oauth2.Config, err error) {
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return fmt.Errorf("failed to start keybase %v", err)
 	}
-
-	if len(s.opts.KBFSRoot) == 0 {
-		return fmt.Errorf("BOT_KBFS_ROOT must be specified\n")
-	}
+	s.Configure(s.opts.Options)
 
 	config, err := s.getOAuthConfig()
 	if err != nil {
@@ -202,31 +221,83 @@ func (s *BotServer) Go() (err error) {
 		return err
 	}
 
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := gcalbot.NewDB(sdb, debugConfig)
+	cipher, err := s.opts.FieldCipher()
+	if err != nil {
+		return fmt.Errorf("failed to configure encryption %v", err)
+	}
+	db.SetCipher(cipher)
+	if s.opts.EncryptExisting {
+		updated, err := db.EncryptExistingAccounts()
+		if err != nil {
+			return fmt.Errorf("failed to encrypt existing accounts %v", err)
+		}
+		s.Debug("encrypted %d existing accounts", updated)
+		return nil
+	}
 
 	stats = stats.SetPrefix(s.Name())
 	renewScheduler := gcalbot.NewRenewChannelScheduler(stats, debugConfig, db, config, s.opts.HTTPPrefix)
-	reminderScheduler := reminderscheduler.NewReminderScheduler(stats, debugConfig, db, config)
-	scheduleScheduler := schedulescheduler.NewScheduleScheduler(stats, debugConfig, db, config)
-	handler := gcalbot.NewHandler(stats, s.kbc, debugConfig, db, config, reminderScheduler, secret, s.opts.HTTPPrefix)
+	analytics := base.NewAnalytics(stats, debugConfig, db.DB)
+	s.RegisterAnalytics(analytics)
+	templates := base.NewMessageTemplates(debugConfig, db.DB)
+	gcalbot.RegisterTemplates(templates)
+	if err := templates.LoadDir(s.opts.TemplatesDir); err != nil {
+		return fmt.Errorf("failed to load templates %v", err)
+	}
+	s.RegisterAdminCommands(templates.AdminCommands()...)
+	auditLog := base.NewAuditLog(stats, debugConfig, db.DB)
+	s.RegisterAuditLog(auditLog)
+	s.RegisterAdminCommands(auditLog.AdminCommands()...)
+	leader := base.NewLeaderElector(stats, debugConfig, db.DB, s.Name())
+	s.RegisterAdminCommands(leader.AdminCommands()...)
+	reminderScheduler := reminderscheduler.NewReminderScheduler(stats, debugConfig, db, config, analytics, templates, leader)
+	scheduleScheduler := schedulescheduler.NewScheduleScheduler(stats, debugConfig, db, config, leader)
+	pager := base.NewPager(stats, debugConfig)
+	s.RegisterPager(pager)
+	handler := gcalbot.NewHandler(stats, s.kbc, debugConfig, db, pager, templates, config, reminderScheduler, secret, s.opts.HTTPPrefix)
+	s.RegisterAdminCommands(handler.AdminCommands(renewScheduler)...)
+	scheduler := base.NewScheduler(stats, debugConfig)
+	scheduler.SetLeaderElector(leader)
+	for _, task := range []base.Task{renewScheduler.Task(), analytics.Task(), auditLog.Task()} {
+		if err := scheduler.Add(task); err != nil {
+			return err
+		}
+	}
+	s.RegisterAdminCommands(scheduler.AdminCommands()...)
+	broadcaster := base.NewBroadcaster(stats, debugConfig, db.DB, db.GetAllSubscribedConvs)
+	s.RegisterAdminCommands(broadcaster.AdminCommands()...)
+	convGC := base.NewConvGC(stats, debugConfig)
+	convGC.AddHook("subscriptions", handler.CleanupConv)
+	s.RegisterAdminCommands(convGC.AdminCommands()...)
 	httpSrv := gcalbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, config, reminderScheduler, handler)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, renewScheduler.Run)
-	s.GoWithRecover(eg, reminderScheduler.Run)
-	s.GoWithRecover(eg, scheduleScheduler.Run)
-	s.GoWithRecover(eg, func() error {
-		return s.HandleSignals(httpSrv, stats, renewScheduler, reminderScheduler, scheduleScheduler)
-	})
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(scheduler.Run, scheduler)
+	lc.Go(convGC.Run, convGC)
+	lc.Go(analytics.Run, analytics)
+	lc.Go(auditLog.Run, auditLog)
+	lc.Go(reminderScheduler.Run, reminderScheduler)
+	lc.Go(scheduleScheduler.Run, scheduleScheduler)
+	// registered after the schedulers so the lease is released once they stop
+	lc.Go(leader.Run, leader)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(broadcaster)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
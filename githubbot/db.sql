@@ -4,7 +4,10 @@ CREATE TABLE `oauth_state` (
   `conv_id` char(64) NOT NULL,
   `msg_id` char(64) NOT NULL,
   `is_complete` boolean NOT NULL DEFAULT 0,
-  PRIMARY KEY (`state`)
+  `code_verifier` varchar(128) NOT NULL DEFAULT '',
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`state`),
+  KEY `ctime` (`ctime`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 CREATE TABLE `oauth` (
@@ -30,6 +33,20 @@ CREATE TABLE `branches` (
   UNIQUE KEY unique_subscription (`conv_id`, `repo`, `branch`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
+CREATE TABLE `labels` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `label` varchar(128) NOT NULL,
+  UNIQUE KEY unique_subscription (`conv_id`, `repo`, `label`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `discussion_categories` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `category` varchar(128) NOT NULL,
+  UNIQUE KEY unique_subscription (`conv_id`, `repo`, `category`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 CREATE TABLE `features` (
   `conv_id` char(64) NOT NULL,
   `repo` varchar(128) NOT NULL,
@@ -37,12 +54,207 @@ CREATE TABLE `features` (
   `pull_requests` boolean NOT NULL DEFAULT 1,
   `commits` boolean NOT NULL DEFAULT 0,
   `statuses` boolean NOT NULL DEFAULT 1,
+  `releases` boolean NOT NULL DEFAULT 1,
+  `deployments` boolean NOT NULL DEFAULT 1,
+  `discussions` boolean NOT NULL DEFAULT 1,
   UNIQUE KEY unique_subscription (`conv_id`, `repo`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
+CREATE TABLE `notification_threads` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `thread_key` varchar(128) NOT NULL,
+  `msg_id` bigint NOT NULL,
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`conv_id`, `repo`, `thread_key`),
+  KEY `ctime` (`ctime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `stale_prs` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `threshold_hours` int NOT NULL,
+  `dm` boolean NOT NULL DEFAULT 0,
+  PRIMARY KEY (`conv_id`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `digests` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `interval_minutes` int NOT NULL,
+  PRIMARY KEY (`conv_id`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `digest_events` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `kind` varchar(32) NOT NULL,
+  `message` text NOT NULL,
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `conv_repo` (`conv_id`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `security_alerts` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `min_severity` varchar(16) NOT NULL,
+  PRIMARY KEY (`conv_id`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `project_watches` (
+  `conv_id` char(64) NOT NULL,
+  `org` varchar(128) NOT NULL,
+  `number` int NOT NULL,
+  `project_node_id` varchar(128) NOT NULL,
+  `installation_id` bigint NOT NULL,
+  `field` varchar(128) NOT NULL,
+  `columns` text NOT NULL,
+  PRIMARY KEY (`conv_id`, `org`, `number`),
+  KEY `project_node_id` (`project_node_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `issue_rotations` (
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `logins` text NOT NULL,
+  `next_index` int NOT NULL DEFAULT 0,
+  PRIMARY KEY (`conv_id`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 CREATE TABLE `user_prefs` (
   `username` varchar(128) NOT NULL,
   `conv_id` char(64) NOT NULL,
   `mention` tinyint(1) NOT NULL,
   PRIMARY KEY unique_prefs (`username`, `conv_id`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `user_mutes` (
+  `username` varchar(128) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `event_type` varchar(32) NOT NULL,
+  PRIMARY KEY (`username`, `conv_id`, `repo`, `event_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `mention_dms` (
+  `username` varchar(128) NOT NULL,
+  PRIMARY KEY (`username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `jobs` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `queue` varchar(64) NOT NULL,
+  `kind` varchar(64) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `attempts` int NOT NULL,
+  `max_attempts` int NOT NULL,
+  `run_at` datetime(6) NOT NULL,
+  `last_error` text NOT NULL,
+  `ctime` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `queue_run_at` (`queue`, `run_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `dead_jobs` (
+  `id` bigint NOT NULL,
+  `queue` varchar(64) NOT NULL,
+  `kind` varchar(64) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `attempts` int NOT NULL,
+  `last_error` text NOT NULL,
+  `failed_at` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `queue_failed_at` (`queue`, `failed_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `identities` (
+  `keybase_username` varchar(128) NOT NULL,
+  `provider` varchar(32) NOT NULL,
+  `external_id` varchar(255) NOT NULL,
+  `verified` boolean NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`keybase_username`, `provider`),
+  UNIQUE KEY `provider_external_id` (`provider`, `external_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `broadcasts` (
+  `id` varchar(32) NOT NULL,
+  `message` text NOT NULL,
+  `created_by` varchar(128) NOT NULL,
+  `ctime` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `broadcast_deliveries` (
+  `broadcast_id` varchar(32) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`broadcast_id`, `conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_events` (
+  `day` char(10) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `name` varchar(64) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `count` int NOT NULL,
+  PRIMARY KEY (`day`, `kind`, `name`, `conv_id`, `username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_daily` (
+  `day` char(10) NOT NULL,
+  `metric` varchar(96) NOT NULL,
+  `value` bigint NOT NULL,
+  PRIMARY KEY (`day`, `metric`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `workflow_dispatches` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `conv_id` char(64) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `workflow` varchar(255) NOT NULL,
+  `ref` varchar(255) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `run_id` bigint DEFAULT NULL,
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `repo_ref` (`repo`, `ref`),
+  KEY `run_id` (`run_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `leases` (
+  `name` varchar(64) NOT NULL,
+  `holder` varchar(32) NOT NULL,
+  `expire_time` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `audit_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `ctime` datetime(6) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `command` varchar(64) NOT NULL,
+  `args` text NOT NULL,
+  `result` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `duration_ms` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`),
+  KEY `username` (`username`),
+  KEY `conv_id` (`conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `conv_settings` (
+  `conv_id` char(64) NOT NULL,
+  `name` varchar(128) NOT NULL,
+  `value` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`conv_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
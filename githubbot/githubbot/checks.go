@@ -0,0 +1,228 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	checkPassed  = "passed"
+	checkFailed  = "failed"
+	checkPending = "pending"
+	// a required check which hasn't reported on the head commit at all
+	checkMissing = "expected"
+)
+
+var checkStateEmoji = map[string]string{
+	checkPassed:  ":white_check_mark:",
+	checkFailed:  ":x:",
+	checkPending: ":hourglass_flowing_sand:",
+	checkMissing: ":grey_question:",
+}
+
+// checkStates maps the name of each check run and commit status on the
+// pull request's head to its state.
+func checkStates(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) (map[string]string, error) {
+	sha := pr.GetHead().GetSHA()
+	states := make(map[string]string)
+	runs, _, err := client.Checks.ListCheckRunsForRef(ctx, owner, repo, sha, &github.ListCheckRunsOptions{
+		Filter:      github.String("latest"),
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs.CheckRuns {
+		switch {
+		case run.GetStatus() != "completed":
+			states[run.GetName()] = checkPending
+		case isFailingConclusion(run.GetConclusion()) || run.GetConclusion() == "cancelled":
+			states[run.GetName()] = checkFailed
+		default:
+			states[run.GetName()] = checkPassed
+		}
+	}
+	status, _, err := client.Repositories.GetCombinedStatus(ctx, owner, repo, sha, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range status.Statuses {
+		switch s.GetState() {
+		case "pending":
+			states[s.GetContext()] = checkPending
+		case "failure", "error":
+			states[s.GetContext()] = checkFailed
+		default:
+			states[s.GetContext()] = checkPassed
+		}
+	}
+	return states, nil
+}
+
+// formatCheckStatus lists the required checks of a pull request, or all of
+// them when the base branch doesn't require any.
+func formatCheckStatus(ref string, pr *github.PullRequest, required []string, states map[string]string) string {
+	names := required
+	if len(names) == 0 {
+		for name := range states {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var lines []string
+	counts := make(map[string]int)
+	for _, name := range names {
+		state, ok := states[name]
+		if !ok {
+			state = checkMissing
+		}
+		counts[state]++
+		lines = append(lines, fmt.Sprintf("%s %s: %s", checkStateEmoji[state], name, state))
+	}
+
+	header := fmt.Sprintf("*%s* “%s”\n", ref, pr.GetTitle())
+	switch {
+	case len(names) == 0:
+		return header + "No checks have reported on this pull request."
+	case len(required) == 0:
+		header += fmt.Sprintf("%s doesn't require any checks, ", pr.GetBase().GetRef())
+	default:
+		header += fmt.Sprintf("Required checks on %s, ", pr.GetBase().GetRef())
+	}
+	switch {
+	case counts[checkFailed] > 0:
+		header += fmt.Sprintf("%d failing:", counts[checkFailed])
+	case counts[checkPending]+counts[checkMissing] > 0:
+		header += fmt.Sprintf("%d still to finish:", counts[checkPending]+counts[checkMissing])
+	default:
+		header += "all passing:"
+	}
+	return header + "\n" + strings.Join(lines, "\n")
+}
+
+// handlePRStatus reports the required checks of a pull request, as `!github
+// status <owner/repo#number>`.
+func (h *Handler) handlePRStatus(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github status <owner/repo#number>`")
+		return nil
+	}
+	owner, repo, number, ok := parsePRRef(args[0])
+	if !ok {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a pull request to me! Try `!github status <owner/repo#number>`", args[0])
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	pr, res, err := client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("find `%s`", args[0]), res, err)
+	}
+	var required []string
+	checks, res, err := client.Repositories.GetRequiredStatusChecks(ctx, owner, repo, pr.GetBase().GetRef())
+	switch {
+	case err == nil:
+		required = checks.Contexts
+	case res != nil && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden):
+		// the branch isn't protected, or the sender can't see its protection
+	default:
+		return fmt.Errorf("error getting required checks: %s", err)
+	}
+	states, err := checkStates(ctx, client, owner, repo, pr)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("get the checks of `%s`", args[0]), nil, err)
+	}
+	h.ChatEcho(msg.ConvID, formatCheckStatus(args[0], pr, required, states))
+	return nil
+}
+
+// failedWorkflowRuns lists the workflow runs for sha which didn't succeed.
+func failedWorkflowRuns(ctx context.Context, client *github.Client, owner, repo, sha string) (failed []*github.WorkflowRun, err error) {
+	// go-github can't filter runs by commit yet
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/actions/runs?head_sha=%s&per_page=100", owner, repo, sha), nil)
+	if err != nil {
+		return nil, err
+	}
+	var runs github.WorkflowRuns
+	if _, err := client.Do(ctx, req, &runs); err != nil {
+		return nil, err
+	}
+	for _, run := range runs.WorkflowRuns {
+		if run.GetStatus() == "completed" && (isFailingConclusion(run.GetConclusion()) || run.GetConclusion() == "cancelled") {
+			failed = append(failed, run)
+		}
+	}
+	return failed, nil
+}
+
+// handleRerun reruns the failed workflow runs of a pull request's head, as
+// `!github rerun <owner/repo#number>`.
+func (h *Handler) handleRerun(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github rerun <owner/repo#number>`")
+		return nil
+	}
+	ref := args[0]
+	owner, repo, number, ok := parsePRRef(ref)
+	if !ok {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a pull request to me! Try `!github rerun <owner/repo#number>`", ref)
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	login, canWrite, res, err := hasWritePermission(ctx, client, owner, repo)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("check your permissions on `%s/%s`", owner, repo), res, err)
+	}
+	if !canWrite {
+		h.ChatEcho(msg.ConvID, "Sorry, your GitHub account *%s* can't rerun workflows on `%s/%s`.", login, owner, repo)
+		return nil
+	}
+	pr, res, err := client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("find `%s`", ref), res, err)
+	}
+	runs, err := failedWorkflowRuns(ctx, client, owner, repo, pr.GetHead().GetSHA())
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("get the workflow runs of `%s`", ref), nil, err)
+	}
+	if len(runs) == 0 {
+		h.ChatEcho(msg.ConvID, "No workflow runs failed on `%s`, nothing to rerun.", ref)
+		return nil
+	}
+	var rerun []string
+	for _, run := range runs {
+		if res, err := client.Actions.RerunWorkflowByID(ctx, owner, repo, run.GetID()); err != nil {
+			return h.reportPRError(msg, fmt.Sprintf("rerun workflow run %d", run.GetID()), res, err)
+		}
+		rerun = append(rerun, run.GetHTMLURL())
+	}
+	h.stats.CountMult("rerun - runs", len(rerun))
+	return h.pager.Send(msg.ConvID, base.PagedList{
+		Header: fmt.Sprintf("Rerunning %d failed workflow runs of `%s`:", len(rerun), ref),
+		Items:  rerun,
+	})
+}
@@ -0,0 +1,265 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// where GitHub looks for a CODEOWNERS file, in order
+var codeOwnersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+const (
+	// pull requests touching more files than this aren't checked
+	maxCodeOwnerFiles = 300
+	// how many owned files a direct message spells out
+	maxCodeOwnerFilesListed = 5
+)
+
+type codeOwnersRule struct {
+	pattern *regexp.Regexp
+	owners  []string
+}
+
+// parseCodeOwners parses a CODEOWNERS file, skipping patterns it can't make
+// sense of.
+func parseCodeOwners(content string) (rules []codeOwnersRule) {
+	for _, line := range strings.Split(content, "\n") {
+		if index := strings.Index(line, "#"); index >= 0 {
+			line = line[:index]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		pattern, err := codeOwnersPattern(fields[0])
+		if err != nil {
+			continue
+		}
+		rules = append(rules, codeOwnersRule{pattern: pattern, owners: fields[1:]})
+	}
+	return rules
+}
+
+// codeOwnersPattern turns a gitignore style CODEOWNERS pattern into a regexp
+// matching the paths it owns.
+func codeOwnersPattern(pattern string) (*regexp.Regexp, error) {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	// a slash anywhere but at the end anchors the pattern to the root
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return nil, fmt.Errorf("empty pattern")
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	if dirOnly {
+		expr.WriteString("/.*$")
+	} else {
+		// the pattern may name a directory, which owns everything under it
+		expr.WriteString("(?:/.*)?$")
+	}
+	return regexp.Compile(expr.String())
+}
+
+// codeOwnersOf returns the owners of file, the last matching rule wins.
+func codeOwnersOf(rules []codeOwnersRule, file string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].pattern.MatchString(file) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// getCodeOwners fetches the CODEOWNERS file of a repo on ref, nil if it has
+// none.
+func getCodeOwners(ctx context.Context, client *github.Client, owner, repo, ref string) ([]codeOwnersRule, error) {
+	for _, path := range codeOwnersPaths {
+		file, _, res, err := client.Repositories.GetContents(ctx, owner, repo, path,
+			&github.RepositoryContentGetOptions{Ref: ref})
+		if err != nil {
+			if res != nil && res.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, err
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, err
+		}
+		return parseCodeOwners(content), nil
+	}
+	return nil, nil
+}
+
+// codeOwnerLogins expands the owners of files, users and `@org/team` teams,
+// into GitHub logins with the files each of them owns.
+func codeOwnerLogins(ctx context.Context, client *github.Client, rules []codeOwnersRule,
+	files []string) (map[string][]string, error) {
+	owned := make(map[string][]string)
+	for _, file := range files {
+		for _, owner := range codeOwnersOf(rules, file) {
+			// email owners can't be mapped to a login
+			if strings.HasPrefix(owner, "@") {
+				owner = strings.ToLower(strings.TrimPrefix(owner, "@"))
+				owned[owner] = append(owned[owner], file)
+			}
+		}
+	}
+	res := make(map[string][]string)
+	for owner, ownedFiles := range owned {
+		parts := strings.Split(owner, "/")
+		if len(parts) != 2 {
+			res[owner] = append(res[owner], ownedFiles...)
+			continue
+		}
+		opts := &github.TeamListTeamMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			members, resp, err := client.Teams.ListTeamMembersBySlug(ctx, parts[0], parts[1], opts)
+			if err != nil {
+				return nil, fmt.Errorf("error listing members of %s: %s", owner, err)
+			}
+			for _, member := range members {
+				login := strings.ToLower(member.GetLogin())
+				res[login] = append(res[login], ownedFiles...)
+			}
+			if resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+	return res, nil
+}
+
+func formatCodeOwnerMessage(evt *github.PullRequestEvent, author string, files []string) string {
+	pr := evt.GetPullRequest()
+	res := fmt.Sprintf("Pull request #%d by %s on %s is ready for review and touches %d files you own: “%s”\n",
+		pr.GetNumber(), author, evt.GetRepo().GetFullName(), len(files), pr.GetTitle())
+	sort.Strings(files)
+	for index, file := range files {
+		if index == maxCodeOwnerFilesListed {
+			res += fmt.Sprintf("- and %d more\n", len(files)-index)
+			break
+		}
+		res += fmt.Sprintf("- %s\n", file)
+	}
+	return res + pr.GetHTMLURL()
+}
+
+// notifyCodeOwners DMs the Keybase users behind the code owners of the files
+// a new pull request touches, whether or not GitHub requested their review.
+func (h *HTTPSrv) notifyCodeOwners(event *github.PullRequestEvent, client *github.Client) {
+	pr := event.GetPullRequest()
+	switch event.GetAction() {
+	case "opened":
+		if pr.GetDraft() {
+			return
+		}
+	case "ready_for_review":
+	default:
+		return
+	}
+	if pr.GetChangedFiles() > maxCodeOwnerFiles {
+		return
+	}
+	owner, repo := event.GetRepo().GetOwner().GetLogin(), event.GetRepo().GetName()
+	ctx := context.TODO()
+	rules, err := getCodeOwners(ctx, client, owner, repo, pr.GetBase().GetRef())
+	if err != nil {
+		h.Debug("notifyCodeOwners: unable to get CODEOWNERS: %s", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	var files []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, res, err := client.PullRequests.ListFiles(ctx, owner, repo, pr.GetNumber(), opts)
+		if err != nil {
+			h.Debug("notifyCodeOwners: unable to list files: %s", err)
+			return
+		}
+		for _, file := range page {
+			files = append(files, file.GetFilename())
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	owners, err := codeOwnerLogins(ctx, client, rules, files)
+	if err != nil {
+		h.Debug("notifyCodeOwners: %s", err)
+		return
+	}
+
+	// requested reviewers hear about it from the review request
+	skip := map[string]bool{
+		strings.ToLower(pr.GetUser().GetLogin()):      true,
+		strings.ToLower(event.GetSender().GetLogin()): true,
+	}
+	for _, reviewer := range pr.RequestedReviewers {
+		skip[strings.ToLower(reviewer.GetLogin())] = true
+	}
+	author := username{githubUsername: pr.GetUser().GetLogin()}
+	if kb := lookupKBUser(h.kbc, h.identities, h.DebugOutput, author.githubUsername); kb != "" {
+		author.keybaseUsername = &kb
+	}
+	for login, ownedFiles := range owners {
+		if skip[login] {
+			continue
+		}
+		kbUsername := lookupKBUser(h.kbc, h.identities, h.DebugOutput, login)
+		if kbUsername == "" {
+			h.Stats.Count("webhook - code owners - unknown owner")
+			continue
+		}
+		if err := h.queue.EnqueueDirectMessage(kbUsername, formatCodeOwnerMessage(event, author.String(),
+			dedupeStrings(ownedFiles))); err != nil {
+			h.Errorf("unable to queue code owner message for %s: %s", kbUsername, err)
+			continue
+		}
+		h.Stats.Count("webhook - code owners - sent")
+	}
+}
+
+func dedupeStrings(items []string) (res []string) {
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			res = append(res, item)
+		}
+	}
+	return res
+}
@@ -3,6 +3,7 @@ package githubbot
 import (
 	"database/sql"
 	"strings"
+	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
@@ -13,9 +14,9 @@ type DB struct {
 	*base.BaseOAuthDB
 }
 
-func NewDB(db *sql.DB) *DB {
+func NewDB(db *base.DB) *DB {
 	return &DB{
-		BaseOAuthDB: base.NewBaseOAuthDB(db),
+		BaseOAuthDB: base.NewBaseOAuthDBWithDB(db),
 	}
 }
 
@@ -80,13 +81,211 @@ func (d *DB) DeleteSubscriptionsForRepo(convID chat1.ConvIDStr, repo string) err
 func (d *DB) DeleteBranchesForRepo(convID chat1.ConvIDStr, repo string) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
-			DELETE FROM subscriptions
+			DELETE FROM branches
 			WHERE conv_id = ? AND repo = ?
 		`, convID, repo)
 		return err
 	})
 }
 
+// ReplaceBranches makes branches, which may be patterns like `release/*`, the
+// only branches watched for repo.
+func (d *DB) ReplaceBranches(convID chat1.ConvIDStr, repo string, branches []string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM branches
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo); err != nil {
+			return err
+		}
+		for _, branch := range branches {
+			if _, err := tx.Exec(`
+				INSERT IGNORE INTO branches
+				(conv_id, repo, branch)
+				VALUES
+				(?, ?, ?)
+			`, convID, repo, branch); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// label filters
+
+// ReplaceLabels limits issue and pull request notifications for repo to those
+// with one of labels, no labels removes the filter.
+func (d *DB) ReplaceLabels(convID chat1.ConvIDStr, repo string, labels []string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM labels
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo); err != nil {
+			return err
+		}
+		for _, label := range labels {
+			if _, err := tx.Exec(`
+				INSERT IGNORE INTO labels
+				(conv_id, repo, label)
+				VALUES
+				(?, ?, ?)
+			`, convID, repo, label); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) UnwatchLabel(convID chat1.ConvIDStr, repo string, label string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM labels
+			WHERE conv_id = ? AND repo = ? AND label = ?
+		`, convID, repo, label)
+		return err
+	})
+}
+
+func (d *DB) GetLabelsForRepo(convID chat1.ConvIDStr, repo string) ([]string, error) {
+	rows, err := d.DB.Query(`SELECT label
+		FROM labels
+		WHERE conv_id = ? AND repo = ?
+		ORDER BY label`, convID, repo)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	defer rows.Close()
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return res, err
+		}
+		res = append(res, label)
+	}
+	return res, nil
+}
+
+func (d *DB) DeleteLabelsForRepo(convID chat1.ConvIDStr, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM labels
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo)
+		return err
+	})
+}
+
+// DeleteConvData removes everything stored for a conversation, for when the bot
+// is removed from it.
+func (d *DB) DeleteConvData(convID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM subscriptions WHERE conv_id = ?`,
+			`DELETE FROM branches WHERE conv_id = ?`,
+			`DELETE FROM features WHERE conv_id = ?`,
+			`DELETE FROM labels WHERE conv_id = ?`,
+			`DELETE FROM discussion_categories WHERE conv_id = ?`,
+			`DELETE FROM notification_threads WHERE conv_id = ?`,
+			`DELETE FROM stale_prs WHERE conv_id = ?`,
+			`DELETE FROM digests WHERE conv_id = ?`,
+			`DELETE FROM digest_events WHERE conv_id = ?`,
+			`DELETE FROM security_alerts WHERE conv_id = ?`,
+			`DELETE FROM project_watches WHERE conv_id = ?`,
+			`DELETE FROM user_prefs WHERE conv_id = ?`,
+			`DELETE FROM user_mutes WHERE conv_id = ?`,
+			`DELETE FROM workflow_dispatches WHERE conv_id = ?`,
+			`DELETE FROM issue_rotations WHERE conv_id = ?`,
+		} {
+			if _, err := tx.Exec(query, convID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRepoData unsubscribes a conversation from repo and removes its
+// settings for it.
+func (d *DB) DeleteRepoData(convID chat1.ConvIDStr, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		for _, query := range []string{
+			`DELETE FROM subscriptions WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM branches WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM features WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM labels WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM discussion_categories WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM notification_threads WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM stale_prs WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM digests WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM digest_events WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM security_alerts WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM workflow_dispatches WHERE conv_id = ? AND repo = ?`,
+			`DELETE FROM issue_rotations WHERE conv_id = ? AND repo = ?`,
+		} {
+			if _, err := tx.Exec(query, convID, repo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetSubscriptionsForInstallation returns the subscriptions going through an
+// installation, limited to repos unless it's empty.
+func (d *DB) GetSubscriptionsForInstallation(installationID int64, repos []string) (res []DBSubscription, err error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, repo, installation_id
+		FROM subscriptions
+		WHERE installation_id = ?
+	`, installationID)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	filter := make(map[string]bool)
+	for _, repo := range repos {
+		filter[strings.ToLower(repo)] = true
+	}
+	for rows.Next() {
+		var subscription DBSubscription
+		if err := rows.Scan(&subscription.ConvID, &subscription.Repo, &subscription.InstallationID); err != nil {
+			return res, err
+		}
+		if len(filter) > 0 && !filter[strings.ToLower(subscription.Repo)] {
+			continue
+		}
+		res = append(res, subscription)
+	}
+	return res, rows.Err()
+}
+
+// MoveSubscriptions points the subscriptions to repos at installationID, for
+// when the app is reinstalled and its installation ID changes.
+func (d *DB) MoveSubscriptions(repos []string, installationID int64) (moved int64, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		for _, repo := range repos {
+			res, err := tx.Exec(`
+				UPDATE subscriptions
+				SET installation_id = ?
+				WHERE repo = ? AND installation_id != ?
+			`, installationID, repo, installationID)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			moved += n
+		}
+		return nil
+	})
+	return moved, err
+}
+
 func (d *DB) GetConvIDsFromRepoInstallation(repo string, installationID int64) (res []chat1.ConvIDStr, err error) {
 	rows, err := d.DB.Query(`
 		SELECT conv_id
@@ -108,23 +307,36 @@ func (d *DB) GetConvIDsFromRepoInstallation(repo string, installationID int64) (
 	return res, nil
 }
 
+// GetAllSubscribedConvs returns every conversation with a subscription, for
+// broadcasts.
+func (d *DB) GetAllSubscribedConvs() (res []chat1.ConvIDStr, err error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id
+		FROM subscriptions
+		GROUP BY conv_id
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var convID chat1.ConvIDStr
+		if err := rows.Scan(&convID); err != nil {
+			return res, err
+		}
+		res = append(res, convID)
+	}
+	return res, nil
+}
+
+// GetSubscriptionForBranchExists reports whether branch matches one of the
+// branches, or branch patterns, watched for repo.
 func (d *DB) GetSubscriptionForBranchExists(convID chat1.ConvIDStr, repo string, branch string) (exists bool, err error) {
-	row := d.DB.QueryRow(`
-	SELECT 1
-	FROM branches
-	WHERE conv_id = ? AND repo = ? AND branch = ?
-	GROUP BY conv_id
-	`, convID, repo, branch)
-	var rowRes string
-	scanErr := row.Scan(&rowRes)
-	switch scanErr {
-	case sql.ErrNoRows:
-		return false, nil
-	case nil:
-		return true, nil
-	default:
-		return false, scanErr
+	patterns, err := d.GetAllBranchesForRepo(convID, repo)
+	if err != nil {
+		return false, err
 	}
+	return matchBranch(patterns, branch), nil
 }
 
 func (d *DB) GetSubscriptionForRepoExists(convID chat1.ConvIDStr, repo string) (exists bool, err error) {
@@ -164,6 +376,72 @@ func (d *DB) GetAllBranchesForRepo(convID chat1.ConvIDStr, repo string) ([]strin
 	return res, nil
 }
 
+// discussion category filters
+
+// ReplaceCategories limits discussion notifications for repo to those in one
+// of categories, no categories removes the filter.
+func (d *DB) ReplaceCategories(convID chat1.ConvIDStr, repo string, categories []string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM discussion_categories
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo); err != nil {
+			return err
+		}
+		for _, category := range categories {
+			if _, err := tx.Exec(`
+				INSERT IGNORE INTO discussion_categories
+				(conv_id, repo, category)
+				VALUES
+				(?, ?, ?)
+			`, convID, repo, category); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) UnwatchCategory(convID chat1.ConvIDStr, repo string, category string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM discussion_categories
+			WHERE conv_id = ? AND repo = ? AND category = ?
+		`, convID, repo, category)
+		return err
+	})
+}
+
+func (d *DB) GetCategoriesForRepo(convID chat1.ConvIDStr, repo string) ([]string, error) {
+	rows, err := d.DB.Query(`SELECT category
+		FROM discussion_categories
+		WHERE conv_id = ? AND repo = ?
+		ORDER BY category`, convID, repo)
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	defer rows.Close()
+	for rows.Next() {
+		var category string
+		if err := rows.Scan(&category); err != nil {
+			return res, err
+		}
+		res = append(res, category)
+	}
+	return res, nil
+}
+
+func (d *DB) DeleteCategoriesForRepo(convID chat1.ConvIDStr, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM discussion_categories
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo)
+		return err
+	})
+}
+
 // subscription preferences
 
 type Features struct {
@@ -171,6 +449,22 @@ type Features struct {
 	PullRequests bool
 	Commits      bool
 	Statuses     bool
+	Releases     bool
+	Deployments  bool
+	Discussions  bool
+}
+
+// AllFeatures is what a subscription without stored features receives.
+func AllFeatures() *Features {
+	return &Features{
+		Issues:       true,
+		PullRequests: true,
+		Commits:      true,
+		Statuses:     true,
+		Releases:     true,
+		Deployments:  true,
+		Discussions:  true,
+	}
 }
 
 func (f *Features) String() string {
@@ -190,9 +484,18 @@ func (f *Features) String() string {
 	if f.Statuses {
 		res = append(res, "commit statuses")
 	}
+	if f.Releases {
+		res = append(res, "releases")
+	}
+	if f.Deployments {
+		res = append(res, "deployments")
+	}
+	if f.Discussions {
+		res = append(res, "discussions")
+	}
 	if len(res) == 0 {
 		return "no events"
-	} else if len(res) == 4 {
+	} else if len(res) == 7 {
 		return "all events"
 	}
 	return strings.Join(res, ", ")
@@ -202,25 +505,30 @@ func (d *DB) SetFeatures(convID chat1.ConvIDStr, repo string, features *Features
 	return d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
 			INSERT INTO features
-			(conv_id, repo, issues, pull_requests, commits, statuses)
+			(conv_id, repo, issues, pull_requests, commits, statuses, releases, deployments, discussions)
 			VALUES
-			(?, ?, ?, ?, ?, ?)
+			(?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON DUPLICATE KEY UPDATE
 			issues=VALUES(issues),
 			pull_requests=VALUES(pull_requests),
 			commits=VALUES(commits),
-			statuses=VALUES(statuses)
-		`, convID, repo, features.Issues, features.PullRequests, features.Commits, features.Statuses)
+			statuses=VALUES(statuses),
+			releases=VALUES(releases),
+			deployments=VALUES(deployments),
+			discussions=VALUES(discussions)
+		`, convID, repo, features.Issues, features.PullRequests, features.Commits, features.Statuses, features.Releases,
+			features.Deployments, features.Discussions)
 		return err
 	})
 }
 
 func (d *DB) GetFeatures(convID chat1.ConvIDStr, repo string) (*Features, error) {
-	row := d.DB.QueryRow(`SELECT issues, pull_requests, commits, statuses
+	row := d.DB.QueryRow(`SELECT issues, pull_requests, commits, statuses, releases, deployments, discussions
 		FROM features
 		WHERE conv_id = ? AND repo = ?`, convID, repo)
 	features := &Features{}
-	err := row.Scan(&features.Issues, &features.PullRequests, &features.Commits, &features.Statuses)
+	err := row.Scan(&features.Issues, &features.PullRequests, &features.Commits, &features.Statuses, &features.Releases,
+		&features.Deployments, &features.Discussions)
 	switch err {
 	case nil:
 		return features, nil
@@ -233,7 +541,8 @@ func (d *DB) GetFeatures(convID chat1.ConvIDStr, repo string) (*Features, error)
 
 func (d *DB) GetFeaturesForAllRepos(convID chat1.ConvIDStr) (map[string]Features, error) {
 	rows, err := d.DB.Query(`SELECT repo, COALESCE(issues, true), COALESCE(pull_requests, true),
-		COALESCE(commits, true), COALESCE(statuses, true)
+		COALESCE(commits, true), COALESCE(statuses, true), COALESCE(releases, true),
+		COALESCE(deployments, true), COALESCE(discussions, true)
 		FROM subscriptions
 		LEFT JOIN features USING(conv_id, repo)
 		WHERE conv_id = ?`, convID)
@@ -245,7 +554,8 @@ func (d *DB) GetFeaturesForAllRepos(convID chat1.ConvIDStr) (map[string]Features
 	for rows.Next() {
 		var repo string
 		var features Features
-		if err := rows.Scan(&repo, &features.Issues, &features.PullRequests, &features.Commits, &features.Statuses); err != nil {
+		if err := rows.Scan(&repo, &features.Issues, &features.PullRequests, &features.Commits, &features.Statuses,
+			&features.Releases, &features.Deployments, &features.Discussions); err != nil {
 			return nil, err
 		}
 		res[repo] = features
@@ -263,6 +573,604 @@ func (d *DB) DeleteFeaturesForRepo(convID chat1.ConvIDStr, repo string) error {
 	})
 }
 
+// stale pull request reminders
+
+type StalePRSetting struct {
+	ConvID         chat1.ConvIDStr
+	Repo           string
+	InstallationID int64
+	Threshold      time.Duration
+	// DM sends reminders to requested reviewers instead of the conversation
+	DM bool
+}
+
+func (d *DB) SetStalePRSetting(convID chat1.ConvIDStr, repo string, threshold time.Duration, dm bool) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO stale_prs
+			(conv_id, repo, threshold_hours, dm)
+			VALUES
+			(?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			threshold_hours=VALUES(threshold_hours),
+			dm=VALUES(dm)
+		`, convID, repo, int(threshold/time.Hour), dm)
+		return err
+	})
+}
+
+func (d *DB) DeleteStalePRSetting(convID chat1.ConvIDStr, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM stale_prs
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo)
+		return err
+	})
+}
+
+// GetStalePRSettings returns the reminder settings of every subscription
+// which has them.
+func (d *DB) GetStalePRSettings() (res []StalePRSetting, err error) {
+	rows, err := d.DB.Query(`
+		SELECT s.conv_id, s.repo, s.installation_id, p.threshold_hours, p.dm
+		FROM stale_prs p
+		JOIN subscriptions s USING(conv_id, repo)
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var setting StalePRSetting
+		var hours int
+		if err := rows.Scan(&setting.ConvID, &setting.Repo, &setting.InstallationID, &hours, &setting.DM); err != nil {
+			return res, err
+		}
+		setting.Threshold = time.Duration(hours) * time.Hour
+		res = append(res, setting)
+	}
+	return res, rows.Err()
+}
+
+// digests
+
+type DigestEvent struct {
+	Kind    string
+	Message string
+}
+
+type DueDigest struct {
+	ConvID   chat1.ConvIDStr
+	Repo     string
+	Interval time.Duration
+}
+
+func (d *DB) SetDigestInterval(convID chat1.ConvIDStr, repo string, interval time.Duration) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO digests
+			(conv_id, repo, interval_minutes)
+			VALUES
+			(?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			interval_minutes=VALUES(interval_minutes)
+		`, convID, repo, int(interval/time.Minute))
+		return err
+	})
+}
+
+// GetDigestInterval returns how often events of a subscription are batched,
+// zero if they're sent as they come.
+func (d *DB) GetDigestInterval(convID chat1.ConvIDStr, repo string) (time.Duration, error) {
+	row := d.DB.QueryRow(`SELECT interval_minutes
+		FROM digests
+		WHERE conv_id = ? AND repo = ?`, convID, repo)
+	var minutes int
+	switch err := row.Scan(&minutes); err {
+	case nil:
+		return time.Duration(minutes) * time.Minute, nil
+	case sql.ErrNoRows:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+// DeleteDigest turns off batching for a subscription, events still waiting
+// are returned so they can be sent right away.
+func (d *DB) DeleteDigest(convID chat1.ConvIDStr, repo string) (events []DigestEvent, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM digests
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo); err != nil {
+			return err
+		}
+		events, err = takeDigestEvents(tx, convID, repo)
+		return err
+	})
+	return events, err
+}
+
+func (d *DB) AddDigestEvent(convID chat1.ConvIDStr, repo string, event DigestEvent) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO digest_events
+			(conv_id, repo, kind, message, ctime)
+			VALUES
+			(?, ?, ?, ?, NOW())
+		`, convID, repo, event.Kind, event.Message)
+		return err
+	})
+}
+
+// GetDueDigests returns the subscriptions whose oldest waiting event is at
+// least their interval old.
+func (d *DB) GetDueDigests() (res []DueDigest, err error) {
+	rows, err := d.DB.Query(`
+		SELECT d.conv_id, d.repo, d.interval_minutes
+		FROM digests d
+		JOIN digest_events e USING(conv_id, repo)
+		GROUP BY d.conv_id, d.repo, d.interval_minutes
+		HAVING MIN(e.ctime) <= DATE_SUB(NOW(), INTERVAL d.interval_minutes MINUTE)
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var digest DueDigest
+		var minutes int
+		if err := rows.Scan(&digest.ConvID, &digest.Repo, &minutes); err != nil {
+			return res, err
+		}
+		digest.Interval = time.Duration(minutes) * time.Minute
+		res = append(res, digest)
+	}
+	return res, rows.Err()
+}
+
+// TakeDigestEvents removes and returns the events waiting in a digest, oldest
+// first.
+func (d *DB) TakeDigestEvents(convID chat1.ConvIDStr, repo string) (events []DigestEvent, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		events, err = takeDigestEvents(tx, convID, repo)
+		return err
+	})
+	return events, err
+}
+
+func takeDigestEvents(tx *sql.Tx, convID chat1.ConvIDStr, repo string) (events []DigestEvent, err error) {
+	rows, err := tx.Query(`
+		SELECT id, kind, message
+		FROM digest_events
+		WHERE conv_id = ? AND repo = ?
+		ORDER BY id
+		FOR UPDATE
+	`, convID, repo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var maxID int64
+	for rows.Next() {
+		var event DigestEvent
+		if err := rows.Scan(&maxID, &event.Kind, &event.Message); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// the connection is busy until the rows are closed
+	rows.Close()
+	if len(events) == 0 {
+		return nil, nil
+	}
+	_, err = tx.Exec(`
+		DELETE FROM digest_events
+		WHERE conv_id = ? AND repo = ? AND id <= ?
+	`, convID, repo, maxID)
+	return events, err
+}
+
+// security alerts
+
+type SecurityConv struct {
+	ConvID      chat1.ConvIDStr
+	MinSeverity string
+}
+
+// GetSubscriptionInstallation returns the installation a conversation's
+// subscription to repo goes through, zero if it isn't subscribed.
+func (d *DB) GetSubscriptionInstallation(convID chat1.ConvIDStr, repo string) (installationID int64, err error) {
+	row := d.DB.QueryRow(`SELECT installation_id
+		FROM subscriptions
+		WHERE conv_id = ? AND repo = ?
+		LIMIT 1`, convID, repo)
+	switch err := row.Scan(&installationID); err {
+	case nil:
+		return installationID, nil
+	case sql.ErrNoRows:
+		return 0, nil
+	default:
+		return 0, err
+	}
+}
+
+func (d *DB) SetSecurityConv(convID chat1.ConvIDStr, repo, minSeverity string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO security_alerts
+			(conv_id, repo, min_severity)
+			VALUES
+			(?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			min_severity=VALUES(min_severity)
+		`, convID, repo, minSeverity)
+		return err
+	})
+}
+
+func (d *DB) DeleteSecurityConv(convID chat1.ConvIDStr, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM security_alerts
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo)
+		return err
+	})
+}
+
+// GetSecurityConvs returns the conversations which want security alerts for
+// a repo installation.
+func (d *DB) GetSecurityConvs(repo string, installationID int64) (res []SecurityConv, err error) {
+	rows, err := d.DB.Query(`
+		SELECT DISTINCT a.conv_id, a.min_severity
+		FROM security_alerts a
+		JOIN subscriptions s USING(conv_id, repo)
+		WHERE a.repo = ? AND s.installation_id = ?
+	`, repo, installationID)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var conv SecurityConv
+		if err := rows.Scan(&conv.ConvID, &conv.MinSeverity); err != nil {
+			return res, err
+		}
+		res = append(res, conv)
+	}
+	return res, rows.Err()
+}
+
+// project watches
+
+// ProjectWatch follows a Projects v2 board, items entering Columns of Field
+// are announced, any value if Columns is empty.
+type ProjectWatch struct {
+	ConvID         chat1.ConvIDStr
+	Org            string
+	Number         int
+	ProjectNodeID  string
+	InstallationID int64
+	Field          string
+	Columns        []string
+}
+
+func (d *DB) SetProjectWatch(watch ProjectWatch) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO project_watches
+			(conv_id, org, number, project_node_id, installation_id, field, columns)
+			VALUES
+			(?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			project_node_id=VALUES(project_node_id),
+			installation_id=VALUES(installation_id),
+			field=VALUES(field),
+			columns=VALUES(columns)
+		`, watch.ConvID, watch.Org, watch.Number, watch.ProjectNodeID, watch.InstallationID, watch.Field,
+			strings.Join(watch.Columns, ","))
+		return err
+	})
+}
+
+func (d *DB) DeleteProjectWatch(convID chat1.ConvIDStr, org string, number int) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM project_watches
+			WHERE conv_id = ? AND org = ? AND number = ?
+		`, convID, org, number)
+		return err
+	})
+}
+
+func (d *DB) getProjectWatches(query string, args ...interface{}) (res []ProjectWatch, err error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, org, number, project_node_id, installation_id, field, columns
+		FROM project_watches
+	`+query, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var watch ProjectWatch
+		var columns string
+		if err := rows.Scan(&watch.ConvID, &watch.Org, &watch.Number, &watch.ProjectNodeID, &watch.InstallationID,
+			&watch.Field, &columns); err != nil {
+			return res, err
+		}
+		watch.Columns = splitList(columns)
+		res = append(res, watch)
+	}
+	return res, rows.Err()
+}
+
+// GetProjectWatches returns the watches of a project through an installation.
+func (d *DB) GetProjectWatches(projectNodeID string, installationID int64) ([]ProjectWatch, error) {
+	return d.getProjectWatches(`WHERE project_node_id = ? AND installation_id = ?`, projectNodeID, installationID)
+}
+
+func (d *DB) GetProjectWatchesForConv(convID chat1.ConvIDStr) ([]ProjectWatch, error) {
+	return d.getProjectWatches(`WHERE conv_id = ? ORDER BY org, number`, convID)
+}
+
+// issue rotations
+
+// Rotation is the roster of GitHub logins new issues of a repo are assigned
+// to in turn, Next is the index of whoever gets the next one.
+type Rotation struct {
+	Logins []string
+	Next   int
+}
+
+func (d *DB) SetRotation(convID chat1.ConvIDStr, repo string, rotation Rotation) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO issue_rotations
+			(conv_id, repo, logins, next_index)
+			VALUES
+			(?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			logins=VALUES(logins),
+			next_index=VALUES(next_index)
+		`, convID, repo, strings.Join(rotation.Logins, ","), rotation.Next)
+		return err
+	})
+}
+
+func (d *DB) DeleteRotation(convID chat1.ConvIDStr, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM issue_rotations
+			WHERE conv_id = ? AND repo = ?
+		`, convID, repo)
+		return err
+	})
+}
+
+// GetRotation returns the rotation of a subscription, or nil if it has none.
+func (d *DB) GetRotation(convID chat1.ConvIDStr, repo string) (*Rotation, error) {
+	row := d.DB.QueryRow(`SELECT logins, next_index
+		FROM issue_rotations
+		WHERE conv_id = ? AND repo = ?`, convID, repo)
+	var rotation Rotation
+	var logins string
+	switch err := row.Scan(&logins, &rotation.Next); err {
+	case nil:
+		rotation.Logins = splitList(logins)
+		return &rotation, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// NextRotationAssignee returns whoever's turn it is in a subscription's
+// rotation and moves the rotation on, "" if it has none.
+func (d *DB) NextRotationAssignee(convID chat1.ConvIDStr, repo string) (login string, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		row := tx.QueryRow(`SELECT logins, next_index
+			FROM issue_rotations
+			WHERE conv_id = ? AND repo = ?
+			FOR UPDATE`, convID, repo)
+		var logins string
+		var next int
+		switch err := row.Scan(&logins, &next); err {
+		case nil:
+		case sql.ErrNoRows:
+			return nil
+		default:
+			return err
+		}
+		roster := splitList(logins)
+		if len(roster) == 0 {
+			return nil
+		}
+		login = roster[next%len(roster)]
+		_, err := tx.Exec(`
+			UPDATE issue_rotations
+			SET next_index = ?
+			WHERE conv_id = ? AND repo = ?
+		`, (next+1)%len(roster), convID, repo)
+		return err
+	})
+	return login, err
+}
+
+// notification threads
+
+// notificationThreadTTL is how long CI results can be threaded under the
+// push or pull request message they belong to.
+const notificationThreadTTL = 30 * 24 * time.Hour
+
+// PutNotificationThread records the message a push or pull request was
+// announced in, and forgets old threads.
+func (d *DB) PutNotificationThread(convID chat1.ConvIDStr, repo, threadKey string, msgID chat1.MessageID) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			INSERT INTO notification_threads
+			(conv_id, repo, thread_key, msg_id, ctime)
+			VALUES
+			(?, ?, ?, ?, NOW())
+			ON DUPLICATE KEY UPDATE
+			msg_id=VALUES(msg_id),
+			ctime=VALUES(ctime)
+		`, convID, repo, threadKey, msgID); err != nil {
+			return err
+		}
+		// ctime is set by the database's clock, so it's expired by it too
+		_, err := tx.Exec(`
+			DELETE FROM notification_threads
+			WHERE ctime < ` + d.Dialect.IntervalAgo(int(notificationThreadTTL.Seconds())))
+		return err
+	})
+}
+
+// GetNotificationThread returns the message to reply to for threadKey, or
+// nil if there's none.
+func (d *DB) GetNotificationThread(convID chat1.ConvIDStr, repo, threadKey string) (*chat1.MessageID, error) {
+	row := d.DB.QueryRow(`SELECT msg_id
+		FROM notification_threads
+		WHERE conv_id = ? AND repo = ? AND thread_key = ?`, convID, repo, threadKey)
+	var msgID chat1.MessageID
+	switch err := row.Scan(&msgID); err {
+	case nil:
+		return &msgID, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// workflow dispatches
+
+// workflowDispatchTTL is how long a dispatch waits for its run to be
+// reported before it's forgotten.
+const workflowDispatchTTL = 24 * time.Hour
+
+// WorkflowDispatch is a workflow run requested from a conversation, RunID is
+// set once GitHub reports the run it started.
+type WorkflowDispatch struct {
+	ID       int64
+	ConvID   chat1.ConvIDStr
+	Repo     string
+	Workflow string
+	Ref      string
+	Username string
+	RunID    int64
+}
+
+// AddWorkflowDispatch records a dispatch so its run can be followed up on,
+// and forgets old ones. Repos are matched case-insensitively.
+func (d *DB) AddWorkflowDispatch(dispatch WorkflowDispatch) (id int64, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			INSERT INTO workflow_dispatches
+			(conv_id, repo, workflow, ref, username, ctime)
+			VALUES
+			(?, ?, ?, ?, ?, NOW())
+		`, dispatch.ConvID, strings.ToLower(dispatch.Repo), dispatch.Workflow, dispatch.Ref, dispatch.Username)
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		// ctime is set by the database's clock, so it's expired by it too
+		_, err = tx.Exec(`
+			DELETE FROM workflow_dispatches
+			WHERE ctime < ` + d.Dialect.IntervalAgo(int(workflowDispatchTTL.Seconds())))
+		return err
+	})
+	return id, err
+}
+
+// DeleteWorkflowDispatch forgets a dispatch which GitHub didn't accept.
+func (d *DB) DeleteWorkflowDispatch(id int64) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM workflow_dispatches
+			WHERE id = ?
+		`, id)
+		return err
+	})
+}
+
+// GetUnstartedWorkflowDispatches returns the dispatches of repo on ref which
+// aren't tied to a run yet, oldest first.
+func (d *DB) GetUnstartedWorkflowDispatches(repo, ref string) (res []WorkflowDispatch, err error) {
+	rows, err := d.DB.Query(`
+		SELECT id, conv_id, repo, workflow, ref, username
+		FROM workflow_dispatches
+		WHERE repo = ? AND ref = ? AND run_id IS NULL
+		ORDER BY id
+	`, strings.ToLower(repo), ref)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var dispatch WorkflowDispatch
+		if err := rows.Scan(&dispatch.ID, &dispatch.ConvID, &dispatch.Repo, &dispatch.Workflow,
+			&dispatch.Ref, &dispatch.Username); err != nil {
+			return res, err
+		}
+		res = append(res, dispatch)
+	}
+	return res, rows.Err()
+}
+
+// SetWorkflowDispatchRun ties a dispatch to the run it started.
+func (d *DB) SetWorkflowDispatchRun(id, runID int64) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE workflow_dispatches
+			SET run_id = ?
+			WHERE id = ?
+		`, runID, id)
+		return err
+	})
+}
+
+// TakeWorkflowDispatch removes and returns the dispatch which started runID,
+// or nil if the run wasn't requested from chat.
+func (d *DB) TakeWorkflowDispatch(runID int64) (res *WorkflowDispatch, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		row := tx.QueryRow(`
+			SELECT id, conv_id, repo, workflow, ref, username, run_id
+			FROM workflow_dispatches
+			WHERE run_id = ?
+			LIMIT 1
+		`, runID)
+		var dispatch WorkflowDispatch
+		switch err := row.Scan(&dispatch.ID, &dispatch.ConvID, &dispatch.Repo, &dispatch.Workflow,
+			&dispatch.Ref, &dispatch.Username, &dispatch.RunID); err {
+		case nil:
+		case sql.ErrNoRows:
+			return nil
+		default:
+			return err
+		}
+		if _, err := tx.Exec(`
+			DELETE FROM workflow_dispatches
+			WHERE id = ?
+		`, dispatch.ID); err != nil {
+			return err
+		}
+		res = &dispatch
+		return nil
+	})
+	return res, err
+}
+
 // OAuth2 token methods
 
 func (d *DB) GetToken(identifier string) (*oauth2.Token, error) {
@@ -342,6 +1250,116 @@ func (d *DB) SetUserPreferences(username string, convID chat1.ConvIDStr, prefs *
 	return err
 }
 
+// mutes
+
+func (d *DB) AddMutes(username string, convID chat1.ConvIDStr, repo string, eventTypes []string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		for _, eventType := range eventTypes {
+			if _, err := tx.Exec(`INSERT IGNORE INTO user_mutes
+			(username, conv_id, repo, event_type)
+			VALUES (?, ?, ?, ?)
+		`, username, convID, repo, eventType); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteMutes unmutes eventTypes of repo, or all of them if eventTypes is
+// empty.
+func (d *DB) DeleteMutes(username string, convID chat1.ConvIDStr, repo string, eventTypes []string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if len(eventTypes) == 0 {
+			_, err := tx.Exec(`DELETE FROM user_mutes
+			WHERE username = ? AND conv_id = ? AND repo = ?
+		`, username, convID, repo)
+			return err
+		}
+		for _, eventType := range eventTypes {
+			if _, err := tx.Exec(`DELETE FROM user_mutes
+			WHERE username = ? AND conv_id = ? AND repo = ? AND event_type = ?
+		`, username, convID, repo, eventType); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IsMuted reports whether a user muted eventType, or every type, of repo.
+func (d *DB) IsMuted(username string, convID chat1.ConvIDStr, repo, eventType string) (muted bool, err error) {
+	row := d.DB.QueryRow(`SELECT 1
+		FROM user_mutes
+		WHERE username = ? AND conv_id = ? AND repo = ? AND event_type IN (?, ?)
+		LIMIT 1`, username, convID, repo, eventType, allEventTypes)
+	var exists int
+	switch err := row.Scan(&exists); err {
+	case nil:
+		return true, nil
+	case sql.ErrNoRows:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// GetMutes returns the muted event types of a user by repo.
+func (d *DB) GetMutes(username string, convID chat1.ConvIDStr) (map[string][]string, error) {
+	rows, err := d.DB.Query(`SELECT repo, event_type
+		FROM user_mutes
+		WHERE username = ? AND conv_id = ?
+		ORDER BY repo, event_type`, username, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := make(map[string][]string)
+	for rows.Next() {
+		var repo, eventType string
+		if err := rows.Scan(&repo, &eventType); err != nil {
+			return nil, err
+		}
+		res[repo] = append(res[repo], eventType)
+	}
+	return res, rows.Err()
+}
+
+// SetMentionDMs opts a Keybase user in or out of direct messages for GitHub
+// comments that @-mention them.
+func (d *DB) SetMentionDMs(username string, enabled bool) error {
+	err := d.RunTxn(func(tx *sql.Tx) error {
+		if !enabled {
+			_, err := tx.Exec(`DELETE FROM mention_dms
+			WHERE username = ?
+		`, username)
+			return err
+		}
+		_, err := tx.Exec(`INSERT IGNORE INTO mention_dms
+		(username)
+		VALUES (?)
+	`, username)
+		return err
+	})
+	return err
+}
+
+func (d *DB) GetMentionDMs(username string) (enabled bool, err error) {
+	row := d.DB.QueryRow(`SELECT 1
+		FROM mention_dms
+		WHERE username = ?`, username)
+	var exists int
+	err = row.Scan(&exists)
+	switch err {
+	case nil:
+		return true, nil
+	case sql.ErrNoRows:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
 // util
 type DBSubscription struct {
 	ConvID         chat1.ConvIDStr
@@ -0,0 +1,87 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v31/github"
+)
+
+// how many earlier deployments are searched for the last successful one
+const maxPreviousDeployments = 10
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func formatDeploymentMessage(evt *github.DeploymentEvent, username string) string {
+	deployment := evt.GetDeployment()
+	return fmt.Sprintf(":rocket: %s started deploying `%s` (`%s`) to *%s* on %s.",
+		username, deployment.GetRef(), shortSHA(deployment.GetSHA()), deployment.GetEnvironment(), evt.GetRepo().GetName())
+}
+
+// formatDeploymentStatusMessage announces finished deployments, changes is
+// the commit range since the environment's last successful deployment.
+func formatDeploymentStatusMessage(evt *github.DeploymentStatusEvent, username string, changes *github.CommitsComparison) (res string) {
+	deployment := evt.GetDeployment()
+	status := evt.GetDeploymentStatus()
+	repo := evt.GetRepo().GetName()
+	switch status.GetState() {
+	case "success":
+		res = fmt.Sprintf(":white_check_mark: %s deployed `%s` (`%s`) to *%s* on %s.",
+			username, deployment.GetRef(), shortSHA(deployment.GetSHA()), deployment.GetEnvironment(), repo)
+		if changes != nil && changes.GetTotalCommits() > 0 {
+			res += fmt.Sprintf("\n%d commit", changes.GetTotalCommits())
+			if changes.GetTotalCommits() != 1 {
+				res += "s"
+			}
+			res += fmt.Sprintf(" since the last deployment: %s", changes.GetHTMLURL())
+		}
+	case "failure", "error":
+		res = fmt.Sprintf(":x: %s's deployment of `%s` (`%s`) to *%s* on %s failed.",
+			username, deployment.GetRef(), shortSHA(deployment.GetSHA()), deployment.GetEnvironment(), repo)
+		if status.GetDescription() != "" {
+			res += fmt.Sprintf("\n> %s", status.GetDescription())
+		}
+	default:
+		// pending and inactive deployments aren't worth a message
+		return ""
+	}
+	if status.GetTargetURL() != "" {
+		res += "\n" + status.GetTargetURL()
+	}
+	return res
+}
+
+// deploymentChanges compares a deployment to the last successful deployment
+// of the same environment before it, nil if there's none.
+func deploymentChanges(ctx context.Context, client *github.Client, owner, repo string,
+	deployment *github.Deployment) (*github.CommitsComparison, error) {
+	deployments, _, err := client.Repositories.ListDeployments(ctx, owner, repo, &github.DeploymentsListOptions{
+		Environment: deployment.GetEnvironment(),
+		ListOptions: github.ListOptions{PerPage: maxPreviousDeployments + 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, previous := range deployments {
+		if previous.GetID() >= deployment.GetID() || previous.GetSHA() == deployment.GetSHA() {
+			continue
+		}
+		statuses, _, err := client.Repositories.ListDeploymentStatuses(ctx, owner, repo, previous.GetID(),
+			&github.ListOptions{PerPage: 10})
+		if err != nil {
+			return nil, err
+		}
+		for _, status := range statuses {
+			if status.GetState() == "success" {
+				comparison, _, err := client.Repositories.CompareCommits(ctx, owner, repo, previous.GetSHA(), deployment.GetSHA())
+				return comparison, err
+			}
+		}
+	}
+	return nil, nil
+}
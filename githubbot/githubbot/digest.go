@@ -0,0 +1,165 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	minDigestInterval = 15 * time.Minute
+	maxDigestInterval = 24 * time.Hour
+	// how many events of each kind a digest spells out
+	maxDigestEvents = 10
+)
+
+// digest event kinds, in the order digests list them
+const (
+	digestPush = "push"
+	digestCI   = "ci"
+)
+
+var digestKinds = []string{digestPush, digestCI}
+
+// digestKind returns how a low-priority event is grouped in digests, or "" if
+// it should be sent right away. Pushes and passing or pending CI results are
+// batched, failures aren't.
+func digestKind(event interface{}) string {
+	switch event := event.(type) {
+	case *github.PushEvent:
+		return digestPush
+	case *github.CheckRunEvent:
+		if isFailingConclusion(event.GetCheckRun().GetConclusion()) {
+			return ""
+		}
+		return digestCI
+	case *github.StatusEvent:
+		switch event.GetState() {
+		case "failure", "error":
+			return ""
+		}
+		return digestCI
+	default:
+		return ""
+	}
+}
+
+func digestKindTitle(kind string, count int) string {
+	switch kind {
+	case digestPush:
+		if count == 1 {
+			return "1 push"
+		}
+		return fmt.Sprintf("%d pushes", count)
+	default:
+		if count == 1 {
+			return "1 CI result"
+		}
+		return fmt.Sprintf("%d CI results", count)
+	}
+}
+
+// formatDigest groups the events of a digest by kind.
+func formatDigest(repo string, events []DigestEvent) string {
+	byKind := make(map[string][]string)
+	for _, event := range events {
+		byKind[event.Kind] = append(byKind[event.Kind], event.Message)
+	}
+	var counts []string
+	var sections []string
+	for _, kind := range digestKinds {
+		messages := byKind[kind]
+		if len(messages) == 0 {
+			continue
+		}
+		title := digestKindTitle(kind, len(messages))
+		counts = append(counts, title)
+		section := []string{fmt.Sprintf("*%s*", title)}
+		for index, message := range messages {
+			if index == maxDigestEvents {
+				section = append(section, fmt.Sprintf("...and %d more", len(messages)-maxDigestEvents))
+				break
+			}
+			section = append(section, message)
+		}
+		sections = append(sections, strings.Join(section, "\n"))
+	}
+	header := fmt.Sprintf(":newspaper: Digest for %s: %s", repo, strings.Join(counts, ", "))
+	return header + "\n\n" + strings.Join(sections, "\n\n")
+}
+
+// ParseDigestInterval parses intervals like `30m` or `2h`.
+func ParseDigestInterval(value string) (time.Duration, error) {
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval < minDigestInterval || interval > maxDigestInterval {
+		return 0, fmt.Errorf("invalid interval %q", value)
+	}
+	return interval.Truncate(time.Minute), nil
+}
+
+func FormatDigestInterval(interval time.Duration) string {
+	if interval%time.Hour == 0 {
+		return fmt.Sprintf("%dh", interval/time.Hour)
+	}
+	return fmt.Sprintf("%dm", interval/time.Minute)
+}
+
+// DigestScheduler sends the digests of subscriptions that batch low-priority
+// events, see `!github digest`.
+type DigestScheduler struct {
+	*base.DebugOutput
+
+	stats *base.StatsRegistry
+	db    *DB
+	queue *base.JobQueue
+}
+
+func NewDigestScheduler(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB,
+	queue *base.JobQueue) *DigestScheduler {
+	return &DigestScheduler{
+		DebugOutput: base.NewDebugOutput("DigestScheduler", debugConfig),
+		stats:       stats.SetPrefix("DigestScheduler"),
+		db:          db,
+		queue:       queue,
+	}
+}
+
+// Task checks for due digests every few minutes, a digest goes out once its
+// oldest event has waited the subscription's interval.
+func (s *DigestScheduler) Task() base.Task {
+	return base.Task{
+		Name:     "send-digests",
+		Schedule: "*/5 * * * *",
+		Run:      s.sendDigests,
+	}
+}
+
+func (s *DigestScheduler) sendDigests(ctx context.Context) error {
+	digests, err := s.db.GetDueDigests()
+	if err != nil {
+		return fmt.Errorf("error getting due digests: %s", err)
+	}
+	for _, digest := range digests {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		events, err := s.db.TakeDigestEvents(digest.ConvID, digest.Repo)
+		if err != nil {
+			s.Errorf("sendDigests: unable to get events for %s: %s", digest.Repo, err)
+			continue
+		}
+		if len(events) == 0 {
+			continue
+		}
+		if err := s.queue.EnqueueChatSend(digest.ConvID, formatDigest(digest.Repo, events)); err != nil {
+			s.Errorf("sendDigests: unable to queue digest for %s: %s", digest.Repo, err)
+			continue
+		}
+		s.stats.Count("sendDigests - sent")
+	}
+	return nil
+}
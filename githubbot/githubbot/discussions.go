@@ -0,0 +1,103 @@
+package githubbot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/managed-bots/base/git"
+)
+
+// go-github doesn't know Discussions events yet, so they're parsed here
+const (
+	discussionHook        = "discussion"
+	discussionCommentHook = "discussion_comment"
+)
+
+type discussionComment struct {
+	Body    string       `json:"body"`
+	HTMLURL string       `json:"html_url"`
+	User    *github.User `json:"user"`
+}
+
+// discussionEvent is a discussion or discussion_comment webhook, Comment is
+// only set for the latter.
+type discussionEvent struct {
+	Action     string `json:"action"`
+	Discussion struct {
+		Number   int          `json:"number"`
+		Title    string       `json:"title"`
+		Body     string       `json:"body"`
+		HTMLURL  string       `json:"html_url"`
+		User     *github.User `json:"user"`
+		Category struct {
+			Name  string `json:"name"`
+			Emoji string `json:"emoji"`
+		} `json:"category"`
+	} `json:"discussion"`
+	Answer       *discussionComment   `json:"answer"`
+	Comment      *discussionComment   `json:"comment"`
+	Repo         *github.Repository   `json:"repository"`
+	Installation *github.Installation `json:"installation"`
+	Sender       *github.User         `json:"sender"`
+}
+
+func (e *discussionEvent) GetRepo() *github.Repository {
+	return e.Repo
+}
+
+func (e *discussionEvent) GetInstallation() *github.Installation {
+	return e.Installation
+}
+
+func parseDiscussionEvent(payload []byte) (*discussionEvent, error) {
+	var evt discussionEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// eventCategory returns the discussion category of an event, ok is false for
+// events which aren't discussions.
+func eventCategory(event interface{}) (category string, ok bool) {
+	if event, ok := event.(*discussionEvent); ok {
+		return event.Discussion.Category.Name, true
+	}
+	return "", false
+}
+
+// formatDiscussionMessage announces new discussions, new comments on them
+// and accepted answers.
+func formatDiscussionMessage(evt *discussionEvent, username string) string {
+	discussion := evt.Discussion
+	category := discussion.Category.Name
+	if discussion.Category.Emoji != "" {
+		category = discussion.Category.Emoji + " " + category
+	}
+	var res string
+	var body, url string
+	switch {
+	case evt.Comment != nil:
+		if evt.Action != "created" {
+			return ""
+		}
+		res = fmt.Sprintf("%s commented on discussion #%d on %s: “%s”\n", username, discussion.Number,
+			evt.Repo.GetFullName(), discussion.Title)
+		body, url = evt.Comment.Body, evt.Comment.HTMLURL
+	case evt.Action == "created":
+		res = fmt.Sprintf("%s started discussion #%d in %s on %s: “%s”\n", username, discussion.Number, category,
+			evt.Repo.GetFullName(), discussion.Title)
+		body, url = discussion.Body, discussion.HTMLURL
+	case evt.Action == "answered" && evt.Answer != nil:
+		res = fmt.Sprintf("%s marked an answer to discussion #%d on %s: “%s”\n", username, discussion.Number,
+			evt.Repo.GetFullName(), discussion.Title)
+		body, url = evt.Answer.Body, evt.Answer.HTMLURL
+	default:
+		return ""
+	}
+	if excerpt := git.FormatExcerpt(body, maxDMExcerptLen); excerpt != "" {
+		res += excerpt + "\n"
+	}
+	return res + url
+}
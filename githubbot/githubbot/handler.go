@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/bradleyfalzon/ghinstallation"
@@ -21,26 +22,34 @@ type Handler struct {
 	stats       *base.StatsRegistry
 	kbc         *kbchat.API
 	db          *DB
+	pager       *base.Pager
+	identities  *base.IdentityStore
+	settings    *base.SettingsStore
 	oauthConfig *oauth2.Config
 	atr         *ghinstallation.AppsTransport
 	httpPrefix  string
 	appName     string
+	repoStats   *repoStatsCache
 }
 
 var _ base.Handler = (*Handler)(nil)
 
 func NewHandler(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig, db *DB,
-	oauthConfig *oauth2.Config, atr *ghinstallation.AppsTransport,
-	httpPrefix, appName string) *Handler {
+	pager *base.Pager, identities *base.IdentityStore, settings *base.SettingsStore, oauthConfig *oauth2.Config,
+	atr *ghinstallation.AppsTransport, httpPrefix, appName string) *Handler {
 	return &Handler{
 		DebugOutput: base.NewDebugOutput("Handler", debugConfig),
 		stats:       stats.SetPrefix("Handler"),
 		kbc:         kbc,
 		db:          db,
+		pager:       pager,
+		identities:  identities,
+		settings:    settings,
 		oauthConfig: oauthConfig,
 		atr:         atr,
 		httpPrefix:  httpPrefix,
 		appName:     appName,
+		repoStats:   newRepoStatsCache(),
 	}
 }
 
@@ -63,8 +72,8 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 
 	cmd := strings.ToLower(strings.TrimSpace(msg.Content.Text.Body))
 	if !strings.HasPrefix(cmd, "!github") {
-		// ignore non-command message
-		return nil
+		// non-command messages may link to GitHub
+		return h.handleUnfurl(msg)
 	}
 
 	if strings.HasPrefix(cmd, "!github mentions") {
@@ -73,7 +82,19 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 		return h.handleMentionPref(cmd, msg)
 	}
 
-	client := github.NewClient(&http.Client{Transport: h.atr})
+	switch {
+	case strings.HasPrefix(cmd, "!github mutes"):
+		h.stats.Count("mutes")
+		return h.handleListMutes(msg)
+	case strings.HasPrefix(cmd, "!github mute"):
+		h.stats.Count("mute")
+		return h.handleMute(cmd, msg, true)
+	case strings.HasPrefix(cmd, "!github unmute"):
+		h.stats.Count("unmute")
+		return h.handleMute(cmd, msg, false)
+	}
+
+	client := github.NewClient(base.NewHTTPClientWithTransport(h.atr))
 	switch {
 	case strings.HasPrefix(cmd, "!github subscribe"):
 		h.stats.Count("subscribe")
@@ -84,6 +105,63 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 	case strings.HasPrefix(cmd, "!github list"):
 		h.stats.Count("list")
 		return h.handleListSubscriptions(msg)
+	case strings.HasPrefix(cmd, "!github repos"):
+		h.stats.Count("repos")
+		return h.handleListRepos(cmd, msg, client)
+	case strings.HasPrefix(cmd, "!github stats"):
+		h.stats.Count("stats")
+		return h.handleRepoStats(cmd, msg, client)
+	case strings.HasPrefix(cmd, "!github releases latest"):
+		h.stats.Count("releases latest")
+		return h.handleLatestRelease(cmd, msg, client)
+	case strings.HasPrefix(cmd, "!github stale"):
+		h.stats.Count("stale")
+		return h.handleStale(cmd, msg)
+	case strings.HasPrefix(cmd, "!github digest"):
+		h.stats.Count("digest")
+		return h.handleDigest(cmd, msg)
+	case strings.HasPrefix(cmd, "!github security"):
+		h.stats.Count("security")
+		return h.handleSecurity(cmd, msg)
+	case strings.HasPrefix(cmd, "!github unfurl"):
+		h.stats.Count("unfurl pref")
+		return h.handleUnfurlPref(cmd, msg)
+	case strings.HasPrefix(cmd, "!github project"):
+		h.stats.Count("project")
+		return h.handleProject(msg)
+	case strings.HasPrefix(cmd, "!github search"):
+		h.stats.Count("search")
+		return h.handleSearch(msg)
+	case strings.HasPrefix(cmd, "!github issue create"):
+		h.stats.Count("issue create")
+		return h.handleIssueCreate(msg)
+	case strings.HasPrefix(cmd, "!github pr approve"):
+		h.stats.Count("pr approve")
+		return h.handlePRApprove(msg)
+	case strings.HasPrefix(cmd, "!github pr merge"):
+		h.stats.Count("pr merge")
+		return h.handlePRMerge(msg)
+	case strings.HasPrefix(cmd, "!github status"):
+		h.stats.Count("status")
+		return h.handlePRStatus(msg)
+	case strings.HasPrefix(cmd, "!github rerun"):
+		h.stats.Count("rerun")
+		return h.handleRerun(msg)
+	case strings.HasPrefix(cmd, "!github workflow run"):
+		h.stats.Count("workflow run")
+		return h.handleWorkflowRunCommand(msg)
+	case strings.HasPrefix(cmd, "!github rotation"):
+		h.stats.Count("rotation")
+		return h.handleRotation(msg)
+	case strings.HasPrefix(cmd, "!github comment"):
+		h.stats.Count("comment")
+		return h.handleComment(msg)
+	case strings.HasPrefix(cmd, "!github link"):
+		h.stats.Count("link")
+		return h.handleLink(msg)
+	case strings.HasPrefix(cmd, "!github unlink"):
+		h.stats.Count("unlink")
+		return h.identities.HandleUnlink(msg, base.GitHubIdentity)
 	default:
 		h.Debug("ignoring unknown command %q", cmd)
 	}
@@ -99,10 +177,10 @@ func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool,
 		return nil
 	}
 
-	args := toks[2:]
+	args, filters := splitListFlags(toks[2:], eventsFlag, branchesFlag, labelsFlag, categoriesFlag)
 	if len(args) < 1 {
 		if create {
-			h.ChatEcho(msg.ConvID, "I don't understand! Try `!github subscribe <owner/repo>`")
+			h.ChatEcho(msg.ConvID, "I don't understand! Try `!github subscribe <owner/repo> [--events issues,prs] [--branches main,release/*] [--labels bug] [--categories q&a]`")
 		} else {
 			h.ChatEcho(msg.ConvID, "I don't understand! Try `!github unsubscribe <owner/repo>`")
 		}
@@ -124,6 +202,9 @@ func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool,
 	if err != nil {
 		return fmt.Errorf("error checking subscription: %s", err)
 	}
+	if len(filters) > 0 {
+		return h.handleSubscribeFilters(repo, filters, msg, create, alreadyExists, client)
+	}
 	if len(args) == 2 {
 		if !alreadyExists {
 			if create {
@@ -140,12 +221,10 @@ func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool,
 				return nil
 			}
 		}
-		switch args[1] {
-		case "issues", "pulls", "statuses", "commits":
+		if isEventType(args[1]) {
 			return h.handleSubscribeToFeature(repo, args[1], msg, create)
-		default:
-			return h.handleSubscribeToBranch(repo, args[1], msg, create)
 		}
+		return h.handleSubscribeToBranch(repo, args[1], msg, create)
 	}
 
 	if create {
@@ -186,6 +265,33 @@ func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool,
 	if err != nil {
 		return fmt.Errorf("error deleting features: %s", err)
 	}
+
+	err = h.db.DeleteLabelsForRepo(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error deleting labels: %s", err)
+	}
+
+	err = h.db.DeleteCategoriesForRepo(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error deleting discussion categories: %s", err)
+	}
+
+	err = h.db.DeleteStalePRSetting(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error deleting stale PR reminders: %s", err)
+	}
+
+	if _, err = h.db.DeleteDigest(msg.ConvID, repo); err != nil {
+		return fmt.Errorf("error deleting digest: %s", err)
+	}
+
+	if err = h.db.DeleteSecurityConv(msg.ConvID, repo); err != nil {
+		return fmt.Errorf("error deleting security notifications: %s", err)
+	}
+
+	if err = h.db.DeleteRotation(msg.ConvID, repo); err != nil {
+		return fmt.Errorf("error deleting issue rotation: %s", err)
+	}
 	h.ChatEcho(msg.ConvID, "Okay, you won't receive updates for `%s` here.", repo)
 	return nil
 }
@@ -201,9 +307,22 @@ func (h *Handler) handleListSubscriptions(msg chat1.MsgSummary) (err error) {
 		return nil
 	}
 
-	var res string
-	for repo, f := range features {
-		res += fmt.Sprintf("- *%s* (%s)\n", repo, &f)
+	repos := make([]string, 0, len(features))
+	for repo := range features {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	var items []string
+	for _, repo := range repos {
+		f := features[repo]
+		item := fmt.Sprintf("- *%s* (%s)", repo, &f)
+		labels, err := h.db.GetLabelsForRepo(msg.ConvID, repo)
+		if err != nil {
+			return fmt.Errorf("error getting labels for repo: %s", err)
+		}
+		if len(labels) > 0 {
+			item += fmt.Sprintf("\n   labels: %s", formatFilterList(labels, "any"))
+		}
 		if f.Commits {
 			branches, err := h.db.GetAllBranchesForRepo(msg.ConvID, repo)
 			if err != nil {
@@ -211,12 +330,29 @@ func (h *Handler) handleListSubscriptions(msg chat1.MsgSummary) (err error) {
 			}
 
 			for _, branch := range branches {
-				res += fmt.Sprintf("   - %s\n", branch)
+				item += fmt.Sprintf("\n   - %s", branch)
 			}
 		}
+		items = append(items, item)
 	}
-	h.ChatEcho(msg.ConvID, res)
-	return nil
+	return h.pager.Send(msg.ConvID, base.PagedList{Items: items})
+}
+
+// handleLink links the sender's GitHub login, confirmed through OAuth, so
+// events they're involved in mention them even without a Keybase proof.
+func (h *Handler) handleLink(msg chat1.MsgSummary) error {
+	tc, err := base.GetOAuthClient(msg.Sender.Username, msg, h.kbc, h.oauthConfig, h.db,
+		base.GetOAuthOpts{
+			AuthMessageTemplate: "Authorize me to confirm your GitHub login by clicking this link:\n%s",
+		})
+	if err != nil || tc == nil {
+		return err
+	}
+	user, _, err := github.NewClient(tc).Users.Get(context.TODO(), "")
+	if err != nil {
+		return fmt.Errorf("error getting GitHub user: %s", err)
+	}
+	return h.identities.HandleLink(msg, base.GitHubIdentity, user.GetLogin(), true)
 }
 
 func (h *Handler) handleNewSubscription(repo string, msg chat1.MsgSummary, client *github.Client) (created bool, err error) {
@@ -300,21 +436,11 @@ func (h *Handler) handleSubscribeToFeature(repo, feature string, msg chat1.MsgSu
 		return fmt.Errorf("Error getting current features: %s", err)
 	}
 	if currentFeatures == nil {
-		currentFeatures = &Features{}
-	}
-	// "issues", "pulls", "statuses", "commits"
-	switch feature {
-	case "issues":
-		currentFeatures.Issues = enable
-	case "pulls":
-		currentFeatures.PullRequests = enable
-	case "statuses":
-		currentFeatures.Statuses = enable
-	case "commits":
-		currentFeatures.Commits = enable
-	default:
+		currentFeatures = AllFeatures()
+	}
+	if unknown := parseEventTypes(feature, currentFeatures, enable); unknown != "" {
 		// Should never get here if check in handleSubscribe is correct
-		return fmt.Errorf("Error subscribing to feature: %s is not a valid feature", feature)
+		return fmt.Errorf("Error subscribing to feature: %s is not a valid feature", unknown)
 	}
 
 	err = h.db.SetFeatures(msg.ConvID, repo, currentFeatures)
@@ -329,6 +455,124 @@ func (h *Handler) handleSubscribeToFeature(repo, feature string, msg chat1.MsgSu
 	return nil
 }
 
+// handleSubscribeFilters handles `--events`, `--branches`, `--labels` and
+// `--categories`. Subscribing limits the subscription to exactly the listed
+// event types, branches (or patterns like `release/*`), labels and discussion
+// categories, unsubscribing removes the listed ones.
+func (h *Handler) handleSubscribeFilters(repo string, filters map[string]string, msg chat1.MsgSummary,
+	create, alreadyExists bool, client *github.Client) (err error) {
+	if !alreadyExists && !create {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to notifications for `%s`!", repo)
+		return nil
+	}
+	var features *Features
+	if events, ok := filters[eventsFlag]; ok {
+		features = &Features{}
+		if !create {
+			if features, err = h.db.GetFeatures(msg.ConvID, repo); err != nil {
+				return fmt.Errorf("Error getting current features: %s", err)
+			} else if features == nil {
+				features = AllFeatures()
+			}
+		}
+		if unknown := parseEventTypes(events, features, create); unknown != "" {
+			h.ChatEcho(msg.ConvID, "I don't know the event type `%s`! Try one of `issues`, `prs`, `commits`, `statuses`, `releases`, `deployments` or `discussions`.", unknown)
+			return nil
+		}
+	}
+	branches, hasBranches := filters[branchesFlag]
+	if hasBranches && create && len(splitList(branches)) == 0 {
+		h.ChatEcho(msg.ConvID, "Give me at least one branch, like `--branches main,release/*`")
+		return nil
+	}
+
+	if !alreadyExists {
+		created, err := h.handleNewSubscription(repo, msg, client)
+		if err != nil {
+			if _, ok := err.(base.OAuthRequiredError); ok {
+				return nil
+			}
+			return err
+		} else if !created {
+			return nil
+		}
+	}
+
+	var replies []string
+	if features != nil {
+		if err := h.db.SetFeatures(msg.ConvID, repo, features); err != nil {
+			return fmt.Errorf("Error setting features: %s", err)
+		}
+		replies = append(replies, fmt.Sprintf("events: %s", features))
+	}
+	if hasBranches {
+		if create {
+			err = h.db.ReplaceBranches(msg.ConvID, repo, splitList(branches))
+		} else {
+			for _, branch := range splitList(branches) {
+				if err = h.db.UnwatchBranch(msg.ConvID, repo, branch); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error setting branches: %s", err)
+		}
+		current, err := h.db.GetAllBranchesForRepo(msg.ConvID, repo)
+		if err != nil {
+			return fmt.Errorf("error getting branches for repo: %s", err)
+		}
+		replies = append(replies, fmt.Sprintf("branches: %s", formatFilterList(current, "none")))
+	}
+	if labels, ok := filters[labelsFlag]; ok {
+		if create {
+			err = h.db.ReplaceLabels(msg.ConvID, repo, splitList(labels))
+		} else {
+			for _, label := range splitList(labels) {
+				if err = h.db.UnwatchLabel(msg.ConvID, repo, label); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error setting labels: %s", err)
+		}
+		current, err := h.db.GetLabelsForRepo(msg.ConvID, repo)
+		if err != nil {
+			return fmt.Errorf("error getting labels for repo: %s", err)
+		}
+		replies = append(replies, fmt.Sprintf("labels: %s", formatFilterList(current, "any")))
+	}
+	if categories, ok := filters[categoriesFlag]; ok {
+		if create {
+			err = h.db.ReplaceCategories(msg.ConvID, repo, splitList(categories))
+		} else {
+			for _, category := range splitList(categories) {
+				if err = h.db.UnwatchCategory(msg.ConvID, repo, category); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("error setting discussion categories: %s", err)
+		}
+		current, err := h.db.GetCategoriesForRepo(msg.ConvID, repo)
+		if err != nil {
+			return fmt.Errorf("error getting discussion categories for repo: %s", err)
+		}
+		replies = append(replies, fmt.Sprintf("discussion categories: %s", formatFilterList(current, "any")))
+	}
+	h.ChatEcho(msg.ConvID, "Okay, updated `%s` here.\n%s", repo, strings.Join(replies, "\n"))
+	return nil
+}
+
+func formatFilterList(items []string, empty string) string {
+	if len(items) == 0 {
+		return empty
+	}
+	return "`" + strings.Join(items, "`, `") + "`"
+}
+
 func (h *Handler) handleSubscribeToBranch(repo, branch string, msg chat1.MsgSummary, create bool) (err error) {
 	exists, err := h.db.GetSubscriptionForRepoExists(msg.ConvID, repo)
 	if err != nil {
@@ -360,6 +604,177 @@ func (h *Handler) handleSubscribeToBranch(repo, branch string, msg chat1.MsgSumm
 	return nil
 }
 
+func (h *Handler) handleLatestRelease(cmd string, msg chat1.MsgSummary, client *github.Client) (err error) {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[3:]
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github releases latest <owner/repo>`")
+		return nil
+	}
+	repo := args[0]
+	parsedRepo := strings.Split(repo, "/")
+	if len(parsedRepo) != 2 {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a repository to me! Try `!github releases latest <owner/repo>`", repo)
+		return nil
+	}
+	installation, res, err := client.Apps.FindRepositoryInstallation(context.TODO(), parsedRepo[0], parsedRepo[1])
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			h.ChatEcho(msg.ConvID, "I can't see `%s`! Make sure the Keybase integration is installed on your repository, and that the repository exists.\n\ngithub.com/apps/%s/installations/new", repo, h.appName)
+			return nil
+		}
+		return fmt.Errorf("error getting installation: %s", err)
+	}
+	itr := ghinstallation.NewFromAppsTransport(h.atr, installation.GetID())
+	installationClient := github.NewClient(base.NewHTTPClientWithTransport(itr))
+	release, res, err := installationClient.Repositories.GetLatestRelease(context.TODO(), parsedRepo[0], parsedRepo[1])
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			h.ChatEcho(msg.ConvID, "`%s` doesn't have any releases yet.", repo)
+			return nil
+		}
+		return fmt.Errorf("error getting latest release: %s", err)
+	}
+	author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, release.GetAuthor().GetLogin(), msg.ConvID,
+		repo, "releases")
+	h.ChatEcho(msg.ConvID, "%s", formatRelease(parsedRepo[1], release, author.String()))
+	return nil
+}
+
+// handleStale configures reminders of pull requests waiting for review, as
+// `!github stale <owner/repo> [threshold|off] [--dm]`.
+func (h *Handler) handleStale(cmd string, msg chat1.MsgSummary) (err error) {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	var args []string
+	dm := false
+	for _, tok := range toks[2:] {
+		if tok == "--dm" {
+			dm = true
+		} else {
+			args = append(args, tok)
+		}
+	}
+	if len(args) < 1 || len(args) > 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github stale <owner/repo> [48h|2d|off] [--dm]`")
+		return nil
+	}
+
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+		return nil
+	}
+
+	repo := args[0]
+	exists, err := h.db.GetSubscriptionForRepoExists(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error getting subscription: %s", err)
+	} else if !exists {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to updates yet!\nSend this first: `!github subscribe %s`", repo)
+		return nil
+	}
+
+	if len(args) == 2 && args[1] == "off" {
+		if err := h.db.DeleteStalePRSetting(msg.ConvID, repo); err != nil {
+			return fmt.Errorf("error deleting stale PR reminders: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, you won't be reminded of pull requests waiting for review on `%s`.", repo)
+		return nil
+	}
+	threshold := DefaultStalePRThreshold
+	if len(args) == 2 {
+		if threshold, err = ParseStalePRThreshold(args[1]); err != nil {
+			h.ChatEcho(msg.ConvID, "I don't understand `%s`! Try a threshold like `48h` or `2d`.", args[1])
+			return nil
+		}
+	}
+	if err := h.db.SetStalePRSetting(msg.ConvID, repo, threshold, dm); err != nil {
+		return fmt.Errorf("error setting stale PR reminders: %s", err)
+	}
+	if dm {
+		h.ChatEcho(msg.ConvID, "Okay, requested reviewers will be reminded of pull requests on `%s` waiting more than %s. Reviewers I can't find on Keybase are listed here.",
+			repo, FormatStalePRThreshold(threshold))
+	} else {
+		h.ChatEcho(msg.ConvID, "Okay, I'll post pull requests on `%s` waiting for review more than %s here on weekdays.",
+			repo, FormatStalePRThreshold(threshold))
+	}
+	return nil
+}
+
+// handleDigest batches a subscription's pushes and passing CI results into
+// digests, as `!github digest <owner/repo> <interval|off>`.
+func (h *Handler) handleDigest(cmd string, msg chat1.MsgSummary) (err error) {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) != 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github digest <owner/repo> <30m|2h|off>`")
+		return nil
+	}
+
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+		return nil
+	}
+
+	repo := args[0]
+	exists, err := h.db.GetSubscriptionForRepoExists(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error getting subscription: %s", err)
+	} else if !exists {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to updates yet!\nSend this first: `!github subscribe %s`", repo)
+		return nil
+	}
+
+	if args[1] == "off" {
+		events, err := h.db.DeleteDigest(msg.ConvID, repo)
+		if err != nil {
+			return fmt.Errorf("error deleting digest: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I'll post every update for `%s` as it happens.", repo)
+		if len(events) > 0 {
+			h.ChatEcho(msg.ConvID, "%s", formatDigest(repo, events))
+		}
+		return nil
+	}
+	interval, err := ParseDigestInterval(args[1])
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "I don't understand `%s`! Try an interval between %s and %s, like `30m` or `2h`.",
+			args[1], FormatDigestInterval(minDigestInterval), FormatDigestInterval(maxDigestInterval))
+		return nil
+	}
+	if err := h.db.SetDigestInterval(msg.ConvID, repo, interval); err != nil {
+		return fmt.Errorf("error setting digest: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, pushes and passing CI results for `%s` will be batched into a digest every %s. Issues, pull requests, releases and failures are still posted right away.",
+		repo, FormatDigestInterval(interval))
+	return nil
+}
+
 // user preferences
 func (h *Handler) handleMentionPref(cmd string, msg chat1.MsgSummary) (err error) {
 	toks, userErr, err := base.SplitTokens(cmd)
@@ -370,8 +785,11 @@ func (h *Handler) handleMentionPref(cmd string, msg chat1.MsgSummary) (err error
 		return nil
 	}
 	args := toks[2:]
+	if len(args) == 1 && (args[0] == "on" || args[0] == "off") {
+		return h.handleMentionDMs(args[0] == "on", msg)
+	}
 	if len(args) != 1 || (args[0] != "disable" && args[0] != "enable") {
-		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github mentions disable` or `!github mentions enable`.")
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github mentions disable`, `!github mentions enable` or `!github mentions on`.")
 		return nil
 	}
 
@@ -388,3 +806,17 @@ func (h *Handler) handleMentionPref(cmd string, msg chat1.MsgSummary) (err error
 	}
 	return nil
 }
+
+// handleMentionDMs toggles direct messages for GitHub comments that @-mention
+// the sender, wherever the comment was posted.
+func (h *Handler) handleMentionDMs(enabled bool, msg chat1.MsgSummary) error {
+	if err := h.db.SetMentionDMs(msg.Sender.Username, enabled); err != nil {
+		return fmt.Errorf("error setting mention DMs: %s", err)
+	}
+	if !enabled {
+		h.ChatEcho(msg.ConvID, "Okay, I won't message you when you're mentioned on GitHub.")
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll message you when a GitHub comment mentions your linked or proven GitHub account, on repositories someone subscribed to. Use `!github link` if your account isn't linked yet.")
+	return nil
+}
@@ -25,43 +25,104 @@ type HTTPSrv struct {
 	db      *DB
 	handler *Handler
 	atr     *ghinstallation.AppsTransport
-	secret  string
+	queue   *base.JobQueue
+
+	identities *base.IdentityStore
+	analytics  *base.Analytics
+
+	// summarizeChecks posts one message per failed check suite rather than
+	// one per failed check run, threadCI replies with CI results to the
+	// push or pull request message they're for
+	summarizeChecks bool
+	threadCI        bool
+	// threadUpdates replies with pull request and issue events, reviews
+	// included, to the message that announced the pull request or issue
+	threadUpdates bool
 }
 
 func NewHTTPSrv(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig, db *DB, handler *Handler,
-	oauthConfig *oauth2.Config, atr *ghinstallation.AppsTransport, secret string) *HTTPSrv {
+	oauthConfig *oauth2.Config, atr *ghinstallation.AppsTransport, queue *base.JobQueue, identities *base.IdentityStore,
+	analytics *base.Analytics, secret string) *HTTPSrv {
 	h := &HTTPSrv{
-		kbc:     kbc,
-		db:      db,
-		handler: handler,
-		atr:     atr,
-		secret:  secret,
+		kbc:        kbc,
+		db:         db,
+		handler:    handler,
+		atr:        atr,
+		queue:      queue,
+		identities: identities,
+		analytics:  analytics,
 	}
+	queue.RegisterHandler(NotificationJobKind, h.handleNotificationJob)
 	h.OAuthHTTPSrv = base.NewOAuthHTTPSrv(stats, kbc, debugConfig, oauthConfig, h.db, h.handler.HandleAuth,
 		"githubbot", base.Images["logo"], "/githubbot")
 	http.HandleFunc("/githubbot", h.handleHealthCheck)
-	http.HandleFunc("/githubbot/webhook", h.handleWebhook)
+	var verifiers []base.WebhookVerifier
+	if secret != "" {
+		verifiers = append(verifiers, base.AnyWebhookVerifier(
+			base.HMACSHA256Verifier("X-Hub-Signature-256", "sha256=", []byte(secret)),
+			base.HMACSHA1Verifier("X-Hub-Signature", "sha1=", []byte(secret)),
+		))
+	}
+	h.HandleVerified("/githubbot/webhook", h.handleWebhook, verifiers...)
 	return h
 }
 
+// SetCIOptions configures CI notifications, see summarizeChecks and threadCI.
+func (h *HTTPSrv) SetCIOptions(summarizeChecks, threadCI bool) {
+	h.summarizeChecks = summarizeChecks
+	h.threadCI = threadCI
+}
+
+// SetThreadUpdates configures threading of pull request and issue updates,
+// see threadUpdates.
+func (h *HTTPSrv) SetThreadUpdates(threadUpdates bool) {
+	h.threadUpdates = threadUpdates
+}
+
 func (h *HTTPSrv) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "beep boop! :)")
 }
 
 func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	payload, err := github.ValidatePayload(r, []byte(h.secret))
+	// the signature was already checked by base.HTTPSrv.Verify, this only
+	// extracts the payload from form or JSON bodies
+	payload, err := github.ValidatePayload(r, nil)
 	if err != nil {
 		h.Debug("Error validating payload (%s): %v\n", r.Header.Get("X-GitHub-Delivery"), err)
 		h.Stats.Count("webhook - invalid payload")
 		return
 	}
 
-	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	switch github.WebHookType(r) {
+	case dependabotAlertHook:
+		h.handleDependabotAlert(payload)
+		return
+	case projectItemHook:
+		h.handleProjectItem(payload)
+		return
+	case workflowRunHook:
+		h.handleWorkflowRun(payload)
+		return
+	}
+
+	var event interface{}
+	switch github.WebHookType(r) {
+	case discussionHook, discussionCommentHook:
+		event, err = parseDiscussionEvent(payload)
+	default:
+		event, err = github.ParseWebHook(github.WebHookType(r), payload)
+	}
 	if err != nil {
 		h.Debug("could not parse webhook: type:%s %s\n", github.WebHookType(r), err)
 		return
 	}
 
+	switch event.(type) {
+	case *github.InstallationEvent, *github.InstallationRepositoriesEvent:
+		h.handleInstallationEvent(event)
+		return
+	}
+
 	type genericPayload interface {
 		GetRepo() *github.Repository
 		GetInstallation() *github.Installation
@@ -84,7 +145,7 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	itr := ghinstallation.NewFromAppsTransport(h.atr, installationID)
-	client := github.NewClient(&http.Client{Transport: itr})
+	client := github.NewClient(base.NewHTTPClientWithTransport(itr))
 
 	if repo == "" {
 		return
@@ -96,6 +157,14 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(convs) > 0 {
+		if event, ok := event.(*github.PullRequestEvent); ok {
+			h.notifyRequestedReviewer(event)
+			h.notifyCodeOwners(event, client)
+		}
+		h.notifyMentionedUsers(event)
+	}
+
 	for _, convID := range convs {
 		features, err := h.db.GetFeatures(convID, repo)
 		if err != nil {
@@ -109,6 +178,30 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		if labels, ok := eventLabels(event); ok {
+			filter, err := h.db.GetLabelsForRepo(convID, repo)
+			if err != nil {
+				h.Errorf("Error getting labels for repo and convID: %s", err)
+				return
+			}
+			if !matchLabels(filter, labels) {
+				h.Stats.Count("webhook - filtered label")
+				continue
+			}
+		}
+
+		if category, ok := eventCategory(event); ok {
+			filter, err := h.db.GetCategoriesForRepo(convID, repo)
+			if err != nil {
+				h.Errorf("Error getting discussion categories for repo and convID: %s", err)
+				return
+			}
+			if !matchLabels(filter, []string{category}) {
+				h.Stats.Count("webhook - filtered category")
+				continue
+			}
+		}
+
 		message, branch := h.formatMessage(convID, event, repo, client)
 		if message == "" {
 			// if we don't have a message to send, bail
@@ -128,8 +221,32 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if kind := digestKind(event); kind != "" {
+			interval, err := h.db.GetDigestInterval(convID, repo)
+			if err != nil {
+				h.Errorf("Error getting digest interval: %s", err)
+			} else if interval > 0 {
+				if err := h.db.AddDigestEvent(convID, repo, DigestEvent{Kind: kind, Message: message}); err != nil {
+					h.Errorf("unable to add event to digest: %s", err)
+					continue
+				}
+				h.Stats.Count("webhook - digest")
+				continue
+			}
+		}
+
 		h.Stats.Count("webhook - success")
-		h.ChatEcho(convID, message)
+		// queue the send so a chat API hiccup doesn't drop the notification
+		if err := h.enqueueNotification(convID, repo, event, message); err != nil {
+			h.Errorf("unable to queue webhook message: %s", err)
+			continue
+		}
+		h.analytics.RecordNotification(convID, github.WebHookType(r))
+	}
+
+	if event, ok := event.(*github.IssuesEvent); ok && len(convs) > 0 {
+		// after the issue's own notification, so the assignment follows it
+		h.assignFromRotation(event, repo, convs, client)
 	}
 }
 
@@ -139,9 +256,10 @@ func (h *HTTPSrv) formatMessage(convID chat1.ConvIDStr, event interface{}, repo
 		h.Debug("invalid repo: %s", repo)
 		return
 	}
+	eventType := muteEventType(event)
 	switch event := event.(type) {
 	case *github.IssuesEvent:
-		author := getPossibleKBUser(h.kbc, h.db, h.DebugOutput, event.GetSender().GetLogin(), convID)
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetSender().GetLogin(), convID, repo, eventType)
 		return git.FormatIssueMsg(
 			*event.Action,
 			author.String(),
@@ -153,9 +271,9 @@ func (h *HTTPSrv) formatMessage(convID chat1.ConvIDStr, event interface{}, repo
 	case *github.PullRequestEvent:
 		var author username
 		if event.GetPullRequest().GetMerged() {
-			author = getPossibleKBUser(h.kbc, h.db, h.DebugOutput, event.GetPullRequest().GetMergedBy().GetLogin(), convID)
+			author = getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetPullRequest().GetMergedBy().GetLogin(), convID, repo, eventType)
 		} else {
-			author = getPossibleKBUser(h.kbc, h.db, h.DebugOutput, event.GetPullRequest().GetUser().GetLogin(), convID)
+			author = getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetPullRequest().GetUser().GetLogin(), convID, repo, eventType)
 		}
 
 		action := *event.Action
@@ -173,6 +291,13 @@ func (h *HTTPSrv) formatMessage(convID chat1.ConvIDStr, event interface{}, repo
 			event.GetPullRequest().GetHTMLURL(),
 			event.GetRepo().GetName(),
 		), ""
+	case *github.PullRequestReviewEvent:
+		if !h.threadUpdates {
+			// reviews are only worth a message in the pull request's thread
+			return "", ""
+		}
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetSender().GetLogin(), convID, repo, eventType)
+		return formatReviewMessage(event, author.String()), ""
 	case *github.PushEvent:
 		if len(event.Commits) == 0 {
 			break
@@ -191,6 +316,10 @@ func (h *HTTPSrv) formatMessage(convID chat1.ConvIDStr, event interface{}, repo
 
 	case *github.CheckRunEvent:
 		var author username
+		if h.summarizeChecks && isFailingConclusion(event.GetCheckRun().GetConclusion()) {
+			// failures are summarized once per check suite instead
+			return "", ""
+		}
 
 		// this is a branch test, not associated with a PR
 		var runPR *github.PullRequest
@@ -217,9 +346,56 @@ func (h *HTTPSrv) formatMessage(convID chat1.ConvIDStr, event interface{}, repo
 			}
 			return formatCheckRunMessage(event, ""), branch
 		}
-		author = getPossibleKBUser(h.kbc, h.db, h.DebugOutput, pr.GetUser().GetLogin(), convID)
+		author = getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, pr.GetUser().GetLogin(), convID, repo, eventType)
 		return formatCheckRunMessage(event, author.String()), branch
 
+	case *github.DeploymentEvent:
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetDeployment().GetCreator().GetLogin(), convID, repo, eventType)
+		return formatDeploymentMessage(event, author.String()), ""
+	case *github.DeploymentStatusEvent:
+		var changes *github.CommitsComparison
+		if event.GetDeploymentStatus().GetState() == "success" {
+			var err error
+			changes, err = deploymentChanges(context.TODO(), client, parsedRepo[0], parsedRepo[1], event.GetDeployment())
+			if err != nil && !strings.Contains(err.Error(), "401 Bad credentials") {
+				h.Errorf("Error getting deployment changes: %s", err)
+			}
+		}
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetDeployment().GetCreator().GetLogin(), convID, repo, eventType)
+		return formatDeploymentStatusMessage(event, author.String(), changes), ""
+	case *github.ReleaseEvent:
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetSender().GetLogin(), convID, repo, eventType)
+		return formatReleaseMessage(event, author.String()), ""
+	case *discussionEvent:
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.Sender.GetLogin(), convID, repo, eventType)
+		return formatDiscussionMessage(event, author.String()), ""
+	case *github.CheckSuiteEvent:
+		suite := event.GetCheckSuite()
+		if !h.summarizeChecks || event.GetAction() != "completed" || !isFailingConclusion(suite.GetConclusion()) {
+			return "", ""
+		}
+		runs, _, err := client.Checks.ListCheckRunsCheckSuite(context.TODO(), parsedRepo[0], parsedRepo[1], suite.GetID(),
+			&github.ListCheckRunsOptions{Filter: github.String("latest"), ListOptions: github.ListOptions{PerPage: 100}})
+		if err != nil && !strings.Contains(err.Error(), "401 Bad credentials") {
+			h.Errorf("Error listing check runs: %s", err)
+		}
+		var checkRuns []*github.CheckRun
+		if runs != nil {
+			checkRuns = runs.CheckRuns
+		}
+		if len(suite.PullRequests) == 0 {
+			return formatCheckSuiteMessage(event, checkRuns, ""), suite.GetHeadBranch()
+		}
+		pr, _, err := client.PullRequests.Get(context.TODO(), parsedRepo[0], parsedRepo[1], suite.PullRequests[0].GetNumber())
+		if err != nil {
+			if !strings.Contains(err.Error(), "401 Bad credentials") {
+				h.Errorf("Error getting pull request object: %s", err)
+			}
+			return formatCheckSuiteMessage(event, checkRuns, ""), ""
+		}
+		author := getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, pr.GetUser().GetLogin(), convID, repo, eventType)
+		return formatCheckSuiteMessage(event, checkRuns, author.String()), ""
+
 	case *github.StatusEvent:
 		var author username
 		pullRequests, _, err := client.PullRequests.ListPullRequestsWithCommit(
@@ -247,11 +423,11 @@ func (h *HTTPSrv) formatMessage(convID chat1.ConvIDStr, event interface{}, repo
 		}
 
 		if runPR != nil {
-			author = getPossibleKBUser(h.kbc, h.db, h.DebugOutput, runPR.GetUser().GetLogin(), convID)
+			author = getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, runPR.GetUser().GetLogin(), convID, repo, eventType)
 		} else if len(event.Branches) >= 1 {
 			// this is a branch test, not associated with a PR
 			branch = event.Branches[0].GetName()
-			author = getPossibleKBUser(h.kbc, h.db, h.DebugOutput, event.GetCommit().GetAuthor().GetLogin(), convID)
+			author = getPossibleKBUser(h.kbc, h.db, h.identities, h.DebugOutput, event.GetCommit().GetAuthor().GetLogin(), convID, repo, eventType)
 		} else {
 			h.Debug("status event had no pull requests or branches")
 			return "", ""
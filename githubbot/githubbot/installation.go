@@ -0,0 +1,160 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+// handleInstallationEvent keeps subscriptions in line with where the app is
+// installed: reinstalls and added repositories move subscriptions to the new
+// installation, uninstalls and removed repositories unsubscribe.
+func (h *HTTPSrv) handleInstallationEvent(event interface{}) {
+	switch event := event.(type) {
+	case *github.InstallationEvent:
+		installationID := event.GetInstallation().GetID()
+		switch event.GetAction() {
+		case "created":
+			h.moveSubscriptions(event.Repositories, installationID)
+		case "deleted":
+			subscriptions, err := h.db.GetSubscriptionsForInstallation(installationID, nil)
+			if err != nil {
+				h.Errorf("Error getting subscriptions for installation: %s", err)
+				return
+			}
+			h.removeSubscriptions(subscriptions, "was uninstalled from")
+		case "suspend", "unsuspend":
+			subscriptions, err := h.db.GetSubscriptionsForInstallation(installationID, nil)
+			if err != nil {
+				h.Errorf("Error getting subscriptions for installation: %s", err)
+				return
+			}
+			state := "suspended, I won't receive updates for"
+			if event.GetAction() == "unsuspend" {
+				state = "unsuspended, I'm receiving updates again for"
+			}
+			for _, subscription := range subscriptions {
+				h.notifyInstallationChange(subscription.ConvID,
+					fmt.Sprintf("The Keybase integration was %s `%s`.", state, subscription.Repo))
+			}
+		}
+	case *github.InstallationRepositoriesEvent:
+		installationID := event.GetInstallation().GetID()
+		h.moveSubscriptions(event.RepositoriesAdded, installationID)
+		if len(event.RepositoriesRemoved) == 0 {
+			return
+		}
+		repos := make([]string, 0, len(event.RepositoriesRemoved))
+		for _, repo := range event.RepositoriesRemoved {
+			repos = append(repos, repo.GetFullName())
+		}
+		subscriptions, err := h.db.GetSubscriptionsForInstallation(installationID, repos)
+		if err != nil {
+			h.Errorf("Error getting subscriptions for installation: %s", err)
+			return
+		}
+		h.removeSubscriptions(subscriptions, "lost access to")
+	}
+}
+
+func (h *HTTPSrv) moveSubscriptions(repos []*github.Repository, installationID int64) {
+	if len(repos) == 0 {
+		return
+	}
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		names = append(names, repo.GetFullName())
+	}
+	moved, err := h.db.MoveSubscriptions(names, installationID)
+	if err != nil {
+		h.Errorf("Error moving subscriptions to installation: %s", err)
+		return
+	}
+	if moved > 0 {
+		h.Stats.CountMult("webhook - installation - moved", int(moved))
+	}
+}
+
+func (h *HTTPSrv) removeSubscriptions(subscriptions []DBSubscription, reason string) {
+	for _, subscription := range subscriptions {
+		if err := h.db.DeleteRepoData(subscription.ConvID, subscription.Repo); err != nil {
+			h.Errorf("Error deleting subscription: %s", err)
+			continue
+		}
+		h.Stats.Count("webhook - installation - unsubscribed")
+		h.notifyInstallationChange(subscription.ConvID, fmt.Sprintf(
+			"The Keybase integration %s `%s`, so this conversation is no longer subscribed to it.",
+			reason, subscription.Repo))
+	}
+}
+
+func (h *HTTPSrv) notifyInstallationChange(convID chat1.ConvIDStr, message string) {
+	if err := h.queue.EnqueueChatSend(convID, message); err != nil {
+		h.Errorf("unable to queue installation message: %s", err)
+	}
+}
+
+// handleListRepos lists the repositories of an owner the app is installed on,
+// as `!github repos <owner>`.
+func (h *Handler) handleListRepos(cmd string, msg chat1.MsgSummary, client *github.Client) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github repos <owner>`")
+		return nil
+	}
+	owner := args[0]
+	ctx := context.TODO()
+	installation, res, err := client.Apps.FindOrganizationInstallation(ctx, owner)
+	if err != nil && res != nil && res.StatusCode == http.StatusNotFound {
+		installation, res, err = client.Apps.FindUserInstallation(ctx, owner)
+	}
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			h.ChatEcho(msg.ConvID, "The Keybase integration isn't installed for `%s` yet!\n\ngithub.com/apps/%s/installations/new", owner, h.appName)
+			return nil
+		}
+		return fmt.Errorf("error getting installation: %s", err)
+	}
+
+	itr := ghinstallation.NewFromAppsTransport(h.atr, installation.GetID())
+	installationClient := github.NewClient(base.NewHTTPClientWithTransport(itr))
+	opts := &github.ListOptions{PerPage: 100}
+	var items []string
+	for {
+		repos, res, err := installationClient.Apps.ListRepos(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("error listing repositories: %s", err)
+		}
+		for _, repo := range repos {
+			item := "- " + repo.GetFullName()
+			if repo.GetPrivate() {
+				item += " (private)"
+			}
+			items = append(items, item)
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	if len(items) == 0 {
+		h.ChatEcho(msg.ConvID, "The Keybase integration for `%s` can't access any repositories yet.", owner)
+		return nil
+	}
+	return h.pager.Send(msg.ConvID, base.PagedList{
+		Header: fmt.Sprintf("I can subscribe to these repositories of `%s` with `!github subscribe <owner/repo>`:", owner),
+		Items:  items,
+	})
+}
@@ -0,0 +1,148 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+var (
+	issueRefRE       = regexp.MustCompile(`^([\w.-]+)/([\w.-]+)#(\d+)$`)
+	keybaseMentionRE = regexp.MustCompile(`@([a-z0-9_]+)`)
+)
+
+// userClient returns a client acting as the sender, asking them to authorize
+// the bot first if needed, in which case the client is nil.
+func (h *Handler) userClient(msg chat1.MsgSummary) (*github.Client, error) {
+	tc, err := base.GetOAuthClient(msg.Sender.Username, msg, h.kbc, h.oauthConfig, h.db,
+		base.GetOAuthOpts{
+			AuthMessageTemplate: "Authorize me to post to GitHub as you by clicking this link:\n%s",
+		})
+	if err != nil || tc == nil {
+		if _, ok := err.(base.OAuthRequiredError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return github.NewClient(tc), nil
+}
+
+// mapMentions replaces @mentions of Keybase users who linked a GitHub login
+// with that login, so they're notified on GitHub too.
+func (h *Handler) mapMentions(text string) string {
+	return keybaseMentionRE.ReplaceAllStringFunc(text, func(mention string) string {
+		identity, err := h.identities.ExternalID(strings.TrimPrefix(mention, "@"), base.GitHubIdentity)
+		if err != nil {
+			h.Debug("mapMentions: unable to get identity: %s", err)
+			return mention
+		}
+		if identity == nil {
+			return mention
+		}
+		return "@" + identity.ExternalID
+	})
+}
+
+// commandArgs tokenizes the original message, the command itself is
+// lowercased, and returns what follows the first n tokens.
+func (h *Handler) commandArgs(msg chat1.MsgSummary, n int) (args []string, ok bool, err error) {
+	toks, userErr, err := base.SplitTokens(strings.TrimSpace(msg.Content.Text.Body))
+	if err != nil {
+		return nil, false, err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil, false, nil
+	}
+	if len(toks) < n {
+		return nil, true, nil
+	}
+	return toks[n:], true, nil
+}
+
+func (h *Handler) reportIssueError(msg chat1.MsgSummary, action string, res *github.Response, err error) error {
+	if res != nil {
+		switch res.StatusCode {
+		case http.StatusNotFound, http.StatusForbidden, http.StatusGone:
+			h.ChatEcho(msg.ConvID, "I couldn't %s! Make sure the repository exists, has issues enabled and that the Keybase integration can write issues there.", action)
+			return nil
+		case http.StatusUnprocessableEntity:
+			h.ChatEcho(msg.ConvID, "GitHub rejected that: %s", err)
+			return nil
+		}
+	}
+	return fmt.Errorf("error trying to %s: %s", action, err)
+}
+
+func (h *Handler) handleIssueCreate(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) < 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github issue create <owner/repo> \"title\" [body]`")
+		return nil
+	}
+	repo := strings.Split(args[0], "/")
+	if len(repo) != 2 || repo[0] == "" || repo[1] == "" {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a repository to me! Try `!github issue create <owner/repo> \"title\" [body]`", args[0])
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	title := h.mapMentions(args[1])
+	body := h.mapMentions(strings.Join(args[2:], " "))
+	issue, res, err := client.Issues.Create(context.TODO(), repo[0], repo[1], &github.IssueRequest{
+		Title: &title,
+		Body:  &body,
+	})
+	if err != nil {
+		return h.reportIssueError(msg, fmt.Sprintf("create an issue on `%s`", args[0]), res, err)
+	}
+	h.ChatEcho(msg.ConvID, "Created issue #%d on `%s`: %s", issue.GetNumber(), args[0], issue.GetHTMLURL())
+	return nil
+}
+
+func (h *Handler) handleComment(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) < 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github comment <owner/repo#number> <message>`")
+		return nil
+	}
+	match := issueRefRE.FindStringSubmatch(args[0])
+	if match == nil {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like an issue to me! Try `!github comment <owner/repo#number> <message>`", args[0])
+		return nil
+	}
+	number, err := strconv.Atoi(match[3])
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like an issue to me!", args[0])
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	body := h.mapMentions(strings.Join(args[1:], " "))
+	comment, res, err := client.Issues.CreateComment(context.TODO(), match[1], match[2], number, &github.IssueComment{
+		Body: &body,
+	})
+	if err != nil {
+		return h.reportIssueError(msg, fmt.Sprintf("comment on `%s`", args[0]), res, err)
+	}
+	h.ChatEcho(msg.ConvID, "Commented on `%s`: %s", args[0], comment.GetHTMLURL())
+	return nil
+}
@@ -0,0 +1,159 @@
+package githubbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+// allEventTypes mutes every event type of a repo
+const allEventTypes = "*"
+
+// muteEventTypes maps the names accepted by `!github mute` to the type stored.
+var muteEventTypes = map[string]string{
+	"issues":      "issues",
+	"pulls":       "pulls",
+	"prs":         "pulls",
+	"reviews":     "reviews",
+	"commits":     "commits",
+	"pushes":      "commits",
+	"statuses":    "statuses",
+	"checks":      "statuses",
+	"releases":    "releases",
+	"deployments": "deployments",
+	"deploys":     "deployments",
+	"discussions": "discussions",
+}
+
+// muteEventType returns the type users mute an event by.
+func muteEventType(event interface{}) string {
+	switch event.(type) {
+	case *github.IssuesEvent:
+		return "issues"
+	case *github.PullRequestEvent:
+		return "pulls"
+	case *github.PullRequestReviewEvent:
+		return "reviews"
+	case *github.PushEvent:
+		return "commits"
+	case *github.CheckRunEvent, *github.CheckSuiteEvent, *github.StatusEvent:
+		return "statuses"
+	case *github.ReleaseEvent:
+		return "releases"
+	case *github.DeploymentEvent, *github.DeploymentStatusEvent:
+		return "deployments"
+	case *discussionEvent:
+		return "discussions"
+	default:
+		return ""
+	}
+}
+
+func parseMuteEventTypes(names []string) (types []string, unknown string) {
+	for _, list := range names {
+		for _, name := range splitList(list) {
+			eventType, ok := muteEventTypes[name]
+			if !ok {
+				return nil, name
+			}
+			types = append(types, eventType)
+		}
+	}
+	return types, ""
+}
+
+func formatMuteEventTypes() string {
+	names := make([]string, 0, len(muteEventTypes))
+	for name := range muteEventTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return formatFilterList(names, "")
+}
+
+// handleMute stops or restores the sender's mentions in events of a repo, as
+// `!github mute <owner/repo> [event types]` and `!github unmute ...`.
+func (h *Handler) handleMute(cmd string, msg chat1.MsgSummary, mute bool) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) < 1 {
+		if mute {
+			h.ChatEcho(msg.ConvID, "I don't understand! Try `!github mute <owner/repo> [reviews,statuses]`")
+		} else {
+			h.ChatEcho(msg.ConvID, "I don't understand! Try `!github unmute <owner/repo> [reviews,statuses]`")
+		}
+		return nil
+	}
+	repo := args[0]
+	if len(strings.Split(repo, "/")) != 2 {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a repository to me!", repo)
+		return nil
+	}
+	types, unknown := parseMuteEventTypes(args[1:])
+	if unknown != "" {
+		h.ChatEcho(msg.ConvID, "I don't know the event type `%s`! Try one of %s.", unknown, formatMuteEventTypes())
+		return nil
+	}
+	if len(types) == 0 {
+		types = []string{allEventTypes}
+	}
+
+	username := msg.Sender.Username
+	if !mute {
+		if len(args) == 1 {
+			// unmuting a repo restores every type
+			types = nil
+		}
+		if err := h.db.DeleteMutes(username, msg.ConvID, repo, types); err != nil {
+			return fmt.Errorf("error deleting mutes: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, you'll be mentioned in %s events for `%s` here again.",
+			formatMutedTypes(types), repo)
+		return nil
+	}
+	if err := h.db.AddMutes(username, msg.ConvID, repo, types); err != nil {
+		return fmt.Errorf("error adding mutes: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, you won't be mentioned in %s events for `%s` here. Undo with `!github unmute %s`.",
+		formatMutedTypes(types), repo, strings.Join(args, " "))
+	return nil
+}
+
+func formatMutedTypes(types []string) string {
+	if len(types) == 0 || (len(types) == 1 && types[0] == allEventTypes) {
+		return "any"
+	}
+	return formatFilterList(types, "")
+}
+
+func (h *Handler) handleListMutes(msg chat1.MsgSummary) error {
+	mutes, err := h.db.GetMutes(msg.Sender.Username, msg.ConvID)
+	if err != nil {
+		return fmt.Errorf("error getting mutes: %s", err)
+	}
+	if len(mutes) == 0 {
+		h.ChatEcho(msg.ConvID, "You haven't muted anything here.")
+		return nil
+	}
+	repos := make([]string, 0, len(mutes))
+	for repo := range mutes {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+	lines := []string{"You aren't mentioned here in:"}
+	for _, repo := range repos {
+		lines = append(lines, fmt.Sprintf("- %s: %s events", repo, formatMutedTypes(mutes[repo])))
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return nil
+}
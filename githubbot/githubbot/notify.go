@@ -0,0 +1,322 @@
+package githubbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/git"
+)
+
+// NotificationJobKind sends webhook notifications which may start or reply
+// to a thread, see HTTPSrv.threadCI and threadUpdates.
+const NotificationJobKind = "github_notification"
+
+// notificationPayload is a message to send, ThreadKey records it as the start
+// of a thread and ReplyTo names the thread to reply in. With both set the
+// message starts the thread only if it doesn't exist yet.
+type notificationPayload struct {
+	ConvID    chat1.ConvIDStr `json:"conv_id"`
+	Repo      string          `json:"repo"`
+	Body      string          `json:"body"`
+	ThreadKey string          `json:"thread_key,omitempty"`
+	ReplyTo   string          `json:"reply_to,omitempty"`
+}
+
+// notificationThreadKeys returns the thread a push or pull request message
+// starts, or the one a CI result belongs in.
+func notificationThreadKeys(event interface{}) (threadKey, replyTo string) {
+	prKey := func(prs []*github.PullRequest) string {
+		if len(prs) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("pr:%d", prs[0].GetNumber())
+	}
+	switch event := event.(type) {
+	case *github.PullRequestEvent:
+		return fmt.Sprintf("pr:%d", event.GetNumber()), ""
+	case *github.PushEvent:
+		return "sha:" + event.GetAfter(), ""
+	case *github.CheckSuiteEvent:
+		if key := prKey(event.GetCheckSuite().PullRequests); key != "" {
+			return "", key
+		}
+		return "", "sha:" + event.GetCheckSuite().GetHeadSHA()
+	case *github.CheckRunEvent:
+		if key := prKey(event.GetCheckRun().PullRequests); key != "" {
+			return "", key
+		}
+		return "", "sha:" + event.GetCheckRun().GetHeadSHA()
+	case *github.StatusEvent:
+		return "", "sha:" + event.GetSHA()
+	case *github.DeploymentEvent:
+		return fmt.Sprintf("deploy:%d", event.GetDeployment().GetID()), ""
+	case *github.DeploymentStatusEvent:
+		return "", fmt.Sprintf("deploy:%d", event.GetDeployment().GetID())
+	}
+	return "", ""
+}
+
+// updateThreadKeys returns the thread of the pull request or issue an event
+// is about. Openings start it, and other events reply to it or start it if
+// the opening was never announced.
+func updateThreadKeys(event interface{}) (threadKey, replyTo string, ok bool) {
+	switch event := event.(type) {
+	case *github.PullRequestEvent:
+		key := fmt.Sprintf("pr:%d", event.GetNumber())
+		if event.GetAction() == "opened" {
+			return key, "", true
+		}
+		return key, key, true
+	case *github.PullRequestReviewEvent:
+		key := fmt.Sprintf("pr:%d", event.GetPullRequest().GetNumber())
+		return key, key, true
+	case *github.IssuesEvent:
+		key := fmt.Sprintf("issue:%d", event.GetIssue().GetNumber())
+		if event.GetAction() == "opened" {
+			return key, "", true
+		}
+		return key, key, true
+	case *discussionEvent:
+		key := fmt.Sprintf("discussion:%d", event.Discussion.Number)
+		if event.Comment == nil && event.Action == "created" {
+			return key, "", true
+		}
+		return key, key, true
+	}
+	return "", "", false
+}
+
+func (h *HTTPSrv) threadKeys(event interface{}) (threadKey, replyTo string) {
+	if h.threadUpdates {
+		if threadKey, replyTo, ok := updateThreadKeys(event); ok {
+			return threadKey, replyTo
+		}
+	}
+	if h.threadCI {
+		return notificationThreadKeys(event)
+	}
+	return "", ""
+}
+
+func (h *HTTPSrv) enqueueNotification(convID chat1.ConvIDStr, repo string, event interface{}, message string) error {
+	threadKey, replyTo := h.threadKeys(event)
+	if threadKey == "" && replyTo == "" {
+		return h.queue.EnqueueChatSend(convID, message)
+	}
+	return h.queue.Enqueue(NotificationJobKind, notificationPayload{
+		ConvID:    convID,
+		Repo:      repo,
+		Body:      message,
+		ThreadKey: threadKey,
+		ReplyTo:   replyTo,
+	})
+}
+
+func (h *HTTPSrv) handleNotificationJob(payload []byte) error {
+	var send notificationPayload
+	if err := json.Unmarshal(payload, &send); err != nil {
+		return err
+	}
+	var replyTo *chat1.MessageID
+	if send.ReplyTo != "" {
+		var err error
+		if replyTo, err = h.db.GetNotificationThread(send.ConvID, send.Repo, send.ReplyTo); err != nil {
+			return err
+		}
+	}
+	var res kbchat.SendResponse
+	var err error
+	if replyTo != nil {
+		res, err = h.kbc.SendReplyByConvID(send.ConvID, replyTo, "%s", send.Body)
+	} else {
+		res, err = h.kbc.SendMessageByConvID(send.ConvID, "%s", send.Body)
+	}
+	if err != nil {
+		h.CollectGoneConv(send.ConvID, err)
+		if err := base.GetNonFatalChatError(err); err != nil {
+			// the conversation is gone, retrying won't help
+			h.Debug("handleNotificationJob: dropping message to %s: %s", send.ConvID, err)
+			return nil
+		}
+		return err
+	}
+	if send.ThreadKey != "" && replyTo == nil && res.Result.MessageID != nil {
+		// the message is out, retrying would only send it twice
+		if err := h.db.PutNotificationThread(send.ConvID, send.Repo, send.ThreadKey, *res.Result.MessageID); err != nil {
+			h.Errorf("unable to record notification thread: %s", err)
+		}
+	}
+	return nil
+}
+
+// maxDMExcerptLen bounds how much of a pull request or comment is quoted in
+// direct messages
+const maxDMExcerptLen = 280
+
+// notifyRequestedReviewer DMs the Keybase user behind the requested reviewer
+// of a pull request, on top of whatever the subscribed conversations get.
+func (h *HTTPSrv) notifyRequestedReviewer(event *github.PullRequestEvent) {
+	if event.GetAction() != "review_requested" {
+		return
+	}
+	reviewer := event.GetRequestedReviewer().GetLogin()
+	if reviewer == "" || reviewer == event.GetSender().GetLogin() {
+		// team review requests have no single user to notify
+		return
+	}
+	kbUsername := lookupKBUser(h.kbc, h.identities, h.DebugOutput, reviewer)
+	if kbUsername == "" {
+		h.Stats.Count("webhook - review request - unknown reviewer")
+		return
+	}
+	requester := username{githubUsername: event.GetSender().GetLogin()}
+	if kb := lookupKBUser(h.kbc, h.identities, h.DebugOutput, requester.githubUsername); kb != "" {
+		requester.keybaseUsername = &kb
+	}
+	message := formatReviewRequestMessage(event, requester.String())
+	if err := h.queue.EnqueueDirectMessage(kbUsername, message); err != nil {
+		h.Errorf("unable to queue review request for %s: %s", kbUsername, err)
+		return
+	}
+	h.Stats.Count("webhook - review request - sent")
+}
+
+func formatReviewRequestMessage(evt *github.PullRequestEvent, requester string) string {
+	pr := evt.GetPullRequest()
+	res := fmt.Sprintf("%s requested your review on pull request #%d on %s: “%s”\n",
+		requester, pr.GetNumber(), evt.GetRepo().GetFullName(), pr.GetTitle())
+	res += fmt.Sprintf("+%d -%d in %d files\n", pr.GetAdditions(), pr.GetDeletions(), pr.GetChangedFiles())
+	if excerpt := git.FormatExcerpt(pr.GetBody(), maxDMExcerptLen); excerpt != "" {
+		res += excerpt + "\n"
+	}
+	return res + pr.GetHTMLURL()
+}
+
+var (
+	githubMentionRE = regexp.MustCompile(`(?:^|[^\w/])@([a-zA-Z0-9](?:[a-zA-Z0-9-]{0,38}))\b`)
+	codeFenceRE     = regexp.MustCompile("(?s)```.*?(```|$)")
+	inlineCodeRE    = regexp.MustCompile("`[^`\n]*`")
+)
+
+// parseGitHubMentions returns the logins @-mentioned in a comment, skipping
+// code and quoted replies so a quote doesn't notify everyone again.
+func parseGitHubMentions(body string) (logins []string) {
+	body = codeFenceRE.ReplaceAllString(body, "")
+	body = inlineCodeRE.ReplaceAllString(body, "")
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), ">") {
+			continue
+		}
+		for _, match := range githubMentionRE.FindAllStringSubmatch(line, -1) {
+			login := strings.ToLower(match[1])
+			if !seen[login] {
+				seen[login] = true
+				logins = append(logins, match[1])
+			}
+		}
+	}
+	return logins
+}
+
+type mentionComment struct {
+	author string
+	body   string
+	url    string
+	// where the comment was made, e.g. `issue #12 on owner/repo`
+	context string
+}
+
+func getMentionComment(event interface{}) (comment mentionComment, ok bool) {
+	switch event := event.(type) {
+	case *github.IssueCommentEvent:
+		if event.GetAction() != "created" {
+			return comment, false
+		}
+		kind := "issue"
+		if event.GetIssue().IsPullRequest() {
+			kind = "pull request"
+		}
+		return mentionComment{
+			author:  event.GetSender().GetLogin(),
+			body:    event.GetComment().GetBody(),
+			url:     event.GetComment().GetHTMLURL(),
+			context: fmt.Sprintf("%s #%d on %s", kind, event.GetIssue().GetNumber(), event.GetRepo().GetFullName()),
+		}, true
+	case *github.PullRequestReviewCommentEvent:
+		if event.GetAction() != "created" {
+			return comment, false
+		}
+		return mentionComment{
+			author:  event.GetSender().GetLogin(),
+			body:    event.GetComment().GetBody(),
+			url:     event.GetComment().GetHTMLURL(),
+			context: fmt.Sprintf("pull request #%d on %s", event.GetPullRequest().GetNumber(), event.GetRepo().GetFullName()),
+		}, true
+	case *github.PullRequestReviewEvent:
+		if event.GetAction() != "submitted" {
+			return comment, false
+		}
+		return mentionComment{
+			author:  event.GetSender().GetLogin(),
+			body:    event.GetReview().GetBody(),
+			url:     event.GetReview().GetHTMLURL(),
+			context: fmt.Sprintf("a review of pull request #%d on %s", event.GetPullRequest().GetNumber(), event.GetRepo().GetFullName()),
+		}, true
+	default:
+		return comment, false
+	}
+}
+
+// notifyMentionedUsers DMs the Keybase users behind the logins a comment
+// @-mentions, if they opted in with `!github mentions on`.
+func (h *HTTPSrv) notifyMentionedUsers(event interface{}) {
+	comment, ok := getMentionComment(event)
+	if !ok {
+		return
+	}
+	var message string
+	for _, login := range parseGitHubMentions(comment.body) {
+		if strings.EqualFold(login, comment.author) {
+			continue
+		}
+		kbUsername := lookupKBUser(h.kbc, h.identities, h.DebugOutput, login)
+		if kbUsername == "" {
+			continue
+		}
+		enabled, err := h.db.GetMentionDMs(kbUsername)
+		if err != nil {
+			h.Errorf("unable to get mention DM preference for %s: %s", kbUsername, err)
+			continue
+		}
+		if !enabled {
+			continue
+		}
+		if message == "" {
+			author := username{githubUsername: comment.author}
+			if kb := lookupKBUser(h.kbc, h.identities, h.DebugOutput, comment.author); kb != "" {
+				author.keybaseUsername = &kb
+			}
+			message = formatMentionMessage(comment, author.String())
+		}
+		if err := h.queue.EnqueueDirectMessage(kbUsername, message); err != nil {
+			h.Errorf("unable to queue mention for %s: %s", kbUsername, err)
+			continue
+		}
+		h.Stats.Count("webhook - mention - sent")
+	}
+}
+
+func formatMentionMessage(comment mentionComment, author string) string {
+	res := fmt.Sprintf("%s mentioned you in %s:\n", author, comment.context)
+	if excerpt := git.FormatExcerpt(comment.body, maxDMExcerptLen); excerpt != "" {
+		res += excerpt + "\n"
+	}
+	return res + comment.url
+}
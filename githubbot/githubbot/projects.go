@@ -0,0 +1,314 @@
+package githubbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	// go-github doesn't know Projects v2 events yet, so they're parsed here
+	projectItemHook = "projects_v2_item"
+
+	columnsFlag = "columns"
+	fieldFlag   = "field"
+
+	defaultProjectField = "Status"
+)
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+// graphQL runs a query against the GitHub GraphQL API and decodes its data
+// into data.
+func graphQL(ctx context.Context, client *github.Client, query string, variables map[string]interface{},
+	data interface{}) (*github.Response, error) {
+	req, err := client.NewRequest("POST", "graphql", graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphQLError  `json:"errors"`
+	}
+	res, err := client.Do(ctx, req, &resp)
+	if err != nil {
+		return res, err
+	}
+	if len(resp.Errors) > 0 {
+		return res, fmt.Errorf("graphql: %s", resp.Errors[0].Message)
+	}
+	return res, json.Unmarshal(resp.Data, data)
+}
+
+const projectQuery = `query($org: String!, $number: Int!) {
+	organization(login: $org) {
+		projectV2(number: $number) { id title url }
+	}
+}`
+
+type projectV2 struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+const projectItemQuery = `query($id: ID!, $field: String!) {
+	node(id: $id) {
+		... on ProjectV2Item {
+			project { id title url }
+			fieldValueByName(name: $field) {
+				... on ProjectV2ItemFieldSingleSelectValue { name }
+			}
+			content {
+				... on Issue { title url number repository { nameWithOwner } }
+				... on PullRequest { title url number repository { nameWithOwner } }
+				... on DraftIssue { title }
+			}
+		}
+	}
+}`
+
+type projectItem struct {
+	Project    projectV2 `json:"project"`
+	FieldValue *struct {
+		Name string `json:"name"`
+	} `json:"fieldValueByName"`
+	Content *struct {
+		Title      string `json:"title"`
+		URL        string `json:"url"`
+		Number     int    `json:"number"`
+		Repository *struct {
+			NameWithOwner string `json:"nameWithOwner"`
+		} `json:"repository"`
+	} `json:"content"`
+}
+
+type projectItemEvent struct {
+	Action      string `json:"action"`
+	ProjectItem struct {
+		NodeID        string `json:"node_id"`
+		ProjectNodeID string `json:"project_node_id"`
+	} `json:"projects_v2_item"`
+	Changes struct {
+		FieldValue *struct {
+			FieldName string `json:"field_name"`
+		} `json:"field_value"`
+	} `json:"changes"`
+	Installation *github.Installation `json:"installation"`
+	Sender       *github.User         `json:"sender"`
+}
+
+func formatProjectItemMessage(item *projectItem, column, username string) string {
+	title, url := "a draft item", item.Project.URL
+	if content := item.Content; content != nil {
+		switch {
+		case content.Repository != nil:
+			title = fmt.Sprintf("%s#%d “%s”", content.Repository.NameWithOwner, content.Number, content.Title)
+			url = content.URL
+		case content.Title != "":
+			title = fmt.Sprintf("“%s”", content.Title)
+		}
+	}
+	return fmt.Sprintf(":clipboard: %s moved %s to *%s* on %s\n%s", username, title, column, item.Project.Title, url)
+}
+
+// handleProjectItem notifies the conversations watching a project when one
+// of its items enters a watched column.
+func (h *HTTPSrv) handleProjectItem(payload []byte) {
+	var evt projectItemEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		h.Debug("could not parse project item event: %s", err)
+		return
+	}
+	if evt.Action != "edited" || evt.Changes.FieldValue == nil {
+		return
+	}
+	watches, err := h.db.GetProjectWatches(evt.ProjectItem.ProjectNodeID, evt.Installation.GetID())
+	if err != nil {
+		h.Errorf("Error getting project watches: %s", err)
+		return
+	}
+	if len(watches) == 0 {
+		return
+	}
+	itr := ghinstallation.NewFromAppsTransport(h.atr, evt.Installation.GetID())
+	client := github.NewClient(base.NewHTTPClientWithTransport(itr))
+	// conversations watching the same field share the lookup
+	items := make(map[string]*projectItem)
+	for _, watch := range watches {
+		if name := evt.Changes.FieldValue.FieldName; name != "" && !strings.EqualFold(name, watch.Field) {
+			continue
+		}
+		item, ok := items[watch.Field]
+		if !ok {
+			var data struct {
+				Node *projectItem `json:"node"`
+			}
+			if _, err := graphQL(context.TODO(), client, projectItemQuery, map[string]interface{}{
+				"id":    evt.ProjectItem.NodeID,
+				"field": watch.Field,
+			}, &data); err != nil {
+				h.Errorf("Error getting project item: %s", err)
+				return
+			}
+			item = data.Node
+			items[watch.Field] = item
+		}
+		if item == nil || item.FieldValue == nil {
+			continue
+		}
+		if !watch.matchColumn(item.FieldValue.Name) {
+			continue
+		}
+		author := lookupKBUser(h.kbc, h.identities, h.DebugOutput, evt.Sender.GetLogin())
+		username := fmt.Sprintf("*%s*", evt.Sender.GetLogin())
+		if author != "" {
+			username = "@" + author
+		}
+		h.Stats.Count("webhook - project item")
+		if err := h.queue.EnqueueChatSend(watch.ConvID, formatProjectItemMessage(item, item.FieldValue.Name, username)); err != nil {
+			h.Errorf("unable to queue project item message: %s", err)
+			continue
+		}
+		h.analytics.RecordNotification(watch.ConvID, projectItemHook)
+	}
+}
+
+func (w ProjectWatch) matchColumn(column string) bool {
+	if len(w.Columns) == 0 {
+		return true
+	}
+	for _, c := range w.Columns {
+		if strings.EqualFold(c, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleProject manages project watches, as `!github project <watch|unwatch>
+// <org> <number> [--columns "In Review,Blocked"] [--field Status]` or
+// `!github project list`.
+func (h *Handler) handleProject(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	args, flags := splitListFlags(args, columnsFlag, fieldFlag)
+	usage := "I don't understand! Try `!github project watch <org> <project number> [--columns \"In Review,Blocked\"] [--field Status]`, `!github project unwatch <org> <project number>` or `!github project list`"
+	if len(args) == 1 && strings.ToLower(args[0]) == "list" {
+		return h.listProjectWatches(msg)
+	}
+	if len(args) != 3 {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	action, org := strings.ToLower(args[0]), args[1]
+	number, err := strconv.Atoi(args[2])
+	if (action != "watch" && action != "unwatch") || err != nil {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+		return nil
+	}
+	if action == "unwatch" {
+		if err := h.db.DeleteProjectWatch(msg.ConvID, org, number); err != nil {
+			return fmt.Errorf("error deleting project watch: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, you won't receive updates for project %d of `%s` here.", number, org)
+		return nil
+	}
+
+	appClient := github.NewClient(base.NewHTTPClientWithTransport(h.atr))
+	installation, res, err := appClient.Apps.FindOrganizationInstallation(context.TODO(), org)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			h.ChatEcho(msg.ConvID, "I can't see `%s`! Make sure the Keybase integration is installed on the organization.\n\ngithub.com/apps/%s/installations/new", org, h.appName)
+			return nil
+		}
+		return fmt.Errorf("error getting installation: %s", err)
+	}
+	// check that the sender can see the project before sharing its updates
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+	var data struct {
+		Organization *struct {
+			ProjectV2 *projectV2 `json:"projectV2"`
+		} `json:"organization"`
+	}
+	if _, err := graphQL(context.TODO(), client, projectQuery, map[string]interface{}{
+		"org":    org,
+		"number": number,
+	}, &data); err != nil || data.Organization == nil || data.Organization.ProjectV2 == nil {
+		h.Debug("handleProject: unable to get project: %v", err)
+		h.ChatEcho(msg.ConvID, "I couldn't find project %d of `%s`! Make sure it exists and that you can see it.", number, org)
+		return nil
+	}
+	project := data.Organization.ProjectV2
+
+	watch := ProjectWatch{
+		ConvID:         msg.ConvID,
+		Org:            org,
+		Number:         number,
+		ProjectNodeID:  project.ID,
+		InstallationID: installation.GetID(),
+		Field:          defaultProjectField,
+		Columns:        splitList(flags[columnsFlag]),
+	}
+	if field, ok := flags[fieldFlag]; ok && strings.TrimSpace(field) != "" {
+		watch.Field = strings.TrimSpace(field)
+	}
+	if err := h.db.SetProjectWatch(watch); err != nil {
+		return fmt.Errorf("error setting project watch: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll post here when items of *%s* move to %s. Make sure the Keybase integration can read organization projects and receives project item events.",
+		project.Title, formatColumns(watch))
+	return nil
+}
+
+func formatColumns(watch ProjectWatch) string {
+	if len(watch.Columns) == 0 {
+		return fmt.Sprintf("any %s", watch.Field)
+	}
+	return fmt.Sprintf("%s %s", watch.Field, formatFilterList(watch.Columns, ""))
+}
+
+func (h *Handler) listProjectWatches(msg chat1.MsgSummary) error {
+	watches, err := h.db.GetProjectWatchesForConv(msg.ConvID)
+	if err != nil {
+		return fmt.Errorf("error getting project watches: %s", err)
+	}
+	if len(watches) == 0 {
+		h.ChatEcho(msg.ConvID, "Not watching any projects here yet, try `!github project watch <org> <project number>`.")
+		return nil
+	}
+	items := make([]string, 0, len(watches))
+	for _, watch := range watches {
+		items = append(items, fmt.Sprintf("- %s project %d: %s", watch.Org, watch.Number, formatColumns(watch)))
+	}
+	return h.pager.Send(msg.ConvID, base.PagedList{Header: "Watched projects:", Items: items})
+}
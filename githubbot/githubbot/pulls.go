@@ -0,0 +1,229 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+const squashFlag = "--squash"
+
+// parsePRRef parses `owner/repo#number`.
+func parsePRRef(ref string) (owner, repo string, number int, ok bool) {
+	match := issueRefRE.FindStringSubmatch(ref)
+	if match == nil {
+		return "", "", 0, false
+	}
+	number, err := strconv.Atoi(match[3])
+	if err != nil {
+		return "", "", 0, false
+	}
+	return match[1], match[2], number, true
+}
+
+func (h *Handler) reportPRError(msg chat1.MsgSummary, action string, res *github.Response, err error) error {
+	if res != nil {
+		switch res.StatusCode {
+		case http.StatusNotFound, http.StatusForbidden:
+			h.ChatEcho(msg.ConvID, "I couldn't %s! Make sure the pull request exists and that the Keybase integration can write pull requests there.", action)
+			return nil
+		case http.StatusMethodNotAllowed, http.StatusConflict, http.StatusUnprocessableEntity:
+			h.ChatEcho(msg.ConvID, "GitHub rejected that: %s", err)
+			return nil
+		}
+	}
+	return fmt.Errorf("error trying to %s: %s", action, err)
+}
+
+// hasWritePermission checks that the authorized user may push to the
+// repository, which a merge requires.
+func hasWritePermission(ctx context.Context, client *github.Client, owner, repo string) (login string, ok bool, res *github.Response, err error) {
+	user, res, err := client.Users.Get(ctx, "")
+	if err != nil {
+		return "", false, res, err
+	}
+	level, res, err := client.Repositories.GetPermissionLevel(ctx, owner, repo, user.GetLogin())
+	if err != nil {
+		return user.GetLogin(), false, res, err
+	}
+	switch level.GetPermission() {
+	case "admin", "write":
+		return user.GetLogin(), true, res, nil
+	default:
+		return user.GetLogin(), false, res, nil
+	}
+}
+
+// pendingChecks lists the checks and commit statuses on the pull request's
+// head which are failing or haven't finished.
+func pendingChecks(ctx context.Context, client *github.Client, owner, repo string, pr *github.PullRequest) (failing, pending []string, err error) {
+	sha := pr.GetHead().GetSHA()
+	runs, _, err := client.Checks.ListCheckRunsForRef(ctx, owner, repo, sha, &github.ListCheckRunsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, run := range runs.CheckRuns {
+		switch {
+		case run.GetStatus() != "completed":
+			pending = append(pending, run.GetName())
+		case isFailingConclusion(run.GetConclusion()) || run.GetConclusion() == "cancelled":
+			failing = append(failing, run.GetName())
+		}
+	}
+	status, _, err := client.Repositories.GetCombinedStatus(ctx, owner, repo, sha, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range status.Statuses {
+		switch s.GetState() {
+		case "pending":
+			pending = append(pending, s.GetContext())
+		case "failure", "error":
+			failing = append(failing, s.GetContext())
+		}
+	}
+	return failing, pending, nil
+}
+
+func (h *Handler) handlePRApprove(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github pr approve <owner/repo#number>`")
+		return nil
+	}
+	owner, repo, number, ok := parsePRRef(args[0])
+	if !ok {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a pull request to me! Try `!github pr approve <owner/repo#number>`", args[0])
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	pr, res, err := client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("find `%s`", args[0]), res, err)
+	}
+	if pr.GetState() != "open" {
+		h.ChatEcho(msg.ConvID, "`%s` is already %s.", args[0], prState(pr))
+		return nil
+	}
+	review, res, err := client.PullRequests.CreateReview(ctx, owner, repo, number, &github.PullRequestReviewRequest{
+		CommitID: github.String(pr.GetHead().GetSHA()),
+		Event:    github.String("APPROVE"),
+	})
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("approve `%s`", args[0]), res, err)
+	}
+	h.ChatEcho(msg.ConvID, "Approved `%s` “%s”: %s", args[0], pr.GetTitle(), review.GetHTMLURL())
+	return nil
+}
+
+func (h *Handler) handlePRMerge(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	method := "merge"
+	var refs []string
+	for _, arg := range args {
+		if strings.ToLower(arg) == squashFlag {
+			method = "squash"
+			continue
+		}
+		refs = append(refs, arg)
+	}
+	if len(refs) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github pr merge <owner/repo#number> [--squash]`")
+		return nil
+	}
+	ref := refs[0]
+	owner, repo, number, ok := parsePRRef(ref)
+	if !ok {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a pull request to me! Try `!github pr merge <owner/repo#number> [--squash]`", ref)
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	login, canWrite, res, err := hasWritePermission(ctx, client, owner, repo)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("check your permissions on `%s/%s`", owner, repo), res, err)
+	}
+	if !canWrite {
+		h.ChatEcho(msg.ConvID, "Sorry, your GitHub account *%s* can't merge pull requests on `%s/%s`.", login, owner, repo)
+		return nil
+	}
+	pr, res, err := client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("find `%s`", ref), res, err)
+	}
+	if pr.GetState() != "open" {
+		h.ChatEcho(msg.ConvID, "`%s` is already %s.", ref, prState(pr))
+		return nil
+	}
+	if pr.GetDraft() {
+		h.ChatEcho(msg.ConvID, "`%s` is still a draft, mark it ready for review first.", ref)
+		return nil
+	}
+	failing, pending, err := pendingChecks(ctx, client, owner, repo, pr)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("get the checks of `%s`", ref), nil, err)
+	}
+	if len(failing) > 0 {
+		h.ChatEcho(msg.ConvID, "Not merging `%s`, these checks are failing: %s", ref, strings.Join(failing, ", "))
+		return nil
+	}
+	if len(pending) > 0 {
+		h.ChatEcho(msg.ConvID, "Not merging `%s` yet, these checks haven't finished: %s", ref, strings.Join(pending, ", "))
+		return nil
+	}
+	switch pr.GetMergeableState() {
+	case "dirty":
+		h.ChatEcho(msg.ConvID, "`%s` has conflicts with %s, resolve them first.", ref, pr.GetBase().GetRef())
+		return nil
+	case "blocked":
+		h.ChatEcho(msg.ConvID, "`%s` is blocked by branch protection, it may still need approving reviews.", ref)
+		return nil
+	case "behind":
+		h.ChatEcho(msg.ConvID, "`%s` is behind %s, update the branch first.", ref, pr.GetBase().GetRef())
+		return nil
+	}
+
+	result, res, err := client.PullRequests.Merge(ctx, owner, repo, number, "", &github.PullRequestOptions{
+		// don't merge commits pushed since the checks above
+		SHA:         pr.GetHead().GetSHA(),
+		MergeMethod: method,
+	})
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("merge `%s`", ref), res, err)
+	}
+	if !result.GetMerged() {
+		h.ChatEcho(msg.ConvID, "GitHub didn't merge `%s`: %s", ref, result.GetMessage())
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, "Merged `%s` “%s” into %s: %s", ref, pr.GetTitle(), pr.GetBase().GetRef(), pr.GetHTMLURL())
+	return nil
+}
+
+func prState(pr *github.PullRequest) string {
+	if pr.GetMerged() {
+		return "merged"
+	}
+	return pr.GetState()
+}
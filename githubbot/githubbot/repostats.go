@@ -0,0 +1,249 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	// repoStatsCacheTTL is how long computed statistics are reused, they
+	// take several search requests each
+	repoStatsCacheTTL = 30 * time.Minute
+
+	defaultStatsWindow = 30 * 24 * time.Hour
+	maxStatsWindow     = 365 * 24 * time.Hour
+	// merged pull requests looked at for time to merge and contributors
+	maxStatsPRs = 500
+	// contributors listed
+	maxStatsContributors = 5
+)
+
+type contributorCount struct {
+	login string
+	count int
+}
+
+type repoStats struct {
+	window       time.Duration
+	mergedPRs    int
+	sampledPRs   int
+	avgMerge     time.Duration
+	openedIssues int
+	closedIssues int
+	contributors []contributorCount
+}
+
+type cachedRepoStats struct {
+	stats     *repoStats
+	fetchedAt time.Time
+}
+
+// repoStatsCache keeps computed statistics for repoStatsCacheTTL so repeated
+// `!github stats` calls don't hit the search API again.
+type repoStatsCache struct {
+	sync.Mutex
+	entries map[string]cachedRepoStats
+}
+
+func newRepoStatsCache() *repoStatsCache {
+	return &repoStatsCache{entries: make(map[string]cachedRepoStats)}
+}
+
+func repoStatsKey(repo string, window time.Duration) string {
+	return fmt.Sprintf("%s:%d", strings.ToLower(repo), window/time.Hour)
+}
+
+func (c *repoStatsCache) get(repo string, window time.Duration) *repoStats {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[repoStatsKey(repo, window)]
+	if !ok || time.Since(entry.fetchedAt) >= repoStatsCacheTTL {
+		return nil
+	}
+	return entry.stats
+}
+
+func (c *repoStatsCache) put(repo string, window time.Duration, stats *repoStats) {
+	c.Lock()
+	defer c.Unlock()
+	for key, entry := range c.entries {
+		if time.Since(entry.fetchedAt) >= repoStatsCacheTTL {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[repoStatsKey(repo, window)] = cachedRepoStats{stats: stats, fetchedAt: time.Now()}
+}
+
+// ParseStatsWindow parses windows like `30d` or `2w`.
+func ParseStatsWindow(value string) (time.Duration, error) {
+	unit := 24 * time.Hour
+	switch {
+	case strings.HasSuffix(value, "d"):
+		value = strings.TrimSuffix(value, "d")
+	case strings.HasSuffix(value, "w"):
+		value = strings.TrimSuffix(value, "w")
+		unit *= 7
+	default:
+		return 0, fmt.Errorf("invalid window %q", value)
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid window %q", value)
+	}
+	window := time.Duration(count) * unit
+	if window > maxStatsWindow {
+		return 0, fmt.Errorf("window %q is longer than a year", value)
+	}
+	return window, nil
+}
+
+func searchCount(ctx context.Context, client *github.Client, query string) (int, error) {
+	result, _, err := client.Search.Issues(ctx, query, &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 1}})
+	if err != nil {
+		return 0, err
+	}
+	return result.GetTotal(), nil
+}
+
+// computeRepoStats gathers the statistics of repo over window from the
+// search API.
+func computeRepoStats(ctx context.Context, client *github.Client, repo string, window time.Duration) (*repoStats, error) {
+	since := time.Now().Add(-window).UTC().Format("2006-01-02")
+	stats := &repoStats{window: window}
+
+	counts := make(map[string]int)
+	var totalMerge time.Duration
+	opts := &github.SearchOptions{Sort: "updated", Order: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+	query := fmt.Sprintf("repo:%s is:pr is:merged merged:>=%s", repo, since)
+	for stats.sampledPRs < maxStatsPRs {
+		result, res, err := client.Search.Issues(ctx, query, opts)
+		if err != nil {
+			return nil, err
+		}
+		stats.mergedPRs = result.GetTotal()
+		for _, pr := range result.Issues {
+			counts[pr.GetUser().GetLogin()]++
+			// search results have no merge time, a merged pull request is
+			// closed when it's merged
+			totalMerge += pr.GetClosedAt().Sub(pr.GetCreatedAt())
+			stats.sampledPRs++
+		}
+		if res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+	if stats.sampledPRs > 0 {
+		stats.avgMerge = totalMerge / time.Duration(stats.sampledPRs)
+	}
+	for login, count := range counts {
+		stats.contributors = append(stats.contributors, contributorCount{login: login, count: count})
+	}
+	sort.Slice(stats.contributors, func(i, j int) bool {
+		if stats.contributors[i].count != stats.contributors[j].count {
+			return stats.contributors[i].count > stats.contributors[j].count
+		}
+		return stats.contributors[i].login < stats.contributors[j].login
+	})
+	if len(stats.contributors) > maxStatsContributors {
+		stats.contributors = stats.contributors[:maxStatsContributors]
+	}
+
+	var err error
+	if stats.openedIssues, err = searchCount(ctx, client, fmt.Sprintf("repo:%s is:issue created:>=%s", repo, since)); err != nil {
+		return nil, err
+	}
+	if stats.closedIssues, err = searchCount(ctx, client, fmt.Sprintf("repo:%s is:issue closed:>=%s", repo, since)); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func formatRepoStats(repo string, stats *repoStats) string {
+	lines := []string{fmt.Sprintf("*%s* over the last %d days:", repo, stats.window/(24*time.Hour))}
+	merged := fmt.Sprintf("- %d pull requests merged", stats.mergedPRs)
+	if stats.sampledPRs > 0 {
+		merged += fmt.Sprintf(", %s from opened to merged on average", formatPRAge(stats.avgMerge))
+		if stats.sampledPRs < stats.mergedPRs {
+			merged += fmt.Sprintf(" (of the %d most recently updated)", stats.sampledPRs)
+		}
+	}
+	lines = append(lines, merged,
+		fmt.Sprintf("- %d issues opened, %d closed", stats.openedIssues, stats.closedIssues))
+	if len(stats.contributors) > 0 {
+		contributors := make([]string, 0, len(stats.contributors))
+		for _, contributor := range stats.contributors {
+			contributors = append(contributors, fmt.Sprintf("%s (%d)", contributor.login, contributor.count))
+		}
+		lines = append(lines, "- Top contributors: "+strings.Join(contributors, ", "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleRepoStats reports activity on a repo, as `!github stats <owner/repo>
+// [window]`.
+func (h *Handler) handleRepoStats(cmd string, msg chat1.MsgSummary, client *github.Client) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) < 1 || len(args) > 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github stats <owner/repo> [30d]`")
+		return nil
+	}
+	repo := args[0]
+	parsedRepo := strings.Split(repo, "/")
+	if len(parsedRepo) != 2 || parsedRepo[0] == "" || parsedRepo[1] == "" {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a repository to me! Try `!github stats <owner/repo> [30d]`", repo)
+		return nil
+	}
+	window := defaultStatsWindow
+	if len(args) == 2 {
+		if window, err = ParseStatsWindow(args[1]); err != nil {
+			h.ChatEcho(msg.ConvID, "I don't understand `%s`! Use a window like `7d`, `30d` or `4w`, up to a year.", args[1])
+			return nil
+		}
+	}
+
+	stats := h.repoStats.get(repo, window)
+	if stats == nil {
+		installation, res, err := client.Apps.FindRepositoryInstallation(context.TODO(), parsedRepo[0], parsedRepo[1])
+		if err != nil {
+			if res != nil && res.StatusCode == http.StatusNotFound {
+				h.ChatEcho(msg.ConvID, "I can't see `%s`! Make sure the Keybase integration is installed on your repository, and that the repository exists.\n\ngithub.com/apps/%s/installations/new", repo, h.appName)
+				return nil
+			}
+			return fmt.Errorf("error getting installation: %s", err)
+		}
+		itr := ghinstallation.NewFromAppsTransport(h.atr, installation.GetID())
+		installationClient := github.NewClient(base.NewHTTPClientWithTransport(itr))
+		stats, err = computeRepoStats(context.TODO(), installationClient, repo, window)
+		if err != nil {
+			if _, ok := err.(*github.RateLimitError); ok {
+				h.ChatEcho(msg.ConvID, "GitHub is rate limiting searches, try again in a minute.")
+				return nil
+			}
+			return fmt.Errorf("error computing stats: %s", err)
+		}
+		h.repoStats.put(repo, window, stats)
+	} else {
+		h.stats.Count("stats - cached")
+	}
+	h.ChatEcho(msg.ConvID, "%s", formatRepoStats(repo, stats))
+	return nil
+}
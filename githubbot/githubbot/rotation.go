@@ -0,0 +1,180 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+// assignFromRotation assigns a newly opened issue to whoever's turn it is in
+// the first of convs with a rotation for repo, and announces it there.
+func (h *HTTPSrv) assignFromRotation(event *github.IssuesEvent, repo string, convs []chat1.ConvIDStr, client *github.Client) {
+	if event.GetAction() != "opened" || len(event.GetIssue().Assignees) > 0 {
+		return
+	}
+	parsedRepo := strings.Split(repo, "/")
+	if len(parsedRepo) != 2 {
+		return
+	}
+	for _, convID := range convs {
+		login, err := h.db.NextRotationAssignee(convID, repo)
+		if err != nil {
+			h.Errorf("Error getting rotation assignee: %s", err)
+			return
+		}
+		if login == "" {
+			continue
+		}
+		issue := event.GetIssue()
+		var message string
+		if _, _, err := client.Issues.AddAssignees(context.TODO(), parsedRepo[0], parsedRepo[1], issue.GetNumber(),
+			[]string{login}); err != nil {
+			h.Debug("assignFromRotation: unable to assign %s: %s", login, err)
+			message = fmt.Sprintf("I couldn't assign %s#%d to *%s*, make sure they can be assigned issues there.",
+				repo, issue.GetNumber(), login)
+		} else {
+			h.Stats.Count("webhook - rotation - assigned")
+			assignee := username{githubUsername: login}
+			if kbUser := lookupKBUser(h.kbc, h.identities, h.DebugOutput, login); kbUser != "" {
+				assignee.keybaseUsername = &kbUser
+			}
+			message = fmt.Sprintf(":point_right: Assigned %s#%d “%s” to %s, it's their turn in the rotation.",
+				repo, issue.GetNumber(), issue.GetTitle(), assignee)
+		}
+		if err := h.queue.EnqueueChatSend(convID, message); err != nil {
+			h.Errorf("unable to queue rotation message: %s", err)
+		}
+		// one assignee per issue, even when several conversations rotate
+		return
+	}
+}
+
+// handleRotation manages the rotation new issues of a repo are assigned from,
+// as `!github rotation <set|add|remove|show|off> <owner/repo> [logins]`.
+func (h *Handler) handleRotation(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	usage := "I don't understand! Try `!github rotation set <owner/repo> <login,login,...>`, `!github rotation add|remove <owner/repo> <login>`, `!github rotation show <owner/repo>` or `!github rotation off <owner/repo>`"
+	if len(args) < 2 {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	action, repo := strings.ToLower(args[0]), strings.ToLower(args[1])
+	logins := splitList(strings.Join(args[2:], ","))
+	switch action {
+	case "set", "add", "remove":
+		if len(logins) == 0 {
+			h.ChatEcho(msg.ConvID, usage)
+			return nil
+		}
+	case "show", "off":
+		if len(logins) != 0 {
+			h.ChatEcho(msg.ConvID, usage)
+			return nil
+		}
+	default:
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+
+	exists, err := h.db.GetSubscriptionForRepoExists(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error getting subscription: %s", err)
+	}
+	if !exists {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to updates yet!\nSend this first: `!github subscribe %s`", repo)
+		return nil
+	}
+	rotation, err := h.db.GetRotation(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error getting rotation: %s", err)
+	}
+	if action == "show" {
+		if rotation == nil || len(rotation.Logins) == 0 {
+			h.ChatEcho(msg.ConvID, "New issues of `%s` aren't assigned from a rotation, try `!github rotation set %s <login,login,...>`.", repo, repo)
+			return nil
+		}
+		next := rotation.Next % len(rotation.Logins)
+		items := make([]string, 0, len(rotation.Logins))
+		for index, login := range rotation.Logins {
+			item := "- " + login
+			if index == next {
+				item += " (next)"
+			}
+			items = append(items, item)
+		}
+		return h.pager.Send(msg.ConvID, base.PagedList{
+			Header: fmt.Sprintf("New issues of `%s` are assigned in turn to:", repo),
+			Items:  items,
+		})
+	}
+
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+		return nil
+	}
+	if rotation == nil {
+		rotation = &Rotation{}
+	}
+	switch action {
+	case "off":
+		if err := h.db.DeleteRotation(msg.ConvID, repo); err != nil {
+			return fmt.Errorf("error deleting rotation: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I won't assign new issues of `%s` anymore.", repo)
+		return nil
+	case "set":
+		rotation = &Rotation{Logins: logins}
+	case "add":
+		for _, login := range logins {
+			if rotationIndex(rotation.Logins, login) < 0 {
+				rotation.Logins = append(rotation.Logins, login)
+			}
+		}
+	case "remove":
+		for _, login := range logins {
+			index := rotationIndex(rotation.Logins, login)
+			if index < 0 {
+				continue
+			}
+			rotation.Logins = append(rotation.Logins[:index], rotation.Logins[index+1:]...)
+			// keep the turn with whoever was next
+			if index < rotation.Next {
+				rotation.Next--
+			}
+		}
+		if len(rotation.Logins) == 0 {
+			if err := h.db.DeleteRotation(msg.ConvID, repo); err != nil {
+				return fmt.Errorf("error deleting rotation: %s", err)
+			}
+			h.ChatEcho(msg.ConvID, "Okay, the rotation of `%s` is empty, I won't assign new issues anymore.", repo)
+			return nil
+		}
+		rotation.Next %= len(rotation.Logins)
+	}
+	if err := h.db.SetRotation(msg.ConvID, repo, *rotation); err != nil {
+		return fmt.Errorf("error setting rotation: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll assign new issues of `%s` in turn to %s, next up is *%s*.", repo,
+		formatFilterList(rotation.Logins, ""), rotation.Logins[rotation.Next%len(rotation.Logins)])
+	return nil
+}
+
+func rotationIndex(logins []string, login string) int {
+	for index, l := range logins {
+		if strings.EqualFold(l, login) {
+			return index
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+// maxSearchResults is how many results `!github search` pages through,
+// GitHub returns at most 100 at once
+const maxSearchResults = 100
+
+func formatSearchResult(issue *github.Issue) string {
+	kind := "issue"
+	if issue.IsPullRequest() {
+		kind = "PR"
+	}
+	res := fmt.Sprintf("- %s #%d [%s] “%s” by *%s*", kind, issue.GetNumber(), issue.GetState(), issue.GetTitle(),
+		issue.GetUser().GetLogin())
+	if len(issue.Labels) > 0 {
+		labels := make([]string, 0, len(issue.Labels))
+		for _, label := range issue.Labels {
+			labels = append(labels, label.GetName())
+		}
+		res += fmt.Sprintf(" (%s)", strings.Join(labels, ", "))
+	}
+	return res + "\n  " + issue.GetHTMLURL()
+}
+
+// handleSearch runs an issue and pull request search restricted to a repo, as
+// the sender so private repositories and `@me` work.
+func (h *Handler) handleSearch(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) < 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github search <owner/repo> [query]`, e.g. `!github search keybase/client is:open label:bug involves:@me`")
+		return nil
+	}
+	repo := strings.Split(args[0], "/")
+	if len(repo) != 2 || repo[0] == "" || repo[1] == "" {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a repository to me! Try `!github search <owner/repo> [query]`", args[0])
+		return nil
+	}
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	query := strings.Join(append([]string{"repo:" + args[0]}, args[1:]...), " ")
+	result, res, err := client.Search.Issues(context.TODO(), query, &github.SearchOptions{
+		Sort:        "updated",
+		Order:       "desc",
+		ListOptions: github.ListOptions{PerPage: maxSearchResults},
+	})
+	if err != nil {
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusUnprocessableEntity:
+				h.ChatEcho(msg.ConvID, "GitHub couldn't run that search, check the repository and qualifiers: %s", err)
+				return nil
+			case http.StatusForbidden:
+				h.ChatEcho(msg.ConvID, "GitHub is rate limiting searches, try again in a minute.")
+				return nil
+			}
+		}
+		return fmt.Errorf("error searching issues: %s", err)
+	}
+	if len(result.Issues) == 0 {
+		h.ChatEcho(msg.ConvID, "Nothing on `%s` matches `%s`.", args[0], strings.Join(args[1:], " "))
+		return nil
+	}
+	header := fmt.Sprintf("%d results for `%s`", result.GetTotal(), query)
+	if result.GetTotal() > len(result.Issues) {
+		header += fmt.Sprintf(", showing the %d most recently updated", len(result.Issues))
+	}
+	items := make([]string, 0, len(result.Issues))
+	for _, issue := range result.Issues {
+		items = append(items, formatSearchResult(issue))
+	}
+	return h.pager.Send(msg.ConvID, base.PagedList{Header: header + ":", Items: items})
+}
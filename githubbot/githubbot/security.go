@@ -0,0 +1,259 @@
+package githubbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+// go-github doesn't know this event yet, so it's parsed here
+const dependabotAlertHook = "dependabot_alert"
+
+// how many open alerts `!github security list` spells out
+const maxSecurityAlerts = 25
+
+var severities = []string{"low", "medium", "high", "critical"}
+
+func severityRank(severity string) int {
+	for index, s := range severities {
+		if strings.EqualFold(s, severity) {
+			return index
+		}
+	}
+	return -1
+}
+
+func isSeverity(severity string) bool {
+	return severityRank(severity) >= 0
+}
+
+func severityIcon(severity string) string {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return ":rotating_light:"
+	case "high":
+		return ":red_circle:"
+	case "medium":
+		return ":large_orange_diamond:"
+	default:
+		return ":small_blue_diamond:"
+	}
+}
+
+type dependabotPackage struct {
+	Ecosystem string `json:"ecosystem"`
+	Name      string `json:"name"`
+}
+
+type dependabotAlert struct {
+	Number     int    `json:"number"`
+	State      string `json:"state"`
+	HTMLURL    string `json:"html_url"`
+	Dependency struct {
+		Package      dependabotPackage `json:"package"`
+		ManifestPath string            `json:"manifest_path"`
+	} `json:"dependency"`
+	SecurityAdvisory struct {
+		GHSAID   string `json:"ghsa_id"`
+		CVEID    string `json:"cve_id"`
+		Summary  string `json:"summary"`
+		Severity string `json:"severity"`
+	} `json:"security_advisory"`
+	SecurityVulnerability struct {
+		VulnerableVersionRange string `json:"vulnerable_version_range"`
+		FirstPatchedVersion    *struct {
+			Identifier string `json:"identifier"`
+		} `json:"first_patched_version"`
+	} `json:"security_vulnerability"`
+}
+
+func (a dependabotAlert) severity() string {
+	return strings.ToLower(a.SecurityAdvisory.Severity)
+}
+
+func (a dependabotAlert) advisoryID() string {
+	if a.SecurityAdvisory.CVEID != "" {
+		return a.SecurityAdvisory.CVEID
+	}
+	return a.SecurityAdvisory.GHSAID
+}
+
+type dependabotAlertEvent struct {
+	Action       string               `json:"action"`
+	Alert        dependabotAlert      `json:"alert"`
+	Repo         *github.Repository   `json:"repository"`
+	Installation *github.Installation `json:"installation"`
+	Sender       *github.User         `json:"sender"`
+}
+
+func formatDependabotAlert(evt *dependabotAlertEvent) string {
+	alert := evt.Alert
+	var verb string
+	switch evt.Action {
+	case "created":
+		verb = "New"
+	case "reintroduced":
+		verb = "Reintroduced"
+	case "fixed":
+		verb = "Fixed"
+	case "dismissed":
+		verb = "Dismissed"
+	default:
+		return ""
+	}
+	res := fmt.Sprintf("%s *[%s]* %s Dependabot alert #%d on %s: %s in %s",
+		severityIcon(alert.severity()), strings.ToUpper(alert.severity()), verb, alert.Number,
+		evt.Repo.GetFullName(), alert.advisoryID(), alert.Dependency.Package.Name)
+	if alert.SecurityAdvisory.Summary != "" {
+		res += fmt.Sprintf("\n> %s", alert.SecurityAdvisory.Summary)
+	}
+	if evt.Action == "created" || evt.Action == "reintroduced" {
+		res += fmt.Sprintf("\nAffects `%s` in %s", alert.SecurityVulnerability.VulnerableVersionRange,
+			alert.Dependency.ManifestPath)
+		if patched := alert.SecurityVulnerability.FirstPatchedVersion; patched != nil && patched.Identifier != "" {
+			res += fmt.Sprintf(", fixed in `%s`", patched.Identifier)
+		}
+	}
+	return res + "\n" + alert.HTMLURL
+}
+
+// handleDependabotAlert posts alerts to the conversations which turned on
+// security notifications for the repository.
+func (h *HTTPSrv) handleDependabotAlert(payload []byte) {
+	var evt dependabotAlertEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		h.Debug("could not parse dependabot alert: %s", err)
+		return
+	}
+	repo := evt.Repo.GetFullName()
+	message := formatDependabotAlert(&evt)
+	if repo == "" || message == "" {
+		return
+	}
+	settings, err := h.db.GetSecurityConvs(repo, evt.Installation.GetID())
+	if err != nil {
+		h.Errorf("Error getting security subscriptions for repo: %s", err)
+		return
+	}
+	for _, setting := range settings {
+		if severityRank(evt.Alert.severity()) < severityRank(setting.MinSeverity) {
+			h.Stats.Count("webhook - security - below severity")
+			continue
+		}
+		h.Stats.Count("webhook - security")
+		if err := h.enqueueNotification(setting.ConvID, repo, &evt, message); err != nil {
+			h.Errorf("unable to queue security alert: %s", err)
+			continue
+		}
+		h.analytics.RecordNotification(setting.ConvID, dependabotAlertHook)
+	}
+}
+
+// handleSecurity configures security notifications, as `!github security
+// <on|off|list> <owner/repo> [minimum severity]`.
+func (h *Handler) handleSecurity(cmd string, msg chat1.MsgSummary) (err error) {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	usage := "I don't understand! Try `!github security on <owner/repo> [low|medium|high|critical]`, `!github security off <owner/repo>` or `!github security list <owner/repo>`"
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && (args[0] != "on" || !isSeverity(args[2]))) {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	action, repo := args[0], args[1]
+	if action != "on" && action != "off" && action != "list" {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+
+	installationID, err := h.db.GetSubscriptionInstallation(msg.ConvID, repo)
+	if err != nil {
+		return fmt.Errorf("error getting subscription: %s", err)
+	} else if installationID == 0 {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to updates yet!\nSend this first: `!github subscribe %s`", repo)
+		return nil
+	}
+	if action == "list" {
+		return h.listSecurityAlerts(msg, repo, installationID)
+	}
+
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+		return nil
+	}
+	if action == "off" {
+		if err := h.db.DeleteSecurityConv(msg.ConvID, repo); err != nil {
+			return fmt.Errorf("error deleting security notifications: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, you won't receive security alerts for `%s` here.", repo)
+		return nil
+	}
+	minSeverity := severities[0]
+	if len(args) == 3 {
+		minSeverity = args[2]
+	}
+	if err := h.db.SetSecurityConv(msg.ConvID, repo, minSeverity); err != nil {
+		return fmt.Errorf("error setting security notifications: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, Dependabot alerts of %s severity or above for `%s` will be posted here. Make sure the Keybase integration can read Dependabot alerts.",
+		minSeverity, repo)
+	return nil
+}
+
+func (h *Handler) listSecurityAlerts(msg chat1.MsgSummary, repo string, installationID int64) error {
+	itr := ghinstallation.NewFromAppsTransport(h.atr, installationID)
+	client := github.NewClient(base.NewHTTPClientWithTransport(itr))
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/dependabot/alerts?state=open&per_page=100", repo), nil)
+	if err != nil {
+		return err
+	}
+	var alerts []dependabotAlert
+	res, err := client.Do(context.TODO(), req, &alerts)
+	if err != nil {
+		if res != nil && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden) {
+			h.ChatEcho(msg.ConvID, "I can't see the Dependabot alerts of `%s`! Make sure Dependabot alerts are enabled and that the Keybase integration can read them.", repo)
+			return nil
+		}
+		return fmt.Errorf("error listing Dependabot alerts: %s", err)
+	}
+	if len(alerts) == 0 {
+		h.ChatEcho(msg.ConvID, "`%s` has no open Dependabot alerts. :tada:", repo)
+		return nil
+	}
+	// most severe first, newest first within a severity
+	sorted := make([]dependabotAlert, 0, len(alerts))
+	for rank := len(severities) - 1; rank >= -1; rank-- {
+		for _, alert := range alerts {
+			if severityRank(alert.severity()) == rank {
+				sorted = append(sorted, alert)
+			}
+		}
+	}
+	lines := []string{fmt.Sprintf("%d open Dependabot alerts on `%s`:", len(alerts), repo)}
+	for index, alert := range sorted {
+		if index == maxSecurityAlerts {
+			lines = append(lines, fmt.Sprintf("- and %d more", len(sorted)-maxSecurityAlerts))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- %s *[%s]* #%d %s in %s: %s", severityIcon(alert.severity()),
+			strings.ToUpper(alert.severity()), alert.Number, alert.advisoryID(), alert.Dependency.Package.Name, alert.HTMLURL))
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return nil
+}
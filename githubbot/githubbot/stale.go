@@ -0,0 +1,234 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	DefaultStalePRThreshold = 48 * time.Hour
+	// how many pull requests a digest lists by name
+	maxStalePRs = 15
+)
+
+// StalePRScheduler reminds conversations, or the requested reviewers, of
+// pull requests which have been waiting for a review longer than the
+// subscription's threshold, see `!github stale`.
+type StalePRScheduler struct {
+	*base.DebugOutput
+
+	stats      *base.StatsRegistry
+	kbc        *kbchat.API
+	db         *DB
+	atr        *ghinstallation.AppsTransport
+	queue      *base.JobQueue
+	identities *base.IdentityStore
+}
+
+func NewStalePRScheduler(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig, db *DB,
+	atr *ghinstallation.AppsTransport, queue *base.JobQueue, identities *base.IdentityStore) *StalePRScheduler {
+	return &StalePRScheduler{
+		DebugOutput: base.NewDebugOutput("StalePRScheduler", debugConfig),
+		stats:       stats.SetPrefix("StalePRScheduler"),
+		kbc:         kbc,
+		db:          db,
+		atr:         atr,
+		queue:       queue,
+		identities:  identities,
+	}
+}
+
+// Task sends the reminders on weekday mornings, US time.
+func (s *StalePRScheduler) Task() base.Task {
+	return base.Task{
+		Name:     "stale-pr-reminders",
+		Schedule: "0 15 * * 1-5",
+		Jitter:   10 * time.Minute,
+		Run:      s.sendReminders,
+	}
+}
+
+type stalePR struct {
+	pr        *github.PullRequest
+	reviewers []string
+}
+
+func (s *StalePRScheduler) sendReminders(ctx context.Context) error {
+	settings, err := s.db.GetStalePRSettings()
+	if err != nil {
+		return fmt.Errorf("error getting stale PR settings: %s", err)
+	}
+	// subscriptions of the same repo share one listing
+	cache := make(map[string][]*github.PullRequest)
+	for _, setting := range settings {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		key := fmt.Sprintf("%s:%d", setting.Repo, setting.InstallationID)
+		prs, ok := cache[key]
+		if !ok {
+			if prs, err = s.listOpenPRs(ctx, setting); err != nil {
+				s.stats.Count("sendReminders - list error")
+				s.Debug("sendReminders: unable to list pull requests for %s: %s", setting.Repo, err)
+				continue
+			}
+			cache[key] = prs
+		}
+		s.remind(setting, s.stalePRs(prs, setting.Threshold))
+	}
+	return nil
+}
+
+func (s *StalePRScheduler) listOpenPRs(ctx context.Context, setting StalePRSetting) (all []*github.PullRequest, err error) {
+	parsedRepo := strings.Split(setting.Repo, "/")
+	if len(parsedRepo) != 2 {
+		return nil, fmt.Errorf("invalid repo: %s", setting.Repo)
+	}
+	itr := ghinstallation.NewFromAppsTransport(s.atr, setting.InstallationID)
+	client := github.NewClient(base.NewHTTPClientWithTransport(itr))
+	opts := &github.PullRequestListOptions{
+		State:       "open",
+		Sort:        "created",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		prs, res, err := client.PullRequests.List(ctx, parsedRepo[0], parsedRepo[1], opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, prs...)
+		if res.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+// stalePRs picks the non-draft pull requests older than threshold which
+// still have reviews requested.
+func (s *StalePRScheduler) stalePRs(prs []*github.PullRequest, threshold time.Duration) (res []stalePR) {
+	cutoff := time.Now().Add(-threshold)
+	for _, pr := range prs {
+		if pr.GetDraft() || !pr.GetCreatedAt().Before(cutoff) {
+			continue
+		}
+		var reviewers []string
+		for _, user := range pr.RequestedReviewers {
+			reviewers = append(reviewers, user.GetLogin())
+		}
+		if len(reviewers) == 0 && len(pr.RequestedTeams) == 0 {
+			continue
+		}
+		res = append(res, stalePR{pr: pr, reviewers: reviewers})
+	}
+	return res
+}
+
+func (s *StalePRScheduler) remind(setting StalePRSetting, prs []stalePR) {
+	if len(prs) == 0 {
+		return
+	}
+	var channel []stalePR
+	byReviewer := make(map[string][]stalePR)
+	for _, pr := range prs {
+		sent := false
+		if setting.DM {
+			for _, reviewer := range pr.reviewers {
+				if kbUsername := lookupKBUser(s.kbc, s.identities, s.DebugOutput, reviewer); kbUsername != "" {
+					byReviewer[kbUsername] = append(byReviewer[kbUsername], pr)
+					sent = true
+				}
+			}
+		}
+		if !sent {
+			// nobody to DM, fall back to the conversation
+			channel = append(channel, pr)
+		}
+	}
+	if len(channel) > 0 {
+		header := fmt.Sprintf("%s waiting for review >%s on %s:", pluralPRs(len(channel)),
+			FormatStalePRThreshold(setting.Threshold), setting.Repo)
+		if err := s.queue.EnqueueChatSend(setting.ConvID, formatStalePRDigest(header, channel, true)); err != nil {
+			s.Errorf("unable to queue stale PR digest: %s", err)
+		}
+		s.stats.Count("remind - conversation")
+	}
+	usernames := make([]string, 0, len(byReviewer))
+	for kbUsername := range byReviewer {
+		usernames = append(usernames, kbUsername)
+	}
+	sort.Strings(usernames)
+	for _, kbUsername := range usernames {
+		reviews := byReviewer[kbUsername]
+		header := fmt.Sprintf("%s on %s waiting for your review >%s:", pluralPRs(len(reviews)), setting.Repo,
+			FormatStalePRThreshold(setting.Threshold))
+		if err := s.queue.EnqueueDirectMessage(kbUsername, formatStalePRDigest(header, reviews, false)); err != nil {
+			s.Errorf("unable to queue stale PR reminder for %s: %s", kbUsername, err)
+		}
+		s.stats.Count("remind - dm")
+	}
+}
+
+func pluralPRs(n int) string {
+	if n == 1 {
+		return "1 PR"
+	}
+	return fmt.Sprintf("%d PRs", n)
+}
+
+func formatStalePRDigest(header string, prs []stalePR, withReviewers bool) string {
+	lines := []string{header}
+	for index, pr := range prs {
+		if index == maxStalePRs {
+			lines = append(lines, fmt.Sprintf("- and %d more", len(prs)-maxStalePRs))
+			break
+		}
+		age := formatPRAge(time.Since(pr.pr.GetCreatedAt()))
+		line := fmt.Sprintf("- #%d “%s” by *%s* (%s)", pr.pr.GetNumber(), pr.pr.GetTitle(), pr.pr.GetUser().GetLogin(), age)
+		if withReviewers && len(pr.reviewers) > 0 {
+			line += ", waiting on " + strings.Join(pr.reviewers, ", ")
+		}
+		lines = append(lines, line+"\n  "+pr.pr.GetHTMLURL())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ParseStalePRThreshold parses thresholds like `48h` or `2d`.
+func ParseStalePRThreshold(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid threshold %q", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold < time.Hour {
+		return 0, fmt.Errorf("invalid threshold %q", value)
+	}
+	return threshold.Truncate(time.Hour), nil
+}
+
+func FormatStalePRThreshold(threshold time.Duration) string {
+	if threshold >= 24*time.Hour && threshold%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", threshold/(24*time.Hour))
+	}
+	return fmt.Sprintf("%dh", threshold/time.Hour)
+}
+
+func formatPRAge(age time.Duration) string {
+	if age < 48*time.Hour {
+		return fmt.Sprintf("%dh", age/time.Hour)
+	}
+	return fmt.Sprintf("%dd", age/(24*time.Hour))
+}
@@ -0,0 +1,206 @@
+package githubbot
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	// unfurlSetting turns link previews off for a conversation
+	unfurlSetting = "unfurl"
+	// how many links of one message get a preview
+	maxUnfurls = 3
+)
+
+var githubLinkRE = regexp.MustCompile(`https?://(?:www\.)?github\.com/([\w.-]+)/([\w.-]+)/(issues|pull|commit)/([0-9a-fA-F]+)`)
+
+type githubLink struct {
+	owner, repo, kind, id string
+}
+
+func (l githubLink) fullRepo() string {
+	return l.owner + "/" + l.repo
+}
+
+// findGitHubLinks returns the distinct issue, pull request and commit links
+// in text.
+func findGitHubLinks(text string) (links []githubLink) {
+	seen := make(map[githubLink]bool)
+	for _, match := range githubLinkRE.FindAllStringSubmatch(text, -1) {
+		link := githubLink{owner: match[1], repo: match[2], kind: match[3], id: match[4]}
+		if link.kind != "commit" {
+			if _, err := strconv.Atoi(link.id); err != nil {
+				continue
+			}
+		} else if len(link.id) < 7 {
+			continue
+		}
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+func formatLabels(labels []*github.Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for _, label := range labels {
+		names = append(names, label.GetName())
+	}
+	return fmt.Sprintf(" · %s", strings.Join(names, ", "))
+}
+
+func formatIssuePreview(repo string, issue *github.Issue) string {
+	return fmt.Sprintf("*%s#%d* “%s”\n%s · opened by *%s*%s", repo, issue.GetNumber(), issue.GetTitle(),
+		issue.GetState(), issue.GetUser().GetLogin(), formatLabels(issue.Labels))
+}
+
+func formatPullRequestPreview(repo string, pr *github.PullRequest) string {
+	state := pr.GetState()
+	switch {
+	case pr.GetMerged():
+		state = "merged"
+	case pr.GetDraft() && state == "open":
+		state = "draft"
+	}
+	return fmt.Sprintf("*%s#%d* “%s”\n%s · opened by *%s* · %s ← %s · +%d -%d%s", repo, pr.GetNumber(), pr.GetTitle(),
+		state, pr.GetUser().GetLogin(), pr.GetBase().GetRef(), pr.GetHead().GetRef(), pr.GetAdditions(),
+		pr.GetDeletions(), formatLabels(pr.Labels))
+}
+
+func formatCommitPreview(repo string, commit *github.RepositoryCommit) string {
+	message := strings.SplitN(commit.GetCommit().GetMessage(), "\n", 2)[0]
+	author := commit.GetAuthor().GetLogin()
+	if author == "" {
+		author = commit.GetCommit().GetAuthor().GetName()
+	}
+	return fmt.Sprintf("*%s@%s* “%s”\nby *%s* · +%d -%d", repo, shortSHA(commit.GetSHA()), message, author,
+		commit.GetStats().GetAdditions(), commit.GetStats().GetDeletions())
+}
+
+// handleUnfurl replies to messages with links to issues, pull requests or
+// commits of repositories the conversation is subscribed to with a preview.
+func (h *Handler) handleUnfurl(msg chat1.MsgSummary) error {
+	links := findGitHubLinks(msg.Content.Text.Body)
+	if len(links) == 0 {
+		return nil
+	}
+	enabled, err := h.settings.GetBool(msg.ConvID, unfurlSetting, true)
+	if err != nil {
+		return fmt.Errorf("error getting unfurl setting: %s", err)
+	}
+	if !enabled {
+		return nil
+	}
+	var previews []string
+	for _, link := range links {
+		if len(previews) == maxUnfurls {
+			break
+		}
+		// only subscribed repositories, so private ones don't leak elsewhere
+		installationID, err := h.db.GetSubscriptionInstallation(msg.ConvID, link.fullRepo())
+		if err != nil {
+			return fmt.Errorf("error getting subscription: %s", err)
+		}
+		if installationID == 0 {
+			continue
+		}
+		preview, err := h.linkPreview(installationID, link)
+		if err != nil {
+			h.Debug("handleUnfurl: unable to preview %s: %s", link.fullRepo(), err)
+			continue
+		}
+		previews = append(previews, preview)
+	}
+	if len(previews) == 0 {
+		return nil
+	}
+	h.stats.Count("unfurl")
+	if _, err := h.kbc.SendReplyByConvID(msg.ConvID, &msg.Id, "%s", strings.Join(previews, "\n\n")); err != nil {
+		if err := base.GetNonFatalChatError(err); err != nil {
+			h.Debug("handleUnfurl: unable to send: %s", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) linkPreview(installationID int64, link githubLink) (string, error) {
+	itr := ghinstallation.NewFromAppsTransport(h.atr, installationID)
+	client := github.NewClient(base.NewHTTPClientWithTransport(itr))
+	ctx := context.TODO()
+	if link.kind == "commit" {
+		commit, _, err := client.Repositories.GetCommit(ctx, link.owner, link.repo, link.id)
+		if err != nil {
+			return "", err
+		}
+		return formatCommitPreview(link.fullRepo(), commit), nil
+	}
+	number, err := strconv.Atoi(link.id)
+	if err != nil {
+		return "", err
+	}
+	if link.kind == "pull" {
+		pr, _, err := client.PullRequests.Get(ctx, link.owner, link.repo, number)
+		if err != nil {
+			return "", err
+		}
+		return formatPullRequestPreview(link.fullRepo(), pr), nil
+	}
+	issue, _, err := client.Issues.Get(ctx, link.owner, link.repo, number)
+	if err != nil {
+		return "", err
+	}
+	return formatIssuePreview(link.fullRepo(), issue), nil
+}
+
+// handleUnfurlPref turns link previews on or off, as `!github unfurl <on|off>`.
+func (h *Handler) handleUnfurlPref(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!github unfurl on` or `!github unfurl off`.")
+		return nil
+	}
+
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+		return nil
+	}
+	if args[0] == "on" {
+		if err := h.settings.Delete(msg.ConvID, unfurlSetting); err != nil {
+			return fmt.Errorf("error setting unfurl: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I'll preview links to issues, pull requests and commits of repositories subscribed to here.")
+		return nil
+	}
+	if err := h.settings.Set(msg.ConvID, unfurlSetting, false); err != nil {
+		return fmt.Errorf("error setting unfurl: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I won't preview GitHub links here.")
+	return nil
+}
@@ -5,12 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/git"
 
 	"github.com/google/go-github/v31/github"
 )
@@ -88,6 +90,91 @@ func formatCheckRunMessage(evt *github.CheckRunEvent, username string) (res stri
 	}
 }
 
+// maxFailedChecks is how many failing checks a CI summary lists by name
+const maxFailedChecks = 10
+
+func formatReviewMessage(evt *github.PullRequestReviewEvent, username string) string {
+	if evt.GetAction() != "submitted" {
+		return ""
+	}
+	review := evt.GetReview()
+	var verb string
+	switch strings.ToLower(review.GetState()) {
+	case "approved":
+		verb = ":white_check_mark: %s approved pull request #%d on %s"
+	case "changes_requested":
+		verb = ":warning: %s requested changes on pull request #%d on %s"
+	case "commented":
+		if review.GetBody() == "" {
+			// replies to review comments come in as empty reviews
+			return ""
+		}
+		verb = ":speech_balloon: %s reviewed pull request #%d on %s"
+	default:
+		return ""
+	}
+	res := fmt.Sprintf(verb, username, evt.GetPullRequest().GetNumber(), evt.GetRepo().GetName())
+	if excerpt := git.FormatExcerpt(review.GetBody(), maxDMExcerptLen); excerpt != "" {
+		res += "\n" + excerpt
+	}
+	return res + "\n" + review.GetHTMLURL()
+}
+
+func isFailingConclusion(conclusion string) bool {
+	switch conclusion {
+	case "failure", "timed_out", "action_required":
+		return true
+	default:
+		return false
+	}
+}
+
+// formatCheckSuiteMessage summarizes a failed check suite: which checks
+// failed, out of how many, with a link to each one's logs.
+func formatCheckSuiteMessage(evt *github.CheckSuiteEvent, runs []*github.CheckRun, username string) (res string) {
+	suite := evt.GetCheckSuite()
+	if evt.GetAction() != "completed" || !isFailingConclusion(suite.GetConclusion()) {
+		return ""
+	}
+	repo := evt.GetRepo().GetName()
+	isPullRequest := len(suite.PullRequests) > 0
+	ciName := "CI"
+	if suite.GetApp().GetName() != "" {
+		ciName = fmt.Sprintf("*%s*", suite.GetApp().GetName())
+	}
+
+	defer func() {
+		res = formatPRUsername(isPullRequest, res, username)
+	}()
+
+	var failed []*github.CheckRun
+	for _, run := range runs {
+		if isFailingConclusion(run.GetConclusion()) {
+			failed = append(failed, run)
+		}
+	}
+	if isPullRequest {
+		res = fmt.Sprintf(":x: %s failed for pull request #%d on %s", ciName, suite.PullRequests[0].GetNumber(), repo)
+	} else {
+		res = fmt.Sprintf(":x: %s failed for %s/%s", ciName, repo, suite.GetHeadBranch())
+	}
+	if len(runs) > 0 {
+		res += fmt.Sprintf(" (%d of %d checks failed)", len(failed), len(runs))
+	}
+	res += ":"
+	for index, run := range failed {
+		if index == maxFailedChecks {
+			res += fmt.Sprintf("\n- and %d more", len(failed)-maxFailedChecks)
+			break
+		}
+		res += fmt.Sprintf("\n- *%s*: %s", run.GetName(), run.GetHTMLURL())
+	}
+	if len(failed) == 0 {
+		res += fmt.Sprintf("\n%s/commit/%s", evt.GetRepo().GetHTMLURL(), suite.GetHeadSHA())
+	}
+	return res
+}
+
 func formatStatusMessage(evt *github.StatusEvent, pullRequests []*github.PullRequest, username string) (res string) {
 	state := evt.GetState()
 	repo := evt.GetRepo().GetName()
@@ -135,6 +222,82 @@ func formatStatusMessage(evt *github.StatusEvent, pullRequests []*github.PullReq
 	}
 }
 
+// limits on how much of a release's notes are quoted
+const (
+	maxReleaseNotesLen   = 1000
+	maxReleaseNotesLines = 15
+	maxReleaseAssets     = 10
+)
+
+func formatReleaseMessage(evt *github.ReleaseEvent, username string) string {
+	if evt.GetAction() != "published" {
+		return ""
+	}
+	return formatRelease(evt.GetRepo().GetName(), evt.GetRelease(), username)
+}
+
+// formatRelease describes a release with its notes, truncated, and assets.
+func formatRelease(repo string, release *github.RepositoryRelease, username string) string {
+	name := release.GetName()
+	if name == "" {
+		name = release.GetTagName()
+	}
+	kind := "release"
+	if release.GetPrerelease() {
+		kind = "pre-release"
+	}
+	res := fmt.Sprintf(":package: %s published %s *%s* (`%s`) on %s.", username, kind, name, release.GetTagName(), repo)
+	if notes := truncateMarkdown(release.GetBody(), maxReleaseNotesLen, maxReleaseNotesLines); notes != "" {
+		res += "\n" + notes
+	}
+	if len(release.Assets) > 0 {
+		res += "\nAssets:"
+		for index, asset := range release.Assets {
+			if index == maxReleaseAssets {
+				res += fmt.Sprintf("\n- and %d more", len(release.Assets)-maxReleaseAssets)
+				break
+			}
+			res += fmt.Sprintf("\n- %s (%s): %s", asset.GetName(), humanizeBytes(asset.GetSize()), asset.GetBrowserDownloadURL())
+		}
+	}
+	return res + "\n" + release.GetHTMLURL()
+}
+
+// truncateMarkdown cuts text to maxLen characters and maxLines lines, closing
+// a code block left open by the cut.
+func truncateMarkdown(text string, maxLen, maxLines int) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	truncated := false
+	if lines := strings.Split(text, "\n"); len(lines) > maxLines {
+		text = strings.Join(lines[:maxLines], "\n")
+		truncated = true
+	}
+	if runes := []rune(text); len(runes) > maxLen {
+		text = string(runes[:maxLen])
+		truncated = true
+	}
+	if truncated {
+		text = strings.TrimSpace(text) + "..."
+	}
+	if strings.Count(text, "```")%2 == 1 {
+		text += "```"
+	}
+	return text
+}
+
+func humanizeBytes(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
 func GetDefaultBranch(repo string, client *github.Client) (branch string, err error) {
 	args := strings.Split(repo, "/")
 	if len(args) != 2 {
@@ -175,36 +338,205 @@ type keybaseID struct {
 	Username string `json:"username"`
 }
 
-func getPossibleKBUser(kbc *kbchat.API, d *DB, debug *base.DebugOutput, githubUsername string, convID chat1.ConvIDStr) (u username) {
-	u = username{githubUsername: githubUsername}
+// lookupKBUser returns the Keybase user who linked githubUsername with
+// `!github link` or has a proof for it, or "" if there's none.
+func lookupKBUser(kbc *kbchat.API, identities *base.IdentityStore, debug *base.DebugOutput,
+	githubUsername string) string {
+	identity, err := identities.KeybaseUser(base.GitHubIdentity, githubUsername)
+	if err != nil {
+		debug.Debug("lookupKBUser: couldn't get linked identity: %s", err)
+	}
+	if identity != nil {
+		return identity.KeybaseUsername
+	}
 	id := kbc.Command("id", "-j", fmt.Sprintf("%s@github", githubUsername))
 	output, err := id.Output()
 	if err != nil {
-		// fall back to github username if `keybase id` errors
-		return u
+		// no proof if `keybase id` errors
+		return ""
 	}
-
 	var i keybaseID
-	err = json.Unmarshal(output, &i)
-	if err != nil {
-		debug.Debug("getPossibleKBUser: couldn't parse keybase id: %s", err)
+	if err := json.Unmarshal(output, &i); err != nil {
+		debug.Debug("lookupKBUser: couldn't parse keybase id: %s", err)
+		return ""
+	}
+	return i.Username
+}
+
+// getPossibleKBUser maps a GitHub login to a Keybase user, either one who
+// linked it with `!github link` or who has a proof for it. The user is only
+// mentioned if they allow mentions in convID and didn't mute eventType events
+// of repo.
+func getPossibleKBUser(kbc *kbchat.API, d *DB, identities *base.IdentityStore, debug *base.DebugOutput,
+	githubUsername string, convID chat1.ConvIDStr, repo, eventType string) (u username) {
+	u = username{githubUsername: githubUsername}
+	kbUsername := lookupKBUser(kbc, identities, debug, githubUsername)
+	if kbUsername == "" {
+		// fall back to github username
 		return u
 	}
 
-	prefs, err := d.GetUserPreferences(i.Username, convID)
+	prefs, err := d.GetUserPreferences(kbUsername, convID)
 	if err != nil {
 		debug.Debug("getPossibleKBUser: couldn't get user preferences: %s", err)
 		return u
 	}
 
-	if prefs.Mention {
-		u.keybaseUsername = &i.Username
+	if !prefs.Mention {
+		return u
+	}
+
+	muted, err := d.IsMuted(kbUsername, convID, repo, eventType)
+	if err != nil {
+		debug.Debug("getPossibleKBUser: couldn't get mutes: %s", err)
+		return u
+	}
+	if !muted {
+		u.keybaseUsername = &kbUsername
 	}
 
 	return u
 }
 
 // pref checking
+
+// eventTypes maps the names accepted by `--events` and `subscribe <repo>
+// <event type>` to the feature they toggle.
+var eventTypes = map[string]func(*Features) *bool{
+	"issues":      func(f *Features) *bool { return &f.Issues },
+	"pulls":       func(f *Features) *bool { return &f.PullRequests },
+	"prs":         func(f *Features) *bool { return &f.PullRequests },
+	"commits":     func(f *Features) *bool { return &f.Commits },
+	"pushes":      func(f *Features) *bool { return &f.Commits },
+	"statuses":    func(f *Features) *bool { return &f.Statuses },
+	"checks":      func(f *Features) *bool { return &f.Statuses },
+	"releases":    func(f *Features) *bool { return &f.Releases },
+	"deployments": func(f *Features) *bool { return &f.Deployments },
+	"deploys":     func(f *Features) *bool { return &f.Deployments },
+	"discussions": func(f *Features) *bool { return &f.Discussions },
+}
+
+func isEventType(name string) bool {
+	_, ok := eventTypes[name]
+	return ok
+}
+
+// parseEventTypes sets the features named in the comma separated list to
+// enable, it returns the first unknown name if any.
+func parseEventTypes(list string, features *Features, enable bool) (unknown string) {
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		field, ok := eventTypes[name]
+		if !ok {
+			return name
+		}
+		*field(features) = enable
+	}
+	return ""
+}
+
+// Filter flags accepted by subscribe and unsubscribe, each takes a comma
+// separated list.
+const (
+	eventsFlag   = "events"
+	branchesFlag = "branches"
+	labelsFlag   = "labels"
+	// categoriesFlag filters discussions by category
+	categoriesFlag = "categories"
+)
+
+// splitListFlags removes `--<name> <list>` or `--<name>=<list>` for each of
+// names from args.
+func splitListFlags(args []string, names ...string) (rest []string, lists map[string]string) {
+	lists = make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		matched := false
+		for _, name := range names {
+			flag := "--" + name
+			switch {
+			case arg == flag && i+1 < len(args):
+				lists[name] = args[i+1]
+				i++
+			case strings.HasPrefix(arg, flag+"="):
+				lists[name] = strings.TrimPrefix(arg, flag+"=")
+			default:
+				continue
+			}
+			matched = true
+			break
+		}
+		if !matched {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, lists
+}
+
+func splitList(list string) (res []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// matchBranch reports whether branch equals or matches one of patterns, e.g.
+// `release/*`.
+func matchBranch(patterns []string, branch string) bool {
+	branch = strings.ToLower(branch)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == branch {
+			return true
+		}
+		if ok, err := path.Match(pattern, branch); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// eventLabels returns the labels of the issue or pull request an event is
+// about, ok is false for other events.
+func eventLabels(event interface{}) (labels []string, ok bool) {
+	var ghLabels []*github.Label
+	switch event := event.(type) {
+	case *github.IssuesEvent:
+		ghLabels = event.GetIssue().Labels
+	case *github.PullRequestEvent:
+		ghLabels = event.GetPullRequest().Labels
+	case *github.PullRequestReviewEvent:
+		ghLabels = event.GetPullRequest().Labels
+	default:
+		return nil, false
+	}
+	for _, label := range ghLabels {
+		labels = append(labels, label.GetName())
+	}
+	return labels, true
+}
+
+// matchLabels reports whether one of labels is in filter, an empty filter
+// matches everything.
+func matchLabels(filter, labels []string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, want := range filter {
+		for _, label := range labels {
+			if strings.EqualFold(want, label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func shouldParseEvent(event interface{}, features *Features) bool {
 	if features == nil {
 		return true
@@ -212,12 +544,18 @@ func shouldParseEvent(event interface{}, features *Features) bool {
 	switch event.(type) {
 	case *github.IssuesEvent:
 		return features.Issues
-	case *github.PullRequestEvent:
+	case *github.PullRequestEvent, *github.PullRequestReviewEvent:
 		return features.PullRequests
 	case *github.PushEvent:
 		return features.Commits
-	case *github.CheckRunEvent, *github.StatusEvent:
+	case *github.CheckRunEvent, *github.CheckSuiteEvent, *github.StatusEvent:
 		return features.Statuses
+	case *github.ReleaseEvent:
+		return features.Releases
+	case *github.DeploymentEvent, *github.DeploymentStatusEvent:
+		return features.Deployments
+	case *discussionEvent:
+		return features.Discussions
 	default:
 		return false
 	}
@@ -0,0 +1,132 @@
+package githubbot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitListFlags(t *testing.T) {
+	rest, lists := splitListFlags([]string{"keybase/client", "--events", "issues,pulls", "--branches=main,release/*", "--labels"},
+		eventsFlag, branchesFlag, labelsFlag)
+	// a trailing flag without a list is left in place
+	require.Equal(t, []string{"keybase/client", "--labels"}, rest)
+	require.Equal(t, map[string]string{eventsFlag: "issues,pulls", branchesFlag: "main,release/*"}, lists)
+
+	require.Equal(t, []string{"main", "release/*"}, splitList(" main, ,release/* "))
+	require.Empty(t, splitList(""))
+}
+
+func TestParseEventTypes(t *testing.T) {
+	features := &Features{}
+	require.Empty(t, parseEventTypes("issues, prs,checks", features, true))
+	require.Equal(t, &Features{Issues: true, PullRequests: true, Statuses: true}, features)
+
+	require.Empty(t, parseEventTypes("pulls", features, false))
+	require.Equal(t, &Features{Issues: true, Statuses: true}, features)
+
+	require.Equal(t, "builds", parseEventTypes("releases,builds", features, true))
+}
+
+func TestMatchBranch(t *testing.T) {
+	patterns := []string{"main", "release/*"}
+	require.True(t, matchBranch(patterns, "main"))
+	require.True(t, matchBranch(patterns, "Release/1.0"))
+	require.False(t, matchBranch(patterns, "release/1.0/hotfix"))
+	require.False(t, matchBranch(patterns, "feature"))
+	require.False(t, matchBranch(nil, "main"))
+}
+
+func TestMatchLabels(t *testing.T) {
+	event := &github.IssuesEvent{Issue: &github.Issue{Labels: []*github.Label{{Name: github.String("Bug")}}}}
+	labels, ok := eventLabels(event)
+	require.True(t, ok)
+	require.Equal(t, []string{"Bug"}, labels)
+	_, ok = eventLabels(&github.PushEvent{})
+	require.False(t, ok)
+
+	require.True(t, matchLabels(nil, labels))
+	require.True(t, matchLabels([]string{"feature", "bug"}, labels))
+	require.False(t, matchLabels([]string{"feature"}, labels))
+	require.False(t, matchLabels([]string{"bug"}, nil))
+}
+
+func TestShouldParseEvent(t *testing.T) {
+	require.True(t, shouldParseEvent(&github.IssuesEvent{}, nil))
+	features := &Features{PullRequests: true, Deployments: true}
+	require.True(t, shouldParseEvent(&github.PullRequestReviewEvent{}, features))
+	require.True(t, shouldParseEvent(&github.DeploymentStatusEvent{}, features))
+	require.False(t, shouldParseEvent(&github.IssuesEvent{}, features))
+	require.False(t, shouldParseEvent(&github.CheckRunEvent{}, features))
+	require.False(t, shouldParseEvent(&github.WatchEvent{}, features))
+}
+
+func TestParseMuteEventTypes(t *testing.T) {
+	types, unknown := parseMuteEventTypes([]string{"prs,checks", "deploys"})
+	require.Empty(t, unknown)
+	require.Equal(t, []string{"pulls", "statuses", "deployments"}, types)
+
+	_, unknown = parseMuteEventTypes([]string{"issues", "builds"})
+	require.Equal(t, "builds", unknown)
+
+	require.Equal(t, "pulls", muteEventType(&github.PullRequestEvent{}))
+	require.Equal(t, "statuses", muteEventType(&github.CheckSuiteEvent{}))
+	require.Equal(t, "discussions", muteEventType(&discussionEvent{}))
+	require.Empty(t, muteEventType(&github.WatchEvent{}))
+	// every mute type can be named by itself
+	for _, eventType := range muteEventTypes {
+		_, ok := muteEventTypes[eventType]
+		require.True(t, ok, eventType)
+	}
+}
+
+func TestStalePRThreshold(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"2d":   48 * time.Hour,
+		"36h":  36 * time.Hour,
+		"90m":  time.Hour,
+		"1h1s": time.Hour,
+	} {
+		threshold, err := ParseStalePRThreshold(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, threshold, value)
+	}
+	for _, value := range []string{"0d", "-1d", "xd", "30m", "soon"} {
+		_, err := ParseStalePRThreshold(value)
+		require.Error(t, err, value)
+	}
+	require.Equal(t, "2d", FormatStalePRThreshold(48*time.Hour))
+	require.Equal(t, "36h", FormatStalePRThreshold(36*time.Hour))
+	require.Equal(t, "5h", formatPRAge(5*time.Hour+time.Minute))
+	require.Equal(t, "3d", formatPRAge(80*time.Hour))
+}
+
+func TestStalePRs(t *testing.T) {
+	old := time.Now().Add(-72 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	newPR := func(number int, createdAt time.Time, draft bool, reviewers ...string) *github.PullRequest {
+		pr := &github.PullRequest{Number: github.Int(number), CreatedAt: &createdAt, Draft: github.Bool(draft)}
+		for _, reviewer := range reviewers {
+			pr.RequestedReviewers = append(pr.RequestedReviewers, &github.User{Login: github.String(reviewer)})
+		}
+		return pr
+	}
+	team := newPR(5, old, false)
+	team.RequestedTeams = []*github.Team{{Slug: github.String("core")}}
+
+	s := &StalePRScheduler{}
+	res := s.stalePRs([]*github.PullRequest{
+		newPR(1, old, false, "alice", "bob"),
+		newPR(2, recent, false, "alice"),
+		newPR(3, old, true, "alice"),
+		newPR(4, old, false),
+		team,
+	}, 48*time.Hour)
+	require.Len(t, res, 2)
+	require.Equal(t, 1, res[0].pr.GetNumber())
+	require.Equal(t, []string{"alice", "bob"}, res[0].reviewers)
+	require.Equal(t, 5, res[1].pr.GetNumber())
+	require.Empty(t, res[1].reviewers)
+}
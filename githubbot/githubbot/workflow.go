@@ -0,0 +1,235 @@
+package githubbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v31/github"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+)
+
+// go-github doesn't know this event yet, so it's parsed here
+const workflowRunHook = "workflow_run"
+
+type workflowRunEvent struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		ID         int64  `json:"id"`
+		Name       string `json:"name"`
+		Path       string `json:"path"`
+		WorkflowID int64  `json:"workflow_id"`
+		RunNumber  int    `json:"run_number"`
+		HeadBranch string `json:"head_branch"`
+		Event      string `json:"event"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+	Repo *github.Repository `json:"repository"`
+}
+
+// matches reports whether the run is of the workflow a dispatch named, by
+// file name or ID.
+func (e *workflowRunEvent) matches(dispatch WorkflowDispatch) bool {
+	run := e.WorkflowRun
+	return strings.EqualFold(dispatch.Workflow, path.Base(run.Path)) ||
+		dispatch.Workflow == strconv.FormatInt(run.WorkflowID, 10)
+}
+
+func formatWorkflowRunResult(dispatch *WorkflowDispatch, evt *workflowRunEvent) string {
+	run := evt.WorkflowRun
+	icon, result := ":x:", "failed"
+	switch run.Conclusion {
+	case "success":
+		icon, result = ":white_check_mark:", "succeeded"
+	case "cancelled":
+		icon, result = ":no_entry_sign:", "was cancelled"
+	case "timed_out":
+		result = "timed out"
+	case "skipped", "neutral":
+		icon, result = ":fast_forward:", "was skipped"
+	}
+	return fmt.Sprintf("%s *%s* #%d on `%s` of %s, run by @%s, %s\n%s", icon, run.Name, run.RunNumber,
+		dispatch.Ref, evt.Repo.GetFullName(), dispatch.Username, result, run.HTMLURL)
+}
+
+// handleWorkflowRun follows up on workflow runs dispatched from chat: the
+// run is tied to its dispatch when it's requested and its result is posted
+// back when it completes.
+func (h *HTTPSrv) handleWorkflowRun(payload []byte) {
+	var evt workflowRunEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		h.Debug("could not parse workflow run: %s", err)
+		return
+	}
+	if evt.WorkflowRun.Event != "workflow_dispatch" {
+		return
+	}
+	switch evt.Action {
+	case "requested":
+		dispatches, err := h.db.GetUnstartedWorkflowDispatches(evt.Repo.GetFullName(), evt.WorkflowRun.HeadBranch)
+		if err != nil {
+			h.Errorf("Error getting workflow dispatches: %s", err)
+			return
+		}
+		for _, dispatch := range dispatches {
+			if !evt.matches(dispatch) {
+				continue
+			}
+			if err := h.db.SetWorkflowDispatchRun(dispatch.ID, evt.WorkflowRun.ID); err != nil {
+				h.Errorf("Error setting workflow dispatch run: %s", err)
+			}
+			return
+		}
+	case "completed":
+		dispatch, err := h.db.TakeWorkflowDispatch(evt.WorkflowRun.ID)
+		if err != nil {
+			h.Errorf("Error getting workflow dispatch: %s", err)
+			return
+		}
+		if dispatch == nil {
+			return
+		}
+		h.Stats.Count("webhook - workflow run")
+		if err := h.queue.EnqueueChatSend(dispatch.ConvID, formatWorkflowRunResult(dispatch, &evt)); err != nil {
+			h.Errorf("unable to queue workflow run message: %s", err)
+			return
+		}
+		h.analytics.RecordNotification(dispatch.ConvID, workflowRunHook)
+	}
+}
+
+// parseWorkflowArgs splits `--ref <ref>` and repeated `--input key=value`
+// flags from the rest of args.
+func parseWorkflowArgs(args []string) (rest []string, ref string, inputs map[string]string, ok bool) {
+	inputs = make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var flag, value string
+		switch {
+		case arg == "--ref" || arg == "--input":
+			if i+1 == len(args) {
+				return nil, "", nil, false
+			}
+			flag, value = arg, args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--ref="), strings.HasPrefix(arg, "--input="):
+			parts := strings.SplitN(arg, "=", 2)
+			flag, value = parts[0], parts[1]
+		default:
+			rest = append(rest, arg)
+			continue
+		}
+		if flag == "--ref" {
+			ref = strings.TrimPrefix(value, "refs/heads/")
+			continue
+		}
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, "", nil, false
+		}
+		inputs[kv[0]] = kv[1]
+	}
+	return rest, ref, inputs, true
+}
+
+func formatWorkflowInputs(inputs map[string]string) string {
+	if len(inputs) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(inputs))
+	for key, value := range inputs {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", key, value))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf(" with `%s`", strings.Join(pairs, " "))
+}
+
+// handleWorkflowRunCommand dispatches a workflow as the sender, as `!github
+// workflow run <owner/repo> <workflow> [--ref <ref>] [--input key=value]...`.
+func (h *Handler) handleWorkflowRunCommand(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	usage := "I don't understand! Try `!github workflow run <owner/repo> <workflow file> [--ref main] [--input env=prod]`"
+	args, ref, inputs, ok := parseWorkflowArgs(args)
+	if !ok || len(args) != 2 {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	fullRepo, workflow := args[0], args[1]
+	repoParts := strings.Split(fullRepo, "/")
+	if len(repoParts) != 2 || repoParts[0] == "" || repoParts[1] == "" {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a repository to me! %s", fullRepo, usage)
+		return nil
+	}
+	owner, repo := repoParts[0], repoParts[1]
+	client, err := h.userClient(msg)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.TODO()
+	login, canWrite, res, err := hasWritePermission(ctx, client, owner, repo)
+	if err != nil {
+		return h.reportPRError(msg, fmt.Sprintf("check your permissions on `%s`", fullRepo), res, err)
+	}
+	if !canWrite {
+		h.ChatEcho(msg.ConvID, "Sorry, your GitHub account *%s* can't run workflows on `%s`.", login, fullRepo)
+		return nil
+	}
+	if ref == "" {
+		repository, res, err := client.Repositories.Get(ctx, owner, repo)
+		if err != nil {
+			return h.reportPRError(msg, fmt.Sprintf("find `%s`", fullRepo), res, err)
+		}
+		ref = repository.GetDefaultBranch()
+	}
+
+	// go-github can't dispatch workflows yet
+	req, err := client.NewRequest("POST", fmt.Sprintf("repos/%s/%s/actions/workflows/%s/dispatches", owner, repo, workflow),
+		struct {
+			Ref    string            `json:"ref"`
+			Inputs map[string]string `json:"inputs,omitempty"`
+		}{Ref: ref, Inputs: inputs})
+	if err != nil {
+		return err
+	}
+	// recorded before dispatching since GitHub can report the run before it
+	// answers the dispatch
+	dispatchID, err := h.db.AddWorkflowDispatch(WorkflowDispatch{
+		ConvID:   msg.ConvID,
+		Repo:     fullRepo,
+		Workflow: workflow,
+		Ref:      ref,
+		Username: msg.Sender.Username,
+	})
+	if err != nil {
+		return fmt.Errorf("error recording workflow dispatch: %s", err)
+	}
+	if res, err := client.Do(ctx, req, nil); err != nil {
+		if err := h.db.DeleteWorkflowDispatch(dispatchID); err != nil {
+			h.Errorf("unable to delete workflow dispatch: %s", err)
+		}
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusNotFound:
+				h.ChatEcho(msg.ConvID, "I couldn't find the workflow `%s` on `%s`! Use its file name, e.g. `deploy.yml`.", workflow, fullRepo)
+				return nil
+			case http.StatusUnprocessableEntity:
+				h.ChatEcho(msg.ConvID, "GitHub rejected that, check that the workflow has a `workflow_dispatch` trigger, that `%s` exists and the inputs: %s", ref, err)
+				return nil
+			}
+		}
+		return h.reportPRError(msg, fmt.Sprintf("run `%s`", workflow), res, err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, started `%s` on `%s` of `%s`%s. I'll post here when the run finishes.",
+		workflow, ref, fullRepo, formatWorkflowInputs(inputs))
+	return nil
+}
@@ -1,13 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -19,7 +16,6 @@ import (
 	"github.com/keybase/managed-bots/githubbot/githubbot"
 	"golang.org/x/oauth2"
 	oauth2github "golang.org/x/oauth2/github"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
@@ -31,6 +27,9 @@ type Options struct {
 	AppID             int64
 	OAuthClientID     string
 	OAuthClientSecret string
+	SummarizeChecks   bool
+	ThreadCI          bool
+	ThreadUpdates     bool
 }
 
 func NewOptions() *Options {
@@ -61,41 +60,45 @@ const backs = "```"
 func (s *BotServer) makeAdvertisement() kbchat.Advertisement {
 	subExtended := fmt.Sprintf(`Enables posting updates from the provided GitHub repository to this conversation.
 
-Running this command without a branch or event type will subscribe you to all events on the specified repository's default branch.
+Running this command without a branch or event type will subscribe you to all events on the specified repository's default branch. Pass %s--events%s, %s--branches%s (patterns like release/* work), %s--labels%s or %s--categories%s with a comma separated list to only receive those event types, pushes and statuses for those branches, issues and pull requests with one of those labels, or discussions in one of those categories.
 
-Event type must be one of %sissues, pulls, commits, statuses%s
+Event type must be one of %sissues, pulls, commits, statuses, releases, deployments, discussions%s
 
 Examples:%s
 !github subscribe keybase/client
 !github subscribe microsoft/typescript pulls
+!github subscribe golang/go --events issues,prs,releases
+!github subscribe golang/go --branches master,release-branch.* --labels release-blocker
+!github subscribe vercel/next.js --events discussions --categories q&a,announcements
 !github subscribe facebook/react gh-pages%s`,
-		backs, backs, backs, backs)
+		"`", "`", "`", "`", "`", "`", "`", "`", backs, backs, backs, backs)
 
 	unsubExtended := fmt.Sprintf(`Disables updates from the provided GitHub repository to this conversation.
 
-Running this command without a branch or event type will unsubscribe you from all events on the specified repository.
+Running this command without a branch or event type will unsubscribe you from all events on the specified repository. Pass %s--events%s, %s--branches%s, %s--labels%s or %s--categories%s with a comma separated list to remove those filters.
 
-Event type must be one of %sissues, pulls, commits, statuses%s
+Event type must be one of %sissues, pulls, commits, statuses, releases, deployments, discussions%s
 
 Examples:%s
 !github unsubscribe keybase/client
 !github unsubscribe microsoft/typescript commits
 !github unsubscribe facebook/react gh-pages%s`,
-		backs, backs, backs, backs)
+		"`", "`", "`", "`", "`", "`", "`", "`", backs, backs, backs, backs)
 
-	mentionsExtended := fmt.Sprintf(`Enables or disables mentions in GitHub events that involve your proven GitHub username.
+	mentionsExtended := fmt.Sprintf(`Enables or disables mentions in GitHub events that involve your proven GitHub username. Use %son%s or %soff%s to get a direct message when a GitHub comment @-mentions you.
 
 Examples:%s
 !github mentions disable
-!github mentions enable%s
-	`, backs, backs)
+!github mentions enable
+!github mentions on%s
+	`, "`", "`", "`", "`", backs, backs)
 
 	cmds := []chat1.UserBotCommandInput{
 		{
 			Name:        "github subscribe",
 			Description: "Enable updates from GitHub repos",
 			ExtendedDescription: &chat1.UserBotExtendedDescription{
-				Title:       `*!github subscribe* <owner/repo> [branch or event type]`,
+				Title:       `*!github subscribe* <owner/repo> [branch or event type] [--events <types>] [--branches <branches>] [--labels <labels>]`,
 				DesktopBody: subExtended,
 				MobileBody:  subExtended,
 			},
@@ -104,7 +107,7 @@ Examples:%s
 			Name:        "github unsubscribe",
 			Description: "Disable updates from GitHub repos",
 			ExtendedDescription: &chat1.UserBotExtendedDescription{
-				Title:       `*!github unsubscribe* <owner/repo> [branch or event type]`,
+				Title:       `*!github unsubscribe* <owner/repo> [branch or event type] [--events <types>] [--branches <branches>] [--labels <labels>]`,
 				DesktopBody: unsubExtended,
 				MobileBody:  unsubExtended,
 			},
@@ -113,15 +116,122 @@ Examples:%s
 			Name:        "github mentions",
 			Description: "Enable or disable mentions in GitHub events for your username in the current conversation.",
 			ExtendedDescription: &chat1.UserBotExtendedDescription{
-				Title:       `*!github mentions* <disable/enable>`,
+				Title:       `*!github mentions* <disable/enable/on/off>`,
 				DesktopBody: mentionsExtended,
 				MobileBody:  mentionsExtended,
 			},
 		},
+		{
+			Name:        "github mute",
+			Description: "Stop being mentioned in some or all events of a repository in this conversation.",
+			Usage:       "<owner/repo> [reviews,statuses,...]",
+		},
+		{
+			Name:        "github unmute",
+			Description: "Be mentioned in events of a repository in this conversation again.",
+			Usage:       "<owner/repo> [reviews,statuses,...]",
+		},
+		{
+			Name:        "github mutes",
+			Description: "List what you muted in this conversation.",
+		},
 		{
 			Name:        "github list",
 			Description: "List subscriptions for the current conversation.",
 		},
+		{
+			Name:        "github repos",
+			Description: "List the repositories of an organization or user I can subscribe to.",
+			Usage:       "<owner>",
+		},
+		{
+			Name:        "github stats",
+			Description: "Show merged pull requests, issues, top contributors and time to merge of a repository.",
+			Usage:       "<owner/repo> [30d]",
+		},
+		{
+			Name:        "github releases latest",
+			Description: "Show the latest release of a repository, with its notes and assets.",
+			Usage:       "<owner/repo>",
+		},
+		{
+			Name:        "github stale",
+			Description: "Remind this conversation, or the requested reviewers with --dm, of pull requests waiting for review.",
+			Usage:       "<owner/repo> [48h|2d|off] [--dm]",
+		},
+		{
+			Name:        "github digest",
+			Description: "Batch pushes and passing CI results of a subscription into a periodic digest.",
+			Usage:       "<owner/repo> <30m|2h|off>",
+		},
+		{
+			Name:        "github security",
+			Description: "Post a repository's Dependabot alerts here, or list the open ones.",
+			Usage:       "<on|off|list> <owner/repo> [low|medium|high|critical]",
+		},
+		{
+			Name:        "github project",
+			Description: "Post here when items of an organization project move to watched columns.",
+			Usage:       `<watch|unwatch|list> [<org> <project number>] [--columns "In Review,Blocked"] [--field Status]`,
+		},
+		{
+			Name:        "github issue create",
+			Description: "Open an issue as you, @mentions of linked Keybase users become their GitHub logins.",
+			Usage:       `<owner/repo> "title" [body]`,
+		},
+		{
+			Name:        "github rotation",
+			Description: "Assign new issues of a repository in turn to a roster of GitHub logins.",
+			Usage:       "<set|add|remove|show|off> <owner/repo> [login,login,...]",
+		},
+		{
+			Name:        "github comment",
+			Description: "Comment on an issue or pull request as you.",
+			Usage:       "<owner/repo#number> <message>",
+		},
+		{
+			Name:        "github search",
+			Description: "Search a repository's issues and pull requests with GitHub search qualifiers, as you.",
+			Usage:       "<owner/repo> [is:open label:bug involves:@me ...]",
+		},
+		{
+			Name:        "github unfurl",
+			Description: "Turn previews of GitHub links pasted here on or off.",
+			Usage:       "<on|off>",
+		},
+		{
+			Name:        "github pr approve",
+			Description: "Approve a pull request as you.",
+			Usage:       "<owner/repo#number>",
+		},
+		{
+			Name:        "github pr merge",
+			Description: "Merge a pull request as you once its checks pass.",
+			Usage:       "<owner/repo#number> [--squash]",
+		},
+		{
+			Name:        "github status",
+			Description: "List the required checks of a pull request and how they're doing.",
+			Usage:       "<owner/repo#number>",
+		},
+		{
+			Name:        "github rerun",
+			Description: "Rerun the failed workflow runs of a pull request.",
+			Usage:       "<owner/repo#number>",
+		},
+		{
+			Name:        "github workflow run",
+			Description: "Start a GitHub Actions workflow as you, I'll post its result here.",
+			Usage:       "<owner/repo> <workflow file> [--ref <ref>] [--input key=value]",
+		},
+		{
+			Name:        "github link",
+			Description: "Link your GitHub login so events you're involved in mention you.",
+		},
+		{
+			Name:        "github unlink",
+			Description: "Unlink your GitHub login.",
+		},
 		base.GetFeedbackCommandAdvertisement(s.kbc.GetUsername()),
 	}
 	return kbchat.Advertisement{
@@ -152,15 +262,11 @@ func (s *BotServer) getAppKey() (appKey []byte, err error) {
 		return b, nil
 	}
 
-	path := fmt.Sprintf("/keybase/private/%s/bot.private-key.pem", s.kbc.GetUsername())
-	s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", path)
-	cmd := s.opts.Command("fs", "read", path)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
+	secrets, err := s.opts.SecretsProvider(fmt.Sprintf("/keybase/private/%s", s.kbc.GetUsername()))
+	if err != nil {
 		return []byte{}, err
 	}
-	return out.Bytes(), nil
+	return secrets.GetSecret("bot.private-key.pem")
 }
 
 type botConfig struct {
@@ -182,16 +288,16 @@ func (s *BotServer) getConfig() (config *botConfig, err error) {
 			s.opts.WebhookSecret,
 		}, nil
 	}
-	path := fmt.Sprintf("/keybase/private/%s/credentials.json", s.kbc.GetUsername())
-	s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", path)
-	cmd := s.opts.Command("fs", "read", path)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
+	secrets, err := s.opts.SecretsProvider(fmt.Sprintf("/keybase/private/%s", s.kbc.GetUsername()))
+	if err != nil {
+		return nil, err
+	}
+	out, err := secrets.GetSecret("credentials.json")
+	if err != nil {
 		return nil, err
 	}
 
-	if err := json.Unmarshal(out.Bytes(), &config); err != nil {
+	if err := json.Unmarshal(out, &config); err != nil {
 		return nil, err
 	}
 
@@ -199,16 +305,19 @@ func (s *BotServer) getConfig() (config *botConfig, err error) {
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
+	s.Configure(s.opts.Options)
 
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := githubbot.NewDB(sdb)
 
 	botConfig, err := s.getConfig()
@@ -230,7 +339,7 @@ func (s *BotServer) Go() (err error) {
 	if err != nil {
 		s.Errorf("failed to get private key: %s", err)
 	}
-	tr := http.DefaultTransport
+	tr := base.NewHTTPTransport()
 	atr, err := ghinstallation.NewAppsTransport(tr, botConfig.AppID, appKey)
 	if err != nil {
 		s.Errorf("failed to make github apps transport: %s", err)
@@ -243,14 +352,61 @@ func (s *BotServer) Go() (err error) {
 		return err
 	}
 	stats = stats.SetPrefix(s.Name())
-	handler := githubbot.NewHandler(stats, s.kbc, debugConfig, db, config, atr, s.opts.HTTPPrefix, botConfig.AppName)
-	httpSrv := githubbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, handler, config, atr, botConfig.WebhookSecret)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	pager := base.NewPager(stats, debugConfig)
+	s.RegisterPager(pager)
+	identities := base.NewIdentityStore(debugConfig, db.DB)
+	settings := base.NewSettingsStore(db.DB)
+	handler := githubbot.NewHandler(stats, s.kbc, debugConfig, db, pager, identities, settings, config, atr,
+		s.opts.HTTPPrefix, botConfig.AppName)
+	queue := base.NewJobQueue(stats, debugConfig, db.DB, s.Name())
+	s.RegisterAdminCommands(queue.AdminCommands()...)
+	broadcaster := base.NewBroadcaster(stats, debugConfig, db.DB, db.GetAllSubscribedConvs)
+	s.RegisterAdminCommands(broadcaster.AdminCommands()...)
+	convGC := base.NewConvGC(stats, debugConfig)
+	convGC.AddHook("subscriptions", db.DeleteConvData)
+	convGC.AddHook("settings", settings.DeleteAll)
+	s.RegisterAdminCommands(convGC.AdminCommands()...)
+	analytics := base.NewAnalytics(stats, debugConfig, db.DB)
+	s.RegisterAnalytics(analytics)
+	auditLog := base.NewAuditLog(stats, debugConfig, db.DB)
+	s.RegisterAuditLog(auditLog)
+	s.RegisterAdminCommands(auditLog.AdminCommands()...)
+	leader := base.NewLeaderElector(stats, debugConfig, db.DB, s.Name())
+	s.RegisterAdminCommands(leader.AdminCommands()...)
+	scheduler := base.NewScheduler(stats, debugConfig)
+	scheduler.SetLeaderElector(leader)
+	stalePRs := githubbot.NewStalePRScheduler(stats, s.kbc, debugConfig, db, atr, queue, identities)
+	digests := githubbot.NewDigestScheduler(stats, debugConfig, db, queue)
+	for _, task := range []base.Task{analytics.Task(), auditLog.Task(), stalePRs.Task(), digests.Task()} {
+		if err := scheduler.Add(task); err != nil {
+			return err
+		}
+	}
+	s.RegisterAdminCommands(scheduler.AdminCommands()...)
+	httpSrv := githubbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, handler, config, atr, queue, identities,
+		analytics, botConfig.WebhookSecret)
+	httpSrv.SetCIOptions(s.opts.SummarizeChecks, s.opts.ThreadCI)
+	httpSrv.SetThreadUpdates(s.opts.ThreadUpdates)
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
+	httpSrv.AddReadinessCheck("github", base.HTTPHealthCheck("https://api.github.com"))
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(queue.Run, queue)
+	lc.AddShutdowner(broadcaster)
+	lc.Go(convGC.Run, convGC)
+	lc.Go(scheduler.Run, scheduler)
+	// registered after the scheduler so the lease is released once it stops
+	lc.Go(leader.Run, leader)
+	lc.Go(analytics.Run, analytics)
+	lc.Go(auditLog.Run, auditLog)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -272,6 +428,12 @@ func mainInner() int {
 	fs.StringVar(&opts.PrivateKeyPath, "private-key-path", "", "Path to GitHub app private key file")
 	fs.StringVar(&opts.AppName, "app-name", "", "Github App name")
 	fs.Int64Var(&opts.AppID, "app-id", -1, "GitHub App ID")
+	fs.BoolVar(&opts.SummarizeChecks, "summarize-checks", os.Getenv("BOT_SUMMARIZE_CHECKS") != "",
+		"Post one summary per failed check suite instead of a message per failed check run")
+	fs.BoolVar(&opts.ThreadCI, "thread-ci", os.Getenv("BOT_THREAD_CI") != "",
+		"Reply with CI results to the push or pull request message they're for")
+	fs.BoolVar(&opts.ThreadUpdates, "thread-updates", os.Getenv("BOT_THREAD_UPDATES") != "",
+		"Reply with pull request and issue updates, including reviews, to the message that announced them")
 	if err := opts.Parse(fs, os.Args); err != nil {
 		fmt.Printf("Unable to parse options: %v\n", err)
 		return 3
@@ -2,17 +2,16 @@
 package main
 
 import (
-	"database/sql"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"os"
 
 	"github.com/bradleyfalzon/ghinstallation"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/google/go-github/v31/github"
 
+	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/githubbot/githubbot"
 )
 
@@ -58,7 +57,7 @@ func mainInner() int {
 	if err != nil {
 		fmt.Printf("failed to get private key: %s", err)
 	}
-	sdb, err := sql.Open("mysql", dsn)
+	sdb, err := base.OpenMySQLDB(dsn)
 	if err != nil {
 		fmt.Printf("failed to connect to MySQL: %s", err)
 		return 1
@@ -66,7 +65,7 @@ func mainInner() int {
 	defer sdb.Close()
 	db := githubbot.NewDB(sdb)
 
-	tr := http.DefaultTransport
+	tr := base.NewHTTPTransport()
 	atr, err := ghinstallation.NewAppsTransport(tr, appID, appKey)
 	if err != nil {
 		fmt.Printf("failed to make github apps transport: %s", err)
@@ -82,7 +81,7 @@ func mainInner() int {
 	fmt.Printf("Found %d subscriptions to migrate\n", len(subs))
 	for i, subscription := range subs {
 		itr := ghinstallation.NewFromAppsTransport(atr, subscription.InstallationID)
-		client := github.NewClient(&http.Client{Transport: itr})
+		client := github.NewClient(base.NewHTTPClientWithTransport(itr))
 
 		defaultBranch, err := githubbot.GetDefaultBranch(subscription.Repo, client)
 		if err != nil {
@@ -1,6 +1,138 @@
 CREATE TABLE `subscriptions` (
   `conv_id` char(64) NOT NULL,
+  `instance_url` varchar(255) NOT NULL DEFAULT 'https://gitlab.com',
   `repo` varchar(128) NOT NULL,
+  `api_token` varchar(255) NOT NULL DEFAULT '',
+  `events` varchar(255) NOT NULL DEFAULT '',
+  `labels` varchar(1024) NOT NULL DEFAULT '',
+  `is_group` boolean NOT NULL DEFAULT FALSE,
+  `excludes` varchar(2048) NOT NULL DEFAULT '',
   `oauth_identifier` varchar(128) NOT NULL,
-  UNIQUE KEY unique_subscription (`conv_id`, `repo`)
+  UNIQUE KEY unique_subscription (`conv_id`, `instance_url`, `repo`),
+  KEY `instance_repo` (`instance_url`, `repo`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `pipeline_filters` (
+  `conv_id` char(64) NOT NULL,
+  `instance_url` varchar(255) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `branches` varchar(1024) NOT NULL,
+  `statuses` varchar(128) NOT NULL,
+  PRIMARY KEY (`conv_id`, `instance_url`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `pipeline_triggers` (
+  `conv_id` char(64) NOT NULL,
+  `instance_url` varchar(255) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `token` varchar(255) NOT NULL,
+  PRIMARY KEY (`conv_id`, `instance_url`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `pipeline_runs` (
+  `instance_url` varchar(255) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `pipeline_id` bigint NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `msg_id` bigint unsigned NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`instance_url`, `repo`, `pipeline_id`),
+  KEY `conv_id` (`conv_id`),
+  KEY `ctime` (`ctime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `deployment_filters` (
+  `conv_id` char(64) NOT NULL,
+  `instance_url` varchar(255) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `environments` varchar(1024) NOT NULL,
+  `statuses` varchar(128) NOT NULL,
+  PRIMARY KEY (`conv_id`, `instance_url`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `board_lists` (
+  `conv_id` char(64) NOT NULL,
+  `instance_url` varchar(255) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `lists` varchar(1024) NOT NULL,
+  PRIMARY KEY (`conv_id`, `instance_url`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `user_tokens` (
+  `username` varchar(128) NOT NULL,
+  `instance_url` varchar(255) NOT NULL,
+  `token` varchar(255) NOT NULL,
+  `gitlab_username` varchar(255) NOT NULL DEFAULT '',
+  `mentions` boolean NOT NULL DEFAULT FALSE,
+  `mtime` datetime NOT NULL,
+  PRIMARY KEY (`username`, `instance_url`),
+  KEY `gitlab_user` (`instance_url`, `gitlab_username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `review_reminders` (
+  `conv_id` char(64) NOT NULL,
+  `instance_url` varchar(255) NOT NULL,
+  `repo` varchar(128) NOT NULL,
+  `threshold_hours` int NOT NULL,
+  `dm` boolean NOT NULL,
+  PRIMARY KEY (`conv_id`, `instance_url`, `repo`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `broadcasts` (
+  `id` varchar(32) NOT NULL,
+  `message` text NOT NULL,
+  `created_by` varchar(128) NOT NULL,
+  `ctime` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `broadcast_deliveries` (
+  `broadcast_id` varchar(32) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`broadcast_id`, `conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_events` (
+  `day` char(10) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `name` varchar(64) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `count` int NOT NULL,
+  PRIMARY KEY (`day`, `kind`, `name`, `conv_id`, `username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `usage_daily` (
+  `day` char(10) NOT NULL,
+  `metric` varchar(96) NOT NULL,
+  `value` bigint NOT NULL,
+  PRIMARY KEY (`day`, `metric`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `leases` (
+  `name` varchar(64) NOT NULL,
+  `holder` varchar(32) NOT NULL,
+  `expire_time` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `audit_log` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `ctime` datetime(6) NOT NULL,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `command` varchar(64) NOT NULL,
+  `args` text NOT NULL,
+  `result` varchar(16) NOT NULL,
+  `error` text NOT NULL,
+  `duration_ms` bigint NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ctime` (`ctime`),
+  KEY `username` (`username`),
+  KEY `conv_id` (`conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
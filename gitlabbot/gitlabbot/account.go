@@ -0,0 +1,100 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// commandArgs tokenizes the original message, the command itself is
+// lowercased, and returns what follows the first n tokens.
+func (h *Handler) commandArgs(msg chat1.MsgSummary, n int) (args []string, ok bool, err error) {
+	toks, userErr, err := base.SplitTokens(strings.TrimSpace(msg.Content.Text.Body))
+	if err != nil {
+		return nil, false, err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil, false, nil
+	}
+	if len(toks) < n {
+		return nil, true, nil
+	}
+	return toks[n:], true, nil
+}
+
+// userClient returns a client acting as the sender on instanceURL with the
+// personal access token they linked, nil after telling them to link one.
+func (h *Handler) userClient(msg chat1.MsgSummary, instanceURL string) (*gitlab.Client, error) {
+	token, err := h.db.GetUserToken(msg.Sender.Username, instanceURL)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user token: %s", err)
+	}
+	if token == "" {
+		instance := ""
+		if instanceURL != defaultInstanceURL {
+			instance = instanceURL + " "
+		}
+		h.ChatEcho(msg.ConvID, "@%s, I need to act as you on %s for that. Create a personal access token with the `api` scope and send me `!gitlab token %s<token>` in a private message.",
+			msg.Sender.Username, instanceURL, instance)
+		return nil, nil
+	}
+	return newClient(instanceURL, token)
+}
+
+// handleToken links the personal access token of the sender on an instance,
+// as `!gitlab token [instance url] <token>`, or unlinks it with `off`.
+func (h *Handler) handleToken(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) < 1 || len(args) > 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab token [https://gitlab.example.com] <personal access token>`, or `off` instead of the token to forget it")
+		return nil
+	}
+	instanceURL := defaultInstanceURL
+	if len(args) == 2 {
+		instanceURL = instanceURLFromWebURL(args[0])
+		if instanceURL == defaultInstanceURL && !strings.EqualFold(strings.TrimSuffix(args[0], "/"), defaultInstanceURL) {
+			h.ChatEcho(msg.ConvID, "`%s` doesn't look like a GitLab instance to me, expected something like `https://gitlab.example.com`", args[0])
+			return nil
+		}
+	}
+	token := args[len(args)-1]
+
+	if strings.ToLower(token) == "off" {
+		if err := h.db.DeleteUserToken(msg.Sender.Username, instanceURL); err != nil {
+			return fmt.Errorf("error deleting user token: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I forgot your token for %s.", instanceURL)
+		return nil
+	}
+	if !base.IsDirectPrivateMessage(h.kbc.GetUsername(), msg.Sender.Username, msg.Channel) {
+		h.ChatEcho(msg.ConvID, "Tokens are secrets! Send it to me in a private message instead, and revoke this one since everyone here can read it.")
+		return nil
+	}
+
+	client, err := newClient(instanceURL, token)
+	if err != nil {
+		return fmt.Errorf("error making client: %s", err)
+	}
+	user, res, err := client.Users.CurrentUser()
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusUnauthorized {
+			h.ChatEcho(msg.ConvID, "%s rejected that token, make sure it's valid and has the `api` scope.", instanceURL)
+			return nil
+		}
+		h.ChatEcho(msg.ConvID, "I couldn't check that token against %s: %s", instanceURL, err)
+		return nil
+	}
+	if err := h.db.PutUserToken(msg.Sender.Username, instanceURL, token, user.Username); err != nil {
+		return fmt.Errorf("error storing user token: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll act as *%s* on %s when you ask me to.", user.Username, instanceURL)
+	return nil
+}
@@ -0,0 +1,129 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+)
+
+// watchedLabel returns the first of labels which is one of lists, ignoring
+// case.
+func watchedLabel(labels []gitlab.Label, lists []string) string {
+	for _, label := range labels {
+		for _, list := range lists {
+			if strings.EqualFold(label.Name, list) {
+				return label.Name
+			}
+		}
+	}
+	return ""
+}
+
+// formatBoardMsg announces an issue moving into one of lists. Board lists
+// are labels, so a move shows up as a label change of the issue.
+func formatBoardMsg(evt *gitlab.IssueEvent, lists []string) string {
+	if evt.ObjectAttributes.Action != "update" || len(lists) == 0 {
+		return ""
+	}
+	previous, current := evt.Changes.Labels.Previous, evt.Changes.Labels.Current
+	var added, removed []gitlab.Label
+	for _, label := range current {
+		if !hasLabel(previous, label.Name) {
+			added = append(added, label)
+		}
+	}
+	for _, label := range previous {
+		if !hasLabel(current, label.Name) {
+			removed = append(removed, label)
+		}
+	}
+	to := watchedLabel(added, lists)
+	if to == "" {
+		return ""
+	}
+	res := fmt.Sprintf(":arrow_right: %s moved issue #%d on %s ", evt.User.Username, evt.ObjectAttributes.IID,
+		evt.Project.PathWithNamespace)
+	if from := watchedLabel(removed, lists); from != "" {
+		res += fmt.Sprintf("from *%s* ", from)
+	}
+	return res + fmt.Sprintf("to *%s*: “%s”\n%s", to, evt.ObjectAttributes.Title, evt.ObjectAttributes.URL)
+}
+
+func hasLabel(labels []gitlab.Label, name string) bool {
+	for _, label := range labels {
+		if strings.EqualFold(label.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// boardMessage announces issue moves between the board lists a subscription
+// watches.
+func (h *HTTPSrv) boardMessage(evt *gitlab.IssueEvent, sub Subscription) string {
+	lists, err := h.db.GetBoardLists(sub.ConvID, sub.InstanceURL, sub.Repo)
+	if err != nil {
+		h.Errorf("Error getting board lists: %s", err)
+		return ""
+	}
+	return formatBoardMsg(evt, lists)
+}
+
+// handleBoard shows or sets the board lists of a project whose issues are
+// announced as they move into them, as `!gitlab board <project> [--lists
+// Doing,Review]` or `!gitlab board <project> off`.
+func (h *Handler) handleBoard(msg chat1.MsgSummary) error {
+	// labels keep their case
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	usage := "I don't understand! Try `!gitlab board <project> --lists Doing,Review`, or `!gitlab board <project> off`"
+	args, values, ok := parseListFlags(args, "--lists")
+	if !ok || len(args) < 1 || len(args) > 2 || (len(args) == 2 && strings.ToLower(args[1]) != "off") {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	lists := values["--lists"]
+	off := len(args) == 2
+	if off && len(lists) > 0 {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, strings.ToLower(args[0]))
+	if err != nil || sub == nil {
+		return err
+	}
+
+	if !off && len(lists) == 0 {
+		current, err := h.db.GetBoardLists(msg.ConvID, sub.InstanceURL, sub.Repo)
+		if err != nil {
+			return fmt.Errorf("error getting board lists: %s", err)
+		}
+		if len(current) == 0 {
+			h.ChatEcho(msg.ConvID, "I don't announce board moves of `%s` here. %s", sub, usage)
+			return nil
+		}
+		h.ChatEcho(msg.ConvID, "I announce issues of `%s` moving into %s here.", sub, formatFilterList(current, ""))
+		return nil
+	}
+
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	if off {
+		if err := h.db.DeleteBoardLists(msg.ConvID, sub.InstanceURL, sub.Repo); err != nil {
+			return fmt.Errorf("error deleting board lists: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I won't announce board moves of `%s` here.", sub)
+		return nil
+	}
+	if err := h.db.SetBoardLists(msg.ConvID, sub.InstanceURL, sub.Repo, lists); err != nil {
+		return fmt.Errorf("error setting board lists: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll announce issues of `%s` moving into %s here. Make sure the webhook has the Issues events trigger checked.",
+		sub, formatFilterList(lists, ""))
+	return nil
+}
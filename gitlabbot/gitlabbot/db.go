@@ -2,6 +2,8 @@ package gitlabbot
 
 import (
 	"database/sql"
+	"strings"
+	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 
@@ -13,54 +15,190 @@ type DB struct {
 	*base.DB
 }
 
-func NewDB(db *sql.DB) *DB {
+func NewDB(db *base.DB) *DB {
 	return &DB{
-		DB: base.NewDB(db),
+		DB: db,
 	}
 }
 
 // webhook subscription methods
 
-func (d *DB) CreateSubscription(convID chat1.ConvIDStr, repo string, oauthIdentifier string) error {
+func (d *DB) CreateSubscription(sub Subscription, oauthIdentifier string) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
 			INSERT INTO subscriptions
-			(conv_id, repo, oauth_identifier)
-			VALUES (?, ?, ?)
+			(conv_id, instance_url, repo, api_token, events, labels, is_group, excludes, oauth_identifier)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON DUPLICATE KEY UPDATE
 			oauth_identifier=VALUES(oauth_identifier)
-		`, convID, repo, oauthIdentifier)
+		`, sub.ConvID, sub.InstanceURL, sub.Repo, sub.APIToken, strings.Join(sub.Events, ","),
+			strings.Join(sub.Labels, ","), sub.Group, strings.Join(sub.Excludes, ","), oauthIdentifier)
 		return err
 	})
 }
 
-func (d *DB) DeleteSubscription(convID chat1.ConvIDStr, repo string) error {
+// SetSubscriptionFilters replaces the event types and labels a subscription
+// is limited to.
+func (d *DB) SetSubscriptionFilters(sub Subscription) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
-			DELETE FROM subscriptions
-			WHERE (conv_id = ? AND repo = ?)
-		`, convID, repo)
+			UPDATE subscriptions
+			SET events = ?, labels = ?
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, strings.Join(sub.Events, ","), strings.Join(sub.Labels, ","), sub.ConvID, sub.InstanceURL, sub.Repo)
 		return err
 	})
 }
 
-func (d *DB) DeleteSubscriptionsForRepo(convID chat1.ConvIDStr, repo string) error {
+// SetSubscriptionExcludes replaces the projects a group subscription skips.
+func (d *DB) SetSubscriptionExcludes(sub Subscription) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE subscriptions
+			SET excludes = ?
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ? AND is_group)
+		`, strings.Join(sub.Excludes, ","), sub.ConvID, sub.InstanceURL, sub.Repo)
+		return err
+	})
+}
+
+func splitColumn(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// SetSubscriptionToken replaces the access token of a subscription.
+func (d *DB) SetSubscriptionToken(convID chat1.ConvIDStr, instanceURL, repo, apiToken string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE subscriptions
+			SET api_token = ?
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, apiToken, convID, instanceURL, repo)
+		return err
+	})
+}
+
+func (d *DB) DeleteSubscription(convID chat1.ConvIDStr, instanceURL, repo string) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
 		_, err := tx.Exec(`
 			DELETE FROM subscriptions
-			WHERE (conv_id = ? AND repo = ?)
-		`, convID, repo)
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo)
 		return err
 	})
 }
 
-func (d *DB) GetSubscribedConvs(repo string) (res []chat1.ConvIDStr, err error) {
+func (d *DB) DeleteSubscriptionsForRepo(convID chat1.ConvIDStr, instanceURL, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		for _, table := range []string{"subscriptions", "pipeline_filters", "pipeline_triggers", "pipeline_runs",
+			"deployment_filters", "board_lists", "review_reminders"} {
+			if _, err := tx.Exec(`
+			DELETE FROM `+table+`
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteConvData removes every subscription of a conversation, for when the
+// bot is removed from it.
+func (d *DB) DeleteConvData(convID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		for _, table := range []string{"subscriptions", "pipeline_filters", "pipeline_triggers", "pipeline_runs",
+			"deployment_filters", "board_lists", "review_reminders"} {
+			if _, err := tx.Exec(`
+			DELETE FROM `+table+`
+			WHERE conv_id = ?
+		`, convID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetSubscriptionsForRepo returns every subscription to a project, along with
+// the subscriptions to the groups it is in. Excluded projects are left to the
+// caller.
+func (d *DB) GetSubscriptionsForRepo(instanceURL, repo string) (res []Subscription, err error) {
+	args := []interface{}{instanceURL, repo}
+	groupClause := "FALSE"
+	if groups := parentGroups(repo); len(groups) > 0 {
+		groupClause = "(is_group AND repo IN (?" + strings.Repeat(", ?", len(groups)-1) + "))"
+		for _, group := range groups {
+			args = append(args, group)
+		}
+	}
+	rows, err := d.DB.Query(`
+		SELECT conv_id, repo, api_token, events, labels, is_group, excludes
+		FROM subscriptions
+		WHERE instance_url = ?
+		AND ((repo = ? AND NOT is_group) OR `+groupClause+`)
+	`, args...)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sub := Subscription{InstanceURL: instanceURL}
+		var events, labels, excludes string
+		if err := rows.Scan(&sub.ConvID, &sub.Repo, &sub.APIToken, &events, &labels, &sub.Group, &excludes); err != nil {
+			return res, err
+		}
+		sub.Events, sub.Labels, sub.Excludes = splitColumn(events), splitColumn(labels), splitColumn(excludes)
+		res = append(res, sub)
+	}
+	return res, nil
+}
+
+// parentGroups lists the groups a project path is nested in, from the
+// outermost.
+func parentGroups(repo string) (res []string) {
+	parts := strings.Split(repo, "/")
+	for i := 1; i < len(parts); i++ {
+		res = append(res, strings.Join(parts[:i], "/"))
+	}
+	return res
+}
+
+// GetGroupSubscriptions returns every group subscription with an access
+// token, which the bot keeps the project webhooks of.
+func (d *DB) GetGroupSubscriptions() (res []Subscription, err error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, instance_url, repo, api_token, excludes
+		FROM subscriptions
+		WHERE (is_group AND api_token != '')
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sub := Subscription{Group: true}
+		var excludes string
+		if err := rows.Scan(&sub.ConvID, &sub.InstanceURL, &sub.Repo, &sub.APIToken, &excludes); err != nil {
+			return res, err
+		}
+		sub.Excludes = splitColumn(excludes)
+		res = append(res, sub)
+	}
+	return res, rows.Err()
+}
+
+// GetAllSubscribedConvs returns every conversation with a subscription, for
+// broadcasts.
+func (d *DB) GetAllSubscribedConvs() (res []chat1.ConvIDStr, err error) {
 	rows, err := d.DB.Query(`
 		SELECT conv_id
 		FROM subscriptions
-		WHERE repo = ?
 		GROUP BY conv_id
-	`, repo)
+	`)
 	if err != nil {
 		return res, err
 	}
@@ -75,13 +213,13 @@ func (d *DB) GetSubscribedConvs(repo string) (res []chat1.ConvIDStr, err error)
 	return res, nil
 }
 
-func (d *DB) GetSubscriptionExists(convID chat1.ConvIDStr, repo string) (exists bool, err error) {
+func (d *DB) GetSubscriptionExists(convID chat1.ConvIDStr, instanceURL, repo string) (exists bool, err error) {
 	row := d.DB.QueryRow(`
 	SELECT 1
 	FROM subscriptions
-	WHERE (conv_id = ? AND repo = ?)
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
 	GROUP BY conv_id
-	`, convID, repo)
+	`, convID, instanceURL, repo)
 	var rowRes string
 	scanErr := row.Scan(&rowRes)
 	switch scanErr {
@@ -94,12 +232,12 @@ func (d *DB) GetSubscriptionExists(convID chat1.ConvIDStr, repo string) (exists
 	}
 }
 
-func (d *DB) GetSubscriptionForRepoExists(convID chat1.ConvIDStr, repo string) (exists bool, err error) {
+func (d *DB) GetSubscriptionForRepoExists(convID chat1.ConvIDStr, instanceURL, repo string) (exists bool, err error) {
 	row := d.DB.QueryRow(`
 	SELECT 1
 	FROM subscriptions
-	WHERE (conv_id = ? AND repo = ?)
-	`, convID, repo)
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+	`, convID, instanceURL, repo)
 	var rowRes string
 	err = row.Scan(&rowRes)
 	switch err {
@@ -112,27 +250,469 @@ func (d *DB) GetSubscriptionForRepoExists(convID chat1.ConvIDStr, repo string) (
 	}
 }
 
-func (d *DB) GetAllSubscriptionsForConvID(convID chat1.ConvIDStr) (res []string, err error) {
+// GetSubscription returns the subscription of a conversation to a project or
+// group, nil if there is none.
+func (d *DB) GetSubscription(convID chat1.ConvIDStr, instanceURL, repo string) (*Subscription, error) {
+	sub := Subscription{ConvID: convID, InstanceURL: instanceURL, Repo: repo}
+	row := d.DB.QueryRow(`
+	SELECT api_token, events, labels, is_group, excludes
+	FROM subscriptions
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+	`, convID, instanceURL, repo)
+	var events, labels, excludes string
+	err := row.Scan(&sub.APIToken, &events, &labels, &sub.Group, &excludes)
+	switch err {
+	case nil:
+		sub.Events, sub.Labels, sub.Excludes = splitColumn(events), splitColumn(labels), splitColumn(excludes)
+		return &sub, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (d *DB) GetAllSubscriptionsForConvID(convID chat1.ConvIDStr) (res []Subscription, err error) {
 	rows, err := d.DB.Query(`
-		SELECT repo
+		SELECT instance_url, repo, api_token, events, labels, is_group, excludes
 		FROM subscriptions
 		WHERE conv_id = ?
-		ORDER BY repo
+		ORDER BY instance_url, repo
 	`, convID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var repo string
-		if err := rows.Scan(&repo); err != nil {
+		sub := Subscription{ConvID: convID}
+		var events, labels, excludes string
+		if err := rows.Scan(&sub.InstanceURL, &sub.Repo, &sub.APIToken, &events, &labels, &sub.Group, &excludes); err != nil {
 			return res, err
 		}
-		res = append(res, repo)
+		sub.Events, sub.Labels, sub.Excludes = splitColumn(events), splitColumn(labels), splitColumn(excludes)
+		res = append(res, sub)
 	}
 	return res, nil
 }
 
+// pipeline filter methods
+
+func (d *DB) SetPipelineFilter(convID chat1.ConvIDStr, instanceURL, repo string, filter PipelineFilter) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO pipeline_filters
+			(conv_id, instance_url, repo, branches, statuses)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			branches=VALUES(branches),
+			statuses=VALUES(statuses)
+		`, convID, instanceURL, repo, strings.Join(filter.Branches, ","), strings.Join(filter.Statuses, ","))
+		return err
+	})
+}
+
+func (d *DB) DeletePipelineFilter(convID chat1.ConvIDStr, instanceURL, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM pipeline_filters
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo)
+		return err
+	})
+}
+
+// GetPipelineFilter returns the pipeline filter of a subscription, nil if it
+// has none.
+func (d *DB) GetPipelineFilter(convID chat1.ConvIDStr, instanceURL, repo string) (*PipelineFilter, error) {
+	row := d.DB.QueryRow(`
+	SELECT branches, statuses
+	FROM pipeline_filters
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+	`, convID, instanceURL, repo)
+	var branches, statuses string
+	err := row.Scan(&branches, &statuses)
+	switch err {
+	case nil:
+		return &PipelineFilter{Branches: splitColumn(branches), Statuses: splitColumn(statuses)}, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// pipeline trigger methods
+
+func (d *DB) SetPipelineTrigger(convID chat1.ConvIDStr, instanceURL, repo, token string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO pipeline_triggers
+			(conv_id, instance_url, repo, token)
+			VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			token=VALUES(token)
+		`, convID, instanceURL, repo, token)
+		return err
+	})
+}
+
+func (d *DB) DeletePipelineTrigger(convID chat1.ConvIDStr, instanceURL, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM pipeline_triggers
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo)
+		return err
+	})
+}
+
+// GetPipelineTrigger returns the trigger token of a subscription, empty if
+// it has none.
+func (d *DB) GetPipelineTrigger(convID chat1.ConvIDStr, instanceURL, repo string) (token string, err error) {
+	row := d.DB.QueryRow(`
+	SELECT token
+	FROM pipeline_triggers
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+	`, convID, instanceURL, repo)
+	err = row.Scan(&token)
+	switch err {
+	case nil, sql.ErrNoRows:
+		return token, nil
+	default:
+		return "", err
+	}
+}
+
+// PipelineRun is a pipeline started from chat, which gets a reply to the
+// command once it finishes.
+type PipelineRun struct {
+	ConvID   chat1.ConvIDStr
+	MsgID    chat1.MessageID
+	Username string
+}
+
+// PutPipelineRun records a pipeline started from chat, runs which never
+// finished are dropped after a week.
+func (d *DB) PutPipelineRun(instanceURL, repo string, pipelineID int, run PipelineRun) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM pipeline_runs
+			WHERE ctime < NOW() - INTERVAL 7 DAY
+		`); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO pipeline_runs
+			(conv_id, instance_url, repo, pipeline_id, msg_id, username, ctime)
+			VALUES (?, ?, ?, ?, ?, ?, NOW())
+			ON DUPLICATE KEY UPDATE
+			conv_id=VALUES(conv_id),
+			msg_id=VALUES(msg_id),
+			username=VALUES(username)
+		`, run.ConvID, instanceURL, repo, pipelineID, run.MsgID, run.Username)
+		return err
+	})
+}
+
+// GetPipelineRun returns who started a pipeline from chat, nil if it wasn't.
+func (d *DB) GetPipelineRun(instanceURL, repo string, pipelineID int) (*PipelineRun, error) {
+	var run PipelineRun
+	row := d.DB.QueryRow(`
+	SELECT conv_id, msg_id, username
+	FROM pipeline_runs
+	WHERE (instance_url = ? AND repo = ? AND pipeline_id = ?)
+	`, instanceURL, repo, pipelineID)
+	err := row.Scan(&run.ConvID, &run.MsgID, &run.Username)
+	switch err {
+	case nil:
+		return &run, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+func (d *DB) DeletePipelineRun(instanceURL, repo string, pipelineID int) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM pipeline_runs
+			WHERE (instance_url = ? AND repo = ? AND pipeline_id = ?)
+		`, instanceURL, repo, pipelineID)
+		return err
+	})
+}
+
+// deployment filter methods
+
+func (d *DB) SetDeploymentFilter(convID chat1.ConvIDStr, instanceURL, repo string, filter DeploymentFilter) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO deployment_filters
+			(conv_id, instance_url, repo, environments, statuses)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			environments=VALUES(environments),
+			statuses=VALUES(statuses)
+		`, convID, instanceURL, repo, strings.Join(filter.Environments, ","), strings.Join(filter.Statuses, ","))
+		return err
+	})
+}
+
+func (d *DB) DeleteDeploymentFilter(convID chat1.ConvIDStr, instanceURL, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM deployment_filters
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo)
+		return err
+	})
+}
+
+// GetDeploymentFilter returns the deployment filter of a subscription, nil if
+// it has none.
+func (d *DB) GetDeploymentFilter(convID chat1.ConvIDStr, instanceURL, repo string) (*DeploymentFilter, error) {
+	row := d.DB.QueryRow(`
+	SELECT environments, statuses
+	FROM deployment_filters
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+	`, convID, instanceURL, repo)
+	var environments, statuses string
+	err := row.Scan(&environments, &statuses)
+	switch err {
+	case nil:
+		return &DeploymentFilter{Environments: splitColumn(environments), Statuses: splitColumn(statuses)}, nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// board list methods
+
+// SetBoardLists replaces the board lists whose issues are announced when they
+// move into them.
+func (d *DB) SetBoardLists(convID chat1.ConvIDStr, instanceURL, repo string, lists []string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO board_lists
+			(conv_id, instance_url, repo, lists)
+			VALUES (?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			lists=VALUES(lists)
+		`, convID, instanceURL, repo, strings.Join(lists, ","))
+		return err
+	})
+}
+
+func (d *DB) DeleteBoardLists(convID chat1.ConvIDStr, instanceURL, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM board_lists
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo)
+		return err
+	})
+}
+
+// GetBoardLists returns the board lists a subscription watches, none if it
+// doesn't.
+func (d *DB) GetBoardLists(convID chat1.ConvIDStr, instanceURL, repo string) ([]string, error) {
+	row := d.DB.QueryRow(`
+	SELECT lists
+	FROM board_lists
+	WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+	`, convID, instanceURL, repo)
+	var lists string
+	err := row.Scan(&lists)
+	switch err {
+	case nil:
+		return splitColumn(lists), nil
+	case sql.ErrNoRows:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// personal access token methods
+
+// PutUserToken links a personal access token of a Keybase user, along with
+// the GitLab user it belongs to.
+func (d *DB) PutUserToken(username, instanceURL, token, gitlabUsername string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO user_tokens
+			(username, instance_url, token, gitlab_username, mtime)
+			VALUES (?, ?, ?, ?, NOW())
+			ON DUPLICATE KEY UPDATE
+			token=VALUES(token),
+			gitlab_username=VALUES(gitlab_username),
+			mtime=VALUES(mtime)
+		`, username, instanceURL, token, gitlabUsername)
+		return err
+	})
+}
+
+// GetUserToken returns the personal access token a user linked for an
+// instance, empty if there is none.
+func (d *DB) GetUserToken(username, instanceURL string) (token string, err error) {
+	row := d.DB.QueryRow(`
+	SELECT token
+	FROM user_tokens
+	WHERE (username = ? AND instance_url = ?)
+	`, username, instanceURL)
+	err = row.Scan(&token)
+	switch err {
+	case nil, sql.ErrNoRows:
+		return token, nil
+	default:
+		return "", err
+	}
+}
+
+func (d *DB) DeleteUserToken(username, instanceURL string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM user_tokens
+			WHERE (username = ? AND instance_url = ?)
+		`, username, instanceURL)
+		return err
+	})
+}
+
+// GetKeybaseUsername returns the Keybase user who linked a token of a GitLab
+// user, empty if nobody did.
+func (d *DB) GetKeybaseUsername(instanceURL, gitlabUsername string) (username string, err error) {
+	row := d.DB.QueryRow(`
+	SELECT username
+	FROM user_tokens
+	WHERE (instance_url = ? AND gitlab_username = ?)
+	ORDER BY mtime DESC
+	LIMIT 1
+	`, instanceURL, gitlabUsername)
+	err = row.Scan(&username)
+	switch err {
+	case nil, sql.ErrNoRows:
+		return username, nil
+	default:
+		return "", err
+	}
+}
+
+// GetUserTokenInstances returns the instances a user linked a token for.
+func (d *DB) GetUserTokenInstances(username string) (res []string, err error) {
+	rows, err := d.DB.Query(`
+	SELECT instance_url
+	FROM user_tokens
+	WHERE username = ?
+	ORDER BY instance_url
+	`, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var instanceURL string
+		if err := rows.Scan(&instanceURL); err != nil {
+			return nil, err
+		}
+		res = append(res, instanceURL)
+	}
+	return res, rows.Err()
+}
+
+// SetMentions turns the forwarding of GitLab mentions on or off for every
+// token a user linked.
+func (d *DB) SetMentions(username string, enabled bool) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE user_tokens
+			SET mentions = ?
+			WHERE username = ?
+		`, enabled, username)
+		return err
+	})
+}
+
+// GetMentionRecipient returns the Keybase user, and their token, who asked
+// for mentions of a GitLab user to be forwarded, empty if nobody did.
+func (d *DB) GetMentionRecipient(instanceURL, gitlabUsername string) (username, token string, err error) {
+	row := d.DB.QueryRow(`
+	SELECT username, token
+	FROM user_tokens
+	WHERE (instance_url = ? AND gitlab_username = ? AND mentions)
+	ORDER BY mtime DESC
+	LIMIT 1
+	`, instanceURL, gitlabUsername)
+	err = row.Scan(&username, &token)
+	switch err {
+	case nil, sql.ErrNoRows:
+		return username, token, nil
+	default:
+		return "", "", err
+	}
+}
+
+// review reminder methods
+
+type ReviewReminder struct {
+	Subscription
+	Threshold time.Duration
+	// DM sends reminders to linked reviewers instead of the conversation
+	DM bool
+}
+
+func (d *DB) SetReviewReminder(convID chat1.ConvIDStr, instanceURL, repo string, threshold time.Duration, dm bool) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO review_reminders
+			(conv_id, instance_url, repo, threshold_hours, dm)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			threshold_hours=VALUES(threshold_hours),
+			dm=VALUES(dm)
+		`, convID, instanceURL, repo, int(threshold/time.Hour), dm)
+		return err
+	})
+}
+
+func (d *DB) DeleteReviewReminder(convID chat1.ConvIDStr, instanceURL, repo string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM review_reminders
+			WHERE (conv_id = ? AND instance_url = ? AND repo = ?)
+		`, convID, instanceURL, repo)
+		return err
+	})
+}
+
+// GetReviewReminders returns the reminder settings of every subscription
+// which has them.
+func (d *DB) GetReviewReminders() (res []ReviewReminder, err error) {
+	rows, err := d.DB.Query(`
+		SELECT s.conv_id, s.instance_url, s.repo, s.api_token, r.threshold_hours, r.dm
+		FROM review_reminders r
+		JOIN subscriptions s USING(conv_id, instance_url, repo)
+	`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reminder ReviewReminder
+		var hours int
+		if err := rows.Scan(&reminder.ConvID, &reminder.InstanceURL, &reminder.Repo, &reminder.APIToken,
+			&hours, &reminder.DM); err != nil {
+			return res, err
+		}
+		reminder.Threshold = time.Duration(hours) * time.Hour
+		res = append(res, reminder)
+	}
+	return res, rows.Err()
+}
+
 // OAuth2 token methods
 
 func (d *DB) GetToken(identifier string) (*oauth2.Token, error) {
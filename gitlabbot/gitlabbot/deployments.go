@@ -0,0 +1,273 @@
+package gitlabbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// go-gitlab doesn't know this event yet, so it's parsed here
+const deploymentHook gitlab.EventType = "Deployment Hook"
+
+const (
+	// max deployments `!gitlab deployments` lists
+	maxDeploymentsListed = 10
+)
+
+var (
+	deploymentStatuses = []string{"running", "success", "failed", "canceled"}
+	// announced without a filter, running deployments are opt in
+	defaultDeploymentStatuses = []string{"success", "failed", "canceled"}
+)
+
+type deploymentEvent struct {
+	ObjectKind    string `json:"object_kind"`
+	Status        string `json:"status"`
+	DeploymentID  int    `json:"deployment_id"`
+	DeployableURL string `json:"deployable_url"`
+	Environment   string `json:"environment"`
+	Ref           string `json:"ref"`
+	ShortSHA      string `json:"short_sha"`
+	CommitURL     string `json:"commit_url"`
+	CommitTitle   string `json:"commit_title"`
+	User          struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+}
+
+func parseDeploymentEvent(payload []byte) (*deploymentEvent, error) {
+	var evt deploymentEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// DeploymentFilter narrows the deployment messages of a subscription, empty
+// lists match every environment and the default statuses.
+type DeploymentFilter struct {
+	Environments []string
+	Statuses     []string
+}
+
+func (f DeploymentFilter) matches(evt *deploymentEvent) bool {
+	statuses := f.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultDeploymentStatuses
+	}
+	if !isOneOf(evt.Status, statuses) {
+		return false
+	}
+	if len(f.Environments) == 0 {
+		return true
+	}
+	environment := strings.ToLower(evt.Environment)
+	for _, pattern := range f.Environments {
+		if ok, err := path.Match(pattern, environment); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f DeploymentFilter) String() string {
+	environments, statuses := "any environment", "`"+strings.Join(defaultDeploymentStatuses, ", ")+"`"
+	if len(f.Environments) > 0 {
+		environments = "`" + strings.Join(f.Environments, ", ") + "`"
+	}
+	if len(f.Statuses) > 0 {
+		statuses = "`" + strings.Join(f.Statuses, ", ") + "`"
+	}
+	return fmt.Sprintf("environments: %s, statuses: %s", environments, statuses)
+}
+
+func formatDeploymentMsg(evt *deploymentEvent) string {
+	var icon, result string
+	switch evt.Status {
+	case "running":
+		icon, result = ":rocket:", "is deploying"
+	case "success":
+		icon, result = ":white_check_mark:", "deployed"
+	case "failed":
+		icon, result = ":x:", "failed to deploy"
+	case "canceled":
+		icon, result = ":warning:", "canceled deploying"
+	default:
+		return ""
+	}
+	what := evt.ShortSHA
+	if evt.Ref != "" {
+		what = fmt.Sprintf("%s@%s", evt.Ref, evt.ShortSHA)
+	}
+	res := fmt.Sprintf("%s %s %s `%s` of %s to *%s*", icon, evt.User.Username, result, what,
+		evt.Project.PathWithNamespace, evt.Environment)
+	if evt.CommitTitle != "" {
+		res += fmt.Sprintf(": “%s”", evt.CommitTitle)
+	}
+	return res + "\n" + evt.DeployableURL
+}
+
+// deploymentWanted applies the deployment filter of a subscription to an
+// event.
+func (h *HTTPSrv) deploymentWanted(evt *deploymentEvent, sub Subscription) bool {
+	filter, err := h.db.GetDeploymentFilter(sub.ConvID, sub.InstanceURL, sub.Repo)
+	if err != nil {
+		h.Errorf("Error getting deployment filter: %s", err)
+		return true
+	}
+	if filter == nil {
+		filter = &DeploymentFilter{}
+	}
+	return filter.matches(evt)
+}
+
+// handleDeploymentFilter shows or sets which deployments of a project are
+// announced, as `!gitlab deployments filter <project> [--environments ...]
+// [--status ...]` or `!gitlab deployments filter <project> off`.
+func (h *Handler) handleDeploymentFilter(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	usage := "I don't understand! Try `!gitlab deployments filter <project> --environments production --status success,failed`, or `!gitlab deployments filter <project> off`"
+	args, values, ok := parseListFlags(toks[3:], "--environments", "--status")
+	if !ok || len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "off") {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	filter := DeploymentFilter{Environments: values["--environments"]}
+	for _, status := range values["--status"] {
+		if status == "cancelled" {
+			status = "canceled"
+		}
+		if !isOneOf(status, deploymentStatuses) {
+			h.ChatEcho(msg.ConvID, "`%s` isn't a deployment status, use `%s`.", status, strings.Join(deploymentStatuses, "`, `"))
+			return nil
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	off := len(args) == 2
+	if off && (len(filter.Environments) > 0 || len(filter.Statuses) > 0) {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, args[0])
+	if err != nil || sub == nil {
+		return err
+	}
+
+	if !off && len(filter.Environments) == 0 && len(filter.Statuses) == 0 {
+		current, err := h.db.GetDeploymentFilter(msg.ConvID, sub.InstanceURL, sub.Repo)
+		if err != nil {
+			return fmt.Errorf("error getting deployment filter: %s", err)
+		}
+		if current == nil {
+			current = &DeploymentFilter{}
+		}
+		h.ChatEcho(msg.ConvID, "I announce deployments of `%s` here for %s.", sub, current)
+		return nil
+	}
+
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	if off {
+		if err := h.db.DeleteDeploymentFilter(msg.ConvID, sub.InstanceURL, sub.Repo); err != nil {
+			return fmt.Errorf("error deleting deployment filter: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I'll announce deployments of `%s` here for %s.", sub, DeploymentFilter{})
+		return nil
+	}
+	for _, environment := range filter.Environments {
+		if _, err := path.Match(environment, ""); err != nil {
+			h.ChatEcho(msg.ConvID, "`%s` isn't a valid environment pattern!", environment)
+			return nil
+		}
+	}
+	if err := h.db.SetDeploymentFilter(msg.ConvID, sub.InstanceURL, sub.Repo, filter); err != nil {
+		return fmt.Errorf("error setting deployment filter: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll only announce deployments of `%s` here for %s.", sub, filter)
+	return nil
+}
+
+func formatDeployment(deployment *gitlab.Deployment) string {
+	ref := deployment.Ref
+	if len(deployment.SHA) >= 8 {
+		ref += "@" + deployment.SHA[:8]
+	}
+	res := fmt.Sprintf("- #%d `%s`, %s", deployment.IID, ref, deployment.Deployable.Status)
+	if deployment.User != nil {
+		res += " by " + deployment.User.Username
+	}
+	if deployment.CreatedAt != nil {
+		res += fmt.Sprintf(" on %s", deployment.CreatedAt.UTC().Format("Jan 2 15:04 MST"))
+	}
+	return res
+}
+
+// handleDeployments lists the latest deployments of a project to an
+// environment, as `!gitlab deployments <project> <environment>`.
+func (h *Handler) handleDeployments(msg chat1.MsgSummary) error {
+	// environment names are case sensitive
+	args, ok, err := h.commandArgs(msg, 2)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) != 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab deployments <project> <environment>`")
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, strings.ToLower(args[0]))
+	if err != nil || sub == nil {
+		return err
+	}
+	client, err := sub.Client()
+	if err != nil {
+		return fmt.Errorf("error making client: %s", err)
+	}
+	if client == nil {
+		h.ChatEcho(msg.ConvID, "I need an access token to look up deployments of `%s`, subscribe again with `--token <access token>`.", sub)
+		return nil
+	}
+	environment := args[1]
+	deployments, res, err := client.Deployments.ListProjectDeployments(sub.Repo, &gitlab.ListProjectDeploymentsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: maxDeploymentsListed},
+		OrderBy:     gitlab.String("id"),
+		Sort:        gitlab.String("desc"),
+		Environment: gitlab.String(environment),
+	})
+	if err != nil {
+		if res != nil && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden) {
+			h.ChatEcho(msg.ConvID, "The token of `%s` can't see its deployments.", sub)
+			return nil
+		}
+		return fmt.Errorf("error listing deployments: %s", err)
+	}
+	if len(deployments) == 0 {
+		h.ChatEcho(msg.ConvID, "`%s` has no deployments to `%s`.", sub, environment)
+		return nil
+	}
+	items := make([]string, 0, len(deployments))
+	for _, deployment := range deployments {
+		items = append(items, formatDeployment(deployment))
+	}
+	return h.pager.Send(msg.ConvID, base.PagedList{
+		Header: fmt.Sprintf("Latest deployments of `%s` to *%s*:", sub, environment),
+		Items:  items,
+	})
+}
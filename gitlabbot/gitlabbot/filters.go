@@ -0,0 +1,151 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+)
+
+// eventTypes maps the names accepted by `--events` to the event type they
+// stand for.
+var eventTypes = map[string]string{
+	"issues":         "issues",
+	"mrs":            "mrs",
+	"mr":             "mrs",
+	"merge_requests": "mrs",
+	"pushes":         "pushes",
+	"commits":        "pushes",
+	"pipelines":      "pipelines",
+	"tags":           "tags",
+	"releases":       "releases",
+	"deployments":    "deployments",
+	"deploys":        "deployments",
+	"wiki":           "wiki",
+	"wikis":          "wiki",
+	"snippets":       "snippets",
+	"snippet":        "snippets",
+}
+
+// optionalEventTypes are only announced by subscriptions which list them
+var optionalEventTypes = []string{"snippets", "wiki"}
+
+// allEventTypes lists every event type a subscription can pick
+func allEventTypes() (res []string) {
+	seen := make(map[string]bool)
+	for _, eventType := range eventTypes {
+		if !seen[eventType] {
+			seen[eventType] = true
+			res = append(res, eventType)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// defaultEventTypes lists the event types of a subscription without an
+// events filter.
+func defaultEventTypes() (res []string) {
+	for _, eventType := range allEventTypes() {
+		if !isOneOf(eventType, optionalEventTypes) {
+			res = append(res, eventType)
+		}
+	}
+	return res
+}
+
+// isDefaultEventTypes reports whether sorted event types are exactly the
+// default ones.
+func isDefaultEventTypes(events []string) bool {
+	return strings.Join(events, ",") == strings.Join(defaultEventTypes(), ",")
+}
+
+// parseEventTypes resolves the names of event types, `all` standing for the
+// default ones, it returns the first unknown name if any.
+func parseEventTypes(names []string) (res []string, unknown string) {
+	for _, name := range names {
+		resolved := []string{eventTypes[strings.ToLower(name)]}
+		if strings.ToLower(name) == "all" {
+			resolved = defaultEventTypes()
+		} else if resolved[0] == "" {
+			return nil, name
+		}
+		for _, eventType := range resolved {
+			if !isOneOf(eventType, res) {
+				res = append(res, eventType)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res, ""
+}
+
+// eventType returns the type of a webhook event subscriptions filter on, and
+// the labels of the issue or merge request it is about.
+func eventType(event interface{}) (eventType string, labels []string) {
+	switch event := event.(type) {
+	case *gitlab.IssueEvent:
+		for _, label := range event.Labels {
+			labels = append(labels, label.Name)
+		}
+		return "issues", labels
+	case *gitlab.MergeEvent:
+		for _, label := range event.Labels {
+			labels = append(labels, label.Name)
+		}
+		return "mrs", labels
+	case *gitlab.PushEvent:
+		return "pushes", nil
+	case *gitlab.TagEvent:
+		return "tags", nil
+	case *releaseEvent:
+		return "releases", nil
+	case *gitlab.PipelineEvent:
+		return "pipelines", nil
+	case *deploymentEvent:
+		return "deployments", nil
+	case *wikiPageEvent:
+		return "wiki", nil
+	case *gitlab.SnippetCommentEvent:
+		return "snippets", nil
+	}
+	return "", nil
+}
+
+// wants reports whether the subscription announces an event. Events are
+// limited to the subscription's event types, or the default ones, and issues
+// and merge requests to those with one of its labels.
+func (s Subscription) wants(event interface{}) bool {
+	eventType, labels := eventType(event)
+	events := s.Events
+	if len(events) == 0 {
+		events = defaultEventTypes()
+	}
+	if !isOneOf(eventType, events) {
+		return false
+	}
+	if len(s.Labels) == 0 || (eventType != "issues" && eventType != "mrs") {
+		return true
+	}
+	for _, label := range labels {
+		for _, watched := range s.Labels {
+			if strings.EqualFold(label, watched) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatFilterList(items []string, empty string) string {
+	if len(items) == 0 {
+		return empty
+	}
+	return "`" + strings.Join(items, "`, `") + "`"
+}
+
+func formatSubscriptionFilters(sub Subscription) string {
+	return fmt.Sprintf("events: %s\nlabels: %s", formatFilterList(sub.Events, "all but "+formatFilterList(optionalEventTypes, "")),
+		formatFilterList(sub.Labels, "any"))
+}
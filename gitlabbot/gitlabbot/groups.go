@@ -0,0 +1,369 @@
+package gitlabbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// projectHookOptions adds the triggers go-gitlab doesn't know yet.
+type projectHookOptions struct {
+	gitlab.AddProjectHookOptions
+	DeploymentEvents *bool `url:"deployment_events,omitempty" json:"deployment_events,omitempty"`
+	ReleasesEvents   *bool `url:"releases_events,omitempty" json:"releases_events,omitempty"`
+}
+
+// parseGroupInput checks if url or <group[/subgroup]> form
+func parseGroupInput(urlOrGroupPath string) (hostedURL string, group string, err error) {
+	urlOrGroupPath = strings.TrimSuffix(urlOrGroupPath, "/")
+	parsedURL, err := url.ParseRequestURI(urlOrGroupPath)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		group = urlOrGroupPath
+		hostedURL = defaultInstanceURL
+	} else {
+		hostedURL = parsedURL.Scheme + "://" + parsedURL.Host
+		group = strings.TrimPrefix(strings.TrimPrefix(parsedURL.Path, "/"), "groups/")
+	}
+	for _, part := range strings.Split(group, "/") {
+		if part == "" || !repoRegex.MatchString(part) {
+			return hostedURL, group, fmt.Errorf("invalid arguments, expected `<group>`")
+		}
+	}
+	return hostedURL, group, nil
+}
+
+// groupHookURL is the webhook URL the bot adds to the projects of a group
+// subscription, the conversation tells its hooks apart from those of other
+// conversations following the same group.
+func groupHookURL(httpPrefix string, convID chat1.ConvIDStr) string {
+	return fmt.Sprintf("%s/gitlabbot/webhook?conv=%s", httpPrefix, convID)
+}
+
+func formatGroupSetupInstructions(group string, hostedURL string, msg chat1.MsgSummary, httpAddress string, secret string) string {
+	back := "`"
+	return fmt.Sprintf(`
+To send me the notifications of every project in the group, go to %s/groups/%s/-/hooks and add a new webhook (group webhooks need GitLab Premium).
+For “URL”, enter %s%s/gitlabbot/webhook%s.
+For “Secret Token”, enter %s%s%s.
+Remember to check all the triggers you would like me to update you on.
+Without group webhooks, subscribe again with %s--token <access token>%s instead, a token of a group Maintainer with the %sapi%s scope, and I'll add a webhook to each project of the group myself, including new ones.
+
+Happy coding!`,
+		hostedURL, group, back, httpAddress, back, back, webhookSecret(hostedURL, group, msg.ConvID, secret), back,
+		back, back, back, back)
+}
+
+// addProjectHooks adds the webhook of a group subscription to each project
+// of the group which doesn't have it yet. It returns how many were added, and
+// how many projects it couldn't add it to, such as those the token can't
+// administer.
+func addProjectHooks(sub Subscription, httpPrefix, secret string) (added, failed int, err error) {
+	client, err := sub.Client()
+	if err != nil || client == nil {
+		return 0, 0, err
+	}
+	hookURL := groupHookURL(httpPrefix, sub.ConvID)
+	opts := &gitlab.ListGroupProjectsOptions{
+		ListOptions:      gitlab.ListOptions{PerPage: 100},
+		Archived:         gitlab.Bool(false),
+		IncludeSubgroups: gitlab.Bool(true),
+	}
+	for {
+		projects, res, err := client.Groups.ListGroupProjects(sub.Repo, opts)
+		if err != nil {
+			return added, failed, err
+		}
+		for _, project := range projects {
+			if isOneOf(strings.ToLower(project.PathWithNamespace), sub.Excludes) {
+				continue
+			}
+			ok, err := addProjectHook(client, project, hookURL, webhookSecret(sub.InstanceURL, sub.Repo, sub.ConvID, secret))
+			switch {
+			case err != nil:
+				failed++
+			case ok:
+				added++
+			}
+		}
+		if res.NextPage == 0 {
+			return added, failed, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+func addProjectHook(client *gitlab.Client, project *gitlab.Project, hookURL, token string) (bool, error) {
+	hooks, _, err := client.Projects.ListProjectHooks(project.ID, &gitlab.ListProjectHooksOptions{PerPage: 100})
+	if err != nil {
+		return false, err
+	}
+	for _, hook := range hooks {
+		if hook.URL == hookURL {
+			return false, nil
+		}
+	}
+	opts := &projectHookOptions{
+		AddProjectHookOptions: gitlab.AddProjectHookOptions{
+			URL:                   gitlab.String(hookURL),
+			Token:                 gitlab.String(token),
+			PushEvents:            gitlab.Bool(true),
+			TagPushEvents:         gitlab.Bool(true),
+			IssuesEvents:          gitlab.Bool(true),
+			MergeRequestsEvents:   gitlab.Bool(true),
+			PipelineEvents:        gitlab.Bool(true),
+			WikiPageEvents:        gitlab.Bool(true),
+			NoteEvents:            gitlab.Bool(true),
+			EnableSSLVerification: gitlab.Bool(true),
+		},
+		DeploymentEvents: gitlab.Bool(true),
+		ReleasesEvents:   gitlab.Bool(true),
+	}
+	req, err := client.NewRequest(http.MethodPost, fmt.Sprintf("projects/%d/hooks", project.ID), opts, nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err := client.Do(req, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// handleGroupSubscribe subscribes to every project of a group, as `!gitlab
+// subscribe <group> --group [--token <access token>]`. With a token the bot
+// adds a webhook to each project, otherwise it asks for a group webhook.
+func (h *Handler) handleGroupSubscribe(args []string, token string, msg chat1.MsgSummary, create bool) error {
+	if len(args) != 1 || (token != "" && !create) {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab subscribe <group> --group [--token <access token>]`")
+		return nil
+	}
+	hostedURL, group, err := parseGroupInput(strings.ToLower(args[0]))
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "Invalid group: %q, expected `<group>` or `https://domain.com/group`", group)
+		return nil
+	}
+	sub := Subscription{ConvID: msg.ConvID, InstanceURL: hostedURL, Repo: group, APIToken: token, Group: true}
+	current, err := h.db.GetSubscription(msg.ConvID, hostedURL, group)
+	if err != nil {
+		return fmt.Errorf("error getting subscription: %s", err)
+	}
+	if current != nil && !current.Group {
+		h.ChatEcho(msg.ConvID, "`%s` is a project, subscribe to it without `--group`.", current)
+		return nil
+	}
+
+	if !create {
+		if current == nil {
+			h.ChatEcho(msg.ConvID, "You aren't subscribed to updates for the group `%s`!", sub)
+			return nil
+		}
+		if err := h.db.DeleteSubscriptionsForRepo(msg.ConvID, hostedURL, group); err != nil {
+			return fmt.Errorf("error deleting subscriptions: %s", err)
+		}
+		reply := fmt.Sprintf("Okay, you won't receive updates for the projects of `%s` here.", sub)
+		if current.APIToken != "" {
+			reply += " The webhooks I added to its projects can be deleted from their settings."
+		}
+		h.ChatEcho(msg.ConvID, "%s", reply)
+		return nil
+	}
+
+	if token != "" {
+		client, err := sub.Client()
+		if err != nil {
+			h.ChatEcho(msg.ConvID, "I can't reach `%s`: %s", hostedURL, err)
+			return nil
+		}
+		if _, res, err := client.Groups.GetGroup(group); err != nil {
+			if res != nil && res.StatusCode == http.StatusUnauthorized {
+				h.ChatEcho(msg.ConvID, "%s rejected that token, make sure it's valid and has the `api` scope.", hostedURL)
+			} else {
+				h.ChatEcho(msg.ConvID, "That token can't see the group `%s`, make sure it exists and the token has access to it.", sub)
+			}
+			return nil
+		}
+		if !base.IsDirectPrivateMessage(h.kbc.GetUsername(), msg.Sender.Username, msg.Channel) {
+			h.ChatEcho(msg.ConvID, "Heads up, everyone here can read that token, you may want to delete your message.")
+		}
+	}
+	switch {
+	case current == nil:
+		if err := h.db.CreateSubscription(sub, base.IdentifierFromMsg(msg)); err != nil {
+			return fmt.Errorf("error creating subscription: %s", err)
+		}
+	case token != "":
+		if err := h.db.SetSubscriptionToken(msg.ConvID, hostedURL, group, token); err != nil {
+			return fmt.Errorf("error setting token: %s", err)
+		}
+		sub.Excludes = current.Excludes
+	default:
+		h.ChatEcho(msg.ConvID, "You're already receiving notifications for the group `%s` here!", sub)
+		return nil
+	}
+
+	if token == "" {
+		_, err = h.kbc.SendMessageByTlfName(msg.Sender.Username, formatGroupSetupInstructions(group, hostedURL, msg, h.httpPrefix, h.secret))
+		if err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+		if !base.IsDirectPrivateMessage(h.kbc.GetUsername(), msg.Sender.Username, msg.Channel) {
+			h.ChatEcho(msg.ConvID, "OK! I've sent a message to @%s to set up the webhook of `%s`.", msg.Sender.Username, sub)
+		}
+		return nil
+	}
+	added, failed, err := addProjectHooks(sub, h.httpPrefix, h.secret)
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "I couldn't list the projects of `%s`: %s", sub, err)
+		return nil
+	}
+	reply := fmt.Sprintf("Okay, I added my webhook to %d projects of `%s` and will add it to new ones as they're created.", added, sub)
+	if failed > 0 {
+		reply += fmt.Sprintf(" I couldn't add it to %d projects, make sure the token belongs to a Maintainer of the group.", failed)
+	}
+	h.ChatEcho(msg.ConvID, "%s", reply)
+	return nil
+}
+
+// groupSubscriptionFor finds the group subscription of the conversation which
+// covers project, the innermost one if groups are nested.
+func (h *Handler) groupSubscriptionFor(convID chat1.ConvIDStr, instanceURL, project string) (*Subscription, error) {
+	subs, err := h.db.GetAllSubscriptionsForConvID(convID)
+	if err != nil {
+		return nil, err
+	}
+	var res *Subscription
+	for index, sub := range subs {
+		if !sub.Group || sub.InstanceURL != instanceURL || !strings.HasPrefix(project, sub.Repo+"/") {
+			continue
+		}
+		if res == nil || len(sub.Repo) > len(res.Repo) {
+			res = &subs[index]
+		}
+	}
+	return res, nil
+}
+
+// handleGroupExclude stops or resumes announcing a project of a subscribed
+// group, as `!gitlab group exclude <project>` or `!gitlab group include
+// <project>`.
+func (h *Handler) handleGroupExclude(cmd string, msg chat1.MsgSummary, exclude bool) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[3:]
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab group exclude <project>` or `!gitlab group include <project>`")
+		return nil
+	}
+	hostedURL, repo, err := parseRepoInput(args[0])
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "Invalid repo: %q, expected `<owner/repo>` or `https://domain.com/owner/repo`", repo)
+		return nil
+	}
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	sub, err := h.groupSubscriptionFor(msg.ConvID, hostedURL, repo)
+	if err != nil {
+		return fmt.Errorf("error getting subscriptions: %s", err)
+	}
+	if sub == nil {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to a group `%s` is in!", args[0])
+		return nil
+	}
+
+	excluded := isOneOf(repo, sub.Excludes)
+	switch {
+	case exclude && excluded:
+		h.ChatEcho(msg.ConvID, "`%s` is already excluded from `%s`.", repo, sub)
+		return nil
+	case !exclude && !excluded:
+		h.ChatEcho(msg.ConvID, "`%s` isn't excluded from `%s`.", repo, sub)
+		return nil
+	case exclude:
+		sub.Excludes = append(sub.Excludes, repo)
+		sort.Strings(sub.Excludes)
+	default:
+		var remaining []string
+		for _, excluded := range sub.Excludes {
+			if excluded != repo {
+				remaining = append(remaining, excluded)
+			}
+		}
+		sub.Excludes = remaining
+	}
+	if err := h.db.SetSubscriptionExcludes(*sub); err != nil {
+		return fmt.Errorf("error setting excluded projects: %s", err)
+	}
+	if exclude {
+		h.ChatEcho(msg.ConvID, "Okay, I won't announce `%s` here as part of `%s`.", repo, sub)
+	} else {
+		h.ChatEcho(msg.ConvID, "Okay, I'll announce `%s` here again as part of `%s`.", repo, sub)
+	}
+	return nil
+}
+
+// GroupHookScheduler adds the webhook of group subscriptions with an access
+// token to projects created in their group since the last run.
+type GroupHookScheduler struct {
+	*base.DebugOutput
+
+	stats      *base.StatsRegistry
+	db         *DB
+	httpPrefix string
+	secret     string
+}
+
+func NewGroupHookScheduler(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB,
+	httpPrefix string, secret string) *GroupHookScheduler {
+	return &GroupHookScheduler{
+		DebugOutput: base.NewDebugOutput("GroupHookScheduler", debugConfig),
+		stats:       stats.SetPrefix("GroupHookScheduler"),
+		db:          db,
+		httpPrefix:  httpPrefix,
+		secret:      secret,
+	}
+}
+
+func (s *GroupHookScheduler) Task() base.Task {
+	return base.Task{
+		Name:       "group-project-hooks",
+		Schedule:   "0 * * * *",
+		Jitter:     5 * time.Minute,
+		RunOnStart: true,
+		Run:        s.addHooks,
+	}
+}
+
+func (s *GroupHookScheduler) addHooks(ctx context.Context) error {
+	subs, err := s.db.GetGroupSubscriptions()
+	if err != nil {
+		return fmt.Errorf("error getting group subscriptions: %s", err)
+	}
+	for _, sub := range subs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		added, failed, err := addProjectHooks(sub, s.httpPrefix, s.secret)
+		if err != nil {
+			s.stats.Count("addHooks - list error")
+			s.Debug("addHooks: unable to list projects of %s: %s", sub, err)
+			continue
+		}
+		s.stats.CountMult("addHooks - added", added)
+		s.stats.CountMult("addHooks - failed", failed)
+	}
+	return nil
+}
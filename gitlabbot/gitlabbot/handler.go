@@ -2,6 +2,7 @@ package gitlabbot
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
@@ -15,6 +16,8 @@ type Handler struct {
 	stats      *base.StatsRegistry
 	kbc        *kbchat.API
 	db         *DB
+	pager      *base.Pager
+	settings   *base.SettingsStore
 	httpPrefix string
 	secret     string
 }
@@ -22,12 +25,14 @@ type Handler struct {
 var _ base.Handler = (*Handler)(nil)
 
 func NewHandler(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig,
-	db *DB, httpPrefix string, secret string) *Handler {
+	db *DB, pager *base.Pager, settings *base.SettingsStore, httpPrefix string, secret string) *Handler {
 	return &Handler{
 		DebugOutput: base.NewDebugOutput("Handler", debugConfig),
 		stats:       stats.SetPrefix("Handler"),
 		kbc:         kbc,
 		db:          db,
+		pager:       pager,
+		settings:    settings,
 		httpPrefix:  httpPrefix,
 		secret:      secret,
 	}
@@ -49,16 +54,68 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 
 	cmd := strings.ToLower(strings.TrimSpace(msg.Content.Text.Body))
 	if !strings.HasPrefix(cmd, "!gitlab") {
-		return nil
+		// non-command messages may link to GitLab
+		return h.handleUnfurl(msg)
 	}
 
 	switch {
 	case strings.HasPrefix(cmd, "!gitlab subscribe"):
 		h.stats.Count("subscribe")
-		return h.handleSubscribe(cmd, msg, true)
+		return h.handleSubscribe(msg, true)
 	case strings.HasPrefix(cmd, "!gitlab unsubscribe"):
 		h.stats.Count("unsubscribe")
-		return h.handleSubscribe(cmd, msg, false)
+		return h.handleSubscribe(msg, false)
+	case strings.HasPrefix(cmd, "!gitlab pipeline filter"):
+		h.stats.Count("pipeline filter")
+		return h.handlePipelineFilter(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab pipeline retry"):
+		h.stats.Count("pipeline retry")
+		return h.handlePipelineRetry(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab pipeline trigger"):
+		h.stats.Count("pipeline trigger")
+		return h.handlePipelineTrigger(msg)
+	case strings.HasPrefix(cmd, "!gitlab pipeline run"):
+		h.stats.Count("pipeline run")
+		return h.handlePipelineRun(msg)
+	case strings.HasPrefix(cmd, "!gitlab deployments filter"):
+		h.stats.Count("deployments filter")
+		return h.handleDeploymentFilter(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab deployments"):
+		h.stats.Count("deployments")
+		return h.handleDeployments(msg)
+	case strings.HasPrefix(cmd, "!gitlab board"):
+		h.stats.Count("board")
+		return h.handleBoard(msg)
+	case strings.HasPrefix(cmd, "!gitlab releases"):
+		h.stats.Count("releases")
+		return h.handleReleases(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab issue create"):
+		h.stats.Count("issue create")
+		return h.handleIssueCreate(msg)
+	case strings.HasPrefix(cmd, "!gitlab mr approve"):
+		h.stats.Count("mr approve")
+		return h.handleMR(msg, false)
+	case strings.HasPrefix(cmd, "!gitlab mr merge"):
+		h.stats.Count("mr merge")
+		return h.handleMR(msg, true)
+	case strings.HasPrefix(cmd, "!gitlab group exclude"):
+		h.stats.Count("group exclude")
+		return h.handleGroupExclude(cmd, msg, true)
+	case strings.HasPrefix(cmd, "!gitlab group include"):
+		h.stats.Count("group include")
+		return h.handleGroupExclude(cmd, msg, false)
+	case strings.HasPrefix(cmd, "!gitlab reminders"):
+		h.stats.Count("reminders")
+		return h.handleReminders(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab unfurl"):
+		h.stats.Count("unfurl pref")
+		return h.handleUnfurlPref(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab mentions"):
+		h.stats.Count("mentions")
+		return h.handleMentions(cmd, msg)
+	case strings.HasPrefix(cmd, "!gitlab token"):
+		h.stats.Count("token")
+		return h.handleToken(msg)
 	case strings.HasPrefix(cmd, "!gitlab list"):
 		h.stats.Count("list")
 		return h.handleListSubscriptions(msg)
@@ -66,8 +123,29 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 	return nil
 }
 
-func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool) (err error) {
-	toks, userErr, err := base.SplitTokens(cmd)
+// parseTokenFlag splits a `--token <access token>` flag from the rest of
+// args.
+func parseTokenFlag(args []string) (rest []string, token string, ok bool) {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--token":
+			if i+1 == len(args) {
+				return nil, "", false
+			}
+			token = args[i+1]
+			i++
+		case strings.HasPrefix(arg, "--token="):
+			token = strings.TrimPrefix(arg, "--token=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, token, true
+}
+
+func (h *Handler) handleSubscribe(msg chat1.MsgSummary, create bool) (err error) {
+	// tokens are case sensitive, so arguments come from the message as sent
+	toks, userErr, err := base.SplitTokens(strings.TrimSpace(msg.Content.Text.Body))
 	if err != nil {
 		return err
 	} else if userErr != "" {
@@ -75,26 +153,55 @@ func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool)
 		return nil
 	}
 
-	args := toks[2:]
-	if len(args) < 1 {
-		h.ChatEcho(msg.ConvID, "Bad arguments for subscribe: %v", args)
+	args, token, ok := parseTokenFlag(toks[2:])
+	if ok && isOneOf("--group", args) {
+		var rest []string
+		for _, arg := range args {
+			if arg != "--group" {
+				rest = append(rest, arg)
+			}
+		}
+		return h.handleGroupSubscribe(rest, token, msg, create)
+	}
+	if ok {
+		var filters map[string][]string
+		args, filters, ok = parseListFlags(args, "--events", "--labels")
+		if ok && len(filters) > 0 {
+			if len(args) != 1 || token != "" {
+				h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab subscribe <owner/repo> [--events mrs,issues,pipelines,tags] [--labels bug]`")
+				return nil
+			}
+			return h.handleSubscribeFilters(args[0], filters, msg, create)
+		}
+	}
+	if !ok || len(args) < 1 || (token != "" && !create) {
+		h.ChatEcho(msg.ConvID, "Bad arguments for subscribe: %v", toks[2:])
 		return nil
 	}
 
-	hostedURL, repo, err := parseRepoInput(args[0])
+	hostedURL, repo, err := parseRepoInput(strings.ToLower(args[0]))
 	if err != nil {
 		h.ChatEcho(msg.ConvID, "Invalid repo: %q, expected `<owner/repo>` or `https://domain.com/owner/repo`", repo)
 		return nil
 	}
+	sub := Subscription{ConvID: msg.ConvID, InstanceURL: hostedURL, Repo: repo, APIToken: token}
 
-	alreadyExists, err := h.db.GetSubscriptionForRepoExists(msg.ConvID, repo)
+	alreadyExists, err := h.db.GetSubscriptionForRepoExists(msg.ConvID, hostedURL, repo)
 	if err != nil {
 		return fmt.Errorf("error checking subscription: %s", err)
 	}
 
 	if create {
+		if token != "" {
+			if ok, err := h.checkToken(msg, sub); err != nil || !ok {
+				return err
+			}
+			if !base.IsDirectPrivateMessage(h.kbc.GetUsername(), msg.Sender.Username, msg.Channel) {
+				h.ChatEcho(msg.ConvID, "Heads up, everyone here can read that token, you may want to delete your message.")
+			}
+		}
 		if !alreadyExists {
-			err = h.db.CreateSubscription(msg.ConvID, repo, base.IdentifierFromMsg(msg))
+			err = h.db.CreateSubscription(sub, base.IdentifierFromMsg(msg))
 			if err != nil {
 				return fmt.Errorf("error creating subscription: %s", err)
 			}
@@ -108,23 +215,149 @@ func (h *Handler) handleSubscribe(cmd string, msg chat1.MsgSummary, create bool)
 			return nil
 		}
 
-		h.ChatEcho(msg.ConvID, "You're already receiving notifications for `%s` here!", repo)
+		if token != "" {
+			if err := h.db.SetSubscriptionToken(msg.ConvID, hostedURL, repo, token); err != nil {
+				return fmt.Errorf("error setting token: %s", err)
+			}
+			h.ChatEcho(msg.ConvID, "Okay, I'll use the new token for `%s`.", sub)
+			return nil
+		}
+		h.ChatEcho(msg.ConvID, "You're already receiving notifications for `%s` here!", sub)
 		return nil
 	}
 
 	if alreadyExists {
-		err = h.db.DeleteSubscriptionsForRepo(msg.ConvID, repo)
+		err = h.db.DeleteSubscriptionsForRepo(msg.ConvID, hostedURL, repo)
 		if err != nil {
 			return fmt.Errorf("error deleting subscriptions: %s", err)
 		}
-		h.ChatEcho(msg.ConvID, "Okay, you won't receive updates for `%s` here.", repo)
+		h.ChatEcho(msg.ConvID, "Okay, you won't receive updates for `%s` here.", sub)
 		return nil
 	}
 
-	h.ChatEcho(msg.ConvID, "You aren't subscribed to updates for `%s`!", repo)
+	h.ChatEcho(msg.ConvID, "You aren't subscribed to updates for `%s`!", sub)
 	return nil
 }
 
+// handleSubscribeFilters handles `--events` and `--labels`. Subscribing
+// limits the subscription to exactly the listed event types and labels,
+// unsubscribing removes the listed ones.
+func (h *Handler) handleSubscribeFilters(project string, filters map[string][]string, msg chat1.MsgSummary,
+	create bool) error {
+	hostedURL, repo, err := parseRepoInput(strings.ToLower(project))
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "Invalid repo: %q, expected `<owner/repo>` or `https://domain.com/owner/repo`", repo)
+		return nil
+	}
+	events, hasEvents := filters["--events"]
+	if hasEvents {
+		var unknown string
+		if events, unknown = parseEventTypes(events); unknown != "" {
+			h.ChatEcho(msg.ConvID, "I don't know the event type `%s`! Try one of %s.", unknown, formatFilterList(allEventTypes(), ""))
+			return nil
+		}
+	}
+	labels, hasLabels := filters["--labels"]
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	sub, err := h.db.GetSubscription(msg.ConvID, hostedURL, repo)
+	if err != nil {
+		return fmt.Errorf("error getting subscription: %s", err)
+	}
+
+	if sub == nil {
+		if !create {
+			h.ChatEcho(msg.ConvID, "You aren't subscribed to updates for `%s`!", project)
+			return nil
+		}
+		sub = &Subscription{ConvID: msg.ConvID, InstanceURL: hostedURL, Repo: repo, Events: events, Labels: labels}
+		if err := h.db.CreateSubscription(*sub, base.IdentifierFromMsg(msg)); err != nil {
+			return fmt.Errorf("error creating subscription: %s", err)
+		}
+		if _, err := h.kbc.SendMessageByTlfName(msg.Sender.Username, formatSetupInstructions(repo, hostedURL, msg, h.httpPrefix, h.secret)); err != nil {
+			return fmt.Errorf("error sending message: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "OK! I've sent a message to @%s to set up the webhook of `%s`.\n%s", msg.Sender.Username,
+			sub, formatSubscriptionFilters(*sub))
+		return nil
+	}
+
+	if create {
+		if hasEvents {
+			sub.Events = events
+		}
+		if hasLabels {
+			sub.Labels = labels
+		}
+	} else {
+		if hasEvents {
+			current := sub.Events
+			if len(current) == 0 {
+				current = defaultEventTypes()
+			}
+			var remaining []string
+			for _, eventType := range current {
+				if !isOneOf(eventType, events) {
+					remaining = append(remaining, eventType)
+				}
+			}
+			if len(remaining) == 0 {
+				h.ChatEcho(msg.ConvID, "That would leave nothing to announce, try `!gitlab unsubscribe %s` instead.", project)
+				return nil
+			}
+			sub.Events = remaining
+		}
+		if hasLabels {
+			var remaining []string
+			for _, label := range sub.Labels {
+				removed := false
+				for _, unwatched := range labels {
+					removed = removed || strings.EqualFold(label, unwatched)
+				}
+				if !removed {
+					remaining = append(remaining, label)
+				}
+			}
+			sub.Labels = remaining
+		}
+	}
+	if isDefaultEventTypes(sub.Events) {
+		sub.Events = nil
+	}
+	if err := h.db.SetSubscriptionFilters(*sub); err != nil {
+		return fmt.Errorf("error setting subscription filters: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, updated `%s` here.\n%s", sub, formatSubscriptionFilters(*sub))
+	return nil
+}
+
+// checkToken makes sure the token of a new subscription can see its project
+// before it's stored.
+func (h *Handler) checkToken(msg chat1.MsgSummary, sub Subscription) (bool, error) {
+	client, err := sub.Client()
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "I can't reach `%s`: %s", sub.InstanceURL, err)
+		return false, nil
+	}
+	_, res, err := client.Projects.GetProject(sub.Repo, nil)
+	if err != nil {
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusUnauthorized:
+				h.ChatEcho(msg.ConvID, "%s rejected that token, make sure it's valid and has the `api` scope.", sub.InstanceURL)
+				return false, nil
+			case http.StatusNotFound, http.StatusForbidden:
+				h.ChatEcho(msg.ConvID, "That token can't see `%s`, make sure the project exists and the token has access to it.", sub)
+				return false, nil
+			}
+		}
+		h.ChatEcho(msg.ConvID, "I couldn't check that token against `%s`: %s", sub.InstanceURL, err)
+		return false, nil
+	}
+	return true, nil
+}
+
 func (h *Handler) handleListSubscriptions(msg chat1.MsgSummary) (err error) {
 	subscriptions, err := h.db.GetAllSubscriptionsForConvID(msg.ConvID)
 	if err != nil {
@@ -136,10 +369,24 @@ func (h *Handler) handleListSubscriptions(msg chat1.MsgSummary) (err error) {
 		return nil
 	}
 
-	var res string
-	for _, repo := range subscriptions {
-		res += fmt.Sprintf("- *%s*\n", repo)
+	items := make([]string, len(subscriptions))
+	for index, sub := range subscriptions {
+		items[index] = fmt.Sprintf("- *%s*", sub.Repo)
+		if sub.InstanceURL != defaultInstanceURL {
+			items[index] += fmt.Sprintf(" on %s", sub.InstanceURL)
+		}
+		if sub.Group {
+			items[index] += " (group)"
+			if len(sub.Excludes) > 0 {
+				items[index] += fmt.Sprintf(", excluding %s", formatFilterList(sub.Excludes, ""))
+			}
+		}
+		if len(sub.Events) > 0 {
+			items[index] += fmt.Sprintf(", events: %s", formatFilterList(sub.Events, ""))
+		}
+		if len(sub.Labels) > 0 {
+			items[index] += fmt.Sprintf(", labels: %s", formatFilterList(sub.Labels, ""))
+		}
 	}
-	h.ChatEcho(msg.ConvID, res)
-	return nil
+	return h.pager.Send(msg.ConvID, base.PagedList{Items: items})
 }
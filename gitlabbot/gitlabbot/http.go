@@ -10,25 +10,30 @@ import (
 	"github.com/xanzy/go-gitlab"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
 )
 
 type HTTPSrv struct {
 	*base.HTTPSrv
 
-	kbc     *kbchat.API
-	db      *DB
-	handler *Handler
-	secret  string
+	kbc       *kbchat.API
+	db        *DB
+	handler   *Handler
+	sends     *base.ChatSendQueue
+	analytics *base.Analytics
+	secret    string
 }
 
 func NewHTTPSrv(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig,
-	db *DB, handler *Handler, secret string) *HTTPSrv {
+	db *DB, handler *Handler, sends *base.ChatSendQueue, analytics *base.Analytics, secret string) *HTTPSrv {
 	h := &HTTPSrv{
-		kbc:     kbc,
-		db:      db,
-		handler: handler,
-		secret:  secret,
+		kbc:       kbc,
+		db:        db,
+		handler:   handler,
+		sends:     sends,
+		analytics: analytics,
+		secret:    secret,
 	}
 	h.HTTPSrv = base.NewHTTPSrv(stats, debugConfig)
 	http.HandleFunc("/gitlabbot", h.handleHealthCheck)
@@ -48,13 +53,23 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	event, err := gitlab.ParseWebhook(gitlab.WebhookEventType(r), payload)
+	var event interface{}
+	switch gitlab.WebhookEventType(r) {
+	case deploymentHook:
+		event, err = parseDeploymentEvent(payload)
+	case releaseHook:
+		event, err = parseReleaseEvent(payload)
+	case gitlab.EventTypeWikiPage:
+		event, err = parseWikiPageEvent(payload)
+	default:
+		event, err = gitlab.ParseWebhook(gitlab.WebhookEventType(r), payload)
+	}
 	if err != nil {
 		h.Errorf("could not parse webhook: type:%v %s\n", gitlab.WebhookEventType(r), err)
 		return
 	}
 
-	var message, repo string
+	var message, repo, webURL string
 	switch event := event.(type) {
 	case *gitlab.IssueEvent:
 		message = git.FormatIssueMsg(
@@ -66,6 +81,7 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			event.ObjectAttributes.URL,
 		)
 		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
 	case *gitlab.MergeEvent:
 		message = git.FormatPullRequestMsg(
 			git.GITLAB,
@@ -78,6 +94,7 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			event.ObjectAttributes.TargetBranch,
 		)
 		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
 	case *gitlab.PushEvent:
 		if len(event.Commits) == 0 {
 			break
@@ -94,30 +111,97 @@ func (h *HTTPSrv) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			commitMsgs,
 			lastCommitDiffURL)
 		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
 	case *gitlab.PipelineEvent:
 		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
 		message = formatPipelineMsg(event, event.User.Username)
+	case *deploymentEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+		message = formatDeploymentMsg(event)
+	case *gitlab.TagEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+		message = formatTagMsg(event)
+	case *releaseEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+		message = formatReleaseMsg(event)
+	case *wikiPageEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+		message = formatWikiPageMsg(event)
+	case *gitlab.SnippetCommentEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+		message = formatSnippetCommentMsg(event)
+	// other comments are only forwarded to the users they mention
+	case *gitlab.IssueCommentEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+	case *gitlab.MergeCommentEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
+	case *gitlab.CommitCommentEvent:
+		repo = event.Project.PathWithNamespace
+		webURL = event.Project.WebURL
 	}
 
-	if message == "" || repo == "" {
+	if repo == "" {
 		return
 	}
 	repo = strings.ToLower(repo)
+	instanceURL := instanceURLFromWebURL(webURL)
 	signature := r.Header.Get("X-Gitlab-Token")
 
-	convs, err := h.db.GetSubscribedConvs(repo)
+	subs, err := h.db.GetSubscriptionsForRepo(instanceURL, repo)
 	if err != nil {
 		h.Errorf("Error getting subscriptions for repo: %s", err)
 		return
 	}
 
-
-	for _, convID := range convs {
-		var secretToken = base.MakeSecret(repo, convID, h.secret)
+	// a conversation can follow a project both directly and through its groups
+	sent := make(map[chat1.ConvIDStr]bool)
+	verified := make(map[chat1.ConvIDStr]bool)
+	for _, sub := range subs {
+		convID := sub.ConvID
+		// group subscriptions share the secret of the group
+		var secretToken = webhookSecret(instanceURL, sub.Repo, convID, h.secret)
 		if signature != secretToken {
 			h.Debug("Error validating payload signature for conversation %s: %v", convID, err)
 			continue
 		}
-		h.ChatEcho(convID, message)
+		verified[convID] = true
+		text := message
+		if event, ok := event.(*gitlab.IssueEvent); ok && text == "" {
+			text = h.boardMessage(event, sub)
+		}
+		if text == "" || sent[convID] || (sub.Group && isOneOf(repo, sub.Excludes)) {
+			continue
+		}
+		if !sub.wants(event) {
+			continue
+		}
+		switch event := event.(type) {
+		case *gitlab.PipelineEvent:
+			if !h.pipelineWanted(event, sub) {
+				continue
+			}
+		case *deploymentEvent:
+			if !h.deploymentWanted(event, sub) {
+				continue
+			}
+		}
+		sent[convID] = true
+		h.sends.Send(convID, text)
+		h.analytics.RecordNotification(convID, string(gitlab.WebhookEventType(r)))
+	}
+	if event, ok := event.(*gitlab.PipelineEvent); ok {
+		h.followUpPipelineRun(event, instanceURL, repo, verified)
+	}
+	if note := noteFromEvent(event); note != nil {
+		note.confidential = note.confidential || gitlab.WebhookEventType(r) == gitlab.EventConfidentialNote
+		h.forwardMentions(note, instanceURL, verified)
 	}
 }
@@ -0,0 +1,75 @@
+package gitlabbot
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// defaultInstanceURL is used for projects given as `<owner/repo>`
+const defaultInstanceURL = "https://gitlab.com"
+
+// Subscription is a conversation following a project on a GitLab instance,
+// APIToken is the optional access token the bot uses to call that instance.
+// Events and Labels limit what is announced, empty lists allow everything.
+// Group subscriptions follow every project under the group path in Repo,
+// except the projects in Excludes.
+type Subscription struct {
+	ConvID      chat1.ConvIDStr
+	InstanceURL string
+	Repo        string
+	APIToken    string
+	Events      []string
+	Labels      []string
+	Group       bool
+	Excludes    []string
+}
+
+// String names the project, with its instance if it isn't on gitlab.com.
+func (s Subscription) String() string {
+	if s.InstanceURL == defaultInstanceURL {
+		return s.Repo
+	}
+	return s.InstanceURL + "/" + s.Repo
+}
+
+// Client returns an API client for the instance of the subscription, nil if
+// it has no token.
+func (s Subscription) Client() (*gitlab.Client, error) {
+	if s.APIToken == "" {
+		return nil, nil
+	}
+	return newClient(s.InstanceURL, s.APIToken)
+}
+
+func newClient(instanceURL, token string) (*gitlab.Client, error) {
+	client := gitlab.NewClient(base.NewHTTPClient(), token)
+	if err := client.SetBaseURL(instanceURL + "/api/v4"); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// instanceURLFromWebURL returns the instance a project web URL is on,
+// gitlab.com for events without one.
+func instanceURLFromWebURL(webURL string) string {
+	parsedURL, err := url.Parse(webURL)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return defaultInstanceURL
+	}
+	return strings.ToLower(parsedURL.Scheme + "://" + parsedURL.Host)
+}
+
+// webhookSecret is the token GitLab must send with webhooks of a
+// subscription. Projects on other instances mix the instance in, so the same
+// path on two instances doesn't share a token.
+func webhookSecret(instanceURL, repo string, convID chat1.ConvIDStr, secret string) string {
+	if instanceURL == defaultInstanceURL {
+		return base.MakeSecret(repo, convID, secret)
+	}
+	return base.MakeSecret(instanceURL+"/"+repo, convID, secret)
+}
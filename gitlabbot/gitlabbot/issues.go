@@ -0,0 +1,88 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+)
+
+// quick actions GitLab applies from the description of a new issue
+var quickActionRE = regexp.MustCompile(`\s+(/(?:label|unlabel|relabel|assign|unassign|reassign|milestone|due|weight|confidential|estimate|spend|epic|iteration|severity|cc|relate|todo|subscribe|lock)\b)`)
+
+// formatQuickActions puts each quick action of body on its own line, which
+// is how GitLab looks for them, so they can be given inline from chat.
+func formatQuickActions(body string) string {
+	return strings.TrimSpace(quickActionRE.ReplaceAllString(" "+body, "\n$1"))
+}
+
+func formatIssueCreated(issue *gitlab.Issue, project string) string {
+	res := fmt.Sprintf("Created issue #%d on `%s`: %s", issue.IID, project, issue.WebURL)
+	var details []string
+	if len(issue.Labels) > 0 {
+		details = append(details, "labels: "+strings.Join(issue.Labels, ", "))
+	}
+	if len(issue.Assignees) > 0 {
+		assignees := make([]string, 0, len(issue.Assignees))
+		for _, assignee := range issue.Assignees {
+			assignees = append(assignees, assignee.Username)
+		}
+		details = append(details, "assigned to "+strings.Join(assignees, ", "))
+	}
+	if issue.Milestone != nil {
+		details = append(details, "milestone: "+issue.Milestone.Title)
+	}
+	if len(details) > 0 {
+		res += "\n" + strings.Join(details, ", ")
+	}
+	return res
+}
+
+// handleIssueCreate files an issue as the sender, as `!gitlab issue create
+// <project> "title" [description]`. Quick actions like `/label ~bug` or
+// `/assign @user` in the description are applied by GitLab.
+func (h *Handler) handleIssueCreate(msg chat1.MsgSummary) error {
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	usage := "I don't understand! Try `!gitlab issue create <project> \"title\" [description] [/label ~bug /assign @user]`"
+	if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	hostedURL, repo, err := parseRepoInput(strings.ToLower(args[0]))
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a project to me! %s", args[0], usage)
+		return nil
+	}
+	project := Subscription{InstanceURL: hostedURL, Repo: repo}
+	client, err := h.userClient(msg, hostedURL)
+	if err != nil || client == nil {
+		return err
+	}
+
+	description := formatQuickActions(strings.Join(args[2:], " "))
+	issue, res, err := client.Issues.CreateIssue(repo, &gitlab.CreateIssueOptions{
+		Title:       gitlab.String(args[1]),
+		Description: gitlab.String(description),
+	})
+	if err != nil {
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusNotFound, http.StatusForbidden, http.StatusUnauthorized:
+				h.ChatEcho(msg.ConvID, "I couldn't create an issue on `%s`! Make sure the project exists, has issues enabled and that you can create issues there.", project)
+				return nil
+			case http.StatusBadRequest, http.StatusUnprocessableEntity:
+				h.ChatEcho(msg.ConvID, "GitLab rejected that: %s", err)
+				return nil
+			}
+		}
+		return fmt.Errorf("error creating issue: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "%s", formatIssueCreated(issue, project.String()))
+	return nil
+}
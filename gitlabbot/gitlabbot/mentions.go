@@ -0,0 +1,189 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/git"
+)
+
+const (
+	// comment excerpts forwarded with mentions
+	maxMentionExcerptLen = 300
+	// mentions of one comment which get forwarded
+	maxMentionsPerNote = 10
+)
+
+// GitLab usernames can't start with a dot or dash, nor end with a dot
+var mentionRE = regexp.MustCompile(`(?:^|[^\w.@/-])@(\w[\w.-]*)`)
+
+// mentionNote is a comment of any Note Hook event.
+type mentionNote struct {
+	author string
+	repo   string
+	// what the comment is on, like `issue #12 “title”`
+	target       string
+	text         string
+	url          string
+	confidential bool
+}
+
+// findMentions returns the distinct users mentioned in a comment, leaving out
+// `@all` which notifies the whole project.
+func findMentions(text string) (res []string) {
+	seen := make(map[string]bool)
+	for _, match := range mentionRE.FindAllStringSubmatch(text, -1) {
+		username := strings.ToLower(strings.TrimRight(match[1], "."))
+		if username == "" || username == "all" || seen[username] {
+			continue
+		}
+		seen[username] = true
+		res = append(res, username)
+	}
+	return res
+}
+
+// noteFromEvent returns the comment of a Note Hook event, nil for other
+// events.
+func noteFromEvent(event interface{}) *mentionNote {
+	switch event := event.(type) {
+	case *gitlab.IssueCommentEvent:
+		return &mentionNote{
+			author:       event.User.Username,
+			repo:         event.Project.PathWithNamespace,
+			target:       fmt.Sprintf("issue #%d “%s”", event.Issue.IID, event.Issue.Title),
+			text:         event.ObjectAttributes.Note,
+			url:          event.ObjectAttributes.URL,
+			confidential: event.Issue.Confidential,
+		}
+	case *gitlab.MergeCommentEvent:
+		return &mentionNote{
+			author: event.User.Username,
+			repo:   event.Project.PathWithNamespace,
+			target: fmt.Sprintf("merge request !%d “%s”", event.MergeRequest.IID, event.MergeRequest.Title),
+			text:   event.ObjectAttributes.Note,
+			url:    event.ObjectAttributes.URL,
+		}
+	case *gitlab.CommitCommentEvent:
+		note := &mentionNote{
+			author: event.User.Username,
+			repo:   event.Project.PathWithNamespace,
+			text:   event.ObjectAttributes.Note,
+		}
+		if event.Commit != nil {
+			note.target = "commit " + formatShortSHA(event.Commit.ID)
+			note.url = fmt.Sprintf("%s#note_%d", event.Commit.URL, event.ObjectAttributes.ID)
+		}
+		return note
+	case *gitlab.SnippetCommentEvent:
+		title := ""
+		if event.Snippet != nil {
+			title = event.Snippet.Title
+		}
+		return &mentionNote{
+			author: event.User.Username,
+			repo:   event.Project.PathWithNamespace,
+			target: fmt.Sprintf("snippet “%s”", title),
+			text:   event.ObjectAttributes.Note,
+			url:    event.ObjectAttributes.URL,
+		}
+	}
+	return nil
+}
+
+func formatShortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+func formatMentionMsg(note *mentionNote) string {
+	return fmt.Sprintf(":bell: %s mentioned you on %s of %s:\n%s\n%s", note.author, note.target, note.repo,
+		git.FormatExcerpt(note.text, maxMentionExcerptLen), note.url)
+}
+
+// forwardMentions sends comments to the private messages of the mentioned
+// users who linked their token and turned mentions on, once the webhook is
+// verified for a conversation.
+func (h *HTTPSrv) forwardMentions(note *mentionNote, instanceURL string, verified map[chat1.ConvIDStr]bool) {
+	// confidential comments stay on GitLab
+	if len(verified) == 0 || note.confidential || note.author == "" {
+		return
+	}
+	message := formatMentionMsg(note)
+	mentions := findMentions(note.text)
+	if len(mentions) > maxMentionsPerNote {
+		mentions = mentions[:maxMentionsPerNote]
+	}
+	for _, mention := range mentions {
+		if strings.EqualFold(mention, note.author) {
+			continue
+		}
+		username, token, err := h.db.GetMentionRecipient(instanceURL, mention)
+		if err != nil {
+			h.Errorf("Error getting mention recipient: %s", err)
+			continue
+		}
+		if username == "" {
+			continue
+		}
+		// GitLab doesn't notify users who can't see the project, neither do we
+		client, err := newClient(instanceURL, token)
+		if err != nil {
+			h.Errorf("Error making client: %s", err)
+			continue
+		}
+		if _, _, err := client.Projects.GetProject(note.repo, nil); err != nil {
+			h.Debug("forwardMentions: %s can't see %s: %s", mention, note.repo, err)
+			continue
+		}
+		if _, err := h.kbc.SendMessageByTlfName(username, "%s", message); err != nil {
+			h.Errorf("Error forwarding mention to %s: %s", username, err)
+			continue
+		}
+		h.Stats.Count("forwardMentions")
+	}
+}
+
+// handleMentions turns the forwarding of GitLab mentions of the sender on or
+// off, as `!gitlab mentions <on|off>`.
+func (h *Handler) handleMentions(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab mentions on` or `!gitlab mentions off`.")
+		return nil
+	}
+	instances, err := h.db.GetUserTokenInstances(msg.Sender.Username)
+	if err != nil {
+		return fmt.Errorf("error getting user tokens: %s", err)
+	}
+	if len(instances) == 0 {
+		h.ChatEcho(msg.ConvID, "@%s, I need to know who you are on GitLab first. Create a personal access token with the `api` scope and send me `!gitlab token <token>` in a private message.",
+			msg.Sender.Username)
+		return nil
+	}
+	enabled := args[0] == "on"
+	if err := h.db.SetMentions(msg.Sender.Username, enabled); err != nil {
+		return fmt.Errorf("error setting mentions: %s", err)
+	}
+	if !enabled {
+		h.ChatEcho(msg.ConvID, "Okay @%s, I won't forward your GitLab mentions anymore.", msg.Sender.Username)
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, "Okay @%s, I'll send you comments mentioning you on %s in a private message, for projects subscribed to in a conversation with me.",
+		msg.Sender.Username, strings.Join(instances, ", "))
+	return nil
+}
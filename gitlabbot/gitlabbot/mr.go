@@ -0,0 +1,163 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+)
+
+// parseMRRef splits `<project>!42`, or the web URL of a merge request, into
+// the project and the merge request IID.
+func parseMRRef(ref string) (project string, iid int, ok bool) {
+	if index := strings.Index(ref, "/-/merge_requests/"); index > 0 {
+		project, ref = ref[:index], ref[index+len("/-/merge_requests/"):]
+	} else if index := strings.LastIndex(ref, "!"); index > 0 {
+		project, ref = ref[:index], ref[index+1:]
+	} else {
+		return "", 0, false
+	}
+	iid, err := strconv.Atoi(strings.TrimSuffix(ref, "/"))
+	if err != nil || iid <= 0 {
+		return "", 0, false
+	}
+	return project, iid, true
+}
+
+// reply answers msg in a thread, falling back to a plain message.
+func (h *Handler) reply(msg chat1.MsgSummary, text string, args ...interface{}) {
+	if _, err := h.kbc.SendReplyByConvID(msg.ConvID, &msg.Id, text, args...); err != nil {
+		h.Debug("reply: unable to reply in thread: %s", err)
+		h.ChatEcho(msg.ConvID, text, args...)
+	}
+}
+
+func accessLevel(project *gitlab.Project) gitlab.AccessLevelValue {
+	var level gitlab.AccessLevelValue
+	if project.Permissions == nil {
+		return level
+	}
+	if access := project.Permissions.ProjectAccess; access != nil && access.AccessLevel > level {
+		level = access.AccessLevel
+	}
+	if access := project.Permissions.GroupAccess; access != nil && access.AccessLevel > level {
+		level = access.AccessLevel
+	}
+	return level
+}
+
+// handleMR approves or merges a merge request as the sender, as `!gitlab mr
+// approve <project>!42` or `!gitlab mr merge <project>!42
+// [--when-pipeline-succeeds]`.
+func (h *Handler) handleMR(msg chat1.MsgSummary, merge bool) error {
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	usage := "I don't understand! Try `!gitlab mr approve <project>!42` or `!gitlab mr merge <project>!42 [--when-pipeline-succeeds]`"
+	whenPipelineSucceeds := false
+	if merge && len(args) == 2 && args[1] == "--when-pipeline-succeeds" {
+		whenPipelineSucceeds = true
+		args = args[:1]
+	}
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	projectArg, iid, ok := parseMRRef(args[0])
+	if !ok {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, strings.ToLower(projectArg))
+	if err != nil || sub == nil {
+		return err
+	}
+	client, err := h.userClient(msg, sub.InstanceURL)
+	if err != nil || client == nil {
+		return err
+	}
+	action := "approve"
+	if merge {
+		action = "merge"
+	}
+
+	project, res, err := client.Projects.GetProject(sub.Repo, nil)
+	if err != nil {
+		if res != nil && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusUnauthorized) {
+			h.reply(msg, "Your GitLab account can't see `%s`, or your token was revoked.", sub)
+			return nil
+		}
+		return fmt.Errorf("error getting project: %s", err)
+	}
+	if accessLevel(project) < gitlab.DeveloperPermissions {
+		h.reply(msg, "Sorry, you need at least the Developer role on `%s` to %s merge requests.", sub, action)
+		return nil
+	}
+	mr, res, err := client.MergeRequests.GetMergeRequest(sub.Repo, iid, nil)
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			h.reply(msg, "I couldn't find merge request !%d on `%s`!", iid, sub)
+			return nil
+		}
+		return fmt.Errorf("error getting merge request: %s", err)
+	}
+	if mr.State != "opened" {
+		h.reply(msg, "Merge request !%d on `%s` is %s already.", iid, sub, mr.State)
+		return nil
+	}
+
+	if !merge {
+		if _, res, err := client.MergeRequestApprovals.ApproveMergeRequest(sub.Repo, iid,
+			&gitlab.ApproveMergeRequestOptions{SHA: gitlab.String(mr.SHA)}); err != nil {
+			if res != nil {
+				switch res.StatusCode {
+				case http.StatusUnauthorized, http.StatusForbidden:
+					h.reply(msg, "GitLab didn't let you approve !%d, you may have approved it already or not be one of its eligible approvers.", iid)
+					return nil
+				case http.StatusConflict:
+					h.reply(msg, "New commits were pushed to !%d while I was approving it, have a look and try again.", iid)
+					return nil
+				}
+			}
+			return fmt.Errorf("error approving merge request: %s", err)
+		}
+		h.reply(msg, ":white_check_mark: Approved !%d “%s” on `%s`.\n%s", iid, mr.Title, sub, mr.WebURL)
+		return nil
+	}
+
+	if mr.WorkInProgress {
+		h.reply(msg, "Merge request !%d on `%s` is still a draft, mark it as ready first.", iid, sub)
+		return nil
+	}
+	opts := &gitlab.AcceptMergeRequestOptions{SHA: gitlab.String(mr.SHA)}
+	if whenPipelineSucceeds {
+		opts.MergeWhenPipelineSucceeds = gitlab.Bool(true)
+	}
+	merged, res, err := client.MergeRequests.AcceptMergeRequest(sub.Repo, iid, opts)
+	if err != nil {
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden:
+				h.reply(msg, "GitLab didn't let you merge !%d, the target branch may be protected.", iid)
+				return nil
+			case http.StatusMethodNotAllowed, http.StatusNotAcceptable:
+				h.reply(msg, "Merge request !%d can't be merged yet, it may need approvals, a passing pipeline or resolved conflicts. Try `--when-pipeline-succeeds` if its pipeline is still running.", iid)
+				return nil
+			case http.StatusConflict:
+				h.reply(msg, "New commits were pushed to !%d while I was merging it, have a look and try again.", iid)
+				return nil
+			}
+		}
+		return fmt.Errorf("error merging merge request: %s", err)
+	}
+	if merged.State != "merged" && merged.MergeWhenPipelineSucceeds {
+		h.reply(msg, ":hourglass: !%d “%s” on `%s` will be merged when its pipeline succeeds.\n%s", iid, mr.Title, sub, mr.WebURL)
+		return nil
+	}
+	h.reply(msg, ":tada: Merged !%d “%s” into `%s` on `%s`.\n%s", iid, mr.Title, mr.TargetBranch, sub, mr.WebURL)
+	return nil
+}
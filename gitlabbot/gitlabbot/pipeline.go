@@ -0,0 +1,305 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	// protectedBranches stands for every protected branch in a branch filter
+	protectedBranches = "protected"
+	// failed jobs a pipeline message spells out
+	maxFailedJobsListed = 10
+)
+
+// pipeline statuses announced, and the ones a filter can pick
+var pipelineStatuses = []string{"success", "failed", "canceled"}
+
+// PipelineFilter narrows the pipeline messages of a subscription, empty
+// lists match everything.
+type PipelineFilter struct {
+	Branches []string
+	Statuses []string
+}
+
+func (f PipelineFilter) matchesStatus(status string) bool {
+	if len(f.Statuses) == 0 {
+		return true
+	}
+	return isOneOf(status, f.Statuses)
+}
+
+// matchesBranch reports whether ref matches one of the branch patterns,
+// isProtected is only called for filters naming protected branches.
+func (f PipelineFilter) matchesBranch(ref string, isProtected func() bool) bool {
+	if len(f.Branches) == 0 {
+		return true
+	}
+	ref = strings.ToLower(ref)
+	for _, pattern := range f.Branches {
+		if pattern == protectedBranches {
+			if isProtected() {
+				return true
+			}
+			continue
+		}
+		if ok, err := path.Match(pattern, ref); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f PipelineFilter) String() string {
+	branches, statuses := "any branch", "any status"
+	if len(f.Branches) > 0 {
+		branches = "`" + strings.Join(f.Branches, ", ") + "`"
+	}
+	if len(f.Statuses) > 0 {
+		statuses = "`" + strings.Join(f.Statuses, ", ") + "`"
+	}
+	return fmt.Sprintf("branches: %s, statuses: %s", branches, statuses)
+}
+
+// formatFailedJobs lists the failed jobs of a pipeline by stage, in the
+// order the stages run.
+func formatFailedJobs(evt *gitlab.PipelineEvent) string {
+	failed := make(map[string][]string)
+	count := 0
+	for _, build := range evt.Builds {
+		if build.Status != "failed" {
+			continue
+		}
+		failed[build.Stage] = append(failed[build.Stage],
+			fmt.Sprintf("%s: %s/-/jobs/%d", build.Name, evt.Project.WebURL, build.ID))
+		count++
+	}
+	if count == 0 {
+		return ""
+	}
+	lines := []string{"Failed jobs:"}
+	listed := 0
+	for _, stage := range evt.ObjectAttributes.Stages {
+		for _, job := range failed[stage] {
+			if listed == maxFailedJobsListed {
+				return strings.Join(append(lines, fmt.Sprintf("- and %d more", count-listed)), "\n")
+			}
+			lines = append(lines, fmt.Sprintf("- *%s* › %s", stage, job))
+			listed++
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pipelineWanted applies the pipeline filter of a subscription to an event.
+func (h *HTTPSrv) pipelineWanted(evt *gitlab.PipelineEvent, sub Subscription) bool {
+	filter, err := h.db.GetPipelineFilter(sub.ConvID, sub.InstanceURL, sub.Repo)
+	if err != nil {
+		h.Errorf("Error getting pipeline filter: %s", err)
+		return true
+	}
+	if filter == nil {
+		return true
+	}
+	if !filter.matchesStatus(evt.ObjectAttributes.Status) {
+		return false
+	}
+	return filter.matchesBranch(evt.ObjectAttributes.Ref, func() bool {
+		if evt.ObjectAttributes.Tag {
+			return false
+		}
+		client, err := sub.Client()
+		if err != nil || client == nil {
+			return false
+		}
+		branch, _, err := client.Branches.GetBranch(sub.Repo, evt.ObjectAttributes.Ref)
+		if err != nil {
+			h.Debug("pipelineWanted: unable to get branch %s of %s: %s", evt.ObjectAttributes.Ref, sub.Repo, err)
+			return false
+		}
+		return branch.Protected
+	})
+}
+
+// parsePipelineFilterArgs splits `--branches` and `--status` flags from the
+// rest of args.
+func parsePipelineFilterArgs(args []string) (rest []string, filter PipelineFilter, ok bool) {
+	rest, values, ok := parseListFlags(args, "--branches", "--status")
+	if !ok {
+		return nil, filter, false
+	}
+	filter.Branches = values["--branches"]
+	for _, status := range values["--status"] {
+		if status == "cancelled" {
+			status = "canceled"
+		}
+		if !isOneOf(status, pipelineStatuses) {
+			return nil, filter, false
+		}
+		filter.Statuses = append(filter.Statuses, status)
+	}
+	return rest, filter, true
+}
+
+// subscriptionFromArgs finds the subscription of the conversation to the
+// project named by arg, letting the sender know when there is none.
+func (h *Handler) subscriptionFromArgs(msg chat1.MsgSummary, arg string) (*Subscription, error) {
+	hostedURL, repo, err := parseRepoInput(arg)
+	if err != nil {
+		h.ChatEcho(msg.ConvID, "Invalid repo: %q, expected `<owner/repo>` or `https://domain.com/owner/repo`", repo)
+		return nil, nil
+	}
+	sub, err := h.db.GetSubscription(msg.ConvID, hostedURL, repo)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subscription: %s", err)
+	}
+	if sub == nil {
+		h.ChatEcho(msg.ConvID, "You aren't subscribed to updates for `%s`!\nSend this first: `!gitlab subscribe %s`", arg, arg)
+		return nil, nil
+	}
+	if sub.Group {
+		h.ChatEcho(msg.ConvID, "`%s` is a group, this needs a subscription to one of its projects.", arg)
+		return nil, nil
+	}
+	return sub, nil
+}
+
+func (h *Handler) isWriter(msg chat1.MsgSummary) (bool, error) {
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return false, fmt.Errorf("Error getting role status: %s", err)
+	}
+	if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to configure me!")
+	}
+	return isAllowed, nil
+}
+
+// handlePipelineFilter shows or sets which pipelines of a project are
+// announced, as `!gitlab pipeline filter <project> [--branches ...]
+// [--status ...]` or `!gitlab pipeline filter <project> off`.
+func (h *Handler) handlePipelineFilter(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	usage := "I don't understand! Try `!gitlab pipeline filter <project> --branches main,release/*,protected --status failed`, or `!gitlab pipeline filter <project> off`"
+	args, filter, ok := parsePipelineFilterArgs(toks[3:])
+	if !ok || len(args) < 1 || len(args) > 2 || (len(args) == 2 && args[1] != "off") {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	off := len(args) == 2
+	if off && (len(filter.Branches) > 0 || len(filter.Statuses) > 0) {
+		h.ChatEcho(msg.ConvID, usage)
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, args[0])
+	if err != nil || sub == nil {
+		return err
+	}
+
+	if !off && len(filter.Branches) == 0 && len(filter.Statuses) == 0 {
+		current, err := h.db.GetPipelineFilter(msg.ConvID, sub.InstanceURL, sub.Repo)
+		if err != nil {
+			return fmt.Errorf("error getting pipeline filter: %s", err)
+		}
+		if current == nil {
+			h.ChatEcho(msg.ConvID, "I announce every finished pipeline of `%s` here.", sub)
+			return nil
+		}
+		h.ChatEcho(msg.ConvID, "I announce pipelines of `%s` here for %s.", sub, current)
+		return nil
+	}
+
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	if off {
+		if err := h.db.DeletePipelineFilter(msg.ConvID, sub.InstanceURL, sub.Repo); err != nil {
+			return fmt.Errorf("error deleting pipeline filter: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I'll announce every finished pipeline of `%s` here.", sub)
+		return nil
+	}
+	for _, branch := range filter.Branches {
+		if _, err := path.Match(branch, ""); err != nil {
+			h.ChatEcho(msg.ConvID, "`%s` isn't a valid branch pattern!", branch)
+			return nil
+		}
+		if branch == protectedBranches && sub.APIToken == "" {
+			h.ChatEcho(msg.ConvID, "I need an access token to know which branches of `%s` are protected, subscribe again with `--token <access token>`.", sub)
+			return nil
+		}
+	}
+	if err := h.db.SetPipelineFilter(msg.ConvID, sub.InstanceURL, sub.Repo, filter); err != nil {
+		return fmt.Errorf("error setting pipeline filter: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I'll only announce pipelines of `%s` here for %s.", sub, filter)
+	return nil
+}
+
+// handlePipelineRetry retries the failed jobs of a pipeline with the token of
+// the subscription, as `!gitlab pipeline retry <project> <id>`.
+func (h *Handler) handlePipelineRetry(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[3:]
+	if len(args) != 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab pipeline retry <project> <pipeline id>`")
+		return nil
+	}
+	pipelineID, err := strconv.Atoi(strings.TrimPrefix(args[1], "#"))
+	if err != nil || pipelineID <= 0 {
+		h.ChatEcho(msg.ConvID, "`%s` doesn't look like a pipeline ID to me!", args[1])
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, args[0])
+	if err != nil || sub == nil {
+		return err
+	}
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	client, err := sub.Client()
+	if err != nil {
+		return fmt.Errorf("error making client: %s", err)
+	}
+	if client == nil {
+		h.ChatEcho(msg.ConvID, "I need an access token to retry pipelines of `%s`, subscribe again with `--token <access token>`.", sub)
+		return nil
+	}
+	pipeline, res, err := client.Pipelines.RetryPipelineBuild(sub.Repo, pipelineID)
+	if err != nil {
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusNotFound:
+				h.ChatEcho(msg.ConvID, "I couldn't find pipeline %d of `%s`!", pipelineID, sub)
+				return nil
+			case http.StatusUnauthorized, http.StatusForbidden:
+				h.ChatEcho(msg.ConvID, "The token of `%s` isn't allowed to retry pipelines, it needs the `api` scope and at least the Developer role.", sub)
+				return nil
+			}
+		}
+		return fmt.Errorf("error retrying pipeline: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, retrying the failed jobs of pipeline %d of `%s`.\n%s",
+		pipelineID, sub, pipeline.WebURL)
+	return nil
+}
@@ -0,0 +1,162 @@
+package gitlabbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+	"github.com/keybase/managed-bots/base/git"
+)
+
+// go-gitlab doesn't know this event yet, so it's parsed here
+const releaseHook gitlab.EventType = "Release Hook"
+
+const (
+	// release notes lines quoted in announcements
+	maxReleaseNotesLines = 15
+	maxReleaseNotesLen   = 1500
+	maxTagMessageLen     = 200
+	// releases `!gitlab releases` lists
+	maxReleasesListed = 5
+	// the SHA of a tag push which creates or deletes the tag
+	zeroSHA = "0000000000000000000000000000000000000000"
+)
+
+type releaseEvent struct {
+	ObjectKind  string `json:"object_kind"`
+	Action      string `json:"action"`
+	Name        string `json:"name"`
+	Tag         string `json:"tag"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+	Project     struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+}
+
+func parseReleaseEvent(payload []byte) (*releaseEvent, error) {
+	var evt releaseEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+// formatReleaseNotes quotes the start of release notes, keeping their lines.
+func formatReleaseNotes(notes string) string {
+	notes = strings.TrimSpace(strings.Replace(notes, "\r\n", "\n", -1))
+	if notes == "" {
+		return ""
+	}
+	truncated := false
+	if runes := []rune(notes); len(runes) > maxReleaseNotesLen {
+		notes, truncated = string(runes[:maxReleaseNotesLen]), true
+	}
+	lines := strings.Split(notes, "\n")
+	if len(lines) > maxReleaseNotesLines {
+		lines, truncated = lines[:maxReleaseNotesLines], true
+	}
+	if truncated {
+		lines = append(lines, "…")
+	}
+	return "> " + strings.Join(lines, "\n> ")
+}
+
+func formatReleaseMsg(evt *releaseEvent) string {
+	if evt.Action != "create" {
+		return ""
+	}
+	res := fmt.Sprintf(":package: New release *%s* of %s", evt.Tag, evt.Project.PathWithNamespace)
+	if evt.Name != "" && evt.Name != evt.Tag {
+		res += fmt.Sprintf(": “%s”", evt.Name)
+	}
+	if notes := formatReleaseNotes(evt.Description); notes != "" {
+		res += "\n" + notes
+	}
+	return res + "\n" + evt.URL
+}
+
+func formatTagMsg(evt *gitlab.TagEvent) string {
+	tag := strings.TrimPrefix(evt.Ref, "refs/tags/")
+	repo := evt.Project.PathWithNamespace
+	switch {
+	case evt.After == zeroSHA:
+		return fmt.Sprintf(":wastebasket: %s deleted tag *%s* of %s.", evt.UserName, tag, repo)
+	case evt.Before == zeroSHA:
+		res := fmt.Sprintf(":label: %s pushed tag *%s* to %s\n", evt.UserName, tag, repo)
+		if message := git.FormatExcerpt(evt.Message, maxTagMessageLen); message != "" {
+			res += message + "\n"
+		}
+		return res + fmt.Sprintf("%s/-/tags/%s", evt.Project.WebURL, url.PathEscape(tag))
+	default:
+		sha := evt.After
+		if len(sha) > 8 {
+			sha = sha[:8]
+		}
+		return fmt.Sprintf(":label: %s moved tag *%s* of %s to `%s`.", evt.UserName, tag, repo, sha)
+	}
+}
+
+// handleReleases lists the latest releases of a project with the access
+// token of the subscription, as `!gitlab releases <project>`.
+func (h *Handler) handleReleases(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) != 1 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab releases <project>`")
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, args[0])
+	if err != nil || sub == nil {
+		return err
+	}
+	client, err := sub.Client()
+	if err != nil {
+		return fmt.Errorf("error making client: %s", err)
+	}
+	if client == nil {
+		h.ChatEcho(msg.ConvID, "I need an access token to look up releases of `%s`, subscribe again with `--token <access token>`.", sub)
+		return nil
+	}
+	releases, res, err := client.Releases.ListReleases(sub.Repo, &gitlab.ListReleasesOptions{PerPage: maxReleasesListed})
+	if err != nil {
+		if res != nil && (res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusForbidden) {
+			h.ChatEcho(msg.ConvID, "The token of `%s` can't see its releases.", sub)
+			return nil
+		}
+		return fmt.Errorf("error listing releases: %s", err)
+	}
+	if len(releases) == 0 {
+		h.ChatEcho(msg.ConvID, "`%s` has no releases yet.", sub)
+		return nil
+	}
+	items := make([]string, 0, len(releases))
+	for _, release := range releases {
+		item := "- *" + release.TagName + "*"
+		if release.Name != "" && release.Name != release.TagName {
+			item += fmt.Sprintf(" “%s”", release.Name)
+		}
+		if release.CreatedAt != nil {
+			item += " on " + release.CreatedAt.UTC().Format("Jan 2, 2006")
+		}
+		item += fmt.Sprintf(": %s/%s/-/releases/%s", sub.InstanceURL, sub.Repo, url.PathEscape(release.TagName))
+		items = append(items, item)
+	}
+	return h.pager.Send(msg.ConvID, base.PagedList{
+		Header: fmt.Sprintf("Latest releases of `%s`:", sub),
+		Items:  items,
+	})
+}
@@ -0,0 +1,319 @@
+package gitlabbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	defaultReviewThreshold = 48 * time.Hour
+	// how many merge requests a reminder lists by name
+	maxReviewMRs = 15
+)
+
+// reviewMR is a merge request with its reviewers, which go-gitlab doesn't
+// know yet.
+type reviewMR struct {
+	gitlab.MergeRequest
+	Reviewers []*gitlab.BasicUser `json:"reviewers"`
+}
+
+type waitingMR struct {
+	mr        *reviewMR
+	reviewers []string
+}
+
+// ReviewReminderScheduler reminds conversations, or the linked reviewers, of
+// merge requests which have been waiting for a review longer than the
+// subscription's threshold, see `!gitlab reminders`.
+type ReviewReminderScheduler struct {
+	*base.DebugOutput
+
+	stats *base.StatsRegistry
+	kbc   *kbchat.API
+	db    *DB
+	sends *base.ChatSendQueue
+}
+
+func NewReviewReminderScheduler(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig,
+	db *DB, sends *base.ChatSendQueue) *ReviewReminderScheduler {
+	return &ReviewReminderScheduler{
+		DebugOutput: base.NewDebugOutput("ReviewReminderScheduler", debugConfig),
+		stats:       stats.SetPrefix("ReviewReminderScheduler"),
+		kbc:         kbc,
+		db:          db,
+		sends:       sends,
+	}
+}
+
+// Task sends the reminders on weekday mornings, US time.
+func (s *ReviewReminderScheduler) Task() base.Task {
+	return base.Task{
+		Name:     "review-reminders",
+		Schedule: "0 15 * * 1-5",
+		Jitter:   10 * time.Minute,
+		Run:      s.sendReminders,
+	}
+}
+
+func (s *ReviewReminderScheduler) sendReminders(ctx context.Context) error {
+	reminders, err := s.db.GetReviewReminders()
+	if err != nil {
+		return fmt.Errorf("error getting review reminders: %s", err)
+	}
+	// subscriptions of the same project with the same token share one listing
+	cache := make(map[string][]*reviewMR)
+	for _, reminder := range reminders {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if reminder.APIToken == "" {
+			continue
+		}
+		key := reminder.InstanceURL + "/" + reminder.Repo + ":" + reminder.APIToken
+		mrs, ok := cache[key]
+		if !ok {
+			if mrs, err = s.listOpenMRs(reminder.Subscription); err != nil {
+				s.stats.Count("sendReminders - list error")
+				s.Debug("sendReminders: unable to list merge requests for %s: %s", reminder.Subscription, err)
+				continue
+			}
+			cache[key] = mrs
+		}
+		s.remind(reminder, waitingMRs(mrs, reminder.Threshold))
+	}
+	return nil
+}
+
+func (s *ReviewReminderScheduler) listOpenMRs(sub Subscription) (all []*reviewMR, err error) {
+	client, err := sub.Client()
+	if err != nil {
+		return nil, err
+	}
+	opts := &gitlab.ListProjectMergeRequestsOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		State:       gitlab.String("opened"),
+		WIP:         gitlab.String("no"),
+		OrderBy:     gitlab.String("created_at"),
+		Sort:        gitlab.String("asc"),
+	}
+	// escaped like go-gitlab escapes project paths
+	project := strings.Replace(url.PathEscape(sub.Repo), ".", "%2E", -1)
+	for {
+		req, err := client.NewRequest(http.MethodGet, "projects/"+project+"/merge_requests", opts, nil)
+		if err != nil {
+			return nil, err
+		}
+		var mrs []*reviewMR
+		res, err := client.Do(req, &mrs)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, mrs...)
+		if res.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = res.NextPage
+	}
+}
+
+// waitingMRs picks the merge requests older than threshold which have
+// reviewers.
+func waitingMRs(mrs []*reviewMR, threshold time.Duration) (res []waitingMR) {
+	cutoff := time.Now().Add(-threshold)
+	for _, mr := range mrs {
+		if mr.WorkInProgress || mr.CreatedAt == nil || !mr.CreatedAt.Before(cutoff) {
+			continue
+		}
+		var reviewers []string
+		for _, user := range mr.Reviewers {
+			reviewers = append(reviewers, user.Username)
+		}
+		if len(reviewers) == 0 {
+			continue
+		}
+		res = append(res, waitingMR{mr: mr, reviewers: reviewers})
+	}
+	return res
+}
+
+func (s *ReviewReminderScheduler) remind(reminder ReviewReminder, mrs []waitingMR) {
+	if len(mrs) == 0 {
+		return
+	}
+	var channel []waitingMR
+	byReviewer := make(map[string][]waitingMR)
+	for _, mr := range mrs {
+		sent := false
+		if reminder.DM {
+			for _, reviewer := range mr.reviewers {
+				kbUsername, err := s.db.GetKeybaseUsername(reminder.InstanceURL, reviewer)
+				if err != nil {
+					s.Errorf("unable to look up Keybase user of %s: %s", reviewer, err)
+					continue
+				}
+				if kbUsername != "" {
+					byReviewer[kbUsername] = append(byReviewer[kbUsername], mr)
+					sent = true
+				}
+			}
+		}
+		if !sent {
+			// nobody to DM, fall back to the conversation
+			channel = append(channel, mr)
+		}
+	}
+	if len(channel) > 0 {
+		header := fmt.Sprintf("%s waiting for review >%s on %s:", pluralMRs(len(channel)),
+			formatReviewThreshold(reminder.Threshold), reminder.Subscription)
+		s.sends.Send(reminder.ConvID, "%s", formatWaitingMRs(header, channel, true))
+		s.stats.Count("remind - conversation")
+	}
+	usernames := make([]string, 0, len(byReviewer))
+	for kbUsername := range byReviewer {
+		usernames = append(usernames, kbUsername)
+	}
+	sort.Strings(usernames)
+	for _, kbUsername := range usernames {
+		reviews := byReviewer[kbUsername]
+		header := fmt.Sprintf("%s on %s waiting for your review >%s:", pluralMRs(len(reviews)), reminder.Subscription,
+			formatReviewThreshold(reminder.Threshold))
+		if _, err := s.kbc.SendMessageByTlfName(kbUsername, "%s", formatWaitingMRs(header, reviews, false)); err != nil {
+			s.Errorf("unable to send review reminder to %s: %s", kbUsername, err)
+			continue
+		}
+		s.stats.Count("remind - dm")
+	}
+}
+
+func pluralMRs(n int) string {
+	if n == 1 {
+		return "1 MR"
+	}
+	return fmt.Sprintf("%d MRs", n)
+}
+
+func formatWaitingMRs(header string, mrs []waitingMR, withReviewers bool) string {
+	lines := []string{header}
+	for index, mr := range mrs {
+		if index == maxReviewMRs {
+			lines = append(lines, fmt.Sprintf("- and %d more", len(mrs)-maxReviewMRs))
+			break
+		}
+		author := ""
+		if mr.mr.Author != nil {
+			author = mr.mr.Author.Username
+		}
+		line := fmt.Sprintf("- !%d “%s” by *%s* (%s)", mr.mr.IID, mr.mr.Title, author,
+			formatMRAge(time.Since(*mr.mr.CreatedAt)))
+		if withReviewers {
+			line += ", waiting on " + strings.Join(mr.reviewers, ", ")
+		}
+		lines = append(lines, line+"\n  "+mr.mr.WebURL)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseReviewThreshold parses thresholds like `48h` or `2d`.
+func parseReviewThreshold(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid threshold %q", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	threshold, err := time.ParseDuration(value)
+	if err != nil || threshold < time.Hour {
+		return 0, fmt.Errorf("invalid threshold %q", value)
+	}
+	return threshold.Truncate(time.Hour), nil
+}
+
+func formatReviewThreshold(threshold time.Duration) string {
+	if threshold >= 24*time.Hour && threshold%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", threshold/(24*time.Hour))
+	}
+	return fmt.Sprintf("%dh", threshold/time.Hour)
+}
+
+func formatMRAge(age time.Duration) string {
+	if age < 48*time.Hour {
+		return fmt.Sprintf("%dh", age/time.Hour)
+	}
+	return fmt.Sprintf("%dd", age/(24*time.Hour))
+}
+
+// handleReminders turns on reminders of merge requests waiting for review, as
+// `!gitlab reminders <project> [48h|2d|off] [--dm]`.
+func (h *Handler) handleReminders(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	var args []string
+	dm := false
+	for _, tok := range toks[2:] {
+		if tok == "--dm" {
+			dm = true
+		} else {
+			args = append(args, tok)
+		}
+	}
+	if len(args) < 1 || len(args) > 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab reminders <project> [48h|2d|off] [--dm]`")
+		return nil
+	}
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	sub, err := h.subscriptionFromArgs(msg, args[0])
+	if err != nil || sub == nil {
+		return err
+	}
+
+	if len(args) == 2 && args[1] == "off" {
+		if err := h.db.DeleteReviewReminder(msg.ConvID, sub.InstanceURL, sub.Repo); err != nil {
+			return fmt.Errorf("error deleting review reminders: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, you won't be reminded of merge requests waiting for review on `%s`.", sub)
+		return nil
+	}
+	if sub.APIToken == "" {
+		h.ChatEcho(msg.ConvID, "I need an access token to look up merge requests of `%s`, subscribe again with `--token <access token>`.", sub)
+		return nil
+	}
+	threshold := defaultReviewThreshold
+	if len(args) == 2 {
+		if threshold, err = parseReviewThreshold(args[1]); err != nil {
+			h.ChatEcho(msg.ConvID, "I don't understand `%s`! Try a threshold like `48h` or `2d`.", args[1])
+			return nil
+		}
+	}
+	if err := h.db.SetReviewReminder(msg.ConvID, sub.InstanceURL, sub.Repo, threshold, dm); err != nil {
+		return fmt.Errorf("error setting review reminders: %s", err)
+	}
+	if dm {
+		h.ChatEcho(msg.ConvID, "Okay, reviewers who linked their token with `!gitlab token` will be reminded of merge requests on `%s` waiting more than %s. Other reviewers are listed here.",
+			sub, formatReviewThreshold(threshold))
+	} else {
+		h.ChatEcho(msg.ConvID, "Okay, I'll post merge requests on `%s` waiting for review more than %s here on weekdays.",
+			sub, formatReviewThreshold(threshold))
+	}
+	return nil
+}
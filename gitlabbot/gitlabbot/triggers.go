@@ -0,0 +1,243 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// description of the trigger the bot creates with the subscription token
+const triggerDescription = "Keybase gitlabbot"
+
+var variableNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parsePipelineRunArgs splits `--ref <ref>` and each `--var KEY=value` from
+// the rest of args.
+func parsePipelineRunArgs(args []string) (rest []string, ref string, variables map[string]string, ok bool) {
+	variables = make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		flag, value := arg, ""
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 && strings.HasPrefix(arg, "--") {
+			flag, value = parts[0], parts[1]
+		}
+		if flag != "--ref" && flag != "--var" {
+			rest = append(rest, arg)
+			continue
+		}
+		if flag == arg {
+			if i+1 == len(args) {
+				return nil, "", nil, false
+			}
+			value = args[i+1]
+			i++
+		}
+		if flag == "--ref" {
+			ref = value
+			continue
+		}
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || !variableNameRE.MatchString(parts[0]) {
+			return nil, "", nil, false
+		}
+		variables[parts[0]] = parts[1]
+	}
+	return rest, ref, variables, true
+}
+
+// pipelineTrigger returns the trigger token of a subscription. Without one
+// it creates a trigger with the access token of the subscription, it returns
+// empty after telling the sender how to set one.
+func (h *Handler) pipelineTrigger(msg chat1.MsgSummary, sub *Subscription) (string, error) {
+	token, err := h.db.GetPipelineTrigger(sub.ConvID, sub.InstanceURL, sub.Repo)
+	if err != nil || token != "" {
+		return token, err
+	}
+	usage := fmt.Sprintf("Create a pipeline trigger token in the CI/CD settings of `%s` and send `!gitlab pipeline trigger %s <trigger token>` first.", sub, sub)
+	client, err := sub.Client()
+	if err != nil {
+		return "", fmt.Errorf("error making client: %s", err)
+	}
+	if client == nil {
+		h.ChatEcho(msg.ConvID, "%s", usage)
+		return "", nil
+	}
+	trigger, res, err := client.PipelineTriggers.AddPipelineTrigger(sub.Repo, &gitlab.AddPipelineTriggerOptions{
+		Description: gitlab.String(triggerDescription),
+	})
+	if err != nil {
+		if res != nil && (res.StatusCode == http.StatusForbidden || res.StatusCode == http.StatusUnauthorized) {
+			h.ChatEcho(msg.ConvID, "The token of `%s` can't create pipeline triggers, it needs the Maintainer role. %s", sub, usage)
+			return "", nil
+		}
+		return "", fmt.Errorf("error creating pipeline trigger: %s", err)
+	}
+	if err := h.db.SetPipelineTrigger(sub.ConvID, sub.InstanceURL, sub.Repo, trigger.Token); err != nil {
+		return "", fmt.Errorf("error setting pipeline trigger: %s", err)
+	}
+	return trigger.Token, nil
+}
+
+// handlePipelineTrigger sets the trigger token `!gitlab pipeline run` uses
+// for a project, as `!gitlab pipeline trigger <project> <trigger token|off>`.
+func (h *Handler) handlePipelineTrigger(msg chat1.MsgSummary) error {
+	// tokens are case sensitive
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	if len(args) != 2 {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab pipeline trigger <project> <trigger token>`, or `off` instead of the token to forget it")
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, strings.ToLower(args[0]))
+	if err != nil || sub == nil {
+		return err
+	}
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	if strings.ToLower(args[1]) == "off" {
+		if err := h.db.DeletePipelineTrigger(msg.ConvID, sub.InstanceURL, sub.Repo); err != nil {
+			return fmt.Errorf("error deleting pipeline trigger: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I forgot the trigger token of `%s`.", sub)
+		return nil
+	}
+	if err := h.db.SetPipelineTrigger(msg.ConvID, sub.InstanceURL, sub.Repo, args[1]); err != nil {
+		return fmt.Errorf("error setting pipeline trigger: %s", err)
+	}
+	reply := fmt.Sprintf("Okay, I'll run pipelines of `%s` with that trigger token.", sub)
+	if !base.IsDirectPrivateMessage(h.kbc.GetUsername(), msg.Sender.Username, msg.Channel) {
+		reply += " Heads up, everyone here can read it, you may want to delete your message."
+	}
+	h.ChatEcho(msg.ConvID, "%s", reply)
+	return nil
+}
+
+// handlePipelineRun starts a pipeline with the trigger token of a project, as
+// `!gitlab pipeline run <project> --ref <ref> [--var KEY=value]`, and replies
+// to the command once the pipeline finishes.
+func (h *Handler) handlePipelineRun(msg chat1.MsgSummary) error {
+	// refs and variables are case sensitive
+	args, ok, err := h.commandArgs(msg, 3)
+	if err != nil || !ok {
+		return err
+	}
+	args, ref, variables, ok := parsePipelineRunArgs(args)
+	if !ok || len(args) != 1 || ref == "" {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab pipeline run <project> --ref main [--var DEPLOY_ENV=prod]`")
+		return nil
+	}
+	sub, err := h.subscriptionFromArgs(msg, strings.ToLower(args[0]))
+	if err != nil || sub == nil {
+		return err
+	}
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	token, err := h.pipelineTrigger(msg, sub)
+	if err != nil || token == "" {
+		return err
+	}
+	client, err := newClient(sub.InstanceURL, sub.APIToken)
+	if err != nil {
+		return fmt.Errorf("error making client: %s", err)
+	}
+	opts := &gitlab.RunPipelineTriggerOptions{
+		Ref:   gitlab.String(ref),
+		Token: gitlab.String(token),
+	}
+	if len(variables) > 0 {
+		opts.Variables = variables
+	}
+	pipeline, res, err := client.PipelineTriggers.RunPipelineTrigger(sub.Repo, opts)
+	if err != nil {
+		if res != nil {
+			switch res.StatusCode {
+			case http.StatusNotFound, http.StatusUnauthorized, http.StatusForbidden:
+				h.reply(msg, "GitLab rejected the trigger token of `%s`, set a new one with `!gitlab pipeline trigger %s <trigger token>`.", sub, sub)
+				return nil
+			case http.StatusBadRequest, http.StatusUnprocessableEntity:
+				h.reply(msg, "GitLab didn't start a pipeline: %s", err)
+				return nil
+			}
+		}
+		return fmt.Errorf("error running pipeline: %s", err)
+	}
+	if err := h.db.PutPipelineRun(sub.InstanceURL, sub.Repo, pipeline.ID, PipelineRun{
+		ConvID:   msg.ConvID,
+		MsgID:    msg.Id,
+		Username: msg.Sender.Username,
+	}); err != nil {
+		return fmt.Errorf("error storing pipeline run: %s", err)
+	}
+	reply := fmt.Sprintf("Started pipeline %d of `%s` on `%s`", pipeline.ID, sub, ref)
+	if len(variables) > 0 {
+		names := make([]string, 0, len(variables))
+		for name := range variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		reply += " with " + formatFilterList(names, "")
+	}
+	h.reply(msg, "%s, I'll reply here when it finishes.\n%s", reply, pipeline.WebURL)
+	return nil
+}
+
+func formatPipelineRunResult(evt *gitlab.PipelineEvent, run *PipelineRun) string {
+	var icon, result string
+	switch evt.ObjectAttributes.Status {
+	case "success":
+		icon, result = ":white_check_mark:", "passed"
+	case "failed":
+		icon, result = ":x:", "failed"
+	case "canceled":
+		icon, result = ":warning:", "was canceled"
+	case "skipped":
+		icon, result = ":warning:", "was skipped"
+	default:
+		return ""
+	}
+	res := fmt.Sprintf("%s @%s, pipeline %d of %s on `%s` %s.\n%s/pipelines/%d", icon, run.Username,
+		evt.ObjectAttributes.ID, evt.Project.PathWithNamespace, evt.ObjectAttributes.Ref, result,
+		evt.Project.WebURL, evt.ObjectAttributes.ID)
+	if evt.ObjectAttributes.Status == "failed" {
+		if jobs := formatFailedJobs(evt); jobs != "" {
+			res += "\n" + jobs
+		}
+	}
+	return res
+}
+
+// followUpPipelineRun replies to the command which started a finished
+// pipeline, if the webhook was verified for its conversation.
+func (h *HTTPSrv) followUpPipelineRun(evt *gitlab.PipelineEvent, instanceURL, repo string, verified map[chat1.ConvIDStr]bool) {
+	run, err := h.db.GetPipelineRun(instanceURL, repo, evt.ObjectAttributes.ID)
+	if err != nil {
+		h.Errorf("Error getting pipeline run: %s", err)
+		return
+	}
+	if run == nil || !verified[run.ConvID] {
+		return
+	}
+	message := formatPipelineRunResult(evt, run)
+	if message == "" {
+		return
+	}
+	if err := h.db.DeletePipelineRun(instanceURL, repo, evt.ObjectAttributes.ID); err != nil {
+		h.Errorf("Error deleting pipeline run: %s", err)
+		return
+	}
+	if _, err := h.kbc.SendReplyByConvID(run.ConvID, &run.MsgID, "%s", message); err != nil {
+		h.Debug("followUpPipelineRun: unable to reply in thread: %s", err)
+		h.sends.Send(run.ConvID, message)
+	}
+}
@@ -0,0 +1,226 @@
+package gitlabbot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+const (
+	// unfurlSetting turns link previews off for a conversation
+	unfurlSetting = "unfurl"
+	// how many links of one message get a preview
+	maxUnfurls = 3
+)
+
+// links on any instance, older instances don't have the `-/` separator
+var gitlabLinkRE = regexp.MustCompile(`(https?://[\w.-]+(?::\d+)?)/((?:[\w.-]+/)+?[\w.-]+)/(?:-/)?(issues|merge_requests|commit)/([0-9a-fA-F]+)`)
+
+type gitlabLink struct {
+	instanceURL, repo, kind, id string
+}
+
+// findGitLabLinks returns the distinct issue, merge request and commit links
+// in text.
+func findGitLabLinks(text string) (links []gitlabLink) {
+	seen := make(map[gitlabLink]bool)
+	for _, match := range gitlabLinkRE.FindAllStringSubmatch(text, -1) {
+		link := gitlabLink{
+			instanceURL: strings.ToLower(match[1]),
+			repo:        strings.ToLower(match[2]),
+			kind:        match[3],
+			id:          match[4],
+		}
+		if link.kind != "commit" {
+			if _, err := strconv.Atoi(link.id); err != nil {
+				continue
+			}
+		} else if len(link.id) < 7 {
+			continue
+		}
+		if seen[link] {
+			continue
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+	return links
+}
+
+func formatLabels(labels gitlab.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" · %s", strings.Join(labels, ", "))
+}
+
+func formatIssuePreview(repo string, issue *gitlab.Issue) string {
+	author := ""
+	if issue.Author != nil {
+		author = issue.Author.Username
+	}
+	return fmt.Sprintf("*%s#%d* “%s”\n%s · opened by *%s*%s", repo, issue.IID, issue.Title, issue.State,
+		author, formatLabels(issue.Labels))
+}
+
+func formatMergeRequestPreview(repo string, mr *gitlab.MergeRequest) string {
+	state := mr.State
+	if mr.WorkInProgress && state == "opened" {
+		state = "draft"
+	}
+	author := ""
+	if mr.Author != nil {
+		author = mr.Author.Username
+	}
+	res := fmt.Sprintf("*%s!%d* “%s”\n%s · opened by *%s* · %s ← %s", repo, mr.IID, mr.Title, state, author,
+		mr.TargetBranch, mr.SourceBranch)
+	switch {
+	case mr.HeadPipeline != nil:
+		res += " · pipeline " + mr.HeadPipeline.Status
+	case mr.Pipeline != nil:
+		res += " · pipeline " + mr.Pipeline.Status
+	}
+	return res + formatLabels(mr.Labels)
+}
+
+func formatCommitPreview(repo string, commit *gitlab.Commit) string {
+	res := fmt.Sprintf("*%s@%s* “%s”\nby *%s*", repo, commit.ShortID, commit.Title, commit.AuthorName)
+	if commit.Stats != nil {
+		res += fmt.Sprintf(" · +%d -%d", commit.Stats.Additions, commit.Stats.Deletions)
+	}
+	if commit.LastPipeline != nil {
+		res += " · pipeline " + commit.LastPipeline.Status
+	}
+	return res
+}
+
+// linkSubscription returns the subscription of the conversation which covers
+// the project of a link, directly or through a group, nil if there is none.
+func (h *Handler) linkSubscription(convID chat1.ConvIDStr, link gitlabLink) (*Subscription, error) {
+	sub, err := h.db.GetSubscription(convID, link.instanceURL, link.repo)
+	if err != nil || (sub != nil && !sub.Group) {
+		return sub, err
+	}
+	sub, err = h.groupSubscriptionFor(convID, link.instanceURL, link.repo)
+	if err != nil || sub == nil || isOneOf(link.repo, sub.Excludes) {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// handleUnfurl replies to messages with links to issues, merge requests or
+// commits of projects the conversation is subscribed to with a preview.
+func (h *Handler) handleUnfurl(msg chat1.MsgSummary) error {
+	links := findGitLabLinks(msg.Content.Text.Body)
+	if len(links) == 0 {
+		return nil
+	}
+	enabled, err := h.settings.GetBool(msg.ConvID, unfurlSetting, true)
+	if err != nil {
+		return fmt.Errorf("error getting unfurl setting: %s", err)
+	}
+	if !enabled {
+		return nil
+	}
+	var previews []string
+	for _, link := range links {
+		if len(previews) == maxUnfurls {
+			break
+		}
+		// only subscribed projects, so private ones don't leak elsewhere
+		sub, err := h.linkSubscription(msg.ConvID, link)
+		if err != nil {
+			return fmt.Errorf("error getting subscription: %s", err)
+		}
+		if sub == nil {
+			continue
+		}
+		preview, err := h.linkPreview(sub.InstanceURL, sub.APIToken, link)
+		if err != nil {
+			h.Debug("handleUnfurl: unable to preview %s: %s", link.repo, err)
+			continue
+		}
+		previews = append(previews, preview)
+	}
+	if len(previews) == 0 {
+		return nil
+	}
+	h.stats.Count("unfurl")
+	if _, err := h.kbc.SendReplyByConvID(msg.ConvID, &msg.Id, "%s", strings.Join(previews, "\n\n")); err != nil {
+		if err := base.GetNonFatalChatError(err); err != nil {
+			h.Debug("handleUnfurl: unable to send: %s", err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// linkPreview looks the link up with the token of the subscription, public
+// projects subscribed without one are looked up anonymously.
+func (h *Handler) linkPreview(instanceURL, token string, link gitlabLink) (string, error) {
+	client, err := newClient(instanceURL, token)
+	if err != nil {
+		return "", err
+	}
+	if link.kind == "commit" {
+		commit, _, err := client.Commits.GetCommit(link.repo, link.id)
+		if err != nil {
+			return "", err
+		}
+		return formatCommitPreview(link.repo, commit), nil
+	}
+	iid, err := strconv.Atoi(link.id)
+	if err != nil {
+		return "", err
+	}
+	if link.kind == "merge_requests" {
+		mr, _, err := client.MergeRequests.GetMergeRequest(link.repo, iid, nil)
+		if err != nil {
+			return "", err
+		}
+		return formatMergeRequestPreview(link.repo, mr), nil
+	}
+	issue, _, err := client.Issues.GetIssue(link.repo, iid)
+	if err != nil {
+		return "", err
+	}
+	return formatIssuePreview(link.repo, issue), nil
+}
+
+// handleUnfurlPref turns link previews on or off, as `!gitlab unfurl <on|off>`.
+func (h *Handler) handleUnfurlPref(cmd string, msg chat1.MsgSummary) error {
+	toks, userErr, err := base.SplitTokens(cmd)
+	if err != nil {
+		return err
+	} else if userErr != "" {
+		h.ChatEcho(msg.ConvID, userErr)
+		return nil
+	}
+	args := toks[2:]
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		h.ChatEcho(msg.ConvID, "I don't understand! Try `!gitlab unfurl on` or `!gitlab unfurl off`.")
+		return nil
+	}
+	if ok, err := h.isWriter(msg); err != nil || !ok {
+		return err
+	}
+	if args[0] == "on" {
+		if err := h.settings.Delete(msg.ConvID, unfurlSetting); err != nil {
+			return fmt.Errorf("error setting unfurl: %s", err)
+		}
+		h.ChatEcho(msg.ConvID, "Okay, I'll preview links to issues, merge requests and commits of projects subscribed to here.")
+		return nil
+	}
+	if err := h.settings.Set(msg.ConvID, unfurlSetting, false); err != nil {
+		return fmt.Errorf("error setting unfurl: %s", err)
+	}
+	h.ChatEcho(msg.ConvID, "Okay, I won't preview GitLab links here.")
+	return nil
+}
@@ -8,8 +8,6 @@ import (
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/xanzy/go-gitlab"
-
-	"github.com/keybase/managed-bots/base"
 )
 
 var repoRegex = regexp.MustCompile(`^[a-zA-Z0-9_\.-]*$`)
@@ -45,11 +43,19 @@ func formatPipelineMsg(evt *gitlab.PipelineEvent, username string) (res string)
 		return fmt.Sprintf(":white_check_mark: All tests passed for merge request #%d on %s.\n%s", mr.IID, repo, mr.URL)
 	case "failed":
 		if !isMergeRequest {
-			return fmt.Sprintf(":x: Tests failed for %s/%s.\n%s", repo, suite.Ref, pipelineURL)
+			res = fmt.Sprintf(":x: Tests failed for %s/%s.\n%s", repo, suite.Ref, pipelineURL)
+		} else {
+			mr := evt.MergeRequest
+			res = fmt.Sprintf(":x: Tests failed for merge request #%d on %s.\n%s", mr.IID, repo, mr.URL)
 		}
-
-		mr := evt.MergeRequest
-		return fmt.Sprintf(":x: Tests failed for merge request #%d on %s.\n%s", mr.IID, repo, mr.URL)
+		if jobs := formatFailedJobs(evt); jobs != "" {
+			res += "\n" + jobs
+		}
+		project := repo
+		if instanceURLFromWebURL(evt.Project.WebURL) != defaultInstanceURL {
+			project = evt.Project.WebURL
+		}
+		return res + fmt.Sprintf("\nRetry with `!gitlab pipeline retry %s %d`", project, suite.ID)
 	case "canceled":
 		if !isMergeRequest {
 			return fmt.Sprintf(":warning: Tests cancelled for %s/%s.\n%s", repo, suite.Ref, pipelineURL)
@@ -68,10 +74,10 @@ To configure your project to send notifications, go to %s/%s/hooks and add a new
 For “URL”, enter %s%s/gitlabbot/webhook%s.
 For “Secret Token”, enter %s%s%s.
 Remember to check all the triggers you would like me to update you on.
-Note that I currently support the following Webhook Events: Push, Issues, Merge Request, Pipeline
+Note that I currently support the following Webhook Events: Push, Tag Push, Issues, Merge Request, Pipeline, Deployment, Release, Wiki Page, Comments
 
 Happy coding!`,
-		hostedURL, repo, back, httpAddress, back, back, base.MakeSecret(repo, msg.ConvID, secret), back)
+		hostedURL, repo, back, httpAddress, back, back, webhookSecret(hostedURL, repo, msg.ConvID, secret), back)
 	return message
 }
 
@@ -112,3 +118,42 @@ func isValidArgs(args []string) bool {
 
 	return true
 }
+
+// parseListFlags splits the given flags, each taking a comma separated list
+// as `--flag a,b` or `--flag=a,b`, from the rest of args.
+func parseListFlags(args []string, flags ...string) (rest []string, values map[string][]string, ok bool) {
+	values = make(map[string][]string)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		flag, value := arg, ""
+		if parts := strings.SplitN(arg, "=", 2); len(parts) == 2 {
+			flag, value = parts[0], parts[1]
+		}
+		if !isOneOf(flag, flags) {
+			rest = append(rest, arg)
+			continue
+		}
+		if flag == arg {
+			if i+1 == len(args) {
+				return nil, nil, false
+			}
+			value = args[i+1]
+			i++
+		}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values[flag] = append(values[flag], item)
+			}
+		}
+	}
+	return rest, values, true
+}
+
+func isOneOf(item string, items []string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
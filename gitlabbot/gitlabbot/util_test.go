@@ -2,8 +2,10 @@ package gitlabbot
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/xanzy/go-gitlab"
 )
 
 func TestParseRepoInputWithURL(t *testing.T) {
@@ -90,3 +92,144 @@ func TestParseRepoInputInvalidNoHostname(t *testing.T) {
 	_, _, err := parseRepoInput(url)
 	require.Error(t, err)
 }
+
+func TestParsePipelineRunArgs(t *testing.T) {
+	rest, ref, variables, ok := parsePipelineRunArgs([]string{"owner/repo", "--ref", "main", "--var", "ENV=staging", "--var=DEBUG=a=b"})
+	require.True(t, ok)
+	require.Equal(t, []string{"owner/repo"}, rest)
+	require.Equal(t, "main", ref)
+	require.Equal(t, map[string]string{"ENV": "staging", "DEBUG": "a=b"}, variables)
+
+	rest, ref, variables, ok = parsePipelineRunArgs([]string{"owner/repo", "--ref=release/1.0"})
+	require.True(t, ok)
+	require.Equal(t, []string{"owner/repo"}, rest)
+	require.Equal(t, "release/1.0", ref)
+	require.Empty(t, variables)
+
+	// flags without a value and invalid variable names are rejected
+	for _, args := range [][]string{
+		{"owner/repo", "--ref"},
+		{"owner/repo", "--var"},
+		{"owner/repo", "--var", "ENV"},
+		{"owner/repo", "--var", "1ENV=staging"},
+		{"owner/repo", "--var=MY-ENV=staging"},
+	} {
+		_, _, _, ok = parsePipelineRunArgs(args)
+		require.False(t, ok, "%v", args)
+	}
+}
+
+func TestParsePipelineFilterArgs(t *testing.T) {
+	rest, filter, ok := parsePipelineFilterArgs([]string{"owner/repo", "--branches", "main, release/*", "--status=failed,cancelled"})
+	require.True(t, ok)
+	require.Equal(t, []string{"owner/repo"}, rest)
+	require.Equal(t, []string{"main", "release/*"}, filter.Branches)
+	require.Equal(t, []string{"failed", "canceled"}, filter.Statuses)
+
+	_, _, ok = parsePipelineFilterArgs([]string{"owner/repo", "--status", "broken"})
+	require.False(t, ok)
+	_, _, ok = parsePipelineFilterArgs([]string{"owner/repo", "--branches"})
+	require.False(t, ok)
+}
+
+func TestPipelineFilterMatches(t *testing.T) {
+	protected := func() bool { return true }
+	unprotected := func() bool { return false }
+
+	var filter PipelineFilter
+	require.True(t, filter.matchesStatus("success"))
+	require.True(t, filter.matchesBranch("feature", unprotected))
+
+	filter = PipelineFilter{Branches: []string{"main", "release/*", protectedBranches}, Statuses: []string{"failed"}}
+	require.True(t, filter.matchesStatus("failed"))
+	require.False(t, filter.matchesStatus("success"))
+	require.True(t, filter.matchesBranch("Main", unprotected))
+	require.True(t, filter.matchesBranch("release/1.0", unprotected))
+	require.False(t, filter.matchesBranch("feature", unprotected))
+	require.True(t, filter.matchesBranch("feature", protected))
+}
+
+func TestParseEventTypes(t *testing.T) {
+	events, unknown := parseEventTypes([]string{"MRs", "commits", "merge_requests"})
+	require.Empty(t, unknown)
+	require.Equal(t, []string{"mrs", "pushes"}, events)
+
+	events, unknown = parseEventTypes([]string{"wiki", "all"})
+	require.Empty(t, unknown)
+	require.Equal(t, []string{"deployments", "issues", "mrs", "pipelines", "pushes", "releases", "tags", "wiki"}, events)
+	require.False(t, isDefaultEventTypes(events))
+
+	events, unknown = parseEventTypes([]string{"all"})
+	require.Empty(t, unknown)
+	require.True(t, isDefaultEventTypes(events))
+
+	_, unknown = parseEventTypes([]string{"issues", "builds"})
+	require.Equal(t, "builds", unknown)
+}
+
+func TestSubscriptionWants(t *testing.T) {
+	issue := &gitlab.IssueEvent{}
+	issue.Labels = []gitlab.Label{{Name: "Bug"}}
+	push := &gitlab.PushEvent{}
+	wiki := &wikiPageEvent{}
+
+	// without filters every default event type is announced
+	sub := Subscription{}
+	require.True(t, sub.wants(issue))
+	require.True(t, sub.wants(push))
+	require.False(t, sub.wants(wiki))
+
+	sub = Subscription{Events: []string{"issues", "wiki"}, Labels: []string{"bug"}}
+	require.True(t, sub.wants(issue))
+	require.False(t, sub.wants(push))
+	require.True(t, sub.wants(wiki))
+
+	// labels only filter issues and merge requests
+	sub.Labels = []string{"feature"}
+	require.False(t, sub.wants(issue))
+	require.True(t, sub.wants(wiki))
+}
+
+func TestParseReviewThreshold(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"2d":   48 * time.Hour,
+		"36h":  36 * time.Hour,
+		"90m":  time.Hour,
+		"1h1s": time.Hour,
+	} {
+		threshold, err := parseReviewThreshold(value)
+		require.NoError(t, err, value)
+		require.Equal(t, expected, threshold, value)
+	}
+	for _, value := range []string{"0d", "-1d", "xd", "30m", "soon"} {
+		_, err := parseReviewThreshold(value)
+		require.Error(t, err, value)
+	}
+	require.Equal(t, "2d", formatReviewThreshold(48*time.Hour))
+	require.Equal(t, "36h", formatReviewThreshold(36*time.Hour))
+}
+
+func TestWaitingMRs(t *testing.T) {
+	old := time.Now().Add(-72 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	newMR := func(iid int, createdAt *time.Time, wip bool, reviewers ...string) *reviewMR {
+		mr := &reviewMR{}
+		mr.IID = iid
+		mr.CreatedAt = createdAt
+		mr.WorkInProgress = wip
+		for _, reviewer := range reviewers {
+			mr.Reviewers = append(mr.Reviewers, &gitlab.BasicUser{Username: reviewer})
+		}
+		return mr
+	}
+	res := waitingMRs([]*reviewMR{
+		newMR(1, &old, false, "alice", "bob"),
+		newMR(2, &recent, false, "alice"),
+		newMR(3, &old, true, "alice"),
+		newMR(4, &old, false),
+		newMR(5, nil, false, "alice"),
+	}, 48*time.Hour)
+	require.Len(t, res, 1)
+	require.Equal(t, 1, res[0].mr.IID)
+	require.Equal(t, []string{"alice", "bob"}, res[0].reviewers)
+}
@@ -0,0 +1,87 @@
+package gitlabbot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xanzy/go-gitlab"
+
+	"github.com/keybase/managed-bots/base/git"
+)
+
+const (
+	// commit messages and snippet comments quoted in announcements
+	maxWikiMessageLen    = 200
+	maxSnippetCommentLen = 300
+)
+
+// wikiPageEvent is the Wiki Page Hook with the version go-gitlab doesn't
+// parse yet.
+type wikiPageEvent struct {
+	ObjectKind string `json:"object_kind"`
+	User       struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	ObjectAttributes struct {
+		Title     string `json:"title"`
+		Message   string `json:"message"`
+		Slug      string `json:"slug"`
+		URL       string `json:"url"`
+		Action    string `json:"action"`
+		VersionID string `json:"version_id"`
+	} `json:"object_attributes"`
+}
+
+func parseWikiPageEvent(payload []byte) (*wikiPageEvent, error) {
+	var evt wikiPageEvent
+	if err := json.Unmarshal(payload, &evt); err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
+
+func formatWikiPageMsg(evt *wikiPageEvent) string {
+	attrs := evt.ObjectAttributes
+	var verb string
+	switch attrs.Action {
+	case "create":
+		verb = "created"
+	case "update":
+		verb = "updated"
+	case "delete":
+		verb = "deleted"
+	default:
+		return ""
+	}
+	res := fmt.Sprintf(":memo: %s %s wiki page “%s” of %s", evt.User.Username, verb, attrs.Title,
+		evt.Project.PathWithNamespace)
+	// the default commit message only repeats the action and title
+	if attrs.Message != "" && !strings.EqualFold(attrs.Message, attrs.Action+" "+attrs.Title) {
+		res += ":\n" + git.FormatExcerpt(attrs.Message, maxWikiMessageLen)
+	}
+	if attrs.Action == "delete" {
+		return res
+	}
+	res += "\n" + attrs.URL
+	if attrs.Action == "update" && attrs.VersionID != "" {
+		res += fmt.Sprintf("\nChanges: %s/diff?version_id=%s", attrs.URL, attrs.VersionID)
+	}
+	return res
+}
+
+// formatSnippetCommentMsg announces comments on project snippets, GitLab
+// doesn't send hooks for the snippets themselves.
+func formatSnippetCommentMsg(evt *gitlab.SnippetCommentEvent) string {
+	title := ""
+	if evt.Snippet != nil {
+		title = evt.Snippet.Title
+	}
+	return fmt.Sprintf(":speech_balloon: %s commented on snippet “%s” of %s:\n%s\n%s", evt.User.Username, title,
+		evt.Project.PathWithNamespace, git.FormatExcerpt(evt.ObjectAttributes.Note, maxSnippetCommentLen),
+		evt.ObjectAttributes.URL)
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -15,7 +13,6 @@ import (
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
@@ -58,13 +55,142 @@ Example:%s
 !gitlab subscribe keybase/client%s
 
 Subscribe to a self-hosted or enterprise project:%s
-!gitlab subscribe https://mywebsite.com/owner/repo%s`,
-		backs, backs, backs, backs)
+!gitlab subscribe https://mywebsite.com/owner/repo%s
+
+Give me an access token for commands that call the GitLab API:%s
+!gitlab subscribe https://mywebsite.com/owner/repo --token <access token>%s
+
+Only announce some events, or issues and merge requests with some labels:%s
+!gitlab subscribe keybase/client --events mrs,issues,pipelines,tags
+!gitlab subscribe keybase/client --labels bug,security%s
+
+Also announce wiki edits and snippet comments, which are off by default:%s
+!gitlab subscribe keybase/client --events all,wiki,snippets%s
+
+Follow every project of a group, including new ones:%s
+!gitlab subscribe keybase --group
+!gitlab subscribe keybase --group --token <maintainer access token>%s`,
+		backs, backs, backs, backs, backs, backs, backs, backs, backs, backs, backs, backs)
 
 	unsubExtended := fmt.Sprintf(`Disables updates from the provided GitLab project to this conversation.
 
 Example:%s
-!gitlab unsubscribe keybase/client%s`,
+!gitlab unsubscribe keybase/client%s
+
+Stop announcing some events, or stop filtering on some labels:%s
+!gitlab unsubscribe keybase/client --events pushes
+!gitlab unsubscribe keybase/client --labels bug%s`,
+		backs, backs, backs, backs)
+
+	pipelineFilterExtended := fmt.Sprintf(`Only announce pipelines of a project on some branches or with some results. `+"`protected`"+` matches protected branches, it needs a subscription with an access token.
+
+Examples:%s
+!gitlab pipeline filter keybase/client --branches main,release/* --status failed
+!gitlab pipeline filter keybase/client --branches protected
+!gitlab pipeline filter keybase/client off%s`,
+		backs, backs)
+
+	pipelineRetryExtended := fmt.Sprintf(`Retries the failed jobs of a pipeline, with the access token of the subscription.
+
+Example:%s
+!gitlab pipeline retry keybase/client 1234%s`,
+		backs, backs)
+
+	pipelineRunExtended := fmt.Sprintf(`Starts a pipeline with the trigger token of the project, and replies in a thread once it finishes. Without a trigger token I create one with the access token of the subscription.
+
+Examples:%s
+!gitlab pipeline run keybase/client --ref main
+!gitlab pipeline run keybase/client --ref main --var DEPLOY_ENV=prod%s`,
+		backs, backs)
+
+	pipelineTriggerExtended := fmt.Sprintf(`Sets the pipeline trigger token I use to run pipelines of a project, from its CI/CD settings.
+
+Examples:%s
+!gitlab pipeline trigger keybase/client <trigger token>
+!gitlab pipeline trigger keybase/client off%s`,
+		backs, backs)
+
+	releasesExtended := fmt.Sprintf(`Lists the latest releases of a project, with the access token of the subscription.
+
+Example:%s
+!gitlab releases keybase/client%s`,
+		backs, backs)
+
+	deploymentsExtended := fmt.Sprintf(`Lists the latest deployments of a project to an environment, with the access token of the subscription.
+
+Example:%s
+!gitlab deployments keybase/client production%s`,
+		backs, backs)
+
+	deploymentsFilterExtended := fmt.Sprintf(`Only announce deployments of a project to some environments or with some results. Running deployments are only announced when asked for.
+
+Examples:%s
+!gitlab deployments filter keybase/client --environments production
+!gitlab deployments filter keybase/client --environments review/* --status running,success,failed
+!gitlab deployments filter keybase/client off%s`,
+		backs, backs)
+
+	boardExtended := fmt.Sprintf(`Announces issues of a project as they move into some lists of its issue boards, which are labels.
+
+Examples:%s
+!gitlab board keybase/client --lists Doing,Review
+!gitlab board keybase/client
+!gitlab board keybase/client off%s`,
+		backs, backs)
+
+	issueCreateExtended := fmt.Sprintf(`Files an issue as you, with the personal access token you linked with `+"`!gitlab token`"+`. Quick actions in the description are applied by GitLab.
+
+Examples:%s
+!gitlab issue create keybase/client "Login is down" Users see a 500 on sign in
+!gitlab issue create keybase/client "Login is down" /label ~incident ~p1 /assign @alice%s`,
+		backs, backs)
+
+	mrApproveExtended := fmt.Sprintf(`Approves a merge request as you, with the personal access token you linked with `+"`!gitlab token`"+`.
+
+Example:%s
+!gitlab mr approve keybase/client!42%s`,
+		backs, backs)
+
+	mrMergeExtended := fmt.Sprintf(`Merges a merge request as you, now or once its pipeline succeeds.
+
+Examples:%s
+!gitlab mr merge keybase/client!42
+!gitlab mr merge keybase/client!42 --when-pipeline-succeeds%s`,
+		backs, backs)
+
+	groupExcludeExtended := fmt.Sprintf(`Stops announcing a project of a group this conversation is subscribed to, `+"`!gitlab group include`"+` announces it again.
+
+Examples:%s
+!gitlab group exclude keybase/sandbox
+!gitlab group include keybase/sandbox%s`,
+		backs, backs)
+
+	remindersExtended := fmt.Sprintf(`Reminds this conversation on weekdays of merge requests with reviewers which have been open longer than a threshold, 48h by default. With `+"`--dm`"+` reviewers who linked a token with `+"`!gitlab token`"+` are reminded in private instead. Needs a subscription with an access token.
+
+Examples:%s
+!gitlab reminders keybase/client
+!gitlab reminders keybase/client 2d --dm
+!gitlab reminders keybase/client off%s`,
+		backs, backs)
+
+	unfurlExtended := fmt.Sprintf(`Turns previews of links to issues, merge requests and commits of the projects subscribed to here on or off. They're on by default.
+
+Example:%s
+!gitlab unfurl off%s`,
+		backs, backs)
+
+	tokenExtended := fmt.Sprintf(`Links a personal access token with the `+"`api`"+` scope, so I can act as you on GitLab. Send it in a private message with me.
+
+Examples:%s
+!gitlab token <personal access token>
+!gitlab token https://gitlab.example.com <personal access token>
+!gitlab token off%s`,
+		backs, backs)
+
+	mentionsExtended := fmt.Sprintf(`Sends you GitLab comments which mention you in a private message, with an excerpt and a link. It needs your token, see `+"`!gitlab token`"+`, and only covers projects subscribed to in a conversation with me.
+
+Example:%s
+!gitlab mentions on%s`,
 		backs, backs)
 
 	cmds := []chat1.UserBotCommandInput{
@@ -72,7 +198,7 @@ Example:%s
 			Name:        "gitlab subscribe",
 			Description: "Enable updates from GitLab projects",
 			ExtendedDescription: &chat1.UserBotExtendedDescription{
-				Title:       `*!gitlab subscribe* <username/project>`,
+				Title:       `*!gitlab subscribe* <username/project> [--events <types>] [--labels <labels>] [--token <access token>] [--group]`,
 				DesktopBody: subExtended,
 				MobileBody:  subExtended,
 			},
@@ -86,6 +212,159 @@ Example:%s
 				MobileBody:  unsubExtended,
 			},
 		},
+		{
+			Name:        "gitlab pipeline filter",
+			Description: "Choose which pipelines are announced",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab pipeline filter* <username/project> [--branches <patterns>] [--status <statuses>]`,
+				DesktopBody: pipelineFilterExtended,
+				MobileBody:  pipelineFilterExtended,
+			},
+		},
+		{
+			Name:        "gitlab pipeline retry",
+			Description: "Retry the failed jobs of a pipeline",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab pipeline retry* <username/project> <pipeline id>`,
+				DesktopBody: pipelineRetryExtended,
+				MobileBody:  pipelineRetryExtended,
+			},
+		},
+		{
+			Name:        "gitlab pipeline run",
+			Description: "Run a pipeline",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab pipeline run* <username/project> --ref <ref> [--var <KEY=value>]`,
+				DesktopBody: pipelineRunExtended,
+				MobileBody:  pipelineRunExtended,
+			},
+		},
+		{
+			Name:        "gitlab pipeline trigger",
+			Description: "Set the trigger token pipelines are run with",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab pipeline trigger* <username/project> <trigger token|off>`,
+				DesktopBody: pipelineTriggerExtended,
+				MobileBody:  pipelineTriggerExtended,
+			},
+		},
+		{
+			Name:        "gitlab releases",
+			Description: "List the latest releases of a project",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab releases* <username/project>`,
+				DesktopBody: releasesExtended,
+				MobileBody:  releasesExtended,
+			},
+		},
+		{
+			Name:        "gitlab deployments",
+			Description: "List recent deployments to an environment",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab deployments* <username/project> <environment>`,
+				DesktopBody: deploymentsExtended,
+				MobileBody:  deploymentsExtended,
+			},
+		},
+		{
+			Name:        "gitlab deployments filter",
+			Description: "Choose which deployments are announced",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab deployments filter* <username/project> [--environments <patterns>] [--status <statuses>]`,
+				DesktopBody: deploymentsFilterExtended,
+				MobileBody:  deploymentsFilterExtended,
+			},
+		},
+		{
+			Name:        "gitlab board",
+			Description: "Announce issues moving between board lists",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab board* <username/project> [--lists <lists>|off]`,
+				DesktopBody: boardExtended,
+				MobileBody:  boardExtended,
+			},
+		},
+		{
+			Name:        "gitlab issue create",
+			Description: "File an issue",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab issue create* <username/project> "title" [description]`,
+				DesktopBody: issueCreateExtended,
+				MobileBody:  issueCreateExtended,
+			},
+		},
+		{
+			Name:        "gitlab mr approve",
+			Description: "Approve a merge request",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab mr approve* <username/project>!<iid>`,
+				DesktopBody: mrApproveExtended,
+				MobileBody:  mrApproveExtended,
+			},
+		},
+		{
+			Name:        "gitlab mr merge",
+			Description: "Merge a merge request",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab mr merge* <username/project>!<iid> [--when-pipeline-succeeds]`,
+				DesktopBody: mrMergeExtended,
+				MobileBody:  mrMergeExtended,
+			},
+		},
+		{
+			Name:        "gitlab group exclude",
+			Description: "Skip a project of a subscribed group",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab group exclude* <username/project>`,
+				DesktopBody: groupExcludeExtended,
+				MobileBody:  groupExcludeExtended,
+			},
+		},
+		{
+			Name:        "gitlab group include",
+			Description: "Announce an excluded project of a subscribed group again",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab group include* <username/project>`,
+				DesktopBody: groupExcludeExtended,
+				MobileBody:  groupExcludeExtended,
+			},
+		},
+		{
+			Name:        "gitlab reminders",
+			Description: "Remind reviewers of waiting merge requests",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab reminders* <username/project> [48h|2d|off] [--dm]`,
+				DesktopBody: remindersExtended,
+				MobileBody:  remindersExtended,
+			},
+		},
+		{
+			Name:        "gitlab unfurl",
+			Description: "Turn previews of GitLab links pasted here on or off",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab unfurl* <on|off>`,
+				DesktopBody: unfurlExtended,
+				MobileBody:  unfurlExtended,
+			},
+		},
+		{
+			Name:        "gitlab token",
+			Description: "Link your GitLab personal access token",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab token* [instance url] <personal access token>`,
+				DesktopBody: tokenExtended,
+				MobileBody:  tokenExtended,
+			},
+		},
+		{
+			Name:        "gitlab mentions",
+			Description: "Forward GitLab comments mentioning you to your private messages",
+			ExtendedDescription: &chat1.UserBotExtendedDescription{
+				Title:       `*!gitlab mentions* <on|off>`,
+				DesktopBody: mentionsExtended,
+				MobileBody:  mentionsExtended,
+			},
+		},
 		{
 			Name:        "gitlab list",
 			Description: "Lists all your project subscriptions, woot!",
@@ -107,12 +386,12 @@ func (s *BotServer) getConfig() (webhookSecret string, err error) {
 	if s.opts.WebhookSecret != "" {
 		return s.opts.WebhookSecret, nil
 	}
-	path := fmt.Sprintf("/keybase/private/%s/credentials.json", s.kbc.GetUsername())
-	cmd := s.opts.Command("fs", "read", path)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", path)
-	if err := cmd.Run(); err != nil {
+	secrets, err := s.opts.SecretsProvider(fmt.Sprintf("/keybase/private/%s", s.kbc.GetUsername()))
+	if err != nil {
+		return "", err
+	}
+	out, err := secrets.GetSecret("credentials.json")
+	if err != nil {
 		return "", err
 	}
 
@@ -120,7 +399,7 @@ func (s *BotServer) getConfig() (webhookSecret string, err error) {
 		WebhookSecret string `json:"webhook_secret"`
 	}
 
-	if err := json.Unmarshal(out.Bytes(), &j); err != nil {
+	if err := json.Unmarshal(out, &j); err != nil {
 		return "", err
 	}
 
@@ -128,16 +407,19 @@ func (s *BotServer) getConfig() (webhookSecret string, err error) {
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
+	s.Configure(s.opts.Options)
 
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := gitlabbot.NewDB(sdb)
 
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
@@ -152,14 +434,57 @@ func (s *BotServer) Go() (err error) {
 		return err
 	}
 	stats = stats.SetPrefix(s.Name())
-	handler := gitlabbot.NewHandler(stats, s.kbc, debugConfig, db, s.opts.HTTPPrefix, secret)
-	httpSrv := gitlabbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, handler, secret)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	pager := base.NewPager(stats, debugConfig)
+	s.RegisterPager(pager)
+	settings := base.NewSettingsStore(db.DB)
+	handler := gitlabbot.NewHandler(stats, s.kbc, debugConfig, db, pager, settings, s.opts.HTTPPrefix, secret)
+	sends := base.NewChatSendQueue(stats, debugConfig)
+	broadcaster := base.NewBroadcaster(stats, debugConfig, db.DB, db.GetAllSubscribedConvs)
+	s.RegisterAdminCommands(broadcaster.AdminCommands()...)
+	convGC := base.NewConvGC(stats, debugConfig)
+	convGC.AddHook("subscriptions", db.DeleteConvData)
+	convGC.AddHook("settings", settings.DeleteAll)
+	s.RegisterAdminCommands(convGC.AdminCommands()...)
+	analytics := base.NewAnalytics(stats, debugConfig, db.DB)
+	s.RegisterAnalytics(analytics)
+	auditLog := base.NewAuditLog(stats, debugConfig, db.DB)
+	// access and trigger tokens aren't always long enough to be redacted
+	auditLog.RedactCommand("!gitlab subscribe")
+	auditLog.RedactCommand("!gitlab token")
+	auditLog.RedactCommand("!gitlab pipeline trigger")
+	s.RegisterAuditLog(auditLog)
+	s.RegisterAdminCommands(auditLog.AdminCommands()...)
+	leader := base.NewLeaderElector(stats, debugConfig, db.DB, s.Name())
+	s.RegisterAdminCommands(leader.AdminCommands()...)
+	scheduler := base.NewScheduler(stats, debugConfig)
+	scheduler.SetLeaderElector(leader)
+	reminders := gitlabbot.NewReviewReminderScheduler(stats, s.kbc, debugConfig, db, sends)
+	groupHooks := gitlabbot.NewGroupHookScheduler(stats, debugConfig, db, s.opts.HTTPPrefix, secret)
+	for _, task := range []base.Task{analytics.Task(), auditLog.Task(), reminders.Task(), groupHooks.Task()} {
+		if err := scheduler.Add(task); err != nil {
+			return err
+		}
+	}
+	s.RegisterAdminCommands(scheduler.AdminCommands()...)
+	httpSrv := gitlabbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, handler, sends, analytics, secret)
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.AddShutdowner(sends, broadcaster)
+	lc.Go(convGC.Run, convGC)
+	lc.Go(scheduler.Run, scheduler)
+	// registered after the scheduler so the lease is released once it stops
+	lc.Go(leader.Run, leader)
+	lc.Go(analytics.Run, analytics)
+	lc.Go(auditLog.Run, auditLog)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -0,0 +1,9 @@
+CREATE TABLE `identities` (
+  `keybase_username` varchar(128) NOT NULL,
+  `provider` varchar(32) NOT NULL,
+  `external_id` varchar(255) NOT NULL,
+  `verified` boolean NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`keybase_username`, `provider`),
+  UNIQUE KEY `provider_external_id` (`provider`, `external_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
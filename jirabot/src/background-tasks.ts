@@ -1,6 +1,8 @@
 import {Context} from './context'
 import logger from './logger'
 import * as Errors from './errors'
+import startJqlPoller from './jql-poller'
+import startSlaPoller from './sla-poller'
 
 const postStats = async (context: Context): Promise<void> => {
   const indicesRet = await context.configs.listAllJiraSubscriptionIndices()
@@ -22,4 +24,6 @@ const statInterval = 60 * 1000 // 1min
 export default (context: Context) => {
   postStats(context)
   setInterval(() => postStats(context), statInterval)
+  startJqlPoller(context)
+  startSlaPoller(context)
 }
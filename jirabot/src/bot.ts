@@ -3,13 +3,28 @@ import * as Message from './message'
 import * as Errors from './errors'
 import CmdSearch from './cmd-search'
 import CmdComment from './cmd-comment'
-import CmdAuth from './cmd-auth'
+import CmdMove from './cmd-move'
+import CmdAssign from './cmd-assign'
+import CmdWatch from './cmd-watch'
+import CmdLog, {timesheet as CmdTimesheet} from './cmd-log'
+import CmdApproval from './cmd-approval'
+import CmdBulk, {confirm as CmdBulkConfirm} from './cmd-bulk'
+import CmdBacklog from './cmd-backlog'
+import CmdBoard from './cmd-board'
+import CmdAuth, {handleCredentials} from './cmd-auth'
 import reacji from './reacji'
 import CmdNew from './cmd-new'
+import CmdCreate, {answer as CmdCreateAnswer} from './cmd-create'
 import CmdConfig from './cmd-config'
 import CmdFeed from './cmd-feed'
+import CmdSla from './cmd-sla'
+import CmdFields from './cmd-fields'
+import CmdNotify from './cmd-notify'
 import CmdDebug from './cmd-debug'
 import CmdShow from './cmd-show'
+import CmdVersion from './cmd-version'
+import CmdEpic from './cmd-epic'
+import CmdUnfurl from './cmd-unfurl'
 import {Context} from './context'
 import logger from './logger'
 import * as Utils from './utils'
@@ -80,6 +95,66 @@ const onMessage = async (
           reactFail(context, parsedMessage.context, kbMessage.id)
         return
       }
+      case Message.BotMessageType.Move: {
+        const {type} = await CmdMove(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Assign: {
+        const {type} = await CmdAssign(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Watch: {
+        const {type} = await CmdWatch(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Approval: {
+        const {type} = await CmdApproval(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Log: {
+        const {type} = await CmdLog(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Timesheet: {
+        const {type} = await CmdTimesheet(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Backlog: {
+        const {type} = await CmdBacklog(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Board: {
+        const {type} = await CmdBoard(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Bulk: {
+        const {type} = await CmdBulk(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.BulkConfirm: {
+        const {type} = await CmdBulkConfirm(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
       case Message.BotMessageType.Reacji:
         reacji(context, parsedMessage)
         return
@@ -89,6 +164,18 @@ const onMessage = async (
           reactFail(context, parsedMessage.context, kbMessage.id)
         return
       }
+      case Message.BotMessageType.CreatePrompted: {
+        const {type} = await CmdCreate(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.PromptAnswer: {
+        const {type} = await CmdCreateAnswer(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
       case Message.BotMessageType.Config: {
         const {type} = await CmdConfig(context, parsedMessage)
         type !== Errors.ReturnType.Ok &&
@@ -102,12 +189,37 @@ const onMessage = async (
           : reactFail(context, parsedMessage.context, kbMessage.id)
         return
       }
+      case Message.BotMessageType.AuthCredentials: {
+        const {type} = await handleCredentials(context, parsedMessage)
+        type === Errors.ReturnType.Ok
+          ? reactDone(context, parsedMessage.context, kbMessage.id)
+          : reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
       case Message.BotMessageType.Feed: {
         const {type} = await CmdFeed(context, parsedMessage)
         type !== Errors.ReturnType.Ok &&
           reactFail(context, parsedMessage.context, kbMessage.id)
         return
       }
+      case Message.BotMessageType.Sla: {
+        const {type} = await CmdSla(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Fields: {
+        const {type} = await CmdFields(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Notify: {
+        const {type} = await CmdNotify(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
       case Message.BotMessageType.Debug: {
         reactAck(context, parsedMessage.context, kbMessage.id)
         const {type} = await CmdDebug(context, parsedMessage)
@@ -122,6 +234,22 @@ const onMessage = async (
           reactFail(context, parsedMessage.context, kbMessage.id)
         return
       }
+      case Message.BotMessageType.Version: {
+        const {type} = await CmdVersion(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Epic: {
+        const {type} = await CmdEpic(context, parsedMessage)
+        type !== Errors.ReturnType.Ok &&
+          reactFail(context, parsedMessage.context, kbMessage.id)
+        return
+      }
+      case Message.BotMessageType.Unfurl:
+        // no reaction on messages which aren't commands
+        await CmdUnfurl(context, parsedMessage)
+        return
       default:
         let _: never = parsedMessage
     }
@@ -143,6 +271,18 @@ const commands = [
       `!jira new _in_ FRONTEND "UI tweaks for menu" margin should be 16px on desktop and 24px on mobile\n` +
       `!jira new bug _in_ frontend _for_ @songgao "fix fs offline bug" app thinks it's offline when it's not\n`,
   },
+  {
+    name: 'jira create',
+    description: 'make a Jira ticket, asking for its type and required fields',
+    usage: `[<PROJECT>] "multi word summary" [description]`,
+    title: 'Create a Jira ticket step by step',
+    body:
+      'Examples:\n\n' +
+      `!jira create FRONTEND "UI tweaks for menu" margin should be 16px\n` +
+      `!jira create "fix fs offline bug"\n\n` +
+      'I first list open tickets with a similar summary, if any; reply `create anyway` to go on. ' +
+      'Reply to my questions with a number or a name, `skip` for optional fields, or `cancel`.',
+  },
   {
     name: 'jira search',
     description: 'search for Jira tickets',
@@ -157,10 +297,100 @@ const commands = [
   {
     name: 'jira comment',
     description: `Comment on a Jira tickets.`,
-    usage: `on <ticket-key> <content>`,
+    usage: `[on <ticket-key>] <content>`,
     title: 'Comment on a Jira ticket',
     body:
-      'Examples:\n\n' + `!jira comment on TRIAGE-1024 this is already fixed\n`,
+      'Examples:\n\n' +
+      `!jira comment on TRIAGE-1024 this is already fixed\n\n` +
+      'Jira comments on tickets I announced in a channel show up as replies to the announcement. Reply there with `!jira comment <content>` to comment back.',
+  },
+  {
+    name: 'jira move',
+    description: `Move a Jira ticket through its workflow.`,
+    usage: `<ticket-key> <transition or status>`,
+    title: 'Move a Jira ticket',
+    body:
+      'Examples:\n\n' +
+      `!jira move TRIAGE-1024 "In Review"\n` +
+      `!jira move TRIAGE-1024 done\n`,
+  },
+  {
+    name: 'jira assign',
+    description: `Assign a Jira ticket to someone who connected their Jira account.`,
+    usage: `<ticket-key> <@kb-username|me>`,
+    title: 'Assign a Jira ticket',
+    body:
+      'Examples:\n\n' +
+      `!jira assign TRIAGE-1024 @songgao\n` +
+      `!jira assign TRIAGE-1024 me\n`,
+  },
+  {
+    name: 'jira watch',
+    description: `Watch a Jira ticket to get its notifications from Jira.`,
+    usage: `<ticket-key>`,
+    title: 'Watch a Jira ticket',
+    body: 'Examples:\n\n' + `!jira watch TRIAGE-1024\n`,
+  },
+  {
+    name: 'jira approve',
+    description: `Approve a Jira Service Management request waiting for your approval.`,
+    usage: `<request-key>`,
+    title: 'Approve a service desk request',
+    body: 'Examples:\n\n' + '!jira approve HELP-123',
+  },
+  {
+    name: 'jira decline',
+    description: `Decline a Jira Service Management request waiting for your approval.`,
+    usage: `<request-key>`,
+    title: 'Decline a service desk request',
+    body: 'Examples:\n\n' + '!jira decline HELP-123',
+  },
+  {
+    name: 'jira log',
+    description: `Log time spent on a Jira ticket.`,
+    usage: `<ticket-key> <time spent> [comment]`,
+    title: 'Log work on a Jira ticket',
+    body:
+      'Examples:\n\n' +
+      '!jira log TRIAGE-1024 2h "debugging flaky test"\n' +
+      '!jira log TRIAGE-1024 1h 30m',
+  },
+  {
+    name: 'jira timesheet',
+    description: `Sum up the time you've logged on Jira tickets.`,
+    usage: `[today|week|month]`,
+    title: 'Your timesheet',
+    body: 'Examples:\n\n' + '!jira timesheet\n' + '!jira timesheet month',
+  },
+  {
+    name: 'jira backlog',
+    description: `Show the top of a project's backlog, with assignees and ages.`,
+    usage: `<project> [--top <count>]`,
+    title: 'Backlog',
+    body:
+      'Examples:\n\n' + '!jira backlog OPS\n' + '!jira backlog OPS --top 20',
+  },
+  {
+    name: 'jira board',
+    description: `Show what's on a board, or in one of its columns, with assignees and ages.`,
+    usage: `<board name or ID> [column <column>]`,
+    title: 'Board',
+    body:
+      'Examples:\n\n' +
+      '!jira board "Platform"\n' +
+      '!jira board "Platform" column "In Progress"\n' +
+      '!jira board 42 column Review',
+  },
+  {
+    name: 'jira bulk',
+    description: `Move or comment on all the Jira tickets of a search, after a preview.`,
+    usage: `"<JQL query>" [--transition <transition or status>] [--comment "<content>"] | confirm | cancel`,
+    title: 'Bulk operations on Jira tickets',
+    body:
+      'Examples:\n\n' +
+      `!jira bulk "project = OPS AND labels = stale" --transition Done --comment "closing stale"\n` +
+      `!jira bulk confirm\n\n` +
+      `I list the tickets first, and only change them once you confirm.`,
   },
   {
     name: 'jira config',
@@ -170,20 +400,24 @@ const commands = [
     body:
       'Examples:\n\n' +
       `!jira config team jiraHost foo.atlassian.net\n` +
+      `!jira config team jiraServer https://jira.example.com/jira\n` +
       `!jira config channel\n` +
       // `!jira config team\n`+
-      `!jira config channel defaultNewIssueProject DESIGN\n`,
+      `!jira config channel defaultNewIssueProject DESIGN\n` +
+      `!jira config channel digestTime "09:30 +02:00"\n` +
+      `!jira config channel unfurl off\n` +
+      `!jira config channel unfurlProjects FRONTEND,DESIGN\n`,
   },
   {
     name: 'jira auth',
     description: `Connect Jirabot to your Jira account`,
-    usage: ``,
+    usage: `[basic|token]`,
     title: 'Jira Authorization',
   },
   {
     name: 'jira feed',
     description: `Subscribe to Jira feed and receive messages on Keybase about Jira activities.`,
-    usage: `list [all] | subscribe <project|'all'> [with updates] | unsubscribe <id>`,
+    usage: `list [all] | subscribe <project|'all'> [with updates] | subscribe jql "<query>" | subscribe board <board-id> [digest] | subscribe versions <project> | subscribe servicedesk <project> | subscribe epic <epic-key> | unsubscribe <id>`,
     title: 'Subscribe to Jira feed',
     body:
       'Examples:\n\n' +
@@ -192,11 +426,56 @@ const commands = [
       '!jira subscribe all\n' +
       '!jira subscribe design\n' +
       '!jira subscribe frontend with updates\n' +
+      '!jira subscribe jql "project = OPS AND priority = Highest"\n' +
+      '!jira subscribe board 42\n' +
+      '!jira subscribe board 42 digest\n' +
+      '!jira subscribe versions frontend\n' +
+      '!jira subscribe servicedesk help\n' +
+      '!jira subscribe epic OPS-100\n' +
       '!jira unsubscribe 123',
   },
+  {
+    name: 'jira sla',
+    description: `Alert this channel about issues of a priority left unresolved for too long.`,
+    usage: `list | add <priority> <duration> | remove <id>`,
+    title: 'SLA alerts',
+    body:
+      'Examples:\n\n' +
+      '!jira sla add Highest 4h\n' +
+      '!jira sla add "High" 2d\n' +
+      '!jira sla list\n' +
+      '!jira sla remove 2\n\n' +
+      'Rules apply to the projects the channel is subscribed to. I mention `@here` at twice the duration, and `@channel` at four times.',
+  },
+  {
+    name: 'jira fields',
+    description: `Choose the custom fields shown in notifications and unfurls of a project's issues.`,
+    usage: `<project> [list | add <custom field ID> <name> | remove <custom field ID>]`,
+    title: 'Custom fields',
+    body:
+      'Examples:\n\n' +
+      '!jira fields OPS add customfield_10023 "Severity"\n' +
+      '!jira fields OPS remove customfield_10023\n' +
+      '!jira fields OPS\n\n' +
+      'Only team admins can add or remove fields.',
+  },
+  {
+    name: 'jira notify',
+    description: `Get private messages when you're mentioned in a comment, assigned an issue, or asked for an approval.`,
+    usage: `[mentions|assignments|approvals] on|off | quiet <HH:mm>-<HH:mm> [UTC offset]|off`,
+    title: 'Private notifications',
+    body:
+      'Examples:\n\n' +
+      '!jira notify on\n' +
+      '!jira notify approvals off\n' +
+      '!jira notify quiet 22:00-08:00 +02:00\n' +
+      '!jira notify quiet off\n' +
+      '!jira notify\n\n' +
+      'This works for projects subscribed to in the team, once you have connected your Jira account with `!jira auth`.',
+  },
   {
     name: 'jira show',
-    description: `Show Jira issue(s). You can also unfurl Jira issues by at-mentioning me.`,
+    description: `Show Jira issue(s). You can also unfurl Jira issues by at-mentioning me, and I unfurl them by myself in channels with a feed subscription.`,
     usage: `show <issue-key> [<issue-key> ...]`,
     title: `Show Jira issue(s)`,
     body:
@@ -204,6 +483,26 @@ const commands = [
       '!jira show DESIGN-1234\n' +
       '!jira show DESIGN-1234 DESIGN-5678',
   },
+  {
+    name: 'jira version',
+    description: `Show the Jira tickets of a version, by status.`,
+    usage: `<project> <version>`,
+    title: 'Show a Jira version',
+    body:
+      'Examples:\n\n' +
+      '!jira version FRONTEND 2.4.0\n\n' +
+      'Get releases announced in a channel with `!jira subscribe versions <project>`.',
+  },
+  {
+    name: 'jira epic',
+    description: `Show the progress of an epic: story points and issues done, and what remains.`,
+    usage: `<epic-key>`,
+    title: 'Epic progress',
+    body:
+      'Examples:\n\n' +
+      '!jira epic OPS-100\n\n' +
+      'Get its progress posted in a channel as it moves with `!jira subscribe epic <epic-key>`.',
+  },
   {
     name: 'jira debug',
   },
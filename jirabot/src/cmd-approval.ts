@@ -0,0 +1,65 @@
+import {ApprovalMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Utils from './utils'
+
+export default async (
+  context: Context,
+  parsedMessage: ApprovalMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  try {
+    // Only approvers can answer, so this is what waits for the sender.
+    const approvals = (await jira.getApprovals(parsedMessage.ticket)).filter(
+      ({pending, canAnswer}) => pending && canAnswer
+    )
+    if (!approvals.length) {
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        `@${parsedMessage.context.senderUsername} ${parsedMessage.ticket} has no approval waiting for you.`
+      )
+      return Errors.makeError(undefined)
+    }
+    let url = ''
+    for (const approval of approvals) {
+      url = await jira.answerApproval(
+        parsedMessage.ticket,
+        approval.id,
+        parsedMessage.approve
+      )
+    }
+    context.stathat.postCount('service desk approvals', 1)
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `@${parsedMessage.context.senderUsername} ${
+        parsedMessage.approve ? 'Approved' : 'Declined'
+      } ${approvals
+        .map(({name}) => (name ? `"${name}"` : 'the approval'))
+        .join(', ')} of ${parsedMessage.ticket}: ${url}`
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
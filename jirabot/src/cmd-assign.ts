@@ -0,0 +1,65 @@
+import {AssignMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Utils from './utils'
+
+export default async (
+  context: Context,
+  parsedMessage: AssignMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  // Keybase users are known to Jira once they link their account
+  const accountIDRet = await Utils.getJiraAccountID(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.assignee
+  )
+  if (accountIDRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      accountIDRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const accountID = accountIDRet.result
+  if (!accountID) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `I don't know who @${parsedMessage.assignee} is on Jira. They need to connect their account with \`!jira auth\` first.`
+    )
+    return Errors.makeError(undefined)
+  }
+
+  try {
+    const url = await jira.assignIssue(parsedMessage.ticket, accountID)
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `@${parsedMessage.context.senderUsername} Assigned ${parsedMessage.ticket} to @${parsedMessage.assignee}: ${url}`
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
@@ -4,6 +4,37 @@ import * as Errors from './errors'
 import * as JiraOauth from './jira-oauth'
 import * as Jira from './jira'
 import * as Utils from './utils'
+import * as Configs from './configs'
+
+const credentialsTimeout = 1000 * 60 * 5 // 5min
+
+type PendingCredentialsItem = {
+  teamName: string
+  method: Message.AuthMethod.Basic | Message.AuthMethod.Token
+  requested: number
+}
+
+// Users asked to send their credentials in a private conversation, by
+// Keybase username.
+export class PendingCredentials {
+  _pending = new Map<string, PendingCredentialsItem>()
+
+  add = (username: string, item: PendingCredentialsItem) =>
+    this._pending.set(username, item)
+
+  get = (username: string): null | PendingCredentialsItem => {
+    const item = this._pending.get(username)
+    if (!item || Date.now() - item.requested > credentialsTimeout) {
+      this._pending.delete(username)
+      return null
+    }
+    return item
+  }
+
+  has = (username: string): boolean => !!this.get(username)
+
+  delete = (username: string) => this._pending.delete(username)
+}
 
 const replyInPrivate = async (
   context: Context,
@@ -93,11 +124,7 @@ export const startAuth = async (
   }
   const oauthResult = oauthRet.result
 
-  const jiraAccountIDRet = await Jira.getAccountId(
-    teamJiraConfig,
-    oauthResult.accessToken,
-    oauthResult.tokenSecret
-  )
+  const jiraAccountIDRet = await Jira.getAccountId(teamJiraConfig, oauthResult)
   if (jiraAccountIDRet.type === Errors.ReturnType.Error) {
     Errors.reportErrorAndReplyChat(
       context,
@@ -131,8 +158,157 @@ export const startAuth = async (
   return Errors.makeResult(undefined)
 }
 
+const startCredentialsAuth = async (
+  context: Context,
+  messageContext: Message.MessageContext,
+  method: Message.AuthMethod.Basic | Message.AuthMethod.Token
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const teamJiraConfigRet = await context.configs.getTeamJiraConfig(
+    messageContext.teamName
+  )
+  if (teamJiraConfigRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      messageContext,
+      teamJiraConfigRet.error.type === Errors.ErrorType.KVStoreNotFound
+        ? Errors.JirabotNotEnabledForTeamError
+        : teamJiraConfigRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const teamJiraConfig = teamJiraConfigRet.result.config
+  if (
+    method === Message.AuthMethod.Token &&
+    teamJiraConfig.deployment !== Configs.JiraDeployment.Server
+  ) {
+    await replyInTeamConvo(
+      context,
+      messageContext,
+      'Personal access tokens only work with Jira Server. Use `!jira auth basic` with your email and an API token instead.'
+    )
+    return Errors.makeError(undefined)
+  }
+
+  context.pendingCredentials.add(messageContext.senderUsername, {
+    teamName: messageContext.teamName,
+    method,
+    requested: Date.now(),
+  })
+  replyInPrivate(
+    context,
+    messageContext,
+    method === Message.AuthMethod.Token
+      ? `Reply here with a personal access token of your account on ${Jira.getBaseURL(
+          teamJiraConfig
+        )}, to use Jirabot in ${messageContext.teamName}.`
+      : `Reply here with your username and password on ${Jira.getBaseURL(
+          teamJiraConfig
+        )}, separated by a space, to use Jirabot in ${
+          messageContext.teamName
+        }. On Jira Cloud, use your email and an API token from https://id.atlassian.com/manage-profile/security/api-tokens instead.`
+  )
+  replyInTeamConvo(
+    context,
+    messageContext,
+    'I sent you a private message. Please continue from there.'
+  )
+  return Errors.makeResult(undefined)
+}
+
+export const parseCredentials = (
+  method: Message.AuthMethod.Basic | Message.AuthMethod.Token,
+  credentials: string
+): undefined | Jira.Credentials => {
+  if (method === Message.AuthMethod.Token) {
+    return credentials && !/\s/.test(credentials)
+      ? {accessToken: '', tokenSecret: '', personalAccessToken: credentials}
+      : undefined
+  }
+  const separator = credentials.search(/\s/)
+  const username = credentials.slice(0, separator)
+  const password = credentials.slice(separator + 1).trim()
+  return separator > 0 && password
+    ? {accessToken: '', tokenSecret: '', basicAuth: {username, password}}
+    : undefined
+}
+
+export const handleCredentials = async (
+  context: Context,
+  parsedMessage: Message.AuthCredentialsMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const username = parsedMessage.context.senderUsername
+  const pending = context.pendingCredentials.get(username)
+  if (!pending) {
+    return Errors.makeError(undefined)
+  }
+  context.pendingCredentials.delete(username)
+  const reply = (body: string) =>
+    Utils.replyToMessageContext(context, parsedMessage.context, body)
+
+  const credentials = parseCredentials(
+    pending.method,
+    parsedMessage.credentials
+  )
+  if (!credentials) {
+    await reply(
+      `That doesn't look right. Use \`!jira auth ${pending.method}\` in ${pending.teamName} to try again.`
+    )
+    return Errors.makeError(undefined)
+  }
+
+  const teamJiraConfigRet = await context.configs.getTeamJiraConfig(
+    pending.teamName
+  )
+  if (teamJiraConfigRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      teamJiraConfigRet.error.type === Errors.ErrorType.KVStoreNotFound
+        ? Errors.JirabotNotEnabledForTeamError
+        : teamJiraConfigRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const teamJiraConfig = teamJiraConfigRet.result.config
+
+  const jiraAccountIDRet = await Jira.getAccountId(teamJiraConfig, credentials)
+  if (jiraAccountIDRet.type === Errors.ReturnType.Error) {
+    await reply(
+      `Jira didn't accept those credentials. Use \`!jira auth ${pending.method}\` in ${pending.teamName} to try again.`
+    )
+    return Errors.makeError(undefined)
+  }
+
+  const updateRet = await context.configs.updateTeamUserConfig(
+    pending.teamName,
+    username,
+    undefined,
+    {
+      jiraAccountID: jiraAccountIDRet.result,
+      accessToken: '',
+      tokenSecret: '',
+      personalAccessToken: credentials.personalAccessToken,
+      basicAuth: credentials.basicAuth,
+    }
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      updateRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  await reply(
+    `Success! You can now use Jirabot in ${pending.teamName}. You may want to delete your message with the credentials.`
+  )
+  return Errors.makeResult(undefined)
+}
+
 export default async (
   context: Context,
   parsedMessage: Message.AuthMessage
 ): Promise<Errors.ResultOrError<undefined, undefined>> =>
-  startAuth(context, parsedMessage.context)
+  parsedMessage.method === Message.AuthMethod.OAuth
+    ? startAuth(context, parsedMessage.context)
+    : startCredentialsAuth(context, parsedMessage.context, parsedMessage.method)
@@ -0,0 +1,59 @@
+import {BacklogMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import {issueTypeToEmojiMaybe} from './cmd-show'
+
+export const issueToLine = (issue: Jira.Issue) =>
+  `${issueTypeToEmojiMaybe(issue.issueType)} *${issue.key}* ${
+    issue.summary
+  } - ${
+    issue.assigneeJira ? `_${issue.assigneeJira}_` : 'not assigned'
+  }, opened ${issue.createdTimeHumanized}`
+
+export default async (
+  context: Context,
+  parsedMessage: BacklogMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  try {
+    // The backlog is what isn't planned in a sprint yet, ranked.
+    const issues = await jira.search(
+      `project = "${parsedMessage.project}" AND resolution = Unresolved AND sprint is EMPTY ORDER BY Rank ASC`,
+      parsedMessage.top
+    )
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      issues.length
+        ? [
+            `Top ${issues.length} of the ${parsedMessage.project} backlog:`,
+            ...issues.map(issueToLine),
+          ].join('\n')
+        : `The ${parsedMessage.project} backlog is empty.`
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
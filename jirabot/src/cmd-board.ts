@@ -0,0 +1,132 @@
+import {BoardMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import {issueToLine} from './cmd-backlog'
+
+// issues listed per column when showing the whole board
+const maxIssuesPerColumn = 5
+const maxColumnIssues = 30
+const maxBoardIssues = 200
+
+const findBoard = async (
+  jira: Jira.JiraClientWrapper,
+  board: string
+): Promise<{id: number; name: string} | string> => {
+  if (/^\d+$/.test(board)) {
+    const id = Number.parseInt(board)
+    return {id, name: await jira.getBoardName(id)}
+  }
+  const boards = await jira.findBoards(board)
+  const lowered = board.toLowerCase()
+  const exact = boards.filter(({name}) => name.toLowerCase() === lowered)
+  if (exact.length === 1 || (!exact.length && boards.length === 1)) {
+    return exact[0] || boards[0]
+  }
+  return boards.length
+    ? `There are several boards named like "${board}": ${boards
+        .map(({id, name}) => `${name} (${id})`)
+        .join(', ')}. Use the ID of one of them.`
+    : `I can't find a board named "${board}".`
+}
+
+export default async (
+  context: Context,
+  parsedMessage: BoardMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  try {
+    const board = await findBoard(jira, parsedMessage.board)
+    if (typeof board === 'string') {
+      await Utils.replyToMessageContext(context, parsedMessage.context, board)
+      return Errors.makeError(undefined)
+    }
+    const columns = await jira.getBoardColumns(board.id)
+
+    if (parsedMessage.column) {
+      const lowered = parsedMessage.column.toLowerCase()
+      const column = columns.find(({name}) => name.toLowerCase() === lowered)
+      if (!column) {
+        await Utils.replyToMessageContext(
+          context,
+          parsedMessage.context,
+          `${board.name} has no column "${
+            parsedMessage.column
+          }". Its columns are ${columns
+            .map(({name}) => `"${name}"`)
+            .join(', ')}.`
+        )
+        return Errors.makeError(undefined)
+      }
+      const issues = column.statusIDs.length
+        ? await jira.getBoardIssues(
+            board.id,
+            column.statusIDs,
+            maxColumnIssues
+          )
+        : []
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        [
+          `*${board.name}* / *${column.name}*: ${
+            issues.length
+              ? `${issues.length}${
+                  issues.length === maxColumnIssues ? '+' : ''
+                } issue${issues.length !== 1 ? 's' : ''}`
+              : 'empty'
+          }`,
+          ...issues.map(issueToLine),
+        ].join('\n')
+      )
+      return Errors.makeResult(undefined)
+    }
+
+    const issues = await jira.getBoardIssues(board.id, [], maxBoardIssues)
+    const lines = [`*${board.name}*`]
+    for (const column of columns) {
+      const statusIDs = new Set(column.statusIDs)
+      const inColumn = issues.filter(({statusID}) => statusIDs.has(statusID))
+      lines.push(
+        `*${column.name}* (${inColumn.length})`,
+        ...inColumn.slice(0, maxIssuesPerColumn).map(issueToLine)
+      )
+      if (inColumn.length > maxIssuesPerColumn) {
+        lines.push(
+          `and ${inColumn.length - maxIssuesPerColumn} more; see them with \`!jira board ${board.id} column "${column.name}"\``
+        )
+      }
+    }
+    if (issues.length === maxBoardIssues) {
+      lines.push(`Only the first ${maxBoardIssues} issues are counted.`)
+    }
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      lines.join('\n')
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
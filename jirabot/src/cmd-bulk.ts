@@ -0,0 +1,226 @@
+import * as Message from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import {matchTransitions} from './cmd-move'
+import {statusToEmoji} from './emoji'
+
+const sessionTimeout = 1000 * 60 * 5 // 5min
+// queries matching more issues than this have to be narrowed down first
+const maxBulkIssues = 50
+
+type BulkOperation = {
+  messageContext: Message.MessageContext
+  jql: string
+  transition?: string
+  comment?: string
+  issueKeys: Array<string>
+  created: number
+}
+
+const getOperationKey = (conversationId: string, username: string) =>
+  `${conversationId}:${username}`
+
+// Bulk operations waiting for `!jira bulk confirm`, one per user in each
+// conversation.
+export class BulkOperations {
+  _operations = new Map<string, BulkOperation>()
+
+  set = (operation: BulkOperation) =>
+    this._operations.set(
+      getOperationKey(
+        operation.messageContext.conversationId,
+        operation.messageContext.senderUsername
+      ),
+      operation
+    )
+
+  // gets and forgets the pending operation of a user
+  take = (conversationId: string, username: string): null | BulkOperation => {
+    const key = getOperationKey(conversationId, username)
+    const operation = this._operations.get(key)
+    this._operations.delete(key)
+    if (!operation || Date.now() - operation.created > sessionTimeout) {
+      return null
+    }
+    return operation
+  }
+}
+
+const formatActions = (operation: {transition?: string; comment?: string}) =>
+  [
+    operation.transition && `move to "${operation.transition}"`,
+    operation.comment && `comment "${operation.comment}"`,
+  ]
+    .filter(Boolean)
+    .join(', then ')
+
+const issueToLine = (issue: Jira.Issue) =>
+  `${statusToEmoji(issue.status)} *${issue.key}* ${issue.summary}`
+
+const getJira = async (
+  context: Context,
+  messageContext: Message.MessageContext
+): Promise<undefined | Jira.JiraClientWrapper> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    messageContext.teamName,
+    messageContext.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(context, messageContext, jiraRet.error)
+    return undefined
+  }
+  return jiraRet.result
+}
+
+// previews the issues of the query, and waits for a confirmation
+export default async (
+  context: Context,
+  parsedMessage: Message.BulkMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jira = await getJira(context, parsedMessage.context)
+  if (!jira) {
+    return Errors.makeError(undefined)
+  }
+  let issues: Array<Jira.Issue>
+  try {
+    issues = await jira.search(parsedMessage.jql, maxBulkIssues + 1)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+  if (!issues.length) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `No issue matches \`${parsedMessage.jql}\`.`
+    )
+    return Errors.makeError(undefined)
+  }
+  if (issues.length > maxBulkIssues) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `More than ${maxBulkIssues} issues match \`${parsedMessage.jql}\`. Narrow the query down and try again.`
+    )
+    return Errors.makeError(undefined)
+  }
+
+  context.bulkOperations.set({
+    messageContext: parsedMessage.context,
+    jql: parsedMessage.jql,
+    transition: parsedMessage.transition,
+    comment: parsedMessage.comment,
+    issueKeys: issues.map(({key}) => key),
+    created: Date.now(),
+  })
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    [
+      `@${parsedMessage.context.senderUsername} I'm about to ${formatActions(
+        parsedMessage
+      )} on these ${issues.length} issue${issues.length !== 1 ? 's' : ''}:`,
+      ...issues.map(issueToLine),
+      'Reply `!jira bulk confirm` within 5 minutes to go ahead, or `!jira bulk cancel`.',
+    ].join('\n')
+  )
+  return Errors.makeResult(undefined)
+}
+
+const transitionIssue = async (
+  jira: Jira.JiraClientWrapper,
+  issueKey: string,
+  input: string
+): Promise<boolean> => {
+  const matched = matchTransitions(await jira.getTransitions(issueKey), input)
+  if (matched.length !== 1) {
+    return false
+  }
+  await jira.transitionIssue(issueKey, matched[0].id)
+  return true
+}
+
+export const confirm = async (
+  context: Context,
+  parsedMessage: Message.BulkConfirmMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const operation = context.bulkOperations.take(
+    parsedMessage.context.conversationId,
+    parsedMessage.context.senderUsername
+  )
+  if (!operation) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      'There is no bulk operation waiting for you to confirm. Start one with `!jira bulk`.'
+    )
+    return Errors.makeError(undefined)
+  }
+  if (!parsedMessage.confirmed) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Okay, I won't touch the issues of \`${operation.jql}\`.`
+    )
+    return Errors.makeResult(undefined)
+  }
+  const jira = await getJira(context, parsedMessage.context)
+  if (!jira) {
+    return Errors.makeError(undefined)
+  }
+
+  // The v2 API (which Jira Server has too) has no bulk transitions nor bulk
+  // comments, so issues are updated one by one.
+  const done = []
+  const noTransition = []
+  const failed = []
+  for (const issueKey of operation.issueKeys) {
+    try {
+      if (
+        operation.transition &&
+        !(await transitionIssue(jira, issueKey, operation.transition))
+      ) {
+        noTransition.push(issueKey)
+        continue
+      }
+      if (operation.comment) {
+        await jira.addComment(issueKey, operation.comment)
+      }
+      done.push(issueKey)
+    } catch (err) {
+      failed.push(issueKey)
+    }
+  }
+  context.stathat.postCount('bulk operations', 1)
+
+  const lines = [
+    `@${parsedMessage.context.senderUsername} Done for ${done.length} of ${
+      operation.issueKeys.length
+    } issue${operation.issueKeys.length !== 1 ? 's' : ''}.`,
+  ]
+  if (noTransition.length) {
+    lines.push(
+      `Not moved, as "${
+        operation.transition
+      }" isn't exactly one transition for them: ${noTransition.join(', ')}`
+    )
+  }
+  if (failed.length) {
+    lines.push(`Jira returned an error for: ${failed.join(', ')}`)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    lines.join('\n')
+  )
+  return done.length
+    ? Errors.makeResult(undefined)
+    : Errors.makeError(undefined)
+}
@@ -2,6 +2,7 @@ import {CommentMessage} from './message'
 import {Context} from './context'
 import * as Errors from './errors'
 import * as Utils from './utils'
+import {markBridgedComment} from './comment-bridge'
 
 export default async (
   context: Context,
@@ -22,10 +23,12 @@ export default async (
   }
   const jira = jiraRet.result
   try {
-    const url = await jira.addComment(
+    const {id, url} = await jira.addComment(
       parsedMessage.ticket,
       parsedMessage.comment
     )
+    // already in the thread if it's a reply there
+    parsedMessage.inThread && markBridgedComment(id)
     await Utils.replyToMessageContext(
       context,
       parsedMessage.context,
@@ -5,6 +5,7 @@ import * as Errors from './errors'
 import * as JiraOauth from './jira-oauth'
 import * as Utils from './utils'
 import * as Jira from './jira'
+import {URL} from 'url'
 
 const makeNewTeamChannelConfig = async (
   context: Context,
@@ -44,6 +45,27 @@ const makeNewTeamChannelConfig = async (
         defaultNewIssueProject: normalizedProject.toLowerCase(),
       })
     }
+    case 'digestTime':
+      return Errors.makeResult<Configs.TeamChannelConfig>({
+        ...oldConfig,
+        digestTime: Utils.formatTimeOfDay(Utils.parseTimeOfDay(value)),
+      })
+    case 'unfurl':
+      return Errors.makeResult<Configs.TeamChannelConfig>({
+        ...oldConfig,
+        unfurl: value === 'on',
+      })
+    case 'unfurlProjects':
+      return Errors.makeResult<Configs.TeamChannelConfig>({
+        ...oldConfig,
+        unfurlProjects:
+          value === 'all'
+            ? undefined
+            : value
+                .split(',')
+                .map(key => key.trim().toUpperCase())
+                .filter(Boolean),
+      })
     default:
       return Errors.makeError<Errors.UnknownParamError>({
         type: Errors.ErrorType.UnknownParam,
@@ -73,7 +95,15 @@ const channelConfigToMessageBody = (
 *defaultNewIssueProject:* ${channelConfig.defaultNewIssueProject ||
     '<undefined>'}
 
+*digestTime:* ${channelConfig.digestTime || '<undefined>'}
+
+*unfurl:* ${channelConfig.unfurl === false ? 'off' : 'on'}
+
+*unfurlProjects:* ${channelConfig.unfurlProjects?.join(',') || 'all'}
+
 When creating a new issue, one can omit the \`in <project>\` part if \`defaultNewIssueProject\` is set.
+Issue keys mentioned in channels with a feed subscription are unfurled unless \`unfurl\` is \`off\`, only for the projects in \`unfurlProjects\` if it's set.
+Daily digests of boards subscribed to with \`!jira feed subscribe board <id> digest\` are posted at \`digestTime\`, or 09:00 UTC if it's not set.
 `
 
 const handleChannelConfig = async (
@@ -187,10 +217,15 @@ const jiraConfigToMessageBody = (
   context: Context,
   jiraConfig: Configs.TeamJiraConfig
 ) =>
-  `This team is now configured for \`${jiraConfig.jiraHost}\`. ` +
-  "If you haven't, here are instructions for connecting on Jira side:\n" +
+  `This team is now configured for \`${Jira.getBaseURL(jiraConfig)}\`` +
+  (jiraConfig.deployment === Configs.JiraDeployment.Server
+    ? ' (Jira Server)'
+    : '') +
+  ". If you haven't, here are instructions for connecting on Jira side:\n" +
   'Go to the application links section of Jira admin settings: ' +
-  `https://${jiraConfig.jiraHost}/plugins/servlet/applinks/listApplicationLinks` +
+  `${Jira.getBaseURL(
+    jiraConfig
+  )}/plugins/servlet/applinks/listApplicationLinks` +
   ', and create an application link of type "Generic Application".' +
   ` Use \`${context.botConfig.httpAddressPrefix}\` as the URL of the application.` +
   '\n\nAfter the application link has been created, edit the link and configure "Incoming Authentication" as following:' +
@@ -199,7 +234,11 @@ const jiraConfigToMessageBody = (
   jiraConfig.jiraAuth.publicKey +
   '```\n' +
   '\nOther fields can be empty or arbitrary values.' +
-  '\n\nAfter this has been done, any user in this team can use `!jira auth` to connect their account with Jirabot. You can also use `jira config channel` to customize Jirabot for each channel in this team.'
+  '\n\nAfter this has been done, any user in this team can use `!jira auth` to connect their account with Jirabot. You can also use `jira config channel` to customize Jirabot for each channel in this team.' +
+  '\n\nUsers can also skip the application link with `!jira auth basic`, to send me their username and password (or email and API token on Jira Cloud)' +
+  (jiraConfig.deployment === Configs.JiraDeployment.Server
+    ? ', or `!jira auth token` for a personal access token.'
+    : '.')
 
 const handleTeamConfig = async (
   context: Context,
@@ -245,6 +284,7 @@ const handleTeamConfig = async (
   }
   switch (parsedMessage.toSet.name) {
     case 'jiraHost':
+    case 'jiraServer':
       // TODO check admin
       const detailsRet = await JiraOauth.generateNewJiraLinkDetails()
       if (detailsRet.type === Errors.ReturnType.Error) {
@@ -257,8 +297,14 @@ const handleTeamConfig = async (
       }
       const details = detailsRet.result
 
+      const value = parsedMessage.toSet.value.replace(/\/+$/, '')
+      const server = parsedMessage.toSet.name === 'jiraServer'
       const newConfig = {
-        jiraHost: parsedMessage.toSet.value.replace(/\/+$/, ''),
+        jiraHost: server ? new URL(value).host : value,
+        deployment: server
+          ? Configs.JiraDeployment.Server
+          : Configs.JiraDeployment.Cloud,
+        baseURL: server ? value : undefined,
         jiraAuth: {
           consumerKey: details.consumerKey,
           publicKey: details.publicKey,
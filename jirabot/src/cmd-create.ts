@@ -0,0 +1,441 @@
+import * as Message from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import logger from './logger'
+import {issueToLine} from './cmd-backlog'
+
+const sessionTimeout = 1000 * 60 * 5 // 5min
+// more options than this are left out of a prompt, but can still be named
+const maxPromptOptions = 25
+// open issues with a similar summary shown before creating a new one
+const maxDuplicates = 5
+
+// system fields that are always prompted for when they are on the screen
+const promptedSystemFields = ['priority', 'components']
+// fields that come from the command or from Jira itself
+const notPromptedFields = new Set([
+  'project',
+  'issuetype',
+  'summary',
+  'description',
+  'reporter',
+])
+
+type PromptOption = {
+  id: string
+  name: string
+}
+
+type PromptStep = {
+  fieldID: string
+  name: string
+  required: boolean
+  multiple: boolean
+  // empty for free text fields
+  options: Array<PromptOption>
+}
+
+type CreateSession = {
+  messageContext: Message.MessageContext
+  project: string
+  name: string
+  description: string
+  issueTypes: Array<Jira.CreateMetaIssueType>
+  issueType?: Jira.CreateMetaIssueType
+  steps: Array<PromptStep>
+  fields: {[fieldID: string]: any}
+  // similar open issues the sender hasn't confirmed past yet
+  duplicates?: Array<Jira.Issue>
+  updated: number
+}
+
+const getSessionKey = (conversationId: string, username: string) =>
+  `${conversationId}:${username}`
+
+// Issues being created by `!jira create`, one per user in each conversation.
+export class CreateSessions {
+  _sessions = new Map<string, CreateSession>()
+
+  set = (session: CreateSession) => {
+    this._sessions.set(
+      getSessionKey(
+        session.messageContext.conversationId,
+        session.messageContext.senderUsername
+      ),
+      {...session, updated: Date.now()}
+    )
+  }
+
+  get = (conversationId: string, username: string): null | CreateSession => {
+    const key = getSessionKey(conversationId, username)
+    const session = this._sessions.get(key)
+    if (!session) {
+      return null
+    }
+    if (Date.now() - session.updated > sessionTimeout) {
+      this._sessions.delete(key)
+      return null
+    }
+    return session
+  }
+
+  has = (conversationId: string, username: string): boolean =>
+    !!this.get(conversationId, username)
+
+  delete = (conversationId: string, username: string) =>
+    this._sessions.delete(getSessionKey(conversationId, username))
+}
+
+const fieldToStep = (fieldID: string, field: any): null | PromptStep => {
+  const multiple = field.schema?.type === 'array'
+  const options = Array.isArray(field.allowedValues)
+    ? field.allowedValues
+        .map((value: any) => ({
+          id: value.id,
+          name: value.name || value.value,
+        }))
+        .filter(({id, name}: PromptOption) => id && name)
+    : []
+  if (!options.length && field.schema?.type !== 'string') {
+    // not something we can ask for in chat
+    return null
+  }
+  return {
+    fieldID,
+    name: field.name || fieldID,
+    required: !!field.required,
+    multiple,
+    options,
+  }
+}
+
+// system fields first, then the rest in the order of the screen
+const promptOrder = (step: PromptStep): number => {
+  const index = promptedSystemFields.indexOf(step.fieldID)
+  return index === -1 ? promptedSystemFields.length : index
+}
+
+const stepsForIssueType = (
+  issueType: Jira.CreateMetaIssueType
+): Array<PromptStep> =>
+  Object.entries(issueType.fields)
+    .filter(
+      ([fieldID, field]) =>
+        !notPromptedFields.has(fieldID) &&
+        (promptedSystemFields.includes(fieldID) ||
+          (field.required && !field.hasDefaultValue))
+    )
+    .map(([fieldID, field]) => fieldToStep(fieldID, field))
+    .filter(Boolean)
+    .sort((a, b) => promptOrder(a) - promptOrder(b))
+
+const issueTypeStep = (session: CreateSession): PromptStep => ({
+  fieldID: 'issuetype',
+  name: 'Issue type',
+  required: true,
+  multiple: false,
+  options: session.issueTypes.map(({id, name}) => ({id, name})),
+})
+
+const formatPrompt = (session: CreateSession, step: PromptStep): string => {
+  const how = step.options.length
+    ? `Reply with a number or name${
+        step.multiple ? ', several separated by commas' : ''
+      }`
+    : 'Reply with the value'
+  const lines = [
+    `@${session.messageContext.senderUsername} *${step.name}* for the new ${
+      session.project
+    } issue? ${how}${step.required ? '' : ', `skip`'} or \`cancel\`:`,
+    ...step.options
+      .slice(0, maxPromptOptions)
+      .map((option, index) => `${index + 1}. ${option.name}`),
+  ]
+  if (step.options.length > maxPromptOptions) {
+    lines.push(`and ${step.options.length - maxPromptOptions} more`)
+  }
+  return lines.join('\n')
+}
+
+// Characters that mean something to Jira's text search; a summary is
+// matched by its words only.
+const textSearchSpecialChars = /[+\-&|!(){}[\]^~*?\\:"\/]/g
+
+const similarIssuesJql = (project: string, summary: string) =>
+  `project = "${project}" AND resolution = Unresolved AND ` +
+  `summary ~ "${summary
+    .replace(textSearchSpecialChars, ' ')
+    .trim()}" ORDER BY updated DESC`
+
+const findDuplicates = async (
+  jira: Jira.JiraClientWrapper,
+  project: string,
+  summary: string
+): Promise<Array<Jira.Issue>> => {
+  if (!summary.replace(textSearchSpecialChars, '').trim()) {
+    return []
+  }
+  try {
+    return await jira.search(similarIssuesJql(project, summary), maxDuplicates)
+  } catch (error) {
+    // not being able to look for duplicates shouldn't stop creating the issue
+    logger.warn({msg: 'findDuplicates', project, error})
+    return []
+  }
+}
+
+const formatDuplicatesPrompt = (session: CreateSession): string =>
+  [
+    `@${session.messageContext.senderUsername} These open ${
+      session.project
+    } issues look like "${session.name}":`,
+    ...session.duplicates.map(issueToLine),
+    'Reply `create anyway` to create it, or `cancel`.',
+  ].join('\n')
+
+const matchOption = (
+  options: Array<PromptOption>,
+  answer: string
+): undefined | PromptOption => {
+  const index = Number.parseInt(answer)
+  if (String(index) === answer && index >= 1 && index <= options.length) {
+    return options[index - 1]
+  }
+  const lowered = answer.toLowerCase()
+  return options.find(option => option.name.toLowerCase() === lowered)
+}
+
+// parses an answer to a step, undefined if it's not a valid one
+const parseAnswer = (step: PromptStep, answer: string): any => {
+  if (!step.options.length) {
+    return answer
+  }
+  const answers = step.multiple
+    ? answer
+        .split(',')
+        .map(s => s.trim())
+        .filter(Boolean)
+    : [answer]
+  const matched = answers.map(a => matchOption(step.options, a))
+  if (!matched.length || matched.some(option => !option)) {
+    return undefined
+  }
+  const values = matched.map(({id}) => ({id}))
+  return step.multiple ? values : values[0]
+}
+
+const createIssue = async (
+  context: Context,
+  session: CreateSession,
+  messageContext: Message.MessageContext
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  context.createSessions.delete(
+    session.messageContext.conversationId,
+    session.messageContext.senderUsername
+  )
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    messageContext.teamName,
+    messageContext.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(context, messageContext, jiraRet.error)
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+  try {
+    const {key, url} = await jira.createIssueFromFields({
+      ...session.fields,
+      project: {key: session.project.toUpperCase()},
+      issuetype: {id: session.issueType.id},
+      summary: session.name,
+      description: session.description || undefined,
+    })
+    await Utils.replyToMessageContext(
+      context,
+      messageContext,
+      `@${messageContext.senderUsername} Created ${key} (${session.issueType.name}): ${url}`
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      messageContext,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
+
+// asks for the next step, or creates the issue when there is none left
+const next = async (
+  context: Context,
+  session: CreateSession,
+  messageContext: Message.MessageContext
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  if (session.duplicates?.length) {
+    context.createSessions.set(session)
+    await Utils.replyToMessageContext(
+      context,
+      messageContext,
+      formatDuplicatesPrompt(session)
+    )
+    return Errors.makeResult(undefined)
+  }
+  const step = session.issueType ? session.steps[0] : issueTypeStep(session)
+  if (!step) {
+    return createIssue(context, session, messageContext)
+  }
+  context.createSessions.set(session)
+  await Utils.replyToMessageContext(
+    context,
+    messageContext,
+    formatPrompt(session, step)
+  )
+  return Errors.makeResult(undefined)
+}
+
+export default async (
+  context: Context,
+  parsedMessage: Message.CreatePromptedMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  let issueTypes: Array<Jira.CreateMetaIssueType>
+  let duplicates: Array<Jira.Issue>
+  try {
+    const [createMeta, similar] = await Promise.all([
+      jira.getCreateMeta(parsedMessage.project),
+      findDuplicates(jira, parsedMessage.project, parsedMessage.name),
+    ])
+    issueTypes = createMeta.filter(({subtask}) => !subtask)
+    duplicates = similar
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+  if (!issueTypes.length) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `You can't create issues in ${parsedMessage.project}.`
+    )
+    return Errors.makeError(undefined)
+  }
+
+  const session: CreateSession = {
+    messageContext: parsedMessage.context,
+    project: parsedMessage.project,
+    name: parsedMessage.name,
+    description: parsedMessage.description,
+    issueTypes,
+    steps: [],
+    fields: {},
+    duplicates,
+    updated: Date.now(),
+  }
+  if (issueTypes.length === 1) {
+    session.issueType = issueTypes[0]
+    session.steps = stepsForIssueType(issueTypes[0])
+  }
+  return next(context, session, parsedMessage.context)
+}
+
+export const answer = async (
+  context: Context,
+  parsedMessage: Message.PromptAnswerMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const session = context.createSessions.get(
+    parsedMessage.context.conversationId,
+    parsedMessage.context.senderUsername
+  )
+  if (!session) {
+    return Errors.makeResult(undefined)
+  }
+  const answer = parsedMessage.answer
+  if (answer.toLowerCase() === 'cancel') {
+    context.createSessions.delete(
+      parsedMessage.context.conversationId,
+      parsedMessage.context.senderUsername
+    )
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Okay, I won't create "${session.name}".`
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  if (session.duplicates?.length) {
+    if (answer.toLowerCase() !== 'create anyway') {
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        formatDuplicatesPrompt(session)
+      )
+      context.createSessions.set(session)
+      return Errors.makeError(undefined)
+    }
+    context.stathat.postCount('issues created despite duplicates', 1)
+    return next(
+      context,
+      {...session, duplicates: undefined},
+      parsedMessage.context
+    )
+  }
+
+  const step = session.issueType ? session.steps[0] : issueTypeStep(session)
+  if (!step.required && answer.toLowerCase() === 'skip') {
+    return next(
+      context,
+      {...session, steps: session.steps.slice(1)},
+      parsedMessage.context
+    )
+  }
+  const value = parseAnswer(step, answer)
+  if (value === undefined) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `"${answer}" is not one of the options.\n` + formatPrompt(session, step)
+    )
+    context.createSessions.set(session)
+    return Errors.makeError(undefined)
+  }
+  if (!session.issueType) {
+    const issueType = session.issueTypes.find(({id}) => id === value.id)
+    return next(
+      context,
+      {...session, issueType, steps: stepsForIssueType(issueType)},
+      parsedMessage.context
+    )
+  }
+  return next(
+    context,
+    {
+      ...session,
+      steps: session.steps.slice(1),
+      fields: {...session.fields, [step.fieldID]: value},
+    },
+    parsedMessage.context
+  )
+}
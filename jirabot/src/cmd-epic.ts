@@ -0,0 +1,46 @@
+import {EpicMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Utils from './utils'
+import {formatEpicProgress, maxEpicChildren} from './epic-poller'
+
+export default async (
+  context: Context,
+  parsedMessage: EpicMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  try {
+    const epic = await jira.get({issueKey: parsedMessage.epicKey})
+    const children = await jira.getEpicChildren(
+      parsedMessage.epicKey,
+      maxEpicChildren
+    )
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      formatEpicProgress(epic, children)
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
@@ -5,6 +5,15 @@ import * as Jira from './jira'
 import * as Configs from './configs'
 import * as Constants from './constants'
 import * as Utils from './utils'
+import {maxPolledIssues} from './jql-poller'
+import {getSprintState} from './sprint-poller'
+import {versionsToState} from './version-poller'
+import {getServiceDesk} from './servicedesk-poller'
+import {
+  childrenToState,
+  formatEpicProgress,
+  maxEpicChildren,
+} from './epic-poller'
 
 const updateTeamJiraSubscriptions = async (
   context: Context,
@@ -49,52 +58,201 @@ const updateTeamJiraSubscriptions = async (
   return Errors.makeUnknownError('update kvstore failed')
 }
 
+const jiraErrorStatusCode = (err: any): undefined | number => {
+  if (typeof err.statusCode === 'number') {
+    return err.statusCode
+  }
+  try {
+    const obj = JSON.parse(err)
+    if (typeof obj.statusCode === 'number') {
+      return obj.statusCode
+    }
+  } catch {}
+  return undefined
+}
+
+// Registering webhooks takes a Jira admin, and some instances have them off.
+const webhookUnavailableStatusCodes = [401, 403, 404]
+
 const reportJiraError = (
   context: Context,
   messageContext: Message.MessageContext,
   err: any
 ) => {
-  let statusCode = undefined
-  if (typeof err.statusCode === 'number') {
-    statusCode = err.statusCode
-  } else {
-    try {
-      const obj = JSON.parse(err)
-      if (typeof obj.statusCode === 'number') {
-        statusCode = obj.statusCode
-      }
-    } catch {}
-  }
   Errors.reportErrorAndReplyChat(
     context,
     messageContext,
-    statusCode === 403
+    jiraErrorStatusCode(err) === 403
       ? {type: Errors.ErrorType.JiraNoPermission}
       : Errors.makeUnknownError(err).error
   )
 }
 
+// error messages of a rejected Jira request, e.g. for an invalid JQL query
+const jiraErrorMessages = (err: any): Array<string> => {
+  let obj = err
+  if (typeof err === 'string') {
+    try {
+      obj = JSON.parse(err)
+    } catch {
+      return []
+    }
+  }
+  const messages = obj?.errorMessages ?? obj?.body?.errorMessages
+  return Array.isArray(messages)
+    ? messages.filter((message: any) => typeof message === 'string')
+    : []
+}
+
+const formatSubscription = (sub: Configs.TeamJiraSubscription): string =>
+  sub.epicKey
+    ? `progress of epic ${sub.epicKey}`
+    : sub.serviceDeskProject
+    ? `requests of service desk ${sub.serviceDeskProject}`
+    : sub.versionsProject
+    ? `versions of ${sub.versionsProject}`
+    : sub.digest
+    ? `daily digest of board ${sub.boardID}`
+    : sub.boardID
+    ? `sprints of board ${sub.boardID}`
+    : `\`${sub.jql}\`${sub.withUpdates ? ' (with issue udpates)' : ''}${
+        sub.pollingUsername ? ' (polled)' : ''
+      }`
+
 const subscribe = async (
   context: Context,
   parsedMessage: Message.FeedSubscribeMessage,
   jira: Jira.JiraClientWrapper
 ): Promise<Errors.ResultOrError<undefined, undefined>> => {
   const urlToken = await Utils.randomString('jira-subscription')
-  const jql = Jira.projectToJqlFilter(parsedMessage.project)
+  const jql =
+    parsedMessage.boardID ||
+    parsedMessage.versionsProject ||
+    parsedMessage.serviceDeskProject ||
+    parsedMessage.epicKey
+      ? ''
+      : parsedMessage.jql || Jira.projectToJqlFilter(parsedMessage.project)
+  let polled = !!(
+    parsedMessage.jql ||
+    parsedMessage.boardID ||
+    parsedMessage.versionsProject ||
+    parsedMessage.serviceDeskProject ||
+    parsedMessage.epicKey
+  )
 
-  let webhookURI: string
-  try {
-    webhookURI = await jira.subscribe(
-      jql,
-      [
-        Jira.JiraSubscriptionEvents.IssueCreated,
-        Jira.JiraSubscriptionEvents.IssueUpdated,
-      ],
-      `${context.botConfig.httpAddressPrefix}${Constants.jiraWebhookPathname}?urlToken=${urlToken}`
-    )
-  } catch (err) {
-    reportJiraError(context, parsedMessage.context, err)
-    return Errors.makeError(undefined)
+  let webhookURI = ''
+  let webhookSecret: string | undefined
+  // the polled JQL of a project subscription without a webhook
+  let fallbackJql = ''
+  let initialIssues: Array<Jira.Issue> = []
+  let initialSprints: Configs.SprintSubscriptionState | undefined
+  let digestBoardName = ''
+  let initialVersions: Configs.VersionSubscriptionState | undefined
+  let initialServiceDesk: Configs.ServiceDeskSubscriptionState | undefined
+  let initialEpic:
+    | undefined
+    | {epic: Jira.Issue; children: Array<Jira.EpicChild>}
+  if (parsedMessage.epicKey) {
+    try {
+      initialEpic = {
+        epic: await jira.get({issueKey: parsedMessage.epicKey}),
+        children: await jira.getEpicChildren(
+          parsedMessage.epicKey,
+          maxEpicChildren
+        ),
+      }
+    } catch (err) {
+      reportJiraError(context, parsedMessage.context, err)
+      return Errors.makeError(undefined)
+    }
+  } else if (parsedMessage.serviceDeskProject) {
+    // Service desk SLAs have no webhooks, so requests are polled too. The
+    // current ones are not announced.
+    try {
+      const serviceDesk = await getServiceDesk(
+        jira,
+        parsedMessage.serviceDeskProject
+      )
+      initialServiceDesk = serviceDesk.state
+    } catch (err) {
+      reportJiraError(context, parsedMessage.context, err)
+      return Errors.makeError(undefined)
+    }
+  } else if (parsedMessage.versionsProject) {
+    // Webhooks of versions can't be filtered by project, so they are polled.
+    try {
+      initialVersions = versionsToState(
+        await jira.getProjectVersions(parsedMessage.versionsProject)
+      )
+    } catch (err) {
+      reportJiraError(context, parsedMessage.context, err)
+      return Errors.makeError(undefined)
+    }
+  } else if (parsedMessage.digest) {
+    try {
+      digestBoardName = await jira.getBoardName(parsedMessage.boardID)
+    } catch (err) {
+      reportJiraError(context, parsedMessage.context, err)
+      return Errors.makeError(undefined)
+    }
+  } else if (parsedMessage.boardID) {
+    // The Agile API has no webhooks for sprints, so boards are polled too.
+    try {
+      initialSprints = await getSprintState(
+        jira,
+        parsedMessage.boardID,
+        await jira.getBoardName(parsedMessage.boardID)
+      )
+    } catch (err) {
+      reportJiraError(context, parsedMessage.context, err)
+      return Errors.makeError(undefined)
+    }
+  } else if (parsedMessage.jql) {
+    // Webhooks can't tell when an issue leaves the results, so JQL
+    // subscriptions are polled. The current results are not announced.
+    try {
+      initialIssues = await jira.search(jql, maxPolledIssues)
+    } catch (err) {
+      const messages = jiraErrorMessages(err)
+      messages.length
+        ? Utils.replyToMessageContext(
+            context,
+            parsedMessage.context,
+            `Jira rejected that JQL query: ${messages.join(' ')}`
+          )
+        : reportJiraError(context, parsedMessage.context, err)
+      return Errors.makeError(undefined)
+    }
+  } else {
+    webhookSecret = await Utils.randomString('jira-webhook-secret')
+    try {
+      webhookURI = await jira.subscribe(
+        jql,
+        [
+          Jira.JiraSubscriptionEvents.IssueCreated,
+          Jira.JiraSubscriptionEvents.IssueUpdated,
+          Jira.JiraSubscriptionEvents.CommentCreated,
+        ],
+        `${context.botConfig.httpAddressPrefix}${Constants.jiraWebhookPathname}?urlToken=${urlToken}`,
+        webhookSecret
+      )
+    } catch (err) {
+      const statusCode = jiraErrorStatusCode(err)
+      if (!statusCode || !webhookUnavailableStatusCodes.includes(statusCode)) {
+        reportJiraError(context, parsedMessage.context, err)
+        return Errors.makeError(undefined)
+      }
+      // New issues of the project are polled instead.
+      webhookSecret = undefined
+      polled = true
+      fallbackJql = `${jql} ORDER BY created DESC`
+      try {
+        initialIssues = await jira.search(fallbackJql, maxPolledIssues)
+      } catch (err) {
+        reportJiraError(context, parsedMessage.context, err)
+        return Errors.makeError(undefined)
+      }
+    }
   }
 
   let id = 0
@@ -116,8 +274,18 @@ const subscribe = async (
             conversationId: parsedMessage.context.conversationId,
             webhookURI,
             urlToken,
-            jql,
-            withUpdates: parsedMessage.withUpdates,
+            webhookSecret,
+            jql: fallbackJql || jql,
+            withUpdates: parsedMessage.withUpdates && !fallbackJql,
+            pollingUsername: polled
+              ? parsedMessage.context.senderUsername
+              : undefined,
+            pollsProject: fallbackJql ? true : undefined,
+            boardID: parsedMessage.boardID,
+            digest: parsedMessage.digest || undefined,
+            versionsProject: parsedMessage.versionsProject,
+            serviceDeskProject: parsedMessage.serviceDeskProject,
+            epicKey: parsedMessage.epicKey,
           },
         ],
       ])
@@ -148,6 +316,163 @@ const subscribe = async (
     return Errors.makeError(undefined)
   }
 
+  if (initialEpic) {
+    const updateStateRet = await context.configs.updateEpicSubscriptionState(
+      parsedMessage.context.teamName,
+      id,
+      undefined,
+      childrenToState(initialEpic.children)
+    )
+    if (updateStateRet.type === Errors.ReturnType.Error) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        updateStateRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Subscribed to epic ${parsedMessage.epicKey}. I'll check it every few minutes with your Jira account and post its progress when its issues change status:\n${id}: progress of epic ${parsedMessage.epicKey}\n\n` +
+        formatEpicProgress(initialEpic.epic, initialEpic.children)
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  if (initialServiceDesk) {
+    const updateStateRet = await context.configs.updateServiceDeskSubscriptionState(
+      parsedMessage.context.teamName,
+      id,
+      undefined,
+      initialServiceDesk
+    )
+    if (updateStateRet.type === Errors.ReturnType.Error) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        updateStateRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Subscribed to service desk ${parsedMessage.serviceDeskProject}. I'll check it every few minutes with your Jira account and announce new requests and SLA breaches here:\n${id}: requests of service desk ${parsedMessage.serviceDeskProject}`
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  if (initialVersions) {
+    const updateStateRet = await context.configs.updateVersionSubscriptionState(
+      parsedMessage.context.teamName,
+      id,
+      undefined,
+      initialVersions
+    )
+    if (updateStateRet.type === Errors.ReturnType.Error) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        updateStateRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Subscribed to the versions of ${parsedMessage.versionsProject}. I'll check them every few minutes with your Jira account and announce releases and release date changes:\n${id}: versions of ${parsedMessage.versionsProject}`
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  if (digestBoardName) {
+    // The first digest covers what moves from now on.
+    const updateStateRet = await context.configs.updateDigestSubscriptionState(
+      parsedMessage.context.teamName,
+      id,
+      undefined,
+      {lastPosted: new Date().toISOString()}
+    )
+    if (updateStateRet.type === Errors.ReturnType.Error) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        updateStateRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Subscribed to a daily digest of board ${digestBoardName}, made with your Jira account. Change when it's posted with \`!jira config channel digestTime <HH:mm>\`:\n${id}: daily digest of board ${parsedMessage.boardID}`
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  if (initialSprints) {
+    const updateStateRet = await context.configs.updateSprintSubscriptionState(
+      parsedMessage.context.teamName,
+      id,
+      undefined,
+      initialSprints
+    )
+    if (updateStateRet.type === Errors.ReturnType.Error) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        updateStateRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    const active = initialSprints.sprints.length
+    Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Subscribed to board ${initialSprints.boardName} with ${active} active sprint${
+        active !== 1 ? 's' : ''
+      } right now. I'll check it every few minutes with your Jira account and announce sprints starting and closing, and issues added to active sprints:\n${id}: sprints of board ${
+        parsedMessage.boardID
+      }`
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  if (parsedMessage.jql || fallbackJql) {
+    const updateStateRet = await context.configs.updateJqlSubscriptionState(
+      parsedMessage.context.teamName,
+      id,
+      undefined,
+      {issues: initialIssues.map(({key, summary}) => ({key, summary}))}
+    )
+    if (updateStateRet.type === Errors.ReturnType.Error) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        updateStateRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    if (fallbackJql) {
+      Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        `Jira didn't let me register a webhook for ${parsedMessage.project}, which takes a Jira admin. I'll check it every few minutes with your Jira account instead and announce new issues, though not comments${
+          parsedMessage.withUpdates ? ' nor issue updates' : ''
+        }:\n${id}: \`${fallbackJql}\``
+      )
+      return Errors.makeResult(undefined)
+    }
+    Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Subscribed to a JQL query with ${initialIssues.length} issue${
+        initialIssues.length !== 1 ? 's' : ''
+      } right now. I'll check it every few minutes with your Jira account and announce issues entering or leaving it:\n${id}: \`${jql}\``
+    )
+    return Errors.makeResult(undefined)
+  }
+
   Utils.replyToMessageContext(
     context,
     parsedMessage.context,
@@ -196,7 +521,10 @@ const unsubscribe = async (
   }
 
   try {
-    await jira.unsubscribe(subscription.webhookURI)
+    // polled subscriptions have no webhook
+    if (subscription.webhookURI) {
+      await jira.unsubscribe(subscription.webhookURI)
+    }
   } catch (err) {
     reportJiraError(context, parsedMessage.context, err)
     return Errors.makeError(undefined)
@@ -276,9 +604,7 @@ const list = async (
         subscriptions.reduce(
           (str, [subscriptionID, sub]) =>
             str +
-            `\n${subscriptionID}: \`${sub.jql}\`${
-              sub.withUpdates ? ' (with issue udpates)' : ''
-            }`,
+            `\n${subscriptionID}: ${formatSubscription(sub)}`,
           ''
         )
     )
@@ -306,9 +632,7 @@ const list = async (
         channelSubscriptions.reduce(
           (str, [subscriptionID, sub]) =>
             str +
-            `\n${subscriptionID}: \`${sub.jql}\`${
-              sub.withUpdates ? ' (with issue udpates)' : ''
-            }`,
+            `\n${subscriptionID}: ${formatSubscription(sub)}`,
           ''
         )
     )
@@ -0,0 +1,242 @@
+import * as Message from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Configs from './configs'
+import * as Utils from './utils'
+import logger from './logger'
+
+const updateTeamCustomFields = async (
+  context: Context,
+  teamname: string,
+  updater: (oldFields?: Configs.TeamCustomFields) => Configs.TeamCustomFields
+): Promise<Errors.ResultOrError<undefined, Errors.UnknownError>> => {
+  loop: for (let attempt = 0; attempt < 2; ++attempt) {
+    const getFieldsRet = await context.configs.getTeamCustomFields(teamname)
+    let oldFields = undefined
+    if (getFieldsRet.type === Errors.ReturnType.Error) {
+      switch (getFieldsRet.error.type) {
+        case Errors.ErrorType.Unknown:
+          return Errors.makeError(getFieldsRet.error)
+        case Errors.ErrorType.KVStoreNotFound:
+          break
+        default:
+          let _: never = getFieldsRet.error
+      }
+    } else {
+      oldFields = getFieldsRet.result
+    }
+    const updateRet = await context.configs.updateTeamCustomFields(
+      teamname,
+      oldFields,
+      updater(oldFields?.config)
+    )
+    if (updateRet.type === Errors.ReturnType.Error) {
+      switch (updateRet.error.type) {
+        case Errors.ErrorType.Unknown:
+          return Errors.makeError(updateRet.error)
+        case Errors.ErrorType.KVStoreRevision:
+          continue loop
+        default:
+          let _: never = updateRet.error
+      }
+    }
+    return Errors.makeResult(undefined)
+  }
+  return Errors.makeUnknownError('update kvstore failed')
+}
+
+const getProjectFields = async (
+  context: Context,
+  teamname: string,
+  projectKey: string
+): Promise<
+  Errors.ResultOrError<
+    ReadonlyArray<Configs.TeamCustomField>,
+    Errors.UnknownError
+  >
+> => {
+  const getFieldsRet = await context.configs.getTeamCustomFields(teamname)
+  if (getFieldsRet.type === Errors.ReturnType.Error) {
+    return getFieldsRet.error.type === Errors.ErrorType.KVStoreNotFound
+      ? Errors.makeResult([])
+      : Errors.makeError(getFieldsRet.error)
+  }
+  return Errors.makeResult(getFieldsRet.result.config.get(projectKey) || [])
+}
+
+// The configured custom fields of an issue's project, like
+// `Severity: S1 | Region: EU`. Empty if there's none or it has no value for
+// them.
+export const formatCustomFields = async (
+  context: Context,
+  teamname: string,
+  projectKey: string,
+  values: {[fieldID: string]: string}
+): Promise<string> => {
+  const fieldsRet = await getProjectFields(context, teamname, projectKey)
+  if (fieldsRet.type === Errors.ReturnType.Error) {
+    logger.warn({msg: 'formatCustomFields', error: fieldsRet.error})
+    return ''
+  }
+  return fieldsRet.result
+    .filter(({id}) => values[id])
+    .map(({id, name}) => `${name}: ${values[id]}`)
+    .join(' | ')
+}
+
+const formatFields = (fields: ReadonlyArray<Configs.TeamCustomField>) =>
+  fields.map(({id, name}) => `\n${name} (\`${id}\`)`).join('')
+
+const checkAdmin = async (
+  context: Context,
+  messageContext: Message.MessageContext
+): Promise<boolean> => {
+  const isAdminRet = await Utils.isTeamAdmin(
+    context,
+    messageContext.teamName,
+    messageContext.senderUsername
+  )
+  if (isAdminRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(context, messageContext, isAdminRet.error)
+    return false
+  }
+  if (!isAdminRet.result) {
+    await Utils.replyToMessageContext(
+      context,
+      messageContext,
+      'Only admins of the team can change which fields are shown.'
+    )
+    return false
+  }
+  return true
+}
+
+const add = async (
+  context: Context,
+  parsedMessage: Message.FieldsAddMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  if (!(await checkAdmin(context, parsedMessage.context))) {
+    return Errors.makeError(undefined)
+  }
+  let projectFields: ReadonlyArray<Configs.TeamCustomField> = []
+  const updateRet = await updateTeamCustomFields(
+    context,
+    parsedMessage.context.teamName,
+    (oldFields?: Configs.TeamCustomFields) => {
+      projectFields = [
+        ...(oldFields?.get(parsedMessage.project) || []).filter(
+          ({id}) => id !== parsedMessage.fieldID
+        ),
+        {id: parsedMessage.fieldID, name: parsedMessage.name},
+      ]
+      return new Map([
+        ...(oldFields?.entries() || []),
+        [parsedMessage.project, projectFields],
+      ])
+    }
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      updateRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    `Notifications and unfurls of ${parsedMessage.project} issues now show:` +
+      formatFields(projectFields)
+  )
+  return Errors.makeResult(undefined)
+}
+
+const remove = async (
+  context: Context,
+  parsedMessage: Message.FieldsRemoveMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  if (!(await checkAdmin(context, parsedMessage.context))) {
+    return Errors.makeError(undefined)
+  }
+  let found = false
+  const updateRet = await updateTeamCustomFields(
+    context,
+    parsedMessage.context.teamName,
+    (oldFields?: Configs.TeamCustomFields) => {
+      const oldProjectFields = oldFields?.get(parsedMessage.project) || []
+      found = oldProjectFields.some(({id}) => id === parsedMessage.fieldID)
+      return new Map([
+        ...(oldFields?.entries() || []),
+        [
+          parsedMessage.project,
+          oldProjectFields.filter(({id}) => id !== parsedMessage.fieldID),
+        ],
+      ])
+    }
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      updateRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  if (!found) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `${parsedMessage.fieldID} isn't shown for ${parsedMessage.project} issues.`
+    )
+    return Errors.makeError(undefined)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    `Notifications and unfurls of ${parsedMessage.project} issues don't show ${parsedMessage.fieldID} anymore.`
+  )
+  return Errors.makeResult(undefined)
+}
+
+const list = async (
+  context: Context,
+  parsedMessage: Message.FieldsListMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const fieldsRet = await getProjectFields(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.project
+  )
+  if (fieldsRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      fieldsRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    fieldsRet.result.length
+      ? `Notifications and unfurls of ${parsedMessage.project} issues show:` +
+          formatFields(fieldsRet.result)
+      : `Notifications and unfurls of ${parsedMessage.project} issues show no custom field.`
+  )
+  return Errors.makeResult(undefined)
+}
+
+export default async (
+  context: Context,
+  parsedMessage: Message.FieldsMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  switch (parsedMessage.fieldsMessageType) {
+    case Message.FieldsMessageType.Add:
+      return add(context, parsedMessage)
+    case Message.FieldsMessageType.Remove:
+      return remove(context, parsedMessage)
+    case Message.FieldsMessageType.List:
+      return list(context, parsedMessage)
+  }
+}
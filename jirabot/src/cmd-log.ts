@@ -0,0 +1,188 @@
+import moment from 'moment'
+import * as Message from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+
+// issues with worklogs beyond this many in a period are left out
+const maxTimesheetIssues = 100
+
+const formatTimeSpent = (seconds: number): string => {
+  const minutes = Math.round(seconds / 60)
+  const hours = Math.floor(minutes / 60)
+  return (
+    [hours && `${hours}h`, minutes % 60 && `${minutes % 60}m`]
+      .filter(Boolean)
+      .join(' ') || '0m'
+  )
+}
+
+const periodStart = (period: Message.TimesheetPeriod): moment.Moment => {
+  switch (period) {
+    case 'today':
+      return moment.utc().startOf('day')
+    case 'week':
+      return moment.utc().startOf('isoWeek')
+    case 'month':
+      return moment.utc().startOf('month')
+  }
+}
+
+const periodDescriptions: {[period in Message.TimesheetPeriod]: string} = {
+  today: 'today',
+  week: 'this week',
+  month: 'this month',
+}
+
+const getJira = async (
+  context: Context,
+  messageContext: Message.MessageContext
+): Promise<undefined | Jira.JiraClientWrapper> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    messageContext.teamName,
+    messageContext.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(context, messageContext, jiraRet.error)
+    return undefined
+  }
+  return jiraRet.result
+}
+
+export default async (
+  context: Context,
+  parsedMessage: Message.LogMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jira = await getJira(context, parsedMessage.context)
+  if (!jira) {
+    return Errors.makeError(undefined)
+  }
+  try {
+    const url = await jira.addWorklog(
+      parsedMessage.ticket,
+      parsedMessage.timeSpent,
+      parsedMessage.comment
+    )
+    context.stathat.postCount('worklogs', 1)
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `@${parsedMessage.context.senderUsername} Logged ${parsedMessage.timeSpent} on ${parsedMessage.ticket}: ${url}`
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
+
+export const timesheet = async (
+  context: Context,
+  parsedMessage: Message.TimesheetMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jira = await getJira(context, parsedMessage.context)
+  if (!jira) {
+    return Errors.makeError(undefined)
+  }
+  const accountIDRet = await Utils.getJiraAccountID(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (accountIDRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      accountIDRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const accountID = accountIDRet.result
+  const since = periodStart(parsedMessage.period)
+  const description = periodDescriptions[parsedMessage.period]
+
+  const byIssue: Array<{issue: Jira.Issue; seconds: number}> = []
+  const byDay = new Map<string, number>()
+  let truncated = false
+  try {
+    // Worklogs in search results are truncated, so they're fetched per issue.
+    const issues = await jira.search(
+      `worklogAuthor = currentUser() AND worklogDate >= "${since.format(
+        'YYYY-MM-DD'
+      )}" ORDER BY key ASC`,
+      maxTimesheetIssues
+    )
+    truncated = issues.length === maxTimesheetIssues
+    for (const issue of issues) {
+      const worklogs = (await jira.getWorklogs(issue.key)).filter(
+        worklog =>
+          worklog.authorID === accountID &&
+          !moment(worklog.started).isBefore(since)
+      )
+      if (!worklogs.length) {
+        continue
+      }
+      let seconds = 0
+      for (const worklog of worklogs) {
+        seconds += worklog.timeSpentSeconds
+        const day = moment.utc(worklog.started).format('YYYY-MM-DD')
+        byDay.set(day, (byDay.get(day) || 0) + worklog.timeSpentSeconds)
+      }
+      byIssue.push({issue, seconds})
+    }
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+
+  if (!byIssue.length) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `@${parsedMessage.context.senderUsername} You haven't logged any time ${description}. Log some with \`!jira log <issue-key> <time spent>\`.`
+    )
+    return Errors.makeResult(undefined)
+  }
+  const total = byIssue.reduce((sum, {seconds}) => sum + seconds, 0)
+  const lines = [
+    `@${parsedMessage.context.senderUsername} You logged *${formatTimeSpent(
+      total
+    )}* ${description}.`,
+    ...byIssue.map(
+      ({issue, seconds}) =>
+        `${formatTimeSpent(seconds)} - *${issue.key}* ${issue.summary}`
+    ),
+  ]
+  if (parsedMessage.period === 'week') {
+    lines.push(
+      [...byDay.entries()]
+        .sort(([a], [b]) => (a < b ? -1 : 1))
+        .map(
+          ([day, seconds]) =>
+            `${moment(day, 'YYYY-MM-DD').format('ddd')}: ${formatTimeSpent(
+              seconds
+            )}`
+        )
+        .join(' | ')
+    )
+  }
+  if (truncated) {
+    lines.push(`Only the first ${maxTimesheetIssues} issues are counted.`)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    lines.join('\n')
+  )
+  return Errors.makeResult(undefined)
+}
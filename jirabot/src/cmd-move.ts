@@ -0,0 +1,110 @@
+import {MoveMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Utils from './utils'
+import * as Jira from './jira'
+
+const normalize = (str: string): string =>
+  str.toLowerCase().replace(/[^a-z0-9]+/g, ' ').trim()
+
+const transitionNames = (transition: Jira.Transition): Array<string> =>
+  [transition.name, transition.toStatus].filter(Boolean).map(normalize)
+
+// Finds the transitions matching what the user typed, trying the strictest
+// way of matching first: the exact name of the transition or of the status
+// it goes to, then a prefix, then all the words appearing in either.
+export const matchTransitions = (
+  transitions: Array<Jira.Transition>,
+  input: string
+): Array<Jira.Transition> => {
+  const query = normalize(input)
+  if (!query) {
+    return []
+  }
+  const words = query.split(' ')
+  const matchers: Array<(name: string) => boolean> = [
+    name => name === query,
+    name => name.startsWith(query),
+    name => words.every(word => name.includes(word)),
+  ]
+  for (const matcher of matchers) {
+    const matched = transitions.filter(transition =>
+      transitionNames(transition).some(matcher)
+    )
+    if (matched.length) {
+      return matched
+    }
+  }
+  return []
+}
+
+const formatTransitions = (transitions: Array<Jira.Transition>): string =>
+  transitions
+    .map(({name, toStatus}) =>
+      toStatus && toStatus !== name
+        ? `\`${name}\` (to ${toStatus})`
+        : `\`${name}\``
+    )
+    .join(', ')
+
+export default async (
+  context: Context,
+  parsedMessage: MoveMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+  try {
+    const transitions = await jira.getTransitions(parsedMessage.ticket)
+    if (!transitions.length) {
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        `You can't move ${parsedMessage.ticket} anywhere right now.`
+      )
+      return Errors.makeError(undefined)
+    }
+    const matched = matchTransitions(transitions, parsedMessage.transition)
+    if (matched.length !== 1) {
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        (matched.length
+          ? `"${parsedMessage.transition}" could be ${formatTransitions(
+              matched
+            )}.`
+          : `${parsedMessage.ticket} can't be moved to "${parsedMessage.transition}".`) +
+          ` Valid transitions are ${formatTransitions(transitions)}.`
+      )
+      return Errors.makeError(undefined)
+    }
+    const transition = matched[0]
+    const url = await jira.transitionIssue(parsedMessage.ticket, transition.id)
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `@${parsedMessage.context.senderUsername} Moved ${
+        parsedMessage.ticket
+      } to ${transition.toStatus || transition.name}: ${url}`
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
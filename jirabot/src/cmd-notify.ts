@@ -0,0 +1,183 @@
+import {NotifyMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Configs from './configs'
+import * as Utils from './utils'
+
+const kindDescriptions: {[kind in Configs.NotificationKind]: string} = {
+  mentions: 'mentions in comments',
+  assignments: 'issues assigned to you',
+  approvals: 'approvals requested from you',
+}
+
+const formatPreferences = (
+  preferences?: Configs.UserNotificationPreferences
+): string => {
+  const enabled =
+    preferences && Configs.notificationKinds.filter(kind => preferences[kind])
+  if (!enabled || !enabled.length) {
+    return "I don't send you private messages about Jira issues. Turn them on with `!jira notify on`."
+  }
+  return (
+    `I send you private messages about ${enabled
+      .map(kind => kindDescriptions[kind])
+      .join(', ')}.` +
+    (preferences?.quietHours
+      ? ` Quiet hours are from ${preferences.quietHours.start} to ${preferences.quietHours.end}.`
+      : '')
+  )
+}
+
+const updatePreferences = async (
+  context: Context,
+  teamname: string,
+  username: string,
+  updater: (
+    oldPreferences: Configs.UserNotificationPreferences
+  ) => Configs.UserNotificationPreferences
+): Promise<
+  Errors.ResultOrError<Configs.UserNotificationPreferences, Errors.UnknownError>
+> => {
+  loop: for (let attempt = 0; attempt < 2; ++attempt) {
+    const getRet = await context.configs.getUserNotificationPreferences(
+      teamname,
+      username
+    )
+    let oldPreferences = undefined
+    if (getRet.type === Errors.ReturnType.Error) {
+      switch (getRet.error.type) {
+        case Errors.ErrorType.Unknown:
+          return Errors.makeError(getRet.error)
+        case Errors.ErrorType.KVStoreNotFound:
+          break
+        default:
+          let _: never = getRet.error
+      }
+    } else {
+      oldPreferences = getRet.result
+    }
+    const newPreferences = updater(
+      oldPreferences
+        ? oldPreferences.config
+        : Configs.defaultUserNotificationPreferences
+    )
+    const updateRet = await context.configs.updateUserNotificationPreferences(
+      teamname,
+      username,
+      oldPreferences,
+      newPreferences
+    )
+    if (updateRet.type === Errors.ReturnType.Error) {
+      switch (updateRet.error.type) {
+        case Errors.ErrorType.Unknown:
+          return Errors.makeError(updateRet.error)
+        case Errors.ErrorType.KVStoreRevision:
+          continue loop
+        default:
+          let _: never = updateRet.error
+      }
+    }
+    return Errors.makeResult(newPreferences)
+  }
+  return Errors.makeUnknownError('update kvstore failed')
+}
+
+export default async (
+  context: Context,
+  parsedMessage: NotifyMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const {teamName, senderUsername} = parsedMessage.context
+  const userConfigRet = await context.configs.getTeamUserConfig(
+    teamName,
+    senderUsername
+  )
+  if (userConfigRet.type === Errors.ReturnType.Error) {
+    if (userConfigRet.error.type === Errors.ErrorType.KVStoreNotFound) {
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        'I need to know your Jira account first. Connect it with `!jira auth`.'
+      )
+    } else {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        userConfigRet.error
+      )
+    }
+    return Errors.makeError(undefined)
+  }
+
+  const {enable, quietHours} = parsedMessage
+  if (!enable && quietHours === undefined) {
+    const getRet = await context.configs.getUserNotificationPreferences(
+      teamName,
+      senderUsername
+    )
+    if (
+      getRet.type === Errors.ReturnType.Error &&
+      getRet.error.type !== Errors.ErrorType.KVStoreNotFound
+    ) {
+      Errors.reportErrorAndReplyChat(
+        context,
+        parsedMessage.context,
+        getRet.error
+      )
+      return Errors.makeError(undefined)
+    }
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      formatPreferences(
+        getRet.type === Errors.ReturnType.Ok ? getRet.result.config : undefined
+      )
+    )
+    return Errors.makeResult(undefined)
+  }
+
+  const setAccountRet = await context.configs.setJiraAccountUser(
+    teamName,
+    userConfigRet.result.config.jiraAccountID,
+    {username: senderUsername}
+  )
+  if (setAccountRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      setAccountRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+
+  const updateRet = await updatePreferences(
+    context,
+    teamName,
+    senderUsername,
+    oldPreferences => ({
+      ...oldPreferences,
+      ...(enable
+        ? enable.kinds.reduce(
+            (toSet, kind) => ({...toSet, [kind]: enable.enabled}),
+            {}
+          )
+        : {}),
+      ...(quietHours !== undefined
+        ? {quietHours: quietHours || undefined}
+        : {}),
+    })
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      updateRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    formatPreferences(updateRet.result)
+  )
+  return Errors.makeResult(undefined)
+}
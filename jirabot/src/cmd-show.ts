@@ -5,7 +5,7 @@ import {Context} from './context'
 import * as Errors from './errors'
 import * as Utils from './utils'
 
-const issueTypeToEmojiMaybe = (issueType: string) => {
+export const issueTypeToEmojiMaybe = (issueType: string) => {
   switch (issueType) {
     case 'Story':
       return ':scroll:'
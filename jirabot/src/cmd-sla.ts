@@ -0,0 +1,251 @@
+import * as Message from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Configs from './configs'
+import * as Utils from './utils'
+import {formatSlaRule, getSlaRuleJql} from './sla-poller'
+
+const updateTeamSlaRules = async (
+  context: Context,
+  teamname: string,
+  updater: (oldRules?: Configs.TeamSlaRules) => Configs.TeamSlaRules
+): Promise<Errors.ResultOrError<undefined, Errors.UnknownError>> => {
+  loop: for (let attempt = 0; attempt < 2; ++attempt) {
+    const getRulesRet = await context.configs.getTeamSlaRules(teamname)
+    let oldRules = undefined
+    if (getRulesRet.type === Errors.ReturnType.Error) {
+      switch (getRulesRet.error.type) {
+        case Errors.ErrorType.Unknown:
+          return Errors.makeError(getRulesRet.error)
+        case Errors.ErrorType.KVStoreNotFound:
+          break
+        default:
+          let _: never = getRulesRet.error
+      }
+    } else {
+      oldRules = getRulesRet.result
+    }
+    const updateRet = await context.configs.updateTeamSlaRules(
+      teamname,
+      oldRules,
+      updater(oldRules?.config)
+    )
+    if (updateRet.type === Errors.ReturnType.Error) {
+      switch (updateRet.error.type) {
+        case Errors.ErrorType.Unknown:
+          return Errors.makeError(updateRet.error)
+        case Errors.ErrorType.KVStoreRevision:
+          continue loop
+        default:
+          let _: never = updateRet.error
+      }
+    }
+    return Errors.makeResult(undefined)
+  }
+  return Errors.makeUnknownError('update kvstore failed')
+}
+
+const getChannelSubscriptions = async (
+  context: Context,
+  messageContext: Message.MessageContext
+): Promise<
+  Errors.ResultOrError<Configs.TeamJiraSubscriptions, Errors.UnknownError>
+> => {
+  const getSubRet = await context.configs.getTeamJiraSubscriptions(
+    messageContext.teamName
+  )
+  if (getSubRet.type === Errors.ReturnType.Error) {
+    return getSubRet.error.type === Errors.ErrorType.KVStoreNotFound
+      ? Errors.makeResult(new Map())
+      : Errors.makeError(getSubRet.error)
+  }
+  return Errors.makeResult(getSubRet.result.config)
+}
+
+const add = async (
+  context: Context,
+  parsedMessage: Message.SlaAddMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  const subscriptionsRet = await getChannelSubscriptions(
+    context,
+    parsedMessage.context
+  )
+  if (subscriptionsRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      subscriptionsRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const rule: Configs.TeamSlaRule = {
+    conversationId: parsedMessage.context.conversationId,
+    priority: parsedMessage.priority,
+    thresholdMinutes: parsedMessage.thresholdMinutes,
+    // the rule is checked with the Jira account of whoever added it
+    pollingUsername: parsedMessage.context.senderUsername,
+  }
+  const jql = getSlaRuleJql(rule, subscriptionsRet.result)
+  if (!jql) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      'SLA rules apply to the projects this channel is subscribed to, and there is none yet. Subscribe with `!jira feed subscribe <project>` first.'
+    )
+    return Errors.makeError(undefined)
+  }
+
+  try {
+    // Jira rejects unknown priorities
+    await jira.search(jql, 1)
+  } catch (err) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `I couldn't check "${parsedMessage.priority}" issues with your Jira account. Is it the name of a priority?`
+    )
+    return Errors.makeError(undefined)
+  }
+
+  let id = 0
+  const updateRet = await updateTeamSlaRules(
+    context,
+    parsedMessage.context.teamName,
+    (oldRules?: Configs.TeamSlaRules) => {
+      const oldEntries = [...(oldRules?.entries() || [])]
+      id =
+        oldEntries.reduce(
+          (max: number, [current]) => (current > max ? current : max),
+          0
+        ) + 1
+      return new Map([...oldEntries, [id, rule]])
+    }
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      updateRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    `I'll check every few minutes with your Jira account and alert this channel about ${formatSlaRule(
+      rule
+    )}, then again with \`@here\` at twice that and \`@channel\` at four times that:\n${id}: ${formatSlaRule(
+      rule
+    )}`
+  )
+  return Errors.makeResult(undefined)
+}
+
+const remove = async (
+  context: Context,
+  parsedMessage: Message.SlaRemoveMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  let found = false
+  const updateRet = await updateTeamSlaRules(
+    context,
+    parsedMessage.context.teamName,
+    (oldRules?: Configs.TeamSlaRules) => {
+      const oldEntries = [...(oldRules?.entries() || [])]
+      found = oldEntries.some(([ruleID]) => ruleID === parsedMessage.ruleID)
+      return new Map(
+        oldEntries.filter(([ruleID]) => ruleID !== parsedMessage.ruleID)
+      )
+    }
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      updateRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  if (!found) {
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      `Unknown SLA rule ID ${parsedMessage.ruleID}`
+    )
+    return Errors.makeError(undefined)
+  }
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    `Removed SLA rule ${parsedMessage.ruleID}.`
+  )
+  return Errors.makeResult(undefined)
+}
+
+const list = async (
+  context: Context,
+  parsedMessage: Message.SlaListMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const getRulesRet = await context.configs.getTeamSlaRules(
+    parsedMessage.context.teamName
+  )
+  if (
+    getRulesRet.type === Errors.ReturnType.Error &&
+    getRulesRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      getRulesRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const rules = (getRulesRet.type === Errors.ReturnType.Ok
+    ? [...getRulesRet.result.config.entries()]
+    : []
+  ).filter(
+    ([_, {conversationId}]) =>
+      conversationId === parsedMessage.context.conversationId
+  )
+  await Utils.replyToMessageContext(
+    context,
+    parsedMessage.context,
+    `This channel has ${rules.length || 'no'} SLA rule${
+      rules.length !== 1 ? 's' : ''
+    }.` +
+      rules.reduce(
+        (str, [ruleID, rule]) => str + `\n${ruleID}: ${formatSlaRule(rule)}`,
+        ''
+      )
+  )
+  return Errors.makeResult(undefined)
+}
+
+export default async (
+  context: Context,
+  parsedMessage: Message.SlaMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  switch (parsedMessage.slaMessageType) {
+    case Message.SlaMessageType.Add:
+      return add(context, parsedMessage)
+    case Message.SlaMessageType.Remove:
+      return remove(context, parsedMessage)
+    case Message.SlaMessageType.List:
+      return list(context, parsedMessage)
+  }
+}
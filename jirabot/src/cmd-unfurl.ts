@@ -0,0 +1,144 @@
+import util from 'util'
+import {Issue as JiraIssue} from './jira'
+import {UnfurlMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Utils from './utils'
+import {issueTypeToEmojiMaybe} from './cmd-show'
+import {formatCustomFields} from './cmd-fields'
+import logger from './logger'
+
+const setTimeoutPromise = util.promisify(setTimeout)
+
+// more issues than this in one message are left alone
+const maxUnfurlsPerMessage = 3
+
+// Issues unfurled recently in a conversation, which are not unfurled again
+// while people keep talking about them.
+const recentlyUnfurled = new Set<string>()
+
+const markUnfurled = (conversationId: string, issueKey: string) => {
+  const key = `${conversationId}:${issueKey}`
+  recentlyUnfurled.add(key)
+  setTimeoutPromise(1000 * 60 * 10 /* 10min */).then(() =>
+    recentlyUnfurled.delete(key)
+  )
+}
+
+const formatCard = (issue: JiraIssue, customFields: string) =>
+  [
+    `${issueTypeToEmojiMaybe(issue.issueType)} *${issue.key}* ${
+      issue.summary
+    }`,
+    [
+      issue.status,
+      issue.priority && `Priority: ${issue.priority}`,
+      issue.assigneeJira
+        ? `Assigned to _${issue.assigneeJira}_`
+        : 'Not assigned',
+      issue.fixVersions.length
+        ? `Fix version: ${issue.fixVersions.join(', ')}`
+        : '',
+      issue.url,
+    ]
+      .filter(Boolean)
+      .join(' | '),
+    customFields,
+  ]
+    .filter(Boolean)
+    .join('\n')
+
+const isSubscribedConversation = async (
+  context: Context,
+  teamname: string,
+  conversationId: string
+): Promise<boolean> => {
+  const getSubRet = await context.configs.getTeamJiraSubscriptions(teamname)
+  if (getSubRet.type === Errors.ReturnType.Error) {
+    getSubRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+      logger.warn({msg: 'isSubscribedConversation', error: getSubRet.error})
+    return false
+  }
+  return [...getSubRet.result.config.values()].some(
+    subscription => subscription.conversationId === conversationId
+  )
+}
+
+// Unfurls are best effort: nothing is said in the conversation when they
+// can't be made, e.g. if the sender hasn't connected their Jira account.
+export default async (
+  context: Context,
+  parsedMessage: UnfurlMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const {teamName, conversationId, senderUsername} = parsedMessage.context
+  const channelConfigRet = await context.configs.getTeamChannelConfig(
+    teamName,
+    conversationId
+  )
+  if (
+    channelConfigRet.type === Errors.ReturnType.Error &&
+    channelConfigRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({msg: 'unfurl', error: channelConfigRet.error})
+    return Errors.makeError(undefined)
+  }
+  const channelConfig =
+    channelConfigRet.type === Errors.ReturnType.Ok
+      ? channelConfigRet.result.config
+      : undefined
+  if (channelConfig?.unfurl === false) {
+    return Errors.makeResult(undefined)
+  }
+
+  const issueKeys = parsedMessage.issueKeys.filter(
+    issueKey =>
+      !recentlyUnfurled.has(`${conversationId}:${issueKey}`) &&
+      (!channelConfig?.unfurlProjects ||
+        channelConfig.unfurlProjects.includes(issueKey.split('-')[0]))
+  )
+  if (
+    !issueKeys.length ||
+    issueKeys.length > maxUnfurlsPerMessage ||
+    !(await isSubscribedConversation(context, teamName, conversationId))
+  ) {
+    return Errors.makeResult(undefined)
+  }
+
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamName,
+    senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  for (const issueKey of issueKeys) {
+    let issue: JiraIssue
+    try {
+      issue = await jira.get({issueKey})
+    } catch (error) {
+      // most likely not an issue key after all
+      logger.debug({msg: 'unfurl', issueKey, error})
+      continue
+    }
+    markUnfurled(conversationId, issueKey)
+    context.stathat.postCount('unfurls', 1)
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      formatCard(
+        issue,
+        await formatCustomFields(
+          context,
+          teamName,
+          issue.projectKey,
+          issue.customFields
+        )
+      ),
+      true
+    )
+  }
+  return Errors.makeResult(undefined)
+}
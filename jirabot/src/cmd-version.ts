@@ -0,0 +1,100 @@
+import {VersionMessage} from './message'
+import {Context} from './context'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import {statusToEmoji} from './emoji'
+
+// issues beyond this many in a version are only counted
+const maxListedIssues = 30
+const maxVersionIssues = 500
+
+export default async (
+  context: Context,
+  parsedMessage: VersionMessage
+): Promise<Errors.ResultOrError<undefined, undefined>> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    parsedMessage.context.teamName,
+    parsedMessage.context.senderUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      jiraRet.error
+    )
+    return Errors.makeError(undefined)
+  }
+  const jira = jiraRet.result
+
+  try {
+    const versions = await jira.getProjectVersions(parsedMessage.project)
+    const lowered = parsedMessage.version.toLowerCase()
+    const version = versions.find(({name}) => name.toLowerCase() === lowered)
+    if (!version) {
+      const unreleased = versions.filter(({released}) => !released)
+      await Utils.replyToMessageContext(
+        context,
+        parsedMessage.context,
+        `${parsedMessage.project} has no version "${parsedMessage.version}".` +
+          (unreleased.length
+            ? ` Unreleased versions are ${unreleased
+                .map(({name}) => `\`${name}\``)
+                .join(', ')}.`
+            : '')
+      )
+      return Errors.makeError(undefined)
+    }
+
+    const issues = await jira.search(
+      `project = "${parsedMessage.project}" AND fixVersion = ${
+        version.id
+      } ORDER BY status DESC, key ASC`,
+      maxVersionIssues
+    )
+    const byStatus = new Map<string, number>()
+    issues.forEach(({status}) =>
+      byStatus.set(status, (byStatus.get(status) || 0) + 1)
+    )
+    const lines = [
+      `*${parsedMessage.project} ${version.name}*: ${
+        version.released ? 'released' : 'unreleased'
+      }${version.releaseDate ? `, release date ${version.releaseDate}` : ''}.`,
+      issues.length
+        ? `${issues.length}${
+            issues.length === maxVersionIssues ? '+' : ''
+          } issue${issues.length !== 1 ? 's' : ''}: ` +
+          [...byStatus.entries()]
+            .map(
+              ([status, count]) => `${statusToEmoji(status)} ${status} ${count}`
+            )
+            .join(', ')
+        : 'No issue in it yet.',
+      ...issues
+        .slice(0, maxListedIssues)
+        .map(
+          issue =>
+            `${statusToEmoji(issue.status)} *${issue.key}* ${issue.summary}${
+              issue.assigneeJira ? ` (${issue.assigneeJira})` : ''
+            }`
+        ),
+    ]
+    if (issues.length > maxListedIssues) {
+      lines.push(`and ${issues.length - maxListedIssues} more`)
+    }
+    await Utils.replyToMessageContext(
+      context,
+      parsedMessage.context,
+      lines.join('\n')
+    )
+    return Errors.makeResult(undefined)
+  } catch (err) {
+    Errors.reportErrorAndReplyChat(
+      context,
+      parsedMessage.context,
+      Errors.makeUnknownError(err).error
+    )
+    return Errors.makeError(undefined)
+  }
+}
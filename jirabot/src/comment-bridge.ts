@@ -0,0 +1,154 @@
+import util from 'util'
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import logger from './logger'
+
+const setTimeoutPromise = util.promisify(setTimeout)
+
+// longer Jira comments are cut in the thread
+const maxCommentLength = 1000
+
+// Jira comments posted from Keybase threads, which the comment_created
+// webhook shouldn't bring back into the thread.
+const bridgedCommentIDs = new Set<string>()
+
+export const markBridgedComment = (commentID: string) => {
+  bridgedCommentIDs.add(commentID)
+  setTimeoutPromise(1000 * 60 * 10 /* 10min */).then(() =>
+    bridgedCommentIDs.delete(commentID)
+  )
+}
+
+const getThreadByIssue = async (
+  context: Context,
+  teamname: string,
+  conversationId: string,
+  issueKey: string
+): Promise<undefined | Configs.CommentThread> => {
+  const threadRet = await context.configs.getCommentThreadByIssue(
+    teamname,
+    conversationId,
+    issueKey
+  )
+  if (threadRet.type === Errors.ReturnType.Error) {
+    threadRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+      logger.warn({msg: 'getThreadByIssue', error: threadRet.error})
+    return undefined
+  }
+  return threadRet.result.config
+}
+
+// Sends an announcement of an issue. The first one in a conversation becomes
+// the thread later Jira comments on the issue are posted under.
+export const announceIssue = async (
+  context: Context,
+  teamname: string,
+  conversationId: string,
+  issueKey: string,
+  body: string
+): Promise<void> => {
+  const sent = await context.bot.chat.send(conversationId, {body})
+  if (!sent?.id) {
+    return
+  }
+  if (await getThreadByIssue(context, teamname, conversationId, issueKey)) {
+    return
+  }
+  const thread = {issueKey, messageID: sent.id}
+  const byIssueRet = await context.configs.setCommentThreadByIssue(
+    teamname,
+    conversationId,
+    thread
+  )
+  const byMessageRet = await context.configs.setCommentThreadByMessage(
+    teamname,
+    conversationId,
+    thread
+  )
+  for (const ret of [byIssueRet, byMessageRet]) {
+    ret.type === Errors.ReturnType.Error &&
+      logger.warn({msg: 'announceIssue', error: ret.error})
+  }
+}
+
+// Posts a Jira comment in the thread of its issue, if it was announced in the
+// conversation.
+export const threadComment = async (
+  context: Context,
+  teamname: string,
+  conversationId: string,
+  baseURL: string,
+  payload: any
+): Promise<void> => {
+  const issueKey = payload.issue?.key
+  const comment = payload.comment
+  if (
+    typeof issueKey !== 'string' ||
+    typeof comment?.id !== 'string' ||
+    typeof comment?.body !== 'string'
+  ) {
+    logger.warn({msg: 'threadComment', error: 'unexpected comment'})
+    return
+  }
+  if (bridgedCommentIDs.has(comment.id)) {
+    return
+  }
+  const thread = await getThreadByIssue(
+    context,
+    teamname,
+    conversationId,
+    issueKey
+  )
+  if (!thread) {
+    return
+  }
+
+  const text =
+    comment.body.length > maxCommentLength
+      ? comment.body.slice(0, maxCommentLength) + '…'
+      : comment.body
+  const author = comment.author?.displayName || 'Someone'
+  const quoted = text
+    .split('\n')
+    .map((line: string) => '> ' + line)
+    .join('\n')
+  const url = `${baseURL}/browse/${issueKey}?focusedCommentId=${comment.id}`
+  context.stathat.postCount('webhook CommentCreated', 1)
+  const sent = await context.bot.chat.send(
+    conversationId,
+    {body: `${author} commented on ${issueKey}:\n${quoted}\n${url}`},
+    {replyTo: thread.messageID}
+  )
+  if (!sent?.id) {
+    return
+  }
+  // replies to the comment go to the same issue
+  const setRet = await context.configs.setCommentThreadByMessage(
+    teamname,
+    conversationId,
+    {issueKey, messageID: sent.id}
+  )
+  setRet.type === Errors.ReturnType.Error &&
+    logger.warn({msg: 'threadComment', error: setRet.error})
+}
+
+// the issue a Keybase message replying in a comment thread is about
+export const getThreadIssueKey = async (
+  context: Context,
+  teamname: string,
+  conversationId: string,
+  replyTo: number
+): Promise<undefined | string> => {
+  const threadRet = await context.configs.getCommentThreadByMessage(
+    teamname,
+    conversationId,
+    replyTo
+  )
+  if (threadRet.type === Errors.ReturnType.Error) {
+    threadRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+      logger.warn({msg: 'getThreadIssueKey', error: threadRet.error})
+    return undefined
+  }
+  return threadRet.result.config.issueKey
+}
@@ -3,9 +3,18 @@ import {BotConfig} from './bot-config'
 import * as Errors from './errors'
 import * as ChatTypes from 'keybase-bot/lib/types/chat1'
 
+export enum JiraDeployment {
+  Cloud = 'cloud',
+  Server = 'server', // self-hosted Jira Server or Data Center
+}
+
 // namespace: jirabot-v1-team-[teamname]; key: jiraConfig
 export type TeamJiraConfig = Readonly<{
   jiraHost: string
+  deployment?: JiraDeployment // Cloud if undefined
+  // Server only, e.g. https://jira.example.com:8443/jira. Cloud is always at
+  // https://[jiraHost].
+  baseURL?: string
   jiraAuth: Readonly<{
     consumerKey: string
     publicKey: string
@@ -16,25 +25,97 @@ export type TeamJiraConfig = Readonly<{
 // namespace: jirabot-v1-team-[teamname]; key: user-[keybase username]
 export type TeamUserConfig = Readonly<{
   jiraAccountID: string
+  // OAuth tokens; empty if the user authenticated with one of the below.
   accessToken: string
   tokenSecret: string
+  personalAccessToken?: string // Server only
+  // Server username and password, or Cloud email and API token
+  basicAuth?: Readonly<{
+    username: string
+    password: string
+  }>
+}>
+
+// namespace: jirabot-v1-team-[teamname]; key: notify-[keybase username]
+// What a user gets private messages about, for issues of projects subscribed
+// to in the team.
+export type NotificationKind = 'mentions' | 'assignments' | 'approvals'
+
+export const notificationKinds: Array<NotificationKind> = [
+  'mentions',
+  'assignments',
+  'approvals',
+]
+
+export type UserNotificationPreferences = Readonly<{
+  mentions: boolean
+  assignments: boolean
+  approvals: boolean
+  // as `HH:mm +hh:mm`; nothing is sent from start until end
+  quietHours?: Readonly<{start: string; end: string}>
+}>
+
+export const defaultUserNotificationPreferences: UserNotificationPreferences = {
+  mentions: true,
+  assignments: true,
+  approvals: true,
+}
+
+// namespace: jirabot-v1-team-[teamname]; key: account-[Jira account ID]
+// The Keybase user of a Jira account, set once they turn notifications on.
+export type JiraAccountUser = Readonly<{
+  username: string
 }>
 
 // namespace: jirabot-v1-team-[teamname]; key: channel-[conversationId]
 export type TeamChannelConfig = Readonly<{
   defaultNewIssueProject?: string
+  // when board digests are posted each day, as `HH:mm`, or `HH:mm +hh:mm`
+  // with an UTC offset; 09:00 UTC if not set
+  digestTime?: string
+  // Issue keys in messages of subscribed channels are unfurled unless this
+  // is false, only for these projects if set.
+  unfurl?: boolean
+  unfurlProjects?: Array<string>
 }>
 
 export const emptyTeamChannelConfig: TeamChannelConfig = {
   defaultNewIssueProject: undefined,
+  digestTime: undefined,
+  unfurl: undefined,
+  unfurlProjects: undefined,
 }
 
 export type TeamJiraSubscription = {
   conversationId: string
-  webhookURI: string // needed for unsubscribing
+  webhookURI: string // needed for unsubscribing; empty for polled subscriptions
   urlToken: string
+  // Set for webhooks registered with a secret, whose deliveries are checked
+  // against their signature.
+  webhookSecret?: string
   jql: string
   withUpdates: boolean
+  // Set for JQL and board subscriptions, which are polled with this user's
+  // Jira account.
+  pollingUsername?: string
+  // Set for project subscriptions polled as Jira didn't let a webhook be
+  // registered. Only new issues are announced, not the ones leaving the
+  // results.
+  pollsProject?: boolean
+  // Set for board subscriptions, which announce sprints instead of issues.
+  boardID?: number
+  // Set for board subscriptions posting a daily digest of what moved on the
+  // board instead of announcing sprints.
+  digest?: boolean
+  // Set for version subscriptions, which announce the releases of this
+  // project's versions and changes of their dates instead of issues.
+  versionsProject?: string
+  // Set for service desk subscriptions, which announce new requests and SLA
+  // breaches of this Jira Service Management project.
+  serviceDeskProject?: string
+  // Set for epic subscriptions, which post the progress of this epic when its
+  // issues change status.
+  epicKey?: string
 }
 
 export type TeamJiraSubscriptions = Readonly<
@@ -44,6 +125,87 @@ export type TeamJiraSubscriptions = Readonly<
   >
 >
 
+// namespace: jirabot-v1-team-[teamname]; key: slaRules
+// Alerts of unresolved issues of a priority open for longer than a threshold,
+// in the projects the channel is subscribed to.
+export type TeamSlaRule = Readonly<{
+  conversationId: string
+  priority: string
+  thresholdMinutes: number
+  pollingUsername: string
+}>
+
+export type TeamSlaRules = Readonly<Map<number, TeamSlaRule>>
+
+// namespace: jirabot-v1-team-[teamname]; key: customFields
+// Custom fields shown in notifications and unfurls of issues, by project key.
+export type TeamCustomField = Readonly<{
+  id: string // like customfield_10023
+  name: string
+}>
+
+export type TeamCustomFields = Readonly<
+  Map<string, ReadonlyArray<TeamCustomField>>
+>
+
+// namespace: jirabot-v1-team-[teamname]; key: slaState-[rule ID]
+export type SlaRuleState = Readonly<{
+  // the escalation level each breaching issue was last alerted at
+  alerts: Array<Readonly<{key: string; level: number}>>
+}>
+
+// namespace: jirabot-v1-team-[teamname]; key: jqlState-[subscription ID]
+export type JqlSubscriptionState = Readonly<{
+  issues: Array<Readonly<{key: string; summary: string}>>
+}>
+
+// namespace: jirabot-v1-team-[teamname]; key: sprintState-[subscription ID]
+export type SprintSubscriptionState = Readonly<{
+  boardName: string
+  // active sprints of the board, with the issues they had at the last poll
+  sprints: Array<Readonly<{id: number; name: string; issues: Array<string>}>>
+}>
+
+// namespace: jirabot-v1-team-[teamname]; key: versionState-[subscription ID]
+export type VersionSubscriptionState = Readonly<{
+  versions: Array<
+    Readonly<{
+      id: string
+      name: string
+      released: boolean
+      releaseDate: string // empty if not set
+    }>
+  >
+}>
+
+// namespace: jirabot-v1-team-[teamname];
+// key: serviceDeskState-[subscription ID]
+export type ServiceDeskSubscriptionState = Readonly<{
+  requests: Array<string> // keys of the latest requests
+  breached: Array<string> // keys of the open requests breaching an SLA
+}>
+
+// namespace: jirabot-v1-team-[teamname]; key: epicState-[subscription ID]
+export type EpicSubscriptionState = Readonly<{
+  // issues of the epic with their status at the last poll
+  children: Array<Readonly<{key: string; status: string}>>
+}>
+
+// namespace: jirabot-v1-team-[teamname]; key: digestState-[subscription ID]
+export type DigestSubscriptionState = Readonly<{
+  lastPosted: string // ISO 8601
+}>
+
+// namespace: jirabot-v1-team-[teamname];
+// key: threadByIssue-[conversationId]-[issue key] or
+// threadByMessage-[conversationId]-[message ID]
+// The Keybase message announcing an issue, which Jira comments are threaded
+// under.
+export type CommentThread = Readonly<{
+  issueKey: string
+  messageID: number
+}>
+
 // this is the value. key is urlToken
 export type JiraSubscriptionIndex = Readonly<{
   teamname: string
@@ -54,9 +216,36 @@ const getNamespace = (teamname: string): string => `jirabot-v1-team-${teamname}`
 const jiraSubscriptionIndexNamespace = 'jirabot-v1-subscription-index'
 const jiraConfigKey = 'jiraConfig'
 const getTeamUserConfigKey = (username: string) => `user-${username}`
+const getUserNotificationPreferencesKey = (username: string) =>
+  `notify-${username}`
+const getJiraAccountUserKey = (jiraAccountID: string) =>
+  `account-${jiraAccountID}`
 const getTeamChannelConfigKey = (conversationId: ChatTypes.ConvIDStr) =>
   `channel-${conversationId}`
 const jiraSubscriptionsKey = 'jiraSubscriptions'
+const slaRulesKey = 'slaRules'
+const customFieldsKey = 'customFields'
+const getSlaRuleStateKey = (ruleID: number) => `slaState-${ruleID}`
+const getJqlSubscriptionStateKey = (subscriptionID: number) =>
+  `jqlState-${subscriptionID}`
+const getSprintSubscriptionStateKey = (subscriptionID: number) =>
+  `sprintState-${subscriptionID}`
+const getVersionSubscriptionStateKey = (subscriptionID: number) =>
+  `versionState-${subscriptionID}`
+const getServiceDeskSubscriptionStateKey = (subscriptionID: number) =>
+  `serviceDeskState-${subscriptionID}`
+const getEpicSubscriptionStateKey = (subscriptionID: number) =>
+  `epicState-${subscriptionID}`
+const getDigestSubscriptionStateKey = (subscriptionID: number) =>
+  `digestState-${subscriptionID}`
+const getCommentThreadByIssueKey = (
+  conversationId: ChatTypes.ConvIDStr,
+  issueKey: string
+) => `threadByIssue-${conversationId}-${issueKey}`
+const getCommentThreadByMessageKey = (
+  conversationId: ChatTypes.ConvIDStr,
+  messageID: number
+) => `threadByMessage-${conversationId}-${messageID}`
 
 const jsonToTeamJiraConfig = (
   objectFromJson: any
@@ -66,12 +255,18 @@ const jsonToTeamJiraConfig = (
     !objectFromJson.jiraAuth ||
     typeof objectFromJson.jiraAuth.consumerKey !== 'string' ||
     typeof objectFromJson.jiraAuth.publicKey !== 'string' ||
-    typeof objectFromJson.jiraAuth.privateKey !== 'string'
+    typeof objectFromJson.jiraAuth.privateKey !== 'string' ||
+    ![undefined, ...Object.values(JiraDeployment)].includes(
+      objectFromJson.deployment
+    ) ||
+    !['string', 'undefined'].includes(typeof objectFromJson.baseURL)
   ) {
     return undefined
   }
   return {
     jiraHost: objectFromJson.jiraHost,
+    deployment: objectFromJson.deployment,
+    baseURL: objectFromJson.baseURL,
     jiraAuth: {
       consumerKey: objectFromJson.jiraAuth.consumerKey,
       publicKey: objectFromJson.jiraAuth.publicKey,
@@ -83,11 +278,21 @@ const jsonToTeamJiraConfig = (
 const jsonToTeamUserConfig = (
   objectFromJson: any
 ): TeamUserConfig | undefined => {
-  const {jiraAccountID, accessToken, tokenSecret} = objectFromJson
+  const {
+    jiraAccountID,
+    accessToken,
+    tokenSecret,
+    personalAccessToken,
+    basicAuth,
+  } = objectFromJson
   if (
     typeof jiraAccountID !== 'string' ||
     typeof accessToken !== 'string' ||
-    typeof tokenSecret !== 'string'
+    typeof tokenSecret !== 'string' ||
+    !['string', 'undefined'].includes(typeof personalAccessToken) ||
+    (basicAuth !== undefined &&
+      (typeof basicAuth?.username !== 'string' ||
+        typeof basicAuth?.password !== 'string'))
   ) {
     return undefined
   }
@@ -95,21 +300,68 @@ const jsonToTeamUserConfig = (
     jiraAccountID,
     accessToken,
     tokenSecret,
+    personalAccessToken,
+    basicAuth: basicAuth && {
+      username: basicAuth.username,
+      password: basicAuth.password,
+    },
   } as TeamUserConfig
 }
 
+const jsonToUserNotificationPreferences = (
+  objectFromJson: any
+): UserNotificationPreferences | undefined => {
+  const {mentions, assignments, approvals, quietHours} = objectFromJson
+  if (
+    typeof mentions !== 'boolean' ||
+    typeof assignments !== 'boolean' ||
+    typeof approvals !== 'boolean' ||
+    (quietHours !== undefined &&
+      (typeof quietHours?.start !== 'string' ||
+        typeof quietHours?.end !== 'string'))
+  ) {
+    return undefined
+  }
+  return {
+    mentions,
+    assignments,
+    approvals,
+    quietHours: quietHours && {start: quietHours.start, end: quietHours.end},
+  } as UserNotificationPreferences
+}
+
+const jsonToJiraAccountUser = (
+  objectFromJson: any
+): JiraAccountUser | undefined =>
+  typeof objectFromJson?.username === 'string'
+    ? {username: objectFromJson.username}
+    : undefined
+
 const jsonToTeamChannelConfig = (
   objectFromJson: any
 ): TeamChannelConfig | undefined => {
-  const {defaultNewIssueProject} = objectFromJson
+  const {
+    defaultNewIssueProject,
+    digestTime,
+    unfurl,
+    unfurlProjects,
+  } = objectFromJson
   if (
-    typeof defaultNewIssueProject !== 'undefined' &&
-    typeof defaultNewIssueProject !== 'string'
+    (typeof defaultNewIssueProject !== 'undefined' &&
+      typeof defaultNewIssueProject !== 'string') ||
+    !['string', 'undefined'].includes(typeof digestTime) ||
+    !['boolean', 'undefined'].includes(typeof unfurl) ||
+    (typeof unfurlProjects !== 'undefined' &&
+      (!Array.isArray(unfurlProjects) ||
+        unfurlProjects.some((project: any) => typeof project !== 'string')))
   ) {
     return undefined
   }
   return {
     defaultNewIssueProject,
+    digestTime,
+    unfurl,
+    unfurlProjects,
   } as TeamChannelConfig
 }
 
@@ -127,8 +379,16 @@ const jsonToTeamJiraSubscriptions = (
       typeof value.conversationId !== 'string' ||
       typeof value.webhookURI !== 'string' ||
       typeof value.urlToken !== 'string' ||
+      !['string', 'undefined'].includes(typeof value.webhookSecret) ||
       typeof value.jql !== 'string' ||
-      !['boolean', 'undefined'].includes(typeof value.withUpdates)
+      !['boolean', 'undefined'].includes(typeof value.withUpdates) ||
+      !['string', 'undefined'].includes(typeof value.pollingUsername) ||
+      !['boolean', 'undefined'].includes(typeof value.pollsProject) ||
+      !['number', 'undefined'].includes(typeof value.boardID) ||
+      !['boolean', 'undefined'].includes(typeof value.digest) ||
+      !['string', 'undefined'].includes(typeof value.versionsProject) ||
+      !['string', 'undefined'].includes(typeof value.serviceDeskProject) ||
+      !['string', 'undefined'].includes(typeof value.epicKey)
     ) {
       return
     }
@@ -136,13 +396,209 @@ const jsonToTeamJiraSubscriptions = (
       conversationId: value.conversationId,
       webhookURI: value.webhookURI,
       urlToken: value.urlToken,
+      webhookSecret: value.webhookSecret,
       jql: value.jql,
       withUpdates: !!value.withUpdates,
+      pollingUsername: value.pollingUsername,
+      pollsProject: value.pollsProject,
+      boardID: value.boardID,
+      digest: value.digest,
+      versionsProject: value.versionsProject,
+      serviceDeskProject: value.serviceDeskProject,
+      epicKey: value.epicKey,
     })
   })
   return subscriptions
 }
 
+const jsonToTeamSlaRules = (objectFromJson: any): TeamSlaRules | undefined => {
+  if (!Array.isArray(objectFromJson)) {
+    return undefined
+  }
+  const rules = new Map<number, TeamSlaRule>()
+  objectFromJson.forEach(([key, value]) => {
+    if (
+      typeof key !== 'number' ||
+      typeof value !== 'object' ||
+      typeof value.conversationId !== 'string' ||
+      typeof value.priority !== 'string' ||
+      typeof value.thresholdMinutes !== 'number' ||
+      typeof value.pollingUsername !== 'string'
+    ) {
+      return
+    }
+    rules.set(key, {
+      conversationId: value.conversationId,
+      priority: value.priority,
+      thresholdMinutes: value.thresholdMinutes,
+      pollingUsername: value.pollingUsername,
+    })
+  })
+  return rules
+}
+
+const jsonToTeamCustomFields = (
+  objectFromJson: any
+): TeamCustomFields | undefined => {
+  if (!Array.isArray(objectFromJson)) {
+    return undefined
+  }
+  const customFields = new Map<string, Array<TeamCustomField>>()
+  objectFromJson.forEach(([key, value]) => {
+    if (typeof key !== 'string' || !Array.isArray(value)) {
+      return
+    }
+    customFields.set(
+      key,
+      value
+        .filter(
+          (field: any) =>
+            typeof field?.id === 'string' && typeof field?.name === 'string'
+        )
+        .map((field: any) => ({id: field.id, name: field.name}))
+    )
+  })
+  return customFields
+}
+
+const jsonToSlaRuleState = (objectFromJson: any): SlaRuleState | undefined => {
+  const {alerts} = objectFromJson
+  if (
+    !Array.isArray(alerts) ||
+    alerts.some(
+      (alert: any) =>
+        typeof alert?.key !== 'string' || typeof alert?.level !== 'number'
+    )
+  ) {
+    return undefined
+  }
+  return {
+    alerts: alerts.map(({key, level}: any) => ({key, level})),
+  } as SlaRuleState
+}
+
+const jsonToJqlSubscriptionState = (
+  objectFromJson: any
+): JqlSubscriptionState | undefined => {
+  const {issues} = objectFromJson
+  if (
+    !Array.isArray(issues) ||
+    issues.some(
+      (issue: any) =>
+        typeof issue?.key !== 'string' || typeof issue?.summary !== 'string'
+    )
+  ) {
+    return undefined
+  }
+  return {
+    issues: issues.map(({key, summary}: any) => ({key, summary})),
+  } as JqlSubscriptionState
+}
+
+const jsonToSprintSubscriptionState = (
+  objectFromJson: any
+): SprintSubscriptionState | undefined => {
+  const {boardName, sprints} = objectFromJson
+  if (
+    typeof boardName !== 'string' ||
+    !Array.isArray(sprints) ||
+    sprints.some(
+      (sprint: any) =>
+        typeof sprint?.id !== 'number' ||
+        typeof sprint?.name !== 'string' ||
+        !Array.isArray(sprint?.issues) ||
+        sprint.issues.some((key: any) => typeof key !== 'string')
+    )
+  ) {
+    return undefined
+  }
+  return {
+    boardName,
+    sprints: sprints.map(({id, name, issues}: any) => ({id, name, issues})),
+  } as SprintSubscriptionState
+}
+
+const jsonToVersionSubscriptionState = (
+  objectFromJson: any
+): VersionSubscriptionState | undefined => {
+  const {versions} = objectFromJson
+  if (
+    !Array.isArray(versions) ||
+    versions.some(
+      (version: any) =>
+        typeof version?.id !== 'string' ||
+        typeof version?.name !== 'string' ||
+        typeof version?.released !== 'boolean' ||
+        typeof version?.releaseDate !== 'string'
+    )
+  ) {
+    return undefined
+  }
+  return {
+    versions: versions.map(({id, name, released, releaseDate}: any) => ({
+      id,
+      name,
+      released,
+      releaseDate,
+    })),
+  } as VersionSubscriptionState
+}
+
+const jsonToServiceDeskSubscriptionState = (
+  objectFromJson: any
+): ServiceDeskSubscriptionState | undefined => {
+  const {requests, breached} = objectFromJson
+  if (
+    !Array.isArray(requests) ||
+    !Array.isArray(breached) ||
+    [...requests, ...breached].some(key => typeof key !== 'string')
+  ) {
+    return undefined
+  }
+  return {requests, breached} as ServiceDeskSubscriptionState
+}
+
+const jsonToEpicSubscriptionState = (
+  objectFromJson: any
+): EpicSubscriptionState | undefined => {
+  const {children} = objectFromJson
+  if (
+    !Array.isArray(children) ||
+    children.some(
+      (child: any) =>
+        typeof child?.key !== 'string' || typeof child?.status !== 'string'
+    )
+  ) {
+    return undefined
+  }
+  return {
+    children: children.map(({key, status}: any) => ({key, status})),
+  } as EpicSubscriptionState
+}
+
+const jsonToDigestSubscriptionState = (
+  objectFromJson: any
+): DigestSubscriptionState | undefined => {
+  const {lastPosted} = objectFromJson
+  if (typeof lastPosted !== 'string') {
+    return undefined
+  }
+  return {lastPosted} as DigestSubscriptionState
+}
+
+const jsonToCommentThread = (
+  objectFromJson: any
+): CommentThread | undefined => {
+  const {issueKey, messageID} = objectFromJson
+  if (typeof issueKey !== 'string' || typeof messageID !== 'number') {
+    return undefined
+  }
+  return {
+    issueKey,
+    messageID,
+  } as CommentThread
+}
+
 const jsonToJiraSubscriptionIndex = (
   objectFromJson: any
 ): JiraSubscriptionIndex | undefined => {
@@ -160,6 +616,12 @@ const teamJiraSubscriptionsToJson = (
   teamJiraSubscriptions: TeamJiraSubscriptions
 ): string => JSON.stringify([...teamJiraSubscriptions.entries()])
 
+const teamSlaRulesToJson = (teamSlaRules: TeamSlaRules): string =>
+  JSON.stringify([...teamSlaRules.entries()])
+
+const teamCustomFieldsToJson = (teamCustomFields: TeamCustomFields): string =>
+  JSON.stringify([...teamCustomFields.entries()])
+
 export type CachedConfig<T> = Readonly<{
   _revision: number
   _timestamp: number
@@ -180,11 +642,44 @@ export default class Configs {
   private cache = {
     teamJiraConfigs: new Map<string, CachedConfig<TeamJiraConfig>>(),
     teamUserConfigs: new Map<string, CachedConfig<TeamUserConfig>>(),
+    userNotificationPreferences: new Map<
+      string,
+      CachedConfig<UserNotificationPreferences>
+    >(),
+    jiraAccountUsers: new Map<string, CachedConfig<JiraAccountUser>>(),
     teamChannelConfigs: new Map<string, CachedConfig<TeamChannelConfig>>(),
     teamJiraSubscriptions: new Map<
       string,
       CachedConfig<TeamJiraSubscriptions>
     >(),
+    jqlSubscriptionStates: new Map<
+      string,
+      CachedConfig<JqlSubscriptionState>
+    >(),
+    sprintSubscriptionStates: new Map<
+      string,
+      CachedConfig<SprintSubscriptionState>
+    >(),
+    versionSubscriptionStates: new Map<
+      string,
+      CachedConfig<VersionSubscriptionState>
+    >(),
+    serviceDeskSubscriptionStates: new Map<
+      string,
+      CachedConfig<ServiceDeskSubscriptionState>
+    >(),
+    epicSubscriptionStates: new Map<
+      string,
+      CachedConfig<EpicSubscriptionState>
+    >(),
+    digestSubscriptionStates: new Map<
+      string,
+      CachedConfig<DigestSubscriptionState>
+    >(),
+    commentThreads: new Map<string, CachedConfig<CommentThread>>(),
+    teamSlaRules: new Map<string, CachedConfig<TeamSlaRules>>(),
+    slaRuleStates: new Map<string, CachedConfig<SlaRuleState>>(),
+    teamCustomFields: new Map<string, CachedConfig<TeamCustomFields>>(),
 
     jiraSubscriptionIndex: new Map<
       string,
@@ -342,6 +837,40 @@ export default class Configs {
     )
   }
 
+  async getUserNotificationPreferences(
+    teamname: string,
+    username: string
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<UserNotificationPreferences>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.userNotificationPreferences,
+      getNamespace(teamname),
+      getUserNotificationPreferencesKey(username),
+      jsonToUserNotificationPreferences
+    )
+  }
+
+  async getJiraAccountUser(
+    teamname: string,
+    jiraAccountID: string
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<JiraAccountUser>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.jiraAccountUsers,
+      getNamespace(teamname),
+      getJiraAccountUserKey(jiraAccountID),
+      jsonToJiraAccountUser
+    )
+  }
+
   async getTeamChannelConfig(
     teamname: string,
     conversationId: ChatTypes.ConvIDStr
@@ -375,6 +904,193 @@ export default class Configs {
     )
   }
 
+  async getJqlSubscriptionState(
+    teamname: string,
+    subscriptionID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<JqlSubscriptionState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.jqlSubscriptionStates,
+      getNamespace(teamname),
+      getJqlSubscriptionStateKey(subscriptionID),
+      jsonToJqlSubscriptionState
+    )
+  }
+
+  async getSprintSubscriptionState(
+    teamname: string,
+    subscriptionID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<SprintSubscriptionState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.sprintSubscriptionStates,
+      getNamespace(teamname),
+      getSprintSubscriptionStateKey(subscriptionID),
+      jsonToSprintSubscriptionState
+    )
+  }
+
+  async getVersionSubscriptionState(
+    teamname: string,
+    subscriptionID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<VersionSubscriptionState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.versionSubscriptionStates,
+      getNamespace(teamname),
+      getVersionSubscriptionStateKey(subscriptionID),
+      jsonToVersionSubscriptionState
+    )
+  }
+
+  async getEpicSubscriptionState(
+    teamname: string,
+    subscriptionID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<EpicSubscriptionState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.epicSubscriptionStates,
+      getNamespace(teamname),
+      getEpicSubscriptionStateKey(subscriptionID),
+      jsonToEpicSubscriptionState
+    )
+  }
+
+  async getServiceDeskSubscriptionState(
+    teamname: string,
+    subscriptionID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<ServiceDeskSubscriptionState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.serviceDeskSubscriptionStates,
+      getNamespace(teamname),
+      getServiceDeskSubscriptionStateKey(subscriptionID),
+      jsonToServiceDeskSubscriptionState
+    )
+  }
+
+  async getDigestSubscriptionState(
+    teamname: string,
+    subscriptionID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<DigestSubscriptionState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.digestSubscriptionStates,
+      getNamespace(teamname),
+      getDigestSubscriptionStateKey(subscriptionID),
+      jsonToDigestSubscriptionState
+    )
+  }
+
+  async getTeamSlaRules(
+    teamname: string
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<TeamSlaRules>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.teamSlaRules,
+      getNamespace(teamname),
+      slaRulesKey,
+      jsonToTeamSlaRules
+    )
+  }
+
+  async getTeamCustomFields(
+    teamname: string
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<TeamCustomFields>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.teamCustomFields,
+      getNamespace(teamname),
+      customFieldsKey,
+      jsonToTeamCustomFields
+    )
+  }
+
+  async getSlaRuleState(
+    teamname: string,
+    ruleID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<SlaRuleState>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.slaRuleStates,
+      getNamespace(teamname),
+      getSlaRuleStateKey(ruleID),
+      jsonToSlaRuleState
+    )
+  }
+
+  async getCommentThreadByIssue(
+    teamname: string,
+    conversationId: ChatTypes.ConvIDStr,
+    issueKey: string
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<CommentThread>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.commentThreads,
+      getNamespace(teamname),
+      getCommentThreadByIssueKey(conversationId, issueKey),
+      jsonToCommentThread
+    )
+  }
+
+  async getCommentThreadByMessage(
+    teamname: string,
+    conversationId: ChatTypes.ConvIDStr,
+    messageID: number
+  ): Promise<
+    Errors.ResultOrError<
+      CachedConfig<CommentThread>,
+      Errors.KVStoreNotFoundError | Errors.UnknownError
+    >
+  > {
+    return await this.getFromCacheOrKVStore(
+      this.cache.commentThreads,
+      getNamespace(teamname),
+      getCommentThreadByMessageKey(conversationId, messageID),
+      jsonToCommentThread
+    )
+  }
+
   async getJiraSubscriptionIndex(
     urlToken: string
   ): Promise<
@@ -445,6 +1161,47 @@ export default class Configs {
     )
   }
 
+  async updateUserNotificationPreferences(
+    teamname: string,
+    username: string,
+    oldConfig: CachedConfig<UserNotificationPreferences> | undefined,
+    newConfig: UserNotificationPreferences
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.userNotificationPreferences,
+      getNamespace(teamname),
+      getUserNotificationPreferencesKey(username),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  // The latest user to connect a Jira account gets its notifications, so
+  // this doesn't check revisions.
+  async setJiraAccountUser(
+    teamname: string,
+    jiraAccountID: string,
+    accountUser: JiraAccountUser
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.jiraAccountUsers,
+      getNamespace(teamname),
+      getJiraAccountUserKey(jiraAccountID),
+      undefined,
+      accountUser
+    )
+  }
+
   async updateTeamUserConfig(
     teamname: string,
     username: string,
@@ -505,6 +1262,225 @@ export default class Configs {
     )
   }
 
+  async updateJqlSubscriptionState(
+    teamname: string,
+    subscriptionID: number,
+    oldConfig: CachedConfig<JqlSubscriptionState> | undefined,
+    newConfig: JqlSubscriptionState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.jqlSubscriptionStates,
+      getNamespace(teamname),
+      getJqlSubscriptionStateKey(subscriptionID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  async updateSprintSubscriptionState(
+    teamname: string,
+    subscriptionID: number,
+    oldConfig: CachedConfig<SprintSubscriptionState> | undefined,
+    newConfig: SprintSubscriptionState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.sprintSubscriptionStates,
+      getNamespace(teamname),
+      getSprintSubscriptionStateKey(subscriptionID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  async updateVersionSubscriptionState(
+    teamname: string,
+    subscriptionID: number,
+    oldConfig: CachedConfig<VersionSubscriptionState> | undefined,
+    newConfig: VersionSubscriptionState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.versionSubscriptionStates,
+      getNamespace(teamname),
+      getVersionSubscriptionStateKey(subscriptionID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  async updateEpicSubscriptionState(
+    teamname: string,
+    subscriptionID: number,
+    oldConfig: CachedConfig<EpicSubscriptionState> | undefined,
+    newConfig: EpicSubscriptionState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.epicSubscriptionStates,
+      getNamespace(teamname),
+      getEpicSubscriptionStateKey(subscriptionID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  async updateServiceDeskSubscriptionState(
+    teamname: string,
+    subscriptionID: number,
+    oldConfig: CachedConfig<ServiceDeskSubscriptionState> | undefined,
+    newConfig: ServiceDeskSubscriptionState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.serviceDeskSubscriptionStates,
+      getNamespace(teamname),
+      getServiceDeskSubscriptionStateKey(subscriptionID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  async updateDigestSubscriptionState(
+    teamname: string,
+    subscriptionID: number,
+    oldConfig: CachedConfig<DigestSubscriptionState> | undefined,
+    newConfig: DigestSubscriptionState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.digestSubscriptionStates,
+      getNamespace(teamname),
+      getDigestSubscriptionStateKey(subscriptionID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  async updateTeamSlaRules(
+    teamname: string,
+    oldConfig: CachedConfig<TeamSlaRules> | undefined,
+    newConfig: TeamSlaRules
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.teamSlaRules,
+      getNamespace(teamname),
+      slaRulesKey,
+      oldConfig,
+      newConfig,
+      teamSlaRulesToJson
+    )
+  }
+
+  async updateTeamCustomFields(
+    teamname: string,
+    oldConfig: CachedConfig<TeamCustomFields> | undefined,
+    newConfig: TeamCustomFields
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.teamCustomFields,
+      getNamespace(teamname),
+      customFieldsKey,
+      oldConfig,
+      newConfig,
+      teamCustomFieldsToJson
+    )
+  }
+
+  async updateSlaRuleState(
+    teamname: string,
+    ruleID: number,
+    oldConfig: CachedConfig<SlaRuleState> | undefined,
+    newConfig: SlaRuleState
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.slaRuleStates,
+      getNamespace(teamname),
+      getSlaRuleStateKey(ruleID),
+      oldConfig,
+      newConfig
+    )
+  }
+
+  // Threads never change once set, so these don't check revisions.
+  async setCommentThreadByIssue(
+    teamname: string,
+    conversationId: ChatTypes.ConvIDStr,
+    thread: CommentThread
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.commentThreads,
+      getNamespace(teamname),
+      getCommentThreadByIssueKey(conversationId, thread.issueKey),
+      undefined,
+      thread
+    )
+  }
+
+  async setCommentThreadByMessage(
+    teamname: string,
+    conversationId: ChatTypes.ConvIDStr,
+    thread: CommentThread
+  ): Promise<
+    Errors.ResultOrError<
+      undefined,
+      Errors.KVStoreRevisionError | Errors.UnknownError
+    >
+  > {
+    return await this.updateToCacheAndKVStore(
+      this.cache.commentThreads,
+      getNamespace(teamname),
+      getCommentThreadByMessageKey(conversationId, thread.messageID),
+      undefined,
+      thread
+    )
+  }
+
   async setOrDeleteJiraSubscriptionIndex(
     urlToken: string,
     index?: JiraSubscriptionIndex // set to undefined to delete
@@ -5,6 +5,9 @@ import util from 'util'
 import * as BotConfig from './bot-config'
 import * as Jira from './jira'
 import Aliases from './aliases'
+import {BulkOperations} from './cmd-bulk'
+import {CreateSessions} from './cmd-create'
+import {PendingCredentials} from './cmd-auth'
 import Configs from './configs'
 import StatHat from './stathat'
 import logger from './logger'
@@ -34,9 +37,12 @@ export type Context = {
   aliases: Aliases
   bot: Bot
   botConfig: BotConfig.BotConfig
+  bulkOperations: BulkOperations
   comment: CommentContext
   configs: Configs
+  createSessions: CreateSessions
   getJiraFromTeamnameAndUsername: typeof Jira.getJiraFromTeamnameAndUsername
+  pendingCredentials: PendingCredentials
   stathat: StatHat
 }
 
@@ -67,9 +73,12 @@ export const init = async (
     aliases: new Aliases({}),
     bot,
     botConfig,
+    bulkOperations: new BulkOperations(),
     comment: new CommentContext(),
     configs: new Configs(bot, botConfig),
+    createSessions: new CreateSessions(),
     getJiraFromTeamnameAndUsername: Jira.getJiraFromTeamnameAndUsername,
+    pendingCredentials: new PendingCredentials(),
     stathat: new StatHat(botConfig),
   }
   await context.bot.init(
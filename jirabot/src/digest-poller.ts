@@ -0,0 +1,201 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import logger from './logger'
+import moment from 'moment'
+
+// Issues beyond this many updated on a board are left out of its digest.
+export const maxDigestIssues = 200
+
+const digestHours = 24
+const defaultDigestTime = '09:00'
+
+// the last time a digest was due, at or before now
+const lastDue = (digestTime: Utils.TimeOfDay): moment.Moment => {
+  const now = moment().utcOffset(digestTime.utcOffset)
+  const due = now.clone().set({
+    hour: digestTime.hour,
+    minute: digestTime.minute,
+    second: 0,
+    millisecond: 0,
+  })
+  return due.isAfter(now) ? due.subtract(1, 'day') : due
+}
+
+const getDigestTime = async (
+  context: Context,
+  teamname: string,
+  conversationId: string
+): Promise<Utils.TimeOfDay> => {
+  const channelConfigRet = await context.configs.getTeamChannelConfig(
+    teamname,
+    conversationId
+  )
+  if (channelConfigRet.type === Errors.ReturnType.Error) {
+    channelConfigRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+      logger.warn({msg: 'getDigestTime', error: channelConfigRet.error})
+  }
+  const configured =
+    channelConfigRet.type === Errors.ReturnType.Ok
+      ? channelConfigRet.result.config.digestTime
+      : undefined
+  return (
+    (configured && Utils.parseTimeOfDay(configured)) ||
+    Utils.parseTimeOfDay(defaultDigestTime)
+  )
+}
+
+const isBlocked = (issue: Jira.IssueActivity) => /block/i.test(issue.statusName)
+
+// what happened to an issue in the digest period, empty if it didn't move
+const describeActivity = (
+  issue: Jira.IssueActivity,
+  since: moment.Moment
+): Array<string> => {
+  const happened = []
+  if (moment(issue.created).isAfter(since)) {
+    happened.push('created')
+  }
+  if (issue.transitions.length) {
+    const first = issue.transitions[0]
+    const last = issue.transitions[issue.transitions.length - 1]
+    happened.push(`moved ${first.from} → ${last.to}`)
+  }
+  if (issue.resolved && moment(issue.resolved).isAfter(since)) {
+    happened.push('resolved')
+  }
+  // only issues updated in the period are fetched
+  if (isBlocked(issue)) {
+    happened.push('*blocked*')
+  }
+  return happened
+}
+
+export const formatDigest = (
+  boardName: string,
+  issues: Array<Jira.IssueActivity>
+): string => {
+  const since = moment().subtract(digestHours, 'hours')
+  const moved = issues
+    .map(issue => ({issue, happened: describeActivity(issue, since)}))
+    .filter(({happened}) => happened.length)
+  if (!moved.length) {
+    return `:newspaper: Nothing moved on board ${boardName} in the last ${digestHours}h.`
+  }
+  const count = (what: string) =>
+    moved.filter(({happened}) => happened.some(h => h.startsWith(what))).length
+  const byAssignee = new Map<string, Array<string>>()
+  for (const {issue, happened} of moved) {
+    const assignee = issue.assigneeJira || ''
+    byAssignee.set(assignee, [
+      ...(byAssignee.get(assignee) || []),
+      `• *${issue.key}* ${issue.summary} - ${happened.join(', ')} - ${
+        issue.url
+      }`,
+    ])
+  }
+  const assignees = [...byAssignee.keys()].sort((a, b) =>
+    // unassigned issues last
+    !a ? 1 : !b ? -1 : a.localeCompare(b)
+  )
+  return [
+    `:newspaper: Daily digest of board ${boardName} for the last ${digestHours}h: ${count(
+      'created'
+    )} created, ${count('moved')} moved, ${count(
+      'resolved'
+    )} resolved, ${count('*blocked*')} blocked.`,
+    ...assignees.map(assignee =>
+      [`*${assignee || 'Unassigned'}*`, ...byAssignee.get(assignee)].join(
+        '\n'
+      )
+    ),
+  ].join('\n\n')
+}
+
+export const pollDigestSubscription = async (
+  context: Context,
+  teamname: string,
+  subscriptionID: number,
+  subscription: Configs.TeamJiraSubscription
+): Promise<void> => {
+  const due = lastDue(
+    await getDigestTime(context, teamname, subscription.conversationId)
+  )
+  const stateRet = await context.configs.getDigestSubscriptionState(
+    teamname,
+    subscriptionID
+  )
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({
+      msg: 'pollDigestSubscription',
+      teamname,
+      error: stateRet.error,
+    })
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+  if (oldState && !moment(oldState.config.lastPosted).isBefore(due)) {
+    return
+  }
+  const newState = {lastPosted: moment().toISOString()}
+  if (!oldState) {
+    // The first digest is at the next digest time.
+    await context.configs.updateDigestSubscriptionState(
+      teamname,
+      subscriptionID,
+      undefined,
+      newState
+    )
+    return
+  }
+
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    subscription.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({msg: 'pollDigestSubscription', teamname, error: jiraRet.error})
+    return
+  }
+  const jira = jiraRet.result
+
+  let body: string
+  try {
+    body = formatDigest(
+      await jira.getBoardName(subscription.boardID),
+      await jira.getBoardActivity(
+        subscription.boardID,
+        digestHours,
+        maxDigestIssues
+      )
+    )
+  } catch (error) {
+    logger.warn({msg: 'pollDigestSubscription', teamname, error})
+    return
+  }
+
+  const updateRet = await context.configs.updateDigestSubscriptionState(
+    teamname,
+    subscriptionID,
+    oldState,
+    newState
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it posts the digest.
+    logger.warn({
+      msg: 'pollDigestSubscription',
+      teamname,
+      error: updateRet.error,
+    })
+    return
+  }
+  context.stathat.postCount('board digests', 1)
+  await context.bot.chat.send(subscription.conversationId, {body})
+}
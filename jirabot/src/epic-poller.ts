@@ -0,0 +1,144 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import logger from './logger'
+
+// issues of an epic beyond this many are not counted
+export const maxEpicChildren = 200
+const progressBarWidth = 10
+
+export const childrenToState = (
+  children: Array<Jira.EpicChild>
+): Configs.EpicSubscriptionState => ({
+  children: children.map(({key, status}) => ({key, status})),
+})
+
+const progressBar = (ratio: number) => {
+  const filled = Math.round(ratio * progressBarWidth)
+  return '▓'.repeat(filled) + '░'.repeat(progressBarWidth - filled)
+}
+
+// Story points are counted when the epic's issues are estimated, issues
+// otherwise.
+export const formatEpicProgress = (
+  epic: Jira.Issue,
+  children: Array<Jira.EpicChild>
+): string => {
+  const done = children.filter(child => child.done)
+  const remaining = children.filter(child => !child.done)
+  const totalPoints = children.reduce((sum, {points}) => sum + points, 0)
+  const donePoints = done.reduce((sum, {points}) => sum + points, 0)
+  const ratio = totalPoints
+    ? donePoints / totalPoints
+    : children.length
+    ? done.length / children.length
+    : 0
+  return [
+    `*${epic.key}* ${epic.summary}`,
+    `${progressBar(ratio)} ${Math.round(ratio * 100)}% - ` +
+      (totalPoints ? `${donePoints} of ${totalPoints} story points, ` : '') +
+      `${done.length} of ${children.length}${
+        children.length === maxEpicChildren ? '+' : ''
+      } issue${children.length !== 1 ? 's' : ''} done`,
+    ...(remaining.length
+      ? [
+          `Remaining: ${remaining
+            .slice(0, 10)
+            .map(({key, status}) => `${key} (${status})`)
+            .join(', ')}${
+            remaining.length > 10 ? ` and ${remaining.length - 10} more` : ''
+          }`,
+        ]
+      : []),
+    epic.url,
+  ].join('\n')
+}
+
+export const pollEpicSubscription = async (
+  context: Context,
+  teamname: string,
+  subscriptionID: number,
+  subscription: Configs.TeamJiraSubscription
+): Promise<void> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    subscription.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({msg: 'pollEpicSubscription', teamname, error: jiraRet.error})
+    return
+  }
+  const jira = jiraRet.result
+
+  const stateRet = await context.configs.getEpicSubscriptionState(
+    teamname,
+    subscriptionID
+  )
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({msg: 'pollEpicSubscription', teamname, error: stateRet.error})
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+
+  let children: Array<Jira.EpicChild>
+  try {
+    children = await jira.getEpicChildren(
+      subscription.epicKey,
+      maxEpicChildren
+    )
+  } catch (error) {
+    logger.warn({msg: 'pollEpicSubscription', teamname, error})
+    return
+  }
+
+  const newState = childrenToState(children)
+  const oldStatuses = new Map(
+    (oldState?.config.children || []).map(({key, status}): [
+      string,
+      string
+    ] => [key, status])
+  )
+  const changed =
+    oldStatuses.size !== children.length ||
+    children.some(({key, status}) => oldStatuses.get(key) !== status)
+  if (oldState && !changed) {
+    return
+  }
+
+  const updateRet = await context.configs.updateEpicSubscriptionState(
+    teamname,
+    subscriptionID,
+    oldState,
+    newState
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it posts the progress.
+    logger.warn({
+      msg: 'pollEpicSubscription',
+      teamname,
+      error: updateRet.error,
+    })
+    return
+  }
+  if (!oldState) {
+    return
+  }
+
+  let epic: Jira.Issue
+  try {
+    epic = await jira.get({issueKey: subscription.epicKey})
+  } catch (error) {
+    logger.warn({msg: 'pollEpicSubscription', teamname, error})
+    return
+  }
+  context.stathat.postCount('epic progress updates', 1)
+  await context.bot.chat.send(subscription.conversationId, {
+    body: formatEpicProgress(epic, children),
+  })
+}
@@ -3,6 +3,9 @@ import * as Configs from './configs'
 import * as Jira from './jira'
 import * as Errors from './errors'
 import logger from './logger'
+import {announceIssue, threadComment} from './comment-bridge'
+import {notifyFromWebhook} from './notifications'
+import {formatCustomFields} from './cmd-fields'
 
 type Issue = {
   type: string
@@ -10,26 +13,31 @@ type Issue = {
   url: string
   reporter: string
   project: string
+  projectKey: string
   summary: string
+  customFields: {[fieldID: string]: string}
 }
 
 const parseIssueFromPayload = (
   issue: any,
-  jiraHost: string
+  baseURL: string
 ): undefined | Issue => {
   const type = issue?.fields?.issuetype?.name
   const issueKey = issue?.key
   const reporter = issue?.fields?.reporter?.displayName
   const project = issue?.fields?.project?.name
+  const projectKey = issue?.fields?.project?.key
   const summary = issue?.fields?.summary
-  return type && issueKey && reporter && project && summary
+  return type && issueKey && reporter && project && projectKey && summary
     ? {
         type,
         issueKey,
-        url: `https://${jiraHost}/browse/${issueKey}`,
+        url: `${baseURL}/browse/${issueKey}`,
         reporter,
         project,
+        projectKey,
         summary,
+        customFields: Jira.getCustomFields(issue.fields),
       }
     : undefined
 }
@@ -154,7 +162,8 @@ export default async (
 
   if (
     webhookEvent !== Jira.JiraSubscriptionEvents.IssueCreated &&
-    webhookEvent !== Jira.JiraSubscriptionEvents.IssueUpdated
+    webhookEvent !== Jira.JiraSubscriptionEvents.IssueUpdated &&
+    webhookEvent !== Jira.JiraSubscriptionEvents.CommentCreated
   ) {
     logger.warn({
       msg: 'handleWebhookEvent',
@@ -170,9 +179,28 @@ export default async (
   }
   const teamJiraConfig = teamJiraConfigRet.result.config
 
+  await notifyFromWebhook(
+    context,
+    teamname,
+    Jira.getBaseURL(teamJiraConfig),
+    payload
+  )
+
+  if (webhookEvent === Jira.JiraSubscriptionEvents.CommentCreated) {
+    // comment payloads don't have the whole issue
+    await threadComment(
+      context,
+      teamname,
+      subscription.conversationId,
+      Jira.getBaseURL(teamJiraConfig),
+      payload
+    )
+    return undefined
+  }
+
   const issue =
     payload.issue &&
-    parseIssueFromPayload(payload.issue, teamJiraConfig.jiraHost)
+    parseIssueFromPayload(payload.issue, Jira.getBaseURL(teamJiraConfig))
   if (!issue) {
     logger.warn({
       msg: 'handleWebhookEvent',
@@ -182,20 +210,38 @@ export default async (
     return undefined
   }
 
+  const customFields = await formatCustomFields(
+    context,
+    teamname,
+    issue.projectKey,
+    issue.customFields
+  )
+  const customFieldsLine = customFields ? `\n${customFields}` : ''
+
   switch (webhookEvent) {
     case Jira.JiraSubscriptionEvents.IssueCreated:
       context.stathat.postCount(`webhook IssueCreated`, 1)
-      context.bot.chat.send(subscription.conversationId, {
-        body: `${issue.reporter} reported a new _${issue.type}_ in ${issue.project}: *${issue.summary}*\n${issue.url}`,
-      })
+      await announceIssue(
+        context,
+        teamname,
+        subscription.conversationId,
+        issue.issueKey,
+        `${issue.reporter} reported a new _${issue.type}_ in ${issue.project}: *${issue.summary}*\n${issue.url}` +
+          customFieldsLine
+      )
       return undefined
     case Jira.JiraSubscriptionEvents.IssueUpdated:
       const projectUpdate = parseChangelogForProjectUpdate(payload.changelog)
       if (projectUpdate) {
         context.stathat.postCount(`webhook ProjectUpdate`, 1)
-        context.bot.chat.send(subscription.conversationId, {
-          body: `A _${issue.type}_ was moved from ~_${projectUpdate.from}_~ to *${projectUpdate.to}*: ${issue.summary} | ${issue.url}`,
-        })
+        await announceIssue(
+          context,
+          teamname,
+          subscription.conversationId,
+          issue.issueKey,
+          `A _${issue.type}_ was moved from ~_${projectUpdate.from}_~ to *${projectUpdate.to}*: ${issue.summary} | ${issue.url}` +
+            customFieldsLine
+        )
       }
 
       if (!subscription.withUpdates) {
@@ -213,9 +259,12 @@ export default async (
         return undefined
       }
       context.stathat.postCount(`webhook NonProjectIssueUpdate`, 1)
-      context.bot.chat.send(subscription.conversationId, {
-        body:
-          `Updated: [${issue.type}] ${issue.summary} | ${issue.url}\n` +
+      await announceIssue(
+        context,
+        teamname,
+        subscription.conversationId,
+        issue.issueKey,
+        `Updated: [${issue.type}] ${issue.summary} | ${issue.url}${customFieldsLine}\n` +
           changelogItems
             .map(item => {
               switch (item.type) {
@@ -257,8 +306,8 @@ export default async (
             })
             .filter(Boolean)
             .map(line => '> ' + line)
-            .join('\n'),
-      })
+            .join('\n')
+      )
       return undefined
   }
 }
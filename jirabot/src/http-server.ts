@@ -1,3 +1,4 @@
+import crypto from 'crypto'
 import http from 'http'
 import url from 'url'
 import {onJiraCallback} from './jira-oauth'
@@ -48,6 +49,26 @@ const readAll = (req: http.IncomingMessage): Promise<string> =>
     req.on('error', () => reject(body))
   })
 
+// Jira sends `X-Hub-Signature: sha256=<hex HMAC of the body>` for webhooks
+// registered with a secret.
+const isValidSignature = (
+  secret: string,
+  header: string | Array<string> | undefined,
+  body: string
+): boolean => {
+  if (typeof header !== 'string' || !header.startsWith('sha256=')) {
+    return false
+  }
+  const expected = crypto
+    .createHmac('sha256', secret)
+    .update(body)
+    .digest()
+  const actual = Buffer.from(header.slice('sha256='.length), 'hex')
+  return (
+    actual.length === expected.length && crypto.timingSafeEqual(actual, expected)
+  )
+}
+
 const jiraWebhook = async (
   context: Context,
   parsedUrl: url.UrlWithParsedQuery,
@@ -105,6 +126,20 @@ const jiraWebhook = async (
   }
 
   const json = await readAll(req)
+  if (
+    subscription.webhookSecret &&
+    !isValidSignature(
+      subscription.webhookSecret,
+      req.headers['x-hub-signature'],
+      json
+    )
+  ) {
+    logger.warn({msg: 'jiraWebhook', error: 'invalid signature'})
+    res.writeHead(401)
+    res.end('invalid signature')
+    return
+  }
+
   let payload = undefined
   try {
     payload = JSON.parse(json)
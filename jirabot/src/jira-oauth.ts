@@ -5,6 +5,7 @@ import * as Errors from './errors'
 import * as Configs from './configs'
 import {Context} from './context'
 import * as Utils from './utils'
+import * as Jira from './jira'
 
 export type OauthResult = Readonly<{
   accessToken: string
@@ -24,7 +25,7 @@ export const onJiraCallback = (oauthToken: string, oauthVerifier: string) => {
 }
 
 const step1 = (
-  jiraHost: string,
+  connection: Jira.ConnectionOptions,
   consumerKey: string,
   privateKey: string,
   httpAddressPrefix: string
@@ -41,7 +42,7 @@ const step1 = (
   >(resolve => {
     JiraClient.oauth_util.getAuthorizeURL(
       {
-        host: jiraHost,
+        ...connection,
         oauth: {
           consumer_key: consumerKey,
           private_key: privateKey,
@@ -77,7 +78,7 @@ const waitForJiraCallback = (
   })
 
 const getAccessToken = (
-  host: string,
+  connection: Jira.ConnectionOptions,
   consumerKey: string,
   privateKey: string,
   tokenSecret: string,
@@ -86,7 +87,7 @@ const getAccessToken = (
   new Promise<Errors.ResultOrError<string, Errors.UnknownError>>(resolve => {
     JiraClient.oauth_util.swapRequestTokenWithAccessToken(
       {
-        host,
+        ...connection,
         oauth: {
           token: tokenCallbackData.oauthToken,
           token_secret: tokenSecret,
@@ -111,7 +112,7 @@ export const doOauth = async (
   Errors.UnknownError | Errors.TimeoutError
 >> => {
   const step1Ret = await step1(
-    teamJiraConfig.jiraHost,
+    Jira.getConnectionOptions(teamJiraConfig),
     teamJiraConfig.jiraAuth.consumerKey,
     teamJiraConfig.jiraAuth.privateKey,
     context.botConfig.httpAddressPrefix
@@ -138,7 +139,7 @@ export const doOauth = async (
   const tokenCallbackData = waitForJiraCallbackRet.result
 
   const accessTokenRet = await getAccessToken(
-    teamJiraConfig.jiraHost,
+    Jira.getConnectionOptions(teamJiraConfig),
     teamJiraConfig.jiraAuth.consumerKey,
     teamJiraConfig.jiraAuth.privateKey,
     res1.token_secret,
@@ -6,6 +6,7 @@ import * as Errors from './errors'
 import {Context} from './context'
 import mem from 'mem'
 import moment from 'moment'
+import {URL} from 'url'
 
 type JiraIssue = any
 // import {Issue as JiraIssue} from 'jira-connector/api/issue'
@@ -16,6 +17,12 @@ export const looksLikeIssueKey = (str: string) =>
 export const findIssueKeys = (str: string) =>
   str.match(/([A-Za-z0-9]+-[0-9]+)/g) || []
 
+// Issue keys appearing in a message without mentioning the bot, which are
+// only unfurled if they look like ones Jira makes, e.g. in an issue URL.
+export const findUnfurlIssueKeys = (str: string): Array<string> => [
+  ...new Set(str.match(/\b[A-Z][A-Z0-9_]+-[1-9][0-9]*\b/g) || []),
+]
+
 export type Issue = {
   key: string
   summary: string
@@ -26,41 +33,228 @@ export type Issue = {
   reporterJira: string
   project: string
   createdTimeHumanized: string
+  created: string // ISO 8601
+  priority: string // empty if the issue was fetched without it
+  fixVersions: Array<string>
+  projectKey: string
+  // formatted values of the custom fields the issue was fetched with
+  customFields: {[fieldID: string]: string}
+}
+
+// a custom field value as text, whether it's a string, an option, a user, or
+// a list of them
+export const formatFieldValue = (value: any): string => {
+  if (value === null || value === undefined) {
+    return ''
+  }
+  if (Array.isArray(value)) {
+    return value
+      .map(formatFieldValue)
+      .filter(Boolean)
+      .join(', ')
+  }
+  if (typeof value === 'object') {
+    return `${value.value || value.displayName || value.name || ''}`
+  }
+  return `${value}`
+}
+
+export const getCustomFields = (fields: any): {[fieldID: string]: string} =>
+  Object.keys(fields || {})
+    .filter(fieldID => fieldID.startsWith('customfield_'))
+    .reduce(
+      (customFields, fieldID) => ({
+        ...customFields,
+        [fieldID]: formatFieldValue(fields[fieldID]),
+      }),
+      {}
+    )
+
+export type CreateMetaIssueType = {
+  id: string
+  name: string
+  subtask: boolean
+  fields: {[fieldID: string]: any}
+}
+
+export type Transition = {
+  id: string
+  name: string
+  toStatus: string
+}
+
+export type Sprint = {
+  id: number
+  name: string
+  state: string // future, active or closed
+  goal: string
+  endDate: string
+}
+
+export type SprintIssue = Issue & {
+  done: boolean
+}
+
+export type BoardColumn = {
+  name: string
+  statusIDs: Array<string>
+}
+
+export type BoardIssue = Issue & {
+  statusID: string
+}
+
+export type EpicChild = {
+  key: string
+  summary: string
+  status: string
+  done: boolean
+  points: number // 0 if not estimated
+}
+
+export type Version = {
+  id: string
+  name: string
+  released: boolean
+  releaseDate: string // YYYY-MM-DD; empty if not set
+  description: string
+}
+
+export type Worklog = {
+  authorID: string // as TeamUserConfig.jiraAccountID
+  started: string // ISO 8601
+  timeSpentSeconds: number
+  comment: string
+}
+
+// an approval of a Jira Service Management request
+export type Approval = {
+  id: string
+  name: string
+  pending: boolean
+  // whether the authenticated user is an approver who hasn't decided yet
+  canAnswer: boolean
+}
+
+export type IssueActivity = Issue & {
+  statusName: string
+  resolved: string // ISO 8601; empty if unresolved
+  // status changes in the period, oldest first
+  transitions: Array<{from: string; to: string}>
 }
 
 export enum JiraSubscriptionEvents {
   Unknown = 'unknown',
   IssueCreated = 'jira:issue_created',
   IssueUpdated = 'jira:issue_updated',
+  CommentCreated = 'comment_created',
   // disabled events:
   //
   // IssueDeleted = 'jira:issue_deleted',
-  // CommentCreated = 'comment_created',
   // CommentUpdated = 'comment_updated',
   // CommentDeleted = 'comment_deleted',
   // IssuePropertySet = 'issue_property_set',
   // IssuePropertyDeleted = 'issue_property_deleted',
 }
 
+// What differs between the APIs of Jira Cloud and Jira Server.
+export interface DeploymentAPI {
+  // the ID stored as TeamUserConfig.jiraAccountID, from /myself
+  accountIDFromMyself(myself: any): string
+  // a user in issue fields like the assignee
+  userField(accountID: string): {[key: string]: string}
+  // the ID of a user in responses, like the author of a worklog
+  userID(user: any): string
+  // JQL of the issues in an epic
+  epicChildrenJql(epicKey: string): string
+}
+
+// Cloud has dropped usernames for account IDs.
+const cloudAPI: DeploymentAPI = {
+  accountIDFromMyself: (myself: any) => myself.accountId,
+  userField: (accountID: string) => ({accountId: accountID}),
+  userID: (user: any) => user?.accountId || '',
+  epicChildrenJql: (epicKey: string) => `parent = ${epicKey}`,
+}
+
+// Server has no account IDs, users are known by their usernames.
+const serverAPI: DeploymentAPI = {
+  accountIDFromMyself: (myself: any) => myself.name,
+  userField: (accountID: string) => ({name: accountID}),
+  userID: (user: any) => user?.name || '',
+  epicChildrenJql: (epicKey: string) => `"Epic Link" = ${epicKey}`,
+}
+
+export const getDeploymentAPI = (
+  teamJiraConfig: Configs.TeamJiraConfig
+): DeploymentAPI =>
+  teamJiraConfig.deployment === Configs.JiraDeployment.Server
+    ? serverAPI
+    : cloudAPI
+
+export const getBaseURL = (teamJiraConfig: Configs.TeamJiraConfig): string =>
+  teamJiraConfig.baseURL || `https://${teamJiraConfig.jiraHost}`
+
+// options of jira-connector (and its oauth_util) for where the Jira API is
+export type ConnectionOptions = {
+  host: string
+  protocol?: string
+  port?: string
+  path_prefix?: string
+}
+
+export const getConnectionOptions = (
+  teamJiraConfig: Configs.TeamJiraConfig
+): ConnectionOptions => {
+  if (!teamJiraConfig.baseURL) {
+    return {host: teamJiraConfig.jiraHost}
+  }
+  const parsed = new URL(teamJiraConfig.baseURL)
+  const pathPrefix = parsed.pathname.replace(/\/+$/, '')
+  return {
+    host: parsed.hostname,
+    protocol: parsed.protocol.replace(/:$/, ''),
+    port: parsed.port || undefined,
+    path_prefix: pathPrefix ? `${pathPrefix}/` : undefined,
+  }
+}
+
 export class JiraClientWrapper {
   private jiraClient: JiraClient
-  private jiraHost: string
+  private baseURL: string
+  private api: DeploymentAPI
 
-  constructor(jiraClient: JiraClient, jiraHost: string) {
+  constructor(jiraClient: JiraClient, baseURL: string, api: DeploymentAPI) {
     this.jiraClient = jiraClient
-    this.jiraHost = jiraHost
+    this.baseURL = baseURL
+    this.api = api
   }
 
   jiraRespMapper = (issue: JiraIssue): Issue => ({
     assigneeJira: issue.fields.assignee?.displayName,
     createdTimeHumanized: moment(issue.fields.created).fromNow(),
+    created: issue.fields.created,
+    priority: issue.fields.priority?.name || '',
+    fixVersions: (issue.fields.fixVersions || []).map(
+      ({name}: {name: string}) => name
+    ),
     issueType: issue.fields.issuetype.name,
     key: issue.key,
     project: issue.fields.project.name,
+    projectKey: issue.fields.project.key,
+    customFields: getCustomFields(issue.fields),
     reporterJira: issue.fields.reporter?.displayName,
     status: issue.fields.status.statusCategory.name,
     summary: issue.fields.summary,
-    url: `https://${this.jiraHost}/browse/${issue.key}`,
+    url: `${this.baseURL}/browse/${issue.key}`,
+  })
+
+  sprintRespMapper = (sprint: any): Sprint => ({
+    id: sprint.id,
+    name: sprint.name,
+    state: sprint.state,
+    goal: sprint.goal || '',
+    endDate: sprint.endDate || '',
   })
 
   get({issueKey}: {issueKey: string}): Promise<any> {
@@ -106,18 +300,330 @@ export class JiraClientWrapper {
     }))
   }
 
-  addComment(issueKey: string, comment: string): Promise<any> {
+  search(jql: string, maxResults: number): Promise<Array<Issue>> {
+    logger.debug({msg: 'search', jql})
+    return this.jiraClient.search
+      .search({
+        jql,
+        fields: [
+          'key',
+          'summary',
+          'status',
+          'project',
+          'issuetype',
+          'assignee',
+          'reporter',
+          'created',
+        ],
+        method: 'GET',
+        maxResults,
+      })
+      .then((res: {issues: Array<JiraIssue>}) =>
+        res.issues.map(this.jiraRespMapper)
+      )
+  }
+
+  getBoardName(boardID: number): Promise<string> {
+    logger.debug({msg: 'getBoardName', boardID})
+    return this.jiraClient.board
+      .getBoard({boardId: boardID})
+      .then(({name}: {name: string}) => name)
+  }
+
+  findBoards(name: string): Promise<Array<{id: number; name: string}>> {
+    logger.debug({msg: 'findBoards', name})
+    return this.jiraClient.board
+      .getAllBoards({name})
+      .then((res: {values: Array<any>}) =>
+        (res.values || []).map(({id, name}: any) => ({id, name}))
+      )
+  }
+
+  getBoardColumns(boardID: number): Promise<Array<BoardColumn>> {
+    logger.debug({msg: 'getBoardColumns', boardID})
+    return this.jiraClient.board
+      .getConfiguration({boardId: boardID})
+      .then((res: any) =>
+        (res.columnConfig?.columns || []).map(
+          (column: any): BoardColumn => ({
+            name: column.name,
+            statusIDs: (column.statuses || []).map(({id}: any) => `${id}`),
+          })
+        )
+      )
+  }
+
+  // issues of a board in the statuses (any if none), in the board's order
+  getBoardIssues(
+    boardID: number,
+    statusIDs: Array<string>,
+    maxResults: number
+  ): Promise<Array<BoardIssue>> {
+    logger.debug({msg: 'getBoardIssues', boardID})
+    return this.jiraClient.board
+      .getIssuesForBoard({
+        boardId: boardID,
+        jql: statusIDs.length ? `status in (${statusIDs.join(', ')})` : '',
+        fields: [
+          'key',
+          'summary',
+          'status',
+          'project',
+          'issuetype',
+          'assignee',
+          'created',
+        ],
+        maxResults,
+      })
+      .then((res: {issues: Array<JiraIssue>}) =>
+        res.issues.map(
+          (issue: JiraIssue): BoardIssue => ({
+            ...this.jiraRespMapper(issue),
+            statusID: `${issue.fields.status?.id || ''}`,
+          })
+        )
+      )
+  }
+
+  getActiveSprints(boardID: number): Promise<Array<Sprint>> {
+    logger.debug({msg: 'getActiveSprints', boardID})
+    return this.jiraClient.board
+      .getAllSprints({boardId: boardID, state: 'active'})
+      .then((res: {values: Array<any>}) =>
+        (res.values || []).map(this.sprintRespMapper)
+      )
+  }
+
+  getSprint(sprintID: number): Promise<Sprint> {
+    logger.debug({msg: 'getSprint', sprintID})
+    return this.jiraClient.sprint
+      .getSprint({sprintId: sprintID})
+      .then(this.sprintRespMapper)
+  }
+
+  getSprintIssues(
+    sprintID: number,
+    maxResults: number
+  ): Promise<Array<SprintIssue>> {
+    logger.debug({msg: 'getSprintIssues', sprintID})
+    return this.jiraClient.sprint
+      .getSprintIssues({
+        sprintId: sprintID,
+        fields: ['key', 'summary', 'status', 'project', 'issuetype'],
+        maxResults,
+      })
+      .then((res: {issues: Array<JiraIssue>}) =>
+        res.issues.map(
+          (issue: JiraIssue): SprintIssue => ({
+            ...this.jiraRespMapper(issue),
+            done: issue.fields.status?.statusCategory?.key === 'done',
+          })
+        )
+      )
+  }
+
+  // The story points field is a custom field with a different ID on each
+  // instance; empty if there's none.
+  getStoryPointsFieldID(): Promise<string> {
+    logger.debug({msg: 'getStoryPointsFieldID'})
+    return this.jiraClient.field
+      .getAllFields()
+      .then(
+        (fields: Array<{id: string; name: string}>) =>
+          (
+            fields.find(({name}) =>
+              ['Story Points', 'Story point estimate'].includes(name)
+            ) || {id: ''}
+          ).id
+      )
+  }
+
+  async getEpicChildren(
+    epicKey: string,
+    maxResults: number
+  ): Promise<Array<EpicChild>> {
+    logger.debug({msg: 'getEpicChildren', epicKey})
+    const pointsFieldID = await this.getStoryPointsFieldID()
+    const res = await this.jiraClient.search.search({
+      jql: `${this.api.epicChildrenJql(epicKey)} ORDER BY Rank ASC`,
+      fields: [
+        'key',
+        'summary',
+        'status',
+        ...(pointsFieldID ? [pointsFieldID] : []),
+      ],
+      method: 'GET',
+      maxResults,
+    })
+    return (res.issues || []).map(
+      (issue: JiraIssue): EpicChild => ({
+        key: issue.key,
+        summary: issue.fields.summary,
+        status: issue.fields.status?.name || '',
+        done: issue.fields.status?.statusCategory?.key === 'done',
+        points: (pointsFieldID && Number(issue.fields[pointsFieldID])) || 0,
+      })
+    )
+  }
+
+  getProjectVersions(project: string): Promise<Array<Version>> {
+    logger.debug({msg: 'getProjectVersions', project})
+    return this.jiraClient.project
+      .getVersions({projectIdOrKey: project})
+      .then((versions: Array<any>) =>
+        versions.map(
+          (version: any): Version => ({
+            id: `${version.id}`,
+            name: version.name,
+            released: !!version.released,
+            releaseDate: version.releaseDate || '',
+            description: version.description || '',
+          })
+        )
+      )
+  }
+
+  // issues of a board updated in the last hours
+  getBoardActivity(
+    boardID: number,
+    hours: number,
+    maxResults: number
+  ): Promise<Array<IssueActivity>> {
+    logger.debug({msg: 'getBoardActivity', boardID})
+    const since = moment().subtract(hours, 'hours')
+    return this.jiraClient.board
+      .getIssuesForBoard({
+        boardId: boardID,
+        jql: `updated >= -${hours}h`,
+        fields: [
+          'key',
+          'summary',
+          'status',
+          'project',
+          'issuetype',
+          'assignee',
+          'created',
+          'resolutiondate',
+        ],
+        expand: 'changelog',
+        maxResults,
+      })
+      .then((res: {issues: Array<JiraIssue>}) =>
+        res.issues.map(
+          (issue: JiraIssue): IssueActivity => ({
+            ...this.jiraRespMapper(issue),
+            statusName: issue.fields.status?.name || '',
+            resolved: issue.fields.resolutiondate || '',
+            transitions: (issue.changelog?.histories || [])
+              .filter((history: any) => moment(history.created).isAfter(since))
+              .sort((a: any, b: any) => moment(a.created).diff(b.created))
+              .reduce(
+                (items: Array<any>, history: any) =>
+                  items.concat(history.items || []),
+                []
+              )
+              .filter((item: any) => item.field === 'status')
+              .map((item: any) => ({from: item.fromString, to: item.toString})),
+          })
+        )
+      )
+  }
+
+  addComment(
+    issueKey: string,
+    comment: string
+  ): Promise<{id: string; url: string}> {
     return this.jiraClient.issue
       .addComment({
         issueKey,
         body: comment,
       })
+      .then(({id}: {id: string}) => ({
+        id,
+        url: `${this.baseURL}/browse/${issueKey}?focusedCommentId=${id}`,
+      }))
+  }
+
+  // timeSpent is in the Jira format, like `2h 30m`
+  addWorklog(
+    issueKey: string,
+    timeSpent: string,
+    comment: string
+  ): Promise<string> {
+    logger.debug({msg: 'addWorklog', issueKey, timeSpent})
+    return this.jiraClient.issue
+      .addWorkLog({
+        issueKey,
+        worklog: {timeSpent, ...(comment ? {comment} : {})},
+      })
       .then(
         ({id}: {id: string}) =>
-          `https://${this.jiraHost}/browse/${issueKey}?focusedCommentId=${id}`
+          `${this.baseURL}/browse/${issueKey}?focusedWorklogId=${id}`
       )
   }
 
+  getWorklogs(issueKey: string): Promise<Array<Worklog>> {
+    logger.debug({msg: 'getWorklogs', issueKey})
+    return this.jiraClient.issue
+      .getWorkLogs({issueKey})
+      .then((resp: {worklogs: Array<any>}) =>
+        (resp.worklogs || []).map(
+          (worklog: any): Worklog => ({
+            authorID: this.api.userID(worklog.author),
+            started: worklog.started,
+            timeSpentSeconds: worklog.timeSpentSeconds || 0,
+            comment: typeof worklog.comment === 'string' ? worklog.comment : '',
+          })
+        )
+      )
+  }
+
+  // jira-connector doesn't cover the Service Management API, so it's called
+  // with the client's authentication.
+  private serviceDeskRequest(
+    method: 'GET' | 'POST',
+    path: string,
+    body?: any
+  ): Promise<any> {
+    return this.jiraClient.makeRequest({
+      uri: `${this.baseURL}/rest/servicedeskapi${path}`,
+      method,
+      json: true,
+      body,
+      headers: {'X-ExperimentalApi': 'opt-in'},
+    })
+  }
+
+  getApprovals(issueKey: string): Promise<Array<Approval>> {
+    logger.debug({msg: 'getApprovals', issueKey})
+    return this.serviceDeskRequest(
+      'GET',
+      `/request/${issueKey}/approval`
+    ).then((resp: {values: Array<any>}) =>
+      (resp?.values || []).map(
+        (approval: any): Approval => ({
+          id: `${approval.id}`,
+          name: approval.name || '',
+          pending: approval.finalDecision === 'pending',
+          canAnswer: !!approval.canAnswerApprovalDecision,
+        })
+      )
+    )
+  }
+
+  answerApproval(
+    issueKey: string,
+    approvalID: string,
+    approve: boolean
+  ): Promise<string> {
+    logger.debug({msg: 'answerApproval', issueKey, approvalID, approve})
+    return this.serviceDeskRequest(
+      'POST',
+      `/request/${issueKey}/approval/${approvalID}`,
+      {decision: approve ? 'approve' : 'decline'}
+    ).then(() => `${this.baseURL}/browse/${issueKey}`)
+  }
+
   createIssue({
     assigneeJira,
     description,
@@ -142,14 +648,112 @@ export class JiraClientWrapper {
     return this.jiraClient.issue
       .createIssue({
         fields: {
-          assignee: assigneeJira ? {name: assigneeJira} : undefined,
+          assignee: assigneeJira
+            ? this.api.userField(assigneeJira)
+            : undefined,
           project: {key: project.toUpperCase()},
           issuetype: {name: issueType},
           summary: name,
           description,
         },
       })
-      .then(({key}: {key: string}) => `https://${this.jiraHost}/browse/${key}`)
+      .then(({key}: {key: string}) => `${this.baseURL}/browse/${key}`)
+  }
+
+  // issue types of a project, with the fields of their create screens
+  getCreateMeta(project: string): Promise<Array<CreateMetaIssueType>> {
+    logger.debug({
+      msg: 'getCreateMeta',
+      project,
+    })
+    return this.jiraClient.issue
+      .getCreateMetadata({
+        projectKeys: [project.toUpperCase()],
+        expand: 'projects.issuetypes.fields',
+      })
+      .then((resp: {projects: Array<{issuetypes: Array<any>}>}) =>
+        (resp.projects?.[0]?.issuetypes || []).map(
+          (issueType: any): CreateMetaIssueType => ({
+            id: issueType.id,
+            name: issueType.name,
+            subtask: !!issueType.subtask,
+            fields: issueType.fields || {},
+          })
+        )
+      )
+  }
+
+  createIssueFromFields(fields: {
+    [fieldID: string]: any
+  }): Promise<{key: string; url: string}> {
+    logger.debug({
+      msg: 'createIssueFromFields',
+      fields,
+    })
+    return this.jiraClient.issue
+      .createIssue({fields})
+      .then(({key}: {key: string}) => ({
+        key,
+        url: `${this.baseURL}/browse/${key}`,
+      }))
+  }
+
+  // transitions of an issue's workflow the authenticated user can make now
+  getTransitions(issueKey: string): Promise<Array<Transition>> {
+    logger.debug({
+      msg: 'getTransitions',
+      issueKey,
+    })
+    return this.jiraClient.issue
+      .getTransitions({issueKey})
+      .then((resp: {transitions: Array<any>}) =>
+        (resp.transitions || []).map(
+          (transition: any): Transition => ({
+            id: transition.id,
+            name: transition.name,
+            toStatus: transition.to?.name || '',
+          })
+        )
+      )
+  }
+
+  transitionIssue(issueKey: string, transitionID: string): Promise<string> {
+    logger.debug({
+      msg: 'transitionIssue',
+      issueKey,
+      transitionID,
+    })
+    return this.jiraClient.issue
+      .transitionIssue({
+        issueKey,
+        transition: {id: transitionID},
+      })
+      .then(() => `${this.baseURL}/browse/${issueKey}`)
+  }
+
+  assignIssue(issueKey: string, accountID: string): Promise<string> {
+    logger.debug({
+      msg: 'assignIssue',
+      issueKey,
+      accountID,
+    })
+    return this.jiraClient.issue
+      .editIssue({
+        issueKey,
+        issue: {fields: {assignee: this.api.userField(accountID)}},
+      })
+      .then(() => `${this.baseURL}/browse/${issueKey}`)
+  }
+
+  addWatcher(issueKey: string, accountID: string): Promise<string> {
+    logger.debug({
+      msg: 'addWatcher',
+      issueKey,
+      accountID,
+    })
+    return this.jiraClient.issue
+      .addWatcher({issueKey, watcher: accountID})
+      .then(() => `${this.baseURL}/browse/${issueKey}`)
   }
 
   getIssueTypes(): Promise<Array<string>> {
@@ -179,10 +783,12 @@ export class JiraClientWrapper {
       .then((resp: Array<{name: string}>) => resp.map(({name}) => name))
   }
 
+  // Jira signs the deliveries with the secret, in X-Hub-Signature.
   subscribe(
     jqlFilter: string,
     events: Array<JiraSubscriptionEvents>,
-    url: string
+    url: string,
+    secret: string
   ): Promise<string> {
     logger.debug({
       msg: 'subscribe',
@@ -193,6 +799,7 @@ export class JiraClientWrapper {
         url,
         filters: {'issue-related-events-section': jqlFilter},
         events,
+        secret,
       })
       .then((res?: {self?: string}) => {
         return res && res.self
@@ -208,41 +815,53 @@ export const projectToJqlFilter = (project: string) => `project = ${project}`
 
 const jiraClientCacheTimeout = 60 * 1000 // 1min
 
+export type Credentials = Pick<
+  Configs.TeamUserConfig,
+  'accessToken' | 'tokenSecret' | 'personalAccessToken' | 'basicAuth'
+>
+
+const getAuthOptions = (
+  teamJiraConfig: Configs.TeamJiraConfig,
+  credentials: Credentials
+) => {
+  if (credentials.personalAccessToken) {
+    return {bearer: credentials.personalAccessToken}
+  }
+  if (credentials.basicAuth) {
+    return {basic_auth: credentials.basicAuth}
+  }
+  return {
+    oauth: {
+      token: credentials.accessToken,
+      token_secret: credentials.tokenSecret,
+      consumer_key: teamJiraConfig.jiraAuth.consumerKey,
+      private_key: teamJiraConfig.jiraAuth.privateKey,
+    },
+  }
+}
+
 const getJiraClient = mem(
   (
-    jiraHost: string,
-    accessToken: string,
-    tokenSecret: string,
-    consumerKey: string,
-    privateKey: string
+    teamJiraConfig: Configs.TeamJiraConfig,
+    credentials: Credentials
   ): JiraClient =>
     new JiraClient({
-      host: jiraHost,
-      oauth: {
-        token: accessToken,
-        token_secret: tokenSecret,
-        consumer_key: consumerKey,
-        private_key: privateKey,
-      },
+      ...getConnectionOptions(teamJiraConfig),
+      ...getAuthOptions(teamJiraConfig, credentials),
     }),
   {maxAge: jiraClientCacheTimeout, cacheKey: JSON.stringify}
 )
 
 export const getAccountId = async (
   teamJiraConfig: Configs.TeamJiraConfig,
-  accessToken: string,
-  tokenSecret: string
+  credentials: Credentials
 ): Promise<Errors.ResultOrError<string, Errors.UnknownError>> => {
-  const tempJiraClient = getJiraClient(
-    teamJiraConfig.jiraHost,
-    accessToken,
-    tokenSecret,
-    teamJiraConfig.jiraAuth.consumerKey,
-    teamJiraConfig.jiraAuth.privateKey
-  )
+  const tempJiraClient = getJiraClient(teamJiraConfig, credentials)
   try {
     const accountDetail = await tempJiraClient.myself.getMyself()
-    return Errors.makeResult(accountDetail.accountId)
+    return Errors.makeResult(
+      getDeploymentAPI(teamJiraConfig).accountIDFromMyself(accountDetail)
+    )
   } catch (err) {
     return Errors.makeUnknownError(err)
   }
@@ -287,16 +906,14 @@ export const getJiraFromTeamnameAndUsername = async (
   }
   const teamUserConfig = teamUserConfigRet.result.config
 
-  const jiraClient = getJiraClient(
-    teamJiraConfig.jiraHost,
-    teamUserConfig.accessToken,
-    teamUserConfig.tokenSecret,
-    teamJiraConfig.jiraAuth.consumerKey,
-    teamJiraConfig.jiraAuth.privateKey
-  )
+  const jiraClient = getJiraClient(teamJiraConfig, teamUserConfig)
 
   return Errors.makeResult(
-    new JiraClientWrapper(jiraClient, teamJiraConfig.jiraHost)
+    new JiraClientWrapper(
+      jiraClient,
+      getBaseURL(teamJiraConfig),
+      getDeploymentAPI(teamJiraConfig)
+    )
   )
 }
 
@@ -0,0 +1,177 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import {statusToEmoji} from './emoji'
+import logger from './logger'
+import {pollBoardSubscription} from './sprint-poller'
+import {pollDigestSubscription} from './digest-poller'
+import {pollVersionSubscription} from './version-poller'
+import {pollServiceDeskSubscription} from './servicedesk-poller'
+import {pollEpicSubscription} from './epic-poller'
+
+// Issues beyond this many results of a JQL subscription are not tracked.
+export const maxPolledIssues = 100
+
+const pollInterval = 5 * 60 * 1000 // 5min
+
+const issueToLine = (issue: Jira.Issue) =>
+  `${statusToEmoji(issue.status)} *${issue.key}* ${issue.summary} - ${
+    issue.url
+  }`
+
+const pollSubscription = async (
+  context: Context,
+  teamname: string,
+  subscriptionID: number,
+  subscription: Configs.TeamJiraSubscription
+): Promise<void> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    subscription.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({msg: 'pollSubscription', teamname, error: jiraRet.error})
+    return
+  }
+  const jira = jiraRet.result
+
+  const stateRet = await context.configs.getJqlSubscriptionState(
+    teamname,
+    subscriptionID
+  )
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({msg: 'pollSubscription', teamname, error: stateRet.error})
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+
+  let issues: Array<Jira.Issue>
+  try {
+    issues = await jira.search(subscription.jql, maxPolledIssues)
+  } catch (error) {
+    logger.warn({msg: 'pollSubscription', teamname, error})
+    return
+  }
+
+  const newState = {
+    issues: issues.map(({key, summary}) => ({key, summary})),
+  }
+  const updateRet = await context.configs.updateJqlSubscriptionState(
+    teamname,
+    subscriptionID,
+    oldState,
+    newState
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it announces the changes.
+    logger.warn({msg: 'pollSubscription', teamname, error: updateRet.error})
+    return
+  }
+  if (!oldState) {
+    return
+  }
+
+  const oldKeys = new Set(oldState.config.issues.map(({key}) => key))
+  const newKeys = new Set(issues.map(({key}) => key))
+  const entered = issues.filter(({key}) => !oldKeys.has(key))
+  // Older issues of a project drop off the results as new ones come in.
+  const left = subscription.pollsProject
+    ? []
+    : oldState.config.issues.filter(({key}) => !newKeys.has(key))
+  if (!entered.length && !left.length) {
+    return
+  }
+
+  context.stathat.postCount('jql subscription changes', 1)
+  const lines = [`Changes in \`${subscription.jql}\`:`]
+  if (entered.length) {
+    lines.push('*Entered:*', ...entered.map(issueToLine))
+  }
+  if (left.length) {
+    lines.push(
+      '*Left:*',
+      ...left.map(({key, summary}) => `~_${key}_~ ${summary}`)
+    )
+  }
+  await context.bot.chat.send(subscription.conversationId, {
+    body: lines.join('\n'),
+  })
+}
+
+export const pollJqlSubscriptions = async (context: Context): Promise<void> => {
+  const indicesRet = await context.configs.listAllJiraSubscriptionIndices()
+  if (indicesRet.type !== Errors.ReturnType.Ok) {
+    logger.warn({msg: 'pollJqlSubscriptions', error: indicesRet.error})
+    return
+  }
+  const teamnames = new Set(indicesRet.result.map(index => index.teamname))
+
+  for (const teamname of teamnames) {
+    const getSubRet = await context.configs.getTeamJiraSubscriptions(teamname)
+    if (getSubRet.type !== Errors.ReturnType.Ok) {
+      logger.warn({msg: 'pollJqlSubscriptions', error: getSubRet.error})
+      continue
+    }
+    for (const [subscriptionID, subscription] of [
+      ...getSubRet.result.config.entries(),
+    ]) {
+      if (!subscription.pollingUsername) {
+        continue
+      }
+      try {
+        subscription.epicKey
+          ? await pollEpicSubscription(
+              context,
+              teamname,
+              subscriptionID,
+              subscription
+            )
+          : subscription.serviceDeskProject
+          ? await pollServiceDeskSubscription(
+              context,
+              teamname,
+              subscriptionID,
+              subscription
+            )
+          : subscription.versionsProject
+          ? await pollVersionSubscription(
+              context,
+              teamname,
+              subscriptionID,
+              subscription
+            )
+          : subscription.digest
+          ? await pollDigestSubscription(
+              context,
+              teamname,
+              subscriptionID,
+              subscription
+            )
+          : subscription.boardID
+          ? await pollBoardSubscription(
+              context,
+              teamname,
+              subscriptionID,
+              subscription
+            )
+          : await pollSubscription(
+              context,
+              teamname,
+              subscriptionID,
+              subscription
+            )
+      } catch (error) {
+        logger.warn({msg: 'pollJqlSubscriptions', teamname, error})
+      }
+    }
+  }
+}
+
+export default (context: Context) =>
+  setInterval(() => pollJqlSubscriptions(context), pollInterval)
@@ -1,24 +1,45 @@
 import ChatTypes from 'keybase-bot/lib/types/chat1'
+import {URL} from 'url'
 import * as Utils from './utils'
 import {Context} from './context'
 import * as Errors from './errors'
 import logger from './logger'
 import * as Configs from './configs'
 import * as Jira from './jira'
+import {getThreadIssueKey} from './comment-bridge'
 // No types
 const isValidDomain = require('is-valid-domain')
 
 export enum BotMessageType {
   Unknown = 'unknown',
   Create = 'create',
+  CreatePrompted = 'create-prompted',
+  PromptAnswer = 'prompt-answer',
   Search = 'search',
   Comment = 'comment',
+  Move = 'move',
+  Assign = 'assign',
+  Watch = 'watch',
+  Approval = 'approval',
+  Log = 'log',
+  Timesheet = 'timesheet',
+  Bulk = 'bulk',
+  Backlog = 'backlog',
+  Board = 'board',
+  BulkConfirm = 'bulk-confirm',
   Reacji = 'reacji',
   Config = 'config',
   Auth = 'auth',
+  AuthCredentials = 'auth-credentials',
   Feed = 'feed',
+  Sla = 'sla',
+  Fields = 'fields',
+  Notify = 'notify',
   Debug = 'debug',
   Show = 'show',
+  Version = 'version',
+  Epic = 'epic',
+  Unfurl = 'unfurl',
 }
 
 export type MessageContext = Readonly<{
@@ -46,6 +67,21 @@ export type CreateMessage = Readonly<{
   issueType: string
 }>
 
+export type CreatePromptedMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.CreatePrompted
+  project: string
+  name: string
+  description: string
+}>
+
+// a reply to a question jirabot asked the sender, e.g. by `!jira create`
+export type PromptAnswerMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.PromptAnswer
+  answer: string
+}>
+
 export type SearchMessage = Readonly<{
   context: MessageContext
   type: BotMessageType.Search
@@ -60,6 +96,81 @@ export type CommentMessage = Readonly<{
   type: BotMessageType.Comment
   ticket: string
   comment: string
+  inThread: boolean // replying to a comment thread of the ticket
+}>
+
+export type MoveMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Move
+  ticket: string
+  transition: string
+}>
+
+export type AssignMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Assign
+  ticket: string
+  assignee: string // Keybase username
+}>
+
+export type WatchMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Watch
+  ticket: string
+}>
+
+// approves or declines the approvals of a service desk request waiting for
+// the sender
+export type ApprovalMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Approval
+  ticket: string
+  approve: boolean
+}>
+
+export type BacklogMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Backlog
+  project: string
+  top: number
+}>
+
+export type BoardMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Board
+  board: string // name or ID
+  column?: string // all columns if unset
+}>
+
+export type LogMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Log
+  ticket: string
+  timeSpent: string // in the Jira format, like `2h 30m`
+  comment: string
+}>
+
+export type TimesheetPeriod = 'today' | 'week' | 'month'
+
+export type TimesheetMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Timesheet
+  period: TimesheetPeriod
+}>
+
+export type BulkMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Bulk
+  jql: string
+  transition?: string
+  comment?: string
+}>
+
+// `!jira bulk confirm` or `!jira bulk cancel` after a bulk preview
+export type BulkConfirmMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.BulkConfirm
+  confirmed: boolean
 }>
 
 export type ReacjiMessage = Readonly<{
@@ -84,9 +195,24 @@ export type ConfigMessage = Readonly<{
   }>
 }>
 
+export enum AuthMethod {
+  OAuth = 'oauth',
+  Basic = 'basic',
+  Token = 'token',
+}
+
 export type AuthMessage = Readonly<{
   context: MessageContext
   type: BotMessageType.Auth
+  method: AuthMethod
+}>
+
+// credentials the sender was asked for in a private conversation by
+// `!jira auth basic` or `!jira auth token`
+export type AuthCredentialsMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.AuthCredentials
+  credentials: string
 }>
 
 export enum FeedMessageType {
@@ -101,6 +227,12 @@ export type FeedSubscribeMessage = Readonly<{
   feedMessageType: FeedMessageType.Subscribe
   project: string
   withUpdates: boolean
+  jql?: string // polled JQL subscription if set
+  boardID?: number // polled sprint subscription if set
+  digest?: boolean // daily digest of the board instead of sprints if set
+  versionsProject?: string // polled version subscription if set
+  serviceDeskProject?: string // polled service desk subscription if set
+  epicKey?: string // polled epic subscription if set
 }>
 
 export type FeedUnsubscribeMessage = Readonly<{
@@ -122,6 +254,82 @@ export type FeedMessage =
   | FeedUnsubscribeMessage
   | FeedListMessage
 
+export enum SlaMessageType {
+  Add = 'add',
+  Remove = 'remove',
+  List = 'list',
+}
+
+export type SlaAddMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Sla
+  slaMessageType: SlaMessageType.Add
+  priority: string
+  thresholdMinutes: number
+}>
+
+export type SlaRemoveMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Sla
+  slaMessageType: SlaMessageType.Remove
+  ruleID: number
+}>
+
+export type SlaListMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Sla
+  slaMessageType: SlaMessageType.List
+}>
+
+export type SlaMessage = SlaAddMessage | SlaRemoveMessage | SlaListMessage
+
+export enum FieldsMessageType {
+  Add = 'add',
+  Remove = 'remove',
+  List = 'list',
+}
+
+export type FieldsAddMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Fields
+  fieldsMessageType: FieldsMessageType.Add
+  project: string
+  fieldID: string
+  name: string
+}>
+
+export type FieldsRemoveMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Fields
+  fieldsMessageType: FieldsMessageType.Remove
+  project: string
+  fieldID: string
+}>
+
+export type FieldsListMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Fields
+  fieldsMessageType: FieldsMessageType.List
+  project: string
+}>
+
+export type FieldsMessage =
+  | FieldsAddMessage
+  | FieldsRemoveMessage
+  | FieldsListMessage
+
+// Without enable nor quietHours, this shows the preferences.
+export type NotifyMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Notify
+  enable?: Readonly<{
+    kinds: Array<Configs.NotificationKind>
+    enabled: boolean
+  }>
+  // null turns quiet hours off
+  quietHours?: null | Readonly<{start: string; end: string}>
+}>
+
 export enum DebugType {
   LogSend = 'logSend',
   Pprof = 'pprof',
@@ -146,17 +354,56 @@ export type ShowMessage = Readonly<{
   issueKeys: Array<string>
 }>
 
+export type VersionMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Version
+  project: string
+  version: string
+}>
+
+export type EpicMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Epic
+  epicKey: string
+}>
+
+// issue keys in a message which isn't a command
+export type UnfurlMessage = Readonly<{
+  context: MessageContext
+  type: BotMessageType.Unfurl
+  issueKeys: Array<string>
+}>
+
 export type Message =
   | UnknownMessage
   | SearchMessage
   | CommentMessage
+  | MoveMessage
+  | AssignMessage
+  | WatchMessage
+  | ApprovalMessage
+  | LogMessage
+  | TimesheetMessage
+  | BulkMessage
+  | BacklogMessage
+  | BoardMessage
+  | BulkConfirmMessage
   | ReacjiMessage
   | CreateMessage
+  | CreatePromptedMessage
+  | PromptAnswerMessage
   | ConfigMessage
   | AuthMessage
+  | AuthCredentialsMessage
   | FeedMessage
+  | SlaMessage
+  | FieldsMessage
+  | NotifyMessage
   | DebugMessage
   | ShowMessage
+  | VersionMessage
+  | EpicMessage
+  | UnfurlMessage
 
 const getTextMessage = (message: ChatTypes.MsgSummary): string | undefined => {
   if (!message || !message.content) {
@@ -334,6 +581,50 @@ const msgSummaryToMessageContext = (
   messageID: kbMessage.id,
 })
 
+const durationUnitMinutes: {[unit: string]: number} = {
+  m: 1,
+  h: 60,
+  d: 60 * 24,
+}
+
+// minutes in a duration like `30m`, `4h` or `2d`; 0 if it isn't one
+const parseDuration = (str: string): number => {
+  const match = str.toLowerCase().match(/^(\d+)([mhd])$/)
+  return match ? Number.parseInt(match[1]) * durationUnitMinutes[match[2]] : 0
+}
+
+// a part of a Jira time tracking duration, like `2h` or `1.5d`
+const isTimeSpentPart = (str: string): boolean =>
+  /^(\d+(\.\d+)?[wdhm])+$/i.test(str)
+
+// base URL of a self-hosted Jira, which may be under a path
+const isValidServerURL = (str: string): boolean => {
+  try {
+    const parsed = new URL(str)
+    return (
+      ['http:', 'https:'].includes(parsed.protocol) &&
+      isValidDomain(parsed.hostname) &&
+      !parsed.search &&
+      !parsed.hash
+    )
+  } catch {
+    return false
+  }
+}
+
+const isPrivateConversationWithBot = (
+  context: Context,
+  messageContext: MessageContext
+) =>
+  messageContext.chatChannel.membersType !== 'team' &&
+  messageContext.chatChannel.name
+    .split(',')
+    .sort()
+    .join(',') ===
+    [messageContext.senderUsername, context.botConfig.keybase.username]
+      .sort()
+      .join(',')
+
 const shouldProcessMessageContext = (
   context: Context,
   messageContext: MessageContext
@@ -351,6 +642,7 @@ const shouldProcessMessageContext = (
 }
 
 const newArgs = new Set(['in', 'for', 'assignee'])
+const maxBacklogTop = 50
 const searchArgs = new Set(['in', 'assignee', 'status'])
 const commentArgs = new Set(['on'])
 
@@ -360,6 +652,19 @@ export const parseMessage = async (
 ): Promise<Message | undefined> => {
   const messageContext = msgSummaryToMessageContext(kbMessage)
   logger.debug({msg: 'got message', messageContext})
+  if (
+    isPrivateConversationWithBot(context, messageContext) &&
+    context.pendingCredentials.has(messageContext.senderUsername)
+  ) {
+    const textBody = getTextMessage(kbMessage)
+    return textBody
+      ? {
+          context: messageContext,
+          type: BotMessageType.AuthCredentials,
+          credentials: textBody.trim(),
+        }
+      : undefined
+  }
   if (!shouldProcessMessageContext(context, messageContext)) {
     logger.debug({
       msg: 'ignoring message from',
@@ -381,6 +686,18 @@ export const parseMessage = async (
   }
 
   if (!textBody.startsWith('!jira')) {
+    if (
+      context.createSessions.has(
+        messageContext.conversationId,
+        messageContext.senderUsername
+      )
+    ) {
+      return {
+        context: messageContext,
+        type: BotMessageType.PromptAnswer,
+        answer: textBody.trim(),
+      }
+    }
     if (textBody.includes(`@${context.botConfig.keybase.username}`)) {
       const issueKeys = Jira.findIssueKeys(textBody)
       if (issueKeys.length) {
@@ -391,10 +708,28 @@ export const parseMessage = async (
         }
       }
     }
+    // edits would unfurl the same issues again
+    if (
+      kbMessage.content.type === 'text' &&
+      messageContext.senderUsername !== context.botConfig.keybase.username
+    ) {
+      const issueKeys = Jira.findUnfurlIssueKeys(textBody)
+      if (issueKeys.length) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unfurl,
+          issueKeys,
+        }
+      }
+    }
     return undefined
   }
 
   const fields = Utils.split2(textBody)
+  if (['list', 'subscribe', 'unsubscribe'].includes(fields[1])) {
+    // `!jira subscribe ...` is short for `!jira feed subscribe ...`
+    fields.splice(1, 0, 'feed')
+  }
 
   switch (fields[1]) {
     case 'new': {
@@ -460,6 +795,54 @@ export const parseMessage = async (
         issueType,
       }
     }
+    case 'create': {
+      if (fields.length < 3) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: '`!jira create` needs at least a summary for the ticket',
+        }
+      }
+      let getProjectRet = await getProject(
+        context,
+        messageContext,
+        fields.length > 3 ? fields[2] : '',
+        true
+      )
+      let rest = fields.slice(fields.length > 3 ? 3 : 2)
+      if (
+        getProjectRet.type === Errors.ReturnType.Error &&
+        getProjectRet.error.type === Errors.ErrorType.InvalidJiraField
+      ) {
+        // Maybe fields[2] is the summary and the channel has a default
+        // project. Otherwise it's just an invalid project.
+        const defaultProjectRet = await getProject(
+          context,
+          messageContext,
+          '',
+          true
+        )
+        if (defaultProjectRet.type === Errors.ReturnType.Ok) {
+          getProjectRet = defaultProjectRet
+          rest = fields.slice(2)
+        }
+      }
+      if (getProjectRet.type === Errors.ReturnType.Error) {
+        Errors.reportErrorAndReplyChat(
+          context,
+          messageContext,
+          getProjectRet.error
+        )
+        return undefined
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.CreatePrompted,
+        project: getProjectRet.result,
+        name: Utils.linebreaksToSpaces(rest[0]),
+        description: rest.slice(1).join(' '),
+      }
+    }
     case 'search': {
       const {args, rest} = extractArgsAfterCommand(fields.slice(2), searchArgs)
       if (rest.length < 1) {
@@ -515,24 +898,266 @@ export const parseMessage = async (
           error: '`!jira comment` needs a comment to post',
         }
       }
+      const replyTo =
+        kbMessage.content.type === 'text'
+          ? kbMessage.content.text?.replyTo
+          : undefined
+      const threadIssueKey =
+        !args.on && replyTo
+          ? await getThreadIssueKey(
+              context,
+              messageContext.teamName,
+              messageContext.conversationId,
+              replyTo
+            )
+          : undefined
+      if (!args.on && !threadIssueKey) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira comment` needs a ticket, like `!jira comment on TRIAGE-1024 ...`, or a reply to one of my announcements',
+        }
+      }
       return {
         context: messageContext,
         type: BotMessageType.Comment,
-        ticket: args.on,
+        ticket: args.on || threadIssueKey,
         comment: rest.join(' '),
+        inThread: !!threadIssueKey,
+      }
+    }
+    case 'move': {
+      if (fields.length < 4 || !Jira.looksLikeIssueKey(fields[2])) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira move` needs an issue key and where to move it, like `!jira move PROJ-123 "In Review"`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Move,
+        ticket: fields[2].toUpperCase(),
+        transition: Utils.linebreaksToSpaces(fields.slice(3).join(' ')),
+      }
+    }
+    case 'assign': {
+      if (
+        fields.length !== 4 ||
+        !Jira.looksLikeIssueKey(fields[2]) ||
+        !(fields[3].startsWith('@') || fields[3] === 'me')
+      ) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira assign` needs an issue key and who to assign it to, like `!jira assign PROJ-123 @alice` or `!jira assign PROJ-123 me`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Assign,
+        ticket: fields[2].toUpperCase(),
+        assignee:
+          fields[3] === 'me'
+            ? messageContext.senderUsername
+            : fields[3].slice(1).toLowerCase(),
+      }
+    }
+    case 'watch': {
+      if (fields.length !== 3 || !Jira.looksLikeIssueKey(fields[2])) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: '`!jira watch` needs an issue key, like `!jira watch PROJ-123`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Watch,
+        ticket: fields[2].toUpperCase(),
+      }
+    }
+    case 'approve':
+    case 'decline': {
+      if (fields.length !== 3 || !Jira.looksLikeIssueKey(fields[2])) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: `\`!jira ${fields[1]}\` needs the key of a service desk request, like \`!jira ${fields[1]} HELP-123\``,
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Approval,
+        ticket: fields[2].toUpperCase(),
+        approve: fields[1] === 'approve',
+      }
+    }
+    case 'log': {
+      // `2h 30m` and `2h30m` are both fine
+      let commentStart = 3
+      while (fields[commentStart] && isTimeSpentPart(fields[commentStart])) {
+        ++commentStart
+      }
+      const timeSpentParts = fields
+        .slice(3, commentStart)
+        .join('')
+        .toLowerCase()
+        .match(/\d+(\.\d+)?[wdhm]/g)
+      if (!Jira.looksLikeIssueKey(fields[2] || '') || !timeSpentParts) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira log` needs an issue key and the time spent, like `!jira log PROJ-123 2h "debugging flaky test"`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Log,
+        ticket: fields[2].toUpperCase(),
+        timeSpent: timeSpentParts.join(' '),
+        comment: fields.slice(commentStart).join(' '),
+      }
+    }
+    case 'timesheet': {
+      const period = fields[2] || 'week'
+      if (fields.length > 3 || !['today', 'week', 'month'].includes(period)) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: '`!jira timesheet` takes `today`, `week` or `month`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Timesheet,
+        period: period as TimesheetPeriod,
+      }
+    }
+    case 'backlog': {
+      const {args, rest} = extractArgsAfterCommand(
+        fields.slice(3),
+        new Set(['--top'])
+      )
+      const top = args['--top'] ? Number.parseInt(args['--top']) : 10
+      if (
+        !fields[2] ||
+        rest.length ||
+        !(top > 0 && top <= maxBacklogTop) ||
+        (args['--top'] && `${top}` !== args['--top'])
+      ) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: `\`!jira backlog\` needs a project and optionally how many issues to show, up to ${maxBacklogTop}, like \`!jira backlog OPS --top 10\``,
+        }
+      }
+      const getProjectRet = await getProject(
+        context,
+        messageContext,
+        fields[2],
+        true
+      )
+      if (getProjectRet.type === Errors.ReturnType.Error) {
+        Errors.reportErrorAndReplyChat(
+          context,
+          messageContext,
+          getProjectRet.error
+        )
+        return undefined
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Backlog,
+        project: getProjectRet.result.toUpperCase(),
+        top,
+      }
+    }
+    case 'board': {
+      if (
+        !fields[2] ||
+        (fields.length !== 3 && (fields[3] !== 'column' || fields.length !== 5))
+      ) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira board` needs the name or ID of a board and optionally a column, like `!jira board "Platform" column "In Progress"`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Board,
+        board: fields[2],
+        column: fields[4],
+      }
+    }
+    case 'bulk': {
+      if (
+        fields.length === 3 &&
+        (fields[2] === 'confirm' || fields[2] === 'cancel')
+      ) {
+        return {
+          context: messageContext,
+          type: BotMessageType.BulkConfirm,
+          confirmed: fields[2] === 'confirm',
+        }
+      }
+      const options: {[option: string]: string} = {}
+      const rest = []
+      for (let i = 2; i < fields.length; ++i) {
+        if (['--transition', '--comment'].includes(fields[i])) {
+          options[fields[i]] = fields[++i] || ''
+        } else {
+          rest.push(fields[i])
+        }
+      }
+      const jql = Utils.linebreaksToSpaces(rest.join(' '))
+      if (
+        !jql ||
+        Object.values(options).some(value => !value) ||
+        !Object.keys(options).length
+      ) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira bulk` needs a JQL query and what to do with the issues, like `!jira bulk "project = OPS AND labels = stale" --transition Done --comment "closing stale"`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Bulk,
+        jql,
+        transition: options['--transition'],
+        comment: options['--comment'],
       }
     }
     case 'auth': {
-      if (fields.length > 2) {
+      if (
+        fields.length > 3 ||
+        (fields.length === 3 && !['basic', 'token'].includes(fields[2]))
+      ) {
         return {
           context: messageContext,
           type: BotMessageType.Unknown,
-          error: 'The `auth` command takes no arguments.',
+          error:
+            'The `auth` command takes no arguments, or `basic` or `token` to send me your credentials in a private message instead.',
         }
       }
       return {
         context: messageContext,
         type: BotMessageType.Auth,
+        method:
+          fields[2] === 'basic'
+            ? AuthMethod.Basic
+            : fields[2] === 'token'
+            ? AuthMethod.Token
+            : AuthMethod.OAuth,
       }
     }
     case 'config': {
@@ -556,20 +1181,27 @@ export const parseMessage = async (
       }
       switch (configType) {
         case ConfigType.Team:
-          if (toSetName && toSetName !== 'jiraHost') {
+          if (toSetName && !['jiraHost', 'jiraServer'].includes(toSetName)) {
             return {
               context: messageContext,
               type: BotMessageType.Unknown,
               error: `unknown team config parameter ${toSetName}`,
             }
           }
-          if (!isValidDomain(toSetValue)) {
+          if (toSetName === 'jiraHost' && !isValidDomain(toSetValue)) {
             return {
               context: messageContext,
               type: BotMessageType.Unknown,
               error: `${toSetValue} is not a valid domain`,
             }
           }
+          if (toSetName === 'jiraServer' && !isValidServerURL(toSetValue)) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error: `${toSetValue} is not a valid URL, like https://jira.example.com`,
+            }
+          }
           return {
             context: messageContext,
             type: BotMessageType.Config,
@@ -580,13 +1212,49 @@ export const parseMessage = async (
             },
           }
         case ConfigType.Channel:
-          if (toSetName && !['defaultNewIssueProject'].includes(toSetName)) {
+          if (
+            toSetName &&
+            ![
+              'defaultNewIssueProject',
+              'digestTime',
+              'unfurl',
+              'unfurlProjects',
+            ].includes(toSetName)
+          ) {
             return {
               context: messageContext,
               type: BotMessageType.Unknown,
               error: `unknown config parameter ${toSetName}`,
             }
           }
+          if (toSetName === 'digestTime' && !Utils.parseTimeOfDay(toSetValue)) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error: `${toSetValue} is not a time of day, like 09:30 or "09:30 +02:00" in another timezone than UTC`,
+            }
+          }
+          if (toSetName === 'unfurl' && !['on', 'off'].includes(toSetValue)) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error: 'unfurl can be `on` or `off`',
+            }
+          }
+          if (
+            toSetName === 'unfurlProjects' &&
+            toSetValue !== 'all' &&
+            !toSetValue
+              .split(',')
+              .every(key => /^[A-Za-z][A-Za-z0-9_]+$/.test(key.trim()))
+          ) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error:
+                'unfurlProjects takes project keys separated by commas, like `FRONTEND,DESIGN`, or `all`',
+            }
+          }
           return {
             context: messageContext,
             type: BotMessageType.Config,
@@ -617,10 +1285,67 @@ export const parseMessage = async (
             allChannelsInTeam: false,
           }
         case 'subscribe':
+          if (fields[3] === 'jql') {
+            const jql = Utils.linebreaksToSpaces(fields.slice(4).join(' '))
+            if (!jql) {
+              return {
+                context: messageContext,
+                type: BotMessageType.Unknown,
+                error: `subscribe jql command requires a JQL query`,
+              }
+            }
+            return {
+              context: messageContext,
+              type: BotMessageType.Feed,
+              feedMessageType: FeedMessageType.Subscribe,
+              project: '',
+              withUpdates: false,
+              jql,
+            }
+          }
+          if (fields[3] === 'board') {
+            const boardID = Number.parseInt(fields[4])
+            if (!(boardID > 0) || `${boardID}` !== fields[4]) {
+              return {
+                context: messageContext,
+                type: BotMessageType.Unknown,
+                error: `subscribe board command requires a board ID, the number in the URL of the board`,
+              }
+            }
+            return {
+              context: messageContext,
+              type: BotMessageType.Feed,
+              feedMessageType: FeedMessageType.Subscribe,
+              project: '',
+              withUpdates: false,
+              boardID,
+              digest: fields[5] === 'digest',
+            }
+          }
+          if (fields[3] === 'epic') {
+            if (fields.length !== 5 || !Jira.looksLikeIssueKey(fields[4])) {
+              return {
+                context: messageContext,
+                type: BotMessageType.Unknown,
+                error:
+                  'subscribe epic command requires the key of an epic, like `!jira subscribe epic OPS-100`',
+              }
+            }
+            return {
+              context: messageContext,
+              type: BotMessageType.Feed,
+              feedMessageType: FeedMessageType.Subscribe,
+              project: '',
+              withUpdates: false,
+              epicKey: fields[4].toUpperCase(),
+            }
+          }
+          const versions = fields[3] === 'versions'
+          const serviceDesk = fields[3] === 'servicedesk'
           const getProjectRet = await getProject(
             context,
             messageContext,
-            fields[3],
+            versions || serviceDesk ? fields[4] : fields[3],
             true
           )
           if (getProjectRet.type === Errors.ReturnType.Error) {
@@ -640,6 +1365,26 @@ export const parseMessage = async (
               error: `subscribe command requires a project name`,
             }
           }
+          if (versions) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Feed,
+              feedMessageType: FeedMessageType.Subscribe,
+              project: '',
+              withUpdates: false,
+              versionsProject: project.toUpperCase(),
+            }
+          }
+          if (serviceDesk) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Feed,
+              feedMessageType: FeedMessageType.Subscribe,
+              project: '',
+              withUpdates: false,
+              serviceDeskProject: project.toUpperCase(),
+            }
+          }
 
           return {
             context: messageContext,
@@ -678,6 +1423,241 @@ export const parseMessage = async (
           }
       }
     }
+    case 'sla': {
+      switch (fields[2]) {
+        case undefined:
+        case 'list':
+          return {
+            context: messageContext,
+            type: BotMessageType.Sla,
+            slaMessageType: SlaMessageType.List,
+          }
+        case 'add': {
+          const thresholdMinutes = parseDuration(fields[4] || '')
+          if (fields.length !== 5 || !thresholdMinutes) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error:
+                'sla add command requires a priority and how long issues can stay unresolved, like `!jira sla add Highest 4h`',
+            }
+          }
+          return {
+            context: messageContext,
+            type: BotMessageType.Sla,
+            slaMessageType: SlaMessageType.Add,
+            priority: fields[3],
+            thresholdMinutes,
+          }
+        }
+        case 'remove': {
+          const ruleID = Number.parseInt(fields[3])
+          if (!(ruleID > 0) || `${ruleID}` !== fields[3]) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error:
+                'sla remove command requires a rule ID. Use `!jira sla list` to see the rules.',
+            }
+          }
+          return {
+            context: messageContext,
+            type: BotMessageType.Sla,
+            slaMessageType: SlaMessageType.Remove,
+            ruleID,
+          }
+        }
+        default:
+          return {
+            context: messageContext,
+            type: BotMessageType.Unknown,
+            error: `unknown sla command ${fields[2]}`,
+          }
+      }
+    }
+    case 'fields': {
+      if (!fields[2]) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira fields` needs a project, like `!jira fields OPS add customfield_10023 "Severity"`',
+        }
+      }
+      const getProjectRet = await getProject(
+        context,
+        messageContext,
+        fields[2],
+        true
+      )
+      if (getProjectRet.type === Errors.ReturnType.Error) {
+        Errors.reportErrorAndReplyChat(
+          context,
+          messageContext,
+          getProjectRet.error
+        )
+        return undefined
+      }
+      const project = getProjectRet.result.toUpperCase()
+      const fieldID = (fields[4] || '').toLowerCase()
+      const isFieldID = /^customfield_\d+$/.test(fieldID)
+      switch (fields[3]) {
+        case undefined:
+        case 'list':
+          return {
+            context: messageContext,
+            type: BotMessageType.Fields,
+            fieldsMessageType: FieldsMessageType.List,
+            project,
+          }
+        case 'add':
+          if (!isFieldID || fields.length !== 6 || !fields[5]) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error:
+                'fields add command requires a custom field ID and the name to show it with, like `!jira fields OPS add customfield_10023 "Severity"`',
+            }
+          }
+          return {
+            context: messageContext,
+            type: BotMessageType.Fields,
+            fieldsMessageType: FieldsMessageType.Add,
+            project,
+            fieldID,
+            name: fields[5],
+          }
+        case 'remove':
+          if (!isFieldID || fields.length !== 5) {
+            return {
+              context: messageContext,
+              type: BotMessageType.Unknown,
+              error:
+                'fields remove command requires a custom field ID, like `!jira fields OPS remove customfield_10023`',
+            }
+          }
+          return {
+            context: messageContext,
+            type: BotMessageType.Fields,
+            fieldsMessageType: FieldsMessageType.Remove,
+            project,
+            fieldID,
+          }
+        default:
+          return {
+            context: messageContext,
+            type: BotMessageType.Unknown,
+            error: `unknown fields command ${fields[3]}`,
+          }
+      }
+    }
+    case 'notify': {
+      if (fields.length === 2) {
+        return {context: messageContext, type: BotMessageType.Notify}
+      }
+      if (fields[2] === 'quiet') {
+        if (fields[3] === 'off' && fields.length === 4) {
+          return {
+            context: messageContext,
+            type: BotMessageType.Notify,
+            quietHours: null,
+          }
+        }
+        const [start, end] = (fields[3] || '').split('-')
+        const offset = fields.slice(4).join(' ')
+        const startTime = Utils.parseTimeOfDay(`${start} ${offset}`)
+        const endTime = Utils.parseTimeOfDay(`${end} ${offset}`)
+        if (!startTime || !endTime) {
+          return {
+            context: messageContext,
+            type: BotMessageType.Unknown,
+            error:
+              'notify quiet command requires a start and end time with an optional UTC offset, like `!jira notify quiet 22:00-08:00 +02:00`, or `!jira notify quiet off`',
+          }
+        }
+        return {
+          context: messageContext,
+          type: BotMessageType.Notify,
+          quietHours: {
+            start: Utils.formatTimeOfDay(startTime),
+            end: Utils.formatTimeOfDay(endTime),
+          },
+        }
+      }
+      const kind = fields.length === 4 ? fields[2] : undefined
+      const onOff = fields[fields.length - 1]
+      if (
+        fields.length > 4 ||
+        (kind !== undefined &&
+          !Configs.notificationKinds.includes(
+            kind as Configs.NotificationKind
+          )) ||
+        (onOff !== 'on' && onOff !== 'off')
+      ) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: `notify command is \`!jira notify [${Configs.notificationKinds.join(
+            '|'
+          )}] on|off\` or \`!jira notify quiet <HH:mm>-<HH:mm> [UTC offset]|off\``,
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Notify,
+        enable: {
+          kinds: kind
+            ? [kind as Configs.NotificationKind]
+            : Configs.notificationKinds,
+          enabled: onOff === 'on',
+        },
+      }
+    }
+    case 'epic': {
+      if (fields.length !== 3 || !Jira.looksLikeIssueKey(fields[2])) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error: '`!jira epic` needs the key of an epic, like `!jira epic OPS-100`',
+        }
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Epic,
+        epicKey: fields[2].toUpperCase(),
+      }
+    }
+    case 'version': {
+      const version = fields.slice(3).join(' ')
+      if (!fields[2] || !version) {
+        return {
+          context: messageContext,
+          type: BotMessageType.Unknown,
+          error:
+            '`!jira version` needs a project and a version, like `!jira version FRONTEND 2.4.0`',
+        }
+      }
+      const getProjectRet = await getProject(
+        context,
+        messageContext,
+        fields[2],
+        true
+      )
+      if (getProjectRet.type === Errors.ReturnType.Error) {
+        Errors.reportErrorAndReplyChat(
+          context,
+          messageContext,
+          getProjectRet.error
+        )
+        return undefined
+      }
+      return {
+        context: messageContext,
+        type: BotMessageType.Version,
+        project: getProjectRet.result.toUpperCase(),
+        version,
+      }
+    }
     case 'debug': {
       if (!context.botConfig._adminsSet.has(messageContext.senderUsername)) {
         return {
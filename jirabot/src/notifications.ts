@@ -0,0 +1,203 @@
+import util from 'util'
+import moment from 'moment'
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import * as Utils from './utils'
+import logger from './logger'
+
+const setTimeoutPromise = util.promisify(setTimeout)
+
+// comment excerpts sent with mentions
+const maxExcerptLength = 300
+
+// Teams subscribed to a project more than once get its webhook events once
+// per subscription, but users get one message.
+const recentNotifications = new Set<string>()
+
+const markNotified = (key: string) => {
+  recentNotifications.add(key)
+  setTimeoutPromise(1000 * 60 * 10 /* 10min */).then(() =>
+    recentNotifications.delete(key)
+  )
+}
+
+const minutesOfDay = (time: Utils.TimeOfDay): number =>
+  time.hour * 60 + time.minute - time.utcOffset
+
+// whether now is within quiet hours, which may span midnight
+export const inQuietHours = (
+  quietHours: Configs.UserNotificationPreferences['quietHours']
+): boolean => {
+  const start = quietHours && Utils.parseTimeOfDay(quietHours.start)
+  const end = quietHours && Utils.parseTimeOfDay(quietHours.end)
+  if (!start || !end) {
+    return false
+  }
+  const day = 60 * 24
+  const now = moment.utc()
+  const current = now.hours() * 60 + now.minutes()
+  const from = (minutesOfDay(start) + day) % day
+  const to = (minutesOfDay(end) + day) % day
+  return from <= to
+    ? current >= from && current < to
+    : current >= from || current < to
+}
+
+const notifyAccount = async (
+  context: Context,
+  teamname: string,
+  jiraAccountID: string,
+  kind: Configs.NotificationKind,
+  dedupKey: string,
+  body: string
+): Promise<void> => {
+  const key = `${teamname}:${jiraAccountID}:${kind}:${dedupKey}`
+  if (recentNotifications.has(key)) {
+    return
+  }
+  markNotified(key)
+
+  const accountUserRet = await context.configs.getJiraAccountUser(
+    teamname,
+    jiraAccountID
+  )
+  if (accountUserRet.type === Errors.ReturnType.Error) {
+    accountUserRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+      logger.warn({msg: 'notifyAccount', error: accountUserRet.error})
+    return
+  }
+  const {username} = accountUserRet.result.config
+  const preferencesRet = await context.configs.getUserNotificationPreferences(
+    teamname,
+    username
+  )
+  if (preferencesRet.type === Errors.ReturnType.Error) {
+    preferencesRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+      logger.warn({msg: 'notifyAccount', error: preferencesRet.error})
+    return
+  }
+  const preferences = preferencesRet.result.config
+  if (!preferences[kind] || inQuietHours(preferences.quietHours)) {
+    return
+  }
+
+  context.stathat.postCount(`notifications ${kind}`, 1)
+  try {
+    await context.bot.chat.send(
+      {
+        name: `${username},${context.bot.myInfo().username}`,
+        public: false,
+        topicType: 'chat',
+      },
+      {body: `${body}\n_(from ${teamname}; change with \`!jira notify\`)_`}
+    )
+  } catch (error) {
+    logger.warn({msg: 'notifyAccount', error})
+  }
+}
+
+// Jira Cloud mentions are [~accountid:ID], Server ones [~username].
+const findMentionedAccounts = (text: string): Array<string> => [
+  ...new Set(
+    (text.match(/\[~(?:accountid:)?[^\]\s]+\]/g) || []).map(mention =>
+      mention.replace(/^\[~(?:accountid:)?/, '').replace(/\]$/, '')
+    )
+  ),
+]
+
+// the account IDs in a changelog value of a user picker field, like
+// `[5b10a2844c20165700ede21g, 5b10ac8d82e05b22cc7d4ef5]`
+const parseAccountList = (value: any): Array<string> =>
+  typeof value === 'string'
+    ? value
+        .replace(/[[\]]/g, '')
+        .split(',')
+        .map(id => id.trim())
+        .filter(Boolean)
+    : []
+
+const getUserID = (user: any): string => user?.accountId || user?.name || ''
+
+// Sends private messages about a webhook event of a subscribed project to
+// the users it concerns.
+export const notifyFromWebhook = async (
+  context: Context,
+  teamname: string,
+  baseURL: string,
+  payload: any
+): Promise<void> => {
+  const issueKey = payload.issue?.key
+  if (typeof issueKey !== 'string') {
+    return
+  }
+  const summary = payload.issue.fields?.summary || ''
+  const url = `${baseURL}/browse/${issueKey}`
+  const dedupKey = `${payload.timestamp}:${issueKey}`
+
+  if (payload.webhookEvent === Jira.JiraSubscriptionEvents.CommentCreated) {
+    const comment = payload.comment
+    if (typeof comment?.body !== 'string') {
+      return
+    }
+    const authorID = getUserID(comment.author)
+    const author = comment.author?.displayName || 'Someone'
+    const excerpt =
+      comment.body.length > maxExcerptLength
+        ? comment.body.slice(0, maxExcerptLength) + '…'
+        : comment.body
+    for (const accountID of findMentionedAccounts(comment.body)) {
+      if (accountID === authorID) {
+        continue
+      }
+      await notifyAccount(
+        context,
+        teamname,
+        accountID,
+        'mentions',
+        dedupKey,
+        `:speech_balloon: ${author} mentioned you on *${issueKey}* ${summary}:\n> ${excerpt
+          .split('\n')
+          .join('\n> ')}\n${url}?focusedCommentId=${comment.id}`
+      )
+    }
+    return
+  }
+
+  if (payload.webhookEvent !== Jira.JiraSubscriptionEvents.IssueUpdated) {
+    return
+  }
+  const actorID = getUserID(payload.user)
+  const actor = payload.user?.displayName || 'Someone'
+  const items = Array.isArray(payload.changelog?.items)
+    ? payload.changelog.items
+    : []
+  for (const item of items) {
+    if (item.field === 'assignee' && item.to && item.to !== actorID) {
+      await notifyAccount(
+        context,
+        teamname,
+        item.to,
+        'assignments',
+        dedupKey,
+        `:bust_in_silhouette: ${actor} assigned *${issueKey}* ${summary} to you.\n${url}`
+      )
+    } else if (/approver/i.test(item.field || '')) {
+      const before = new Set(parseAccountList(item.from))
+      for (const accountID of parseAccountList(item.to)) {
+        if (before.has(accountID) || accountID === actorID) {
+          continue
+        }
+        await notifyAccount(
+          context,
+          teamname,
+          accountID,
+          'approvals',
+          dedupKey,
+          `:ballot_box_with_check: ${actor} is asking for your approval on *${issueKey}* ${summary}.\n${url}`
+        )
+      }
+    }
+  }
+}
@@ -0,0 +1,136 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import logger from './logger'
+
+// requests created between two polls beyond this many are not announced
+const maxPolledRequests = 50
+
+// The SLAs every service desk starts with. Their names are what JQL knows
+// them by.
+const breachedJql = (project: string) =>
+  `project = "${project}" AND resolution = Unresolved AND ` +
+  `("Time to first response" = breached() OR "Time to resolution" = breached()) ` +
+  `ORDER BY created ASC`
+
+const requestsJql = (project: string) =>
+  `project = "${project}" ORDER BY created DESC`
+
+type ServiceDesk = {
+  state: Configs.ServiceDeskSubscriptionState
+  requests: Array<Jira.Issue>
+  breached: Array<Jira.Issue>
+}
+
+export const getServiceDesk = async (
+  jira: Jira.JiraClientWrapper,
+  project: string
+): Promise<ServiceDesk> => {
+  const [requests, breached] = await Promise.all([
+    jira.search(requestsJql(project), maxPolledRequests),
+    jira.search(breachedJql(project), maxPolledRequests),
+  ])
+  return {
+    state: {
+      requests: requests.map(({key}) => key),
+      breached: breached.map(({key}) => key),
+    },
+    requests,
+    breached,
+  }
+}
+
+export const pollServiceDeskSubscription = async (
+  context: Context,
+  teamname: string,
+  subscriptionID: number,
+  subscription: Configs.TeamJiraSubscription
+): Promise<void> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    subscription.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({
+      msg: 'pollServiceDeskSubscription',
+      teamname,
+      error: jiraRet.error,
+    })
+    return
+  }
+  const jira = jiraRet.result
+
+  const stateRet = await context.configs.getServiceDeskSubscriptionState(
+    teamname,
+    subscriptionID
+  )
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({
+      msg: 'pollServiceDeskSubscription',
+      teamname,
+      error: stateRet.error,
+    })
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+
+  let current: ServiceDesk
+  try {
+    current = await getServiceDesk(jira, subscription.serviceDeskProject)
+  } catch (error) {
+    logger.warn({msg: 'pollServiceDeskSubscription', teamname, error})
+    return
+  }
+
+  const updateRet = await context.configs.updateServiceDeskSubscriptionState(
+    teamname,
+    subscriptionID,
+    oldState,
+    current.state
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it announces the changes.
+    logger.warn({
+      msg: 'pollServiceDeskSubscription',
+      teamname,
+      error: updateRet.error,
+    })
+    return
+  }
+  if (!oldState) {
+    return
+  }
+
+  const knownRequests = new Set(oldState.config.requests)
+  const knownBreached = new Set(oldState.config.breached)
+  // oldest first
+  for (const request of current.requests
+    .filter(({key}) => !knownRequests.has(key))
+    .reverse()) {
+    context.stathat.postCount('service desk requests', 1)
+    await context.bot.chat.send(subscription.conversationId, {
+      body: `:raising_hand: ${request.reporterJira ||
+        'Someone'} opened a request in ${request.project}: *${
+        request.key
+      }* ${request.summary}\n${request.url}`,
+    })
+  }
+  for (const request of current.breached.filter(
+    ({key}) => !knownBreached.has(key)
+  )) {
+    context.stathat.postCount('service desk breaches', 1)
+    await context.bot.chat.send(subscription.conversationId, {
+      body: `:rotating_light: *${request.key}* ${request.summary} breached its SLA (${
+        request.assigneeJira
+          ? `assigned to _${request.assigneeJira}_`
+          : 'not assigned'
+      })\n${request.url}`,
+    })
+  }
+}
@@ -0,0 +1,185 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import {statusToEmoji} from './emoji'
+import logger from './logger'
+import moment from 'moment'
+
+// Breaching issues beyond this many are not alerted about.
+export const maxBreachingIssues = 50
+
+const pollInterval = 5 * 60 * 1000 // 5min
+
+// Each level alerts again, with louder mentions: once the threshold is
+// passed, at twice the threshold, and at four times the threshold.
+const escalations = [
+  {factor: 1, mention: ''},
+  {factor: 2, mention: '@here '},
+  {factor: 4, mention: '@channel '},
+]
+
+export const formatDuration = (minutes: number): string =>
+  minutes % (60 * 24) === 0
+    ? `${minutes / 60 / 24}d`
+    : minutes % 60 === 0
+    ? `${minutes / 60}h`
+    : `${minutes}m`
+
+export const formatSlaRule = (rule: Configs.TeamSlaRule): string =>
+  `${rule.priority} issues unresolved for more than ${formatDuration(
+    rule.thresholdMinutes
+  )}`
+
+// The JQL of the issues breaching a rule, among the ones the channel is
+// subscribed to. Undefined if the channel has no subscription with issues.
+export const getSlaRuleJql = (
+  rule: Configs.TeamSlaRule,
+  subscriptions: Configs.TeamJiraSubscriptions
+): undefined | string => {
+  const jqls = [...subscriptions.values()]
+    .filter(
+      ({conversationId, boardID, jql}) =>
+        conversationId === rule.conversationId && !boardID && jql
+    )
+    .map(({jql}) => `(${jql})`)
+  if (!jqls.length) {
+    return undefined
+  }
+  return (
+    `(${jqls.join(' OR ')}) AND priority = "${rule.priority.replace(
+      /"/g,
+      ''
+    )}" AND resolution = Unresolved` +
+    ` AND created <= "-${rule.thresholdMinutes}m" ORDER BY created ASC`
+  )
+}
+
+const getLevel = (rule: Configs.TeamSlaRule, issue: Jira.Issue): number => {
+  const minutes = moment().diff(moment(issue.created), 'minutes')
+  return escalations.filter(
+    ({factor}) => minutes >= rule.thresholdMinutes * factor
+  ).length
+}
+
+const pollSlaRule = async (
+  context: Context,
+  teamname: string,
+  ruleID: number,
+  rule: Configs.TeamSlaRule,
+  subscriptions: Configs.TeamJiraSubscriptions
+): Promise<void> => {
+  const jql = getSlaRuleJql(rule, subscriptions)
+  if (!jql) {
+    return
+  }
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    rule.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({msg: 'pollSlaRule', teamname, error: jiraRet.error})
+    return
+  }
+  const jira = jiraRet.result
+
+  const stateRet = await context.configs.getSlaRuleState(teamname, ruleID)
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({msg: 'pollSlaRule', teamname, error: stateRet.error})
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+
+  let issues: Array<Jira.Issue>
+  try {
+    issues = await jira.search(jql, maxBreachingIssues)
+  } catch (error) {
+    logger.warn({msg: 'pollSlaRule', teamname, error})
+    return
+  }
+
+  const oldLevels = new Map(
+    (oldState?.config.alerts || []).map(({key, level}) => [key, level])
+  )
+  const breaches = issues.map(issue => {
+    const oldLevel = oldLevels.get(issue.key) || 0
+    return {issue, oldLevel, level: Math.max(getLevel(rule, issue), oldLevel)}
+  })
+  // Resolved issues are dropped, so they alert again if they are reopened.
+  const updateRet = await context.configs.updateSlaRuleState(
+    teamname,
+    ruleID,
+    oldState,
+    {alerts: breaches.map(({issue, level}) => ({key: issue.key, level}))}
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it sends the alerts.
+    logger.warn({msg: 'pollSlaRule', teamname, error: updateRet.error})
+    return
+  }
+  const escalated = breaches.filter(({level, oldLevel}) => level > oldLevel)
+  if (!escalated.length) {
+    return
+  }
+
+  const level = Math.max(...escalated.map(({level}) => level))
+  context.stathat.postCount('sla alerts', 1)
+  const lines = [
+    `${escalations[level - 1].mention}:rotating_light: ${
+      escalated.length
+    } ${formatSlaRule(rule)}:`,
+    ...escalated.map(
+      ({issue}) =>
+        `${statusToEmoji(issue.status)} *${issue.key}* ${
+          issue.summary
+        } - opened ${issue.createdTimeHumanized}${
+          issue.assigneeJira ? `, assigned to ${issue.assigneeJira}` : ''
+        } - ${issue.url}`
+    ),
+  ]
+  await context.bot.chat.send(rule.conversationId, {body: lines.join('\n')})
+}
+
+export const pollSlaRules = async (context: Context): Promise<void> => {
+  const indicesRet = await context.configs.listAllJiraSubscriptionIndices()
+  if (indicesRet.type !== Errors.ReturnType.Ok) {
+    logger.warn({msg: 'pollSlaRules', error: indicesRet.error})
+    return
+  }
+  const teamnames = new Set(indicesRet.result.map(index => index.teamname))
+
+  for (const teamname of teamnames) {
+    const getRulesRet = await context.configs.getTeamSlaRules(teamname)
+    if (getRulesRet.type !== Errors.ReturnType.Ok) {
+      getRulesRet.error.type !== Errors.ErrorType.KVStoreNotFound &&
+        logger.warn({msg: 'pollSlaRules', error: getRulesRet.error})
+      continue
+    }
+    const getSubRet = await context.configs.getTeamJiraSubscriptions(teamname)
+    if (getSubRet.type !== Errors.ReturnType.Ok) {
+      logger.warn({msg: 'pollSlaRules', error: getSubRet.error})
+      continue
+    }
+    for (const [ruleID, rule] of [...getRulesRet.result.config.entries()]) {
+      try {
+        await pollSlaRule(
+          context,
+          teamname,
+          ruleID,
+          rule,
+          getSubRet.result.config
+        )
+      } catch (error) {
+        logger.warn({msg: 'pollSlaRules', teamname, error})
+      }
+    }
+  }
+}
+
+export default (context: Context) =>
+  setInterval(() => pollSlaRules(context), pollInterval)
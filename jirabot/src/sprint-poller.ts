@@ -0,0 +1,182 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import {statusToEmoji} from './emoji'
+import logger from './logger'
+import moment from 'moment'
+
+// Issues beyond this many in a sprint are not tracked.
+export const maxSprintIssues = 200
+
+const issueToLine = (issue: Jira.Issue) =>
+  `${statusToEmoji(issue.status)} *${issue.key}* ${issue.summary} - ${
+    issue.url
+  }`
+
+const pluralize = (count: number, noun: string) =>
+  `${count} ${noun}${count !== 1 ? 's' : ''}`
+
+const sprintToState = (
+  sprint: Jira.Sprint,
+  issues: Array<Jira.SprintIssue>
+) => ({
+  id: sprint.id,
+  name: sprint.name,
+  issues: issues.map(({key}) => key),
+})
+
+// the active sprints of a board with their issues, as stored between polls
+export const getSprintState = async (
+  jira: Jira.JiraClientWrapper,
+  boardID: number,
+  boardName: string
+): Promise<Configs.SprintSubscriptionState> => {
+  const sprints = await jira.getActiveSprints(boardID)
+  const states = []
+  for (const sprint of sprints) {
+    const issues = await jira.getSprintIssues(sprint.id, maxSprintIssues)
+    states.push(sprintToState(sprint, issues))
+  }
+  return {boardName, sprints: states}
+}
+
+const formatStarted = (
+  boardName: string,
+  sprint: Jira.Sprint,
+  issues: Array<Jira.SprintIssue>
+): string =>
+  `:rocket: *${sprint.name}* started on board ${boardName} with ${pluralize(
+    issues.length,
+    'issue'
+  )}` +
+  (sprint.endDate
+    ? `, ending ${moment(sprint.endDate).format('ddd, MMM D')}`
+    : '') +
+  '.' +
+  (sprint.goal ? `\nGoal: ${sprint.goal}` : '')
+
+const formatClosed = (
+  boardName: string,
+  sprint: Jira.Sprint,
+  issues: Array<Jira.SprintIssue>
+): string => {
+  const done = issues.filter(({done}) => done)
+  const notDone = issues.filter(({done}) => !done)
+  const lines = [
+    `:checkered_flag: *${sprint.name}* closed on board ${boardName}: ${
+      done.length
+    } of ${pluralize(issues.length, 'issue')} completed${
+      issues.length
+        ? ` (${Math.round((done.length / issues.length) * 100)}%)`
+        : ''
+    }.`,
+  ]
+  if (notDone.length) {
+    lines.push('*Not completed:*', ...notDone.map(issueToLine))
+  }
+  return lines.join('\n')
+}
+
+const formatAdded = (
+  boardName: string,
+  sprint: Jira.Sprint,
+  added: Array<Jira.SprintIssue>
+): string =>
+  [
+    `:heavy_plus_sign: ${pluralize(added.length, 'issue')} added to *${
+      sprint.name
+    }* on board ${boardName}:`,
+    ...added.map(issueToLine),
+  ].join('\n')
+
+export const pollBoardSubscription = async (
+  context: Context,
+  teamname: string,
+  subscriptionID: number,
+  subscription: Configs.TeamJiraSubscription
+): Promise<void> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    subscription.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({msg: 'pollBoardSubscription', teamname, error: jiraRet.error})
+    return
+  }
+  const jira = jiraRet.result
+
+  const stateRet = await context.configs.getSprintSubscriptionState(
+    teamname,
+    subscriptionID
+  )
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({msg: 'pollBoardSubscription', teamname, error: stateRet.error})
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+  const boardName = oldState?.config.boardName || `${subscription.boardID}`
+
+  const messages: Array<string> = []
+  const newSprints = []
+  try {
+    const active = await jira.getActiveSprints(subscription.boardID)
+    for (const sprint of active) {
+      const issues = await jira.getSprintIssues(sprint.id, maxSprintIssues)
+      newSprints.push(sprintToState(sprint, issues))
+      if (!oldState) {
+        continue
+      }
+      const old = oldState.config.sprints.find(({id}) => id === sprint.id)
+      if (!old) {
+        messages.push(formatStarted(boardName, sprint, issues))
+        continue
+      }
+      const oldKeys = new Set(old.issues)
+      const added = issues.filter(({key}) => !oldKeys.has(key))
+      if (added.length) {
+        messages.push(formatAdded(boardName, sprint, added))
+      }
+    }
+    for (const old of oldState?.config.sprints || []) {
+      if (active.some(({id}) => id === old.id)) {
+        continue
+      }
+      const sprint = await jira.getSprint(old.id)
+      // sprints going back to future ones are not announced
+      if (sprint.state === 'closed') {
+        const issues = await jira.getSprintIssues(sprint.id, maxSprintIssues)
+        messages.push(formatClosed(boardName, sprint, issues))
+      }
+    }
+  } catch (error) {
+    logger.warn({msg: 'pollBoardSubscription', teamname, error})
+    return
+  }
+
+  const updateRet = await context.configs.updateSprintSubscriptionState(
+    teamname,
+    subscriptionID,
+    oldState,
+    {boardName, sprints: newSprints}
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it announces the changes.
+    logger.warn({
+      msg: 'pollBoardSubscription',
+      teamname,
+      error: updateRet.error,
+    })
+    return
+  }
+
+  for (const body of messages) {
+    context.stathat.postCount('sprint announcements', 1)
+    await context.bot.chat.send(subscription.conversationId, {body})
+  }
+}
@@ -1,4 +1,6 @@
 import * as Utils from './utils'
+import * as Message from './message'
+import {parseCredentials} from './cmd-auth'
 
 test('split2', () => {
   ;[
@@ -12,3 +14,32 @@ test('split2', () => {
     {input: `a\nb\r\nc\rd`, output: ['a', 'b', 'c', 'd']},
   ].forEach(({input, output}) => expect(Utils.split2(input)).toEqual(output))
 })
+
+test('parseCredentials', () => {
+  const {Basic, Token} = Message.AuthMethod
+  const token = personalAccessToken => ({
+    accessToken: '',
+    tokenSecret: '',
+    personalAccessToken,
+  })
+  const basic = (username, password) => ({
+    accessToken: '',
+    tokenSecret: '',
+    basicAuth: {username, password},
+  })
+  ;[
+    {method: Token, input: 'abc123', output: token('abc123')},
+    {method: Token, input: '', output: undefined},
+    {method: Token, input: 'abc 123', output: undefined},
+    {
+      method: Basic,
+      input: 'alice@example.com  my secret pass ',
+      output: basic('alice@example.com', 'my secret pass'),
+    },
+    {method: Basic, input: 'alice@example.com', output: undefined},
+    {method: Basic, input: 'alice@example.com ', output: undefined},
+    {method: Basic, input: ' password', output: undefined},
+  ].forEach(({method, input, output}) =>
+    expect(parseCredentials(method, input)).toEqual(output)
+  )
+})
@@ -109,6 +109,26 @@ export const getJiraAccountID = async (
   }
 }
 
+// whether a user is an owner or admin of the team
+export const isTeamAdmin = async (
+  context: Context,
+  kbTeamname: string,
+  kbUsername: string
+): Promise<Errors.ResultOrError<boolean, Errors.UnknownError>> => {
+  try {
+    const {members} = await context.bot.team.listTeamMemberships({
+      team: kbTeamname,
+    })
+    return Errors.makeResult(
+      [...(members.owners || []), ...(members.admins || [])].some(
+        ({username}) => username === kbUsername
+      )
+    )
+  } catch (err) {
+    return Errors.makeUnknownError(err)
+  }
+}
+
 export const randomString = (prefix: string): Promise<string> =>
   new Promise<string>((resolve, reject) =>
     crypto.randomBytes(16, (err, buf) => {
@@ -118,3 +138,46 @@ export const randomString = (prefix: string): Promise<string> =>
 
 export const linebreaksToSpaces = (str: string): string =>
   str.replace(/\r\n|\r|\n/g, ' ')
+
+export type TimeOfDay = {
+  hour: number
+  minute: number
+  utcOffset: number // minutes
+}
+
+// parses `HH:mm` (UTC) or `HH:mm +hh:mm`; undefined if invalid
+export const parseTimeOfDay = (str: string): undefined | TimeOfDay => {
+  const match = str
+    .trim()
+    .match(/^(\d{1,2}):(\d{2})(?:\s*(?:UTC)?\s*([+-])(\d{1,2}):?(\d{2})?)?$/i)
+  if (!match) {
+    return undefined
+  }
+  const hour = Number.parseInt(match[1])
+  const minute = Number.parseInt(match[2])
+  const offsetHours = match[3] ? Number.parseInt(match[4]) : 0
+  const offsetMinutes = match[5] ? Number.parseInt(match[5]) : 0
+  if (hour > 23 || minute > 59 || offsetHours > 14 || offsetMinutes > 59) {
+    return undefined
+  }
+  return {
+    hour,
+    minute,
+    utcOffset:
+      (match[3] === '-' ? -1 : 1) * (offsetHours * 60 + offsetMinutes),
+  }
+}
+
+const pad = (n: number) => `${n}`.padStart(2, '0')
+
+export const formatTimeOfDay = ({
+  hour,
+  minute,
+  utcOffset,
+}: TimeOfDay): string =>
+  `${pad(hour)}:${pad(minute)} ` +
+  (utcOffset
+    ? `${utcOffset < 0 ? '-' : '+'}${pad(
+        Math.floor(Math.abs(utcOffset) / 60)
+      )}:${pad(Math.abs(utcOffset) % 60)}`
+    : 'UTC')
@@ -0,0 +1,128 @@
+import {Context} from './context'
+import * as Configs from './configs'
+import * as Errors from './errors'
+import * as Jira from './jira'
+import logger from './logger'
+import moment from 'moment'
+
+const formatDate = (date: string) =>
+  date ? moment(date, 'YYYY-MM-DD').format('ddd, MMM D YYYY') : 'no date'
+
+export const versionsToState = (
+  versions: Array<Jira.Version>
+): Configs.VersionSubscriptionState => ({
+  versions: versions.map(({id, name, released, releaseDate}) => ({
+    id,
+    name,
+    released,
+    releaseDate,
+  })),
+})
+
+const formatChanges = (
+  project: string,
+  oldState: Configs.VersionSubscriptionState,
+  versions: Array<Jira.Version>
+): Array<string> => {
+  const messages = []
+  for (const version of versions) {
+    const old = oldState.versions.find(({id}) => id === version.id)
+    if (!old) {
+      continue
+    }
+    if (version.released && !old.released) {
+      messages.push(
+        `:package: ${project} *${version.name}* was released${
+          version.releaseDate ? ` on ${formatDate(version.releaseDate)}` : ''
+        }. See what's in it with \`!jira version ${project} "${
+          version.name
+        }"\`` + (version.description ? `\n> ${version.description}` : '')
+      )
+    } else if (!version.released && version.releaseDate !== old.releaseDate) {
+      messages.push(
+        `:calendar: ${project} *${
+          version.name
+        }* release date changed from ~${formatDate(
+          old.releaseDate
+        )}~ to *${formatDate(version.releaseDate)}*.`
+      )
+    }
+  }
+  return messages
+}
+
+export const pollVersionSubscription = async (
+  context: Context,
+  teamname: string,
+  subscriptionID: number,
+  subscription: Configs.TeamJiraSubscription
+): Promise<void> => {
+  const jiraRet = await context.getJiraFromTeamnameAndUsername(
+    context,
+    teamname,
+    subscription.pollingUsername
+  )
+  if (jiraRet.type === Errors.ReturnType.Error) {
+    logger.warn({
+      msg: 'pollVersionSubscription',
+      teamname,
+      error: jiraRet.error,
+    })
+    return
+  }
+  const jira = jiraRet.result
+
+  const stateRet = await context.configs.getVersionSubscriptionState(
+    teamname,
+    subscriptionID
+  )
+  if (
+    stateRet.type === Errors.ReturnType.Error &&
+    stateRet.error.type !== Errors.ErrorType.KVStoreNotFound
+  ) {
+    logger.warn({
+      msg: 'pollVersionSubscription',
+      teamname,
+      error: stateRet.error,
+    })
+    return
+  }
+  const oldState =
+    stateRet.type === Errors.ReturnType.Ok ? stateRet.result : undefined
+
+  let versions: Array<Jira.Version>
+  try {
+    versions = await jira.getProjectVersions(subscription.versionsProject)
+  } catch (error) {
+    logger.warn({msg: 'pollVersionSubscription', teamname, error})
+    return
+  }
+
+  const updateRet = await context.configs.updateVersionSubscriptionState(
+    teamname,
+    subscriptionID,
+    oldState,
+    versionsToState(versions)
+  )
+  if (updateRet.type === Errors.ReturnType.Error) {
+    // Another poll got there first; it announces the changes.
+    logger.warn({
+      msg: 'pollVersionSubscription',
+      teamname,
+      error: updateRet.error,
+    })
+    return
+  }
+  if (!oldState) {
+    return
+  }
+
+  for (const body of formatChanges(
+    subscription.versionsProject,
+    oldState.config,
+    versions
+  )) {
+    context.stathat.postCount('version announcements', 1)
+    await context.bot.chat.send(subscription.conversationId, {body})
+  }
+}
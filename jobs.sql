@@ -0,0 +1,25 @@
+CREATE TABLE `jobs` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `queue` varchar(64) NOT NULL,
+  `kind` varchar(64) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `attempts` int NOT NULL,
+  `max_attempts` int NOT NULL,
+  `run_at` datetime(6) NOT NULL,
+  `last_error` text NOT NULL,
+  `ctime` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `queue_run_at` (`queue`, `run_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `dead_jobs` (
+  `id` bigint NOT NULL,
+  `queue` varchar(64) NOT NULL,
+  `kind` varchar(64) NOT NULL,
+  `payload` mediumtext NOT NULL,
+  `attempts` int NOT NULL,
+  `last_error` text NOT NULL,
+  `failed_at` datetime(6) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `queue_failed_at` (`queue`, `failed_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,6 @@
+CREATE TABLE `leases` (
+  `name` varchar(64) NOT NULL,
+  `holder` varchar(32) NOT NULL,
+  `expire_time` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"database/sql"
 	"flag"
 	"fmt"
 	"os"
@@ -11,7 +10,6 @@ import (
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
-	"golang.org/x/sync/errgroup"
 )
 
 type BotServer struct {
@@ -83,15 +81,18 @@ Examples:%s
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	s.Configure(&s.opts)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := macrobot.NewDB(sdb)
 
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
@@ -103,12 +104,17 @@ func (s *BotServer) Go() (err error) {
 	stats = stats.SetPrefix(s.Name())
 	handler := macrobot.NewHandler(stats, s.kbc, debugConfig, db)
 	httpSrv := macrobot.NewHTTPSrv(stats, debugConfig)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -4,16 +4,100 @@ CREATE TABLE `oauth_state` (
   `conv_id` char(64) NOT NULL,
   `msg_id` char(64) NOT NULL,
   `is_complete` boolean NOT NULL DEFAULT 0,
-  PRIMARY KEY (`state`)
+  `code_verifier` varchar(128) NOT NULL DEFAULT '',
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`state`),
+  KEY `ctime` (`ctime`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 CREATE TABLE `oauth` (
   `identifier` varchar(128) NOT NULL,
   `ctime` datetime NOT NULL,
   `mtime` datetime NOT NULL,
-  `access_token` varchar(256) NOT NULL,
+  `access_token` varchar(2048) NOT NULL,
   `token_type` varchar(64) NOT NULL,
-  `refresh_token` varchar(256) NOT NULL,
+  `refresh_token` varchar(2048) NOT NULL,
   `expiry` datetime NOT NULL,
   PRIMARY KEY (`identifier`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `recurring_meetings` (
+  `conv_id` char(64) NOT NULL,
+  `name` varchar(128) NOT NULL,
+  `days` varchar(32) NOT NULL,
+  `hour` tinyint NOT NULL,
+  `minute` tinyint NOT NULL,
+  `timezone` varchar(64) NOT NULL,
+  `link` varchar(256) NOT NULL,
+  `creator` varchar(128) NOT NULL,
+  `next_run` datetime NOT NULL,
+  PRIMARY KEY (`conv_id`, `name`),
+  KEY `next_run` (`next_run`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `recurring_meeting_skips` (
+  `conv_id` char(64) NOT NULL,
+  `name` varchar(128) NOT NULL,
+  `day` char(10) NOT NULL,
+  PRIMARY KEY (`conv_id`, `name`, `day`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `meetings` (
+  `conv_id` char(64) NOT NULL,
+  `title` varchar(256) NOT NULL,
+  `link` varchar(256) NOT NULL,
+  `started` datetime NOT NULL,
+  `timebox_end` datetime DEFAULT NULL,
+  `timebox_warned` boolean NOT NULL DEFAULT 0,
+  PRIMARY KEY (`conv_id`),
+  KEY `timebox_end` (`timebox_end`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `meeting_notes` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `conv_id` char(64) NOT NULL,
+  `author` varchar(128) NOT NULL,
+  `note` text NOT NULL,
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `conv_id` (`conv_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `scheduled_meetings` (
+  `conv_id` char(64) NOT NULL,
+  `event_id` varchar(128) NOT NULL,
+  `token_identifier` varchar(128) NOT NULL,
+  `title` varchar(256) NOT NULL,
+  `start` datetime NOT NULL,
+  `end` datetime NOT NULL,
+  `timezone` varchar(64) NOT NULL,
+  `reminded` boolean NOT NULL DEFAULT 0,
+  PRIMARY KEY (`conv_id`, `event_id`),
+  KEY `start` (`start`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `office_hours` (
+  `conv_id` char(64) NOT NULL,
+  `host` varchar(128) NOT NULL,
+  `link` varchar(256) NOT NULL,
+  `closes` datetime NOT NULL,
+  `current` varchar(128) NOT NULL DEFAULT '',
+  PRIMARY KEY (`conv_id`),
+  KEY `closes` (`closes`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `office_hours_queue` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `conv_id` char(64) NOT NULL,
+  `username` varchar(128) NOT NULL,
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `conv_username` (`conv_id`, `username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE `leases` (
+  `name` varchar(64) NOT NULL,
+  `holder` varchar(32) NOT NULL,
+  `expire_time` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"golang.org/x/oauth2"
 
@@ -16,13 +13,17 @@ import (
 	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/meetbot/meetbot"
 	"golang.org/x/oauth2/google"
-	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/docs/v1"
 )
 
 type Options struct {
 	*base.Options
-	KBFSRoot string
+	KBFSRoot              string
+	HTTPPrefix            string
+	ZoomOAuthClientID     string
+	ZoomOAuthClientSecret string
+	JitsiURL              string
 }
 
 func NewOptions() *Options {
@@ -48,7 +49,22 @@ func NewBotServer(opts Options) *BotServer {
 	}
 }
 
+const back = "`"
+const backs = "```"
+
 func (s *BotServer) makeAdvertisement() kbchat.Advertisement {
+	scheduleDesc := fmt.Sprintf(`Creates an event with a Google Meet in your calendar and invites the attendees who linked their Google account with %s!meet link google <email>%s.
+The day is today, tomorrow, a weekday or a date like 2020-04-30, the time is like 2pm or 14:30 in your calendar's timezone, and the meeting lasts 30 minutes unless a duration like 45m or 1h30m is given.
+Examples:%s
+!meet schedule "Design review" tomorrow 2pm 45m @alice @bob
+!meet schedule "1:1" friday 10:30am @alice%s`,
+		back, back, backs, backs)
+	recurringDesc := fmt.Sprintf(`Posts the link to a standing meeting in this conversation when the meeting starts. The days are daily, weekdays, weekends or a list like mon,wed,fri and the time is in your calendar's timezone.
+Skip the next meeting with %s!meet recurring skip <name>%s, every meeting on a day with %s!meet recurring holiday <yyyy-mm-dd>%s, and see them all with %s!meet recurring list%s.
+Examples:%s
+!meet recurring standup weekdays 9:30am
+!meet recurring retro fri 4pm%s`,
+		back, back, back, back, back, back, backs, backs)
 	return kbchat.Advertisement{
 		Alias: "Google Meet",
 		Advertisements: []chat1.AdvertiseCommandAPIParam{
@@ -57,7 +73,69 @@ func (s *BotServer) makeAdvertisement() kbchat.Advertisement {
 				Commands: []chat1.UserBotCommandInput{
 					{
 						Name:        "meet",
-						Description: "New Google Meet",
+						Description: "New meeting, on Google Meet unless changed with !meet provider set",
+					},
+					{
+						Name:        "meet schedule",
+						Description: "Schedule a Google Meet and invite attendees",
+						Usage:       `"<title>" [day] <time> [duration] [@attendee...]`,
+						ExtendedDescription: &chat1.UserBotExtendedDescription{
+							Title:       `*!meet schedule* "<title>" [day] <time> [duration] [@attendee...]`,
+							DesktopBody: scheduleDesc,
+							MobileBody:  scheduleDesc,
+						},
+					},
+					{
+						Name:        "meet rsvp",
+						Description: "Show who is coming to the meetings scheduled here",
+					},
+					{
+						Name:        "meet recurring",
+						Description: "Post the link to a standing meeting at the same time every day or week",
+						Usage:       "<name> <days> <time>",
+						ExtendedDescription: &chat1.UserBotExtendedDescription{
+							Title:       `*!meet recurring* <name> <days> <time>`,
+							DesktopBody: recurringDesc,
+							MobileBody:  recurringDesc,
+						},
+					},
+					{
+						Name:        "meet now",
+						Description: "Start a meeting with a pinned agenda and an optional timebox",
+						Usage:       `[--timebox <duration>] "<title>" ["<agenda item>"...]`,
+					},
+					{
+						Name:        "meet note",
+						Description: "Add a note to the meeting going on here",
+						Usage:       "<text>",
+					},
+					{
+						Name:        "meet end",
+						Description: "End the meeting going on here and post its notes",
+					},
+					{
+						Name:        "meet officehours open",
+						Description: "Hold office hours, seeing people who get in line one at a time",
+						Usage:       "<duration>",
+					},
+					{
+						Name:        "meet queue",
+						Description: "Get in line for the office hours held here",
+					},
+					{
+						Name:        "meet defaults set",
+						Description: "Change the provider, duration, calendar event and invitees of meetings here",
+						Usage:       "<provider|duration|calendar|invitees> <value...>",
+					},
+					{
+						Name:        "meet provider set",
+						Description: "Choose whether meetings here are on Google Meet, Zoom or Jitsi",
+						Usage:       "<google|zoom|jitsi>",
+					},
+					{
+						Name:        "meet link",
+						Description: "Link your Google account so you're invited to scheduled meetings",
+						Usage:       "google <email>",
 					},
 					base.GetFeedbackCommandAdvertisement(s.kbc.GetUsername()),
 				},
@@ -68,20 +146,17 @@ func (s *BotServer) makeAdvertisement() kbchat.Advertisement {
 
 func (s *BotServer) getOAuthConfig() (config *oauth2.Config, err error) {
 	defer s.Trace(&err, "getOAuthConfig")()
-	if len(s.opts.KBFSRoot) == 0 {
-		return nil, fmt.Errorf("BOT_KBFS_ROOT must be specified\n")
+	secrets, err := s.opts.SecretsProvider(s.opts.KBFSRoot)
+	if err != nil {
+		return nil, err
 	}
-	configPath := filepath.Join(s.opts.KBFSRoot, "credentials.json")
-	cmd := s.opts.Command("fs", "read", configPath)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	fmt.Printf("Running `keybase fs read` on %q and waiting for it to finish...\n", configPath)
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("could not read credentials.json: %v", err)
+	out, err := secrets.GetSecret("credentials.json")
+	if err != nil {
+		return nil, err
 	}
 
 	// If modifying these scopes, drop the saved tokens in the db
-	config, err = google.ConfigFromJSON(out.Bytes(), calendar.CalendarEventsScope)
+	config, err = google.ConfigFromJSON(out, calendar.CalendarEventsScope, docs.DocumentsScope)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
 	}
@@ -90,22 +165,38 @@ func (s *BotServer) getOAuthConfig() (config *oauth2.Config, err error) {
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return fmt.Errorf("failed to start keybase %v", err)
 	}
+	s.Configure(s.opts.Options)
 
 	config, err := s.getOAuthConfig()
 	if err != nil {
 		return fmt.Errorf("failed to get config %v", err)
 	}
 
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
-	db := base.NewOAuthDB(sdb)
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
+	db := meetbot.NewDB(sdb)
+	cipher, err := s.opts.FieldCipher()
+	if err != nil {
+		return fmt.Errorf("failed to configure encryption %v", err)
+	}
+	db.SetCipher(cipher)
+	if s.opts.EncryptExisting {
+		updated, err := db.EncryptExistingTokens()
+		if err != nil {
+			return fmt.Errorf("failed to encrypt existing tokens %v", err)
+		}
+		s.Debug("encrypted %d existing tokens", updated)
+		return nil
+	}
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
 	stats, err := base.NewStatsRegistry(debugConfig, s.opts.StathatEZKey)
 	if err != nil {
@@ -113,14 +204,54 @@ func (s *BotServer) Go() (err error) {
 		return err
 	}
 	stats = stats.SetPrefix(s.Name())
-	handler := meetbot.NewHandler(stats, s.kbc, debugConfig, db, config)
-	httpSrv := meetbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, handler, config)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	identities := base.NewIdentityStore(debugConfig, db.DB)
+	settings := base.NewSettingsStore(db.DB)
+	handler := meetbot.NewHandler(stats, s.kbc, debugConfig, db, identities, settings, config)
+	var zoomConfig *oauth2.Config
+	if s.opts.ZoomOAuthClientID != "" && s.opts.ZoomOAuthClientSecret != "" {
+		zoomConfig = meetbot.NewZoomOAuthConfig(s.opts.ZoomOAuthClientID, s.opts.ZoomOAuthClientSecret,
+			s.opts.HTTPPrefix)
+		handler.SetZoom(zoomConfig)
+	}
+	if s.opts.JitsiURL != "" {
+		handler.SetJitsi(s.opts.JitsiURL)
+	}
+	recurringScheduler := meetbot.NewRecurringScheduler(stats, debugConfig, db)
+	attendanceReporter := meetbot.NewAttendanceReporter(stats, s.kbc, debugConfig, db, identities, config)
+	timeboxReminder := meetbot.NewTimeboxReminder(stats, debugConfig, db)
+	officeHoursCloser := meetbot.NewOfficeHoursCloser(stats, debugConfig, db)
+	leader := base.NewLeaderElector(stats, debugConfig, db.DB, s.Name())
+	s.RegisterAdminCommands(leader.AdminCommands()...)
+	scheduler := base.NewScheduler(stats, debugConfig)
+	scheduler.SetLeaderElector(leader)
+	if err := scheduler.Add(recurringScheduler.Task()); err != nil {
+		return err
+	}
+	if err := scheduler.Add(attendanceReporter.Task()); err != nil {
+		return err
+	}
+	if err := scheduler.Add(timeboxReminder.Task()); err != nil {
+		return err
+	}
+	if err := scheduler.Add(officeHoursCloser.Task()); err != nil {
+		return err
+	}
+	s.RegisterAdminCommands(scheduler.AdminCommands()...)
+	httpSrv := meetbot.NewHTTPSrv(stats, s.kbc, debugConfig, db.OAuthDB, handler, config, zoomConfig)
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(scheduler.Run, scheduler)
+	// registered after the scheduler so the lease is released once it stops
+	lc.Go(leader.Run, leader)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -136,6 +267,10 @@ func mainInner() int {
 	opts := NewOptions()
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	fs.StringVar(&opts.KBFSRoot, "kbfs-root", os.Getenv("BOT_KBFS_ROOT"), "root path to bot's KBFS backed config")
+	fs.StringVar(&opts.HTTPPrefix, "http-prefix", os.Getenv("BOT_HTTP_PREFIX"), "address of bots HTTP server for Zoom OAuth")
+	fs.StringVar(&opts.ZoomOAuthClientID, "zoom-client-id", os.Getenv("BOT_ZOOM_CLIENT_ID"), "Zoom OAuth2 client ID, enables Zoom meetings")
+	fs.StringVar(&opts.ZoomOAuthClientSecret, "zoom-client-secret", os.Getenv("BOT_ZOOM_CLIENT_SECRET"), "Zoom OAuth2 client secret")
+	fs.StringVar(&opts.JitsiURL, "jitsi-url", os.Getenv("BOT_JITSI_URL"), "address of a Jitsi server, e.g. https://meet.jit.si, enables Jitsi meetings")
 	if err := opts.Parse(fs, os.Args); err != nil {
 		fmt.Printf("Unable to parse options: %v\n", err)
 		return 3
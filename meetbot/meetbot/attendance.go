@@ -0,0 +1,251 @@
+package meetbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// how long before a scheduled meeting its attendance is reported and the
+// invitees who haven't responded are reminded
+const attendanceReminderLead = time.Hour
+
+// Attendance is who is coming to a scheduled meeting, by their Keybase
+// username if they linked their Google account and email otherwise.
+type Attendance struct {
+	Accepted   []string
+	Tentative  []string
+	Declined   []string
+	NoResponse []string
+	// Pending are the Keybase usernames of the invitees who haven't
+	// responded, which can be reminded.
+	Pending []string
+}
+
+// GetAttendance sorts the invitees of an event by their response. usernames
+// maps the emails of the invitees who linked their Google account to their
+// Keybase username. The organizer and rooms aren't invitees.
+func GetAttendance(attendees []*calendar.EventAttendee, usernames map[string]string) Attendance {
+	var attendance Attendance
+	for _, attendee := range attendees {
+		if attendee.Organizer || attendee.Resource {
+			continue
+		}
+		name := attendee.Email
+		username, ok := usernames[strings.ToLower(attendee.Email)]
+		if ok {
+			name = "@" + username
+		}
+		switch attendee.ResponseStatus {
+		case "accepted":
+			attendance.Accepted = append(attendance.Accepted, name)
+		case "tentative":
+			attendance.Tentative = append(attendance.Tentative, name)
+		case "declined":
+			attendance.Declined = append(attendance.Declined, name)
+		default:
+			attendance.NoResponse = append(attendance.NoResponse, name)
+			if ok {
+				attendance.Pending = append(attendance.Pending, username)
+			}
+		}
+	}
+	return attendance
+}
+
+// FormatAttendance lists who responded what, skipping the responses nobody
+// gave.
+func FormatAttendance(attendance Attendance) string {
+	var lines []string
+	for _, response := range []struct {
+		title string
+		names []string
+	}{
+		{"Accepted", attendance.Accepted},
+		{"Maybe", attendance.Tentative},
+		{"Declined", attendance.Declined},
+		{"No response", attendance.NoResponse},
+	} {
+		if len(response.names) > 0 {
+			lines = append(lines, fmt.Sprintf("• %s: %s", response.title, strings.Join(response.names, ", ")))
+		}
+	}
+	if len(lines) == 0 {
+		return "• Nobody is invited"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatScheduledMeetingTime(meeting ScheduledMeeting) string {
+	loc, err := time.LoadLocation(meeting.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return FormatMeetingTime(meeting.Start.In(loc), meeting.End.Sub(meeting.Start))
+}
+
+// errEventGone is returned for events which were deleted or cancelled since
+// they were scheduled.
+var errEventGone = errors.New("event is gone")
+
+// getAttendance looks up the invitees of a scheduled meeting with the token
+// it was scheduled with.
+func getAttendance(db *DB, identities *base.IdentityStore, config *oauth2.Config,
+	meeting ScheduledMeeting) (*Attendance, error) {
+	token, err := db.GetToken(meeting.TokenIdentifier)
+	if err != nil {
+		return nil, err
+	} else if token == nil {
+		return nil, fmt.Errorf("no token for %q", meeting.TokenIdentifier)
+	}
+	client := base.NewPersistingClient(config, token, base.StorageTokenSaver(db, meeting.TokenIdentifier))
+	srv, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+	event, err := srv.Events.Get("primary", meeting.EventID).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok &&
+		(apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone) {
+		return nil, errEventGone
+	} else if err != nil {
+		return nil, err
+	} else if event.Status == "cancelled" {
+		return nil, errEventGone
+	}
+
+	usernames := make(map[string]string)
+	for _, attendee := range event.Attendees {
+		identity, err := identities.KeybaseUser(base.GoogleIdentity, attendee.Email)
+		if err != nil {
+			return nil, err
+		} else if identity != nil {
+			usernames[strings.ToLower(attendee.Email)] = identity.KeybaseUsername
+		}
+	}
+	attendance := GetAttendance(event.Attendees, usernames)
+	return &attendance, nil
+}
+
+// AttendanceReporter reports who is coming to scheduled meetings an hour
+// before they start, and reminds the invitees who haven't responded.
+type AttendanceReporter struct {
+	*base.DebugOutput
+
+	stats      *base.StatsRegistry
+	kbc        *kbchat.API
+	db         *DB
+	identities *base.IdentityStore
+	config     *oauth2.Config
+}
+
+func NewAttendanceReporter(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig,
+	db *DB, identities *base.IdentityStore, config *oauth2.Config) *AttendanceReporter {
+	return &AttendanceReporter{
+		DebugOutput: base.NewDebugOutput("AttendanceReporter", debugConfig),
+		stats:       stats.SetPrefix("AttendanceReporter"),
+		kbc:         kbc,
+		db:          db,
+		identities:  identities,
+		config:      config,
+	}
+}
+
+// Task checks for meetings to report every 5 minutes.
+func (r *AttendanceReporter) Task() base.Task {
+	return base.Task{
+		Name:     "scheduled-meeting-attendance",
+		Schedule: "*/5 * * * *",
+		Run:      r.reportDueMeetings,
+	}
+}
+
+func (r *AttendanceReporter) reportDueMeetings(ctx context.Context) error {
+	if err := r.db.DeleteStartedScheduledMeetings(); err != nil {
+		return fmt.Errorf("error deleting started meetings: %s", err)
+	}
+	meetings, err := r.db.GetScheduledMeetingsToRemind(time.Now().Add(attendanceReminderLead))
+	if err != nil {
+		return fmt.Errorf("error getting meetings to remind: %s", err)
+	}
+	for _, meeting := range meetings {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := r.reportMeeting(meeting); err != nil {
+			r.Errorf("reportDueMeetings: unable to report %q: %s", meeting.Title, err)
+		}
+	}
+	return nil
+}
+
+func (r *AttendanceReporter) reportMeeting(meeting ScheduledMeeting) error {
+	// mark it first so a failure doesn't remind twice
+	if err := r.db.SetScheduledMeetingReminded(meeting.ConvID, meeting.EventID); err != nil {
+		return err
+	}
+	attendance, err := getAttendance(r.db, r.identities, r.config, meeting)
+	if err == errEventGone {
+		r.stats.Count("reportMeeting - gone")
+		return r.db.DeleteScheduledMeeting(meeting.ConvID, meeting.EventID)
+	} else if err != nil {
+		return err
+	}
+	r.ChatEcho(meeting.ConvID, ":calendar: *%s* starts soon, %s\n%s", meeting.Title,
+		formatScheduledMeetingTime(meeting), FormatAttendance(*attendance))
+	for _, username := range attendance.Pending {
+		if _, err := r.kbc.SendMessageByTlfName(username,
+			"You're invited to *%s*, %s, and haven't responded yet. Will you be there?",
+			meeting.Title, formatScheduledMeetingTime(meeting)); err != nil {
+			r.Debug("reportMeeting: unable to remind %s: %s", username, err)
+		}
+	}
+	r.stats.Count("reportMeeting - sent")
+	return nil
+}
+
+func (h *Handler) handleRSVP(msg chat1.MsgSummary) error {
+	meetings, err := h.db.GetScheduledMeetingsForConv(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	if len(meetings) == 0 {
+		h.ChatEcho(msg.ConvID, "There are no upcoming meetings scheduled here, schedule one with `!meet schedule %s`.",
+			scheduleUsage)
+		return nil
+	}
+	var sections []string
+	for _, meeting := range meetings {
+		attendance, err := getAttendance(h.db, h.identities, h.config, meeting)
+		if err == errEventGone {
+			if err := h.db.DeleteScheduledMeeting(msg.ConvID, meeting.EventID); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			h.Debug("handleRSVP: unable to get attendance of %q: %s", meeting.Title, err)
+			sections = append(sections, fmt.Sprintf("*%s*, %s\n• I wasn't able to get the responses",
+				meeting.Title, formatScheduledMeetingTime(meeting)))
+			continue
+		}
+		sections = append(sections, fmt.Sprintf("*%s*, %s\n%s", meeting.Title,
+			formatScheduledMeetingTime(meeting), FormatAttendance(*attendance)))
+	}
+	if len(sections) == 0 {
+		h.ChatEcho(msg.ConvID, "There are no upcoming meetings scheduled here.")
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(sections, "\n\n"))
+	return nil
+}
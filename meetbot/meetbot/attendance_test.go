@@ -0,0 +1,38 @@
+package meetbot_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/keybase/managed-bots/meetbot/meetbot"
+)
+
+func TestGetAttendance(t *testing.T) {
+	attendance := meetbot.GetAttendance([]*calendar.EventAttendee{
+		{Email: "me@example.com", Organizer: true, ResponseStatus: "accepted"},
+		{Email: "room@resource.example.com", Resource: true, ResponseStatus: "needsAction"},
+		{Email: "Alice@example.com", ResponseStatus: "accepted"},
+		{Email: "bob@example.com", ResponseStatus: "declined"},
+		{Email: "carol@example.com", ResponseStatus: "tentative"},
+		{Email: "dave@example.com", ResponseStatus: "needsAction"},
+		{Email: "erin@example.com", ResponseStatus: "needsAction"},
+	}, map[string]string{
+		"alice@example.com": "alice",
+		"dave@example.com":  "dave",
+	})
+	require.Equal(t, meetbot.Attendance{
+		Accepted:   []string{"@alice"},
+		Tentative:  []string{"carol@example.com"},
+		Declined:   []string{"bob@example.com"},
+		NoResponse: []string{"@dave", "erin@example.com"},
+		Pending:    []string{"dave"},
+	}, attendance)
+	require.Equal(t, `• Accepted: @alice
+• Maybe: carol@example.com
+• Declined: bob@example.com
+• No response: @dave, erin@example.com`, meetbot.FormatAttendance(attendance))
+
+	require.Equal(t, "• Nobody is invited", meetbot.FormatAttendance(meetbot.Attendance{}))
+}
@@ -0,0 +1,681 @@
+package meetbot
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+type DB struct {
+	*base.OAuthDB
+}
+
+func NewDB(db *base.DB) *DB {
+	return &DB{
+		OAuthDB: base.NewOAuthDBWithDB(db),
+	}
+}
+
+// recurring meetings
+
+type RecurringMeeting struct {
+	ConvID chat1.ConvIDStr
+	Name   string
+	// Days is the day of week field of a cron schedule, see formatDays
+	Days     string
+	Hour     int
+	Minute   int
+	Timezone string
+	Link     string
+	Creator  string
+	NextRun  time.Time
+}
+
+func (d *DB) PutRecurringMeeting(meeting RecurringMeeting) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO recurring_meetings
+			(conv_id, name, days, hour, minute, timezone, link, creator, next_run)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			days=VALUES(days),
+			hour=VALUES(hour),
+			minute=VALUES(minute),
+			timezone=VALUES(timezone),
+			link=VALUES(link),
+			creator=VALUES(creator),
+			next_run=VALUES(next_run)
+		`, meeting.ConvID, meeting.Name, meeting.Days, meeting.Hour, meeting.Minute, meeting.Timezone,
+			meeting.Link, meeting.Creator, meeting.NextRun)
+		return err
+	})
+}
+
+// DeleteRecurringMeeting returns false if the conversation has no meeting
+// by that name.
+func (d *DB) DeleteRecurringMeeting(convID chat1.ConvIDStr, name string) (deleted bool, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			DELETE FROM recurring_meetings
+			WHERE conv_id = ? AND name = ?
+		`, convID, name)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		deleted = rows > 0
+		_, err = tx.Exec(`
+			DELETE FROM recurring_meeting_skips
+			WHERE conv_id = ? AND name = ?
+		`, convID, name)
+		return err
+	})
+	return deleted, err
+}
+
+func (d *DB) UpdateRecurringMeetingNextRun(convID chat1.ConvIDStr, name string, nextRun time.Time) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE recurring_meetings
+			SET next_run = ?
+			WHERE conv_id = ? AND name = ?
+		`, nextRun, convID, name)
+		return err
+	})
+}
+
+func scanRecurringMeetings(rows *sql.Rows) (meetings []RecurringMeeting, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var meeting RecurringMeeting
+		var nextRun int64
+		if err := rows.Scan(&meeting.ConvID, &meeting.Name, &meeting.Days, &meeting.Hour, &meeting.Minute,
+			&meeting.Timezone, &meeting.Link, &meeting.Creator, &nextRun); err != nil {
+			return nil, err
+		}
+		meeting.NextRun = time.Unix(nextRun, 0)
+		meetings = append(meetings, meeting)
+	}
+	return meetings, rows.Err()
+}
+
+func (d *DB) GetRecurringMeeting(convID chat1.ConvIDStr, name string) (*RecurringMeeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, name, days, hour, minute, timezone, link, creator, ROUND(UNIX_TIMESTAMP(next_run))
+		FROM recurring_meetings
+		WHERE conv_id = ? AND name = ?
+	`, convID, name)
+	if err != nil {
+		return nil, err
+	}
+	meetings, err := scanRecurringMeetings(rows)
+	if err != nil || len(meetings) == 0 {
+		return nil, err
+	}
+	return &meetings[0], nil
+}
+
+func (d *DB) GetRecurringMeetingsForConv(convID chat1.ConvIDStr) ([]RecurringMeeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, name, days, hour, minute, timezone, link, creator, ROUND(UNIX_TIMESTAMP(next_run))
+		FROM recurring_meetings
+		WHERE conv_id = ?
+		ORDER BY name
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecurringMeetings(rows)
+}
+
+// GetDueRecurringMeetings returns the meetings whose next run has come.
+func (d *DB) GetDueRecurringMeetings() ([]RecurringMeeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, name, days, hour, minute, timezone, link, creator, ROUND(UNIX_TIMESTAMP(next_run))
+		FROM recurring_meetings
+		WHERE next_run <= NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecurringMeetings(rows)
+}
+
+// AddRecurringMeetingSkip skips the meeting called name on day, formatted
+// like 2006-01-02. An empty name is a holiday which skips every meeting of
+// the conversation.
+func (d *DB) AddRecurringMeetingSkip(convID chat1.ConvIDStr, name, day string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT IGNORE INTO recurring_meeting_skips
+			(conv_id, name, day)
+			VALUES (?, ?, ?)
+		`, convID, name, day)
+		return err
+	})
+}
+
+// IsRecurringMeetingSkipped tells whether the meeting or every meeting of the
+// conversation is skipped on day.
+func (d *DB) IsRecurringMeetingSkipped(convID chat1.ConvIDStr, name, day string) (skipped bool, err error) {
+	row := d.DB.QueryRow(`
+		SELECT EXISTS(
+			SELECT *
+			FROM recurring_meeting_skips
+			WHERE conv_id = ? AND name IN (?, '') AND day = ?
+		)
+	`, convID, name, day)
+	err = row.Scan(&skipped)
+	return skipped, err
+}
+
+// GetRecurringMeetingSkips returns the upcoming skips of a conversation by
+// meeting name, holidays are under "".
+func (d *DB) GetRecurringMeetingSkips(convID chat1.ConvIDStr, today string) (skips map[string][]string, err error) {
+	rows, err := d.DB.Query(`
+		SELECT name, day
+		FROM recurring_meeting_skips
+		WHERE conv_id = ? AND day >= ?
+		ORDER BY day
+	`, convID, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	skips = make(map[string][]string)
+	for rows.Next() {
+		var name, day string
+		if err := rows.Scan(&name, &day); err != nil {
+			return nil, err
+		}
+		skips[name] = append(skips[name], day)
+	}
+	return skips, rows.Err()
+}
+
+// DeleteRecurringMeetingSkipsBefore forgets skips of days which have passed.
+func (d *DB) DeleteRecurringMeetingSkipsBefore(convID chat1.ConvIDStr, day string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM recurring_meeting_skips
+			WHERE conv_id = ? AND day < ?
+		`, convID, day)
+		return err
+	})
+}
+
+// meetings in progress and their notes
+
+type Meeting struct {
+	ConvID  chat1.ConvIDStr
+	Title   string
+	Link    string
+	Started time.Time
+	// TimeboxEnd is when the meeting should be over, if it's timeboxed
+	TimeboxEnd *time.Time
+	// TimeboxWarned is set once the end of the timebox is announced as near
+	TimeboxWarned bool
+}
+
+type MeetingNote struct {
+	Author string
+	Note   string
+	Ctime  time.Time
+}
+
+// PutMeeting starts the meeting of a conversation, replacing the one in
+// progress and dropping its notes.
+func (d *DB) PutMeeting(meeting Meeting) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM meeting_notes
+			WHERE conv_id = ?
+		`, meeting.ConvID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO meetings
+			(conv_id, title, link, started, timebox_end, timebox_warned)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			title=VALUES(title),
+			link=VALUES(link),
+			started=VALUES(started),
+			timebox_end=VALUES(timebox_end),
+			timebox_warned=VALUES(timebox_warned)
+		`, meeting.ConvID, meeting.Title, meeting.Link, meeting.Started, meeting.TimeboxEnd,
+			meeting.TimeboxWarned)
+		return err
+	})
+}
+
+func scanMeetings(rows *sql.Rows) (meetings []Meeting, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var meeting Meeting
+		var started int64
+		var timeboxEnd sql.NullInt64
+		if err := rows.Scan(&meeting.ConvID, &meeting.Title, &meeting.Link, &started, &timeboxEnd,
+			&meeting.TimeboxWarned); err != nil {
+			return nil, err
+		}
+		meeting.Started = time.Unix(started, 0)
+		if timeboxEnd.Valid {
+			end := time.Unix(timeboxEnd.Int64, 0)
+			meeting.TimeboxEnd = &end
+		}
+		meetings = append(meetings, meeting)
+	}
+	return meetings, rows.Err()
+}
+
+func (d *DB) GetMeeting(convID chat1.ConvIDStr) (*Meeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, title, link, ROUND(UNIX_TIMESTAMP(started)), ROUND(UNIX_TIMESTAMP(timebox_end)),
+		timebox_warned
+		FROM meetings
+		WHERE conv_id = ?
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	meetings, err := scanMeetings(rows)
+	if err != nil || len(meetings) == 0 {
+		return nil, err
+	}
+	return &meetings[0], nil
+}
+
+// GetTimeboxedMeetingsEndingBefore returns the meetings whose timebox ends
+// before before, or has ended.
+func (d *DB) GetTimeboxedMeetingsEndingBefore(before time.Time) ([]Meeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, title, link, ROUND(UNIX_TIMESTAMP(started)), ROUND(UNIX_TIMESTAMP(timebox_end)),
+		timebox_warned
+		FROM meetings
+		WHERE timebox_end <= ?
+	`, before)
+	if err != nil {
+		return nil, err
+	}
+	return scanMeetings(rows)
+}
+
+func (d *DB) SetMeetingTimeboxWarned(convID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE meetings
+			SET timebox_warned = true
+			WHERE conv_id = ?
+		`, convID)
+		return err
+	})
+}
+
+// ClearMeetingTimebox keeps the meeting going without a timebox, so notes
+// can still be taken once it's over.
+func (d *DB) ClearMeetingTimebox(convID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE meetings
+			SET timebox_end = NULL, timebox_warned = false
+			WHERE conv_id = ?
+		`, convID)
+		return err
+	})
+}
+
+func (d *DB) DeleteMeeting(convID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM meeting_notes
+			WHERE conv_id = ?
+		`, convID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			DELETE FROM meetings
+			WHERE conv_id = ?
+		`, convID)
+		return err
+	})
+}
+
+func (d *DB) AddMeetingNote(convID chat1.ConvIDStr, author, note string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO meeting_notes
+			(conv_id, author, note, ctime)
+			VALUES (?, ?, ?, NOW())
+		`, convID, author, note)
+		return err
+	})
+}
+
+func (d *DB) GetMeetingNotes(convID chat1.ConvIDStr) (notes []MeetingNote, err error) {
+	rows, err := d.DB.Query(`
+		SELECT author, note, ROUND(UNIX_TIMESTAMP(ctime))
+		FROM meeting_notes
+		WHERE conv_id = ?
+		ORDER BY id
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var note MeetingNote
+		var ctime int64
+		if err := rows.Scan(&note.Author, &note.Note, &ctime); err != nil {
+			return nil, err
+		}
+		note.Ctime = time.Unix(ctime, 0)
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// scheduled meetings, followed until they start
+
+type ScheduledMeeting struct {
+	ConvID  chat1.ConvIDStr
+	EventID string
+	// TokenIdentifier is the identifier of the OAuth token of the calendar
+	// the event is on
+	TokenIdentifier string
+	Title           string
+	Start           time.Time
+	End             time.Time
+	Timezone        string
+	Reminded        bool
+}
+
+func (d *DB) PutScheduledMeeting(meeting ScheduledMeeting) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT INTO scheduled_meetings
+			(conv_id, event_id, token_identifier, title, start, end, timezone, reminded)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			token_identifier=VALUES(token_identifier),
+			title=VALUES(title),
+			start=VALUES(start),
+			end=VALUES(end),
+			timezone=VALUES(timezone),
+			reminded=VALUES(reminded)
+		`, meeting.ConvID, meeting.EventID, meeting.TokenIdentifier, meeting.Title, meeting.Start, meeting.End,
+			meeting.Timezone, meeting.Reminded)
+		return err
+	})
+}
+
+func (d *DB) DeleteScheduledMeeting(convID chat1.ConvIDStr, eventID string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM scheduled_meetings
+			WHERE conv_id = ? AND event_id = ?
+		`, convID, eventID)
+		return err
+	})
+}
+
+// DeleteStartedScheduledMeetings stops following the meetings which have
+// started.
+func (d *DB) DeleteStartedScheduledMeetings() error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			DELETE FROM scheduled_meetings
+			WHERE start <= NOW()
+		`)
+		return err
+	})
+}
+
+func (d *DB) SetScheduledMeetingReminded(convID chat1.ConvIDStr, eventID string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE scheduled_meetings
+			SET reminded = true
+			WHERE conv_id = ? AND event_id = ?
+		`, convID, eventID)
+		return err
+	})
+}
+
+func scanScheduledMeetings(rows *sql.Rows) (meetings []ScheduledMeeting, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var meeting ScheduledMeeting
+		var start, end int64
+		if err := rows.Scan(&meeting.ConvID, &meeting.EventID, &meeting.TokenIdentifier, &meeting.Title,
+			&start, &end, &meeting.Timezone, &meeting.Reminded); err != nil {
+			return nil, err
+		}
+		meeting.Start = time.Unix(start, 0)
+		meeting.End = time.Unix(end, 0)
+		meetings = append(meetings, meeting)
+	}
+	return meetings, rows.Err()
+}
+
+func (d *DB) GetScheduledMeetingsForConv(convID chat1.ConvIDStr) ([]ScheduledMeeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, event_id, token_identifier, title, ROUND(UNIX_TIMESTAMP(start)),
+		ROUND(UNIX_TIMESTAMP(end)), timezone, reminded
+		FROM scheduled_meetings
+		WHERE conv_id = ? AND start > NOW()
+		ORDER BY start
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	return scanScheduledMeetings(rows)
+}
+
+// GetScheduledMeetingsToRemind returns the meetings starting before
+// before whose invitees haven't been reminded yet.
+func (d *DB) GetScheduledMeetingsToRemind(before time.Time) ([]ScheduledMeeting, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, event_id, token_identifier, title, ROUND(UNIX_TIMESTAMP(start)),
+		ROUND(UNIX_TIMESTAMP(end)), timezone, reminded
+		FROM scheduled_meetings
+		WHERE start <= ? AND start > NOW() AND NOT reminded
+	`, before)
+	if err != nil {
+		return nil, err
+	}
+	return scanScheduledMeetings(rows)
+}
+
+// office hours and their queue
+
+type OfficeHours struct {
+	ConvID chat1.ConvIDStr
+	Host   string
+	Link   string
+	Closes time.Time
+	// Current is who the host is seeing, if anybody
+	Current string
+}
+
+// PutOfficeHours opens office hours in a conversation, replacing the ones
+// already open and emptying their queue.
+func (d *DB) PutOfficeHours(officeHours OfficeHours) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM office_hours_queue
+			WHERE conv_id = ?
+		`, officeHours.ConvID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			INSERT INTO office_hours
+			(conv_id, host, link, closes, current)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+			host=VALUES(host),
+			link=VALUES(link),
+			closes=VALUES(closes),
+			current=VALUES(current)
+		`, officeHours.ConvID, officeHours.Host, officeHours.Link, officeHours.Closes, officeHours.Current)
+		return err
+	})
+}
+
+func scanOfficeHours(rows *sql.Rows) (res []OfficeHours, err error) {
+	defer rows.Close()
+	for rows.Next() {
+		var officeHours OfficeHours
+		var closes int64
+		if err := rows.Scan(&officeHours.ConvID, &officeHours.Host, &officeHours.Link, &closes,
+			&officeHours.Current); err != nil {
+			return nil, err
+		}
+		officeHours.Closes = time.Unix(closes, 0)
+		res = append(res, officeHours)
+	}
+	return res, rows.Err()
+}
+
+func (d *DB) GetOfficeHours(convID chat1.ConvIDStr) (*OfficeHours, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, host, link, ROUND(UNIX_TIMESTAMP(closes)), current
+		FROM office_hours
+		WHERE conv_id = ?
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	res, err := scanOfficeHours(rows)
+	if err != nil || len(res) == 0 {
+		return nil, err
+	}
+	return &res[0], nil
+}
+
+// GetClosingOfficeHours returns the office hours whose time is up.
+func (d *DB) GetClosingOfficeHours() ([]OfficeHours, error) {
+	rows, err := d.DB.Query(`
+		SELECT conv_id, host, link, ROUND(UNIX_TIMESTAMP(closes)), current
+		FROM office_hours
+		WHERE closes <= NOW()
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanOfficeHours(rows)
+}
+
+func (d *DB) SetOfficeHoursCurrent(convID chat1.ConvIDStr, username string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			UPDATE office_hours
+			SET current = ?
+			WHERE conv_id = ?
+		`, username, convID)
+		return err
+	})
+}
+
+func (d *DB) DeleteOfficeHours(convID chat1.ConvIDStr) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			DELETE FROM office_hours_queue
+			WHERE conv_id = ?
+		`, convID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			DELETE FROM office_hours
+			WHERE conv_id = ?
+		`, convID)
+		return err
+	})
+}
+
+// JoinOfficeHoursQueue adds username at the end of the queue, unless they're
+// already in it.
+func (d *DB) JoinOfficeHoursQueue(convID chat1.ConvIDStr, username string) error {
+	return d.RunTxn(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			INSERT IGNORE INTO office_hours_queue
+			(conv_id, username, ctime)
+			VALUES (?, ?, NOW())
+		`, convID, username)
+		return err
+	})
+}
+
+// LeaveOfficeHoursQueue returns false if username wasn't in the queue.
+func (d *DB) LeaveOfficeHoursQueue(convID chat1.ConvIDStr, username string) (left bool, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		res, err := tx.Exec(`
+			DELETE FROM office_hours_queue
+			WHERE conv_id = ? AND username = ?
+		`, convID, username)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		left = rows > 0
+		return err
+	})
+	return left, err
+}
+
+// PopOfficeHoursQueue removes and returns who is first in the queue, or ""
+// if nobody is waiting.
+func (d *DB) PopOfficeHoursQueue(convID chat1.ConvIDStr) (username string, err error) {
+	err = d.RunTxn(func(tx *sql.Tx) error {
+		var id int64
+		row := tx.QueryRow(`
+			SELECT id, username
+			FROM office_hours_queue
+			WHERE conv_id = ?
+			ORDER BY id
+			LIMIT 1
+			FOR UPDATE
+		`, convID)
+		switch err := row.Scan(&id, &username); err {
+		case nil:
+		case sql.ErrNoRows:
+			return nil
+		default:
+			return err
+		}
+		_, err := tx.Exec(`
+			DELETE FROM office_hours_queue
+			WHERE id = ?
+		`, id)
+		return err
+	})
+	return username, err
+}
+
+// GetOfficeHoursQueue returns who is waiting, first in line first.
+func (d *DB) GetOfficeHoursQueue(convID chat1.ConvIDStr) (usernames []string, err error) {
+	rows, err := d.DB.Query(`
+		SELECT username
+		FROM office_hours_queue
+		WHERE conv_id = ?
+		ORDER BY id
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return nil, err
+		}
+		usernames = append(usernames, username)
+	}
+	return usernames, rows.Err()
+}
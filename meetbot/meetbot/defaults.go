@@ -0,0 +1,308 @@
+package meetbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// Names of the conversation settings holding the defaults, besides
+// providerSetting.
+const (
+	durationSetting      = "duration"
+	calendarEventSetting = "calendar_event"
+	inviteesSetting      = "invitees"
+)
+
+const defaultsUsage = "<provider|duration|calendar|invitees> <value...>"
+
+// Defaults are how meetings of a conversation are made when `!meet` and
+// `!meet schedule` aren't told otherwise.
+type Defaults struct {
+	Provider Provider
+	Duration time.Duration
+	// CalendarEvent makes `!meet` put the meeting in the sender's calendar
+	// and invite Invitees.
+	CalendarEvent bool
+	// Invitees are Keybase usernames, without the @
+	Invitees []string
+}
+
+// ParseInvitees parses `@alice @bob`, `alice,bob` or `none` into usernames.
+func ParseInvitees(args []string) (usernames []string, ok bool) {
+	if len(args) == 1 && strings.ToLower(args[0]) == "none" {
+		return nil, true
+	}
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		for _, username := range strings.Split(arg, ",") {
+			username = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+			if username == "" || seen[username] {
+				continue
+			}
+			seen[username] = true
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames, len(usernames) > 0
+}
+
+func parseOnOff(args []string) (on bool, ok bool) {
+	if len(args) != 1 {
+		return false, false
+	}
+	switch strings.ToLower(args[0]) {
+	case "on", "yes", "true":
+		return true, true
+	case "off", "no", "false":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func formatInvitees(usernames []string) string {
+	if len(usernames) == 0 {
+		return "nobody"
+	}
+	var mentions []string
+	for _, username := range usernames {
+		mentions = append(mentions, "@"+username)
+	}
+	return strings.Join(mentions, ", ")
+}
+
+func (h *Handler) getDefaults(convID chat1.ConvIDStr) (defaults Defaults, err error) {
+	if defaults.Provider, err = h.getProvider(convID); err != nil {
+		return defaults, err
+	}
+	if defaults.Duration, err = h.settings.GetDuration(convID, durationSetting, DefaultMeetingDuration); err != nil {
+		return defaults, err
+	}
+	if defaults.CalendarEvent, err = h.settings.GetBool(convID, calendarEventSetting, false); err != nil {
+		return defaults, err
+	}
+	invitees, err := h.settings.GetString(convID, inviteesSetting, "")
+	if err != nil {
+		return defaults, err
+	} else if invitees != "" {
+		defaults.Invitees = strings.Split(invitees, ",")
+	}
+	return defaults, nil
+}
+
+func (h *Handler) defaultsCommands() []base.Command {
+	return []base.Command{
+		{
+			Name:        "defaults",
+			Description: "Show how meetings of this conversation are made unless told otherwise",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleDefaults(msg)
+			},
+		},
+		{
+			Name:        "defaults set",
+			Usage:       defaultsUsage,
+			Description: "Change how meetings of this conversation are made unless told otherwise",
+			MinArgs:     2,
+			MaxArgs:     -1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleDefaultsSet(msg, strings.ToLower(args.Positional[0]), args.Positional[1:])
+			},
+		},
+		{
+			Name:        "defaults reset",
+			Description: "Go back to making meetings of this conversation the usual way",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleDefaultsReset(msg)
+			},
+		},
+	}
+}
+
+func (h *Handler) handleDefaults(msg chat1.MsgSummary) error {
+	defaults, err := h.getDefaults(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	calendarEvent := "off"
+	if defaults.CalendarEvent {
+		calendarEvent = "on"
+	}
+	lines := []string{
+		"Meetings here:",
+		fmt.Sprintf("• Provider: %s", defaults.Provider.Title()),
+		fmt.Sprintf("• Duration: %s", formatMinutes(defaults.Duration)),
+		fmt.Sprintf("• Calendar event for `!meet`: %s", calendarEvent),
+		fmt.Sprintf("• Invitees: %s", formatInvitees(defaults.Invitees)),
+		fmt.Sprintf("Change them with `!meet defaults set %s`.", defaultsUsage),
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return nil
+}
+
+func (h *Handler) handleDefaultsSet(msg chat1.MsgSummary, name string, args []string) error {
+	if name == "provider" {
+		if len(args) != 1 {
+			h.ChatEcho(msg.ConvID, "Usage: `!meet defaults set provider <%s>`", strings.Join(h.providerNames(), "|"))
+			return nil
+		}
+		return h.handleProviderSet(msg, strings.ToLower(args[0]))
+	}
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return err
+	} else if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to change how meetings are made.")
+		return nil
+	}
+
+	var key string
+	var value interface{}
+	switch name {
+	case "duration":
+		duration, err := time.ParseDuration(strings.Join(args, ""))
+		if err != nil || duration <= 0 || duration > 24*time.Hour {
+			h.ChatEcho(msg.ConvID, "I don't understand the duration %q, use one like `45m` or `1h30m`.",
+				strings.Join(args, " "))
+			return nil
+		}
+		key, value = durationSetting, duration
+	case "calendar":
+		calendarEvent, ok := parseOnOff(args)
+		if !ok {
+			h.ChatEcho(msg.ConvID, "Usage: `!meet defaults set calendar <on|off>`")
+			return nil
+		}
+		key, value = calendarEventSetting, calendarEvent
+	case "invitees":
+		invitees, ok := ParseInvitees(args)
+		if !ok {
+			h.ChatEcho(msg.ConvID, "Usage: `!meet defaults set invitees <@user...|none>`")
+			return nil
+		}
+		key, value = inviteesSetting, strings.Join(invitees, ",")
+	default:
+		h.ChatEcho(msg.ConvID, "I don't have a default %q.\nUsage: `!meet defaults set %s`", name, defaultsUsage)
+		return nil
+	}
+	if err := h.settings.Set(msg.ConvID, key, value); err != nil {
+		return err
+	}
+	h.stats.Count("defaults set - " + name)
+	return h.handleDefaults(msg)
+}
+
+func (h *Handler) handleDefaultsReset(msg chat1.MsgSummary) error {
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return err
+	} else if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to change how meetings are made.")
+		return nil
+	}
+	for _, key := range []string{providerSetting, durationSetting, calendarEventSetting, inviteesSetting} {
+		if err := h.settings.Delete(msg.ConvID, key); err != nil {
+			return err
+		}
+	}
+	return h.handleDefaults(msg)
+}
+
+// lookupAttendees returns the Google accounts of the users who linked one,
+// and the mentions of those who didn't.
+func (h *Handler) lookupAttendees(usernames []string) (attendees []*calendar.EventAttendee, unknown []string, err error) {
+	for _, username := range usernames {
+		identity, err := h.identities.ExternalID(username, base.GoogleIdentity)
+		if err != nil {
+			return nil, nil, err
+		} else if identity == nil {
+			unknown = append(unknown, "@"+username)
+			continue
+		}
+		attendees = append(attendees, &calendar.EventAttendee{Email: identity.ExternalID})
+	}
+	return attendees, unknown, nil
+}
+
+func unknownAttendeesLine(unknown []string) string {
+	return fmt.Sprintf(
+		"I don't know the Google account of %s, so they're not invited. They can tell me with `!meet link google <email>`.",
+		strings.Join(unknown, ", "))
+}
+
+// meetWithEvent puts a meeting starting now in the sender's calendar, with
+// the conversation's invitees, and posts its links.
+func (h *Handler) meetWithEvent(msg chat1.MsgSummary, srv *calendar.Service, defaults Defaults) error {
+	loc, err := getCalendarTimezone(srv)
+	if err != nil {
+		h.Debug("meetWithEvent: unable to get timezone, using UTC: %s", err)
+		loc = time.UTC
+	}
+	attendees, unknown, err := h.lookupAttendees(defaults.Invitees)
+	if err != nil {
+		return err
+	}
+	start := time.Now().In(loc)
+	event := &calendar.Event{
+		Summary: "Meeting",
+		Start: &calendar.EventDateTime{
+			DateTime: start.Format(time.RFC3339),
+			TimeZone: loc.String(),
+		},
+		End: &calendar.EventDateTime{
+			DateTime: start.Add(defaults.Duration).Format(time.RFC3339),
+			TimeZone: loc.String(),
+		},
+		Attendees: attendees,
+	}
+	if _, ok := defaults.Provider.(googleMeetProvider); ok {
+		requestID, err := base.MakeRequestID()
+		if err != nil {
+			return err
+		}
+		event.ConferenceData = &calendar.ConferenceData{
+			CreateRequest: &calendar.CreateConferenceRequest{
+				RequestId: requestID,
+				ConferenceSolutionKey: &calendar.ConferenceSolutionKey{
+					Type: "hangoutsMeet",
+				},
+			},
+		}
+	} else {
+		link, err := defaults.Provider.CreateMeeting(msg, "")
+		if err != nil {
+			return err
+		}
+		event.Location = link
+		event.Description = fmt.Sprintf("Join on %s: https://%s", defaults.Provider.Title(), link)
+	}
+	event, err = srv.Events.Insert("primary", event).ConferenceDataVersion(1).SendUpdates("all").Do()
+	if err != nil {
+		return fmt.Errorf("meetWithEvent: unable to create event %s", err)
+	}
+	link := event.Location
+	if link == "" {
+		link = videoLink(event)
+	}
+	if link == "" {
+		h.ChatEcho(msg.ConvID, "I wasn't able to create a meeting, please try again.")
+		return nil
+	}
+
+	lines := []string{link, fmt.Sprintf("Event: %s", event.HtmlLink)}
+	if len(unknown) > 0 {
+		lines = append(lines, unknownAttendeesLine(unknown))
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return startMeeting(h.DebugOutput, h.db, Meeting{
+		ConvID:  msg.ConvID,
+		Link:    link,
+		Started: time.Now(),
+	})
+}
@@ -0,0 +1,22 @@
+package meetbot_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/meetbot/meetbot"
+)
+
+func TestParseInvitees(t *testing.T) {
+	usernames, ok := meetbot.ParseInvitees([]string{"@Alice", "bob,@carol", "@alice"})
+	require.True(t, ok)
+	require.Equal(t, []string{"alice", "bob", "carol"}, usernames)
+
+	usernames, ok = meetbot.ParseInvitees([]string{"none"})
+	require.True(t, ok)
+	require.Nil(t, usernames)
+
+	_, ok = meetbot.ParseInvitees([]string{"@", ","})
+	require.False(t, ok)
+}
@@ -3,7 +3,9 @@ package meetbot
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
@@ -17,23 +19,35 @@ import (
 type Handler struct {
 	*base.DebugOutput
 
-	stats  *base.StatsRegistry
-	kbc    *kbchat.API
-	db     *base.OAuthDB
-	config *oauth2.Config
+	stats      *base.StatsRegistry
+	kbc        *kbchat.API
+	router     *base.CommandRouter
+	db         *DB
+	identities *base.IdentityStore
+	settings   *base.SettingsStore
+	config     *oauth2.Config
+	providers  map[string]Provider
 }
 
 var _ base.Handler = (*Handler)(nil)
 
+const scheduleUsage = `"<title>" [today|tomorrow|<weekday>|<yyyy-mm-dd>] <time> [duration] [@attendee...]`
+
 func NewHandler(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig,
-	db *base.OAuthDB, config *oauth2.Config) *Handler {
-	return &Handler{
+	db *DB, identities *base.IdentityStore, settings *base.SettingsStore, config *oauth2.Config) *Handler {
+	h := &Handler{
 		DebugOutput: base.NewDebugOutput("Handler", debugConfig),
 		stats:       stats.SetPrefix("Handler"),
 		kbc:         kbc,
 		db:          db,
+		identities:  identities,
+		settings:    settings,
 		config:      config,
+		providers:   make(map[string]Provider),
 	}
+	h.providers[GoogleMeetProvider] = googleMeetProvider{h: h}
+	h.router = h.newCommandRouter(debugConfig)
+	return h
 }
 
 func (h *Handler) HandleNewConv(conv chat1.ConvSummary) error {
@@ -50,27 +64,87 @@ func (h *Handler) HandleCommand(msg chat1.MsgSummary) error {
 		return nil
 	}
 
+	// a bare `!meet` is the instant meeting, everything else is a subcommand
+	var err error
 	cmd := strings.TrimSpace(msg.Content.Text.Body)
-	switch {
-	case strings.HasPrefix(cmd, "!meet"):
+	if cmd == "!meet" {
 		h.stats.Count("meet")
-		return h.meetHandler(msg)
+		err = h.meetHandler(msg)
+	} else if text, ok := noteText(cmd); ok {
+		h.stats.Count("note")
+		err = h.handleNote(msg, text)
+	} else {
+		_, err = h.router.Handle(msg)
 	}
-	return nil
+	// the sender was asked to authorize the bot, the command is run again
+	// once they have
+	if _, ok := err.(base.OAuthRequiredError); ok {
+		return nil
+	}
+	return err
 }
 
-func (h *Handler) meetHandler(msg chat1.MsgSummary) error {
+func (h *Handler) newCommandRouter(debugConfig *base.ChatDebugOutputConfig) *base.CommandRouter {
+	router := base.NewCommandRouter(h.stats, debugConfig, "!meet")
+	router.Register(
+		base.Command{
+			Name:        "schedule",
+			Usage:       scheduleUsage,
+			Description: "Schedule a Google Meet in your calendar and invite the attendees",
+			MinArgs:     2,
+			MaxArgs:     -1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.withCalendarService(msg, func(srv *calendar.Service) error {
+					return h.handleSchedule(msg, srv, args.Positional)
+				})
+			},
+		},
+	)
+	router.Register(
+		base.Command{
+			Name:        "rsvp",
+			Description: "Show who is coming to the meetings scheduled in this conversation",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleRSVP(msg)
+			},
+		},
+	)
+	router.Register(h.nowCommand())
+	router.Register(h.recurringCommands()...)
+	router.Register(h.providerCommands()...)
+	router.Register(h.defaultsCommands()...)
+	router.Register(h.officeHoursCommands()...)
+	router.Register(h.notesCommands()...)
+	router.Register(h.identities.Commands()...)
+	return router
+}
+
+// withCalendarService runs fn with the sender's Google Calendar. It returns
+// base.OAuthRequiredError if they're asked to authorize the bot first.
+func (h *Handler) withCalendarService(msg chat1.MsgSummary, fn func(srv *calendar.Service) error) error {
+	return h.withGoogleClient(msg, func(client *http.Client) error {
+		srv, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+		if err != nil {
+			return err
+		}
+		return fn(srv)
+	})
+}
+
+// withGoogleClient runs fn with a client authorized by the sender's Google
+// account, asking them to authorize the bot again if Google rejects it.
+func (h *Handler) withGoogleClient(msg chat1.MsgSummary, fn func(client *http.Client) error) error {
 	retry := func() error {
 		// retry auth after nuking stored credentials
 		if err := h.db.DeleteToken(base.IdentifierFromMsg(msg)); err != nil {
 			return err
 		}
-		return h.meetHandlerInner(msg)
+		return h.withGoogleClientInner(msg, fn)
 	}
-	err := h.meetHandlerInner(msg)
+	err := h.withGoogleClientInner(msg, fn)
 	switch err.(type) {
 	case nil, base.OAuthRequiredError:
-		return nil
+		return err
 	case *googleapi.Error:
 		h.Errorf("unable to get service %v, deleting credentials and retrying", err)
 		return retry()
@@ -83,7 +157,7 @@ func (h *Handler) meetHandler(msg chat1.MsgSummary) error {
 	}
 }
 
-func (h *Handler) meetHandlerInner(msg chat1.MsgSummary) error {
+func (h *Handler) withGoogleClientInner(msg chat1.MsgSummary, fn func(client *http.Client) error) error {
 	identifier := base.IdentifierFromMsg(msg)
 	client, err := base.GetOAuthClient(identifier, msg, h.kbc, h.config, h.db,
 		base.GetOAuthOpts{
@@ -95,17 +169,42 @@ func (h *Handler) meetHandlerInner(msg chat1.MsgSummary) error {
 	} else if client == nil {
 		h.Errorf("unable to get oauth client: %q", identifier)
 	}
+	return fn(client)
+}
 
-	srv, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+func (h *Handler) meetHandler(msg chat1.MsgSummary) error {
+	defaults, err := h.getDefaults(msg.ConvID)
 	if err != nil {
 		return err
 	}
+	if defaults.CalendarEvent {
+		return h.withCalendarService(msg, func(srv *calendar.Service) error {
+			return h.meetWithEvent(msg, srv, defaults)
+		})
+	}
+	link, err := defaults.Provider.CreateMeeting(msg, "")
+	if err != nil {
+		return err
+	} else if link == "" {
+		h.ChatEcho(msg.ConvID, "I wasn't able to create a meeting, please try again.")
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, link)
+	return startMeeting(h.DebugOutput, h.db, Meeting{
+		ConvID:  msg.ConvID,
+		Link:    link,
+		Started: time.Now(),
+	})
+}
 
+// createMeetLink returns the link to a new meeting, or "" if Google didn't
+// create one.
+func createMeetLink(srv *calendar.Service) (string, error) {
 	// Create a bogus event on the primary calendar to host the meeting, it is
 	// instantly deleted once the meeting is created.
 	requestID, err := base.MakeRequestID()
 	if err != nil {
-		return err
+		return "", err
 	}
 	event := &calendar.Event{
 		Start: &calendar.EventDateTime{
@@ -124,27 +223,138 @@ func (h *Handler) meetHandlerInner(msg chat1.MsgSummary) error {
 	calendarId := "primary"
 	event, err = srv.Events.Insert(calendarId, event).ConferenceDataVersion(1).Do()
 	if err != nil {
-		return fmt.Errorf("meetHandler: unable to create event %s", err)
+		return "", fmt.Errorf("createMeetLink: unable to create event %s", err)
 	}
 	if err := srv.Events.Delete(calendarId, event.Id).Do(); err != nil {
-		return fmt.Errorf("meetHandler: unable to delete event %s", err)
+		return "", fmt.Errorf("createMeetLink: unable to delete event %s", err)
 	}
+	return videoLink(event), nil
+}
 
+// videoLink returns the Meet link of event without its protocol, which skips
+// the unfurl prompt, or "" if it has no conference.
+func videoLink(event *calendar.Event) string {
 	if confData := event.ConferenceData; confData != nil {
 		for _, ep := range confData.EntryPoints {
 			if ep.EntryPointType == "video" {
-				// strip protocol to skip unfurl prompt
-				link := strings.TrimPrefix(ep.Uri, "https://")
-				if link == "" {
-					continue
+				if link := strings.TrimPrefix(ep.Uri, "https://"); link != "" {
+					return link
 				}
-				h.ChatEcho(msg.ConvID, link)
-				return nil
 			}
 		}
 	}
+	return ""
+}
+
+// getCalendarTimezone returns the timezone of the primary calendar, which
+// events listings report without needing more than the events scope.
+func getCalendarTimezone(srv *calendar.Service) (*time.Location, error) {
+	events, err := srv.Events.List("primary").MaxResults(1).Fields("timeZone").Do()
+	if err != nil {
+		return nil, err
+	}
+	return time.LoadLocation(events.TimeZone)
+}
 
-	h.Debug("meetHandler: no event found, conferenceData: %+v", event.ConferenceData)
-	h.ChatEcho(msg.ConvID, "I wasn't able to create a meeting, please try again.")
+// getTimezone returns the timezone of the sender's calendar, or UTC if they
+// haven't authorized the bot, which they needn't when meetings aren't on
+// Google Meet.
+func (h *Handler) getTimezone(msg chat1.MsgSummary) *time.Location {
+	identifier := base.IdentifierFromMsg(msg)
+	token, err := h.db.GetToken(identifier)
+	if err != nil || token == nil {
+		return time.UTC
+	}
+	client := base.NewPersistingClient(h.config, token, base.StorageTokenSaver(h.db, identifier))
+	srv, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		h.Debug("getTimezone: unable to get service, using UTC: %s", err)
+		return time.UTC
+	}
+	loc, err := getCalendarTimezone(srv)
+	if err != nil {
+		h.Debug("getTimezone: unable to get timezone, using UTC: %s", err)
+		return time.UTC
+	}
+	return loc
+}
+
+func (h *Handler) handleSchedule(msg chat1.MsgSummary, srv *calendar.Service, args []string) error {
+	loc, err := getCalendarTimezone(srv)
+	if err != nil {
+		h.Debug("handleSchedule: unable to get timezone, using UTC: %s", err)
+		loc = time.UTC
+	}
+	defaults, err := h.getDefaults(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	schedule, err := ParseScheduleWithDuration(args, time.Now().In(loc), defaults.Duration)
+	switch err := err.(type) {
+	case nil:
+	case ScheduleError:
+		h.ChatEcho(msg.ConvID, "%s\nUsage: `!meet schedule %s`", err, scheduleUsage)
+		return nil
+	default:
+		return err
+	}
+
+	usernames, _ := ParseInvitees(append(schedule.Attendees, defaults.Invitees...))
+	attendees, unknown, err := h.lookupAttendees(usernames)
+	if err != nil {
+		return err
+	}
+
+	requestID, err := base.MakeRequestID()
+	if err != nil {
+		return err
+	}
+	event := &calendar.Event{
+		Summary: schedule.Title,
+		Start: &calendar.EventDateTime{
+			DateTime: schedule.Start.Format(time.RFC3339),
+			TimeZone: loc.String(),
+		},
+		End: &calendar.EventDateTime{
+			DateTime: schedule.Start.Add(schedule.Duration).Format(time.RFC3339),
+			TimeZone: loc.String(),
+		},
+		Attendees: attendees,
+		ConferenceData: &calendar.ConferenceData{
+			CreateRequest: &calendar.CreateConferenceRequest{
+				RequestId: requestID,
+				ConferenceSolutionKey: &calendar.ConferenceSolutionKey{
+					Type: "hangoutsMeet",
+				},
+			},
+		},
+	}
+	event, err = srv.Events.Insert("primary", event).ConferenceDataVersion(1).SendUpdates("all").Do()
+	if err != nil {
+		return fmt.Errorf("handleSchedule: unable to create event %s", err)
+	}
+	h.stats.Count("schedule")
+	if err := h.db.PutScheduledMeeting(ScheduledMeeting{
+		ConvID:          msg.ConvID,
+		EventID:         event.Id,
+		TokenIdentifier: base.IdentifierFromMsg(msg),
+		Title:           schedule.Title,
+		Start:           schedule.Start,
+		End:             schedule.Start.Add(schedule.Duration),
+		Timezone:        loc.String(),
+	}); err != nil {
+		return err
+	}
+
+	lines := []string{fmt.Sprintf("Scheduled *%s* for %s", schedule.Title,
+		FormatMeetingTime(schedule.Start, schedule.Duration))}
+	if link := videoLink(event); link != "" {
+		lines = append(lines, fmt.Sprintf("Join: %s", link))
+	}
+	lines = append(lines, fmt.Sprintf("Event: %s", event.HtmlLink))
+	if len(unknown) > 0 {
+		lines = append(lines, unknownAttendeesLine(unknown))
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
 	return nil
 }
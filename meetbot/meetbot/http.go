@@ -21,13 +21,19 @@ type HTTPSrv struct {
 }
 
 func NewHTTPSrv(stats *base.StatsRegistry, kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig,
-	db *base.OAuthDB, handler *Handler, oauthConfig *oauth2.Config) *HTTPSrv {
+	db *base.OAuthDB, handler *Handler, oauthConfig, zoomConfig *oauth2.Config) *HTTPSrv {
 	h := &HTTPSrv{
 		db:      db,
 		handler: handler,
 	}
 	h.OAuthHTTPSrv = base.NewOAuthHTTPSrv(stats, kbc, debugConfig, oauthConfig, h.db, h.handler.HandleAuth,
 		"meetbot", base.Images["logo"], "/meetbot")
+	if zoomConfig != nil {
+		// Zoom tokens come back on their own callback, they share storage
+		// with Google's under a separate identifier
+		base.NewOAuthHTTPSrv(stats, kbc, debugConfig, zoomConfig, h.db, h.handler.HandleAuth,
+			"meetbot", base.Images["logo"], "/meetbot/zoom")
+	}
 	http.HandleFunc("/meetbot", h.healthCheckHandler)
 	http.HandleFunc("/meetbot/home", h.homeHandler)
 	http.HandleFunc("/meetbot/image", h.handleImage)
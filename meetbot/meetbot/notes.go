@@ -0,0 +1,233 @@
+package meetbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/option"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// notes are only taken this long after a meeting starts
+const maxMeetingLength = 4 * time.Hour
+
+// notesDocSetting is the conversation setting holding the ID of the Google
+// Doc notes are appended to.
+const notesDocSetting = "notes_doc"
+
+var docLinkRegex = regexp.MustCompile(`^(?:https?://)?docs\.google\.com/document/d/([\w-]+)`)
+var docIDRegex = regexp.MustCompile(`^[\w-]{20,}$`)
+
+// ParseDocID returns the ID of the Google Doc at link, which may also be a
+// bare ID.
+func ParseDocID(link string) (id string, ok bool) {
+	if match := docLinkRegex.FindStringSubmatch(link); match != nil {
+		return match[1], true
+	}
+	if docIDRegex.MatchString(link) {
+		return link, true
+	}
+	return "", false
+}
+
+// noteText returns the text of a `!meet note` command, which is taken
+// verbatim rather than split into arguments so quotes and apostrophes are
+// kept.
+func noteText(cmd string) (text string, ok bool) {
+	const prefix = "!meet note"
+	if !strings.HasPrefix(cmd, prefix) {
+		return "", false
+	}
+	rest := cmd[len(prefix):]
+	if rest == "" || !strings.ContainsAny(rest[:1], " \t\n") {
+		return "", false
+	}
+	text = strings.TrimSpace(rest)
+	return text, text != ""
+}
+
+// FormatNotes compiles the notes of a meeting into a summary, with times in
+// loc.
+func FormatNotes(meeting Meeting, notes []MeetingNote, loc *time.Location) string {
+	title := meeting.Title
+	if title == "" {
+		title = "the meeting"
+	} else {
+		title = fmt.Sprintf("*%s*", title)
+	}
+	lines := []string{fmt.Sprintf(":memo: Notes from %s started %s:", title,
+		meeting.Started.In(loc).Format("Mon Jan 2 at 3:04pm MST"))}
+	for _, note := range notes {
+		lines = append(lines, fmt.Sprintf("• %s @%s: %s", note.Ctime.In(loc).Format("3:04pm"),
+			note.Author, note.Note))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// startMeeting records that a meeting started in a conversation so
+// notes can be taken, posting the notes left over from the previous one.
+func startMeeting(out *base.DebugOutput, db *DB, meeting Meeting) error {
+	previous, err := db.GetMeeting(meeting.ConvID)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		notes, err := db.GetMeetingNotes(meeting.ConvID)
+		if err != nil {
+			return err
+		}
+		if len(notes) > 0 {
+			out.ChatEcho(meeting.ConvID, "%s", FormatNotes(*previous, notes, time.UTC))
+		}
+	}
+	return db.PutMeeting(meeting)
+}
+
+func (h *Handler) notesCommands() []base.Command {
+	return []base.Command{
+		{
+			Name:        "note",
+			Usage:       "<text>",
+			Description: "Add a note to the meeting going on in this conversation",
+			MinArgs:     1,
+			MaxArgs:     -1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleNote(msg, strings.Join(args.Positional, " "))
+			},
+		},
+		{
+			Name:        "end",
+			Description: "End the meeting going on in this conversation and post its notes",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleEnd(msg)
+			},
+		},
+		{
+			Name:        "notes doc",
+			Usage:       "<google doc link|off>",
+			Description: "Also append the notes of meetings in this conversation to a Google Doc",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleNotesDoc(msg, args.Positional[0])
+			},
+		},
+	}
+}
+
+func (h *Handler) handleNote(msg chat1.MsgSummary, text string) error {
+	meeting, err := h.db.GetMeeting(msg.ConvID)
+	if err != nil {
+		return err
+	} else if meeting == nil || time.Since(meeting.Started) > maxMeetingLength {
+		h.ChatEcho(msg.ConvID, "There's no meeting going on here, start one with `!meet`.")
+		return nil
+	}
+	if err := h.db.AddMeetingNote(msg.ConvID, msg.Sender.Username, text); err != nil {
+		return err
+	}
+	_, err = h.kbc.ReactByConvID(msg.ConvID, msg.Id, ":memo:")
+	return err
+}
+
+func (h *Handler) handleEnd(msg chat1.MsgSummary) error {
+	meeting, err := h.db.GetMeeting(msg.ConvID)
+	if err != nil {
+		return err
+	} else if meeting == nil {
+		h.ChatEcho(msg.ConvID, "There's no meeting going on here.")
+		return nil
+	}
+	notes, err := h.db.GetMeetingNotes(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		if err := h.db.DeleteMeeting(msg.ConvID); err != nil {
+			return err
+		}
+		h.ChatEcho(msg.ConvID, "The meeting is over, nobody took notes.")
+		return nil
+	}
+
+	summary := FormatNotes(*meeting, notes, h.getTimezone(msg))
+	docID, err := h.settings.GetString(msg.ConvID, notesDocSetting, "")
+	if err != nil {
+		return err
+	}
+	var docErr error
+	if docID != "" {
+		// the notes are kept until the sender authorized the bot, `!meet end`
+		// is run again once they have
+		docErr = h.withGoogleClient(msg, func(client *http.Client) error {
+			return appendToDoc(client, docID, summary)
+		})
+		if _, ok := docErr.(base.OAuthRequiredError); ok {
+			return docErr
+		}
+	}
+	if err := h.db.DeleteMeeting(msg.ConvID); err != nil {
+		return err
+	}
+	h.stats.Count("end")
+	h.ChatEcho(msg.ConvID, "%s", summary)
+	if docErr != nil {
+		h.Debug("handleEnd: unable to append to doc: %s", docErr)
+		h.ChatEcho(msg.ConvID, "I wasn't able to add the notes to the Google Doc, can you edit it?")
+	}
+	return nil
+}
+
+// appendToDoc adds text at the end of a Google Doc, separated from what's
+// already there by a blank line.
+func appendToDoc(client *http.Client, docID, text string) error {
+	srv, err := docs.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return err
+	}
+	_, err = srv.Documents.BatchUpdate(docID, &docs.BatchUpdateDocumentRequest{
+		Requests: []*docs.Request{
+			{
+				InsertText: &docs.InsertTextRequest{
+					Text:                 "\n" + text + "\n",
+					EndOfSegmentLocation: &docs.EndOfSegmentLocation{},
+				},
+			},
+		},
+	}).Do()
+	return err
+}
+
+func (h *Handler) handleNotesDoc(msg chat1.MsgSummary, link string) error {
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return err
+	} else if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to change where notes go.")
+		return nil
+	}
+	if strings.ToLower(link) == "off" {
+		if err := h.settings.Delete(msg.ConvID, notesDocSetting); err != nil {
+			return err
+		}
+		h.ChatEcho(msg.ConvID, "OK! Notes are only posted here from now on.")
+		return nil
+	}
+	docID, ok := ParseDocID(link)
+	if !ok {
+		h.ChatEcho(msg.ConvID, "I don't recognize %q as a Google Doc, use a link like `https://docs.google.com/document/d/<id>`.", link)
+		return nil
+	}
+	if err := h.settings.Set(msg.ConvID, notesDocSetting, docID); err != nil {
+		return err
+	}
+	h.ChatEcho(msg.ConvID, "OK! Notes are also appended to that doc from now on, with the Google account of whoever ends the meeting.")
+	return nil
+}
@@ -0,0 +1,41 @@
+package meetbot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/meetbot/meetbot"
+)
+
+func TestParseDocID(t *testing.T) {
+	const id = "1aBcDeFgHiJkLmNoPqRsTuVwXyZ_0123456789-ab"
+	for _, link := range []string{
+		"https://docs.google.com/document/d/" + id + "/edit",
+		"docs.google.com/document/d/" + id,
+		id,
+	} {
+		docID, ok := meetbot.ParseDocID(link)
+		require.True(t, ok, link)
+		require.Equal(t, id, docID)
+	}
+	for _, link := range []string{"", "off", "https://example.com/document/d/" + id} {
+		_, ok := meetbot.ParseDocID(link)
+		require.False(t, ok, link)
+	}
+}
+
+func TestFormatNotes(t *testing.T) {
+	started := time.Date(2020, time.April, 29, 14, 0, 0, 0, time.UTC)
+	notes := []meetbot.MeetingNote{
+		{Author: "alice", Note: "ship it on Friday", Ctime: started.Add(5 * time.Minute)},
+		{Author: "bob", Note: "I'll write the \"release\" notes", Ctime: started.Add(12 * time.Minute)},
+	}
+	require.Equal(t, `:memo: Notes from *standup* started Wed Apr 29 at 2:00pm UTC:
+• 2:05pm @alice: ship it on Friday
+• 2:12pm @bob: I'll write the "release" notes`,
+		meetbot.FormatNotes(meetbot.Meeting{Title: "standup", Started: started}, notes, time.UTC))
+	require.Equal(t, ":memo: Notes from the meeting started Wed Apr 29 at 2:00pm UTC:",
+		meetbot.FormatNotes(meetbot.Meeting{Started: started}, nil, time.UTC))
+}
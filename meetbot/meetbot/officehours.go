@@ -0,0 +1,320 @@
+package meetbot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// office hours can't be open longer than this
+const maxOfficeHours = 12 * time.Hour
+
+// FormatQueue lists who is waiting, first in line first.
+func FormatQueue(usernames []string) string {
+	if len(usernames) == 0 {
+		return "Nobody is waiting."
+	}
+	lines := []string{"Waiting:"}
+	for i, username := range usernames {
+		lines = append(lines, fmt.Sprintf("%d. @%s", i+1, username))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *Handler) officeHoursCommands() []base.Command {
+	return []base.Command{
+		{
+			Name:        "officehours",
+			Description: "Show who is holding office hours in this conversation and who is waiting",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleOfficeHours(msg)
+			},
+		},
+		{
+			Name:        "officehours open",
+			Usage:       "<duration>",
+			Description: "Hold office hours in this conversation, seeing people one at a time in a meeting",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleOfficeHoursOpen(msg, args.Positional[0])
+			},
+		},
+		{
+			Name:        "officehours next",
+			Description: "Done with the current person, send the next one in",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleOfficeHoursNext(msg)
+			},
+		},
+		{
+			Name:        "officehours close",
+			Description: "Stop holding office hours in this conversation",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleOfficeHoursClose(msg)
+			},
+		},
+		{
+			Name:        "queue",
+			Description: "Wait in line for the office hours held in this conversation",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleQueue(msg)
+			},
+		},
+		{
+			Name:        "queue leave",
+			Description: "Stop waiting in line for the office hours held in this conversation",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleQueueLeave(msg)
+			},
+		},
+	}
+}
+
+// getOfficeHours returns the office hours open in a conversation, or nil
+// after telling the sender there are none.
+func (h *Handler) getOfficeHours(msg chat1.MsgSummary) (*OfficeHours, error) {
+	officeHours, err := h.db.GetOfficeHours(msg.ConvID)
+	if err != nil {
+		return nil, err
+	} else if officeHours == nil || !officeHours.Closes.After(time.Now()) {
+		h.ChatEcho(msg.ConvID, "Nobody is holding office hours here, open them with `!meet officehours open <duration>`.")
+		return nil, nil
+	}
+	return officeHours, nil
+}
+
+func (h *Handler) handleOfficeHours(msg chat1.MsgSummary) error {
+	officeHours, err := h.getOfficeHours(msg)
+	if err != nil || officeHours == nil {
+		return err
+	}
+	queue, err := h.db.GetOfficeHoursQueue(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	lines := []string{fmt.Sprintf("@%s is holding office hours until %s.", officeHours.Host,
+		officeHours.Closes.In(h.getTimezone(msg)).Format("3:04pm MST"))}
+	if officeHours.Current != "" {
+		lines = append(lines, fmt.Sprintf("Seeing @%s.", officeHours.Current))
+	}
+	lines = append(lines, FormatQueue(queue))
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return nil
+}
+
+func (h *Handler) handleOfficeHoursOpen(msg chat1.MsgSummary, arg string) error {
+	duration, err := time.ParseDuration(arg)
+	if err != nil || duration <= 0 || duration > maxOfficeHours {
+		h.ChatEcho(msg.ConvID, "I don't understand the duration %q, use one like `2h` or `45m`, up to %d hours.",
+			arg, int(maxOfficeHours.Hours()))
+		return nil
+	}
+	current, err := h.db.GetOfficeHours(msg.ConvID)
+	if err != nil {
+		return err
+	} else if current != nil && current.Host != msg.Sender.Username && current.Closes.After(time.Now()) {
+		h.ChatEcho(msg.ConvID, "@%s is already holding office hours here.", current.Host)
+		return nil
+	}
+	provider, err := h.getProvider(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	link, err := provider.CreateMeeting(msg, fmt.Sprintf("%s's office hours", msg.Sender.Username))
+	if err != nil {
+		return err
+	} else if link == "" {
+		h.ChatEcho(msg.ConvID, "I wasn't able to create a meeting, please try again.")
+		return nil
+	}
+	officeHours := OfficeHours{
+		ConvID: msg.ConvID,
+		Host:   msg.Sender.Username,
+		Link:   link,
+		Closes: time.Now().Add(duration),
+	}
+	if err := h.db.PutOfficeHours(officeHours); err != nil {
+		return err
+	}
+	h.stats.Count("officehours open")
+	h.ChatEcho(msg.ConvID, ":office: @%s is holding office hours until %s. Get in line with `!meet queue`, I'll send you the link when it's your turn.",
+		officeHours.Host, officeHours.Closes.In(h.getTimezone(msg)).Format("3:04pm MST"))
+	return nil
+}
+
+// advanceOfficeHours sends the next person in line to the host, if anybody
+// is waiting.
+func (h *Handler) advanceOfficeHours(officeHours OfficeHours) error {
+	next, err := h.db.PopOfficeHoursQueue(officeHours.ConvID)
+	if err != nil {
+		return err
+	}
+	if err := h.db.SetOfficeHoursCurrent(officeHours.ConvID, next); err != nil {
+		return err
+	}
+	if next == "" {
+		if _, err := h.kbc.SendMessageByTlfName(officeHours.Host,
+			"Nobody is waiting at your office hours, I'll tell you when somebody is."); err != nil {
+			h.Debug("advanceOfficeHours: unable to message host: %s", err)
+		}
+		return nil
+	}
+	h.stats.Count("officehours - next")
+	if _, err := h.kbc.SendMessageByTlfName(next, "It's your turn at @%s's office hours! Join: %s",
+		officeHours.Host, officeHours.Link); err != nil {
+		h.Debug("advanceOfficeHours: unable to message %s: %s", next, err)
+	}
+	if _, err := h.kbc.SendMessageByTlfName(officeHours.Host,
+		"@%s is joining your office hours. Send in the next person with `!meet officehours next` when you're done.",
+		next); err != nil {
+		h.Debug("advanceOfficeHours: unable to message host: %s", err)
+	}
+	h.ChatEcho(officeHours.ConvID, "@%s, you're up! I sent you the link.", next)
+	return nil
+}
+
+func (h *Handler) handleOfficeHoursNext(msg chat1.MsgSummary) error {
+	officeHours, err := h.getOfficeHours(msg)
+	if err != nil || officeHours == nil {
+		return err
+	}
+	sender := msg.Sender.Username
+	if sender != officeHours.Host && sender != officeHours.Current {
+		h.ChatEcho(msg.ConvID, "Only @%s or who they're seeing can move the line along.", officeHours.Host)
+		return nil
+	}
+	return h.advanceOfficeHours(*officeHours)
+}
+
+func (h *Handler) handleOfficeHoursClose(msg chat1.MsgSummary) error {
+	officeHours, err := h.getOfficeHours(msg)
+	if err != nil || officeHours == nil {
+		return err
+	}
+	if msg.Sender.Username != officeHours.Host {
+		h.ChatEcho(msg.ConvID, "Only @%s can close their office hours.", officeHours.Host)
+		return nil
+	}
+	return closeOfficeHours(h.DebugOutput, h.db, *officeHours)
+}
+
+// closeOfficeHours tells who is still waiting that they won't be seen.
+func closeOfficeHours(out *base.DebugOutput, db *DB, officeHours OfficeHours) error {
+	queue, err := db.GetOfficeHoursQueue(officeHours.ConvID)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteOfficeHours(officeHours.ConvID); err != nil {
+		return err
+	}
+	text := fmt.Sprintf(":office: @%s's office hours are over.", officeHours.Host)
+	if len(queue) > 0 {
+		var mentions []string
+		for _, username := range queue {
+			mentions = append(mentions, "@"+username)
+		}
+		text += fmt.Sprintf(" Sorry %s, catch them next time!", strings.Join(mentions, ", "))
+	}
+	out.ChatEcho(officeHours.ConvID, "%s", text)
+	return nil
+}
+
+func (h *Handler) handleQueue(msg chat1.MsgSummary) error {
+	officeHours, err := h.getOfficeHours(msg)
+	if err != nil || officeHours == nil {
+		return err
+	}
+	sender := msg.Sender.Username
+	switch sender {
+	case officeHours.Host:
+		h.ChatEcho(msg.ConvID, "You're the one holding office hours!")
+		return nil
+	case officeHours.Current:
+		h.ChatEcho(msg.ConvID, "It's already your turn, I sent you the link.")
+		return nil
+	}
+	if err := h.db.JoinOfficeHoursQueue(msg.ConvID, sender); err != nil {
+		return err
+	}
+	h.stats.Count("queue")
+	if officeHours.Current == "" {
+		return h.advanceOfficeHours(*officeHours)
+	}
+	queue, err := h.db.GetOfficeHoursQueue(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	for i, username := range queue {
+		if username == sender {
+			h.ChatEcho(msg.ConvID, "You're #%d in line, I'll send you the link when it's your turn.", i+1)
+			break
+		}
+	}
+	return nil
+}
+
+func (h *Handler) handleQueueLeave(msg chat1.MsgSummary) error {
+	officeHours, err := h.getOfficeHours(msg)
+	if err != nil || officeHours == nil {
+		return err
+	}
+	left, err := h.db.LeaveOfficeHoursQueue(msg.ConvID, msg.Sender.Username)
+	if err != nil {
+		return err
+	} else if !left {
+		h.ChatEcho(msg.ConvID, "You're not in line.")
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, "OK! You're out of the line.")
+	return nil
+}
+
+// OfficeHoursCloser closes office hours once their time is up, see
+// `!meet officehours open`.
+type OfficeHoursCloser struct {
+	*base.DebugOutput
+
+	stats *base.StatsRegistry
+	db    *DB
+}
+
+func NewOfficeHoursCloser(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB) *OfficeHoursCloser {
+	return &OfficeHoursCloser{
+		DebugOutput: base.NewDebugOutput("OfficeHoursCloser", debugConfig),
+		stats:       stats.SetPrefix("OfficeHoursCloser"),
+		db:          db,
+	}
+}
+
+// Task checks for office hours to close every minute.
+func (c *OfficeHoursCloser) Task() base.Task {
+	return base.Task{
+		Name:     "office-hours",
+		Schedule: "* * * * *",
+		Run:      c.closeDueOfficeHours,
+	}
+}
+
+func (c *OfficeHoursCloser) closeDueOfficeHours(ctx context.Context) error {
+	res, err := c.db.GetClosingOfficeHours()
+	if err != nil {
+		return fmt.Errorf("error getting closing office hours: %s", err)
+	}
+	for _, officeHours := range res {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := closeOfficeHours(c.DebugOutput, c.db, officeHours); err != nil {
+			c.Errorf("closeDueOfficeHours: unable to close %s: %s", officeHours.ConvID, err)
+			continue
+		}
+		c.stats.Count("closed")
+	}
+	return nil
+}
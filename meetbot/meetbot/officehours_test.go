@@ -0,0 +1,14 @@
+package meetbot_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/meetbot/meetbot"
+)
+
+func TestFormatQueue(t *testing.T) {
+	require.Equal(t, "Nobody is waiting.", meetbot.FormatQueue(nil))
+	require.Equal(t, "Waiting:\n1. @alice\n2. @bob", meetbot.FormatQueue([]string{"alice", "bob"}))
+}
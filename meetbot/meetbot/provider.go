@@ -0,0 +1,258 @@
+package meetbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// Names of the providers, as used by `!meet provider set`.
+const (
+	GoogleMeetProvider = "google"
+	ZoomProvider       = "zoom"
+	JitsiProvider      = "jitsi"
+)
+
+// providerSetting is the conversation setting holding the provider name.
+const providerSetting = "provider"
+
+// Provider creates meetings on a conferencing service.
+type Provider interface {
+	// Title is the name of the service shown in chat.
+	Title() string
+	// CreateMeeting returns the link to a new meeting, or "" if the service
+	// didn't create one. It returns base.OAuthRequiredError when the sender of
+	// msg has been asked to authorize the bot first.
+	CreateMeeting(msg chat1.MsgSummary, topic string) (link string, err error)
+}
+
+type googleMeetProvider struct {
+	h *Handler
+}
+
+func (p googleMeetProvider) Title() string {
+	return "Google Meet"
+}
+
+func (p googleMeetProvider) CreateMeeting(msg chat1.MsgSummary, topic string) (link string, err error) {
+	err = p.h.withCalendarService(msg, func(srv *calendar.Service) (err error) {
+		link, err = createMeetLink(srv)
+		return err
+	})
+	return link, err
+}
+
+const zoomInvalidTokenCode = 124
+
+type zoomAPIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e zoomAPIError) Error() string {
+	return e.Message
+}
+
+// zoomProvider creates instant Zoom meetings with the team's Zoom account,
+// which is authorized separately from its Google account.
+type zoomProvider struct {
+	kbc    *kbchat.API
+	db     *DB
+	config *oauth2.Config
+}
+
+// NewZoomOAuthConfig returns the Zoom OAuth app config, redirecting to
+// `/meetbot/zoom/oauth` on httpPrefix.
+func NewZoomOAuthConfig(clientID, clientSecret, httpPrefix string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://zoom.us/oauth/authorize",
+			TokenURL: "https://zoom.us/oauth/token",
+		},
+		RedirectURL: fmt.Sprintf("%s/meetbot/zoom/oauth", httpPrefix),
+		Scopes:      []string{"meeting:write"},
+	}
+}
+
+func zoomIdentifierFromMsg(msg chat1.MsgSummary) string {
+	return "zoom:" + base.IdentifierFromMsg(msg)
+}
+
+func (p zoomProvider) Title() string {
+	return "Zoom"
+}
+
+func (p zoomProvider) CreateMeeting(msg chat1.MsgSummary, topic string) (string, error) {
+	link, err := p.createMeeting(msg, topic)
+	if apiErr, ok := err.(zoomAPIError); ok && apiErr.Code == zoomInvalidTokenCode {
+		// retry auth after nuking stored credentials
+		if err := p.db.DeleteToken(zoomIdentifierFromMsg(msg)); err != nil {
+			return "", err
+		}
+		return p.createMeeting(msg, topic)
+	}
+	return link, err
+}
+
+func (p zoomProvider) createMeeting(msg chat1.MsgSummary, topic string) (string, error) {
+	client, err := base.GetOAuthClient(zoomIdentifierFromMsg(msg), msg, p.kbc, p.config, p.db,
+		base.GetOAuthOpts{
+			AuthMessageTemplate: "Authorize me to create Zoom meetings by clicking this link:\n%s",
+		})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"topic": topic,
+		// an instant meeting
+		"type": 1,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Post("https://api.zoom.us/v2/users/me/meetings", "application/json",
+		bytes.NewBuffer(payload))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusCreated {
+		var apiErr zoomAPIError
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Code == 0 {
+			return "", fmt.Errorf("createMeeting: statusCode: %d, error: %s", resp.StatusCode, data)
+		}
+		return "", apiErr
+	}
+	var meeting struct {
+		JoinURL string `json:"join_url"`
+	}
+	if err := json.Unmarshal(data, &meeting); err != nil {
+		return "", err
+	}
+	// strip protocol to skip unfurl prompt
+	return strings.TrimPrefix(meeting.JoinURL, "https://"), nil
+}
+
+// jitsiProvider makes up rooms on a Jitsi server, which creates them when
+// the first person joins, so it needs no account.
+type jitsiProvider struct {
+	baseURL string
+}
+
+func (p jitsiProvider) Title() string {
+	return "Jitsi"
+}
+
+func (p jitsiProvider) CreateMeeting(msg chat1.MsgSummary, topic string) (string, error) {
+	room, err := base.MakeRequestID()
+	if err != nil {
+		return "", err
+	}
+	// strip protocol to skip unfurl prompt, as for Google Meet links
+	return strings.TrimPrefix(fmt.Sprintf("%s/keybase-%s", strings.TrimSuffix(p.baseURL, "/"), room),
+		"https://"), nil
+}
+
+// SetZoom allows conversations to use Zoom, with the Zoom OAuth app config.
+func (h *Handler) SetZoom(config *oauth2.Config) {
+	h.providers[ZoomProvider] = zoomProvider{
+		kbc:    h.kbc,
+		db:     h.db,
+		config: config,
+	}
+}
+
+// SetJitsi allows conversations to use the Jitsi server at baseURL, e.g.
+// https://meet.jit.si.
+func (h *Handler) SetJitsi(baseURL string) {
+	h.providers[JitsiProvider] = jitsiProvider{baseURL: baseURL}
+}
+
+func (h *Handler) providerNames() (names []string) {
+	for name := range h.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// getProvider returns the provider a conversation chose, Google Meet unless
+// it chose one which isn't available anymore.
+func (h *Handler) getProvider(convID chat1.ConvIDStr) (Provider, error) {
+	name, err := h.settings.GetString(convID, providerSetting, GoogleMeetProvider)
+	if err != nil {
+		return nil, err
+	}
+	if provider, ok := h.providers[name]; ok {
+		return provider, nil
+	}
+	return h.providers[GoogleMeetProvider], nil
+}
+
+func (h *Handler) providerCommands() []base.Command {
+	return []base.Command{
+		{
+			Name:        "provider",
+			Description: "Show which service meetings of this conversation are on",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				provider, err := h.getProvider(msg.ConvID)
+				if err != nil {
+					return err
+				}
+				h.ChatEcho(msg.ConvID, "Meetings here are on %s. Change it with `!meet provider set <%s>`.",
+					provider.Title(), strings.Join(h.providerNames(), "|"))
+				return nil
+			},
+		},
+		{
+			Name:        "provider set",
+			Usage:       "<google|zoom|jitsi>",
+			Description: "Choose the service meetings of this conversation are on",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleProviderSet(msg, strings.ToLower(args.Positional[0]))
+			},
+		},
+	}
+}
+
+func (h *Handler) handleProviderSet(msg chat1.MsgSummary, name string) error {
+	provider, ok := h.providers[name]
+	if !ok {
+		h.ChatEcho(msg.ConvID, "I can't create meetings on %q, try one of: %s.", name,
+			strings.Join(h.providerNames(), ", "))
+		return nil
+	}
+	isAllowed, err := base.IsAtLeastWriter(h.kbc, msg.Sender.Username, msg.Channel)
+	if err != nil {
+		return err
+	} else if !isAllowed {
+		h.ChatEcho(msg.ConvID, "You must be at least a writer to change where meetings are.")
+		return nil
+	}
+	if err := h.settings.Set(msg.ConvID, providerSetting, name); err != nil {
+		return err
+	}
+	h.stats.Count("provider set - " + name)
+	h.ChatEcho(msg.ConvID, "OK! Meetings here are on %s from now on.", provider.Title())
+	return nil
+}
@@ -0,0 +1,351 @@
+package meetbot
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+const dayFormat = "2006-01-02"
+
+// a meeting the bot was too late for, e.g. because it was down, isn't posted
+const maxRecurringMeetingDelay = 15 * time.Minute
+
+var dayShorthands = map[string]string{
+	"daily":    "*",
+	"everyday": "*",
+	"weekdays": "1-5",
+	"weekends": "0,6",
+}
+
+// ParseDays parses `daily`, `weekdays`, `weekends` or weekdays separated by
+// commas such as `mon,wed,fri` into the day of week field of a cron schedule.
+func ParseDays(s string) (days string, ok bool) {
+	s = strings.ToLower(s)
+	if days, ok := dayShorthands[s]; ok {
+		return days, true
+	}
+	var numbers []string
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+			full := strings.ToLower(weekday.String())
+			if name == full || name == full[:3] {
+				numbers = append(numbers, fmt.Sprint(int(weekday)))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "", false
+		}
+	}
+	return strings.Join(numbers, ","), true
+}
+
+func formatDays(days string) string {
+	for name, shorthand := range dayShorthands {
+		if days == shorthand && name != "everyday" {
+			return name
+		}
+	}
+	var names []string
+	for _, number := range strings.Split(days, ",") {
+		weekday, err := strconv.Atoi(number)
+		if err != nil {
+			return days
+		}
+		names = append(names, time.Weekday(weekday).String()[:3])
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatTimeOfDay(hour, minute int) string {
+	return time.Date(2000, 1, 1, hour, minute, 0, 0, time.UTC).Format("3:04pm")
+}
+
+// nextRun returns the first time after now the meeting is due.
+func (m RecurringMeeting) nextRun(now time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(m.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	schedule, err := base.ParseCron(fmt.Sprintf("%d %d * * %s", m.Minute, m.Hour, m.Days))
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(now.In(loc))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("recurring meeting %q never runs", m.Name)
+	}
+	return next, nil
+}
+
+// day is the date of the meeting's next run in its own timezone.
+func (m RecurringMeeting) day() string {
+	if loc, err := time.LoadLocation(m.Timezone); err == nil {
+		return m.NextRun.In(loc).Format(dayFormat)
+	}
+	return m.NextRun.UTC().Format(dayFormat)
+}
+
+// RecurringScheduler posts the links of recurring meetings when they're due,
+// see `!meet recurring`.
+type RecurringScheduler struct {
+	*base.DebugOutput
+
+	stats *base.StatsRegistry
+	db    *DB
+}
+
+func NewRecurringScheduler(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB) *RecurringScheduler {
+	return &RecurringScheduler{
+		DebugOutput: base.NewDebugOutput("RecurringScheduler", debugConfig),
+		stats:       stats.SetPrefix("RecurringScheduler"),
+		db:          db,
+	}
+}
+
+// Task checks for due meetings every minute.
+func (s *RecurringScheduler) Task() base.Task {
+	return base.Task{
+		Name:     "recurring-meetings",
+		Schedule: "* * * * *",
+		Run:      s.postDueMeetings,
+	}
+}
+
+func (s *RecurringScheduler) postDueMeetings(ctx context.Context) error {
+	meetings, err := s.db.GetDueRecurringMeetings()
+	if err != nil {
+		return fmt.Errorf("error getting due meetings: %s", err)
+	}
+	now := time.Now()
+	for _, meeting := range meetings {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := s.postMeeting(meeting, now); err != nil {
+			s.Errorf("postDueMeetings: unable to post %q: %s", meeting.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *RecurringScheduler) postMeeting(meeting RecurringMeeting, now time.Time) error {
+	// move on to the next run first so a failure doesn't post twice
+	nextRun, err := meeting.nextRun(now)
+	if err != nil {
+		return err
+	}
+	if err := s.db.UpdateRecurringMeetingNextRun(meeting.ConvID, meeting.Name, nextRun); err != nil {
+		return err
+	}
+	day := meeting.day()
+	if err := s.db.DeleteRecurringMeetingSkipsBefore(meeting.ConvID, day); err != nil {
+		return err
+	}
+	skipped, err := s.db.IsRecurringMeetingSkipped(meeting.ConvID, meeting.Name, day)
+	if err != nil {
+		return err
+	}
+	switch {
+	case skipped:
+		s.stats.Count("postMeeting - skipped")
+		return nil
+	case now.Sub(meeting.NextRun) > maxRecurringMeetingDelay:
+		s.stats.Count("postMeeting - late")
+		return nil
+	}
+	s.ChatEcho(meeting.ConvID, ":calendar: Time for *%s*! Join: %s", meeting.Name, meeting.Link)
+	s.stats.Count("postMeeting - sent")
+	return startMeeting(s.DebugOutput, s.db, Meeting{
+		ConvID:  meeting.ConvID,
+		Title:   meeting.Name,
+		Link:    meeting.Link,
+		Started: now,
+	})
+}
+
+const recurringUsage = "<name> <daily|weekdays|weekends|mon,wed,...> <time>"
+
+func (h *Handler) recurringCommands() []base.Command {
+	return []base.Command{
+		{
+			Name:        "recurring",
+			Usage:       recurringUsage,
+			Description: "Post the link to a standing meeting in this conversation at the same time every day or week",
+			MinArgs:     3,
+			MaxArgs:     3,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleRecurring(msg, args.Positional)
+			},
+		},
+		{
+			Name:        "recurring list",
+			Description: "List the recurring meetings of this conversation",
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleRecurringList(msg)
+			},
+		},
+		{
+			Name:        "recurring remove",
+			Usage:       "<name>",
+			Description: "Stop posting a recurring meeting",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleRecurringRemove(msg, args.Positional[0])
+			},
+		},
+		{
+			Name:        "recurring skip",
+			Usage:       "<name> [yyyy-mm-dd]",
+			Description: "Skip the next meeting, or the one on a given day",
+			MinArgs:     1,
+			MaxArgs:     2,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleRecurringSkip(msg, args.Positional)
+			},
+		},
+		{
+			Name:        "recurring holiday",
+			Usage:       "<yyyy-mm-dd>",
+			Description: "Skip every recurring meeting of this conversation on a day",
+			MinArgs:     1,
+			MaxArgs:     1,
+			Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+				return h.handleRecurringHoliday(msg, args.Positional[0])
+			},
+		},
+	}
+}
+
+func (h *Handler) handleRecurring(msg chat1.MsgSummary, args []string) error {
+	name := args[0]
+	days, ok := ParseDays(args[1])
+	if !ok {
+		h.ChatEcho(msg.ConvID, "I don't know which days %q are.\nUsage: `!meet recurring %s`", args[1], recurringUsage)
+		return nil
+	}
+	hour, minute, ok := ParseTimeOfDay(args[2])
+	if !ok {
+		h.ChatEcho(msg.ConvID, "I don't understand the time %q, use one like `9:30am` or `14:00`.", args[2])
+		return nil
+	}
+	provider, err := h.getProvider(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	loc := h.getTimezone(msg)
+	link, err := provider.CreateMeeting(msg, name)
+	if err != nil {
+		return err
+	} else if link == "" {
+		h.ChatEcho(msg.ConvID, "I wasn't able to create a meeting, please try again.")
+		return nil
+	}
+	meeting := RecurringMeeting{
+		ConvID:   msg.ConvID,
+		Name:     name,
+		Days:     days,
+		Hour:     hour,
+		Minute:   minute,
+		Timezone: loc.String(),
+		Link:     link,
+		Creator:  msg.Sender.Username,
+	}
+	if meeting.NextRun, err = meeting.nextRun(time.Now()); err != nil {
+		return err
+	}
+	if err := h.db.PutRecurringMeeting(meeting); err != nil {
+		return err
+	}
+	h.stats.Count("recurring")
+	h.ChatEcho(msg.ConvID, "OK! I'll post the link to *%s* here %s at %s (%s), next on %s.\nJoin: %s",
+		name, formatDays(days), formatTimeOfDay(hour, minute), loc, meeting.NextRun.Format("Mon Jan 2"), link)
+	return nil
+}
+
+func (h *Handler) handleRecurringList(msg chat1.MsgSummary) error {
+	meetings, err := h.db.GetRecurringMeetingsForConv(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	if len(meetings) == 0 {
+		h.ChatEcho(msg.ConvID, "There are no recurring meetings here, add one with `!meet recurring %s`.",
+			recurringUsage)
+		return nil
+	}
+	skips, err := h.db.GetRecurringMeetingSkips(msg.ConvID, time.Now().UTC().AddDate(0, 0, -1).Format(dayFormat))
+	if err != nil {
+		return err
+	}
+	lines := []string{"Recurring meetings:"}
+	for _, meeting := range meetings {
+		line := fmt.Sprintf("• *%s* %s at %s (%s): %s", meeting.Name, formatDays(meeting.Days),
+			formatTimeOfDay(meeting.Hour, meeting.Minute), meeting.Timezone, meeting.Link)
+		if days := skips[meeting.Name]; len(days) > 0 {
+			line += fmt.Sprintf(", skipping %s", strings.Join(days, ", "))
+		}
+		lines = append(lines, line)
+	}
+	if days := skips[""]; len(days) > 0 {
+		lines = append(lines, fmt.Sprintf("Holidays: %s", strings.Join(days, ", ")))
+	}
+	h.ChatEcho(msg.ConvID, "%s", strings.Join(lines, "\n"))
+	return nil
+}
+
+func (h *Handler) handleRecurringRemove(msg chat1.MsgSummary, name string) error {
+	deleted, err := h.db.DeleteRecurringMeeting(msg.ConvID, name)
+	if err != nil {
+		return err
+	} else if !deleted {
+		h.ChatEcho(msg.ConvID, "There's no recurring meeting called %q here.", name)
+		return nil
+	}
+	h.ChatEcho(msg.ConvID, "OK! I won't post *%s* anymore.", name)
+	return nil
+}
+
+func (h *Handler) handleRecurringSkip(msg chat1.MsgSummary, args []string) error {
+	meeting, err := h.db.GetRecurringMeeting(msg.ConvID, args[0])
+	if err != nil {
+		return err
+	} else if meeting == nil {
+		h.ChatEcho(msg.ConvID, "There's no recurring meeting called %q here.", args[0])
+		return nil
+	}
+	day := meeting.day()
+	if len(args) > 1 {
+		if _, err := time.Parse(dayFormat, args[1]); err != nil {
+			h.ChatEcho(msg.ConvID, "I don't understand the day %q, use one like `2020-12-24`.", args[1])
+			return nil
+		}
+		day = args[1]
+	}
+	if err := h.db.AddRecurringMeetingSkip(msg.ConvID, meeting.Name, day); err != nil {
+		return err
+	}
+	h.ChatEcho(msg.ConvID, "OK! I'll skip *%s* on %s.", meeting.Name, day)
+	return nil
+}
+
+func (h *Handler) handleRecurringHoliday(msg chat1.MsgSummary, day string) error {
+	if _, err := time.Parse(dayFormat, day); err != nil {
+		h.ChatEcho(msg.ConvID, "I don't understand the day %q, use one like `2020-12-24`.", day)
+		return nil
+	}
+	if err := h.db.AddRecurringMeetingSkip(msg.ConvID, "", day); err != nil {
+		return err
+	}
+	h.ChatEcho(msg.ConvID, "OK! I won't post any recurring meeting here on %s.", day)
+	return nil
+}
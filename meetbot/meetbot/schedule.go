@@ -0,0 +1,155 @@
+package meetbot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMeetingDuration is how long meetings last unless told otherwise.
+const DefaultMeetingDuration = 30 * time.Minute
+
+// Schedule is a meeting requested with `!meet schedule`.
+type Schedule struct {
+	Title    string
+	Start    time.Time
+	Duration time.Duration
+	// Attendees are Keybase usernames, without the @
+	Attendees []string
+}
+
+// ScheduleError is a mistake in the arguments of `!meet schedule` which is
+// explained to the sender as is.
+type ScheduleError string
+
+func (e ScheduleError) Error() string { return string(e) }
+
+var timeOfDayRegexp = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+
+// ParseTimeOfDay parses times like `2pm`, `9:30am` or `14:00`. A bare hour
+// needs am or pm so it isn't mistaken for something else.
+func ParseTimeOfDay(s string) (hour, minute int, ok bool) {
+	match := timeOfDayRegexp.FindStringSubmatch(strings.ToLower(s))
+	if match == nil || (match[2] == "" && match[3] == "") {
+		return 0, 0, false
+	}
+	hour, _ = strconv.Atoi(match[1])
+	if match[2] != "" {
+		minute, _ = strconv.Atoi(match[2])
+	}
+	switch match[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, false
+		}
+		hour %= 12
+		if match[3] == "pm" {
+			hour += 12
+		}
+	default:
+		if hour > 23 {
+			return 0, 0, false
+		}
+	}
+	if minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// parseDay finds the date meant by `today`, `tomorrow`, a weekday name or a
+// `2006-01-02` date, relative to now. A weekday is the next one to come,
+// which is today only if the meeting is still ahead, see ParseSchedule.
+func parseDay(s string, now time.Time) (day time.Time, isWeekday bool, ok bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	s = strings.ToLower(s)
+	switch s {
+	case "today":
+		return today, false, true
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), false, true
+	}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if s == name || s == name[:3] {
+			return today.AddDate(0, 0, (int(weekday)-int(now.Weekday())+7)%7), true, true
+		}
+	}
+	if date, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return date, false, true
+	}
+	return time.Time{}, false, false
+}
+
+// ParseSchedule parses the arguments of `!meet schedule`, i.e.
+// `"<title>" [day] <time> [duration] [@attendee...]`, in now's location. The
+// day defaults to today and the duration to DefaultMeetingDuration.
+func ParseSchedule(args []string, now time.Time) (*Schedule, error) {
+	return ParseScheduleWithDuration(args, now, DefaultMeetingDuration)
+}
+
+// ParseScheduleWithDuration is ParseSchedule with the duration defaulting to
+// duration, e.g. the one a conversation chose.
+func ParseScheduleWithDuration(args []string, now time.Time, duration time.Duration) (*Schedule, error) {
+	if len(args) == 0 || strings.TrimSpace(args[0]) == "" {
+		return nil, ScheduleError("The meeting needs a title.")
+	}
+	schedule := &Schedule{
+		Title:    args[0],
+		Duration: duration,
+	}
+	var day time.Time
+	var hasDay, hasTime, hasDuration, isWeekday bool
+	var hour, minute int
+	for _, arg := range args[1:] {
+		if strings.HasPrefix(arg, "@") {
+			if username := strings.TrimPrefix(arg, "@"); username != "" {
+				schedule.Attendees = append(schedule.Attendees, username)
+			}
+			continue
+		}
+		if d, weekday, ok := parseDay(arg, now); ok && !hasDay {
+			day, isWeekday, hasDay = d, weekday, true
+			continue
+		}
+		if h, m, ok := ParseTimeOfDay(arg); ok && !hasTime {
+			hour, minute, hasTime = h, m, true
+			continue
+		}
+		if duration, err := time.ParseDuration(arg); err == nil && !hasDuration {
+			if duration <= 0 || duration > 24*time.Hour {
+				return nil, ScheduleError(fmt.Sprintf("A meeting can't last %s.", arg))
+			}
+			schedule.Duration, hasDuration = duration, true
+			continue
+		}
+		return nil, ScheduleError(fmt.Sprintf("I don't understand %q.", arg))
+	}
+	if !hasTime {
+		return nil, ScheduleError("When should the meeting start? Use a time like `2pm` or `14:30`.")
+	}
+	if !hasDay {
+		day, _, _ = parseDay("today", now)
+	}
+	schedule.Start = time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+	if isWeekday && !schedule.Start.After(now) {
+		schedule.Start = schedule.Start.AddDate(0, 0, 7)
+	}
+	if !schedule.Start.After(now) {
+		return nil, ScheduleError("That's in the past, pick a time that's still to come.")
+	}
+	return schedule, nil
+}
+
+// FormatMeetingTime formats a meeting's start and end like
+// `Thu Oct 15 2:00pm - 2:45pm (EDT)`.
+func FormatMeetingTime(start time.Time, duration time.Duration) string {
+	end := start.Add(duration)
+	endFormat := "3:04pm"
+	if end.YearDay() != start.YearDay() || end.Year() != start.Year() {
+		endFormat = "Mon Jan 2 3:04pm"
+	}
+	return fmt.Sprintf("%s - %s (%s)", start.Format("Mon Jan 2 3:04pm"), end.Format(endFormat), start.Format("MST"))
+}
@@ -0,0 +1,98 @@
+package meetbot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/meetbot/meetbot"
+)
+
+func TestParseSchedule(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	// a Wednesday
+	now := time.Date(2020, time.April, 29, 10, 0, 0, 0, loc)
+
+	t.Run("day, time, duration and attendees", func(t *testing.T) {
+		schedule, err := meetbot.ParseSchedule(
+			[]string{"Design review", "tomorrow", "2pm", "45m", "@alice", "@bob"}, now)
+		require.NoError(t, err)
+		require.Equal(t, "Design review", schedule.Title)
+		require.Equal(t, time.Date(2020, time.April, 30, 14, 0, 0, 0, loc), schedule.Start)
+		require.Equal(t, 45*time.Minute, schedule.Duration)
+		require.Equal(t, []string{"alice", "bob"}, schedule.Attendees)
+	})
+
+	t.Run("defaults to today and 30 minutes", func(t *testing.T) {
+		schedule, err := meetbot.ParseSchedule([]string{"Sync", "14:30"}, now)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2020, time.April, 29, 14, 30, 0, 0, loc), schedule.Start)
+		require.Equal(t, meetbot.DefaultMeetingDuration, schedule.Duration)
+	})
+
+	t.Run("duration defaults to the conversation's", func(t *testing.T) {
+		schedule, err := meetbot.ParseScheduleWithDuration([]string{"Sync", "14:30"}, now, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, time.Hour, schedule.Duration)
+
+		schedule, err = meetbot.ParseScheduleWithDuration([]string{"Sync", "14:30", "15m"}, now, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, 15*time.Minute, schedule.Duration)
+	})
+
+	t.Run("weekdays are the next one to come", func(t *testing.T) {
+		schedule, err := meetbot.ParseSchedule([]string{"1:1", "fri", "10:30am"}, now)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2020, time.May, 1, 10, 30, 0, 0, loc), schedule.Start)
+
+		schedule, err = meetbot.ParseSchedule([]string{"1:1", "wednesday", "9am"}, now)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2020, time.May, 6, 9, 0, 0, 0, loc), schedule.Start)
+	})
+
+	t.Run("dates", func(t *testing.T) {
+		schedule, err := meetbot.ParseSchedule([]string{"Planning", "2020-05-12", "12pm", "1h30m"}, now)
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2020, time.May, 12, 12, 0, 0, 0, loc), schedule.Start)
+		require.Equal(t, 90*time.Minute, schedule.Duration)
+	})
+
+	t.Run("mistakes", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"No time", "tomorrow"},
+			{"Bare hour", "tomorrow", "2"},
+			{"Past", "today", "9am"},
+			{"Gibberish", "tomorrow", "2pm", "soon"},
+			{"Too long", "tomorrow", "2pm", "25h"},
+		} {
+			_, err := meetbot.ParseSchedule(args, now)
+			require.IsType(t, meetbot.ScheduleError(""), err, "%v", args)
+		}
+	})
+}
+
+func TestFormatMeetingTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	start := time.Date(2020, time.April, 30, 14, 0, 0, 0, loc)
+	require.Equal(t, "Thu Apr 30 2:00pm - 2:45pm (EDT)", meetbot.FormatMeetingTime(start, 45*time.Minute))
+	require.Equal(t, "Thu Apr 30 2:00pm - Fri May 1 2:00am (EDT)", meetbot.FormatMeetingTime(start, 12*time.Hour))
+}
+
+func TestParseDays(t *testing.T) {
+	for input, expected := range map[string]string{
+		"weekdays":    "1-5",
+		"Daily":       "*",
+		"weekends":    "0,6",
+		"mon,wed,fri": "1,3,5",
+		"tuesday":     "2",
+	} {
+		days, ok := meetbot.ParseDays(input)
+		require.True(t, ok, input)
+		require.Equal(t, expected, days, input)
+	}
+	_, ok := meetbot.ParseDays("mon,someday")
+	require.False(t, ok)
+}
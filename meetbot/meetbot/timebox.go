@@ -0,0 +1,220 @@
+package meetbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/go-keybase-chat-bot/kbchat"
+	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
+
+	"github.com/keybase/managed-bots/base"
+)
+
+// how long before the end of a timeboxed meeting it's announced as near
+const timeboxWarning = 5 * time.Minute
+
+// a timebox which ended this long ago, e.g. while the bot was down, isn't
+// announced
+const maxTimeboxReminderDelay = 15 * time.Minute
+
+const nowUsage = `"<title>" ["<agenda item>"...]`
+
+func formatMinutes(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	if minutes == 1 {
+		return "1 minute"
+	}
+	return fmt.Sprintf("%d minutes", minutes)
+}
+
+// FormatAgenda formats the agenda of a meeting started with `!meet now`,
+// with times in loc.
+func FormatAgenda(meeting Meeting, items []string, starter string, loc *time.Location) string {
+	lines := []string{fmt.Sprintf(":clipboard: *%s*", meeting.Title)}
+	started := meeting.Started.In(loc).Format("3:04pm MST")
+	if meeting.TimeboxEnd != nil {
+		lines = append(lines, fmt.Sprintf("Started by @%s at %s, timeboxed to %s (until %s)", starter, started,
+			formatMinutes(meeting.TimeboxEnd.Sub(meeting.Started)), meeting.TimeboxEnd.In(loc).Format("3:04pm")))
+	} else {
+		lines = append(lines, fmt.Sprintf("Started by @%s at %s", starter, started))
+	}
+	if len(items) > 0 {
+		lines = append(lines, "Agenda:")
+		for i, item := range items {
+			lines = append(lines, fmt.Sprintf("%d. %s", i+1, item))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("Join: %s", meeting.Link),
+		"Take notes with `!meet note <text>` and wrap up with `!meet end`.")
+	return strings.Join(lines, "\n")
+}
+
+type pinArg struct {
+	Method string `json:"method"`
+	Params struct {
+		Options struct {
+			ConversationID chat1.ConvIDStr `json:"conversation_id"`
+			MessageID      chat1.MessageID `json:"message_id"`
+		} `json:"options"`
+	} `json:"params"`
+}
+
+type pinRes struct {
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// pinMessage pins a message to the top of its conversation, which the chat
+// client has no method for.
+func pinMessage(kbc *kbchat.API, convID chat1.ConvIDStr, msgID chat1.MessageID) error {
+	var arg pinArg
+	arg.Method = "pin"
+	arg.Params.Options.ConversationID = convID
+	arg.Params.Options.MessageID = msgID
+	input, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	output, err := kbc.Command("chat", "api", "-m", string(input)).Output()
+	if err != nil {
+		return fmt.Errorf("unable to pin: %v", err)
+	}
+	var res pinRes
+	if err := json.Unmarshal(output, &res); err != nil {
+		return fmt.Errorf("invalid pin response: %v", err)
+	} else if res.Error != nil {
+		return fmt.Errorf("unable to pin: %s", res.Error.Message)
+	}
+	return nil
+}
+
+func (h *Handler) nowCommand() base.Command {
+	return base.Command{
+		Name:        "now",
+		Usage:       nowUsage,
+		Description: "Start a meeting with an agenda pinned to this conversation",
+		Flags: []base.CommandFlag{
+			{
+				Name:        "timebox",
+				Type:        base.DurationCommandFlag,
+				Description: "Warn 5 minutes before the meeting should be over, and once it is, e.g. 30m",
+			},
+		},
+		MinArgs: 1,
+		MaxArgs: -1,
+		Handler: func(msg chat1.MsgSummary, args *base.CommandArgs) error {
+			return h.handleNow(msg, args.Positional[0], args.Positional[1:], args.Duration("timebox"))
+		},
+	}
+}
+
+func (h *Handler) handleNow(msg chat1.MsgSummary, title string, items []string, timebox time.Duration) error {
+	if timebox < 0 || timebox > maxMeetingLength {
+		h.ChatEcho(msg.ConvID, "The timebox must be at most %d hours.", int(maxMeetingLength.Hours()))
+		return nil
+	}
+	provider, err := h.getProvider(msg.ConvID)
+	if err != nil {
+		return err
+	}
+	link, err := provider.CreateMeeting(msg, title)
+	if err != nil {
+		return err
+	} else if link == "" {
+		h.ChatEcho(msg.ConvID, "I wasn't able to create a meeting, please try again.")
+		return nil
+	}
+
+	meeting := Meeting{
+		ConvID:  msg.ConvID,
+		Title:   title,
+		Link:    link,
+		Started: time.Now(),
+	}
+	if timebox > 0 {
+		end := meeting.Started.Add(timebox)
+		meeting.TimeboxEnd = &end
+	}
+	res, err := h.kbc.SendMessageByConvID(msg.ConvID, "%s",
+		FormatAgenda(meeting, items, msg.Sender.Username, h.getTimezone(msg)))
+	if err != nil {
+		return err
+	}
+	if res.Result.MessageID != nil {
+		if err := pinMessage(h.kbc, msg.ConvID, *res.Result.MessageID); err != nil {
+			h.Debug("handleNow: unable to pin agenda: %s", err)
+		}
+	}
+	h.stats.Count("now")
+	return startMeeting(h.DebugOutput, h.db, meeting)
+}
+
+// TimeboxReminder announces when timeboxed meetings are about to be over, and
+// when they are, see `!meet now`.
+type TimeboxReminder struct {
+	*base.DebugOutput
+
+	stats *base.StatsRegistry
+	db    *DB
+}
+
+func NewTimeboxReminder(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB) *TimeboxReminder {
+	return &TimeboxReminder{
+		DebugOutput: base.NewDebugOutput("TimeboxReminder", debugConfig),
+		stats:       stats.SetPrefix("TimeboxReminder"),
+		db:          db,
+	}
+}
+
+// Task checks for timeboxes ending every minute.
+func (r *TimeboxReminder) Task() base.Task {
+	return base.Task{
+		Name:     "meeting-timeboxes",
+		Schedule: "* * * * *",
+		Run:      r.remindEndingMeetings,
+	}
+}
+
+func (r *TimeboxReminder) remindEndingMeetings(ctx context.Context) error {
+	now := time.Now()
+	meetings, err := r.db.GetTimeboxedMeetingsEndingBefore(now.Add(timeboxWarning))
+	if err != nil {
+		return fmt.Errorf("error getting ending meetings: %s", err)
+	}
+	for _, meeting := range meetings {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := r.remindMeeting(meeting, now); err != nil {
+			r.Errorf("remindEndingMeetings: unable to remind %q: %s", meeting.Title, err)
+		}
+	}
+	return nil
+}
+
+func (r *TimeboxReminder) remindMeeting(meeting Meeting, now time.Time) error {
+	left := meeting.TimeboxEnd.Sub(now)
+	switch {
+	case left <= 0:
+		if err := r.db.ClearMeetingTimebox(meeting.ConvID); err != nil {
+			return err
+		}
+		if -left > maxTimeboxReminderDelay {
+			r.stats.Count("remindMeeting - late")
+			return nil
+		}
+		r.ChatEcho(meeting.ConvID, ":alarm_clock: Time's up for *%s*! Wrap up with `!meet end`.", meeting.Title)
+		r.stats.Count("remindMeeting - over")
+	case !meeting.TimeboxWarned:
+		if err := r.db.SetMeetingTimeboxWarned(meeting.ConvID); err != nil {
+			return err
+		}
+		r.ChatEcho(meeting.ConvID, ":hourglass: %s left for *%s*.", formatMinutes(left), meeting.Title)
+		r.stats.Count("remindMeeting - warned")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package meetbot_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/keybase/managed-bots/meetbot/meetbot"
+)
+
+func TestFormatAgenda(t *testing.T) {
+	started := time.Date(2020, time.April, 29, 14, 0, 0, 0, time.UTC)
+	end := started.Add(30 * time.Minute)
+	meeting := meetbot.Meeting{
+		Title:      "Incident triage",
+		Link:       "meet.jit.si/keybase-abc",
+		Started:    started,
+		TimeboxEnd: &end,
+	}
+	require.Equal(t, ":clipboard: *Incident triage*\n"+
+		"Started by @alice at 2:00pm UTC, timeboxed to 30 minutes (until 2:30pm)\n"+
+		"Agenda:\n"+
+		"1. What broke\n"+
+		"2. Who's on it\n"+
+		"Join: meet.jit.si/keybase-abc\n"+
+		"Take notes with `!meet note <text>` and wrap up with `!meet end`.",
+		meetbot.FormatAgenda(meeting, []string{"What broke", "Who's on it"}, "alice", time.UTC))
+
+	meeting.TimeboxEnd = nil
+	require.Equal(t, ":clipboard: *Incident triage*\n"+
+		"Started by @alice at 2:00pm UTC\n"+
+		"Join: meet.jit.si/keybase-abc\n"+
+		"Take notes with `!meet note <text>` and wrap up with `!meet end`.",
+		meetbot.FormatAgenda(meeting, nil, "alice", time.UTC))
+}
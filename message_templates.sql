@@ -0,0 +1,6 @@
+CREATE TABLE `message_templates` (
+  `name` varchar(128) NOT NULL,
+  `body` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
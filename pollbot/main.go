@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"flag"
 	"fmt"
 	"os"
@@ -11,7 +9,6 @@ import (
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/pollbot/pollbot"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
@@ -81,27 +78,36 @@ func (s *BotServer) getLoginSecret() (secret string, err error) {
 	if s.opts.LoginSecret != "" {
 		return s.opts.LoginSecret, nil
 	}
-	path := fmt.Sprintf("/keybase/private/%s/login.secret", s.kbc.GetUsername())
-	cmd := s.opts.Command("fs", "read", path)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", path)
-	if err := cmd.Run(); err != nil {
+	secrets, err := s.opts.SecretsProvider(fmt.Sprintf("/keybase/private/%s", s.kbc.GetUsername()))
+	if err != nil {
 		return "", err
 	}
-	return out.String(), nil
+	out, err := secrets.GetSecret("login.secret")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	s.Configure(s.opts.Options)
+	sdb, err := base.OpenDB(s.opts.DSN)
 	if err != nil {
-		s.Errorf("failed to connect to MySQL: %s", err)
+		s.Errorf("failed to connect to database: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
+	if s.opts.Migrate {
+		if err := sdb.Migrate(pollbot.Migrations); err != nil {
+			s.Errorf("failed to migrate database: %s", err)
+			return err
+		}
+	}
 	db := pollbot.NewDB(sdb)
 
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
@@ -117,13 +123,18 @@ func (s *BotServer) Go() (err error) {
 	}
 	stats = stats.SetPrefix(s.Name())
 	httpSrv := pollbot.NewHTTPSrv(stats, s.kbc, debugConfig, db, loginSecret)
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
 	handler := pollbot.NewHandler(stats, s.kbc, debugConfig, httpSrv, db, s.opts.HTTPPrefix)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -18,20 +18,75 @@ type DB struct {
 	*base.DB
 }
 
-func NewDB(db *sql.DB) *DB {
+func NewDB(db *base.DB) *DB {
 	return &DB{
-		DB: base.NewDB(db),
+		DB: db,
 	}
 }
 
+var Migrations = []base.Migration{
+	{
+		ID: "pollbot-1",
+		Statements: map[base.Dialect][]string{
+			base.MySQLDialect: {`
+				CREATE TABLE IF NOT EXISTS polls (
+					id varchar(16) NOT NULL,
+					conv_id varchar(100) NOT NULL,
+					msg_id int(11) NOT NULL,
+					result_msg_id int(11) NOT NULL,
+					choices int(11) NOT NULL,
+					PRIMARY KEY (id)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8`, `
+				CREATE TABLE IF NOT EXISTS votes (
+					id varchar(16) NOT NULL,
+					username varchar(50) NOT NULL,
+					choice int(11) NOT NULL,
+					PRIMARY KEY (id, username)
+				) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+			},
+			base.PostgresDialect: {`
+				CREATE TABLE IF NOT EXISTS polls (
+					id varchar(16) NOT NULL,
+					conv_id varchar(100) NOT NULL,
+					msg_id integer NOT NULL,
+					result_msg_id integer NOT NULL,
+					choices integer NOT NULL,
+					PRIMARY KEY (id)
+				)`, `
+				CREATE TABLE IF NOT EXISTS votes (
+					id varchar(16) NOT NULL,
+					username varchar(50) NOT NULL,
+					choice integer NOT NULL,
+					PRIMARY KEY (id, username)
+				)`,
+			},
+			base.SQLiteDialect: {`
+				CREATE TABLE IF NOT EXISTS polls (
+					id text NOT NULL PRIMARY KEY,
+					conv_id text NOT NULL,
+					msg_id integer NOT NULL,
+					result_msg_id integer NOT NULL,
+					choices integer NOT NULL
+				)`, `
+				CREATE TABLE IF NOT EXISTS votes (
+					id text NOT NULL,
+					username text NOT NULL,
+					choice integer NOT NULL,
+					PRIMARY KEY (id, username)
+				)`,
+			},
+		},
+	},
+}
+
 func (d *DB) CreatePoll(id string, convID chat1.ConvIDStr, msgID chat1.MessageID, resultMsgID chat1.MessageID, numChoices int) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`
+		_, err := tx.Exec(d.Rebind(`
 			INSERT INTO polls
 			(id, conv_id, msg_id, result_msg_id, choices)
 			VALUES
 			(?, ?, ?, ?, ?)
-		`, id, convID, msgID, resultMsgID, numChoices)
+		`), id, convID, msgID, resultMsgID, numChoices)
 		return err
 	})
 }
@@ -71,12 +126,11 @@ func (d *DB) GetTally(id string) (res Tally, err error) {
 
 func (d *DB) CastVote(username string, vote Vote) error {
 	return d.RunTxn(func(tx *sql.Tx) error {
-		_, err := tx.Exec(`
-			REPLACE INTO votes
-			(id, username, choice)
-			VALUES
-			(?, ?, ?)
-		`, vote.ID, username, vote.Choice)
+		_, err := tx.Exec(d.Rebind(d.Dialect.Upsert("votes",
+			[]string{"id", "username", "choice"},
+			[]string{"id", "username"},
+			[]string{"choice"})),
+			vote.ID, username, vote.Choice)
 		return err
 	})
 }
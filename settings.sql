@@ -0,0 +1,7 @@
+CREATE TABLE `conv_settings` (
+  `conv_id` char(64) NOT NULL,
+  `name` varchar(128) NOT NULL,
+  `value` text NOT NULL,
+  `mtime` datetime(6) NOT NULL,
+  PRIMARY KEY (`conv_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -95,6 +95,7 @@ type session struct {
 
 	kbc            *kbchat.API
 	db             *DB
+	httpClient     *http.Client
 	convID         chat1.ConvIDStr
 	numUsersInConv int
 	curQuestion    *question
@@ -108,6 +109,7 @@ func newSession(kbc *kbchat.API, debugConfig *base.ChatDebugOutputConfig, db *DB
 	return &session{
 		DebugOutput: base.NewDebugOutput("session", debugConfig),
 		db:          db,
+		httpClient:  base.NewHTTPClient(),
 		convID:      convID,
 		answerCh:    make(chan answer, 10),
 		kbc:         kbc,
@@ -121,7 +123,7 @@ func (s *session) getCategory() int {
 }
 
 func (s *session) getAPIToken() (string, error) {
-	resp, err := http.Get("https://opentdb.com/api_token.php?command=request")
+	resp, err := s.httpClient.Get("https://opentdb.com/api_token.php?command=request")
 	if err != nil {
 		return "", err
 	}
@@ -173,7 +175,7 @@ func (s *session) getNextQuestion() error {
 		url := fmt.Sprintf("https://opentdb.com/api.php?amount=1&category=%d&token=%s&type=multiple",
 			s.getCategory(), token)
 		s.Debug("getNextQuestion: url: %s", url)
-		resp, err := http.Get(url)
+		resp, err := s.httpClient.Get(url)
 		if err != nil {
 			return err
 		}
@@ -1,21 +1,21 @@
 package main
 
 import (
-	"database/sql"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/keybase/go-keybase-chat-bot/kbchat"
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/webhookbot/webhookbot"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
 	*base.Options
 	HTTPPrefix string
+	AllowedIPs string
 }
 
 func NewOptions() *Options {
@@ -93,15 +93,18 @@ Remove a webhook`,
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return err
 	}
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	s.Configure(s.opts.Options)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := webhookbot.NewDB(sdb)
 
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
@@ -111,14 +114,31 @@ func (s *BotServer) Go() (err error) {
 		return err
 	}
 	stats = stats.SetPrefix(s.Name())
-	httpSrv := webhookbot.NewHTTPSrv(stats, debugConfig, db)
+	var verifiers []base.WebhookVerifier
+	if s.opts.AllowedIPs != "" {
+		verifier, err := base.IPAllowListVerifier(strings.Split(s.opts.AllowedIPs, ","), false)
+		if err != nil {
+			return fmt.Errorf("invalid allowed IPs: %v", err)
+		}
+		verifiers = append(verifiers, verifier)
+	}
+	attachments, err := base.NewAttachmentStore(stats, debugConfig, 0)
+	if err != nil {
+		return err
+	}
+	httpSrv := webhookbot.NewHTTPSrv(stats, debugConfig, db, attachments, verifiers...)
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
 	handler := webhookbot.NewHandler(stats, s.kbc, debugConfig, httpSrv, db, s.opts.HTTPPrefix)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.AddShutdowner(attachments, stats)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -135,6 +155,8 @@ func mainInner() int {
 	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	fs.StringVar(&opts.HTTPPrefix, "http-prefix", os.Getenv("BOT_HTTP_PREFIX"),
 		"Desired prefix for generated webhooks")
+	fs.StringVar(&opts.AllowedIPs, "allowed-ips", os.Getenv("BOT_WEBHOOK_ALLOWED_IPS"),
+		"Comma separated IPs or CIDR ranges allowed to call webhooks, optional")
 	if err := opts.Parse(fs, os.Args); err != nil {
 		fmt.Printf("Unable to parse options: %v\n", err)
 		return 3
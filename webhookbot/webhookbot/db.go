@@ -14,9 +14,9 @@ type DB struct {
 	*base.DB
 }
 
-func NewDB(db *sql.DB) *DB {
+func NewDB(db *base.DB) *DB {
 	return &DB{
-		DB: base.NewDB(db),
+		DB: db,
 	}
 }
 
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,20 +14,32 @@ import (
 	"github.com/keybase/managed-bots/base"
 )
 
+// hooks posting to the same conversation share a rate limit so a looping
+// script can't flood it
+const (
+	hookRateLimit = 60
+	hookRateBurst = 20
+)
+
 type HTTPSrv struct {
 	*base.HTTPSrv
 
-	db *DB
+	db          *DB
+	limiter     *base.RateLimiter
+	attachments *base.AttachmentStore
 }
 
-func NewHTTPSrv(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB) *HTTPSrv {
+func NewHTTPSrv(stats *base.StatsRegistry, debugConfig *base.ChatDebugOutputConfig, db *DB,
+	attachments *base.AttachmentStore, verifiers ...base.WebhookVerifier) *HTTPSrv {
 	h := &HTTPSrv{
-		db: db,
+		db:          db,
+		limiter:     base.NewRateLimiter(hookRateLimit, hookRateBurst),
+		attachments: attachments,
 	}
 	h.HTTPSrv = base.NewHTTPSrv(stats, debugConfig)
 	rtr := mux.NewRouter()
 	rtr.HandleFunc("/webhookbot", h.handleHealthCheck)
-	rtr.HandleFunc("/webhookbot/{id:[A-Za-z0-9_-]+}", h.handleHook)
+	rtr.Handle("/webhookbot/{id:[A-Za-z0-9_-]+}", h.Verify(http.HandlerFunc(h.handleHook), verifiers...))
 	http.Handle("/", rtr)
 	return h
 }
@@ -78,6 +90,13 @@ func (h *HTTPSrv) handleHook(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if allowed, _ := h.limiter.Allow(string(hook.convID)); !allowed {
+		h.Stats.Count("handle - rate limited")
+		retryAfter := h.limiter.RetryAfter(string(hook.convID))
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "rate limit exceeded, slow down", http.StatusTooManyRequests)
+		return
+	}
 	h.Stats.Count("handle - success")
 	if _, err := h.Config().KBC.SendMessageByConvID(hook.convID, "[hook: *%s*]\n\n%s", hook.name, msg); err != nil {
 		if err := base.GetNonFatalChatError(err); err != nil {
@@ -88,24 +107,10 @@ func (h *HTTPSrv) handleHook(w http.ResponseWriter, r *http.Request) {
 		// error created in https://github.com/keybase/client/blob/7d6aa64f3fba66adba7a5dd1cc7c523d5086a548/go/chat/msgchecker/plaintext_checker.go#L50
 		if strings.Contains(err.Error(), "exceeds the maximum length") {
 			fileName := fmt.Sprintf("webhookbot-%s-%d.txt", hook.name, time.Now().Unix())
-			filePath := fmt.Sprintf("/tmp/%s", fileName)
-			if err := ioutil.WriteFile(filePath, []byte(msg), 0644); err != nil {
-				h.Errorf("failed to write %s: %s", filePath, err)
-				return
-			}
+			title := fmt.Sprintf("[hook: *%s*]", hook.name)
 			base.GoWithRecover(h.DebugOutput, func() {
-				defer func() {
-					// Cleanup after the file is sent.
-					time.Sleep(time.Minute)
-					h.Debug("cleaning up %s", filePath)
-					if err = os.Remove(filePath); err != nil {
-						h.Errorf("unable to clean up %s: %v", filePath, err)
-					}
-				}()
-				title := fmt.Sprintf("[hook: *%s*]", hook.name)
-				if _, err := h.Config().KBC.SendAttachmentByConvID(hook.convID, filePath, title); err != nil {
-					h.Errorf("failed to send attachment %s: %s", filePath, err)
-					return
+				if err := h.attachments.Upload(hook.convID, fileName, title, []byte(msg)); err != nil {
+					h.Errorf("failed to send attachment %s: %s", fileName, err)
 				}
 			})
 			return
@@ -4,7 +4,10 @@ CREATE TABLE `oauth_state` (
   `conv_id` char(64) NOT NULL,
   `msg_id` char(64) NOT NULL,
   `is_complete` boolean NOT NULL DEFAULT 0,
-  PRIMARY KEY (`state`)
+  `code_verifier` varchar(128) NOT NULL DEFAULT '',
+  `ctime` datetime NOT NULL,
+  PRIMARY KEY (`state`),
+  KEY `ctime` (`ctime`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 CREATE TABLE `oauth` (
@@ -1,13 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
 
 	"golang.org/x/oauth2"
 
@@ -16,7 +13,6 @@ import (
 	"github.com/keybase/go-keybase-chat-bot/kbchat/types/chat1"
 	"github.com/keybase/managed-bots/base"
 	"github.com/keybase/managed-bots/zoombot/zoombot"
-	"golang.org/x/sync/errgroup"
 )
 
 type Options struct {
@@ -78,20 +74,17 @@ func (s *BotServer) getCredentials() (credentials *zoombot.Credentials, err erro
 			VerificationToken: s.opts.VerificationToken,
 		}
 	} else {
-		if len(s.opts.KBFSRoot) == 0 {
-			return nil, fmt.Errorf("BOT_KBFS_ROOT must be specified\n")
+		secrets, err := s.opts.SecretsProvider(s.opts.KBFSRoot)
+		if err != nil {
+			return nil, err
 		}
-		configPath := filepath.Join(s.opts.KBFSRoot, "credentials.json")
-		cmd := s.opts.Command("fs", "read", configPath)
-		var out bytes.Buffer
-		cmd.Stdout = &out
-		s.Debug("Running `keybase fs read` on %q and waiting for it to finish...\n", configPath)
-		if err := cmd.Run(); err != nil {
+		out, err := secrets.GetSecret("credentials.json")
+		if err != nil {
 			return nil, err
 		}
 
 		credentials = &zoombot.Credentials{}
-		if err := json.Unmarshal(out.Bytes(), credentials); err != nil {
+		if err := json.Unmarshal(out, credentials); err != nil {
 			return nil, err
 		}
 	}
@@ -105,9 +98,11 @@ func (s *BotServer) getCredentials() (credentials *zoombot.Credentials, err erro
 }
 
 func (s *BotServer) Go() (err error) {
+	s.SetDryRun(s.opts.DryRun)
 	if s.kbc, err = s.Start(s.opts.ErrReportConv); err != nil {
 		return fmt.Errorf("failed to start keybase %v", err)
 	}
+	s.Configure(s.opts.Options)
 
 	credentials, err := s.getCredentials()
 	if err != nil {
@@ -125,12 +120,13 @@ func (s *BotServer) Go() (err error) {
 		Scopes:      []string{"user:read", "meeting:write"},
 	}
 
-	sdb, err := sql.Open("mysql", s.opts.DSN)
+	sdb, err := base.OpenMySQLDB(s.opts.DSN)
 	if err != nil {
 		s.Errorf("failed to connect to MySQL: %s", err)
 		return err
 	}
 	defer sdb.Close()
+	base.ConfigureDBPool(sdb.DB, s.opts.DBPoolOptions())
 	db := zoombot.NewDB(sdb)
 
 	debugConfig := base.NewChatDebugOutputConfig(s.kbc, s.opts.ErrReportConv)
@@ -142,12 +138,17 @@ func (s *BotServer) Go() (err error) {
 	stats = stats.SetPrefix(s.Name())
 	handler := zoombot.NewHandler(stats, s.kbc, debugConfig, db, config)
 	httpSrv := zoombot.NewHTTPSrv(stats, s.kbc, debugConfig, db, handler, config, credentials)
-	eg := &errgroup.Group{}
-	s.GoWithRecover(eg, func() error { return s.Listen(handler) })
-	s.GoWithRecover(eg, httpSrv.Listen)
-	s.GoWithRecover(eg, func() error { return s.HandleSignals(httpSrv, stats) })
-	s.GoWithRecover(eg, func() error { return s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.") })
-	if err := eg.Wait(); err != nil {
+	httpSrv.AddReadinessCheck("db", sdb.Ping)
+	lc := base.NewLifecycle(debugConfig, base.DefaultShutdownDeadline)
+	lc.Go(func() error { return s.Listen(handler) }, s)
+	lc.Go(httpSrv.Listen, httpSrv)
+	lc.Go(func() error {
+		// failing to announce is already logged and shouldn't shut the bot down
+		_ = s.AnnounceAndAdvertise(s.makeAdvertisement(), "I live.")
+		return nil
+	}, nil)
+	lc.AddShutdowner(stats)
+	if err := lc.Run(); err != nil {
 		s.Debug("wait error: %s", err)
 		return err
 	}
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"time"
+
+	"github.com/keybase/managed-bots/base"
 )
 
 const (
@@ -236,7 +238,7 @@ func DataCompliance(clientID, clientSecret string, request *DataComplianceReques
 		return nil, err
 	}
 
-	client := &http.Client{}
+	client := base.NewHTTPClient()
 	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payload))
 	if err != nil {
 		return nil, err
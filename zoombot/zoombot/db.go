@@ -10,9 +10,9 @@ type DB struct {
 	*base.OAuthDB
 }
 
-func NewDB(db *sql.DB) *DB {
+func NewDB(db *base.DB) *DB {
 	return &DB{
-		OAuthDB: base.NewOAuthDB(db),
+		OAuthDB: base.NewOAuthDBWithDB(db),
 	}
 }
 
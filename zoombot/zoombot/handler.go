@@ -1,7 +1,6 @@
 package zoombot
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -45,7 +44,7 @@ func (h *Handler) HandleAuth(msg chat1.MsgSummary, identifier string) error {
 	if err != nil {
 		return fmt.Errorf("error getting token: %s", err)
 	}
-	client := h.config.Client(context.Background(), token)
+	client := base.NewPersistingClient(h.config, token, base.StorageTokenSaver(h.db, identifier))
 
 	user, err := GetUser(client, currentUserID)
 	if err != nil {